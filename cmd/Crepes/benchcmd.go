@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/fixtures"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+)
+
+// -- ENGINE BENCHMARKS --
+//
+// crepes bench RUNS A SYNTHETIC PIPELINE AGAINST A GENERATED FIXTURE SITE ON LOOPBACK AND REPORTS
+// THROUGHPUT, ITEM LATENCY AND MEMORY, SO WORK ON THE WORKER POOLS, THE CRAWL FRONTIER OR THE
+// DOWNLOAD SCHEDULER CAN BE MEASURED BEFORE AND AFTER. THE PIPELINE CRAWLS EVERY PAGE OF THE SITE
+// (archiveSite WITHOUT BROWSER CAPTURES), THEN DOWNLOADS EVERY ASSET IN A for-each STAGE.
+
+// ITEM RESULTS ARE LABELLED WITH THE STAGE'S NAME
+const benchDownloadStage = "Download assets"
+
+// ONE BENCHMARK RUN'S MEASUREMENTS
+type benchResult struct {
+	Run         int                     `json:"run"`
+	Status      string                  `json:"status"`
+	Error       string                  `json:"error,omitempty"`
+	Duration    time.Duration           `json:"durationNs"`
+	Served      fixtures.SyntheticStats `json:"served"`
+	PagesPerSec float64                 `json:"pagesPerSec"`
+	ItemsPerSec float64                 `json:"itemsPerSec"`
+	MBPerSec    float64                 `json:"mbPerSec"`
+	Items       int                     `json:"items"`
+	ItemsFailed int                     `json:"itemsFailed"`
+	LatencyP50  time.Duration           `json:"latencyP50Ns"`
+	LatencyP90  time.Duration           `json:"latencyP90Ns"`
+	LatencyP99  time.Duration           `json:"latencyP99Ns"`
+	LatencyMax  time.Duration           `json:"latencyMaxNs"`
+	Memory      benchMemory             `json:"memory"`
+}
+
+// GO HEAP AND RUNTIME FIGURES OVER A RUN (BROWSERS ARE SEPARATE PROCESSES AND NOT INCLUDED)
+type benchMemory struct {
+	PeakHeap       uint64 `json:"peakHeap"`       // MOST HEAP IN USE AT ANY SAMPLE
+	PeakSys        uint64 `json:"peakSys"`        // MOST MEMORY OBTAINED FROM THE OS
+	Allocated      uint64 `json:"allocated"`      // BYTES ALLOCATED DURING THE RUN
+	GCs            uint32 `json:"gcs"`            // GARBAGE COLLECTIONS DURING THE RUN
+	PeakGoroutines int    `json:"peakGoroutines"` // MOST GOROUTINES AT ANY SAMPLE
+}
+
+// RUN crepes bench AND RETURN THE EXIT CODE
+func benchCommand(args []string) int {
+	flags := flag.NewFlagSet("crepes bench", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to configuration file (network and download settings)")
+	pages := flags.Int("pages", 100, "Pages in the synthetic site")
+	assets := flags.Int("assets", 10, "Assets linked from each page")
+	assetSize := flags.Int("asset-size", 64<<10, "Bytes per asset")
+	latency := flags.Duration("latency", 0, "Delay the fixture server adds to every response")
+	crawlers := flags.Int("crawlers", 4, "Pages crawled at once")
+	workers := flags.Int("workers", 8, "Assets downloaded at once (for-each maxWorkers)")
+	connsPerHost := flags.Int("conns-per-host", 0, "Override the download scheduler's connections per host (0 keeps the config's)")
+	runs := flags.Int("runs", 3, "Times to run the pipeline")
+	timeout := flags.Duration("timeout", 10*time.Minute, "Time limit for each run")
+	asJSON := flags.Bool("json", false, "Print the results as JSON")
+	verbose := flags.Bool("v", false, "Show engine logs")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: crepes bench [-pages n] [-assets m] [-asset-size bytes] [-latency d] [-crawlers n] [-workers n] [-runs n] [-json] [-v]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *pages <= 0 || *assets < 0 || *assetSize < 0 || *crawlers <= 0 || *workers <= 0 || *runs <= 0 {
+		flags.Usage()
+		return 2
+	}
+
+	instance, err := newScratchInstance(*configPath, *verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer instance.Close()
+	if *connsPerHost > 0 {
+		instance.cfg.MaxConnsPerHost = *connsPerHost
+	}
+	instance.cfg.DefaultTimeout = int(timeout.Milliseconds())
+
+	if !*asJSON {
+		fmt.Printf("crepes %s bench: %d pages x %d assets of %d bytes, %d crawlers, %d workers, %v latency, %s/%s, %d CPUs\n",
+			VERSION, *pages, *assets, *assetSize, *crawlers, *workers, *latency, runtime.GOOS, runtime.GOARCH, runtime.NumCPU())
+	}
+
+	var results []benchResult
+	failed := false
+	for run := 1; run <= *runs; run++ {
+		// A FRESH SITE PER RUN SO ITS COUNTERS ARE THIS RUN'S
+		site := fixtures.NewSyntheticSite(fixtures.SyntheticOptions{Pages: *pages, Assets: *assets, AssetSize: *assetSize, Latency: *latency})
+		server := httptest.NewServer(site)
+		result := benchRun(instance, run, site, server.URL, *pages, *crawlers, *workers, *timeout)
+		server.Close()
+		if result.Error != "" || result.Status != "completed" {
+			failed = true
+		}
+		results = append(results, result)
+		if !*asJSON {
+			printBenchResult(result)
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]any{
+			"version":   VERSION,
+			"pages":     *pages,
+			"assets":    *assets,
+			"assetSize": *assetSize,
+			"latencyNs": *latency,
+			"crawlers":  *crawlers,
+			"workers":   *workers,
+			"cpus":      runtime.NumCPU(),
+			"runs":      results,
+		})
+	} else if len(results) > 1 {
+		printBenchSummary(results)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// THE SYNTHETIC PIPELINE: CRAWL THE SITE, LIST ITS ASSETS, DOWNLOAD THEM
+func benchPipeline(site *fixtures.SyntheticSite, base string, pages, crawlers, workers int) (string, error) {
+	always := models.Condition{Type: "always"}
+	stages := []models.Stage{
+		{
+			ID:          "stage_crawl",
+			Name:        "Crawl",
+			Condition:   always,
+			Parallelism: models.ParallelismConfig{Mode: "sequential", MaxWorkers: 1},
+			Tasks: []models.Task{{
+				ID:   "crawl",
+				Name: "Crawl site",
+				Type: "archiveSite",
+				Config: map[string]any{
+					"url":            base + "/",
+					"maxPages":       pages,
+					"maxDepth":       pages, // THE TREE IS ONLY log2(pages) DEEP
+					"includeSitemap": false,
+					"warc":           false,
+					"screenshot":     false,
+					"pdf":            false,
+					"delay":          0,
+					"concurrency":    crawlers,
+				},
+				InputRefs: []string{},
+				Condition: always,
+			}},
+		},
+		{
+			ID:          "stage_list",
+			Name:        "List assets",
+			Condition:   always,
+			Parallelism: models.ParallelismConfig{Mode: "sequential", MaxWorkers: 1},
+			Tasks: []models.Task{{
+				ID:        "assetUrls",
+				Name:      "Asset URLs",
+				Type:      "sliceItems",
+				Config:    map[string]any{"items": site.AssetURLs(base)},
+				InputRefs: []string{},
+				Condition: always,
+			}},
+		},
+		{
+			ID:          "stage_download",
+			Name:        benchDownloadStage,
+			Condition:   always,
+			Parallelism: models.ParallelismConfig{Mode: "for-each", MaxWorkers: workers},
+			Tasks: []models.Task{{
+				ID:        "download",
+				Name:      "Download asset",
+				Type:      "downloadAsset",
+				Config:    map[string]any{"folder": "bench"},
+				InputRefs: []string{"assetUrls"},
+				Condition: always,
+			}},
+		},
+	}
+	// THROUGH JSON SO NUMBERS ARE WHAT THE PIPELINE LOADER PRODUCES
+	encoded, err := json.Marshal(stages)
+	return string(encoded), err
+}
+
+// RUN THE PIPELINE ONCE AS A NEW JOB, SAMPLING MEMORY WHILE IT RUNS
+func benchRun(instance *scratchInstance, run int, site *fixtures.SyntheticSite, base string, pages, crawlers, workers int, timeout time.Duration) benchResult {
+	result := benchResult{Run: run}
+	pipeline, err := benchPipeline(site, base, pages, crawlers, workers)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if errs := instance.engine.ValidatePipeline(pipeline); errs != nil {
+		result.Error = fmt.Sprintf("invalid pipeline: %v", errs)
+		return result
+	}
+	job := models.Job{
+		ID:       fmt.Sprintf("bench_%d_%d", time.Now().Unix(), run),
+		Name:     fmt.Sprintf("Benchmark run %d", run),
+		BaseURL:  base,
+		Status:   "idle",
+		Pipeline: pipeline,
+	}
+	if err := instance.db.Create(&job).Error; err != nil {
+		result.Error = fmt.Sprintf("failed to create job: %v", err)
+		return result
+	}
+
+	// LEAVE THE LAST RUN'S GARBAGE OUT OF THIS ONE'S FIGURES
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	stop := make(chan struct{})
+	var sampler sync.WaitGroup
+	sampler.Add(1)
+	go func() {
+		defer sampler.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			result.Memory.PeakHeap = max(result.Memory.PeakHeap, stats.HeapInuse)
+			result.Memory.PeakSys = max(result.Memory.PeakSys, stats.Sys)
+			result.Memory.PeakGoroutines = max(result.Memory.PeakGoroutines, runtime.NumGoroutine())
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	started := time.Now()
+	runErr := instance.runJob(job.ID, scraper.RunOptions{}, timeout)
+	result.Duration = time.Since(started)
+	close(stop)
+	sampler.Wait()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	result.Memory.Allocated = after.TotalAlloc - before.TotalAlloc
+	result.Memory.GCs = after.NumGC - before.NumGC
+
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	var finished models.Job
+	if err := instance.db.First(&finished, "id = ?", job.ID).Error; err == nil {
+		result.Status = finished.Status
+	}
+	if result.Error == "" && result.Status != "completed" {
+		if progress, err := instance.engine.GetJobProgress(job.ID); err == nil && len(progress.Errors) > 0 {
+			result.Error = progress.Errors[0]
+		}
+	}
+
+	result.Served = site.Stats()
+	seconds := result.Duration.Seconds()
+	if seconds > 0 {
+		result.PagesPerSec = float64(result.Served.Pages) / seconds
+		result.ItemsPerSec = float64(result.Served.Assets) / seconds
+		result.MBPerSec = float64(result.Served.Bytes) / seconds / (1 << 20)
+	}
+
+	// PER-ASSET LATENCY FROM THE for-each STAGE'S ITEM RESULTS
+	var items []models.ItemResult
+	instance.db.Select("status", "duration_ms").Where("job_id = ? AND stage = ?", job.ID, benchDownloadStage).Find(&items)
+	durations := make([]time.Duration, 0, len(items))
+	for _, item := range items {
+		if item.Status != scraper.ItemSucceeded {
+			result.ItemsFailed++
+			continue
+		}
+		durations = append(durations, time.Duration(item.DurationMs)*time.Millisecond)
+	}
+	result.Items = len(items)
+	slices.Sort(durations)
+	result.LatencyP50 = percentile(durations, 50)
+	result.LatencyP90 = percentile(durations, 90)
+	result.LatencyP99 = percentile(durations, 99)
+	result.LatencyMax = percentile(durations, 100)
+	return result
+}
+
+// NEAREST-RANK PERCENTILE OF SORTED VALUES (0 WHEN THERE ARE NONE)
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+func printBenchResult(r benchResult) {
+	fmt.Printf("run %d: %s in %v\n", r.Run, r.Status, r.Duration.Round(time.Millisecond))
+	if r.Error != "" {
+		fmt.Printf("  error:      %s\n", r.Error)
+	}
+	fmt.Printf("  served:     %d pages, %d assets, %s (%d not found)\n", r.Served.Pages, r.Served.Assets, formatBytes(r.Served.Bytes), r.Served.NotFound)
+	fmt.Printf("  throughput: %.1f pages/s, %.1f assets/s, %.2f MB/s\n", r.PagesPerSec, r.ItemsPerSec, r.MBPerSec)
+	fmt.Printf("  latency:    p50 %v, p90 %v, p99 %v, max %v (%d items, %d failed)\n", r.LatencyP50, r.LatencyP90, r.LatencyP99, r.LatencyMax, r.Items, r.ItemsFailed)
+	fmt.Printf("  memory:     peak heap %s, peak sys %s, %s allocated, %d GCs, %d goroutines\n",
+		formatBytes(int64(r.Memory.PeakHeap)), formatBytes(int64(r.Memory.PeakSys)), formatBytes(int64(r.Memory.Allocated)), r.Memory.GCs, r.Memory.PeakGoroutines)
+}
+
+// MEDIANS ACROSS RUNS, WHICH A SINGLE SLOW RUN DOESN'T SKEW
+func printBenchSummary(results []benchResult) {
+	median := func(value func(benchResult) float64) float64 {
+		values := make([]float64, len(results))
+		for i, r := range results {
+			values[i] = value(r)
+		}
+		slices.Sort(values)
+		return values[len(values)/2]
+	}
+	fmt.Printf("median of %d runs: %v, %.1f pages/s, %.1f assets/s, %.2f MB/s, p50 %v, p99 %v, peak heap %s\n",
+		len(results),
+		time.Duration(median(func(r benchResult) float64 { return float64(r.Duration) })).Round(time.Millisecond),
+		median(func(r benchResult) float64 { return r.PagesPerSec }),
+		median(func(r benchResult) float64 { return r.ItemsPerSec }),
+		median(func(r benchResult) float64 { return r.MBPerSec }),
+		time.Duration(median(func(r benchResult) float64 { return float64(r.LatencyP50) })),
+		time.Duration(median(func(r benchResult) float64 { return float64(r.LatencyP99) })),
+		formatBytes(int64(median(func(r benchResult) float64 { return float64(r.Memory.PeakHeap) }))),
+	)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
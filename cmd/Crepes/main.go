@@ -3,62 +3,155 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
 	"path/filepath"
-	"syscall"
+	"strings"
 	"time"
 
 	"github.com/nickheyer/Crepes/internal/api"
+	"github.com/nickheyer/Crepes/internal/auth"
 	"github.com/nickheyer/Crepes/internal/config"
 	"github.com/nickheyer/Crepes/internal/database"
+	"github.com/nickheyer/Crepes/internal/middleware"
 	"github.com/nickheyer/Crepes/internal/models"
 	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/storage"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"golang.org/x/crypto/acme/autocert"
+	"gorm.io/gorm"
 )
 
 const VERSION = "v0.1.0"
 
 func main() {
-	configPath := flag.String("config", "config.json", "Path to configuration file")
-	port := flag.String("port", "", "HTTP port to listen on (overrides config)")
-	flag.Parse()
+	// THE FIRST ARGUMENT MAY NAME A COMMAND; WITHOUT ONE THE SERVER RUNS
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	// test AND bench HAVE FLAGS OF THEIR OWN
+	switch command {
+	case "test":
+		os.Exit(testCommand(args))
+	case "bench":
+		os.Exit(benchCommand(args))
+	}
+	flags := flag.NewFlagSet("crepes "+command, flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to configuration file")
+	port := flags.String("port", "", "HTTP port to listen on (overrides config)")
+	dir := flags.String("dir", "", "Directory to run in (set by service install)")
+	flags.Parse(args)
+
+	switch command {
+	case "run":
+		if *dir != "" {
+			if err := os.Chdir(*dir); err != nil {
+				log.Fatalf("Failed to change to %s: %v", *dir, err)
+			}
+		}
+		runCommand(*configPath, *port)
+	case "install", "uninstall", "start", "stop", "restart", "status":
+		controlService(command, *configPath, *port)
+	default:
+		fmt.Fprintf(os.Stderr, "usage: crepes [run|install|uninstall|start|stop|restart|status|test|bench] [-config path] [-port port]\n")
+		os.Exit(2)
+	}
+}
 
-	cfg, err := config.LoadConfig(*configPath)
+// RUN THE SERVER UNTIL stop IS CLOSED OR IT FAILS
+func run(configPath, port string, stop <-chan struct{}) error {
+	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Printf("WARNING: Failed to load config file: %v, using default settings", err)
 		cfg = config.GetDefaultConfig()
 	}
 
-	if *port != "" {
-		cfg.Port = *port
+	if port != "" {
+		cfg.Port = port
+	}
+
+	// KEEP THE ENCRYPTION SECRET OUT OF config.json IF PREFERRED
+	if secret := os.Getenv("CREPES_ENCRYPTION_SECRET"); secret != "" {
+		cfg.EncryptionSecret = secret
+	}
+
+	// A STORAGE BACKEND THE CONFIG GETS WRONG WOULD QUIETLY KEEP EVERYTHING ON DISK
+	stores, err := storage.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid storage config: %v", err)
 	}
 
 	createDirs(cfg)
+	appLog := openAppLog(cfg)
+	if appLog != nil {
+		defer appLog.Close()
+	}
+	if stores.Remote() {
+		log.Printf("Uploading finished assets to %s bucket %s (served by %s)", stores.Backend, cfg.Storage.S3.Bucket, stores.Serve)
+	}
+
+	// ONE INSTANCE PER DATA DIRECTORY; A PID LEFT BEHIND MEANS THE LAST RUN CRASHED
+	pidFile, err := utils.AcquirePIDFile(filepath.Join(cfg.DataPath, "crepes.pid"))
+	if err != nil {
+		return fmt.Errorf("failed to lock the data directory: %v", err)
+	}
+	defer pidFile.Release()
+	if pidFile.PreviousPID != 0 {
+		log.Printf("WARNING: The previous run (PID %d) did not shut down cleanly, recovering its jobs", pidFile.PreviousPID)
+	}
+
+	scraper.DetectMediaTools(cfg)
 
 	db, err := database.SetupDatabase(cfg.DataPath)
 	if err != nil {
-		log.Fatalf("Failed to setup database: %v", err)
+		return fmt.Errorf("failed to setup database: %v", err)
 	}
 
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatalf("failed to get db from GORM: %v", err)
+		return fmt.Errorf("failed to get db from GORM: %v", err)
 	}
 	defer sqlDB.Close()
 
-	if err := db.AutoMigrate(&models.Job{}, &models.Asset{}, &models.Setting{}); err != nil {
-		log.Fatalf("Failed to migrate database schemas: %v", err)
+	if err := migrate(db); err != nil {
+		return err
+	}
+
+	// JOBS AND ASSETS FROM BEFORE THE DATABASE (jobs.json) MOVE INTO IT ON THE FIRST START
+	// A FAILED IMPORT LEAVES THE FILES IN PLACE FOR THE NEXT START; IT NEVER KEEPS THE SERVER DOWN
+	imported, err := database.ImportLegacyData(db, cfg.DataPath, cfg.StoragePath, cfg.ThumbnailsPath)
+	if err != nil {
+		log.Printf("Failed to import legacy data: %v", err)
+	} else if len(imported.Files) > 0 {
+		log.Printf("Imported %d jobs and %d assets from %s (%d already present)", imported.Jobs, imported.Assets, strings.Join(imported.Files, ", "), imported.Skipped)
+	}
+	if imported.Relocated > 0 {
+		log.Printf("Made %d asset paths relative to storage", imported.Relocated)
 	}
 
 	database.EnsureDefaultSettings(db)
 
+	// ACCOUNTS: THE FIRST ADMIN WHEN AUTH IS NEW, AND NO EXPIRED SESSIONS LEFT BEHIND
+	if err := auth.Bootstrap(db, cfg.Auth); err != nil {
+		return err
+	}
+	if pruned, err := auth.PruneSessions(db); err == nil && pruned > 0 {
+		log.Printf("Removed %d expired sessions", pruned)
+	}
+
 	scraperEngine := scraper.NewEngine(db, cfg)
+	scraperEngine.SetAppLog(appLog)
 
 	jobScheduler := scraper.NewScheduler(db, scraperEngine)
 	jobScheduler.Start()
-	defer jobScheduler.Stop()
+
+	// RUNS THE LAST PROCESS DIDN'T FINISH ARE MARKED, AND RESUMED WHERE CONFIGURED
+	if resumed := scraperEngine.RecoverInterruptedJobs(); resumed > 0 {
+		log.Printf("Resumed %d interrupted jobs", resumed)
+	}
 
 	routerConfig := api.RouterConfig{
 		DB:            db,
@@ -68,35 +161,95 @@ func main() {
 	}
 	router := api.SetupRouter(routerConfig)
 
+	// PROXY HEADERS ARE APPLIED BEFORE THE BASE PATH IS STRIPPED
+	handler := middleware.ProxyHeaders(cfg.TrustedProxies)(middleware.BasePath(cfg.BasePath)(router))
+
 	addr := ":" + cfg.Port
 	srv := &http.Server{
-		Handler:      router,
+		Handler:      handler,
 		Addr:         addr,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	serverErr := make(chan error, 1)
 	go func() {
-		log.Printf("Crepes %s starting on http://localhost%s", VERSION, addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
-		}
+		serverErr <- serve(srv, cfg)
 	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Println("Shutting down server...")
+	var runErr error
+	select {
+	case <-stop:
+		log.Println("Shutting down server...")
+	case err := <-serverErr:
+		runErr = fmt.Errorf("server error: %v", err)
+		log.Printf("%v, shutting down", runErr)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// RUNNING JOBS ARE MARKED INTERRUPTED FOR THE NEXT START
+	jobScheduler.Stop()
+	scraperEngine.Close()
+
 	log.Println("Server exited properly")
+	return runErr
+}
+
+// BRING THE DATABASE SCHEMA UP TO DATE
+func migrate(db *gorm.DB) error {
+	if err := database.PrepareRecordKeys(db); err != nil {
+		return fmt.Errorf("failed to migrate records: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Job{}, &models.Asset{}, &models.AssetText{}, &models.Setting{}, &models.Secret{}, &models.IdempotencyRecord{}, &models.SavedFilter{}, &models.Template{}, &models.User{}, &models.AuthSession{}, &models.Folder{}, &models.ErrorLog{}, &models.Record{}, &models.RecordChange{}, &models.RecordAlert{}, &models.RecordRejection{}, &models.PageSnapshot{}, &models.PageChange{}, &models.JobState{}, &models.ItemResult{}, &models.RunEvent{}, &models.Session{}, &models.PartialDownload{}); err != nil {
+		return fmt.Errorf("failed to migrate database schemas: %v", err)
+	}
+	if err := database.UpgradeLegacyRows(db); err != nil {
+		return fmt.Errorf("failed to upgrade legacy rows: %v", err)
+	}
+	return nil
+}
+
+// SERVE PLAIN HTTP, HTTPS WITH A CERT/KEY PAIR, OR HTTPS WITH LET'S ENCRYPT CERTIFICATES
+func serve(srv *http.Server, cfg *config.Config) error {
+	basePath := "/" + strings.Trim(cfg.BasePath, "/")
+
+	switch {
+	case len(cfg.AutocertDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(filepath.Join(cfg.DataPath, "autocert")),
+			Email:      cfg.AutocertEmail,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+
+		// ACME HTTP-01 CHALLENGES, EVERYTHING ELSE REDIRECTS TO HTTPS
+		httpPort := cfg.AutocertHTTPPort
+		if httpPort == "" {
+			httpPort = "80"
+		}
+		go func() {
+			if err := http.ListenAndServe(":"+httpPort, manager.HTTPHandler(nil)); err != nil {
+				log.Printf("WARNING: ACME challenge listener failed: %v", err)
+			}
+		}()
+
+		log.Printf("Crepes %s starting on https://%s:%s%s (Let's Encrypt)", VERSION, cfg.AutocertDomains[0], cfg.Port, basePath)
+		return srv.ListenAndServeTLS("", "")
+	case cfg.TLSCertFile != "" && cfg.TLSKeyFile != "":
+		log.Printf("Crepes %s starting on https://localhost%s%s", VERSION, srv.Addr, basePath)
+		return srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	default:
+		log.Printf("Crepes %s starting on http://localhost%s%s", VERSION, srv.Addr, basePath)
+		return srv.ListenAndServe()
+	}
 }
 
 func createDirs(cfg *config.Config) {
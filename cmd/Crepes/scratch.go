@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/database"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"gorm.io/gorm"
+)
+
+// A THROWAWAY INSTANCE FOR crepes test AND crepes bench: ITS OWN DATABASE AND STORAGE IN A
+// TEMPORARY DIRECTORY, NOTHING SHARED WITH A REAL ONE
+type scratchInstance struct {
+	dir    string
+	cfg    *config.Config
+	db     *gorm.DB
+	engine *scraper.Engine
+	quiet  bool
+}
+
+// START A SCRATCH INSTANCE. THE CONFIG FILE ONLY SUPPLIES BROWSER, TOOL AND NETWORK SETTINGS;
+// ENGINE LOGS ARE DISCARDED UNLESS verbose
+func newScratchInstance(configPath string, verbose bool) (*scratchInstance, error) {
+	dir, err := os.MkdirTemp("", "crepes-scratch-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a work directory: %v", err)
+	}
+	s := &scratchInstance{dir: dir, quiet: !verbose}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		cfg = config.GetDefaultConfig()
+	}
+	cfg.DataPath = filepath.Join(dir, "data")
+	cfg.StoragePath = filepath.Join(dir, "storage")
+	cfg.ThumbnailsPath = filepath.Join(dir, "thumbnails")
+	cfg.Proxy = ""
+	// FIXTURES ARE SERVED IN-PROCESS OR ON LOOPBACK, SO THERE IS NO EGRESS TO GUARD
+	cfg.SSRF.Enabled = false
+	s.cfg = cfg
+
+	if s.quiet {
+		log.SetOutput(io.Discard)
+	}
+	for _, path := range []string{cfg.DataPath, cfg.StoragePath, cfg.ThumbnailsPath} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to create %s: %v", path, err)
+		}
+	}
+	scraper.DetectMediaTools(cfg)
+
+	if s.db, err = database.SetupDatabase(cfg.DataPath); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to setup database: %v", err)
+	}
+	if err := migrate(s.db); err != nil {
+		s.Close()
+		return nil, err
+	}
+	database.EnsureDefaultSettings(s.db)
+
+	s.engine = scraper.NewEngine(s.db, cfg)
+	return s, nil
+}
+
+// STOP THE ENGINE AND REMOVE EVERYTHING THE INSTANCE WROTE
+func (s *scratchInstance) Close() {
+	if s.engine != nil {
+		s.engine.Close()
+	}
+	if s.db != nil {
+		if sqlDB, err := s.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	if s.quiet {
+		log.SetOutput(os.Stderr)
+	}
+	os.RemoveAll(s.dir)
+}
+
+// START A JOB AND WAIT FOR IT TO FINISH, STOPPING IT IF IT RUNS LONGER THAN timeout
+func (s *scratchInstance) runJob(jobID string, opts scraper.RunOptions, timeout time.Duration) error {
+	if err := s.engine.RunJobWithOptions(jobID, opts); err != nil {
+		return fmt.Errorf("failed to start job: %v", err)
+	}
+	deadline := time.Now().Add(timeout)
+	for s.engine.IsJobRunning(jobID) {
+		if time.Now().After(deadline) {
+			s.engine.StopJob(jobID)
+			for s.engine.IsJobRunning(jobID) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return fmt.Errorf("timed out after %v", timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
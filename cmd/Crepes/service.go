@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// -- SERVICE --
+//
+// crepes install REGISTERS A systemd UNIT, LAUNCHD JOB OR WINDOWS SERVICE THAT RUNS
+// crepes run WITH THE SAME CONFIG AND DIRECTORY, AND IS RESTARTED IF IT CRASHES.
+
+const serviceName = "crepes"
+
+// HOW LONG A SERVICE STOP WAITS FOR JOBS AND THE SERVER TO WIND DOWN
+const serviceStopTimeout = 30 * time.Second
+
+// THE SERVER AS A SERVICE MANAGER SEES IT
+type program struct {
+	configPath string
+	port       string
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func newProgram(configPath, port string) *program {
+	return &program{configPath: configPath, port: port, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (p *program) Start(s service.Service) error {
+	go func() {
+		defer close(p.done)
+		if err := run(p.configPath, p.port, p.stop); err != nil {
+			log.Printf("Crepes stopped: %v", err)
+			os.Exit(1) // A FAILURE EXIT IS WHAT MAKES THE SERVICE MANAGER RESTART US
+		}
+	}()
+	return nil
+}
+
+func (p *program) Stop(s service.Service) error {
+	close(p.stop)
+	select {
+	case <-p.done:
+	case <-time.After(serviceStopTimeout):
+		log.Printf("WARNING: Shutdown did not finish within %v", serviceStopTimeout)
+	}
+	return nil
+}
+
+// SERVICE DEFINITION: ARGUMENTS ARE MADE ABSOLUTE SO THE SERVICE FINDS THE SAME FILES
+func newService(prg *program, configPath, port string) (service.Service, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	absConfig, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"run", "-config", absConfig, "-dir", dir}
+	if port != "" {
+		args = append(args, "-port", port)
+	}
+	return service.New(prg, &service.Config{
+		Name:             serviceName,
+		DisplayName:      "Crepes",
+		Description:      "Crepes web scraper",
+		Arguments:        args,
+		WorkingDirectory: dir,
+		Dependencies:     []string{"After=network-online.target", "Wants=network-online.target"},
+		Option: service.KeyValue{
+			"Restart":                "on-failure", // systemd
+			"OnFailure":              "restart",    // WINDOWS
+			"OnFailureDelayDuration": "5s",
+			"OnFailureResetPeriod":   300,
+			"KeepAlive":              true, // LAUNCHD
+			"RunAtLoad":              true,
+		},
+	})
+}
+
+// RUN UNDER THE SERVICE MANAGER WHEN STARTED BY ONE, OTHERWISE IN THE FOREGROUND UNTIL
+// INTERRUPTED
+func runCommand(configPath, port string) {
+	prg := newProgram(configPath, port)
+	if !service.Interactive() {
+		s, err := newService(prg, configPath, port)
+		if err == nil {
+			if err := s.Run(); err != nil {
+				log.Fatalf("Service failed: %v", err)
+			}
+			return
+		}
+		log.Printf("WARNING: No service manager found (%v), running in the foreground", err)
+	}
+	prg.Start(nil)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	prg.Stop(nil)
+}
+
+// install, uninstall, start, stop, restart OR status
+func controlService(command, configPath, port string) {
+	s, err := newService(newProgram(configPath, port), configPath, port)
+	if err != nil {
+		log.Fatalf("Service setup failed: %v", err)
+	}
+	if command == "status" {
+		status, err := s.Status()
+		if err != nil {
+			log.Fatalf("Failed to get service status: %v", err)
+		}
+		fmt.Println(map[service.Status]string{
+			service.StatusRunning: "running",
+			service.StatusStopped: "stopped",
+		}[status])
+		return
+	}
+	if err := service.Control(s, command); err != nil {
+		log.Fatalf("Failed to %s service: %v", command, err)
+	}
+	fmt.Printf("Service %s: done\n", command)
+}
+
+// THE LOG GOES TO crepes.log IN THE DATA DIRECTORY, AND TO THE CONSOLE TOO WHEN THERE IS ONE
+// (WINDOWS SERVICES HAVE NONE)
+func openAppLog(cfg *config.Config) *utils.RotatingLog {
+	opts := utils.LogOptions{
+		MaxSize:    int64(cfg.Log.MaxSize) << 20,
+		MaxAge:     time.Duration(cmp.Or(cfg.Log.MaxAge, 14)) * 24 * time.Hour,
+		MaxBackups: cfg.Log.MaxBackups,
+		Compress:   cfg.Log.Compress == nil || *cfg.Log.Compress,
+	}
+	appLog, err := utils.OpenRotatingLog(utils.AppLogPath(cfg.DataPath), opts)
+	if err != nil {
+		log.Printf("WARNING: Failed to open the log file: %v", err)
+		return nil
+	}
+	if runtime.GOOS == "windows" && !service.Interactive() {
+		log.SetOutput(appLog)
+	} else {
+		log.SetOutput(io.MultiWriter(os.Stderr, appLog))
+	}
+	return appLog
+}
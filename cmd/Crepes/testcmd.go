@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/fixtures"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"gorm.io/gorm"
+)
+
+// -- PIPELINE REGRESSION TESTS --
+//
+// crepes test RUNS SAVED PIPELINES AGAINST RECORDED FIXTURES AND COMPARES WHAT THEY PRODUCE WITH
+// GOLDEN OUTPUTS, SO A SELECTOR OR ENGINE CHANGE THAT ALTERS RESULTS SHOWS UP BEFORE IT SHIPS.
+// EACH SUITE IS A DIRECTORY HOLDING:
+//
+//	job.json      THE JOB TO RUN (AS EXPORTED BY THE API; ITS ID AND FOLDER ARE IGNORED)
+//	fixtures/     HAR FILES AND <HOST>/<PATH> SNAPSHOTS THE RUN IS SERVED FROM (SEE internal/fixtures)
+//	golden.json   THE EXPECTED STATUS, RECORDS AND ASSETS (WRITTEN BY -update)
+//
+// A DIRECTORY WITHOUT job.json IS SEARCHED FOR SUITES ONE LEVEL DOWN.
+
+const (
+	suiteJobFile    = "job.json"
+	suiteFixtureDir = "fixtures"
+	suiteGoldenFile = "golden.json"
+)
+
+// ONE SUITE'S OUTCOME
+type suiteResult struct {
+	name   string
+	diffs  []string
+	misses []string
+	errors []string
+	err    error
+}
+
+func (r suiteResult) passed() bool {
+	return r.err == nil && len(r.diffs) == 0
+}
+
+// RUN crepes test AND RETURN THE EXIT CODE
+func testCommand(args []string) int {
+	flags := flag.NewFlagSet("crepes test", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to configuration file (browser and tool settings)")
+	update := flags.Bool("update", false, "Write each suite's outputs as its new golden file")
+	pattern := flags.String("run", "", "Only run suites whose name matches this regular expression")
+	timeout := flags.Duration("timeout", 2*time.Minute, "Time limit for each suite")
+	serve := flags.Bool("serve", false, "Serve the first suite's fixtures over HTTP instead of testing")
+	verbose := flags.Bool("v", false, "Show engine logs")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: crepes test [-update] [-run pattern] [-timeout d] [-serve] [-v] [dirs...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	dirs := flags.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"tests"}
+	}
+	var filter *regexp.Regexp
+	if *pattern != "" {
+		var err error
+		if filter, err = regexp.Compile(*pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -run pattern: %v\n", err)
+			return 2
+		}
+	}
+	suites, err := findSuites(dirs, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if len(suites) == 0 {
+		fmt.Fprintf(os.Stderr, "no test suites found in %v\n", dirs)
+		return 2
+	}
+
+	if *serve {
+		return serveFixtures(suites[0])
+	}
+
+	instance, err := newScratchInstance(*configPath, *verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer instance.Close()
+
+	failed := 0
+	start := time.Now()
+	for _, dir := range suites {
+		suiteStart := time.Now()
+		result := runSuite(instance, dir, *update, *timeout)
+		elapsed := time.Since(suiteStart).Round(time.Millisecond)
+		switch {
+		case result.err != nil:
+			failed++
+			fmt.Printf("FAIL  %s (%v)\n      %v\n", result.name, elapsed, result.err)
+		case len(result.diffs) > 0:
+			failed++
+			fmt.Printf("FAIL  %s (%v)\n", result.name, elapsed)
+			for _, diff := range result.diffs {
+				fmt.Printf("      %s\n", diff)
+			}
+		case *update:
+			fmt.Printf("SAVED %s (%v)\n", result.name, elapsed)
+		default:
+			fmt.Printf("ok    %s (%v)\n", result.name, elapsed)
+		}
+		if !result.passed() {
+			for _, jobErr := range result.errors {
+				fmt.Printf("      job error: %s\n", jobErr)
+			}
+		}
+		// A MISS ISN'T A FAILURE ON ITS OWN, BUT IT USUALLY EXPLAINS ONE
+		for _, miss := range result.misses {
+			fmt.Printf("      no fixture: %s\n", miss)
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed in %v\n", len(suites)-failed, failed, time.Since(start).Round(time.Millisecond))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// SUITE DIRECTORIES UNDER dirs, SORTED BY NAME
+func findSuites(dirs []string, filter *regexp.Regexp) ([]string, error) {
+	var suites []string
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, suiteJobFile)); err == nil {
+			suites = append(suites, dir)
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !entry.IsDir() {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(path, suiteJobFile)); err == nil {
+				suites = append(suites, path)
+			}
+		}
+	}
+	kept := suites[:0]
+	for _, suite := range suites {
+		if filter == nil || filter.MatchString(filepath.Base(suite)) {
+			kept = append(kept, suite)
+		}
+	}
+	sort.Strings(kept)
+	return kept, nil
+}
+
+// RUN ONE SUITE'S JOB AGAINST ITS FIXTURES AND COMPARE (OR SAVE) ITS OUTPUTS
+func runSuite(instance *scratchInstance, dir string, update bool, timeout time.Duration) suiteResult {
+	db, engine := instance.db, instance.engine
+	result := suiteResult{name: filepath.Base(dir)}
+
+	data, err := os.ReadFile(filepath.Join(dir, suiteJobFile))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	var job models.Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		result.err = fmt.Errorf("%s: %v", suiteJobFile, err)
+		return result
+	}
+	site, err := fixtures.LoadSite(filepath.Join(dir, suiteFixtureDir))
+	if err != nil {
+		result.err = fmt.Errorf("fixtures: %v", err)
+		return result
+	}
+	var golden *fixtures.Golden
+	if !update {
+		if golden, err = fixtures.LoadGolden(filepath.Join(dir, suiteGoldenFile)); err != nil {
+			result.err = fmt.Errorf("%v (run with -update to create it)", err)
+			return result
+		}
+	}
+
+	// THE SUITE'S JOB, STANDING ALONE: NO FOLDER SETTINGS, NO SCHEDULE
+	job.ID = "test_" + result.name
+	job.FolderID = ""
+	job.Schedule = ""
+	job.Status = "idle"
+	job.Assets = nil
+	if job.Name == "" {
+		job.Name = result.name
+	}
+	if err := db.Create(&job).Error; err != nil {
+		result.err = fmt.Errorf("failed to create job: %v", err)
+		return result
+	}
+
+	result.err = instance.runJob(job.ID, scraper.RunOptions{Replay: site}, timeout)
+	if progress, err := engine.GetJobProgress(job.ID); err == nil {
+		result.errors = progress.Errors
+	}
+	result.misses = site.Misses()
+	if result.err != nil {
+		return result
+	}
+
+	got, err := suiteOutputs(db, job.ID)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if update {
+		// KEEP THE FIELDS AN EARLIER GOLDEN FILE SAID TO IGNORE
+		if previous, err := fixtures.LoadGolden(filepath.Join(dir, suiteGoldenFile)); err == nil {
+			got.Ignore = previous.Ignore
+		}
+		result.err = got.Save(filepath.Join(dir, suiteGoldenFile))
+		return result
+	}
+	result.diffs = golden.Compare(got)
+	return result
+}
+
+// WHAT A FINISHED TEST JOB LEFT IN THE DATABASE
+func suiteOutputs(db *gorm.DB, jobID string) (*fixtures.Golden, error) {
+	var job models.Job
+	if err := db.First(&job, "id = ?", jobID).Error; err != nil {
+		return nil, fmt.Errorf("failed to read job: %v", err)
+	}
+	var records []models.Record
+	if err := db.Where("job_id = ?", jobID).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to read records: %v", err)
+	}
+	var assets []models.Asset
+	if err := db.Where("job_id = ?", jobID).Find(&assets).Error; err != nil {
+		return nil, fmt.Errorf("failed to read assets: %v", err)
+	}
+	got := &fixtures.Golden{
+		Status:  job.Status,
+		Records: make([]fixtures.GoldenRecord, 0, len(records)),
+		Assets:  make([]fixtures.GoldenAsset, 0, len(assets)),
+	}
+	for _, record := range records {
+		got.Records = append(got.Records, fixtures.GoldenRecord{Source: record.Source, URL: record.URL, Data: record.Data})
+	}
+	for _, asset := range assets {
+		got.Assets = append(got.Assets, fixtures.GoldenAsset{URL: asset.URL, Type: asset.Type, Size: asset.Size})
+	}
+	return got, nil
+}
+
+// SERVE A SUITE'S FIXTURES ON A LOOPBACK PORT UNTIL INTERRUPTED, FOR LOOKING AT THEM IN A BROWSER
+// (SET THE Host HEADER, OR USE THE SERVER AS AN HTTP PROXY, TO PICK THE SITE)
+func serveFixtures(dir string) int {
+	site, err := fixtures.LoadSite(filepath.Join(dir, suiteFixtureDir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fixtures: %v\n", err)
+		return 1
+	}
+	server := fixtures.Serve(site)
+	defer server.Close()
+	fmt.Printf("serving %d fixtures of %s at %s (as an HTTP proxy or with a Host header)\n", site.Len(), filepath.Base(dir), server.URL)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	return 0
+}
@@ -26,17 +26,34 @@ func SetupRouter(cfg RouterConfig) *mux.Router {
 
 	// MIDDLEWARE
 	router.Use(middleware.LoggingMiddleware)
-	router.Use(middleware.CORSMiddleware)
+	router.Use(middleware.CORSMiddleware(cfg.Config.CORSOrigins))
+	if !cfg.Config.DisableCSRF {
+		// CONFIGURED CORS ORIGINS ARE THE FRONTENDS TRUSTED TO MAKE CHANGES
+		router.Use(middleware.CSRFMiddleware(cfg.Config.CORSOrigins))
+	}
+
+	// PREFLIGHTS MUST MATCH A ROUTE FOR THE MIDDLEWARE ABOVE TO ANSWER THEM
+	router.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
 	// API ROUTES
 	apiRouter := router.PathPrefix("/api").Subrouter()
+	apiRouter.Use(middleware.RateLimitMiddleware(cfg.DB, cfg.Config.RateLimit, cfg.Config.Auth))
+	apiRouter.Use(middleware.AuthMiddleware(cfg.DB, cfg.Config.Auth))
 
 	// SETUP ALL API ROUTES
-	setupJobRoutes(apiRouter, cfg.DB, cfg.ScraperEngine, cfg.JobScheduler)
-	setupAssetRoutes(apiRouter, cfg.DB, cfg.Config)
+	setupAuthRoutes(apiRouter, cfg.DB, cfg.Config)
+	setupJobRoutes(apiRouter, cfg.DB, cfg.ScraperEngine, cfg.JobScheduler, cfg.Config)
+	setupAssetRoutes(apiRouter, cfg.DB, cfg.ScraperEngine, cfg.Config)
 	setupSettingsRoutes(apiRouter, cfg.DB, cfg.Config)
-	setupStorageRoutes(apiRouter, cfg.Config)
-	setupProxyRoutes(apiRouter)
+	setupStorageRoutes(apiRouter, cfg.DB, cfg.ScraperEngine, cfg.Config)
+	setupProxyRoutes(apiRouter, cfg.Config)
+	setupToolRoutes(apiRouter, cfg.DB, cfg.ScraperEngine, cfg.Config)
+	setupAdminRoutes(apiRouter, cfg.DB, cfg.ScraperEngine, cfg.JobScheduler, cfg.Config)
+	setupStatsRoutes(apiRouter, cfg.DB, cfg.ScraperEngine)
+	setupErrorRoutes(apiRouter, cfg.DB, cfg.Config)
+	setupRecordRoutes(apiRouter, cfg.DB, cfg.Config)
+	setupTemplateRoutes(apiRouter, cfg.DB, cfg.ScraperEngine, cfg.JobScheduler)
+	setupSystemRoutes(apiRouter, cfg.ScraperEngine, cfg.Config)
 
 	// UI ROUTES
 	fileServer := http.FileServer(ui.GetFileSystem())
@@ -52,38 +69,136 @@ func SetupRouter(cfg RouterConfig) *mux.Router {
 	return router
 }
 
+// AUTH ROUTES
+func setupAuthRoutes(router *mux.Router, db *gorm.DB, cfg *config.Config) {
+	// SIGN IN AND OUT (STATUS, SETUP AND LOGIN NEED NO SESSION)
+	router.HandleFunc("/auth/status", handlers.GetAuthStatus(db, cfg)).Methods("GET")
+	router.HandleFunc("/auth/setup", handlers.SetupAuth(db, cfg)).Methods("POST")
+	router.HandleFunc("/auth/login", handlers.Login(db, cfg)).Methods("POST")
+	router.HandleFunc("/auth/logout", handlers.Logout(db, cfg)).Methods("POST")
+
+	// THE SIGNED-IN USER
+	router.HandleFunc("/auth/me", handlers.GetCurrentUser()).Methods("GET")
+	router.HandleFunc("/auth/password", handlers.ChangePassword(db)).Methods("PUT")
+
+	// MANAGE USERS (ADMINS ONLY)
+	router.HandleFunc("/users", handlers.GetUsers(db)).Methods("GET")
+	router.HandleFunc("/users", handlers.CreateUser(db)).Methods("POST")
+	router.HandleFunc("/users/{id}", handlers.UpdateUser(db)).Methods("PUT")
+	router.HandleFunc("/users/{id}", handlers.DeleteUser(db)).Methods("DELETE")
+}
+
 // JOBS ROUTES
-func setupJobRoutes(router *mux.Router, db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler) {
-	// GET ALL JOBS
+func setupJobRoutes(router *mux.Router, db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler, cfg *config.Config) {
+	// SEARCH AND LIST JOBS
 	router.HandleFunc("/jobs", handlers.GetAllJobs(db)).Methods("GET")
 
+	// SAVED JOB FILTERS (REGISTERED BEFORE /jobs/{id} SO "filters" ISN'T TAKEN AS AN ID)
+	router.HandleFunc("/jobs/filters", handlers.GetSavedFilters(db)).Methods("GET")
+	router.HandleFunc("/jobs/filters", handlers.CreateSavedFilter(db)).Methods("POST")
+	router.HandleFunc("/jobs/filters/{id}", handlers.DeleteSavedFilter(db)).Methods("DELETE")
+
+	// BULK RE-TAG / MOVE AND BULK START
+	router.HandleFunc("/jobs/bulk", handlers.BulkUpdateJobs(db)).Methods("POST")
+	router.HandleFunc("/jobs/bulk/start", handlers.BulkStartJobs(db, engine)).Methods("POST")
+
+	// SITE PRESETS AND THE JOB DRAFTS THEY MAKE (BEFORE /jobs/{id} FOR THE SAME REASON)
+	router.HandleFunc("/jobs/presets", handlers.GetSitePresets(cfg)).Methods("GET")
+	router.HandleFunc("/jobs/presets/{id}", handlers.GetSitePresetJob(engine, cfg)).Methods("GET")
+
 	// GET JOB BY ID
-	router.HandleFunc("/jobs/{id}", handlers.GetJobByID(db)).Methods("GET")
+	router.HandleFunc("/jobs/{id}", handlers.GetJobByID(db, cfg)).Methods("GET")
 
 	// CREATE JOB
-	router.HandleFunc("/jobs", handlers.CreateJob(db, scheduler)).Methods("POST")
+	router.HandleFunc("/jobs", handlers.Idempotent(db, handlers.CreateJob(db, engine, scheduler))).Methods("POST")
+
+	// VALIDATE JOB PAYLOAD WITHOUT SAVING
+	router.HandleFunc("/jobs/validate", handlers.ValidateJob(engine)).Methods("POST")
+
+	// ESTIMATE A JOB PAYLOAD'S REQUESTS, DURATION AND STORAGE WITHOUT RUNNING IT
+	router.HandleFunc("/jobs/simulate", handlers.SimulateJob(db, engine)).Methods("POST")
+
+	// CREATE ARCHIVE-SITE JOB FROM PRESET
+	router.HandleFunc("/jobs/presets/archive-site", handlers.Idempotent(db, handlers.CreateArchiveSiteJob(db, scheduler))).Methods("POST")
+
+	// CREATE A JOB THAT WATCHES PAGES FOR CHANGES ON A SCHEDULE
+	router.HandleFunc("/jobs/presets/monitor", handlers.Idempotent(db, handlers.CreateMonitorJob(db, engine, scheduler))).Methods("POST")
 
 	// UPDATE JOB
-	router.HandleFunc("/jobs/{id}", handlers.UpdateJob(db, scheduler)).Methods("PUT")
+	router.HandleFunc("/jobs/{id}", handlers.UpdateJob(db, engine, scheduler)).Methods("PUT")
 
 	// DELETE JOB
-	router.HandleFunc("/jobs/{id}", handlers.DeleteJob(db, engine, scheduler)).Methods("DELETE")
+	router.HandleFunc("/jobs/{id}", handlers.DeleteJob(db, engine, scheduler, cfg)).Methods("DELETE")
+
+	// WHAT CHANGED ON THE PAGES A JOB WATCHES
+	router.HandleFunc("/jobs/{id}/changes", handlers.GetJobChanges(db)).Methods("GET")
+
+	// CONVERT A LEGACY SELECTOR JOB INTO A PIPELINE (?preview=true TO ONLY SEE IT)
+	router.HandleFunc("/jobs/{id}/migrate", handlers.MigrateLegacyJob(db, engine)).Methods("POST")
 
 	// START JOB
-	router.HandleFunc("/jobs/{id}/start", handlers.StartJob(db, engine)).Methods("POST")
+	router.HandleFunc("/jobs/{id}/start", handlers.Idempotent(db, handlers.StartJob(db, engine))).Methods("POST")
 
 	// STOP JOB
 	router.HandleFunc("/jobs/{id}/stop", handlers.StopJob(db, engine)).Methods("POST")
 
+	// ESTIMATE A SAVED JOB'S REQUESTS, DURATION AND STORAGE WITHOUT RUNNING IT
+	router.HandleFunc("/jobs/{id}/simulate", handlers.SimulateJob(db, engine)).Methods("POST")
+
 	// GET JOB ASSETS
 	router.HandleFunc("/jobs/{id}/assets", handlers.GetJobAssets(db)).Methods("GET")
 
 	// GET JOB STATISTICS
 	router.HandleFunc("/jobs/{id}/statistics", handlers.GetJobStatistics(db, engine)).Methods("GET")
+
+	// GET JOB DOWNLOAD PROGRESS
+	router.HandleFunc("/jobs/{id}/downloads", handlers.GetJobDownloads(db, engine)).Methods("GET")
+
+	// STATE KEPT BETWEEN RUNS (CURSORS, LAST SEEN IDS)
+	router.HandleFunc("/jobs/{id}/state", handlers.GetJobState(db, engine)).Methods("GET")
+	router.HandleFunc("/jobs/{id}/state", handlers.DeleteJobState(db, engine)).Methods("DELETE")
+	router.HandleFunc("/jobs/{id}/state/{key}", handlers.PutJobState(db, engine)).Methods("PUT")
+	router.HandleFunc("/jobs/{id}/state/{key}", handlers.DeleteJobState(db, engine)).Methods("DELETE")
+
+	// COOKIES AND LOCAL STORAGE KEPT BETWEEN RUNS (persistSession RULE)
+	router.HandleFunc("/jobs/{id}/session", handlers.GetJobSession(db, engine)).Methods("GET")
+	router.HandleFunc("/jobs/{id}/session", handlers.DeleteJobSession(db, engine)).Methods("DELETE")
+
+	// RESPONSES RECORDED FOR REPLAY (cassette RULE)
+	router.HandleFunc("/jobs/{id}/cassette", handlers.GetJobCassette(db, cfg)).Methods("GET")
+	router.HandleFunc("/jobs/{id}/cassette", handlers.DeleteJobCassette(db, engine)).Methods("DELETE")
+
+	// WHAT HAPPENED TO EACH ITEM OF A RUN'S LOOPS
+	router.HandleFunc("/jobs/{id}/items", handlers.GetJobItemResults(db)).Methods("GET")
+	router.HandleFunc("/jobs/{id}/items/summary", handlers.GetJobItemSummary(db)).Methods("GET")
+
+	// WHERE THE TIME OF A RUN WENT: STAGES, TASKS, RETRIES AND DOWNLOADS
+	router.HandleFunc("/jobs/{id}/timeline", handlers.GetJobTimeline(db, engine)).Methods("GET")
+
+	// QUEUED, RUNNING AND SCHEDULED RUNS
+	router.HandleFunc("/queue", handlers.GetQueue(db, engine, scheduler)).Methods("GET")
+
+	// A QUEUED JOB'S POSITION, AND MOVING IT TO ANOTHER PRIORITY
+	router.HandleFunc("/jobs/{id}/queue", handlers.GetJobQueuePosition(db, engine)).Methods("GET")
+	router.HandleFunc("/jobs/{id}/queue", handlers.UpdateJobQueuePriority(db, engine)).Methods("PUT")
+
+	// JOB FOLDERS
+	router.HandleFunc("/folders", handlers.GetFolders(db)).Methods("GET")
+	router.HandleFunc("/folders", handlers.CreateFolder(db, scheduler)).Methods("POST")
+	router.HandleFunc("/folders/{id}", handlers.UpdateFolder(db, scheduler)).Methods("PUT")
+	router.HandleFunc("/folders/{id}", handlers.DeleteFolder(db, scheduler)).Methods("DELETE")
+
+	// JOB TAGS
+	router.HandleFunc("/tags", handlers.GetTags(db)).Methods("GET")
+	router.HandleFunc("/tags/{tag}", handlers.RenameTag(db)).Methods("PUT")
+	router.HandleFunc("/tags/{tag}", handlers.DeleteTag(db)).Methods("DELETE")
+
+	// LIVE ENGINE EVENTS (WEBSOCKET)
+	router.HandleFunc("/ws", handlers.EventStream(db, engine)).Methods("GET")
 }
 
 // ASSETS ROUTES
-func setupAssetRoutes(router *mux.Router, db *gorm.DB, cfg *config.Config) {
+func setupAssetRoutes(router *mux.Router, db *gorm.DB, engine *scraper.Engine, cfg *config.Config) {
 	// GET ALL ASSETS WITH OPTIONAL FILTERS
 	router.HandleFunc("/assets", handlers.GetAllAssets(db)).Methods("GET")
 
@@ -91,19 +206,27 @@ func setupAssetRoutes(router *mux.Router, db *gorm.DB, cfg *config.Config) {
 	router.HandleFunc("/assets/{id}", handlers.GetAssetByID(db)).Methods("GET")
 
 	// DELETE ASSET
-	router.HandleFunc("/assets/{id}", handlers.DeleteAsset(db, cfg)).Methods("DELETE")
+	router.HandleFunc("/assets/{id}", handlers.DeleteAsset(db, engine, cfg)).Methods("DELETE")
 
-	// REGENERATE THUMBNAIL
-	router.HandleFunc("/assets/{id}/regenerate-thumbnail", handlers.RegenerateThumbnail(db, cfg)).Methods("POST")
+	// REGENERATE THUMBNAILS: ONE NOW, OR MANY THROUGH THE THUMBNAIL QUEUE
+	router.HandleFunc("/assets/thumbnails/regenerate", handlers.RegenerateThumbnails(db, engine)).Methods("POST")
+	router.HandleFunc("/assets/{id}/thumbnail/regenerate", handlers.RegenerateThumbnail(engine)).Methods("POST")
+	router.HandleFunc("/assets/{id}/regenerate-thumbnail", handlers.RegenerateThumbnail(engine)).Methods("POST") // OLDER PATH
+
+	// TEXT EXTRACTED FROM A DOCUMENT ASSET
+	router.HandleFunc("/assets/{id}/text", handlers.GetAssetText(db)).Methods("GET")
+
+	// STREAM ASSET FILE (RANGE REQUESTS, CONDITIONAL REQUESTS)
+	router.HandleFunc("/assets/{id}/content", handlers.StreamAsset(db, cfg, engine.Storage())).Methods("GET", "HEAD")
 
 	// GET ASSET COUNTS BY TYPE
 	router.HandleFunc("/assets/counts", handlers.GetAssetCounts(db)).Methods("GET")
 
 	// SERVE ASSET FILES
-	router.PathPrefix("/assets/").Handler(http.StripPrefix("/api/assets/", http.FileServer(http.Dir(cfg.StoragePath))))
+	router.PathPrefix("/assets/").Handler(http.StripPrefix("/api/assets/", handlers.ServeStorage(db, cfg.StoragePath, cfg, engine.Storage(), engine.Storage().Assets)))
 
 	// SERVE THUMBNAIL FILES
-	router.PathPrefix("/thumbnails/").Handler(http.StripPrefix("/api/thumbnails/", http.FileServer(http.Dir(cfg.ThumbnailsPath))))
+	router.PathPrefix("/thumbnails/").Handler(http.StripPrefix("/api/thumbnails/", handlers.ServeStorage(nil, cfg.ThumbnailsPath, cfg, engine.Storage(), engine.Storage().Thumbnails)))
 }
 
 // SETTINGS ROUTES
@@ -111,21 +234,154 @@ func setupSettingsRoutes(router *mux.Router, db *gorm.DB, cfg *config.Config) {
 	// GET ALL SETTINGS
 	router.HandleFunc("/settings", handlers.GetSettings(db, cfg)).Methods("GET")
 
-	// UPDATE SETTINGS
+	// UPDATE SETTINGS (CHECKED AGAINST THE SCHEMA)
 	router.HandleFunc("/settings", handlers.UpdateSettings(db, cfg)).Methods("PUT")
 
+	// TYPES, RANGES AND DESCRIPTIONS OF EVERY SETTING, FOR BUILDING THE SETTINGS FORM
+	router.HandleFunc("/settings/schema", handlers.GetSettingsSchema()).Methods("GET")
+
 	// CLEAR CACHE
 	router.HandleFunc("/cache/clear", handlers.ClearCache()).Methods("POST")
+
+	// VAULT: NAMED SECRETS FOR TASKS (VALUES ARE WRITE-ONLY)
+	router.HandleFunc("/secrets", handlers.GetSecrets(db)).Methods("GET")
+	router.HandleFunc("/secrets/{name}", handlers.PutSecret(db, cfg)).Methods("PUT")
+	router.HandleFunc("/secrets/{name}", handlers.DeleteSecret(db)).Methods("DELETE")
 }
 
 // STORAGE ROUTES
-func setupStorageRoutes(router *mux.Router, cfg *config.Config) {
+func setupStorageRoutes(router *mux.Router, db *gorm.DB, engine *scraper.Engine, cfg *config.Config) {
 	// GET STORAGE INFO
-	router.HandleFunc("/storage/info", handlers.GetStorageInfo(cfg)).Methods("GET")
+	router.HandleFunc("/storage/info", handlers.GetStorageInfo(cfg, engine.Storage())).Methods("GET")
+
+	// GET DISK USAGE PER JOB AND TYPE
+	router.HandleFunc("/storage/usage", handlers.GetStorageUsage(db, cfg, engine.Storage())).Methods("GET")
 }
 
 // PROXY ROUTES
-func setupProxyRoutes(router *mux.Router) {
+func setupProxyRoutes(router *mux.Router, cfg *config.Config) {
 	// PROXY HANDLER FOR FRONTEND VISUAL SELECTOR
-	router.HandleFunc("/proxy", handlers.ProxyHandler()).Methods("GET")
+	router.HandleFunc("/proxy", handlers.ProxyHandler(cfg)).Methods("GET")
+}
+
+// TOOL ROUTES
+func setupToolRoutes(router *mux.Router, db *gorm.DB, engine *scraper.Engine, cfg *config.Config) {
+	// PROBE A URL FOR MEDIA SOURCES WITHOUT CREATING A JOB
+	router.HandleFunc("/tools/extract-media", handlers.ExtractMedia(cfg)).Methods("POST")
+
+	// RE-RUN A FAILED TASK AGAINST ITS CAPTURED SNAPSHOT
+	router.HandleFunc("/tools/replay", handlers.ReplayTask(db, engine)).Methods("POST")
+
+	// VALIDATE A CRON SCHEDULE AND LIST ITS NEXT RUN TIMES
+	router.HandleFunc("/tools/preview-schedule", handlers.PreviewSchedule()).Methods("POST")
+
+	// SEND A TEST NOTIFICATION TO THE CONFIGURED CHANNELS
+	router.HandleFunc("/tools/test-notification", handlers.TestNotification(engine, cfg)).Methods("POST")
+}
+
+// ADMIN ROUTES
+func setupAdminRoutes(router *mux.Router, db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler, cfg *config.Config) {
+	// RECONCILE STORAGE AGAINST THE DATABASE (DRY RUN UNLESS {"dryRun": false})
+	router.HandleFunc("/admin/gc", handlers.GarbageCollect(db, engine, cfg)).Methods("POST")
+
+	// DATABASE AND STORAGE MAINTENANCE: STATUS, OR RUN TASKS NOW
+	router.HandleFunc("/admin/maintenance", handlers.GetMaintenance(engine, scheduler)).Methods("GET")
+	router.HandleFunc("/admin/maintenance", handlers.RunMaintenance(engine)).Methods("POST")
+
+	// USER AGENT POOL AND ITS SIGNED MANIFEST: STATUS, OR CHECK FOR A NEWER ONE NOW
+	router.HandleFunc("/admin/user-agents", handlers.GetUserAgents(engine, scheduler)).Methods("GET")
+	router.HandleFunc("/admin/user-agents/update", handlers.UpdateUserAgents(engine)).Methods("POST")
+
+	// TAIL THE APPLICATION LOG (?lines=200&contains=)
+	router.HandleFunc("/admin/logs", handlers.GetAppLog(engine)).Methods("GET")
+}
+
+// STATS ROUTES
+func setupStatsRoutes(router *mux.Router, db *gorm.DB, engine *scraper.Engine) {
+	// DASHBOARD OVERVIEW
+	router.HandleFunc("/stats/overview", handlers.GetStatsOverview(db, engine)).Methods("GET")
+}
+
+// ERROR LOG ROUTES
+func setupErrorRoutes(router *mux.Router, db *gorm.DB, cfg *config.Config) {
+	// LIST AND FILTER ERRORS
+	router.HandleFunc("/errors", handlers.GetErrorLogs(db)).Methods("GET")
+
+	// GROUP ERRORS BY JOB, STAGE, STATUS CODE OR FINGERPRINT
+	router.HandleFunc("/errors/groups", handlers.GetErrorGroups(db)).Methods("GET")
+
+	// ACKNOWLEDGE / RESOLVE / REOPEN ERRORS
+	router.HandleFunc("/errors/bulk", handlers.BulkUpdateErrorLogs(db)).Methods("POST")
+
+	// GET ERROR BY ID
+	router.HandleFunc("/errors/{id}", handlers.GetErrorLog(db)).Methods("GET")
+
+	// FAILURE CAPTURES
+	router.HandleFunc("/errors/{id}/screenshot", handlers.GetErrorCapture(db, cfg, "screenshot")).Methods("GET")
+	router.HandleFunc("/errors/{id}/snapshot", handlers.GetErrorCapture(db, cfg, "snapshot")).Methods("GET")
+
+	// DOWNLOAD A REPRODUCTION BUNDLE (CONFIG, INPUTS, HAR, SNAPSHOT, SCREENSHOT)
+	router.HandleFunc("/errors/{id}/bundle", handlers.GetErrorBundle(db, cfg)).Methods("GET")
+}
+
+// RECORD ROUTES
+func setupRecordRoutes(router *mux.Router, db *gorm.DB, cfg *config.Config) {
+	// LIST AND FILTER RECORDS
+	router.HandleFunc("/records", handlers.GetRecords(db)).Methods("GET")
+
+	// EXPORT RECORDS AS JSON, JSONL OR CSV
+	router.HandleFunc("/records/export", handlers.ExportRecords(db)).Methods("GET")
+
+	// SCHEMA VALIDITY RATE PER RUN
+	router.HandleFunc("/records/validity", handlers.GetRecordValidity(db)).Methods("GET")
+
+	// ALERTS FIRED BY JOBS' recordAlerts RULES
+	router.HandleFunc("/records/alerts", handlers.GetRecordAlerts(db)).Methods("GET")
+
+	// RECORDS REJECTED BY THEIR JOB'S SCHEMA: LIST, RETRY (OPTIONALLY CORRECTED), DISCARD
+	router.HandleFunc("/records/rejections", handlers.GetRecordRejections(db)).Methods("GET")
+	router.HandleFunc("/records/rejections/{id}/retry", handlers.RetryRecordRejection(db, cfg)).Methods("POST")
+	router.HandleFunc("/records/rejections/{id}", handlers.DeleteRecordRejection(db)).Methods("DELETE")
+
+	// GET RECORD BY ID
+	router.HandleFunc("/records/{id}", handlers.GetRecord(db)).Methods("GET")
+
+	// CHANGE HISTORY OF A KEYED RECORD
+	router.HandleFunc("/records/{id}/history", handlers.GetRecordHistory(db)).Methods("GET")
+
+	// DELETE RECORD
+	router.HandleFunc("/records/{id}", handlers.DeleteRecord(db)).Methods("DELETE")
+}
+
+// TEMPLATE ROUTES
+func setupTemplateRoutes(router *mux.Router, db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler) {
+	// LIST AND CREATE TEMPLATES
+	router.HandleFunc("/templates", handlers.GetTemplates(db)).Methods("GET")
+	router.HandleFunc("/templates", handlers.Idempotent(db, handlers.CreateTemplate(db))).Methods("POST")
+
+	// SHARE TEMPLATES BETWEEN INSTANCES (ALL OR ?ids=)
+	router.HandleFunc("/templates/export", handlers.ExportTemplates(db)).Methods("GET")
+	router.HandleFunc("/templates/import", handlers.Idempotent(db, handlers.ImportTemplates(db))).Methods("POST")
+
+	// GET, UPDATE, DELETE AND EXPORT A TEMPLATE
+	router.HandleFunc("/templates/{id}", handlers.GetTemplate(db)).Methods("GET")
+	router.HandleFunc("/templates/{id}", handlers.UpdateTemplate(db)).Methods("PUT")
+	router.HandleFunc("/templates/{id}", handlers.DeleteTemplate(db)).Methods("DELETE")
+	router.HandleFunc("/templates/{id}/export", handlers.ExportTemplates(db)).Methods("GET")
+
+	// CREATE A JOB FROM A TEMPLATE, FILLING IN ITS VARIABLES
+	router.HandleFunc("/templates/{id}/instantiate", handlers.Idempotent(db, handlers.InstantiateTemplate(db, engine, scheduler))).Methods("POST")
+}
+
+// SYSTEM ROUTES
+func setupSystemRoutes(router *mux.Router, engine *scraper.Engine, cfg *config.Config) {
+	// RUNTIME ENVIRONMENT REPORT
+	router.HandleFunc("/system/environment", handlers.GetEnvironment(engine)).Methods("GET")
+
+	// MEMORY WATCHDOG STATUS
+	router.HandleFunc("/system/memory", handlers.GetMemoryStatus(engine)).Methods("GET")
+
+	// BROWSER POOL STATS AND LIVE LIMITS
+	router.HandleFunc("/system/browser-pool", handlers.GetBrowserPool(engine)).Methods("GET")
+	router.HandleFunc("/system/browser-pool", handlers.UpdateBrowserPool(engine, cfg)).Methods("PUT")
 }
@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// -- ACCOUNTS --
+//
+// WITH auth.enabled EVERY API CALL CARRIES A SESSION TOKEN (Authorization: Bearer OR THE SESSION
+// COOKIE THE UI GETS AT LOGIN). TOKENS ARE RANDOM AND ONLY THEIR SHA-256 IS STORED, SO A COPY OF
+// THE DATABASE CAN'T LOG ANYONE IN. ADMINS SEE AND MANAGE EVERYTHING; OTHER USERS ONLY THEIR OWN
+// JOBS AND THE ASSETS, RECORDS AND RUNS THOSE JOBS PRODUCE. WITH AUTH OFF THERE IS NO USER ON A
+// REQUEST AND NOTHING IS FILTERED.
+
+// ROLES
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// COOKIE THE UI'S SESSION TOKEN TRAVELS IN
+const SessionCookie = "crepes_session"
+
+// SHORTEST PASSWORD ACCEPTED
+const MinPasswordLength = 8
+
+// HOW OFTEN A SESSION'S EXPIRY IS PUSHED BACK AS IT IS USED
+const sessionTouchInterval = time.Minute
+
+var (
+	ErrInvalidCredentials = errors.New("INVALID USERNAME OR PASSWORD")
+	ErrSessionExpired     = errors.New("SESSION EXPIRED OR UNKNOWN")
+)
+
+type userKey struct{}
+
+// A CONTEXT CARRYING THE SIGNED-IN USER
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// THE SIGNED-IN USER, NIL WHEN AUTH IS OFF
+func UserFrom(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userKey{}).(*models.User)
+	return user
+}
+
+// WHETHER A REQUEST'S USER MAY SEE EVERYTHING (ADMINS, AND EVERYONE WHEN AUTH IS OFF)
+func SeesAll(user *models.User) bool {
+	return user == nil || user.Role == RoleAdmin
+}
+
+// WHETHER A PASSWORD IS ACCEPTABLE (BCRYPT ONLY READS THE FIRST 72 BYTES)
+func CheckPassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("PASSWORD MUST BE AT LEAST %d CHARACTERS", MinPasswordLength)
+	}
+	if len(password) > 72 {
+		return fmt.Errorf("PASSWORD CAN'T BE LONGER THAN 72 BYTES")
+	}
+	return nil
+}
+
+func HashPassword(password string) (string, error) {
+	if err := CheckPassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CHECK A USERNAME AND PASSWORD, RETURNING THE (ENABLED) USER
+func Authenticate(db *gorm.DB, username, password string) (*models.User, error) {
+	var user models.User
+	found := db.Where("username = ?", strings.TrimSpace(username)).Limit(1).Find(&user)
+	if found.Error != nil {
+		return nil, found.Error
+	}
+	if found.RowsAffected == 0 {
+		// SAME WORK AS A WRONG PASSWORD SO TIMING DOESN'T GIVE AWAY WHICH USERNAMES EXIST
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil || user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("crepes-no-such-user"), bcrypt.DefaultCost)
+
+// START A SESSION, RETURNING THE TOKEN TO HAND THE CLIENT
+func CreateSession(db *gorm.DB, user *models.User, lifetime time.Duration, userAgent, ip string) (string, *models.AuthSession, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now()
+	session := &models.AuthSession{
+		ID:         hashToken(token),
+		UserID:     user.ID,
+		ExpiresAt:  now.Add(lifetime),
+		LastUsedAt: now,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+	}
+	if err := db.Create(session).Error; err != nil {
+		return "", nil, err
+	}
+	db.Model(user).Update("last_login_at", now)
+	return token, session, nil
+}
+
+// THE USER BEHIND A TOKEN, PUSHING THE SESSION'S EXPIRY BACK
+func ResolveSession(db *gorm.DB, token string, lifetime time.Duration) (*models.User, error) {
+	if token == "" {
+		return nil, ErrSessionExpired
+	}
+	var session models.AuthSession
+	found := db.Where("id = ?", hashToken(token)).Limit(1).Find(&session)
+	if found.Error != nil {
+		return nil, found.Error
+	}
+	now := time.Now()
+	if found.RowsAffected == 0 || now.After(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	var user models.User
+	if db.Where("id = ?", session.UserID).Limit(1).Find(&user).RowsAffected == 0 || user.Disabled {
+		return nil, ErrSessionExpired
+	}
+	if now.Sub(session.LastUsedAt) > sessionTouchInterval {
+		db.Model(&session).Updates(map[string]any{"last_used_at": now, "expires_at": now.Add(lifetime)})
+	}
+	return &user, nil
+}
+
+// END THE SESSION A TOKEN BELONGS TO
+func DeleteSession(db *gorm.DB, token string) error {
+	return db.Where("id = ?", hashToken(token)).Delete(&models.AuthSession{}).Error
+}
+
+// END EVERY SESSION OF A USER (PASSWORD CHANGES, DISABLED OR DELETED ACCOUNTS)
+func DeleteUserSessions(db *gorm.DB, userID string) error {
+	return db.Where("user_id = ?", userID).Delete(&models.AuthSession{}).Error
+}
+
+// END A USER'S OTHER SESSIONS, KEEPING THE ONE A TOKEN BELONGS TO
+func DeleteOtherSessions(db *gorm.DB, userID, keepToken string) error {
+	return db.Where("user_id = ? AND id <> ?", userID, hashToken(keepToken)).Delete(&models.AuthSession{}).Error
+}
+
+// DROP EXPIRED SESSIONS
+func PruneSessions(db *gorm.DB) (int64, error) {
+	result := db.Where("expires_at < ?", time.Now()).Delete(&models.AuthSession{})
+	return result.RowsAffected, result.Error
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MAKE A USER (PASSWORD CHECKED AND HASHED)
+func CreateUser(db *gorm.DB, username, password, role string) (*models.User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		role = RoleUser
+	}
+	now := time.Now()
+	user := &models.User{
+		ID:           utils.GenerateID("user"),
+		Username:     strings.TrimSpace(username),
+		PasswordHash: hash,
+		Role:         role,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	return user, db.Create(user).Error
+}
+
+// CREATE THE FIRST ADMIN FROM THE CONFIG (OR CREPES_ADMIN_PASSWORD) WHILE THERE ARE NO USERS
+func Bootstrap(db *gorm.DB, cfg config.AuthConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	var users int64
+	if err := db.Model(&models.User{}).Count(&users).Error; err != nil {
+		return err
+	}
+	if users > 0 {
+		return nil
+	}
+	password := cfg.AdminPassword
+	if password == "" {
+		password = os.Getenv("CREPES_ADMIN_PASSWORD")
+	}
+	if password == "" {
+		log.Printf("AUTH IS ON BUT THERE ARE NO USERS: POST /api/auth/setup TO CREATE THE FIRST ADMIN")
+		return nil
+	}
+	username := cfg.AdminUsername
+	if username == "" {
+		username = "admin"
+	}
+	if _, err := CreateUser(db, username, password, RoleAdmin); err != nil {
+		return fmt.Errorf("COULD NOT CREATE ADMIN %s: %v", username, err)
+	}
+	log.Printf("CREATED ADMIN USER %s", username)
+	return nil
+}
+
+// -- OWNERSHIP SCOPES --
+
+// LIMIT A JOBS QUERY TO THE USER'S OWN
+func OwnJobs(user *models.User) func(*gorm.DB) *gorm.DB {
+	return Owned(user)
+}
+
+// LIMIT A QUERY OF ROWS WITH AN owner_id (JOBS, FOLDERS, TEMPLATES) TO THE USER'S OWN
+func Owned(user *models.User) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if SeesAll(user) {
+			return db
+		}
+		return db.Where("owner_id = ?", user.ID)
+	}
+}
+
+// LIMIT A QUERY OF ROWS WITH A job_id (ASSETS, RECORDS, RUNS...) TO THE USER'S JOBS
+func OwnJobRows(user *models.User) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if SeesAll(user) {
+			return db
+		}
+		return db.Where("job_id IN (SELECT id FROM jobs WHERE owner_id = ?)", user.ID)
+	}
+}
+
+// WHETHER THE USER MAY SEE A JOB (AN UNKNOWN JOB COUNTS AS YES SO THE HANDLER CAN 404 IT)
+func CanAccessJob(db *gorm.DB, user *models.User, jobID string) bool {
+	if SeesAll(user) {
+		return true
+	}
+	var job models.Job
+	if db.Select("id", "owner_id").Where("id = ?", jobID).Limit(1).Find(&job).RowsAffected == 0 {
+		return true
+	}
+	return job.OwnerID == user.ID
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 // CONFIG STRUCTURE
@@ -14,6 +16,311 @@ type Config struct {
 	DataPath       string `json:"dataPath"`
 	MaxConcurrent  int    `json:"maxConcurrent"`
 	DefaultTimeout int    `json:"defaultTimeout"` // IN MS
+	TLSFingerprint string `json:"tlsFingerprint"` // CLIENTHELLO TO IMPERSONATE FOR DIRECT HTTP (chrome, firefox, ...)
+	HTTPVersion    string `json:"httpVersion"`    // auto, 1.1, 2, 3
+	Humanize       bool   `json:"humanize"`       // PACE CLICKS, TYPING AND SCROLLING LIKE A PERSON (JOBS AND TASKS CAN OVERRIDE)
+
+	// CERTIFICATE CHECKS FOR DIRECT HTTP REQUESTS (JOBS CAN TURN THEM OFF WITH THE tlsVerify RULE)
+	TLSVerify        *bool    `json:"tlsVerify"`        // DEFAULTS TO TRUE
+	TLSCAFile        string   `json:"tlsCaFile"`        // PEM BUNDLE TRUSTED ON TOP OF THE SYSTEM ROOTS
+	TLSInsecureHosts []string `json:"tlsInsecureHosts"` // HOSTS (OR *.DOMAINS) WHOSE CERTIFICATES AREN'T CHECKED
+
+	// BROWSER RUNTIME
+	BrowserPath     string `json:"browserPath"`     // CHROMIUM/CHROME TO LAUNCH INSTEAD OF PLAYWRIGHT'S (ALSO CHROMIUM_PATH)
+	BrowserCacheDir string `json:"browserCacheDir"` // WHERE THE PLAYWRIGHT DRIVER AND BROWSERS ARE INSTALLED
+	BrowserSandbox  string `json:"browserSandbox"`  // auto (OFF IN CONTAINERS AND AS ROOT), on, off
+
+	// BROWSERS AND PAGES UNUSED THIS LONG ARE CLOSED, IN MS (0 = 30 MINUTES, NEGATIVE = NEVER)
+	ResourceIdleTimeout int `json:"resourceIdleTimeout"`
+
+	// BROWSER POOL (0 = NO LIMIT); LAUNCHES AND PAGES OVER A LIMIT WAIT FOR ONE TO CLOSE
+	MaxBrowsers     int `json:"maxBrowsers"`     // OPEN BROWSERS ACROSS ALL JOBS
+	MaxBrowserTabs  int `json:"maxBrowserTabs"`  // OPEN PAGES PER BROWSER
+	BrowserLifetime int `json:"browserLifetime"` // MS BEFORE AN IDLE BROWSER IS RELAUNCHED (0 = NEVER)
+
+	// PAGES PARSED WITHOUT A BROWSER (MEDIA EXTRACTION, SITE ARCHIVES): BYTES READ AND ELEMENTS KEPT
+	HTMLMaxBytes    int64 `json:"htmlMaxBytes"`    // 0 = 50MB
+	HTMLMaxElements int   `json:"htmlMaxElements"` // 0 = 100000
+
+	// MEMORY WATCHDOG LIMITS IN MB (0 = 70%/85% OF THE CONTAINER OR SYSTEM MEMORY, NEGATIVE = OFF)
+	MemorySoftLimit int `json:"memorySoftLimit"` // FEWER PARALLEL WORKERS, DOWNLOADS WAIT
+	MemoryHardLimit int `json:"memoryHardLimit"` // ONE WORKER, HEAVIEST BROWSERS RECYCLED
+
+	// EXTERNAL MEDIA TOOLS FOR THUMBNAILS (EMPTY = LOOK ON THE PATH)
+	FFmpegPath  string `json:"ffmpegPath"`
+	FFprobePath string `json:"ffprobePath"`
+	MagickPath  string `json:"magickPath"` // IMAGEMAGICK 7 magick OR 6 convert
+
+	// EXTERNAL DOCUMENT TOOLS: PDF TEXT FOR SEARCH, OFFICE FILE PREVIEWS (EMPTY = LOOK ON THE PATH)
+	PdftotextPath   string `json:"pdftotextPath"`
+	LibreOfficePath string `json:"libreOfficePath"` // soffice OR libreoffice
+
+	// THUMBNAIL QUEUE: RENDERS AT ONCE (0 = 2) AND ASSETS HELD IN MEMORY (0 = 1000, THE REST WAIT IN THE DATABASE)
+	ThumbnailWorkers   int `json:"thumbnailWorkers"`
+	ThumbnailQueueSize int `json:"thumbnailQueueSize"`
+
+	// OUTGOING JOB TRAFFIC (JOBS AND FOLDERS CAN OVERRIDE BOTH WITH THEIR settings)
+	Proxy     string `json:"proxy"`     // http, https OR socks5 URL (EMPTY = THE ENVIRONMENT'S HTTP_PROXY)
+	UserAgent string `json:"userAgent"` // default, rotate OR A USER AGENT STRING
+
+	// KEEPING THE USER AGENT POOL (AND OPTIONALLY THE BROWSER) CURRENT FROM A SIGNED MANIFEST
+	UserAgentUpdates UserAgentUpdateConfig `json:"userAgentUpdates"`
+
+	// NAMED HEADER SETS JOBS APPLY WITH THE headerProfile RULE, E.G. {"shop-api": {"X-Api-Key": "env:SHOP_KEY"}}
+	HeaderProfiles map[string]map[string]string `json:"headerProfiles"`
+
+	// DOWNLOAD CONCURRENCY
+	MaxDownloadWorkers int `json:"maxDownloadWorkers"` // GLOBAL SIMULTANEOUS DOWNLOADS
+	MaxConnsPerHost    int `json:"maxConnsPerHost"`    // SIMULTANEOUS DOWNLOADS PER HOST
+
+	// JOB QUEUE
+	MaxConcurrentJobs int              `json:"maxConcurrentJobs"` // RUNS AT ONCE (0 = maxBrowsers, OR 4 WITHOUT IT; NEGATIVE = UNLIMITED); EXTRA RUNS WAIT IN THE QUEUE
+	ConcurrencyGroups map[string]int   `json:"concurrencyGroups"` // RUNS AT ONCE PER GROUP (JOBS JOIN WITH THE concurrencyGroup RULE)
+	BlackoutWindows   []BlackoutWindow `json:"blackoutWindows"`   // TIMES WHEN NO NEW RUNS START
+
+	// START RUNS A RESTART INTERRUPTED AGAIN (JOBS CAN OPT IN OR OUT WITH THE resumeOnRestart RULE)
+	ResumeInterruptedJobs bool `json:"resumeInterruptedJobs"`
+
+	// INTERNET ARCHIVE S3 KEYS FOR AUTHENTICATED SAVEPAGENOW SUBMISSIONS (OPTIONAL)
+	WaybackAccessKey string `json:"waybackAccessKey"`
+	WaybackSecretKey string `json:"waybackSecretKey"`
+
+	// DEPLOYMENT
+	BasePath         string   `json:"basePath"`         // SERVE UNDER A URL PREFIX, E.G. /crepes
+	TrustedProxies   []string `json:"trustedProxies"`   // IPS/CIDRS ALLOWED TO SET X-Forwarded-* HEADERS
+	TLSCertFile      string   `json:"tlsCertFile"`      // SERVE HTTPS WITH THIS CERTIFICATE...
+	TLSKeyFile       string   `json:"tlsKeyFile"`       // ...AND KEY
+	AutocertDomains  []string `json:"autocertDomains"`  // OR OBTAIN CERTIFICATES FROM LET'S ENCRYPT FOR THESE HOSTS
+	AutocertEmail    string   `json:"autocertEmail"`    // CONTACT FOR LET'S ENCRYPT
+	AutocertHTTPPort string   `json:"autocertHttpPort"` // PORT FOR ACME HTTP-01 CHALLENGES AND REDIRECTS (DEFAULT 80)
+
+	// BROWSER ACCESS
+	CORSOrigins []string `json:"corsOrigins"` // ORIGINS ALLOWED TO CALL THE API (EMPTY OR "*" = ANY, WITHOUT CREDENTIALS)
+	DisableCSRF bool     `json:"disableCsrf"` // TURN OFF CROSS-ORIGIN CHECKS ON STATE-CHANGING REQUESTS
+
+	// API ACCOUNTS; OFF LEAVES THE API OPEN TO ANYONE WHO CAN REACH IT
+	Auth AuthConfig `json:"auth"`
+
+	// API RATE LIMITING
+	RateLimit RateLimitConfig `json:"rateLimit"`
+
+	// REFUSE TO FETCH PRIVATE, LOOPBACK AND METADATA ADDRESSES FROM JOBS AND TOOLS
+	SSRF SSRFConfig `json:"ssrf"`
+
+	// DOMAINS NO JOB OR TOOL MAY REACH, OR THE ONLY ONES THEY MAY
+	Domains DomainsConfig `json:"domains"`
+
+	// HOW HOST NAMES ARE RESOLVED FOR JOBS AND TOOLS (JOBS CAN OVERRIDE IT WITH THE dns RULE)
+	DNS DNSConfig `json:"dns"`
+
+	// WHICH WAY JOBS AND TOOLS CONNECT OUT (JOBS CAN OVERRIDE IT WITH THE egress RULE)
+	Egress EgressConfig `json:"egress"`
+
+	// MASTER SECRET FOR ENCRYPTING ASSETS AT REST (JOBS OPT IN WITH THE encryptAssets RULE)
+	EncryptionSecret string `json:"encryptionSecret"`
+
+	// WHERE FINISHED ASSET FILES AND THUMBNAILS ARE KEPT: storagePath AND thumbnailsPath, OR A BUCKET
+	Storage StorageConfig `json:"storage"`
+
+	// WHERE ALERTS AND OTHER NOTIFICATIONS ARE SENT (THE UI ALWAYS GETS THEM OVER THE EVENT STREAM)
+	Notifications NotificationConfig `json:"notifications"`
+
+	// DATABASE AND STORAGE UPKEEP, RUN ON A SCHEDULE AND THROUGH /api/admin/maintenance
+	Maintenance MaintenanceConfig `json:"maintenance"`
+
+	// ROTATION AND RETENTION OF THE APPLICATION LOG
+	Log LogConfig `json:"log"`
+}
+
+// RUNS ALLOWED AT ONCE WHEN maxConcurrentJobs IS UNSET AND THERE IS NO BROWSER LIMIT TO FOLLOW
+const defaultMaxConcurrentJobs = 4
+
+// RUNS ALLOWED AT ONCE (0 = NO LIMIT). UNSET, IT FOLLOWS maxBrowsers: A RUN OPENS AT LEAST ONE
+// BROWSER, SO STARTING MORE RUNS THAN THAT ONLY LEAVES THEM WAITING FOR ONE
+func (c *Config) ConcurrentJobLimit() int {
+	switch {
+	case c.MaxConcurrentJobs < 0:
+		return 0
+	case c.MaxConcurrentJobs > 0:
+		return c.MaxConcurrentJobs
+	case c.MaxBrowsers > 0:
+		return c.MaxBrowsers
+	default:
+		return defaultMaxConcurrentJobs
+	}
+}
+
+// WHETHER SERVER CERTIFICATES ARE CHECKED (UNSET MEANS YES)
+func (c *Config) VerifyTLS() bool {
+	return c.TLSVerify == nil || *c.TLSVerify
+}
+
+// ASSET STORAGE, E.G. {"backend": "s3", "s3": {"endpoint": "http://minio:9000", "bucket": "crepes", "pathStyle": true}}
+type StorageConfig struct {
+	Backend string   `json:"backend"` // local (DEFAULT) OR s3
+	S3      S3Config `json:"s3"`
+
+	// HTML, SVG AND XML TYPES SHOWN IN THE BROWSER INSTEAD OF DOWNLOADED, E.G. ["image/svg+xml"]
+	// (STILL SANDBOXED; EMPTY = ALWAYS DOWNLOAD THEM)
+	InlineTypes []string `json:"inlineTypes"`
+}
+
+// AN S3-COMPATIBLE BUCKET (AWS, MINIO, R2, ...). DOWNLOADS STREAM STRAIGHT INTO IT; WHAT HAS TO
+// BE ON DISK FIRST (THUMBNAILS, ASSETS ENCRYPTED AT REST OR DOWNLOADED RESUMABLY, EVERYTHING WITH
+// keepLocal) IS WRITTEN UNDER storagePath AND thumbnailsPath, THEN UPLOADED AND REMOVED LOCALLY
+type S3Config struct {
+	Endpoint        string `json:"endpoint"`        // E.G. http://minio:9000 (EMPTY = AWS IN region)
+	Region          string `json:"region"`          // DEFAULT us-east-1
+	Bucket          string `json:"bucket"`          // REQUIRED
+	Prefix          string `json:"prefix"`          // KEY PREFIX, E.G. crepes/
+	AccessKeyID     string `json:"accessKeyId"`     // OR CREPES_S3_ACCESS_KEY_ID / AWS_ACCESS_KEY_ID
+	SecretAccessKey string `json:"secretAccessKey"` // OR CREPES_S3_SECRET_ACCESS_KEY / AWS_SECRET_ACCESS_KEY
+	SessionToken    string `json:"sessionToken"`    // TEMPORARY CREDENTIALS ONLY (OR AWS_SESSION_TOKEN)
+	PathStyle       bool   `json:"pathStyle"`       // BUCKET IN THE PATH INSTEAD OF THE HOST NAME (MINIO)
+	Serve           string `json:"serve"`           // redirect (DEFAULT) TO A SIGNED URL, OR proxy THROUGH THE SERVER
+	URLExpiry       int    `json:"urlExpiry"`       // SECONDS A SIGNED URL LASTS (0 = 900)
+	KeepLocal       bool   `json:"keepLocal"`       // KEEP THE LOCAL COPIES AFTER UPLOADING
+}
+
+// NOTIFICATION CHANNELS
+type NotificationConfig struct {
+	Webhooks []WebhookChannel `json:"webhooks"`
+}
+
+// HTTP ENDPOINT NOTIFICATIONS ARE POSTED TO, E.G. {"url": "https://hooks.slack.com/...", "format": "slack"}
+type WebhookChannel struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Format string   `json:"format"` // json (DEFAULT, FULL PAYLOAD), slack OR discord (TEXT MESSAGE)
+	Secret string   `json:"secret"` // SIGNS json PAYLOADS WITH HMAC-SHA256 IN X-Crepes-Signature
+	Types  []string `json:"types"`  // NOTIFICATION TYPES TO SEND, EMPTY = ALL
+}
+
+// WHETHER THE CHANNEL WANTS A NOTIFICATION TYPE
+func (c WebhookChannel) Accepts(notificationType string) bool {
+	if len(c.Types) == 0 {
+		return true
+	}
+	for _, t := range c.Types {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+// DAILY WINDOW (LOCAL TIME) DURING WHICH QUEUED RUNS ARE HELD, E.G. {"start": "22:00", "end": "06:00", "days": ["sat", "sun"]}
+type BlackoutWindow struct {
+	Start string   `json:"start"` // HH:MM
+	End   string   `json:"end"`   // HH:MM, EARLIER THAN START FOR WINDOWS CROSSING MIDNIGHT
+	Days  []string `json:"days"`  // DAYS THE WINDOW STARTS ON (mon..sun), EMPTY = EVERY DAY
+}
+
+// WHETHER THE WINDOW STARTS ON THE GIVEN WEEKDAY
+func (b BlackoutWindow) OnDay(day time.Weekday) bool {
+	if len(b.Days) == 0 {
+		return true
+	}
+	name := strings.ToLower(day.String()[:3])
+	for _, d := range b.Days {
+		if strings.HasPrefix(strings.ToLower(d), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MAINTENANCE, E.G. {"schedule": "0 4 * * 0", "tasks": ["analyze", "checkpoint"], "keepRuns": 5}
+type MaintenanceConfig struct {
+	Schedule     string   `json:"schedule"`     // CRON SPEC (DEFAULT "0 4 * * *"), OR "off" TO ONLY RUN ON DEMAND
+	Tasks        []string `json:"tasks"`        // TASKS A SCHEDULED PASS RUNS (EMPTY = ALL)
+	KeepRuns     int      `json:"keepRuns"`     // RUNS PER JOB WHOSE ITEM RESULTS AND TIMELINES ARE KEPT (0 = 20)
+	ErrorLogDays int      `json:"errorLogDays"` // DAYS ACKNOWLEDGED AND RESOLVED ERROR LOGS ARE KEPT (0 = 30)
+}
+
+// USER AGENT UPDATES, E.G. {"url": "https://example.com/crepes/user-agents.json", "publicKey": "<base64 ed25519 key>"}
+type UserAgentUpdateConfig struct {
+	URL            string `json:"url"`            // SIGNED MANIFEST (EMPTY = KEEP THE BUNDLED POOL)
+	PublicKey      string `json:"publicKey"`      // BASE64 ED25519 KEY THE MANIFEST MUST BE SIGNED WITH
+	Schedule       string `json:"schedule"`       // CRON SPEC (DEFAULT "30 3 * * *"), OR "off" TO ONLY CHECK ON DEMAND
+	UpdateBrowsers bool   `json:"updateBrowsers"` // ALSO INSTALL THE CHROMIUM BUILD THE MANIFEST NAMES (USED FROM THE NEXT START)
+}
+
+// THE APPLICATION LOG, crepes.log IN THE DATA DIRECTORY, E.G. {"maxSize": 20, "maxAge": 7}
+type LogConfig struct {
+	MaxSize    int   `json:"maxSize"`    // MB IT GROWS TO BEFORE IT IS ROTATED (0 = 50); IT ALSO ROTATES DAILY
+	MaxAge     int   `json:"maxAge"`     // DAYS ROTATED FILES ARE KEPT (0 = 14)
+	MaxBackups int   `json:"maxBackups"` // ROTATED FILES KEPT (0 = 5)
+	Compress   *bool `json:"compress"`   // GZIP ROTATED FILES (DEFAULT TRUE)
+}
+
+// API AUTHENTICATION, E.G. {"enabled": true, "sessionTtl": "72h", "adminUsername": "admin"}
+type AuthConfig struct {
+	Enabled       bool   `json:"enabled"`
+	SessionTTL    string `json:"sessionTtl"`    // GO DURATION A LOGIN LASTS UNUSED (DEFAULT 168h)
+	AdminUsername string `json:"adminUsername"` // FIRST ADMIN, CREATED AT START WHILE THERE ARE NO USERS (DEFAULT admin)...
+	AdminPassword string `json:"adminPassword"` // ...WITH THIS PASSWORD OR CREPES_ADMIN_PASSWORD; WITHOUT ONE POST /api/auth/setup CREATES IT
+}
+
+// HOW LONG A LOGIN LASTS WITHOUT BEING USED
+func (a AuthConfig) SessionLifetime() time.Duration {
+	if ttl, err := time.ParseDuration(a.SessionTTL); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 7 * 24 * time.Hour
+}
+
+// RATE LIMITS FOR THE HTTP API
+type RateLimitConfig struct {
+	Enabled           bool         `json:"enabled"`
+	RequestsPerMinute int          `json:"requestsPerMinute"` // DEFAULT PER-CLIENT LIMIT
+	Routes            []RouteQuota `json:"routes"`            // ADDITIONAL PER-ROUTE QUOTAS
+}
+
+// SSRF PROTECTION, E.G. {"enabled": true, "block": ["private", "metadata"], "allow": ["10.0.5.0/24", "intranet.local"]}
+type SSRFConfig struct {
+	Enabled bool     `json:"enabled"`
+	Block   []string `json:"block"` // GROUPS (loopback, private, link-local, metadata, unspecified, multicast) OR CIDRS; EMPTY = ALL GROUPS
+	Allow   []string `json:"allow"` // CIDRS, IPS OR HOST NAMES EXEMPT FROM THE BLOCKLIST
+}
+
+// INSTANCE DOMAIN LISTS, E.G. {"block": ["internal.example.com", "*.corp"], "allowOnly": []}. A DOMAIN COVERS ITS SUBDOMAINS
+type DomainsConfig struct {
+	Block     []string `json:"block"`
+	AllowOnly []string `json:"allowOnly"` // WHEN SET, EVERY OTHER DOMAIN IS BLOCKED
+}
+
+// DNS RESOLUTION, E.G. {"doh": "https://1.1.1.1/dns-query", "hosts": {"intranet.example": "10.0.0.5"}}
+type DNSConfig struct {
+	Resolver string            `json:"resolver"` // DNS SERVER (IP OR IP:PORT) INSTEAD OF THE SYSTEM'S
+	DoH      string            `json:"doh"`      // DNS-OVER-HTTPS ENDPOINT; SET THIS OR resolver
+	Hosts    map[string]string `json:"hosts"`    // NAME (OR *.DOMAIN) -> IP
+}
+
+// OUTBOUND CONNECTIONS, E.G. {"ipVersion": "4", "interface": "wg0"}
+type EgressConfig struct {
+	IPVersion   string `json:"ipVersion"`   // 4, 6 OR EMPTY FOR EITHER
+	BindAddress string `json:"bindAddress"` // LOCAL ADDRESS TO CONNECT FROM
+	Interface   string `json:"interface"`   // INTERFACE TO CONNECT THROUGH, E.G. A VPN TUNNEL
+}
+
+// QUOTA FOR MATCHING ROUTES, E.G. {"method": "POST", "path": "/api/jobs/{id}/start", "limit": 10, "window": "1h"}
+type RouteQuota struct {
+	Method string `json:"method"` // EMPTY MATCHES ANY METHOD
+	Path   string `json:"path"`   // ROUTE TEMPLATE OR PATH; A TRAILING * MATCHES BY PREFIX
+	Limit  int    `json:"limit"`
+	Window string `json:"window"` // GO DURATION, DEFAULT 1m
+}
+
+// WHETHER THE QUOTA APPLIES TO A REQUEST
+func (q RouteQuota) Matches(method, template, path string) bool {
+	if q.Method != "" && !strings.EqualFold(q.Method, method) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(q.Path, "*"); ok {
+		return strings.HasPrefix(template, prefix) || strings.HasPrefix(path, prefix)
+	}
+	return q.Path == template || q.Path == path
 }
 
 // LOAD CONFIG FROM FILE
@@ -65,6 +372,11 @@ func GetDefaultConfig() *Config {
 		DataPath:       "./data",
 		MaxConcurrent:  5,
 		DefaultTimeout: 5 * 60 * 1000, // 5 MINUTES IN MS
+
+		MaxDownloadWorkers: 8,
+		MaxConnsPerHost:    2,
+
+		RateLimit: RateLimitConfig{RequestsPerMinute: 300},
 	}
 }
 
@@ -11,10 +11,15 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// WHERE THE DATABASE FILE LIVES IN A DATA DIRECTORY
+func Path(dataPath string) string {
+	return filepath.Join(dataPath, "crepes.db")
+}
+
 // SETUP DATABASE CONNECTION
 func SetupDatabase(dataPath string) (*gorm.DB, error) {
 	// CREATE DB PATH
-	dbPath := filepath.Join(dataPath, "crepes.db")
+	dbPath := Path(dataPath)
 	log.Printf("Using database at: %s", dbPath)
 
 	// OPEN DATABASE CONNECTION
@@ -49,3 +54,30 @@ func EnsureDefaultSettings(db *gorm.DB) {
 		}
 	}
 }
+
+// RECORDS USED TO BE UNIQUE ON (JOB, HASH); GIVE EXISTING ROWS THEIR HASH AS THE KEY AND DROP
+// THE OLD INDEX BEFORE AUTOMIGRATE ADDS THE UNIQUE (JOB, KEY) INDEX
+func PrepareRecordKeys(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.Record{}) || migrator.HasColumn(&models.Record{}, "Key") {
+		return nil
+	}
+	log.Println("Migrating records to keyed upserts...")
+	for _, column := range []string{"Key", "FirstSeen", "LastSeen"} {
+		if err := migrator.AddColumn(&models.Record{}, column); err != nil {
+			return err
+		}
+	}
+	err := db.Model(&models.Record{}).Where("1 = 1").Updates(map[string]any{
+		"key":        gorm.Expr("hash"),
+		"first_seen": gorm.Expr("created_at"),
+		"last_seen":  gorm.Expr("created_at"),
+	}).Error
+	if err != nil {
+		return err
+	}
+	if migrator.HasIndex(&models.Record{}, "idx_records_job_hash") {
+		return migrator.DropIndex(&models.Record{}, "idx_records_job_hash")
+	}
+	return nil
+}
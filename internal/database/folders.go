@@ -0,0 +1,66 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"gorm.io/gorm"
+)
+
+// A FOLDER AND ALL OF ITS DESCENDANTS
+func FolderTree(db *gorm.DB, rootID string) ([]string, error) {
+	var folders []models.Folder
+	if err := db.Select("id", "parent_id").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	children := make(map[string][]string)
+	for _, folder := range folders {
+		children[folder.ParentID] = append(children[folder.ParentID], folder.ID)
+	}
+
+	ids := []string{rootID}
+	seen := map[string]bool{rootID: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// IDS OF THE JOBS IN A FOLDER, OPTIONALLY INCLUDING SUBFOLDERS
+func FolderJobIDs(db *gorm.DB, folderID string, recursive bool) ([]string, error) {
+	folderIDs := []string{folderID}
+	if recursive {
+		tree, err := FolderTree(db, folderID)
+		if err != nil {
+			return nil, err
+		}
+		folderIDs = tree
+	}
+	var jobIDs []string
+	err := db.Model(&models.Job{}).Where("folder_id IN ?", folderIDs).Pluck("id", &jobIDs).Error
+	return jobIDs, err
+}
+
+// A FOLDER FOLLOWED BY ITS PARENTS UP TO THE TOP LEVEL, NEAREST FIRST
+func FolderAncestors(db *gorm.DB, folderID string) ([]models.Folder, error) {
+	var chain []models.Folder
+	seen := make(map[string]bool)
+	for id := folderID; id != "" && !seen[id]; {
+		seen[id] = true
+		var folder models.Folder
+		if err := db.First(&folder, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			return chain, err
+		}
+		chain = append(chain, folder)
+		id = folder.ParentID
+	}
+	return chain, nil
+}
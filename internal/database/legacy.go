@@ -0,0 +1,438 @@
+package database
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// -- LEGACY DATA --
+//
+// BEFORE THE DATABASE, CREPES KEPT JOBS IN jobs.json IN THE DATA DIRECTORY (ASSETS INLINE OR IN
+// AN assets.json NEXT TO IT) WITH ABSOLUTE FILE PATHS, AND EARLY DATABASES HAD AN author COLUMN
+// ON ASSETS. BOTH ARE BROUGHT ONTO THE ONE jobs/assets SCHEMA HERE: THE FILES ARE IMPORTED ONCE
+// AND RENAMED TO *.imported, AND OLD ROWS ARE REWRITTEN IN PLACE. NOTHING IS DELETED: A FILE
+// THAT CAN'T BE READ IS LEFT WHERE IT IS, AND THE author COLUMN STAYS (IT'S NO LONGER READ).
+// THE SHAPES ACCEPTED ARE THE ONES IN testdata/legacy.
+
+// WHAT AN IMPORT OF THE LEGACY FILES DID
+type LegacyImport struct {
+	Files     []string // FILES READ (AND RENAMED)
+	Jobs      int
+	Assets    int
+	Skipped   int // ALREADY IN THE DATABASE
+	Relocated int // ASSET ROWS WHOSE ABSOLUTE PATHS WERE MADE RELATIVE TO STORAGE
+}
+
+// LEGACY FILE NAMES IN THE DATA DIRECTORY
+var legacyFiles = []string{"jobs.json", "assets.json"}
+
+type legacyJob struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	BaseURL     string            `json:"baseUrl"`
+	Description string            `json:"description"`
+	Status      string            `json:"status"`
+	Schedule    string            `json:"schedule"`
+	Selectors   models.JSONArray  `json:"selectors"`
+	Filters     models.JSONArray  `json:"filters"`
+	Rules       models.JSONMap    `json:"rules"`
+	Processing  models.JSONMap    `json:"processing"`
+	Tags        models.JSONArray  `json:"tags"`
+	Pipeline    json.RawMessage   `json:"pipeline"` // A STRING, OR THE STAGES THEMSELVES
+	LastRun     legacyTime        `json:"lastRun"`
+	NextRun     legacyTime        `json:"nextRun"`
+	CreatedAt   legacyTime        `json:"createdAt"`
+	Assets      []json.RawMessage `json:"assets"` // WHOLE ASSETS, OR JUST THEIR IDS
+}
+
+type legacyAsset struct {
+	ID            string         `json:"id"`
+	JobID         string         `json:"jobId"`
+	URL           string         `json:"url"`
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Description   string         `json:"description"`
+	Author        string         `json:"author"`
+	LocalPath     string         `json:"localPath"`
+	ThumbnailPath string         `json:"thumbnailPath"`
+	Size          int64          `json:"size"`
+	Date          legacyTime     `json:"date"`
+	Metadata      models.JSONMap `json:"metadata"`
+}
+
+// A TIME WRITTEN BY ANY OLD VERSION: RFC 3339, A PLAIN DATE(TIME), UNIX SECONDS OR NULL. Raw
+// KEEPS A STRING THAT DIDN'T PARSE
+type legacyTime struct {
+	Time time.Time
+	Raw  string
+}
+
+var legacyTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+func (t *legacyTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		if n, err := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64); err == nil {
+			t.Time = time.Unix(n, 0)
+		}
+		return nil
+	}
+	for _, layout := range legacyTimeLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	t.Raw = s
+	return nil
+}
+
+// IMPORT jobs.json AND assets.json FROM THE DATA DIRECTORY (A LIST, OR AN OBJECT KEYED BY ID)
+// AND MAKE ABSOLUTE ASSET PATHS RELATIVE TO STORAGE. ROWS THAT ALREADY EXIST ARE LEFT ALONE, SO
+// AN IMPORT THAT FAILED HALFWAY CAN SIMPLY RUN AGAIN
+func ImportLegacyData(db *gorm.DB, dataPath, storagePath, thumbnailsPath string) (LegacyImport, error) {
+	var result LegacyImport
+	relocated, err := relocateAssetPaths(db, storagePath)
+	if err != nil {
+		return result, err
+	}
+	result.Relocated = relocated
+
+	var jobs []legacyJob
+	var assets []legacyAsset
+	for _, name := range legacyFiles {
+		path := filepath.Join(dataPath, name)
+		fileJobs, fileAssets, err := readLegacyEntries(path, name == "jobs.json")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		// ONE BAD FILE DOESN'T STOP THE OTHER, AND IS KEPT TO BE FIXED BY HAND AND IMPORTED NEXT START
+		if err != nil {
+			log.Printf("WARNING: SKIPPING LEGACY FILE %s: %v", path, err)
+			continue
+		}
+		result.Files = append(result.Files, path)
+		jobs = append(jobs, fileJobs...)
+		assets = append(assets, fileAssets...)
+	}
+	if len(result.Files) == 0 {
+		return result, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, legacy := range jobs {
+			// INLINE ASSETS BELONG TO THEIR JOB; BARE IDS POINT INTO assets.json
+			for _, raw := range legacy.Assets {
+				var asset legacyAsset
+				if json.Unmarshal(raw, &asset) != nil {
+					continue
+				}
+				asset.JobID = cmp.Or(asset.JobID, legacy.ID)
+				assets = append(assets, asset)
+			}
+			if exists(tx, &models.Job{}, legacy.ID) {
+				result.Skipped++
+				continue
+			}
+			job := legacy.job()
+			if err := tx.Create(&job).Error; err != nil {
+				return fmt.Errorf("IMPORTING JOB %s: %w", job.ID, err)
+			}
+			result.Jobs++
+		}
+		seen := make(map[string]bool)
+		for _, legacy := range assets {
+			if legacy.ID != "" && (seen[legacy.ID] || exists(tx, &models.Asset{}, legacy.ID)) {
+				result.Skipped++
+				continue
+			}
+			asset := legacy.asset(storagePath, thumbnailsPath)
+			if err := tx.Create(&asset).Error; err != nil {
+				return fmt.Errorf("IMPORTING ASSET %s: %w", asset.ID, err)
+			}
+			seen[asset.ID] = true
+			result.Assets++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// ONLY ONCE EVERYTHING IS IN; THE RENAMED FILES STAY AS A BACKUP
+	for _, path := range result.Files {
+		if err := os.Rename(path, path+".imported"); err != nil {
+			log.Printf("WARNING: IMPORTED %s BUT COULDN'T RENAME IT: %v", path, err)
+		}
+	}
+	return result, nil
+}
+
+// BRING ROWS WRITTEN BY OLDER SCHEMAS IN LINE, AFTER AUTOMIGRATE HAS ADDED THE NEW COLUMNS:
+// AN author COLUMN IS COPIED INTO METADATA (THE COLUMN ITSELF IS KEPT), AND TIMES LEFT NULL BY
+// ADDED COLUMNS ARE FILLED (A NULL TIME CAN'T BE READ BACK)
+func UpgradeLegacyRows(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if migrator.HasColumn(&models.Asset{}, "author") {
+		log.Println("Moving asset authors into metadata...")
+		var rows []struct {
+			ID       string
+			Author   string
+			Metadata models.JSONMap `gorm:"type:text"`
+		}
+		if err := db.Table("assets").Select("id", "author", "metadata").Where("author IS NOT NULL AND author != ''").Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if row.Metadata == nil {
+				row.Metadata = make(models.JSONMap)
+			}
+			// ALREADY COPIED ON AN EARLIER START, OR SET SINCE
+			if _, ok := row.Metadata["author"]; ok {
+				continue
+			}
+			row.Metadata["author"] = row.Author
+			if err := db.Model(&models.Asset{}).Where("id = ?", row.ID).UpdateColumn("metadata", row.Metadata).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	now := time.Now()
+	fills := []struct {
+		model  any
+		column string
+		value  any
+	}{
+		{&models.Job{}, "created_at", now},
+		{&models.Job{}, "updated_at", gorm.Expr("created_at")},
+		{&models.Job{}, "last_run", time.Time{}},
+		{&models.Job{}, "next_run", time.Time{}},
+		{&models.Asset{}, "created_at", now},
+		{&models.Asset{}, "updated_at", gorm.Expr("created_at")},
+		{&models.Asset{}, "date", gorm.Expr("created_at")},
+	}
+	for _, fill := range fills {
+		if err := db.Model(fill.model).Where(fill.column+" IS NULL").UpdateColumn(fill.column, fill.value).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OLD VERSIONS STORED ABSOLUTE PATHS; SERVED FILES ARE NOW RESOLVED RELATIVE TO STORAGE. PATHS
+// OUTSIDE STORAGE ARE LEFT AS THEY ARE (THEY WON'T BE SERVED, BUT THE ROW IS KEPT)
+func relocateAssetPaths(db *gorm.DB, storagePath string) (int, error) {
+	var rows []struct {
+		ID        string
+		LocalPath string
+	}
+	if err := db.Model(&models.Asset{}).Select("id", "local_path").Where("local_path LIKE ?", "/%").Find(&rows).Error; err != nil {
+		return 0, err
+	}
+	relocated := 0
+	for _, row := range rows {
+		rel, err := utils.RelativeToRoot(storagePath, row.LocalPath)
+		if err != nil {
+			log.Printf("WARNING: ASSET %s IS OUTSIDE STORAGE (%s), LEAVING ITS PATH", row.ID, row.LocalPath)
+			continue
+		}
+		if err := db.Model(&models.Asset{}).Where("id = ?", row.ID).UpdateColumn("local_path", rel).Error; err != nil {
+			return relocated, err
+		}
+		relocated++
+	}
+	return relocated, nil
+}
+
+// ONE ENTRY OF A LEGACY FILE; Key IS ITS ID WHEN THE FILE IS AN OBJECT KEYED BY ID
+type legacyEntry struct {
+	Key string
+	Raw json.RawMessage
+}
+
+// THE JOBS (OR ASSETS) IN ONE LEGACY FILE; AN ENTRY THAT DOESN'T PARSE FAILS THE WHOLE FILE
+func readLegacyEntries(path string, isJobs bool) ([]legacyJob, []legacyAsset, error) {
+	entries, err := readLegacyFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var jobs []legacyJob
+	var assets []legacyAsset
+	for i, entry := range entries {
+		if isJobs {
+			var job legacyJob
+			if err := json.Unmarshal(entry.Raw, &job); err != nil {
+				return nil, nil, fmt.Errorf("JOB %d: %w", i, err)
+			}
+			job.ID = cmp.Or(job.ID, entry.Key, utils.GenerateID("job"))
+			jobs = append(jobs, job)
+			continue
+		}
+		var asset legacyAsset
+		if err := json.Unmarshal(entry.Raw, &asset); err != nil {
+			return nil, nil, fmt.Errorf("ASSET %d: %w", i, err)
+		}
+		asset.ID = cmp.Or(asset.ID, entry.Key)
+		assets = append(assets, asset)
+	}
+	return jobs, assets, nil
+}
+
+// THE ENTRIES OF A LEGACY FILE, IN FILE ORDER FOR A LIST AND BY ID FOR AN OBJECT
+func readLegacyFile(path string) ([]legacyEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err == nil {
+		entries := make([]legacyEntry, len(list))
+		for i, raw := range list {
+			entries[i] = legacyEntry{Raw: raw}
+		}
+		return entries, nil
+	}
+	var keyed map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keyed); err != nil {
+		return nil, errors.New("NOT A LIST OR OBJECT OF ENTRIES")
+	}
+	entries := make([]legacyEntry, 0, len(keyed))
+	for key, raw := range keyed {
+		entries = append(entries, legacyEntry{Key: key, Raw: raw})
+	}
+	slices.SortFunc(entries, func(a, b legacyEntry) int { return strings.Compare(a.Key, b.Key) })
+	return entries, nil
+}
+
+func (legacy legacyJob) job() models.Job {
+	now := time.Now()
+	job := models.Job{
+		ID:          legacy.ID,
+		Name:        legacy.Name,
+		BaseURL:     legacy.BaseURL,
+		Description: legacy.Description,
+		Status:      legacy.Status,
+		Schedule:    legacy.Schedule,
+		Selectors:   legacy.Selectors,
+		Filters:     legacy.Filters,
+		Rules:       legacy.Rules,
+		Processing:  legacy.Processing,
+		Tags:        legacy.Tags,
+		LastRun:     legacy.LastRun.Time,
+		NextRun:     legacy.NextRun.Time,
+		CreatedAt:   legacy.CreatedAt.Time,
+		UpdatedAt:   now,
+	}
+	if job.Name == "" {
+		job.Name = job.ID
+		if u, err := url.Parse(job.BaseURL); err == nil && u.Host != "" {
+			job.Name = u.Host
+		}
+	}
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = now
+	}
+	// NOTHING FROM THE OLD PROCESS IS STILL RUNNING
+	if job.Status == "running" || job.Status == "" {
+		job.Status = "idle"
+	}
+	var pipeline string
+	if json.Unmarshal(legacy.Pipeline, &pipeline) == nil {
+		job.Pipeline = pipeline
+	} else if len(legacy.Pipeline) > 0 && string(legacy.Pipeline) != "null" {
+		job.Pipeline = string(legacy.Pipeline)
+	}
+	return job
+}
+
+func (legacy legacyAsset) asset(storagePath, thumbnailsPath string) models.Asset {
+	now := time.Now()
+	asset := models.Asset{
+		ID:          legacy.ID,
+		JobID:       legacy.JobID,
+		URL:         legacy.URL,
+		Type:        legacy.Type,
+		Title:       legacy.Title,
+		Description: legacy.Description,
+		Size:        legacy.Size,
+		Date:        legacy.Date.Time,
+		Metadata:    legacy.Metadata,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if asset.ID == "" {
+		asset.ID = fmt.Sprintf("asset_%s", utils.GenerateID(""))
+	}
+	if asset.Metadata == nil {
+		asset.Metadata = make(models.JSONMap)
+	}
+	if legacy.Author != "" {
+		asset.Metadata["author"] = legacy.Author
+	}
+	if legacy.Date.Raw != "" {
+		asset.Metadata["date"] = legacy.Date.Raw
+	}
+	if asset.Date.IsZero() {
+		asset.Date = now
+	}
+
+	// PATHS WERE ABSOLUTE OR RELATIVE TO THE OLD WORKING DIRECTORY
+	asset.LocalPath = legacy.LocalPath
+	if rel, err := utils.RelativeToRoot(storagePath, legacy.LocalPath); err == nil && legacy.LocalPath != "" {
+		asset.LocalPath = rel
+	}
+	if full, err := utils.ConfinePath(storagePath, asset.LocalPath); err == nil && asset.LocalPath != "" && asset.Size == 0 {
+		if info, err := os.Stat(full); err == nil {
+			asset.Size = info.Size()
+		}
+	}
+	if asset.Type == "" {
+		asset.Type = legacyAssetType(asset.LocalPath)
+	}
+
+	// THUMBNAILS ARE NAMED IN THE THUMBNAILS DIRECTORY; ONE THAT'S GONE IS LEFT FOR REGENERATION
+	if legacy.ThumbnailPath != "" {
+		name := filepath.Base(legacy.ThumbnailPath)
+		if _, err := os.Stat(filepath.Join(thumbnailsPath, name)); err == nil {
+			asset.ThumbnailPath = name
+		}
+	}
+	return asset
+}
+
+// ASSET TYPE FROM A FILE EXTENSION, FOR ASSETS SAVED WITHOUT ONE
+func legacyAssetType(path string) string {
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	for _, kind := range []string{"image", "video", "audio"} {
+		if strings.HasPrefix(contentType, kind+"/") {
+			return kind
+		}
+	}
+	if strings.HasPrefix(contentType, "text/") || strings.HasPrefix(contentType, "application/") {
+		return "document"
+	}
+	return "unknown"
+}
+
+func exists(db *gorm.DB, model any, id string) bool {
+	var count int64
+	db.Model(model).Where("id = ?", id).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "crepes.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Job{}, &models.Asset{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// A DATA DIRECTORY HOLDING ONE OF THE testdata/legacy FIXTURES, AND A STORAGE DIRECTORY BESIDE IT
+// WITH THE WORKING DIRECTORY SET TO THEIR PARENT, AS AN OLD INSTALL RAN
+func legacyInstall(t *testing.T, fixture string) (dataPath, storagePath string) {
+	t.Helper()
+	src, err := filepath.Abs(filepath.Join("testdata", "legacy", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	t.Chdir(dir)
+	dataPath = filepath.Join(dir, "data")
+	storagePath = filepath.Join(dir, "storage")
+	for _, path := range []string{dataPath, filepath.Join(storagePath, "job_1")} {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range legacyFiles {
+		data, err := os.ReadFile(filepath.Join(src, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dataPath, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dataPath, storagePath
+}
+
+func TestImportLegacyList(t *testing.T) {
+	db := openTestDB(t)
+	dataPath, storagePath := legacyInstall(t, "list")
+	if err := os.WriteFile(filepath.Join(storagePath, "job_1", "a.jpg"), []byte("jpeg"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ImportLegacyData(db, dataPath, storagePath, filepath.Join(dataPath, "thumbnails"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Jobs != 2 || result.Assets != 2 || len(result.Files) != 2 {
+		t.Fatalf("imported %+v; want 2 jobs and 2 assets from 2 files", result)
+	}
+
+	var job models.Job
+	if err := db.First(&job, "id = ?", "job_1").Error; err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "idle" || job.CreatedAt.IsZero() || job.LastRun.IsZero() || len(job.Selectors) != 1 {
+		t.Errorf("job_1 = %+v", job)
+	}
+	var unnamed models.Job
+	if err := db.First(&unnamed, "base_url = ?", "https://example.org/").Error; err != nil {
+		t.Fatal(err)
+	}
+	if unnamed.ID == "" || unnamed.Name != "example.org" || unnamed.Pipeline == "" {
+		t.Errorf("job without an id = %+v", unnamed)
+	}
+
+	// INLINE, WITH A PATH FROM THE OLD WORKING DIRECTORY
+	var inline models.Asset
+	if err := db.First(&inline, "id = ?", "asset_1").Error; err != nil {
+		t.Fatal(err)
+	}
+	if inline.JobID != "job_1" || inline.LocalPath != filepath.Join("job_1", "a.jpg") || inline.Size != 4 ||
+		inline.Type != "image" || inline.Metadata["author"] != "Alice" || inline.Date.Unix() != 1680343200 {
+		t.Errorf("inline asset = %+v", inline)
+	}
+	// BY ID, FROM assets.json
+	var byID models.Asset
+	if err := db.First(&byID, "id = ?", "asset_2").Error; err != nil {
+		t.Fatal(err)
+	}
+	if byID.JobID != "job_1" || byID.Type != "video" || byID.Metadata["date"] != "last tuesday" {
+		t.Errorf("asset by id = %+v", byID)
+	}
+
+	for _, name := range legacyFiles {
+		if _, err := os.Stat(filepath.Join(dataPath, name+".imported")); err != nil {
+			t.Errorf("%s wasn't renamed: %v", name, err)
+		}
+	}
+}
+
+func TestImportLegacyKeyed(t *testing.T) {
+	db := openTestDB(t)
+	dataPath, storagePath := legacyInstall(t, "keyed")
+
+	result, err := ImportLegacyData(db, dataPath, storagePath, filepath.Join(dataPath, "thumbnails"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Jobs != 2 || result.Assets != 1 {
+		t.Fatalf("imported %+v; want 2 jobs and 1 asset", result)
+	}
+	var job models.Job
+	if err := db.First(&job, "id = ?", "job_2").Error; err != nil {
+		t.Fatal(err)
+	}
+	if job.Name != "Keyed" || job.Status != "completed" {
+		t.Errorf("job_2 = %+v", job)
+	}
+	var asset models.Asset
+	if err := db.First(&asset, "id = ?", "asset_3").Error; err != nil {
+		t.Fatal(err)
+	}
+	if asset.JobID != "job_2" || asset.Type != "document" {
+		t.Errorf("asset_3 = %+v", asset)
+	}
+}
+
+func TestImportLegacySkipsMalformedFile(t *testing.T) {
+	db := openTestDB(t)
+	dataPath, storagePath := legacyInstall(t, "malformed")
+
+	result, err := ImportLegacyData(db, dataPath, storagePath, filepath.Join(dataPath, "thumbnails"))
+	if err != nil {
+		t.Fatalf("a malformed file failed the import: %v", err)
+	}
+	if result.Jobs != 0 || result.Assets != 1 {
+		t.Fatalf("imported %+v; want only the asset from the readable file", result)
+	}
+	// THE BAD FILE STAYS FOR THE NEXT START; THE GOOD ONE IS DONE
+	if _, err := os.Stat(filepath.Join(dataPath, "jobs.json")); err != nil {
+		t.Errorf("malformed jobs.json was moved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataPath, "assets.json.imported")); err != nil {
+		t.Errorf("assets.json wasn't renamed: %v", err)
+	}
+
+	// RUNNING AGAIN DOESN'T DUPLICATE ANYTHING
+	if _, err := ImportLegacyData(db, dataPath, storagePath, filepath.Join(dataPath, "thumbnails")); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&models.Asset{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d assets after a second import; want 1", count)
+	}
+}
+
+func TestUpgradeLegacyRowsKeepsAuthorColumn(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec("ALTER TABLE assets ADD COLUMN author text").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO assets (id, job_id, author) VALUES ('asset_1', 'job_1', 'Alice')").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := UpgradeLegacyRows(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !db.Migrator().HasColumn(&models.Asset{}, "author") {
+		t.Error("the author column was dropped")
+	}
+	var asset models.Asset
+	if err := db.First(&asset, "id = ?", "asset_1").Error; err != nil {
+		t.Fatal(err)
+	}
+	if asset.Metadata["author"] != "Alice" || asset.CreatedAt.IsZero() {
+		t.Errorf("upgraded asset = %+v", asset)
+	}
+}
@@ -0,0 +1,152 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// -- GOLDEN OUTPUTS --
+//
+// WHAT A PIPELINE PRODUCED FROM ITS FIXTURES, SAVED ONCE AS golden.json AND COMPARED AGAINST
+// EVERY LATER RUN. RECORDS AND ASSETS ARE COMPARED AS SETS, SO THE ORDER WORK FINISHED IN
+// DOESN'T MATTER; FIELDS THAT CHANGE FROM RUN TO RUN (TIMESTAMPS) CAN BE LISTED IN ignore.
+
+// A RECORD AS IT IS COMPARED
+type GoldenRecord struct {
+	Source string         `json:"source"`
+	URL    string         `json:"url,omitempty"`
+	Data   map[string]any `json:"data"`
+}
+
+// AN ASSET AS IT IS COMPARED
+type GoldenAsset struct {
+	URL  string `json:"url"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+}
+
+// A RUN'S OUTPUTS
+type Golden struct {
+	Status  string         `json:"status"`
+	Records []GoldenRecord `json:"records"`
+	Assets  []GoldenAsset  `json:"assets"`
+	Ignore  []string       `json:"ignore,omitempty"` // RECORD DATA FIELDS LEFT OUT OF THE COMPARISON
+}
+
+func LoadGolden(path string) (*Golden, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var golden Golden
+	if err := json.Unmarshal(data, &golden); err != nil {
+		return nil, fmt.Errorf("%s IS NOT A GOLDEN FILE: %v", path, err)
+	}
+	return &golden, nil
+}
+
+// WRITE THE OUTPUTS SORTED, SO A CHANGED GOLDEN FILE DIFFS CLEANLY
+func (g *Golden) Save(path string) error {
+	g.sort()
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (g *Golden) sort() {
+	sort.SliceStable(g.Records, func(i, j int) bool {
+		return g.Records[i].canonical(nil) < g.Records[j].canonical(nil)
+	})
+	sort.SliceStable(g.Assets, func(i, j int) bool {
+		if g.Assets[i].URL != g.Assets[j].URL {
+			return g.Assets[i].URL < g.Assets[j].URL
+		}
+		return g.Assets[i].Type < g.Assets[j].Type
+	})
+}
+
+// THE RECORD AS ONE LINE OF JSON WITH SORTED KEYS, WITHOUT THE IGNORED FIELDS
+func (r GoldenRecord) canonical(ignore []string) string {
+	data := make(map[string]any, len(r.Data))
+	for k, v := range r.Data {
+		data[k] = v
+	}
+	for _, field := range ignore {
+		delete(data, field)
+	}
+	// encoding/json SORTS MAP KEYS
+	encoded, _ := json.Marshal(GoldenRecord{Source: r.Source, URL: r.URL, Data: data})
+	return string(encoded)
+}
+
+func (a GoldenAsset) canonical() string {
+	return fmt.Sprintf("%s %s (%d bytes)", a.Type, a.URL, a.Size)
+}
+
+// HOW A RUN DIFFERS FROM THE GOLDEN OUTPUTS (EMPTY WHEN IT MATCHES). THE GOLDEN FILE'S ignore
+// LIST APPLIES TO BOTH SIDES
+func (g *Golden) Compare(got *Golden) []string {
+	var diffs []string
+	if got.Status != g.Status {
+		diffs = append(diffs, fmt.Sprintf("status: want %q, got %q", g.Status, got.Status))
+	}
+	want := make([]string, len(g.Records))
+	for i, r := range g.Records {
+		want[i] = r.canonical(g.Ignore)
+	}
+	have := make([]string, len(got.Records))
+	for i, r := range got.Records {
+		have[i] = r.canonical(g.Ignore)
+	}
+	diffs = append(diffs, diffSets("record", want, have)...)
+
+	want = make([]string, len(g.Assets))
+	for i, a := range g.Assets {
+		want[i] = a.canonical()
+	}
+	have = make([]string, len(got.Assets))
+	for i, a := range got.Assets {
+		have[i] = a.canonical()
+	}
+	return append(diffs, diffSets("asset", want, have)...)
+}
+
+// LINES FOR ITEMS MISSING FROM have (-) OR UNEXPECTED IN IT (+), COUNTING DUPLICATES
+func diffSets(kind string, want, have []string) []string {
+	counts := make(map[string]int)
+	for _, item := range want {
+		counts[item]++
+	}
+	for _, item := range have {
+		counts[item]--
+	}
+	items := make([]string, 0, len(counts))
+	for item := range counts {
+		items = append(items, item)
+	}
+	sort.Strings(items)
+	var diffs []string
+	for _, item := range items {
+		n := counts[item]
+		sign := "-"
+		if n < 0 {
+			sign, n = "+", -n
+		}
+		for ; n > 0; n-- {
+			diffs = append(diffs, fmt.Sprintf("%s %s %s", sign, kind, truncate(item, 300)))
+		}
+	}
+	return diffs
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.TrimSpace(s[:n]) + "..."
+}
@@ -0,0 +1,493 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// -- FIXTURE SITES --
+//
+// A FIXTURE SITE ANSWERS REQUESTS FROM RECORDED RESPONSES INSTEAD OF THE NETWORK, SO A PIPELINE
+// CAN BE RUN AGAINST THE SAME PAGES EVERY TIME. RESPONSES COME FROM HAR FILES (THE recordHar RULE
+// WRITES THEM, AND BROWSER DEVTOOLS EXPORT THEM) AND FROM HTML SNAPSHOTS LAID OUT AS
+// <HOST>/<PATH> UNDER A DIRECTORY. A SITE IS AN http.Handler, SERVED BY AN httptest SERVER WITH
+// Serve, AND AN http.RoundTripper FOR ANSWERING A RUN'S REQUESTS IN-PROCESS. SaveHAR WRITES A
+// SITE BACK OUT, WHICH IS HOW A JOB'S RECORDED CASSETTE IS STORED.
+
+// HEADERS THAT DESCRIBED THE RECORDED TRANSFER RATHER THAN THE CONTENT (BODIES ARE STORED DECODED)
+var transferHeaders = []string{"Content-Encoding", "Content-Length", "Transfer-Encoding", "Connection", "Keep-Alive"}
+
+// A RECORDED RESPONSE
+type Response struct {
+	Method string
+	URL    string
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// RECORDED RESPONSES BY REQUEST
+type Site struct {
+	Strict bool // ONLY ANSWER EXACT MATCHES, E.G. WHEN ?page=2 MUST NOT GET PAGE 1'S RESPONSE
+
+	mu        sync.Mutex
+	responses map[string]*Response // BY requestKey
+	order     []string             // requestKeys IN THE ORDER THEY WERE FIRST RECORDED
+	loose     map[string]*Response // BY requestKey WITHOUT THE QUERY, FIRST RECORDED WINS
+	misses    map[string]int       // REQUESTS WITH NO RECORDING
+	hits      int
+}
+
+func NewSite() *Site {
+	return &Site{
+		responses: make(map[string]*Response),
+		loose:     make(map[string]*Response),
+		misses:    make(map[string]int),
+	}
+}
+
+// LOAD EVERY HAR FILE IN dir AND THE SNAPSHOTS IN ITS HOST DIRECTORIES
+func LoadSite(dir string) (*Site, error) {
+	site := NewSite()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch {
+		case entry.IsDir():
+			if err := site.LoadSnapshots(path, entry.Name()); err != nil {
+				return nil, err
+			}
+		case strings.EqualFold(filepath.Ext(entry.Name()), ".har"):
+			if err := site.LoadHAR(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return site, nil
+}
+
+// KEY A REQUEST IS RECORDED UNDER: METHOD, HOST (WITHOUT A DEFAULT PORT), PATH AND SORTED QUERY.
+// THE SCHEME IS LEFT OUT SO A PAGE RECORDED OVER https ALSO ANSWERS http
+func requestKey(method string, u *url.URL, withQuery bool) string {
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	key := strings.ToUpper(method) + " " + host + path
+	if withQuery && u.RawQuery != "" {
+		key += "?" + u.Query().Encode()
+	}
+	return key
+}
+
+// RECORD A RESPONSE, REPLACING ANY EARLIER ONE FOR THE SAME REQUEST
+func (s *Site) Add(r Response) error {
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("FIXTURE URL %q IS NOT ABSOLUTE", r.URL)
+	}
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	for _, name := range transferHeaders {
+		r.Header.Del(name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := requestKey(r.Method, u, true)
+	if _, ok := s.responses[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.responses[key] = &r
+	if loose := requestKey(r.Method, u, false); s.loose[loose] == nil {
+		s.loose[loose] = &r
+	}
+	return nil
+}
+
+// NUMBER OF RECORDED RESPONSES
+func (s *Site) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.responses)
+}
+
+// THE RECORDING FOR A REQUEST: AN EXACT MATCH, THEN THE SAME PATH WITH ANY QUERY. HEAD IS
+// ANSWERED FROM GET
+func (s *Site) Lookup(method string, u *url.URL) (*Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	methods := []string{method}
+	if strings.EqualFold(method, http.MethodHead) {
+		methods = append(methods, http.MethodGet)
+	}
+	for _, m := range methods {
+		if r, ok := s.responses[requestKey(m, u, true)]; ok {
+			s.hits++
+			return r, true
+		}
+		if r, ok := s.loose[requestKey(m, u, false)]; ok && !s.Strict {
+			s.hits++
+			return r, true
+		}
+	}
+	s.misses[requestKey(method, u, true)]++
+	return nil, false
+}
+
+// REQUESTS ANSWERED FROM RECORDINGS
+func (s *Site) Hits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+// REQUESTS THAT HAD NO RECORDING, SORTED
+func (s *Site) Misses() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	misses := make([]string, 0, len(s.misses))
+	for key := range s.misses {
+		misses = append(misses, key)
+	}
+	sort.Strings(misses)
+	return misses
+}
+
+// SERVE A RECORDING. PROXY-STYLE REQUESTS CARRY THE FULL URL; OTHERWISE THE Host HEADER NAMES THE SITE
+func (s *Site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := *r.URL
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	recorded, ok := s.Lookup(r.Method, &u)
+	if !ok {
+		http.Error(w, "no fixture for "+r.Method+" "+u.String(), http.StatusNotFound)
+		return
+	}
+	for name, values := range recorded.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(recorded.Body)))
+	w.WriteHeader(recorded.Status)
+	if r.Method != http.MethodHead {
+		w.Write(recorded.Body)
+	}
+}
+
+// ANSWER A REQUEST IN-PROCESS WITHOUT TOUCHING THE NETWORK
+func (s *Site) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// THE RECORDING FOR A REQUEST AS A RESPONSE TO IT, OR FALSE WHEN THERE IS NONE
+func (s *Site) Replay(req *http.Request) (*http.Response, bool) {
+	recorded, ok := s.Lookup(req.Method, req.URL)
+	if !ok {
+		return nil, false
+	}
+	body := recorded.Body
+	if req.Method == http.MethodHead {
+		body = nil
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, true
+}
+
+// SERVE THE SITE FROM A LOOPBACK httptest SERVER (CLOSE IT WHEN DONE)
+func Serve(site *Site) *httptest.Server {
+	return httptest.NewServer(site)
+}
+
+// HAR 1.2, AS MUCH OF IT AS A REPLAY NEEDS
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method string `json:"method"`
+				URL    string `json:"url"`
+			} `json:"request"`
+			Response struct {
+				Status  int `json:"status"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				Content struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+					File     string `json:"_file"` // PLAYWRIGHT'S ATTACHED CONTENT, NEXT TO THE HAR
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// ADD THE RESPONSES OF A HAR FILE. ENTRIES THAT NEVER GOT A RESPONSE ARE SKIPPED
+func (s *Site) LoadHAR(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return fmt.Errorf("%s IS NOT A HAR FILE: %v", filepath.Base(path), err)
+	}
+	for _, entry := range har.Log.Entries {
+		if entry.Response.Status <= 0 {
+			continue
+		}
+		content := entry.Response.Content
+		var body []byte
+		switch {
+		case content.File != "":
+			body, err = os.ReadFile(filepath.Join(filepath.Dir(path), filepath.Clean(content.File)))
+		case content.Encoding == "base64":
+			body, err = base64.StdEncoding.DecodeString(content.Text)
+		default:
+			body = []byte(content.Text)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: BODY OF %s: %v", filepath.Base(path), entry.Request.URL, err)
+		}
+		header := http.Header{}
+		for _, h := range entry.Response.Headers {
+			// HTTP/2 PSEUDO-HEADERS AREN'T REAL HEADERS
+			if !strings.HasPrefix(h.Name, ":") {
+				header.Add(h.Name, h.Value)
+			}
+		}
+		if header.Get("Content-Type") == "" && content.MimeType != "" {
+			header.Set("Content-Type", content.MimeType)
+		}
+		if err := s.Add(Response{Method: entry.Request.Method, URL: entry.Request.URL, Status: entry.Response.Status, Header: header, Body: body}); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+// ADD THE FILES UNDER root AS PAGES OF host: root/a/b.html IS https://host/a/b.html, AND AN
+// index.html ALSO ANSWERS FOR ITS DIRECTORY
+func (s *Site) LoadSnapshots(root, host string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		contentType := mime.TypeByExtension(filepath.Ext(path))
+		if contentType == "" {
+			contentType = http.DetectContentType(body)
+		}
+		paths := []string{"/" + rel}
+		if dir, ok := strings.CutSuffix(rel, "index.html"); ok {
+			paths = append(paths, "/"+dir)
+		}
+		for _, p := range paths {
+			u := url.URL{Scheme: "https", Host: host, Path: p}
+			header := http.Header{"Content-Type": {contentType}}
+			if err := s.Add(Response{URL: u.String(), Header: header, Body: bytes.Clone(body)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// HAR 1.2 AS WRITTEN BY SaveHAR
+type harOutput struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int         `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harHeader `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harHeader `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	Content     harContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    int `json:"send"`
+	Wait    int `json:"wait"`
+	Receive int `json:"receive"`
+}
+
+// WRITE EVERY RECORDED RESPONSE TO A HAR FILE, IN THE ORDER THEY WERE RECORDED. TEXT BODIES ARE
+// WRITTEN AS TEXT AND ANYTHING ELSE AS BASE64, SO LoadHAR READS THE SAME BYTES BACK
+func (s *Site) SaveHAR(path, creator string) error {
+	s.mu.Lock()
+	entries := make([]harEntry, 0, len(s.order))
+	started := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, key := range s.order {
+		r := s.responses[key]
+		entry := harEntry{StartedDateTime: started}
+		entry.Request = harRequest{
+			Method:      r.Method,
+			URL:         r.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harHeader{},
+			Headers:     []harHeader{},
+			QueryString: []harHeader{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		if u, err := url.Parse(r.URL); err == nil {
+			for name, values := range u.Query() {
+				for _, value := range values {
+					entry.Request.QueryString = append(entry.Request.QueryString, harHeader{Name: name, Value: value})
+				}
+			}
+		}
+		content := harContent{Size: len(r.Body), MimeType: r.Header.Get("Content-Type")}
+		if utf8.Valid(r.Body) {
+			content.Text = string(r.Body)
+		} else {
+			content.Text = base64.StdEncoding.EncodeToString(r.Body)
+			content.Encoding = "base64"
+		}
+		entry.Response = harResponse{
+			Status:      r.Status,
+			StatusText:  http.StatusText(r.Status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harHeader{},
+			Headers:     []harHeader{},
+			Content:     content,
+			RedirectURL: r.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(r.Body),
+		}
+		names := make([]string, 0, len(r.Header))
+		for name := range r.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range r.Header[name] {
+				entry.Response.Headers = append(entry.Response.Headers, harHeader{Name: name, Value: value})
+			}
+		}
+		entries = append(entries, entry)
+	}
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(harOutput{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: creator, Version: "1"},
+		Entries: entries,
+	}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// WRITE THEN RENAME, SO A CRASH MID-WRITE LEAVES THE PREVIOUS FILE
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,161 @@
+package fixtures
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// -- SYNTHETIC SITES --
+//
+// A GENERATED SITE OF N PAGES WITH M ASSETS EACH FOR LOAD-TESTING THE ENGINE. NOTHING IS STORED:
+// PAGES AND ASSET BYTES ARE PRODUCED PER REQUEST, SO A SITE OF THOUSANDS OF PAGES COSTS NO MEMORY.
+// PAGES FORM A BINARY TREE FROM / (PAGE 0 LINKS TO PAGES 1 AND 2, PAGE 1 TO 3 AND 4, ...), SO A
+// CRAWL FROM / REACHES ALL OF THEM IN ABOUT log2(N) LEVELS.
+//
+//	/                     PAGE 0
+//	/page/<i>.html        PAGE i
+//	/asset/<i>/<j>.bin    ASSET j OF PAGE i
+
+// SHAPE OF A SYNTHETIC SITE
+type SyntheticOptions struct {
+	Pages     int           // PAGES IN THE SITE
+	Assets    int           // ASSETS LINKED FROM EACH PAGE
+	AssetSize int           // BYTES PER ASSET
+	Latency   time.Duration // ADDED BEFORE EVERY RESPONSE, TO STAND IN FOR A REMOTE SITE
+}
+
+// WHAT A SYNTHETIC SITE HAS SERVED
+type SyntheticStats struct {
+	Pages    int64 `json:"pages"`
+	Assets   int64 `json:"assets"`
+	Bytes    int64 `json:"bytes"`
+	NotFound int64 `json:"notFound"`
+}
+
+type SyntheticSite struct {
+	opts     SyntheticOptions
+	pages    atomic.Int64
+	assets   atomic.Int64
+	bytes    atomic.Int64
+	notFound atomic.Int64
+}
+
+func NewSyntheticSite(opts SyntheticOptions) *SyntheticSite {
+	return &SyntheticSite{opts: opts}
+}
+
+func (s *SyntheticSite) Stats() SyntheticStats {
+	return SyntheticStats{
+		Pages:    s.pages.Load(),
+		Assets:   s.assets.Load(),
+		Bytes:    s.bytes.Load(),
+		NotFound: s.notFound.Load(),
+	}
+}
+
+// PATH OF PAGE i
+func SyntheticPagePath(i int) string {
+	if i == 0 {
+		return "/"
+	}
+	return fmt.Sprintf("/page/%d.html", i)
+}
+
+// PATH OF ASSET j OF PAGE i
+func SyntheticAssetPath(i, j int) string {
+	return fmt.Sprintf("/asset/%d/%d.bin", i, j)
+}
+
+// EVERY ASSET URL OF THE SITE SERVED AT base, PAGE BY PAGE
+func (s *SyntheticSite) AssetURLs(base string) []string {
+	base = strings.TrimSuffix(base, "/")
+	urls := make([]string, 0, s.opts.Pages*s.opts.Assets)
+	for i := range s.opts.Pages {
+		for j := range s.opts.Assets {
+			urls = append(urls, base+SyntheticAssetPath(i, j))
+		}
+	}
+	return urls
+}
+
+func (s *SyntheticSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Latency > 0 {
+		select {
+		case <-time.After(s.opts.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	var body []byte
+	switch {
+	case r.URL.Path == "/":
+		body = s.page(0)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		s.pages.Add(1)
+	case strings.HasPrefix(r.URL.Path, "/page/"):
+		i, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/page/"), ".html"))
+		if err != nil || i <= 0 || i >= s.opts.Pages {
+			s.miss(w)
+			return
+		}
+		body = s.page(i)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		s.pages.Add(1)
+	case strings.HasPrefix(r.URL.Path, "/asset/"):
+		var i, j int
+		if _, err := fmt.Sscanf(r.URL.Path, "/asset/%d/%d.bin", &i, &j); err != nil || i < 0 || i >= s.opts.Pages || j < 0 || j >= s.opts.Assets {
+			s.miss(w)
+			return
+		}
+		body = s.asset(i, j)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		s.assets.Add(1)
+	default:
+		s.miss(w)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	n, _ := w.Write(body)
+	s.bytes.Add(int64(n))
+}
+
+func (s *SyntheticSite) miss(w http.ResponseWriter) {
+	s.notFound.Add(1)
+	http.NotFound(w, nil)
+}
+
+func (s *SyntheticSite) page(i int) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html><html><head><title>Page %d</title></head><body><h1>Page %d</h1>\n", i, i)
+	for _, child := range []int{2*i + 1, 2*i + 2} {
+		if child < s.opts.Pages {
+			fmt.Fprintf(&b, "<p><a href=\"%s\">Page %d</a></p>\n", SyntheticPagePath(child), child)
+		}
+	}
+	for j := range s.opts.Assets {
+		fmt.Fprintf(&b, "<img src=\"%s\" alt=\"Asset %d of page %d\">\n", SyntheticAssetPath(i, j), j, i)
+	}
+	b.WriteString("</body></html>\n")
+	return []byte(b.String())
+}
+
+// ASSET BYTES, DIFFERENT FOR EVERY ASSET SO CONTENT DEDUPLICATION DOESN'T SKEW A RUN
+func (s *SyntheticSite) asset(i, j int) []byte {
+	body := make([]byte, s.opts.AssetSize)
+	seed := uint32(i*1_000_003 + j*7919 + 1)
+	for k := range body {
+		// XORSHIFT: CHEAP, DETERMINISTIC AND INCOMPRESSIBLE ENOUGH
+		seed ^= seed << 13
+		seed ^= seed >> 17
+		seed ^= seed << 5
+		body[k] = byte(seed)
+	}
+	return body
+}
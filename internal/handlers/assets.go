@@ -1,23 +1,24 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
 	"github.com/nickheyer/Crepes/internal/config"
 	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
 	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
 	"gorm.io/gorm"
 )
 
 func GetAllAssets(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		query := db.Model(&models.Asset{})
+		user := auth.UserFrom(r.Context())
+		query := db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user))
 		if assetType := r.URL.Query().Get("type"); assetType != "" {
 			query = query.Where("type = ?", assetType)
 		}
@@ -26,7 +27,9 @@ func GetAllAssets(db *gorm.DB) http.HandlerFunc {
 		}
 		if search := r.URL.Query().Get("search"); search != "" {
 			searchTerm := "%" + search + "%"
-			query = query.Where("title LIKE ? OR description LIKE ? OR url LIKE ?", searchTerm, searchTerm, searchTerm)
+			// DOCUMENTS ALSO MATCH ON THEIR EXTRACTED TEXT
+			query = query.Where("title LIKE ? OR description LIKE ? OR url LIKE ? OR id IN (SELECT asset_id FROM asset_texts WHERE text LIKE ?)",
+				searchTerm, searchTerm, searchTerm, searchTerm)
 		}
 		if fromDate := r.URL.Query().Get("from"); fromDate != "" {
 			query = query.Where("date >= ?", fromDate)
@@ -34,6 +37,9 @@ func GetAllAssets(db *gorm.DB) http.HandlerFunc {
 		if toDate := r.URL.Query().Get("to"); toDate != "" {
 			query = query.Where("date <= ?", toDate)
 		}
+		if thumbnailStatus := r.URL.Query().Get("thumbnailStatus"); thumbnailStatus != "" {
+			query = query.Where("thumbnail_status = ?", thumbnailStatus)
+		}
 		sortBy := r.URL.Query().Get("sortBy")
 		sortDirection := r.URL.Query().Get("sortDirection")
 		if sortBy != "" {
@@ -64,11 +70,11 @@ func GetAllAssets(db *gorm.DB) http.HandlerFunc {
 			Document int64 `json:"document"`
 			Total    int64 `json:"total"`
 		}
-		db.Model(&models.Asset{}).Count(&counts.Total)
-		db.Model(&models.Asset{}).Where("type = ?", "image").Count(&counts.Image)
-		db.Model(&models.Asset{}).Where("type = ?", "video").Count(&counts.Video)
-		db.Model(&models.Asset{}).Where("type = ?", "audio").Count(&counts.Audio)
-		db.Model(&models.Asset{}).Where("type = ?", "document").Count(&counts.Document)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Count(&counts.Total)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Where("type = ?", "image").Count(&counts.Image)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Where("type = ?", "video").Count(&counts.Video)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Where("type = ?", "audio").Count(&counts.Audio)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Where("type = ?", "document").Count(&counts.Document)
 		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
 			"assets": assets,
 			"counts": counts,
@@ -94,7 +100,26 @@ func GetAssetByID(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
-func DeleteAsset(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+// THE TEXT EXTRACTED FROM A DOCUMENT ASSET, FOR PREVIEWS
+func GetAssetText(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		var text models.AssetText
+		found := db.Where("asset_id = ?", id).Limit(1).Find(&text)
+		if found.Error != nil {
+			log.Printf("Failed to fetch asset text: %v", found.Error)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch asset text")
+			return
+		}
+		if found.RowsAffected == 0 {
+			utils.RespondWithError(w, http.StatusNotFound, "No text has been extracted from this asset")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, text)
+	}
+}
+
+func DeleteAsset(db *gorm.DB, engine *scraper.Engine, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		id := params["id"]
@@ -105,23 +130,13 @@ func DeleteAsset(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 			utils.RespondWithError(w, http.StatusNotFound, "Asset not found")
 			return
 		}
-		if asset.LocalPath != "" {
-			filePath := filepath.Join(cfg.StoragePath, asset.LocalPath)
-			if err := os.Remove(filePath); err != nil {
-				log.Printf("Warning: failed to delete asset file: %v", err)
-			}
-		}
-		if asset.ThumbnailPath != "" {
-			thumbPath := filepath.Join(cfg.ThumbnailsPath, asset.ThumbnailPath)
-			if err := os.Remove(thumbPath); err != nil {
-				log.Printf("Warning: failed to delete thumbnail file: %v", err)
-			}
-		}
+		removeAssetFiles(cfg, engine, &asset)
 		if err := db.Delete(&asset).Error; err != nil {
 			log.Printf("Failed to delete asset from DB: %v", err)
 			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete asset")
 			return
 		}
+		db.Delete(&models.AssetText{}, "asset_id = ?", asset.ID)
 		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
 			"success": true,
 			"message": "Asset deleted successfully",
@@ -129,69 +144,77 @@ func DeleteAsset(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	}
 }
 
-func RegenerateThumbnail(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+// RE-RENDER ONE ASSET'S THUMBNAIL UNDER A NEW VERSIONED NAME, SO CLIENTS PICK UP THE NEW IMAGE
+func RegenerateThumbnail(engine *scraper.Engine) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		id := params["id"]
-		var asset models.Asset
-		result := db.First(&asset, "id = ?", id)
-		if result.Error != nil {
-			log.Printf("Asset not found for thumbnail regeneration: %v", result.Error)
+		id := mux.Vars(r)["id"]
+		asset, fallback, err := engine.RegenerateThumbnail(id)
+		if errors.Is(err, scraper.ErrAssetNotFound) {
 			utils.RespondWithError(w, http.StatusNotFound, "Asset not found")
 			return
 		}
-		if asset.LocalPath == "" {
-			utils.RespondWithError(w, http.StatusBadRequest, "Asset does not have a local file")
+		if err != nil {
+			log.Printf("Failed to regenerate thumbnail for asset %s: %v", id, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to generate thumbnail: "+err.Error())
 			return
 		}
-		filePath := filepath.Join(cfg.StoragePath, asset.LocalPath)
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			log.Printf("Asset file not found for thumbnail regeneration: %v", err)
-			utils.RespondWithError(w, http.StatusNotFound, "Asset file not found")
-			return
+		response := map[string]any{
+			"success":          true,
+			"message":          "Thumbnail regenerated successfully",
+			"thumbnailPath":    asset.ThumbnailPath,
+			"thumbnailVersion": asset.ThumbnailVersion,
+			"asset":            asset,
 		}
-		thumbnailFilename := "thumb_" + id + "_" + strconv.FormatInt(asset.UpdatedAt.Unix(), 10) + ".jpg"
-		thumbnailPath := filepath.Join(cfg.ThumbnailsPath, thumbnailFilename)
-		if asset.ThumbnailPath != "" {
-			oldThumbPath := filepath.Join(cfg.ThumbnailsPath, asset.ThumbnailPath)
-			if err := os.Remove(oldThumbPath); err != nil {
-				log.Printf("Warning: failed to delete old thumbnail: %v", err)
-			}
+		if fallback != nil {
+			response["message"] = "Placeholder thumbnail generated: " + fallback.Reason
+			response["warning"] = fallback.Reason
+		}
+		utils.RespondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+// QUEUE THUMBNAILS OF MANY ASSETS (BY IDS, JOB, TYPE AND/OR THUMBNAIL STATUS) FOR RE-RENDERING
+func RegenerateThumbnails(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var selection scraper.ThumbnailSelection
+		if errs := validation.DecodeJSON(r.Body, &selection); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
 		}
-		var err error
-		switch {
-		case strings.HasPrefix(asset.Type, "image"):
-			err = utils.GenerateImageThumbnail(filePath, thumbnailPath)
-		case strings.HasPrefix(asset.Type, "video"):
-			err = utils.GenerateVideoThumbnail(filePath, thumbnailPath)
-		case strings.HasPrefix(asset.Type, "audio"):
-			err = utils.GenerateAudioThumbnail(thumbnailPath)
-		case strings.HasPrefix(asset.Type, "document") || strings.HasPrefix(asset.Type, "application"):
-			err = utils.GenerateDocumentThumbnail(thumbnailPath)
+		switch selection.Status {
+		case "", scraper.ThumbnailDone, scraper.ThumbnailFailed:
 		default:
-			err = utils.GenerateGenericThumbnail(thumbnailPath)
+			errs := validation.Errors{{Path: "status", Message: "status must be done or failed", Expected: "done, failed", Rule: "enum"}}
+			respondWithValidationErrors(w, errs)
+			return
 		}
-		if err != nil {
-			log.Printf("Failed to generate thumbnail: %v", err)
-			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to generate thumbnail: "+err.Error())
+		user := auth.UserFrom(r.Context())
+		if selection.JobID != "" && !auth.CanAccessJob(db, user, selection.JobID) {
+			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
 			return
 		}
-		asset.ThumbnailPath = thumbnailFilename
-		if err := db.Save(&asset).Error; err != nil {
-			log.Printf("Failed to update asset with new thumbnail: %v", err)
-			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update asset")
+		selection.Scope = auth.OwnJobRows(user)
+		queued, err := engine.RequeueThumbnails(selection)
+		if errors.Is(err, scraper.ErrEmptyThumbnailSelection) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Select assets with assetIds, jobId, type or status")
 			return
 		}
-		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
-			"success":       true,
-			"message":       "Thumbnail regenerated successfully",
-			"thumbnailPath": thumbnailFilename,
+		if err != nil {
+			log.Printf("Failed to queue thumbnails: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to queue thumbnails")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusAccepted, map[string]any{
+			"success": true,
+			"queued":  queued,
+			"queue":   engine.ThumbnailStats(),
 		})
 	}
 }
 
 func GetAssetCounts(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.UserFrom(r.Context())
 		var counts struct {
 			Image    int64 `json:"image"`
 			Video    int64 `json:"video"`
@@ -199,11 +222,11 @@ func GetAssetCounts(db *gorm.DB) http.HandlerFunc {
 			Document int64 `json:"document"`
 			Total    int64 `json:"total"`
 		}
-		db.Model(&models.Asset{}).Count(&counts.Total)
-		db.Model(&models.Asset{}).Where("type = ?", "image").Count(&counts.Image)
-		db.Model(&models.Asset{}).Where("type = ?", "video").Count(&counts.Video)
-		db.Model(&models.Asset{}).Where("type = ?", "audio").Count(&counts.Audio)
-		db.Model(&models.Asset{}).Where("type = ?", "document").Count(&counts.Document)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Count(&counts.Total)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Where("type = ?", "image").Count(&counts.Image)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Where("type = ?", "video").Count(&counts.Video)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Where("type = ?", "audio").Count(&counts.Audio)
+		db.Model(&models.Asset{}).Scopes(auth.OwnJobRows(user)).Where("type = ?", "document").Count(&counts.Document)
 		utils.RespondWithJSON(w, http.StatusOK, counts)
 	}
 }
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/middleware"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+type credentials struct {
+	Username string `json:"username" validate:"required,max=100"`
+	Password string `json:"password" validate:"required"`
+}
+
+// WHETHER AUTH IS ON, WHETHER THE FIRST ADMIN STILL HAS TO BE CREATED, AND WHO IS SIGNED IN
+func GetAuthStatus(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := map[string]any{"enabled": cfg.Auth.Enabled}
+		if cfg.Auth.Enabled {
+			var users int64
+			db.Model(&models.User{}).Count(&users)
+			status["setupRequired"] = users == 0
+			if user, err := auth.ResolveSession(db, middleware.SessionToken(r), cfg.Auth.SessionLifetime()); err == nil {
+				status["user"] = user
+			}
+		}
+		utils.RespondWithJSON(w, http.StatusOK, status)
+	}
+}
+
+// CREATE THE FIRST ADMIN AND SIGN THEM IN; ONLY WHILE THERE ARE NO USERS
+func SetupAuth(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.Auth.Enabled {
+			utils.RespondWithError(w, http.StatusBadRequest, "Authentication is not enabled")
+			return
+		}
+		var body credentials
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if errs := validation.Struct(body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if err := auth.CheckPassword(body.Password); err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "password", Message: err.Error(), Rule: "password"}})
+			return
+		}
+		var user *models.User
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var users int64
+			if err := tx.Model(&models.User{}).Count(&users).Error; err != nil {
+				return err
+			}
+			if users > 0 {
+				return errSetupDone
+			}
+			var err error
+			user, err = auth.CreateUser(tx, body.Username, body.Password, auth.RoleAdmin)
+			return err
+		})
+		if errors.Is(err, errSetupDone) {
+			utils.RespondWithError(w, http.StatusConflict, "Setup is already done")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to create admin: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create admin")
+			return
+		}
+		log.Printf("Created first admin user %s", user.Username)
+		startSession(w, r, db, cfg, user)
+	}
+}
+
+var errSetupDone = errors.New("SETUP IS ALREADY DONE")
+
+// SIGN IN: THE TOKEN COMES BACK IN THE BODY (FOR API CLIENTS) AND AS AN HTTP-ONLY COOKIE (FOR THE UI)
+func Login(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.Auth.Enabled {
+			utils.RespondWithError(w, http.StatusBadRequest, "Authentication is not enabled")
+			return
+		}
+		var body credentials
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		user, err := auth.Authenticate(db, body.Username, body.Password)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			log.Printf("Failed login for %q from %s", body.Username, requestIP(r))
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid username or password")
+			return
+		}
+		if err != nil {
+			log.Printf("Login failed: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Login failed")
+			return
+		}
+		startSession(w, r, db, cfg, user)
+	}
+}
+
+func startSession(w http.ResponseWriter, r *http.Request, db *gorm.DB, cfg *config.Config, user *models.User) {
+	token, session, err := auth.CreateSession(db, user, cfg.Auth.SessionLifetime(), r.UserAgent(), requestIP(r))
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Login failed")
+		return
+	}
+	cookie := authCookie(r, cfg, token)
+	cookie.Expires = session.ExpiresAt
+	http.SetCookie(w, cookie)
+	utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+		"token":     token,
+		"expiresAt": session.ExpiresAt,
+		"user":      user,
+	})
+}
+
+// THE UI'S SESSION COOKIE, SCOPED TO basePath AND SECURE WHENEVER THE BROWSER IS ON HTTPS (DIRECTLY
+// OR THROUGH A TRUSTED PROXY, WHICH ProxyHeaders RECORDS AS THE URL'S SCHEME)
+func authCookie(r *http.Request, cfg *config.Config, token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     "/" + strings.Trim(cfg.BasePath, "/"),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.URL.Scheme == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// SIGN OUT: END THE SESSION AND CLEAR THE COOKIE
+func Logout(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := middleware.SessionToken(r); token != "" {
+			if err := auth.DeleteSession(db, token); err != nil {
+				log.Printf("Failed to end session: %v", err)
+			}
+		}
+		cookie := authCookie(r, cfg, "")
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true})
+	}
+}
+
+// THE SIGNED-IN USER
+func GetCurrentUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.UserFrom(r.Context())
+		if user == nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Authentication is not enabled")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, user)
+	}
+}
+
+// CHANGE THE SIGNED-IN USER'S PASSWORD; THEIR OTHER SESSIONS END
+func ChangePassword(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.UserFrom(r.Context())
+		if user == nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Authentication is not enabled")
+			return
+		}
+		var body struct {
+			CurrentPassword string `json:"currentPassword" validate:"required"`
+			NewPassword     string `json:"newPassword" validate:"required"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if _, err := auth.Authenticate(db, user.Username, body.CurrentPassword); err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "currentPassword", Message: "wrong password", Rule: "password"}})
+			return
+		}
+		hash, err := auth.HashPassword(body.NewPassword)
+		if err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "newPassword", Message: err.Error(), Rule: "password"}})
+			return
+		}
+		if err := db.Model(user).Updates(map[string]any{"password_hash": hash, "updated_at": time.Now()}).Error; err != nil {
+			log.Printf("Failed to change password: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to change password")
+			return
+		}
+		auth.DeleteOtherSessions(db, user.ID, middleware.SessionToken(r))
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true})
+	}
+}
+
+// -- USERS (ADMIN) --
+
+func GetUsers(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users := []models.User{}
+		if err := db.Order("username").Find(&users).Error; err != nil {
+			log.Printf("Failed to fetch users: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch users")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, users)
+	}
+}
+
+func CreateUser(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			credentials
+			Role string `json:"role" validate:"omitempty,oneof=admin user"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if errs := validation.Struct(body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if usernameTaken(db, body.Username, "") {
+			respondWithValidationErrors(w, validation.Errors{{Path: "username", Message: "username is taken", Rule: "unique"}})
+			return
+		}
+		if err := auth.CheckPassword(body.Password); err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "password", Message: err.Error(), Rule: "password"}})
+			return
+		}
+		user, err := auth.CreateUser(db, body.Username, body.Password, body.Role)
+		if err != nil {
+			log.Printf("Failed to create user: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create user")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, user)
+	}
+}
+
+// CHANGE A USER'S NAME, ROLE, PASSWORD OR DISABLED FLAG. A NEW PASSWORD OR DISABLING ENDS THEIR SESSIONS
+func UpdateUser(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := findUser(w, db, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+		var body struct {
+			Username *string `json:"username" validate:"omitempty,max=100"`
+			Password *string `json:"password"`
+			Role     *string `json:"role" validate:"omitempty,oneof=admin user"`
+			Disabled *bool   `json:"disabled"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if errs := validation.Struct(body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		updates := map[string]any{"updated_at": time.Now()}
+		endSessions := false
+		if body.Username != nil {
+			name := strings.TrimSpace(*body.Username)
+			if name == "" || usernameTaken(db, name, user.ID) {
+				respondWithValidationErrors(w, validation.Errors{{Path: "username", Message: "username is empty or taken", Rule: "unique"}})
+				return
+			}
+			updates["username"] = name
+		}
+		if body.Password != nil {
+			hash, err := auth.HashPassword(*body.Password)
+			if err != nil {
+				respondWithValidationErrors(w, validation.Errors{{Path: "password", Message: err.Error(), Rule: "password"}})
+				return
+			}
+			updates["password_hash"] = hash
+			endSessions = true
+		}
+		demoted := body.Role != nil && *body.Role != auth.RoleAdmin
+		disabled := body.Disabled != nil && *body.Disabled
+		if (demoted || disabled) && user.Role == auth.RoleAdmin && isLastAdmin(db, user.ID) {
+			utils.RespondWithError(w, http.StatusConflict, "Can't demote or disable the last admin")
+			return
+		}
+		if body.Role != nil {
+			updates["role"] = *body.Role
+		}
+		if body.Disabled != nil {
+			updates["disabled"] = *body.Disabled
+			endSessions = endSessions || disabled
+		}
+		if err := db.Model(&user).Updates(updates).Error; err != nil {
+			log.Printf("Failed to update user: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+		if endSessions {
+			auth.DeleteUserSessions(db, user.ID)
+		}
+		db.First(&user, "id = ?", user.ID)
+		utils.RespondWithJSON(w, http.StatusOK, user)
+	}
+}
+
+// DELETE A USER AND THEIR SESSIONS. THEIR JOBS STAY, VISIBLE TO ADMINS ONLY
+func DeleteUser(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := findUser(w, db, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+		if current := auth.UserFrom(r.Context()); current != nil && current.ID == user.ID {
+			utils.RespondWithError(w, http.StatusConflict, "Can't delete your own account")
+			return
+		}
+		if user.Role == auth.RoleAdmin && isLastAdmin(db, user.ID) {
+			utils.RespondWithError(w, http.StatusConflict, "Can't delete the last admin")
+			return
+		}
+		if err := db.Delete(&user).Error; err != nil {
+			log.Printf("Failed to delete user: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete user")
+			return
+		}
+		auth.DeleteUserSessions(db, user.ID)
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"message": "User deleted successfully",
+		})
+	}
+}
+
+func findUser(w http.ResponseWriter, db *gorm.DB, id string) (models.User, bool) {
+	var user models.User
+	if db.Where("id = ?", id).Limit(1).Find(&user).RowsAffected == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "User not found")
+		return user, false
+	}
+	return user, true
+}
+
+func usernameTaken(db *gorm.DB, username, exceptID string) bool {
+	var count int64
+	db.Model(&models.User{}).Where("username = ? AND id <> ?", strings.TrimSpace(username), exceptID).Count(&count)
+	return count > 0
+}
+
+// WHETHER THE USER IS THE ONLY ENABLED ADMIN
+func isLastAdmin(db *gorm.DB, userID string) bool {
+	var others int64
+	db.Model(&models.User{}).Where("role = ? AND disabled = ? AND id <> ?", auth.RoleAdmin, false, userID).Count(&others)
+	return others == 0
+}
+
+// THE CLIENT ADDRESS (ALREADY REWRITTEN FOR TRUSTED PROXIES)
+func requestIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
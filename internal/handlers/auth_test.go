@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "crepes.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.AuthSession{}, &models.Job{}, &models.Asset{},
+		&models.Folder{}, &models.Template{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// THE SESSION COOKIE IS SECURE ON HTTPS (OR BEHIND A PROXY THAT TERMINATES IT) AND LIVES UNDER basePath
+func TestSessionCookie(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := auth.CreateUser(db, "alice", "correct horse battery staple", auth.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{BasePath: "/crepes/", Auth: config.AuthConfig{Enabled: true}}
+
+	tests := []struct {
+		name   string
+		setup  func(r *http.Request)
+		secure bool
+	}{
+		{name: "plain http", setup: func(r *http.Request) {}},
+		{name: "tls", setup: func(r *http.Request) { r.TLS = &tls.ConnectionState{} }, secure: true},
+		{name: "trusted proxy", setup: func(r *http.Request) { r.URL.Scheme = "https" }, secure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username": "alice", "password": "correct horse battery staple"}`))
+			tt.setup(req)
+			rec := httptest.NewRecorder()
+			Login(db, cfg)(rec, req)
+			cookies := rec.Result().Cookies()
+			if rec.Code != http.StatusOK || len(cookies) != 1 {
+				t.Fatalf("login = %d with cookies %v", rec.Code, cookies)
+			}
+			if cookies[0].Secure != tt.secure || cookies[0].Path != "/crepes" || !cookies[0].HttpOnly {
+				t.Errorf("session cookie = %+v", cookies[0])
+			}
+
+			req = httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+			req.AddCookie(cookies[0])
+			tt.setup(req)
+			rec = httptest.NewRecorder()
+			Logout(db, cfg)(rec, req)
+			cleared := rec.Result().Cookies()
+			if len(cleared) != 1 || cleared[0].MaxAge >= 0 || cleared[0].Path != "/crepes" || cleared[0].Secure != tt.secure {
+				t.Errorf("cleared cookie = %v", cleared)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// WHAT CHANGED ON THE PAGES A JOB WATCHES: EACH PAGE'S LATEST CHECK (pages) AND ITS CHANGES, NEWEST
+// FIRST (changes), FILTERED BY ?url= (COMMA-SEPARATED) AND ?since= / ?until= (RFC 3339), WITH
+// ?limit= AND ?offset= OVER THE CHANGES
+func GetJobChanges(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var job models.Job
+		if err := db.Select("id").First(&job, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		values := r.URL.Query()
+		pagesQuery := db.Model(&models.PageSnapshot{}).Where("job_id = ?", job.ID)
+		changesQuery := db.Model(&models.PageChange{}).Where("job_id = ?", job.ID)
+		if list := splitList(values.Get("url")); len(list) > 0 {
+			pagesQuery = pagesQuery.Where("url IN ?", list)
+			changesQuery = changesQuery.Where("url IN ?", list)
+		}
+		for param, op := range map[string]string{"since": ">=", "until": "<="} {
+			if value := values.Get(param); value != "" {
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					utils.RespondWithError(w, http.StatusBadRequest, "Invalid since/until time (expected RFC 3339)")
+					return
+				}
+				changesQuery = changesQuery.Where("created_at "+op+" ?", t)
+			}
+		}
+
+		// THE TEXT IS ONLY NEEDED TO DIFF THE NEXT CHECK, SO THE LISTING LEAVES IT OUT
+		pages := []models.PageSnapshot{}
+		if err := pagesQuery.Omit("text").Order("url").Find(&pages).Error; err != nil {
+			log.Printf("Failed to fetch page snapshots: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch page changes")
+			return
+		}
+		var total int64
+		changesQuery.Session(&gorm.Session{}).Count(&total)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+		limit := 100
+		if n, err := strconv.Atoi(values.Get("limit")); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+		offset, _ := strconv.Atoi(values.Get("offset"))
+		changes := []models.PageChange{}
+		if err := changesQuery.Order("created_at DESC").Limit(limit).Offset(max(offset, 0)).Find(&changes).Error; err != nil {
+			log.Printf("Failed to fetch page changes: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch page changes")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"pages":   pages,
+			"changes": changes,
+		})
+	}
+}
+
+// CREATE A MONITOR JOB: ON A SCHEDULE (HOURLY BY DEFAULT) IT LOADS EACH OF urls AND RECORDS WHAT
+// CHANGED SINCE THE LAST CHECK, SEEN AT GET /jobs/{id}/changes
+func CreateMonitorJob(db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name           string            `json:"name"`
+			URLs           []string          `json:"urls"`
+			Schedule       string            `json:"schedule"`
+			Scope          string            `json:"scope"`
+			Ignore         []string          `json:"ignore"`
+			IgnorePatterns []string          `json:"ignorePatterns"`
+			Selectors      map[string]string `json:"selectors"`
+			Concurrency    int               `json:"concurrency"`
+			WaitUntil      string            `json:"waitUntil"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &req); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if req.Concurrency < 0 {
+			respondWithValidationErrors(w, validation.Errors{{Path: "concurrency", Message: "can't be negative", Expected: "integer", Rule: "min"}})
+			return
+		}
+		job, err := scraper.BuildMonitorJob(scraper.MonitorJobOptions{
+			Name:           req.Name,
+			URLs:           req.URLs,
+			Schedule:       req.Schedule,
+			Scope:          req.Scope,
+			Ignore:         req.Ignore,
+			IgnorePatterns: req.IgnorePatterns,
+			Selectors:      req.Selectors,
+			Concurrency:    req.Concurrency,
+			WaitUntil:      req.WaitUntil,
+		})
+		if errors.Is(err, scraper.ErrInvalidInput) {
+			path := "urls"
+			for _, field := range []string{"waitUntil", "scope", "ignorePatterns", "ignore", "selectors"} {
+				if strings.Contains(err.Error(), field) {
+					path = field
+					break
+				}
+			}
+			respondWithValidationErrors(w, validation.Errors{{Path: path, Message: strings.ToLower(err.Error()), Expected: "monitor options", Rule: "monitor"}})
+			return
+		}
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to build monitor job")
+			return
+		}
+
+		now := time.Now()
+		job.ID = utils.GenerateID("job")
+		job.OwnerID = ownerOf(r)
+		job.CreatedAt = now
+		job.UpdatedAt = now
+		if errs := validateJob(engine, &job); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if err := db.Create(&job).Error; err != nil {
+			log.Printf("Failed to create monitor job: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create job")
+			return
+		}
+		scheduler.ScheduleJob(&job)
+		utils.RespondWithJSON(w, http.StatusCreated, job)
+	}
+}
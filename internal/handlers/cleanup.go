@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// A FILE ON DISK WITH NO ASSET REFERENCING IT
+type orphanFile struct {
+	Root string `json:"root"` // storage OR thumbnails
+	Path string `json:"path"` // RELATIVE TO THE ROOT
+	Size int64  `json:"size"`
+}
+
+// RESULT OF A GARBAGE COLLECTION PASS
+type gcReport struct {
+	DryRun        bool         `json:"dryRun"`
+	OrphanFiles   []orphanFile `json:"orphanFiles"`
+	OrphanBytes   int64        `json:"orphanBytes"`
+	MissingAssets []string     `json:"missingAssets"` // ASSETS WHOSE FILE IS GONE
+	OrphanAssets  []string     `json:"orphanAssets"`  // ASSETS WHOSE JOB IS GONE
+	RemovedFiles  int          `json:"removedFiles"`
+	RemovedAssets int          `json:"removedAssets"`
+	FreedBytes    int64        `json:"freedBytes"`
+}
+
+// RESOLVE A STORED PATH UNDER A ROOT, REFUSING PATHS THAT ESCAPE IT (OR ARE THE ROOT ITSELF)
+func pathUnder(root, name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	full, err := utils.ConfinePath(root, name)
+	if err != nil {
+		return "", false
+	}
+	if absRoot, err := filepath.Abs(root); err != nil || full == absRoot {
+		return "", false
+	}
+	return full, true
+}
+
+// REMOVE A FILE AND ANY DIRECTORIES LEFT EMPTY BETWEEN IT AND THE ROOT, RETURNING THE BYTES FREED
+func removeStoredFile(root, name string) (int64, error) {
+	full, ok := pathUnder(root, name)
+	if !ok {
+		return 0, nil
+	}
+	info, err := os.Stat(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Remove(full); err != nil {
+		return 0, err
+	}
+	absRoot, _ := filepath.Abs(root)
+	for dir := filepath.Dir(full); dir != absRoot && strings.HasPrefix(dir, absRoot); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // NOT EMPTY
+		}
+	}
+	return info.Size(), nil
+}
+
+// DELETE AN ASSET'S FILE AND THUMBNAIL FROM DISK AND FROM THE BUCKET IT WAS UPLOADED TO
+func removeAssetFiles(cfg *config.Config, engine *scraper.Engine, asset *models.Asset) int64 {
+	freed := engine.RemoveRemoteFiles(asset)
+	if asset.LocalPath != "" {
+		n, err := removeStoredFile(cfg.StoragePath, asset.LocalPath)
+		if err != nil {
+			log.Printf("Warning: failed to delete asset file: %v", err)
+		}
+		freed += n
+	}
+	if asset.ThumbnailPath != "" {
+		n, err := removeStoredFile(cfg.ThumbnailsPath, asset.ThumbnailPath)
+		if err != nil {
+			log.Printf("Warning: failed to delete thumbnail file: %v", err)
+		}
+		freed += n
+	}
+	return freed
+}
+
+// DELETE A JOB'S ASSETS (ROWS AND FILES), ANY SITE ARCHIVE DIRECTORIES IT PRODUCED, ITS ERROR LOGS AND RECORDS
+func deleteJobAssets(db *gorm.DB, engine *scraper.Engine, cfg *config.Config, jobID string) (int, error) {
+	var assets []models.Asset
+	if err := db.Where("job_id = ?", jobID).Find(&assets).Error; err != nil {
+		return 0, err
+	}
+	archives := make(map[string]bool)
+	for i := range assets {
+		removeAssetFiles(cfg, engine, &assets[i])
+		if archiveID, ok := assets[i].Metadata["archiveId"].(string); ok && archiveID != "" {
+			archives[archiveID] = true
+		}
+	}
+	// ARCHIVES ALSO HOLD FILES THAT AREN'T ASSETS (index.json, PAGE SNAPSHOTS)
+	for archiveID := range archives {
+		if dir, ok := pathUnder(cfg.StoragePath, filepath.Join("archives", archiveID)); ok {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Printf("Warning: failed to delete archive directory: %v", err)
+			}
+		}
+	}
+	if err := db.Where("job_id = ?", jobID).Delete(&models.Asset{}).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Where("job_id = ?", jobID).Delete(&models.AssetText{}).Error; err != nil {
+		return 0, err
+	}
+
+	// FAILURE CAPTURES AND THE ERRORS THEY BELONG TO
+	if dir, ok := pathUnder(cfg.StoragePath, filepath.Join("failures", jobID)); ok {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Warning: failed to delete failure captures: %v", err)
+		}
+	}
+	if err := db.Where("job_id = ?", jobID).Delete(&models.ErrorLog{}).Error; err != nil {
+		return 0, err
+	}
+
+	// EXTRACTED RECORDS
+	if err := db.Where("job_id = ?", jobID).Delete(&models.Record{}).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Where("job_id = ?", jobID).Delete(&models.RecordRejection{}).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Where("job_id = ?", jobID).Delete(&models.RecordChange{}).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Where("job_id = ?", jobID).Delete(&models.RecordAlert{}).Error; err != nil {
+		return 0, err
+	}
+
+	// SNAPSHOTS AND CHANGES OF THE PAGES IT WATCHES
+	if err := db.Where("job_id = ?", jobID).Delete(&models.PageSnapshot{}).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Where("job_id = ?", jobID).Delete(&models.PageChange{}).Error; err != nil {
+		return 0, err
+	}
+
+	// STATE KEPT BETWEEN RUNS
+	if err := db.Where("job_id = ?", jobID).Delete(&models.JobState{}).Error; err != nil {
+		return 0, err
+	}
+
+	// PER-ITEM RESULTS OF ITS LOOPS AND ITS RUNS' TIMELINES
+	if err := db.Where("job_id = ?", jobID).Delete(&models.ItemResult{}).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Where("job_id = ?", jobID).Delete(&models.RunEvent{}).Error; err != nil {
+		return 0, err
+	}
+
+	// COOKIES AND LOCAL STORAGE KEPT BETWEEN RUNS
+	if err := db.Where("job_id = ?", jobID).Delete(&models.Session{}).Error; err != nil {
+		return 0, err
+	}
+
+	// PART FILES OF ITS UNFINISHED DOWNLOADS
+	if err := db.Where("job_id = ?", jobID).Delete(&models.PartialDownload{}).Error; err != nil {
+		return 0, err
+	}
+	if err := os.RemoveAll(scraper.PartialsDir(cfg, jobID)); err != nil {
+		log.Printf("Warning: failed to delete partial downloads: %v", err)
+	}
+
+	// RECORDED RESPONSES OF ITS cassette RULE
+	if err := os.Remove(scraper.CassettePath(cfg, jobID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to delete cassette: %v", err)
+	}
+	return len(assets), nil
+}
+
+// RECONCILE STORAGE AGAINST THE DATABASE: FIND (AND UNLESS dryRun, DELETE) FILES NO ASSET
+// REFERENCES, ASSETS WHOSE FILE IS MISSING AND ASSETS WHOSE JOB NO LONGER EXISTS
+func GarbageCollect(db *gorm.DB, engine *scraper.Engine, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			DryRun *bool  `json:"dryRun"` // DEFAULT TRUE
+			MinAge string `json:"minAge"` // IGNORE FILES NEWER THAN THIS (DEFAULT 1h) SO IN-FLIGHT WRITES SURVIVE
+		}
+		if r.ContentLength != 0 {
+			if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+				respondWithValidationErrors(w, errs)
+				return
+			}
+		}
+		dryRun := body.DryRun == nil || *body.DryRun
+		minAge := time.Hour
+		if body.MinAge != "" {
+			d, err := time.ParseDuration(body.MinAge)
+			if err != nil || d < 0 {
+				respondWithValidationErrors(w, validation.Errors{{Path: "minAge", Message: "must be a duration like 30m or 2h", Expected: "duration", Rule: "duration"}})
+				return
+			}
+			minAge = d
+		}
+		if !dryRun && len(engine.QueueStatus().Running) > 0 {
+			utils.RespondWithError(w, http.StatusConflict, "Jobs are running; try again when they finish or use a dry run")
+			return
+		}
+
+		var assets []models.Asset
+		if err := db.Select("id", "job_id", "local_path", "thumbnail_path", "metadata", "size", "storage", "storage_status").Find(&assets).Error; err != nil {
+			log.Printf("Failed to load assets for gc: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to load assets")
+			return
+		}
+		var jobIDs []string
+		db.Model(&models.Job{}).Pluck("id", &jobIDs)
+		jobs := make(map[string]bool, len(jobIDs))
+		for _, id := range jobIDs {
+			jobs[id] = true
+		}
+
+		report := gcReport{DryRun: dryRun, OrphanFiles: []orphanFile{}, MissingAssets: []string{}, OrphanAssets: []string{}}
+		storageRefs := make(map[string]bool)
+		thumbRefs := make(map[string]bool)
+		archiveRefs := make(map[string]bool)
+		var doomed []models.Asset
+		for _, asset := range assets {
+			if asset.JobID != "" && !jobs[asset.JobID] {
+				report.OrphanAssets = append(report.OrphanAssets, asset.ID)
+				doomed = append(doomed, asset)
+				continue
+			}
+			if full, ok := pathUnder(cfg.StoragePath, asset.LocalPath); ok {
+				storageRefs[full] = true
+				// AN UPLOADED ASSET'S FILE IS IN ITS BUCKET, NOT ON DISK
+				if _, err := os.Stat(full); errors.Is(err, fs.ErrNotExist) && !asset.IsRemote() {
+					report.MissingAssets = append(report.MissingAssets, asset.ID)
+					doomed = append(doomed, asset)
+					continue
+				}
+			}
+			if full, ok := pathUnder(cfg.ThumbnailsPath, asset.ThumbnailPath); ok {
+				thumbRefs[full] = true
+			}
+			if archiveID, ok := asset.Metadata["archiveId"].(string); ok && archiveID != "" {
+				archiveRefs[archiveID] = true
+			}
+		}
+
+		// FAILURE CAPTURES ATTACHED TO ERROR LOGS
+		var captures []models.ErrorLog
+		db.Select("screenshot", "snapshot", "har").Where("screenshot != '' OR snapshot != '' OR har != ''").Find(&captures)
+		for _, capture := range captures {
+			for _, name := range []string{capture.Screenshot, capture.Snapshot, capture.HAR} {
+				if full, ok := pathUnder(cfg.StoragePath, name); ok {
+					storageRefs[full] = true
+				}
+			}
+		}
+
+		// DIRECTORIES THAT AREN'T ASSET STORAGE EVEN IF NESTED UNDER IT
+		var skip []string
+		for _, dir := range []string{cfg.ThumbnailsPath, cfg.StoragePath, cfg.DataPath} {
+			if abs, err := filepath.Abs(dir); err == nil {
+				skip = append(skip, abs)
+			}
+		}
+		cutoff := time.Now().Add(-minAge)
+		scan := func(rootName, root string, refs map[string]bool) {
+			absRoot, err := filepath.Abs(root)
+			if err != nil {
+				return
+			}
+			filepath.WalkDir(absRoot, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return nil
+				}
+				if d.IsDir() {
+					for _, other := range skip {
+						if path != absRoot && path == other {
+							return filepath.SkipDir
+						}
+					}
+					return nil
+				}
+				if refs[path] {
+					return nil
+				}
+				rel, _ := filepath.Rel(absRoot, path)
+				// FILES INSIDE A SITE ARCHIVE STAY WHILE ANY ASSET STILL POINTS INTO THAT ARCHIVE
+				parts := strings.Split(filepath.ToSlash(rel), "/")
+				if rootName == "storage" && len(parts) > 2 && parts[0] == "archives" && archiveRefs[parts[1]] {
+					return nil
+				}
+				info, err := d.Info()
+				if err != nil || info.ModTime().After(cutoff) {
+					return nil
+				}
+				report.OrphanFiles = append(report.OrphanFiles, orphanFile{Root: rootName, Path: filepath.ToSlash(rel), Size: info.Size()})
+				report.OrphanBytes += info.Size()
+				return nil
+			})
+		}
+		scan("storage", cfg.StoragePath, storageRefs)
+		scan("thumbnails", cfg.ThumbnailsPath, thumbRefs)
+
+		if !dryRun {
+			for _, orphan := range report.OrphanFiles {
+				root := cfg.StoragePath
+				if orphan.Root == "thumbnails" {
+					root = cfg.ThumbnailsPath
+				}
+				freed, err := removeStoredFile(root, filepath.FromSlash(orphan.Path))
+				if err != nil {
+					log.Printf("Warning: failed to delete orphan file %s: %v", orphan.Path, err)
+					continue
+				}
+				report.RemovedFiles++
+				report.FreedBytes += freed
+			}
+			for i := range doomed {
+				report.FreedBytes += removeAssetFiles(cfg, engine, &doomed[i])
+				if err := db.Delete(&models.Asset{}, "id = ?", doomed[i].ID).Error; err != nil {
+					log.Printf("Warning: failed to delete asset %s: %v", doomed[i].ID, err)
+					continue
+				}
+				db.Delete(&models.AssetText{}, "asset_id = ?", doomed[i].ID)
+				report.RemovedAssets++
+			}
+			log.Printf("Garbage collection removed %d files and %d assets (%s freed)",
+				report.RemovedFiles, report.RemovedAssets, utils.FormatFileSize(uint64(report.FreedBytes)))
+		}
+		utils.RespondWithJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// COLUMNS ERRORS CAN BE GROUPED BY
+var errorGroupColumns = map[string]string{
+	"job":         "job_id",
+	"stage":       "stage",
+	"statusCode":  "status_code",
+	"fingerprint": "fingerprint",
+	"taskType":    "task_type",
+}
+
+// ONE GROUP OF ERRORS
+type errorGroup struct {
+	Key       string    `json:"key"`
+	Count     int64     `json:"count"`
+	Open      int64     `json:"open"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Sample    string    `json:"sample"` // MOST RECENT MESSAGE IN THE GROUP
+	SampleID  string    `json:"sampleId"`
+}
+
+// FILTER ERROR LOGS BY ?jobId= ?stage= ?statusCode= ?fingerprint= ?taskType= ?status= (COMMA-SEPARATED)
+// ?since= / ?until= (RFC 3339) AND ?q= (MESSAGE SEARCH)
+func errorLogQuery(db *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	values := r.URL.Query()
+	query := db.Model(&models.ErrorLog{})
+	for param, column := range map[string]string{
+		"jobId":       "job_id",
+		"stage":       "stage",
+		"fingerprint": "fingerprint",
+		"taskType":    "task_type",
+		"status":      "status",
+	} {
+		if list := splitList(values.Get(param)); len(list) > 0 {
+			query = query.Where(column+" IN ?", list)
+		}
+	}
+	if codes := splitList(values.Get("statusCode")); len(codes) > 0 {
+		query = query.Where("status_code IN ?", codes)
+	}
+	for param, op := range map[string]string{"since": ">=", "until": "<="} {
+		if value := values.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, err
+			}
+			query = query.Where("created_at "+op+" ?", t)
+		}
+	}
+	if q := strings.TrimSpace(values.Get("q")); q != "" {
+		query = query.Where("message LIKE ? ESCAPE '\\'", "%"+escapeLike(q)+"%")
+	}
+	return query, nil
+}
+
+// LIST ERROR LOGS, NEWEST FIRST, WITH ?limit= AND ?offset=
+func GetErrorLogs(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := errorLogQuery(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid since/until time (expected RFC 3339)")
+			return
+		}
+		var total int64
+		query.Session(&gorm.Session{}).Count(&total)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+		limit := 100
+		if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		var logs []models.ErrorLog
+		if err := query.Order("created_at DESC").Limit(limit).Offset(max(offset, 0)).Find(&logs).Error; err != nil {
+			log.Printf("Failed to fetch error logs: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch error logs")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, logs)
+	}
+}
+
+// GET ONE ERROR LOG
+func GetErrorLog(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var entry models.ErrorLog
+		if err := db.First(&entry, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Error log not found")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, entry)
+	}
+}
+
+// GROUP ERROR LOGS ?by=job|stage|statusCode|fingerprint|taskType (SAME FILTERS AS THE LIST)
+func GetErrorGroups(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		by := r.URL.Query().Get("by")
+		if by == "" {
+			by = "fingerprint"
+		}
+		column, ok := errorGroupColumns[by]
+		if !ok {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid group field; use job, stage, statusCode, fingerprint or taskType")
+			return
+		}
+		query, err := errorLogQuery(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid since/until time (expected RFC 3339)")
+			return
+		}
+
+		var logs []models.ErrorLog
+		if err := query.Select("id", column, "message", "status", "created_at").Order("created_at DESC").Find(&logs).Error; err != nil {
+			log.Printf("Failed to fetch error logs: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch error logs")
+			return
+		}
+		// NEWEST FIRST, SO THE FIRST ENTRY SEEN PER GROUP IS ITS SAMPLE
+		groups := []*errorGroup{}
+		byKey := make(map[string]*errorGroup)
+		for _, entry := range logs {
+			key := errorGroupKey(by, &entry)
+			group, ok := byKey[key]
+			if !ok {
+				group = &errorGroup{Key: key, LastSeen: entry.CreatedAt, Sample: entry.Message, SampleID: entry.ID}
+				byKey[key] = group
+				groups = append(groups, group)
+			}
+			group.Count++
+			if entry.Status == models.ErrorStatusOpen {
+				group.Open++
+			}
+			group.FirstSeen = entry.CreatedAt
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"by": by, "groups": groups})
+	}
+}
+
+func errorGroupKey(by string, entry *models.ErrorLog) string {
+	switch by {
+	case "job":
+		return entry.JobID
+	case "stage":
+		return entry.Stage
+	case "statusCode":
+		return strconv.Itoa(entry.StatusCode)
+	case "taskType":
+		return entry.TaskType
+	default:
+		return entry.Fingerprint
+	}
+}
+
+// ACKNOWLEDGE, RESOLVE OR REOPEN ERRORS SELECTED BY IDS, FINGERPRINT AND/OR JOB
+func BulkUpdateErrorLogs(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Action      string   `json:"action" validate:"required,oneof=acknowledge resolve reopen"`
+			IDs         []string `json:"ids"`
+			Fingerprint string   `json:"fingerprint"`
+			JobID       string   `json:"jobId"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if errs := validation.Struct(body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if len(body.IDs) == 0 && body.Fingerprint == "" && body.JobID == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Select errors with ids, fingerprint or jobId")
+			return
+		}
+
+		query := db.Model(&models.ErrorLog{})
+		if len(body.IDs) > 0 {
+			query = query.Where("id IN ?", body.IDs)
+		}
+		if body.Fingerprint != "" {
+			query = query.Where("fingerprint = ?", body.Fingerprint)
+		}
+		if body.JobID != "" {
+			query = query.Where("job_id = ?", body.JobID)
+		}
+		now := time.Now()
+		var updates map[string]any
+		switch body.Action {
+		case "acknowledge":
+			// RESOLVED ERRORS STAY RESOLVED
+			query = query.Where("status = ?", models.ErrorStatusOpen)
+			updates = map[string]any{"status": models.ErrorStatusAcknowledged, "acknowledged_at": now}
+		case "resolve":
+			updates = map[string]any{"status": models.ErrorStatusResolved, "resolved_at": now}
+		case "reopen":
+			updates = map[string]any{"status": models.ErrorStatusOpen, "acknowledged_at": nil, "resolved_at": nil}
+		}
+		result := query.Updates(updates)
+		if result.Error != nil {
+			log.Printf("Failed to update error logs: %v", result.Error)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update error logs")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true, "updated": result.RowsAffected})
+	}
+}
+
+// SERVE AN ERROR'S FAILURE SCREENSHOT OR DOM SNAPSHOT
+func GetErrorCapture(db *gorm.DB, cfg *config.Config, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var entry models.ErrorLog
+		if err := db.First(&entry, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Error log not found")
+			return
+		}
+		name, contentType := entry.Screenshot, "image/jpeg"
+		if kind == "snapshot" {
+			// SERVED AS TEXT SO THE CAPTURED PAGE'S SCRIPTS DON'T RUN ON THIS ORIGIN
+			name, contentType = entry.Snapshot, "text/plain; charset=utf-8"
+		}
+		full, ok := pathUnder(cfg.StoragePath, name)
+		if !ok {
+			utils.RespondWithError(w, http.StatusNotFound, "No "+kind+" was captured for this error")
+			return
+		}
+		serveFile(w, r, full, contentType, cfg)
+	}
+}
+
+// DOWNLOAD EVERYTHING NEEDED TO REPRODUCE A FAILED TASK AS A ZIP: THE ERROR RECORD, THE
+// TASK'S CONFIG, THE INPUTS IT RAN WITH AND WHATEVER SCREENSHOT, SNAPSHOT AND HAR WERE CAPTURED
+func GetErrorBundle(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var entry models.ErrorLog
+		if err := db.First(&entry, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Error log not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": "error_" + entry.ID + ".zip",
+		}))
+		archive := zip.NewWriter(w)
+		defer archive.Close()
+
+		documents := []struct {
+			name  string
+			value any
+		}{
+			{"error.json", entry},
+			{"task.json", map[string]any{
+				"id":     entry.TaskID,
+				"name":   entry.TaskName,
+				"type":   entry.TaskType,
+				"config": entry.TaskConfig,
+			}},
+			{"inputs.json", entry.Inputs},
+		}
+		for _, doc := range documents {
+			data, err := json.MarshalIndent(doc.value, "", "  ")
+			if err != nil {
+				continue
+			}
+			if part, err := archive.Create(doc.name); err == nil {
+				part.Write(data)
+			}
+		}
+
+		// CAPTURES ARE OPTIONAL; A HAR ONLY EXISTS ONCE ITS PAGE WAS CLOSED
+		captures := []struct{ name, path string }{
+			{"screenshot.jpg", entry.Screenshot},
+			{"snapshot.html", entry.Snapshot},
+			{"page.har", entry.HAR},
+		}
+		for _, capture := range captures {
+			full, ok := pathUnder(cfg.StoragePath, capture.path)
+			if !ok {
+				continue
+			}
+			if err := addFileToZip(archive, capture.name, full); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to add %s to error bundle %s: %v", capture.name, entry.ID, err)
+			}
+		}
+	}
+}
+
+// COPY A FILE INTO A ZIP ARCHIVE
+func addFileToZip(archive *zip.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	part, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// STREAM ENGINE EVENTS OVER A WEBSOCKET, OPTIONALLY FILTERED BY ?jobId=. NON-ADMINS ONLY GET
+// EVENTS OF THEIR OWN JOBS (AND THOSE OF NO JOB)
+func EventStream(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.UserFrom(r.Context())
+		jobID := r.URL.Query().Get("jobId")
+		if jobID != "" && !auth.CanAccessJob(db, user, jobID) {
+			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("WebSocket upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		events, unsubscribe := engine.Events().Subscribe(jobID)
+		defer unsubscribe()
+
+		// READ LOOP ONLY TO NOTICE CLIENT DISCONNECTS
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		visible := map[string]bool{}
+		canSee := func(event scraper.Event) bool {
+			if auth.SeesAll(user) || event.JobID == "" {
+				return true
+			}
+			if _, ok := visible[event.JobID]; !ok {
+				var count int64
+				db.Model(&models.Job{}).Scopes(auth.OwnJobs(user)).Where("id = ?", event.JobID).Count(&count)
+				visible[event.JobID] = count > 0
+			}
+			return visible[event.JobID]
+		}
+
+		ping := time.NewTicker(30 * time.Second)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if !canSee(event) {
+					continue
+				}
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
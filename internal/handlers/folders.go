@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/database"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// FOLDER WITH THE NUMBER OF JOBS DIRECTLY INSIDE IT
+type folderWithCount struct {
+	models.Folder
+	JobCount int64 `json:"jobCount"`
+}
+
+// JOBS TARGETED BY A BULK OPERATION: EXPLICIT IDS, A FOLDER (WITH SUBFOLDERS) AND/OR A TAG
+type jobSelection struct {
+	JobIDs   []string `json:"jobIds"`
+	FolderID string   `json:"folderId"`
+	Tag      string   `json:"tag"`
+
+	user *models.User // ONLY THIS USER'S JOBS ARE SELECTED (NIL FOR ALL)
+}
+
+// LIST THE USER'S FOLDERS (FLAT; parentId BUILDS THE TREE)
+func GetFolders(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.UserFrom(r.Context())
+		var folders []models.Folder
+		if err := db.Scopes(auth.Owned(user)).Order("name").Find(&folders).Error; err != nil {
+			log.Printf("Failed to fetch folders: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch folders")
+			return
+		}
+		var counts []struct {
+			FolderID string
+			Count    int64
+		}
+		db.Model(&models.Job{}).Scopes(auth.OwnJobs(user)).Select("folder_id, COUNT(*) AS count").Group("folder_id").Scan(&counts)
+		byFolder := make(map[string]int64)
+		for _, c := range counts {
+			byFolder[c.FolderID] = c.Count
+		}
+		out := make([]folderWithCount, len(folders))
+		for i, folder := range folders {
+			out[i] = folderWithCount{Folder: folder, JobCount: byFolder[folder.ID]}
+		}
+		utils.RespondWithJSON(w, http.StatusOK, out)
+	}
+}
+
+// CREATE A FOLDER
+func CreateFolder(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var folder models.Folder
+		if errs := validation.DecodeJSON(r.Body, &folder); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		folder.ID = utils.GenerateID("folder")
+		folder.OwnerID = ownerOf(r)
+		if errs := validateFolder(db, auth.UserFrom(r.Context()), &folder); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if err := db.Create(&folder).Error; err != nil {
+			log.Printf("Failed to create folder: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create folder")
+			return
+		}
+		scheduler.ScheduleFolder(&folder)
+		utils.RespondWithJSON(w, http.StatusCreated, folder)
+	}
+}
+
+// RENAME, MOVE OR RESCHEDULE A FOLDER (OMITTED FIELDS ARE LEFT AS THEY ARE)
+func UpdateFolder(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		var folder models.Folder
+		if err := db.First(&folder, "id = ?", id).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Folder not found")
+			return
+		}
+		var update struct {
+			Name     *string         `json:"name"`
+			ParentID *string         `json:"parentId"`
+			Schedule *string         `json:"schedule"`
+			Settings *models.JSONMap `json:"settings"` // {} CLEARS THE OVERRIDES
+		}
+		if errs := validation.DecodeJSON(r.Body, &update); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if update.Name != nil {
+			folder.Name = *update.Name
+		}
+		if update.ParentID != nil {
+			folder.ParentID = *update.ParentID
+		}
+		if update.Schedule != nil {
+			folder.Schedule = *update.Schedule
+		}
+		if update.Settings != nil {
+			folder.Settings = *update.Settings
+		}
+		if errs := validateFolder(db, auth.UserFrom(r.Context()), &folder); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		folder.UpdatedAt = time.Now()
+		if err := db.Save(&folder).Error; err != nil {
+			log.Printf("Failed to update folder: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update folder")
+			return
+		}
+		scheduler.ScheduleFolder(&folder)
+		utils.RespondWithJSON(w, http.StatusOK, folder)
+	}
+}
+
+// DELETE A FOLDER; ITS JOBS AND SUBFOLDERS MOVE UP TO ITS PARENT
+func DeleteFolder(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		var folder models.Folder
+		if err := db.First(&folder, "id = ?", id).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Folder not found")
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Job{}).Where("folder_id = ?", id).Update("folder_id", folder.ParentID).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Folder{}).Where("parent_id = ?", id).Update("parent_id", folder.ParentID).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&folder).Error
+		})
+		if err != nil {
+			log.Printf("Failed to delete folder: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete folder")
+			return
+		}
+		scheduler.RemoveFolder(id)
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"message": "Folder deleted successfully",
+		})
+	}
+}
+
+// VALIDATE A FOLDER'S FIELDS AND THAT ITS PARENT IS ONE OF THE USER'S WITHOUT CREATING A CYCLE
+func validateFolder(db *gorm.DB, user *models.User, folder *models.Folder) validation.Errors {
+	folder.Name = strings.TrimSpace(folder.Name)
+	errs := validation.Struct(folder)
+	errs = append(errs, validateSettingsOverride("settings", folder.Settings)...)
+	if folder.ParentID == "" {
+		return errs
+	}
+	if folder.ParentID == folder.ID {
+		return append(errs, validation.FieldError{Path: "parentId", Message: "a folder cannot be its own parent", Rule: "cycle"})
+	}
+	var count int64
+	db.Model(&models.Folder{}).Scopes(auth.Owned(user)).Where("id = ?", folder.ParentID).Count(&count)
+	if count == 0 {
+		return append(errs, validation.FieldError{Path: "parentId", Message: "folder not found", Expected: "folder id", Rule: "ref"})
+	}
+	tree, err := database.FolderTree(db, folder.ID)
+	if err == nil {
+		for _, descendant := range tree {
+			if descendant == folder.ParentID {
+				return append(errs, validation.FieldError{Path: "parentId", Message: "cannot move a folder into its own subfolder", Rule: "cycle"})
+			}
+		}
+	}
+	return errs
+}
+
+// CHECK SETTINGS A JOB OR FOLDER OVERRIDES
+func validateSettingsOverride(path string, raw any) validation.Errors {
+	if _, err := scraper.ParseSettingsOverride(raw); err != nil {
+		return validation.Errors{{
+			Path:     path,
+			Message:  err.Error(),
+			Expected: "object with timeout (ms), concurrency, storage (local or s3), proxy (http, https or socks5 URL, or direct) and/or userAgent (default, rotate or a user agent)",
+			Rule:     "type",
+		}}
+	}
+	return nil
+}
+
+// CHECK A JOB'S FOLDER REFERENCE IS ONE OF THE USER'S FOLDERS
+func validateJobFolder(db *gorm.DB, user *models.User, folderID string) validation.Errors {
+	if folderID == "" {
+		return nil
+	}
+	var count int64
+	db.Model(&models.Folder{}).Scopes(auth.Owned(user)).Where("id = ?", folderID).Count(&count)
+	if count == 0 {
+		return validation.Errors{{Path: "folderId", Message: "folder not found", Expected: "folder id", Rule: "ref"}}
+	}
+	return nil
+}
+
+// LIST TAGS IN USE WITH THE NUMBER OF JOBS CARRYING EACH
+func GetTags(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var tags []struct {
+			Tag   string `json:"tag"`
+			Count int64  `json:"count"`
+		}
+		err := db.Table("jobs, json_each(CAST(jobs.tags AS TEXT))").
+			Select("json_each.value AS tag, COUNT(*) AS count").
+			Where("json_each.type = 'text'").Scopes(auth.OwnJobs(auth.UserFrom(r.Context()))).
+			Group("json_each.value").Order("json_each.value").Scan(&tags).Error
+		if err != nil {
+			log.Printf("Failed to fetch tags: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch tags")
+			return
+		}
+		if tags == nil {
+			tags = tags[:0]
+		}
+		utils.RespondWithJSON(w, http.StatusOK, tags)
+	}
+}
+
+// RENAME A TAG ON EVERY JOB (MERGING IT INTO AN EXISTING TAG IF THE NEW NAME IS TAKEN)
+func RenameTag(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag := mux.Vars(r)["tag"]
+		var body struct {
+			Name string `json:"name" validate:"required,max=100"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if errs := validation.Struct(body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		updated, err := retagJobs(db, jobSelection{Tag: tag, user: auth.UserFrom(r.Context())}, []string{body.Name}, []string{tag}, nil)
+		if err != nil {
+			log.Printf("Failed to rename tag: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to rename tag")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true, "updated": updated})
+	}
+}
+
+// REMOVE A TAG FROM EVERY JOB
+func DeleteTag(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag := mux.Vars(r)["tag"]
+		updated, err := retagJobs(db, jobSelection{Tag: tag, user: auth.UserFrom(r.Context())}, nil, []string{tag}, nil)
+		if err != nil {
+			log.Printf("Failed to delete tag: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete tag")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true, "updated": updated})
+	}
+}
+
+// BULK RE-TAG AND/OR MOVE THE SELECTED JOBS
+func BulkUpdateJobs(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			jobSelection
+			AddTags    []string `json:"addTags"`
+			RemoveTags []string `json:"removeTags"`
+			SetTags    []string `json:"setTags"` // REPLACES ALL TAGS WHEN PRESENT
+			MoveTo     *string  `json:"moveTo"`  // FOLDER ID, "" FOR TOP LEVEL
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		body.user = auth.UserFrom(r.Context())
+		if body.MoveTo != nil {
+			if validateJobFolder(db, body.user, *body.MoveTo) != nil {
+				errs := validation.Errors{{Path: "moveTo", Message: "folder not found", Expected: "folder id", Rule: "ref"}}
+				respondWithValidationErrors(w, errs)
+				return
+			}
+		}
+		// RESOLVE THE SELECTION ONCE SO RE-TAGGING CAN'T CHANGE WHICH JOBS ARE MOVED
+		ids, err := selectJobIDs(db, body.jobSelection)
+		if errors.Is(err, errEmptySelection) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Select jobs with jobIds, folderId or tag")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to select jobs: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to select jobs")
+			return
+		}
+		updated := 0
+		if len(ids) > 0 {
+			updated, err = retagJobs(db, jobSelection{JobIDs: ids}, body.AddTags, body.RemoveTags, body.SetTags)
+			if err != nil {
+				log.Printf("Failed to bulk update jobs: %v", err)
+				utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update jobs")
+				return
+			}
+			if body.MoveTo != nil {
+				if err := db.Model(&models.Job{}).Where("id IN ?", ids).Update("folder_id", *body.MoveTo).Error; err != nil {
+					log.Printf("Failed to move jobs: %v", err)
+					utils.RespondWithError(w, http.StatusInternalServerError, "Failed to move jobs")
+					return
+				}
+				updated = len(ids)
+			}
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true, "updated": updated})
+	}
+}
+
+// START EVERY SELECTED JOB (RUNS BEYOND THE CONCURRENCY LIMITS ARE QUEUED)
+func BulkStartJobs(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var selection jobSelection
+		if errs := validation.DecodeJSON(r.Body, &selection); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		selection.user = auth.UserFrom(r.Context())
+		ids, err := selectJobIDs(db, selection)
+		if errors.Is(err, errEmptySelection) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Select jobs with jobIds, folderId or tag")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to select jobs: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to select jobs")
+			return
+		}
+		results := make(map[string]string, len(ids))
+		for _, id := range ids {
+			switch err := engine.RunJob(id); {
+			case err == nil:
+				results[id] = "started"
+			case errors.Is(err, scraper.ErrJobQueued):
+				results[id] = "queued"
+			case errors.Is(err, scraper.ErrJobAlreadyRunning):
+				results[id] = "running"
+			default:
+				log.Printf("Error starting job %s: %v", id, err)
+				results[id] = "failed"
+			}
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true, "jobs": results})
+	}
+}
+
+var errEmptySelection = errors.New("EMPTY JOB SELECTION")
+
+// RESOLVE A SELECTION TO JOB IDS; CRITERIA ARE COMBINED (IDS AND FOLDER AND TAG)
+func selectJobIDs(db *gorm.DB, sel jobSelection) ([]string, error) {
+	if len(sel.JobIDs) == 0 && sel.FolderID == "" && sel.Tag == "" {
+		return nil, errEmptySelection
+	}
+	query := db.Model(&models.Job{}).Scopes(auth.OwnJobs(sel.user))
+	if len(sel.JobIDs) > 0 {
+		query = query.Where("id IN ?", sel.JobIDs)
+	}
+	if sel.FolderID != "" {
+		tree, err := database.FolderTree(db, sel.FolderID)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("folder_id IN ?", tree)
+	}
+	if sel.Tag != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(CAST(jobs.tags AS TEXT)) WHERE json_each.value = ?)", sel.Tag)
+	}
+	var ids []string
+	err := query.Pluck("id", &ids).Error
+	return ids, err
+}
+
+// APPLY TAG CHANGES TO THE SELECTED JOBS, RETURNING HOW MANY CHANGED
+func retagJobs(db *gorm.DB, sel jobSelection, add, remove, set []string) (int, error) {
+	ids, err := selectJobIDs(db, sel)
+	if err != nil || (add == nil && remove == nil && set == nil) {
+		return 0, err
+	}
+	var jobs []models.Job
+	if len(ids) > 0 {
+		if err := db.Select("id", "tags").Where("id IN ?", ids).Find(&jobs).Error; err != nil {
+			return 0, err
+		}
+	}
+	updated := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, job := range jobs {
+			tags := editTags(job.Tags, add, remove, set)
+			if sameTags(tags, job.Tags) {
+				continue
+			}
+			if err := tx.Model(&models.Job{}).Where("id = ?", job.ID).Update("tags", tags).Error; err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// NEW TAG LIST: SET REPLACES, THEN REMOVE, THEN ADD (TRIMMED, DEDUPED, SORTED)
+func editTags(current models.JSONArray, add, remove, set []string) models.JSONArray {
+	tags := make(map[string]bool)
+	if set != nil {
+		for _, tag := range set {
+			tags[strings.TrimSpace(tag)] = true
+		}
+	} else {
+		for _, tag := range current {
+			if s, ok := tag.(string); ok {
+				tags[strings.TrimSpace(s)] = true
+			}
+		}
+	}
+	for _, tag := range remove {
+		delete(tags, strings.TrimSpace(tag))
+	}
+	for _, tag := range add {
+		tags[strings.TrimSpace(tag)] = true
+	}
+	delete(tags, "")
+
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	out := make(models.JSONArray, len(names))
+	for i, name := range names {
+		out[i] = name
+	}
+	return out
+}
+
+func sameTags(a, b models.JSONArray) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+)
+
+// A REQUEST MADE AS user (AUTH HAS ALREADY RESOLVED THEM)
+func requestAs(user *models.User, method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	return req.WithContext(auth.WithUser(req.Context(), user))
+}
+
+// LISTS ONLY HOLD THE USER'S OWN FOLDERS AND TEMPLATES, AND NOTHING CAN POINT INTO ANOTHER USER'S FOLDER
+func TestFoldersAndTemplatesAreScopedToTheirOwner(t *testing.T) {
+	db := openTestDB(t)
+	alice, err := auth.CreateUser(db, "alice", "correct horse battery staple", auth.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := auth.CreateUser(db, "bob", "correct horse battery staple", auth.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []any{
+		&models.Folder{ID: "folder_a", Name: "Alice's folder", OwnerID: alice.ID},
+		&models.Job{ID: "job_a", Name: "Alice's job", OwnerID: alice.ID, FolderID: "folder_a"},
+		&models.Job{ID: "job_b", Name: "Bob's job", OwnerID: bob.ID},
+		&models.Template{ID: "template_a", Name: "Alice's template", OwnerID: alice.ID},
+	} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	scheduler := scraper.NewScheduler(db, nil)
+
+	decode := func(rec *httptest.ResponseRecorder, into any) {
+		t.Helper()
+		if err := json.Unmarshal(rec.Body.Bytes(), into); err != nil {
+			t.Fatalf("%s: %v", rec.Body, err)
+		}
+	}
+
+	t.Run("folder list", func(t *testing.T) {
+		for user, want := range map[*models.User]int{alice: 1, bob: 0} {
+			rec := httptest.NewRecorder()
+			GetFolders(db)(rec, requestAs(user, http.MethodGet, "/api/folders", ""))
+			var folders []folderWithCount
+			decode(rec, &folders)
+			if len(folders) != want {
+				t.Errorf("%s sees %d folders; want %d", user.Username, len(folders), want)
+			}
+		}
+	})
+
+	t.Run("new folder", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		CreateFolder(db, scheduler)(rec, requestAs(bob, http.MethodPost, "/api/folders", `{"name": "Inside", "parentId": "folder_a"}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("bob made a folder inside alice's: %d %s", rec.Code, rec.Body)
+		}
+		rec = httptest.NewRecorder()
+		CreateFolder(db, scheduler)(rec, requestAs(bob, http.MethodPost, "/api/folders", `{"name": "Mine", "ownerId": "`+alice.ID+`"}`))
+		var folder models.Folder
+		decode(rec, &folder)
+		if rec.Code != http.StatusCreated || folder.OwnerID != bob.ID {
+			t.Errorf("bob's new folder = %d %+v", rec.Code, folder)
+		}
+	})
+
+	t.Run("moving jobs", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		BulkUpdateJobs(db)(rec, requestAs(bob, http.MethodPost, "/api/jobs/bulk", `{"jobIds": ["job_b"], "moveTo": "folder_a"}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("bob moved a job into alice's folder: %d %s", rec.Code, rec.Body)
+		}
+		var job models.Job
+		db.First(&job, "id = ?", "job_b")
+		if job.FolderID != "" {
+			t.Errorf("job_b moved to %q", job.FolderID)
+		}
+	})
+
+	t.Run("template list and export", func(t *testing.T) {
+		for user, want := range map[*models.User]int{alice: 1, bob: 0} {
+			rec := httptest.NewRecorder()
+			GetTemplates(db)(rec, requestAs(user, http.MethodGet, "/api/templates", ""))
+			var templates []models.Template
+			decode(rec, &templates)
+			if len(templates) != want {
+				t.Errorf("%s sees %d templates; want %d", user.Username, len(templates), want)
+			}
+
+			rec = httptest.NewRecorder()
+			ExportTemplates(db)(rec, requestAs(user, http.MethodGet, "/api/templates/export?ids=template_a", ""))
+			var doc templateExport
+			decode(rec, &doc)
+			if len(doc.Templates) != want {
+				t.Errorf("%s exported %d templates; want %d", user.Username, len(doc.Templates), want)
+			}
+		}
+	})
+
+	t.Run("imported templates", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ImportTemplates(db)(rec, requestAs(bob, http.MethodPost, "/api/templates/import",
+			`{"format": "crepes-templates", "version": 1, "templates": [{"name": "Shared", "job": {"baseUrl": "https://example.com"}}]}`))
+		var templates []models.Template
+		decode(rec, &templates)
+		if rec.Code != http.StatusCreated || len(templates) != 1 || templates[0].OwnerID != bob.ID {
+			t.Errorf("import = %d %s", rec.Code, rec.Body)
+		}
+	})
+}
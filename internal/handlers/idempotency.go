@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HOW LONG A KEY'S RESPONSE IS REPLAYED
+const idempotencyTTL = 24 * time.Hour
+
+// MAKE A STATE-CHANGING HANDLER SAFE TO RETRY WITH AN Idempotency-Key HEADER: THE FIRST
+// RESPONSE IS STORED AND REPLAYED FOR RETRIES WITH THE SAME KEY AND PAYLOAD
+func Idempotent(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientKey := r.Header.Get("Idempotency-Key")
+		if clientKey == "" {
+			next(w, r)
+			return
+		}
+		if len(clientKey) > 255 {
+			utils.RespondWithError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		// KEYS ARE SCOPED TO THE ENDPOINT (AND USER) SO ONE KEY CAN'T REPLAY ANOTHER ROUTE'S OR USER'S RESPONSE
+		key := r.Method + " " + r.URL.Path + " " + clientKey
+		if user := auth.UserFrom(r.Context()); user != nil {
+			key = user.ID + " " + key
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+
+		// FORGET EXPIRED KEYS
+		db.Where("created_at < ?", time.Now().Add(-idempotencyTTL)).Delete(&models.IdempotencyRecord{})
+
+		// CLAIM THE KEY; THE PRIMARY KEY MAKES CONCURRENT RETRIES LOSE THE RACE
+		record := models.IdempotencyRecord{Key: key, RequestHash: hash, CreatedAt: time.Now()}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			log.Printf("Failed to record idempotency key: %v", result.Error)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to record idempotency key")
+			return
+		}
+		if result.RowsAffected == 0 {
+			var existing models.IdempotencyRecord
+			if err := db.First(&existing, "key = ?", key).Error; err != nil {
+				log.Printf("Failed to load idempotency key: %v", err)
+				utils.RespondWithError(w, http.StatusInternalServerError, "Failed to record idempotency key")
+				return
+			}
+			switch {
+			case existing.RequestHash != hash:
+				utils.RespondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request payload")
+			case existing.StatusCode == 0:
+				utils.RespondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			default:
+				if existing.ContentType != "" {
+					w.Header().Set("Content-Type", existing.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write([]byte(existing.Body))
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+
+		// SERVER ERRORS ARE NOT CACHED SO THE CLIENT CAN RETRY
+		if recorder.status >= 500 {
+			db.Delete(&models.IdempotencyRecord{}, "key = ?", key)
+			return
+		}
+		db.Model(&models.IdempotencyRecord{}).Where("key = ?", key).Updates(map[string]any{
+			"status_code":  recorder.status,
+			"content_type": recorder.Header().Get("Content-Type"),
+			"body":         recorder.body.String(),
+		})
+	}
+}
+
+// CAPTURES THE STATUS AND BODY WHILE PASSING THEM THROUGH
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
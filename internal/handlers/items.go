@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// ITEM RESULTS OF ONE JOB RUN: ?runId= (DEFAULTS TO THE JOB'S LATEST RUN WITH ITEM RESULTS)
+// ?stage= AND ?status= (COMMA-SEPARATED). THE RUN IS "" WHEN THE JOB HAS NONE
+func itemResultQuery(db *gorm.DB, r *http.Request, jobID string) (*gorm.DB, string) {
+	values := r.URL.Query()
+	runID := values.Get("runId")
+	if runID == "" {
+		var latest models.ItemResult
+		db.Select("run_id").Where("job_id = ?", jobID).Order("created_at DESC").Limit(1).Find(&latest)
+		runID = latest.RunID
+	}
+	query := db.Model(&models.ItemResult{}).Where("job_id = ? AND run_id = ?", jobID, runID)
+	if list := splitList(values.Get("stage")); len(list) > 0 {
+		query = query.Where("stage IN ?", list)
+	}
+	if list := splitList(values.Get("status")); len(list) > 0 {
+		query = query.Where("status IN ?", list)
+	}
+	return query, runID
+}
+
+// LIST THE ITEMS A RUN'S LOOPS PROCESSED, IN ORDER, WITH ?limit= AND ?offset=, E.G.
+// ?status=failed FOR THE ONES THAT FAILED AND WHY
+func GetJobItemResults(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		query, runID := itemResultQuery(db, r, id)
+		var total int64
+		query.Session(&gorm.Session{}).Count(&total)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+		limit := 100
+		if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		results := []models.ItemResult{}
+		if err := query.Order("stage, item_index").Limit(limit).Offset(max(offset, 0)).Find(&results).Error; err != nil {
+			log.Printf("Failed to fetch item results: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch item results")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"runId":   runID,
+			"total":   total,
+			"data":    results,
+		})
+	}
+}
+
+// COUNT A RUN'S ITEMS BY STAGE AND STATUS, WITH THEIR AVERAGE AND LONGEST DURATIONS
+func GetJobItemSummary(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		query, runID := itemResultQuery(db, r, id)
+		type group struct {
+			Stage         string  `json:"stage"`
+			Status        string  `json:"status"`
+			Count         int64   `json:"count"`
+			AvgDurationMs float64 `json:"avgDurationMs"`
+			MaxDurationMs int64   `json:"maxDurationMs"`
+		}
+		groups := []group{}
+		if err := query.Select("stage, status, COUNT(*) AS count, AVG(duration_ms) AS avg_duration_ms, MAX(duration_ms) AS max_duration_ms").
+			Group("stage, status").Order("stage, status").Scan(&groups).Error; err != nil {
+			log.Printf("Failed to summarize item results: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to summarize item results")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"runId":   runID,
+			"data":    groups,
+		})
+	}
+}
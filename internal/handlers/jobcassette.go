@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// DOWNLOAD THE HAR FILE A JOB'S cassette RULE RECORDED, E.G. TO USE AS crepes test FIXTURES
+func GetJobCassette(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		file, err := os.Open(scraper.CassettePath(cfg, id))
+		if err != nil {
+			if os.IsNotExist(err) {
+				utils.RespondWithError(w, http.StatusNotFound, "Job has no recorded cassette")
+				return
+			}
+			log.Printf("Failed to open cassette: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to open cassette")
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to open cassette")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": id + ".har",
+		}))
+		http.ServeContent(w, r, "", info.ModTime(), file)
+	}
+}
+
+// DELETE A JOB'S CASSETTE SO ITS NEXT RUN RECORDS FROM THE SITE AGAIN
+func DeleteJobCassette(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		if engine.IsJobRunning(id) {
+			// THE RUN WOULD WRITE IT BACK WHEN IT FINISHES
+			utils.RespondWithError(w, http.StatusConflict, "Job is running")
+			return
+		}
+		cleared, err := engine.ClearCassette(id)
+		if err != nil {
+			log.Printf("Failed to clear cassette: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to clear cassette")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"cleared": cleared,
+		})
+	}
+}
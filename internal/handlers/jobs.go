@@ -1,25 +1,55 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/config"
 	"github.com/nickheyer/Crepes/internal/models"
 	"github.com/nickheyer/Crepes/internal/scraper"
 	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
 	"gorm.io/gorm"
 )
 
+// LIST JOBS: ?q= SEARCHES NAME/DESCRIPTION/BASE URL/PIPELINE, ?status= AND ?tag= TAKE COMMA-SEPARATED
+// VALUES, ?domain= MATCHES THE BASE URL HOST, ?folder= INCLUDES SUBFOLDERS, ?sort= (PREFIX - FOR DESCENDING),
+// ?filter= APPLIES A SAVED FILTER
 func GetAllJobs(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := jobSearchParams(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Saved filter not found")
+			return
+		}
+		query, err := jobSearchQuery(db.Model(&models.Job{}).Scopes(auth.OwnJobs(auth.UserFrom(r.Context()))), params)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			log.Printf("Failed to count jobs: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch jobs")
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+			query = query.Limit(limit)
+		}
+		if offset, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && offset > 0 {
+			query = query.Offset(offset)
+		}
 		var jobs []models.Job
-		result := db.Model(&models.Job{}).
-			Preload("Assets").
-			Order("created_at DESC").
-			Find(&jobs)
+		result := query.Preload("Assets").Find(&jobs)
 		if result.Error != nil {
 			log.Printf("Failed to fetch jobs: %v", result.Error)
 			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch jobs")
@@ -50,7 +80,14 @@ func GetAllJobs(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
-func GetJobByID(db *gorm.DB) http.HandlerFunc {
+// A JOB WITH THE SETTINGS ITS RUNS USE AND WHERE EACH ONE COMES FROM
+type jobDetail struct {
+	models.Job
+	EffectiveSettings scraper.JobSettings `json:"effectiveSettings"`
+	SettingsError     string              `json:"settingsError,omitempty"` // WHY AN OVERRIDE IS IGNORED
+}
+
+func GetJobByID(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		id := params["id"]
@@ -80,20 +117,45 @@ func GetJobByID(db *gorm.DB) http.HandlerFunc {
 		if job.Tags == nil {
 			job.Tags = []any{}
 		}
-		utils.RespondWithJSON(w, http.StatusOK, job)
+		settings, err := scraper.ResolveJobSettings(db, cfg, &job)
+		detail := jobDetail{Job: job, EffectiveSettings: settings.Redacted()}
+		if err != nil {
+			detail.SettingsError = err.Error()
+		}
+		utils.RespondWithJSON(w, http.StatusOK, detail)
 	}
 }
 
-func CreateJob(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
+func CreateJob(db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var job models.Job
-		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
-			log.Printf("Invalid request payload: %v", err)
-			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		if errs := validation.DecodeJSON(r.Body, &job); errs != nil {
+			log.Printf("Invalid request payload: %v", errs)
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		user := auth.UserFrom(r.Context())
+		errs := append(validateJob(engine, &job), validateJobFolder(db, user, job.FolderID)...)
+		if errs := append(errs, validateJobOwner(db, user, job.OwnerID)...); errs != nil {
+			respondWithValidationErrors(w, errs)
 			return
 		}
 		if job.ID == "" {
 			job.ID = utils.GenerateID("job")
+		} else {
+			// A CLIENT-SUPPLIED ID MAKES RETRIED CREATES RETURN THE ORIGINAL JOB
+			var existing models.Job
+			if db.Limit(1).Find(&existing, "id = ?", job.ID).RowsAffected > 0 {
+				if !auth.CanAccessJob(db, user, existing.ID) {
+					utils.RespondWithError(w, http.StatusConflict, "Job ID is taken")
+					return
+				}
+				utils.RespondWithJSON(w, http.StatusOK, existing)
+				return
+			}
+		}
+		if job.OwnerID == "" {
+			job.OwnerID = ownerOf(r)
 		}
 		job.CreatedAt = time.Now()
 		job.UpdatedAt = time.Now()
@@ -112,7 +174,7 @@ func CreateJob(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
 	}
 }
 
-func UpdateJob(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
+func UpdateJob(db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		id := params["id"]
@@ -124,9 +186,16 @@ func UpdateJob(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
 			return
 		}
 		var updatedJob models.Job
-		if err := json.NewDecoder(r.Body).Decode(&updatedJob); err != nil {
-			log.Printf("Invalid request payload for update: %v", err)
-			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		if errs := validation.DecodeJSON(r.Body, &updatedJob); errs != nil {
+			log.Printf("Invalid request payload for update: %v", errs)
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		// UPDATES ARE PARTIAL, SO MISSING FIELDS KEEP THEIR CURRENT VALUES
+		user := auth.UserFrom(r.Context())
+		errs := append(validateJob(engine, &updatedJob).Without("required"), validateJobFolder(db, user, updatedJob.FolderID)...)
+		if errs := append(errs, validateJobOwner(db, user, updatedJob.OwnerID)...); errs != nil {
+			respondWithValidationErrors(w, errs)
 			return
 		}
 		updatedJob.ID = id
@@ -173,33 +242,68 @@ func UpdateJob(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
 	}
 }
 
-func DeleteJob(db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler) http.HandlerFunc {
+// DELETE A JOB TOGETHER WITH ITS ASSETS AND THEIR FILES
+func DeleteJob(db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		id := params["id"]
+		var job models.Job
+		if err := db.First(&job, "id = ?", id).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
 		scheduler.RemoveJob(id)
 		engine.StopJob(id)
-		result := db.Delete(&models.Job{}, "id = ?", id)
-		if result.Error != nil {
-			log.Printf("Failed to delete job: %v", result.Error)
-			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete job")
+		// A RUN THAT IS STILL WINDING DOWN COULD REGISTER NEW ASSETS AFTER THE CLEANUP
+		for i := 0; i < 100 && engine.IsJobRunning(id); i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if engine.IsJobRunning(id) {
+			utils.RespondWithError(w, http.StatusConflict, "Job is still stopping; try again shortly")
 			return
 		}
-		if result.RowsAffected == 0 {
-			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+		removed, err := deleteJobAssets(db, engine, cfg, id)
+		if err != nil {
+			log.Printf("Failed to delete job assets: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete job assets")
+			return
+		}
+		if err := db.Delete(&job).Error; err != nil {
+			log.Printf("Failed to delete job: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete job")
 			return
 		}
 		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
-			"success": true,
-			"message": "Job deleted successfully",
+			"success":       true,
+			"message":       "Job deleted successfully",
+			"deletedAssets": removed,
 		})
 	}
 }
 
+// START A JOB. ?sample=N MAKES IT A SAMPLE RUN: THE FULL PIPELINE, BUT EACH WORKER-PER-ITEM
+// STAGE AND CRAWL FRONTIER STOPS AFTER N ITEMS. ?priority=low|normal|high SETS WHERE THE RUN
+// QUEUES IF IT HAS TO WAIT, OVERRIDING THE JOB'S priority RULE
 func StartJob(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		id := params["id"]
+		var opts scraper.RunOptions
+		if raw := r.URL.Query().Get("sample"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				utils.RespondWithError(w, http.StatusBadRequest, "sample must be a positive number of items")
+				return
+			}
+			opts.Sample = n
+		}
+		if priority := r.URL.Query().Get("priority"); priority != "" {
+			if !slices.Contains(scraper.QueuePriorities, priority) {
+				utils.RespondWithError(w, http.StatusBadRequest, "priority must be low, normal or high")
+				return
+			}
+			opts.Priority = priority
+		}
 		var job models.Job
 		result := db.First(&job, "id = ?", id)
 		if result.Error != nil {
@@ -207,17 +311,40 @@ func StartJob(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
 			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
 			return
 		}
-		go func() {
-			err := engine.RunJob(id)
-			if err != nil {
-				log.Printf("Error starting job %s: %v", id, err)
+		if engine.IsJobRunning(id) {
+			utils.RespondWithError(w, http.StatusConflict, "Job is already running")
+			return
+		}
+		if engine.IsJobQueued(id) {
+			utils.RespondWithError(w, http.StatusConflict, "Job is already queued")
+			return
+		}
+		err := engine.RunJobWithOptions(id, opts)
+		switch {
+		case errors.Is(err, scraper.ErrJobQueued):
+			response := map[string]any{
+				"success": true,
+				"queued":  true,
+				"sample":  opts.Sample,
+				"message": "Job queued",
 			}
-		}()
-		db.Model(&models.Job{}).Where("id = ?", id).Update("status", "running")
-		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
-			"success": true,
-			"message": "Job started successfully",
-		})
+			if run, ok := engine.QueuedRun(id); ok {
+				response["position"] = run.Position
+				response["priority"] = run.Priority
+			}
+			utils.RespondWithJSON(w, http.StatusAccepted, response)
+		case errors.Is(err, scraper.ErrJobAlreadyRunning):
+			utils.RespondWithError(w, http.StatusConflict, "Job is already running")
+		case err != nil:
+			log.Printf("Error starting job %s: %v", id, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to start job")
+		default:
+			utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+				"success": true,
+				"sample":  opts.Sample,
+				"message": "Job started successfully",
+			})
+		}
 	}
 }
 
@@ -301,3 +428,437 @@ func GetJobStatistics(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
 		})
 	}
 }
+
+func GetJobDownloads(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		id := params["id"]
+		var job models.Job
+		if err := db.Select("id").First(&job, "id = ?", id).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		downloads := engine.GetJobDownloads(id)
+		active := 0
+		for _, d := range downloads {
+			if d.Status == "queued" || d.Status == "downloading" {
+				active++
+			}
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data": map[string]any{
+				"downloads": downloads,
+				"active":    active,
+				"total":     len(downloads),
+			},
+		})
+	}
+}
+
+// CREATE AN "ARCHIVE SITE" JOB FROM A PRESET (CRAWL + WARC + SCREENSHOTS + PDFS + INDEX)
+func CreateArchiveSiteJob(db *gorm.DB, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			Schedule       string `json:"schedule"`
+			MaxPages       int    `json:"maxPages"`
+			MaxDepth       *int   `json:"maxDepth"`
+			SameHost       *bool  `json:"sameHost"`
+			IncludeSitemap *bool  `json:"includeSitemap"`
+			WARC           *bool  `json:"warc"`
+			Screenshot     *bool  `json:"screenshot"`
+			PDF            *bool  `json:"pdf"`
+			Delay          *int   `json:"delay"`
+
+			Strategy         string   `json:"strategy"`
+			PriorityPatterns []string `json:"priorityPatterns"`
+			Concurrency      *int     `json:"concurrency"`
+
+			Sitemap     string   `json:"sitemap"`
+			SitemapURLs []string `json:"sitemapUrls"`
+			Include     []string `json:"include"`
+			Exclude     []string `json:"exclude"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		// ONLY SET WHAT THE CALLER PROVIDED SO THE TASK DEFAULTS APPLY
+		taskConfig := map[string]any{"url": req.URL}
+		if req.MaxPages > 0 {
+			taskConfig["maxPages"] = req.MaxPages
+		}
+		if req.Strategy != "" {
+			taskConfig["strategy"] = req.Strategy
+		}
+		if len(req.PriorityPatterns) > 0 {
+			taskConfig["priorityPatterns"] = req.PriorityPatterns
+		}
+		if req.Sitemap != "" {
+			taskConfig["sitemap"] = req.Sitemap
+		}
+		for key, patterns := range map[string][]string{"sitemapUrls": req.SitemapURLs, "include": req.Include, "exclude": req.Exclude} {
+			if len(patterns) > 0 {
+				taskConfig[key] = patterns
+			}
+		}
+		optional := map[string]any{
+			"maxDepth":       req.MaxDepth,
+			"sameHost":       req.SameHost,
+			"includeSitemap": req.IncludeSitemap,
+			"warc":           req.WARC,
+			"screenshot":     req.Screenshot,
+			"pdf":            req.PDF,
+			"delay":          req.Delay,
+			"concurrency":    req.Concurrency,
+		}
+		for key, value := range optional {
+			switch v := value.(type) {
+			case *int:
+				if v != nil {
+					taskConfig[key] = *v
+				}
+			case *bool:
+				if v != nil {
+					taskConfig[key] = *v
+				}
+			}
+		}
+
+		// ROUND-TRIP THROUGH JSON SO NUMBERS MATCH WHAT THE PIPELINE LOADER PRODUCES
+		raw, _ := json.Marshal(taskConfig)
+		var normalized map[string]any
+		json.Unmarshal(raw, &normalized)
+		if err := (&scraper.ArchiveSiteTask{}).ValidateConfig(normalized); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		stages := []models.Stage{{
+			ID:          utils.GenerateID("stage"),
+			Name:        "Archive Site",
+			Description: "Crawl the site and capture every page as WARC, screenshot and PDF",
+			Condition:   models.Condition{Type: "always"},
+			Parallelism: models.ParallelismConfig{Mode: "sequential", MaxWorkers: 1},
+			Tasks: []models.Task{{
+				ID:        utils.GenerateID("task"),
+				Name:      "Archive Site",
+				Type:      "archiveSite",
+				Config:    normalized,
+				InputRefs: []string{},
+				Condition: models.Condition{Type: "always"},
+			}},
+		}}
+		pipeline, err := json.Marshal(stages)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to build pipeline")
+			return
+		}
+
+		name := req.Name
+		if name == "" {
+			name = "Archive: " + req.URL
+		}
+		now := time.Now()
+		job := models.Job{
+			ID:          utils.GenerateID("job"),
+			Name:        name,
+			BaseURL:     req.URL,
+			Description: "Full-site visual archive",
+			Status:      "idle",
+			Schedule:    req.Schedule,
+			Selectors:   models.JSONArray{},
+			Filters:     models.JSONArray{},
+			Rules:       models.JSONMap{},
+			Processing:  models.JSONMap{"thumbnails": true},
+			Tags:        models.JSONArray{"archive"},
+			Pipeline:    string(pipeline),
+			OwnerID:     ownerOf(r),
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := db.Create(&job).Error; err != nil {
+			log.Printf("Failed to create archive job: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create job")
+			return
+		}
+		if job.Schedule != "" {
+			scheduler.ScheduleJob(&job)
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, job)
+	}
+}
+
+// VALIDATE A JOB PAYLOAD WITHOUT SAVING IT
+func ValidateJob(engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var job models.Job
+		errs := validation.DecodeJSON(r.Body, &job)
+		if errs == nil {
+			errs = validateJob(engine, &job)
+		}
+		if errs == nil {
+			errs = validation.Errors{}
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data": map[string]any{
+				"valid":  len(errs) == 0,
+				"errors": errs,
+			},
+		})
+	}
+}
+
+// DRY-RUN A JOB: WALK ITS PIPELINE WITHOUT SIDE EFFECTS AND ESTIMATE PAGES, REQUESTS PER HOST,
+// DURATION AND STORAGE. SIMULATES A SAVED JOB (/jobs/{id}/simulate) OR A JOB PAYLOAD (/jobs/simulate)
+func SimulateJob(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var job models.Job
+		if id, ok := mux.Vars(r)["id"]; ok {
+			if err := db.First(&job, "id = ?", id).Error; err != nil {
+				utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+				return
+			}
+		} else {
+			if errs := validation.DecodeJSON(r.Body, &job); errs != nil {
+				respondWithValidationErrors(w, errs)
+				return
+			}
+			job.ID = ""
+		}
+		if errs := engine.ValidatePipeline(job.Pipeline); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+
+		var opts scraper.SimulateOptions
+		if v := r.URL.Query().Get("itemsPerList"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				utils.RespondWithError(w, http.StatusBadRequest, "itemsPerList must be a positive integer")
+				return
+			}
+			opts.ItemsPerList = n
+		}
+
+		simulation, err := engine.SimulateJob(&job, opts)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    simulation,
+		})
+	}
+}
+
+// CHECK A REQUESTED ownerId: USERS ONLY OWN WHAT THEY MAKE, ADMINS MAY GIVE JOBS TO ANY USER
+func validateJobOwner(db *gorm.DB, user *models.User, ownerID string) validation.Errors {
+	if user == nil || ownerID == "" || ownerID == user.ID {
+		return nil
+	}
+	if user.Role != auth.RoleAdmin {
+		return validation.Errors{{Path: "ownerId", Message: "only admins can give jobs to other users", Expected: "your user id", Rule: "owner"}}
+	}
+	var count int64
+	db.Model(&models.User{}).Where("id = ?", ownerID).Count(&count)
+	if count == 0 {
+		return validation.Errors{{Path: "ownerId", Message: "user not found", Expected: "user id", Rule: "ref"}}
+	}
+	return nil
+}
+
+// THE OWNER OF A JOB MADE BY THIS REQUEST ("" WHEN AUTH IS OFF)
+func ownerOf(r *http.Request) string {
+	if user := auth.UserFrom(r.Context()); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// VALIDATE JOB FIELDS AND ITS NESTED PIPELINE
+func validateJob(engine *scraper.Engine, job *models.Job) validation.Errors {
+	errs := validation.Struct(job)
+	errs = append(errs, engine.ValidatePipeline(job.Pipeline)...)
+	if schema, ok := job.Rules["recordSchema"]; ok && schema != nil {
+		if _, err := scraper.CompileRecordSchema(schema); err != nil {
+			errs = append(errs, validation.FieldError{
+				Path:     "rules.recordSchema",
+				Message:  err.Error(),
+				Expected: "JSON Schema",
+				Rule:     "schema",
+			})
+		}
+	}
+	if _, err := scraper.RecordKeyFields(job.Rules["recordKey"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.recordKey",
+			Message:  err.Error(),
+			Expected: "field name or list of field names",
+			Rule:     "type",
+		})
+	}
+	if _, err := scraper.ParseRecordAlerts(job.Rules["recordAlerts"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.recordAlerts",
+			Message:  err.Error(),
+			Expected: "list of {field, below, above, changePercent, direction}",
+			Rule:     "type",
+		})
+	}
+	if _, err := scraper.ParseAuthProfiles(job.Rules["auth"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.auth",
+			Message:  err.Error(),
+			Expected: "object of named oauth2, bearer or basic profiles",
+			Rule:     "type",
+		})
+	}
+	for _, rule := range []string{"tlsVerify", "resumeOnRestart", "persistSession"} {
+		if v, ok := job.Rules[rule]; ok && v != nil {
+			if _, isBool := v.(bool); !isBool {
+				errs = append(errs, validation.FieldError{
+					Path:     "rules." + rule,
+					Message:  "must be true or false",
+					Expected: "boolean",
+					Rule:     "type",
+				})
+			}
+		}
+	}
+	if _, err := scraper.ParseBudgetRule(job.Rules["budget"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.budget",
+			Message:  err.Error(),
+			Expected: "object with maxRequests, maxRequestsPerHost, maxRuntime (ms) and/or maxBytes",
+			Rule:     "type",
+		})
+	}
+	if _, err := scraper.ParseThrottleRule(job.Rules["throttle"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.throttle",
+			Message:  err.Error(),
+			Expected: "true, or object with minDelay and maxDelay (ms), slowFactor and/or maxErrorRate (0-1)",
+			Rule:     "type",
+		})
+	}
+	errs = append(errs, validateSettingsOverride("rules.settings", job.Rules["settings"])...)
+	if _, err := scraper.ParseCassetteRule(job.Rules["cassette"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.cassette",
+			Message:  err.Error(),
+			Expected: "auto, record, replay or off",
+			Rule:     "enum",
+		})
+	}
+	if _, err := scraper.ParseEgressPolicy(job.Rules["egress"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.egress",
+			Message:  err.Error(),
+			Expected: "object with ipVersion (4 or 6), bindAddress (ip) and/or interface (name)",
+			Rule:     "type",
+		})
+	}
+	if _, err := scraper.ParseDNSPolicy(job.Rules["dns"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.dns",
+			Message:  err.Error(),
+			Expected: "object with resolver (ip[:port]) or doh (https URL), and hosts (name to ip)",
+			Rule:     "type",
+		})
+	}
+	if v, ok := job.Rules["assetConflict"]; ok && v != nil {
+		policy, _ := v.(string)
+		if err := scraper.ValidateConflictPolicy(policy); err != nil {
+			errs = append(errs, validation.FieldError{
+				Path:     "rules.assetConflict",
+				Message:  err.Error(),
+				Expected: "overwrite, skip, version or hash",
+				Rule:     "oneof",
+			})
+		}
+	}
+	if _, err := scraper.ParseThumbnailTimestamp(job.Rules["thumbnailTimestamp"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.thumbnailTimestamp",
+			Message:  err.Error(),
+			Expected: "seconds (number) or a clock time like 1:30",
+			Rule:     "type",
+		})
+	}
+	if v, ok := job.Rules["audioThumbnail"]; ok && v != nil {
+		style, _ := v.(string)
+		if err := scraper.ValidateAudioThumbnailStyle(style); err != nil {
+			errs = append(errs, validation.FieldError{
+				Path:     "rules.audioThumbnail",
+				Message:  err.Error(),
+				Expected: "waveform or spectrogram",
+				Rule:     "oneof",
+			})
+		}
+	}
+	if _, err := scraper.ParsePIIPolicy(job.Rules["pii"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.pii",
+			Message:  err.Error(),
+			Expected: "object with action (redact, hash or flag), types, fields and patterns",
+			Rule:     "type",
+		})
+	}
+	if _, err := scraper.ParseCompliance(job.Rules["compliance"]); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.compliance",
+			Message:  err.Error(),
+			Expected: "object with purpose, dataCategory, retentionDays, tosReviewed, tosReviewedBy, tosReviewedAt and notes",
+			Rule:     "type",
+		})
+	}
+	if err := engine.ValidateJobHeaders(job.Rules); err != nil {
+		errs = append(errs, validation.FieldError{
+			Path:     "rules.headers",
+			Message:  err.Error(),
+			Expected: "object of header names to values, with an optional headerProfile name or list",
+			Rule:     "type",
+		})
+	}
+	if job.BaseURL != "" {
+		// NAMES THAT DON'T RESOLVE YET ARE LEFT FOR THE RUN TO REPORT
+		if err := engine.CheckURL(context.Background(), job.BaseURL); scraper.IsBlocked(err) {
+			errs = append(errs, validation.FieldError{
+				Path:     "baseUrl",
+				Message:  err.Error(),
+				Expected: "URL of a host not blocked by the ssrf or domain settings",
+				Rule:     "ssrf",
+			})
+		}
+	}
+	if raw, ok := job.Rules["session"]; ok && raw != nil {
+		var pipeline []models.Stage
+		json.Unmarshal([]byte(job.Pipeline), &pipeline) // PIPELINE ERRORS ARE REPORTED ABOVE
+		if _, err := scraper.ParseSessionRule(raw, pipeline); err != nil {
+			errs = append(errs, validation.FieldError{
+				Path:     "rules.session",
+				Message:  err.Error(),
+				Expected: "{loginStage, url, selector, loggedOutSelector, loginUrl, stages, maxRelogins, timeout}",
+				Rule:     "type",
+			})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// RESPOND WITH FIELD-LEVEL VALIDATION ERRORS
+func respondWithValidationErrors(w http.ResponseWriter, errs validation.Errors) {
+	utils.RespondWithJSON(w, http.StatusBadRequest, map[string]any{
+		"error":   "Validation failed",
+		"details": errs,
+	})
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/database"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// SEARCH PARAMETERS ACCEPTED BY GET /jobs AND STORED IN SAVED FILTERS
+var jobSearchKeys = []string{"q", "status", "tag", "domain", "folder", "sort"}
+
+// SORTABLE JOB FIELDS AND THEIR COLUMNS
+var jobSortColumns = map[string]string{
+	"name":      "name",
+	"status":    "status",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+	"lastRun":   "last_run",
+	"nextRun":   "next_run",
+}
+
+// USER OWNING SAVED FILTERS: THE SIGNED-IN USER, OR WHOEVER THE CLIENT SAYS WHEN AUTH IS OFF
+func currentUser(r *http.Request) string {
+	if user := auth.UserFrom(r.Context()); user != nil {
+		return user.ID
+	}
+	return r.Header.Get("X-Crepes-User")
+}
+
+// SEARCH PARAMETERS FROM THE SAVED FILTER (IF ANY), OVERRIDDEN BY THE QUERY STRING
+func jobSearchParams(db *gorm.DB, r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+	values := r.URL.Query()
+	if filterID := values.Get("filter"); filterID != "" {
+		var filter models.SavedFilter
+		if err := db.First(&filter, "id = ? AND user_id = ?", filterID, currentUser(r)).Error; err != nil {
+			return nil, err
+		}
+		for _, key := range jobSearchKeys {
+			if value, ok := filter.Query[key].(string); ok && value != "" {
+				params[key] = value
+			}
+		}
+	}
+	for _, key := range jobSearchKeys {
+		if value := strings.TrimSpace(values.Get(key)); value != "" {
+			params[key] = value
+		}
+	}
+	return params, nil
+}
+
+// APPLY SEARCH PARAMETERS TO A JOB QUERY
+func jobSearchQuery(query *gorm.DB, params map[string]string) (*gorm.DB, error) {
+	// EVERY TERM MUST APPEAR IN ONE OF THE TEXT FIELDS
+	for _, term := range strings.Fields(params["q"]) {
+		like := "%" + escapeLike(term) + "%"
+		query = query.Where(
+			"name LIKE ? ESCAPE '\\' OR description LIKE ? ESCAPE '\\' OR base_url LIKE ? ESCAPE '\\' OR pipeline LIKE ? ESCAPE '\\'",
+			like, like, like, like,
+		)
+	}
+	if statuses := splitList(params["status"]); len(statuses) > 0 {
+		query = query.Where("status IN ?", statuses)
+	}
+	// JOBS MUST CARRY EVERY REQUESTED TAG
+	for _, tag := range splitList(params["tag"]) {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(CAST(jobs.tags AS TEXT)) WHERE json_each.value = ?)", tag)
+	}
+	// THE HOST OR ANY SUBDOMAIN OF IT, WITH OR WITHOUT A PORT OR PATH
+	if domain := strings.ToLower(strings.TrimPrefix(params["domain"], ".")); domain != "" {
+		var clauses []string
+		var args []any
+		for _, prefix := range []string{"://", "."} {
+			host := prefix + escapeLike(domain)
+			for _, suffix := range []string{"", "/%", ":%", "?%"} {
+				clauses = append(clauses, "LOWER(base_url) LIKE ? ESCAPE '\\'")
+				args = append(args, "%"+host+suffix)
+			}
+		}
+		query = query.Where(strings.Join(clauses, " OR "), args...)
+	}
+
+	// A FOLDER AND ITS SUBFOLDERS, OR "none" FOR JOBS OUTSIDE ANY FOLDER
+	switch folder := params["folder"]; folder {
+	case "":
+	case "none":
+		query = query.Where("folder_id = '' OR folder_id IS NULL")
+	default:
+		tree, err := database.FolderTree(query.Session(&gorm.Session{NewDB: true}), folder)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("folder_id IN ?", tree)
+	}
+
+	sortBy := params["sort"]
+	if sortBy == "" {
+		sortBy = "-createdAt"
+	}
+	direction := "ASC"
+	if strings.HasPrefix(sortBy, "-") {
+		direction = "DESC"
+		sortBy = sortBy[1:]
+	}
+	column, ok := jobSortColumns[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("Invalid sort field %q", sortBy)
+	}
+	return query.Order(column + " " + direction), nil
+}
+
+// ESCAPE LIKE WILDCARDS IN USER INPUT
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
+// SPLIT A COMMA-SEPARATED PARAMETER
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// LIST THE CURRENT USER'S SAVED JOB FILTERS
+func GetSavedFilters(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var filters []models.SavedFilter
+		if err := db.Where("user_id = ?", currentUser(r)).Order("name").Find(&filters).Error; err != nil {
+			log.Printf("Failed to fetch saved filters: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch saved filters")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, filters)
+	}
+}
+
+// SAVE A NAMED JOB FILTER FOR THE CURRENT USER
+func CreateSavedFilter(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var filter models.SavedFilter
+		if errs := validation.DecodeJSON(r.Body, &filter); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		errs := validation.Struct(filter)
+		// ONLY KNOWN SEARCH PARAMETERS, AND THE SORT FIELD MUST BE VALID
+		params := make(map[string]string)
+		for key, value := range filter.Query {
+			str, ok := value.(string)
+			switch {
+			case !isJobSearchKey(key):
+				errs = append(errs, validation.FieldError{
+					Path:     "query." + key,
+					Message:  "unknown search parameter",
+					Expected: "one of: " + strings.Join(jobSearchKeys, ", "),
+					Rule:     "oneof",
+				})
+			case !ok:
+				errs = append(errs, validation.FieldError{
+					Path:     "query." + key,
+					Message:  "expected string but got " + validation.ValueTypeName(value),
+					Expected: "string",
+					Rule:     "type",
+				})
+			default:
+				params[key] = str
+			}
+		}
+		if _, err := jobSearchQuery(db.Model(&models.Job{}).Scopes(auth.OwnJobs(auth.UserFrom(r.Context()))), params); err != nil {
+			errs = append(errs, validation.FieldError{Path: "query.sort", Message: err.Error(), Rule: "oneof"})
+		}
+		if errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+
+		filter.ID = utils.GenerateID("filter")
+		filter.UserID = currentUser(r)
+		if err := db.Create(&filter).Error; err != nil {
+			log.Printf("Failed to save filter: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save filter")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, filter)
+	}
+}
+
+// DELETE ONE OF THE CURRENT USER'S SAVED FILTERS
+func DeleteSavedFilter(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		result := db.Delete(&models.SavedFilter{}, "id = ? AND user_id = ?", id, currentUser(r))
+		if result.Error != nil {
+			log.Printf("Failed to delete saved filter: %v", result.Error)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete saved filter")
+			return
+		}
+		if result.RowsAffected == 0 {
+			utils.RespondWithError(w, http.StatusNotFound, "Saved filter not found")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"message": "Saved filter deleted successfully",
+		})
+	}
+}
+
+func isJobSearchKey(key string) bool {
+	for _, k := range jobSearchKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
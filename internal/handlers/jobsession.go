@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// A STORED COOKIE, WITHOUT ITS VALUE
+type sessionCookie struct {
+	Name     string     `json:"name"`
+	Domain   string     `json:"domain"`
+	Path     string     `json:"path"`
+	Expires  *time.Time `json:"expires"` // NIL FOR A SESSION COOKIE
+	HttpOnly bool       `json:"httpOnly"`
+	Secure   bool       `json:"secure"`
+}
+
+// LOCAL STORAGE KEYS STORED FOR AN ORIGIN
+type sessionOrigin struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+// DESCRIBE THE COOKIES AND LOCAL STORAGE A JOB CARRIES BETWEEN RUNS (VALUES ARE NEVER RETURNED)
+func GetJobSession(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		state, record, err := engine.LoadJobSession(id)
+		if record == nil {
+			if err != nil {
+				log.Printf("Failed to fetch job session: %v", err)
+				utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch job session")
+				return
+			}
+			utils.RespondWithError(w, http.StatusNotFound, "Job has no stored session")
+			return
+		}
+		cookies := []sessionCookie{}
+		origins := []sessionOrigin{}
+		if state != nil {
+			for _, c := range state.Cookies {
+				cookie := sessionCookie{Name: c.Name, Domain: c.Domain, Path: c.Path, HttpOnly: c.HttpOnly, Secure: c.Secure}
+				if c.Expires > 0 {
+					expires := time.Unix(int64(c.Expires), 0)
+					cookie.Expires = &expires
+				}
+				cookies = append(cookies, cookie)
+			}
+			for _, o := range state.Origins {
+				origin := sessionOrigin{Origin: o.Origin, Keys: []string{}}
+				for _, item := range o.LocalStorage {
+					origin.Keys = append(origin.Keys, item.Name)
+				}
+				origins = append(origins, origin)
+			}
+		}
+		data := map[string]any{
+			"session": record,
+			"cookies": cookies,
+			"origins": origins,
+		}
+		if err != nil {
+			// STORED BUT UNREADABLE, E.G. SEALED UNDER A SECRET THAT HAS SINCE CHANGED
+			data["error"] = err.Error()
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
+
+// FORGET A JOB'S STORED SESSION SO ITS NEXT RUN STARTS LOGGED OUT
+func DeleteJobSession(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		cleared, err := engine.ClearJobSession(id)
+		if err != nil {
+			log.Printf("Failed to clear job session: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to clear job session")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"cleared": cleared,
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// FIND THE JOB NAMED IN THE PATH, RESPONDING 404 WHEN IT DOESN'T EXIST
+func jobFromPath(w http.ResponseWriter, r *http.Request, db *gorm.DB) (string, bool) {
+	id := mux.Vars(r)["id"]
+	var job models.Job
+	if err := db.Select("id").First(&job, "id = ?", id).Error; err != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+		return "", false
+	}
+	return id, true
+}
+
+// LIST THE STATE A JOB KEEPS BETWEEN RUNS
+func GetJobState(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		entries, err := engine.JobState(id)
+		if err != nil {
+			log.Printf("Failed to fetch job state: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch job state")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    entries,
+		})
+	}
+}
+
+// SET ONE KEY OF A JOB'S STATE FROM {"value": ...}, E.G. TO REWIND A CURSOR
+func PutJobState(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		key := mux.Vars(r)["key"]
+		if err := scraper.ValidateStateKey(key); err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "key", Message: "must be 1-200 characters", Rule: "max"}})
+			return
+		}
+		var request struct {
+			Value json.RawMessage `json:"value"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &request); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if request.Value == nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "value", Message: "is required", Rule: "required"}})
+			return
+		}
+		var value any
+		if err := json.Unmarshal(request.Value, &value); err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "value", Message: "must be JSON", Rule: "type"}})
+			return
+		}
+		entry, err := engine.SetJobState(id, "", key, value)
+		if err != nil {
+			log.Printf("Failed to save job state %s: %v", key, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save job state")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    entry,
+		})
+	}
+}
+
+// CLEAR ONE KEY OF A JOB'S STATE, OR ALL OF IT WHEN NO KEY IS GIVEN, SO THE NEXT RUN STARTS OVER
+func DeleteJobState(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		key := mux.Vars(r)["key"]
+		cleared, err := engine.DeleteJobState(id, key)
+		if err != nil {
+			log.Printf("Failed to clear job state: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to clear job state")
+			return
+		}
+		if key != "" && cleared == 0 {
+			utils.RespondWithError(w, http.StatusNotFound, "State key not found")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"cleared": cleared,
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// CONVERT A LEGACY SELECTOR JOB INTO A PIPELINE AND SAVE IT (ITS SELECTORS ARE KEPT). WITH
+// ?preview=true THE GENERATED STAGES, RULES AND NOTES ARE RETURNED WITHOUT SAVING
+func MigrateLegacyJob(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var job models.Job
+		if err := db.First(&job, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		preview := false
+		if raw := r.URL.Query().Get("preview"); raw != "" {
+			value, err := strconv.ParseBool(raw)
+			if err != nil {
+				respondWithValidationErrors(w, validation.Errors{{Path: "preview", Message: "must be true or false", Expected: "boolean", Rule: "type"}})
+				return
+			}
+			preview = value
+		}
+		if !scraper.IsLegacyJob(&job) {
+			utils.RespondWithError(w, http.StatusConflict, "Job already has a pipeline or has no selectors to convert")
+			return
+		}
+
+		conversion, err := scraper.ConvertLegacyJob(&job)
+		if errors.Is(err, scraper.ErrInvalidInput) {
+			path := "selectors"
+			if strings.Contains(err.Error(), "baseUrl") {
+				path = "baseUrl"
+			}
+			respondWithValidationErrors(w, validation.Errors{{Path: path, Message: strings.ToLower(err.Error()), Expected: "legacy job", Rule: "convert"}})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to convert job %s: %v", job.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to convert job")
+			return
+		}
+		pipeline, err := json.Marshal(conversion.Stages)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to convert job")
+			return
+		}
+		job.Pipeline = string(pipeline)
+		job.Rules = conversion.Rules
+
+		// A CONVERSION THAT WOULDN'T SAVE IS A CONVERTER BUG, NOT THE CALLER'S
+		if errs := validateJob(engine, &job); errs != nil {
+			utils.RespondWithJSON(w, http.StatusUnprocessableEntity, map[string]any{
+				"error":   "Converted pipeline is invalid",
+				"details": errs,
+			})
+			return
+		}
+
+		if !preview {
+			job.UpdatedAt = time.Now()
+			if err := db.Model(&job).Select("pipeline", "rules", "updated_at").Updates(&job).Error; err != nil {
+				log.Printf("Failed to save converted job %s: %v", job.ID, err)
+				utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save converted job")
+				return
+			}
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"saved":  !preview,
+			"stages": conversion.Stages,
+			"rules":  conversion.Rules,
+			"notes":  conversion.Notes,
+			"job":    job,
+		})
+	}
+}
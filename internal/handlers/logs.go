@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// MOST LINES ONE REQUEST CAN TAIL, AND HOW FAR BACK INTO THE LOG IT LOOKS
+const (
+	maxTailLines = 5000
+	maxTailBytes = 16 << 20
+)
+
+// THE END OF THE APPLICATION LOG: ?lines= (DEFAULT 200) AND ?contains= TO KEEP ONLY LINES WITH
+// SOME TEXT (CASE-INSENSITIVE), PLUS THE ROTATED FILES KEPT BESIDE IT
+func GetAppLog(engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		appLog := engine.AppLog()
+		if appLog == nil {
+			utils.RespondWithError(w, http.StatusNotFound, "The application log is not being written to a file")
+			return
+		}
+		lines := 200
+		if raw := r.URL.Query().Get("lines"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxTailLines {
+				respondWithValidationErrors(w, validation.Errors{{Path: "query.lines", Message: "must be a number from 1 to " + strconv.Itoa(maxTailLines), Expected: "integer", Rule: "max"}})
+				return
+			}
+			lines = n
+		}
+		var match func(string) bool
+		if contains := strings.ToLower(r.URL.Query().Get("contains")); contains != "" {
+			match = func(line string) bool { return strings.Contains(strings.ToLower(line), contains) }
+		}
+
+		tail, err := utils.TailLines(appLog.Path(), lines, maxTailBytes, match)
+		if err != nil {
+			log.Printf("Failed to read the application log: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to read the application log")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data": map[string]any{
+				"size":    appLog.Size(),
+				"lines":   tail,
+				"backups": appLog.Backups(),
+			},
+		})
+	}
+}
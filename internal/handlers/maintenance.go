@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// THE MAINTENANCE TASKS, WHEN THE NEXT SCHEDULED PASS RUNS AND WHAT THE LAST PASS DID
+func GetMaintenance(engine *scraper.Engine, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]any{
+			"tasks":   scraper.MaintenanceTasks,
+			"nextRun": nil,
+			"last":    engine.LastMaintenance(),
+		}
+		if next := scheduler.NextMaintenance(); !next.IsZero() {
+			data["nextRun"] = next
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
+
+// RUN MAINTENANCE NOW: {"tasks": ["vacuum", "analyze"]}, OR EVERY TASK WITHOUT A BODY
+func RunMaintenance(engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Tasks []string `json:"tasks"`
+		}
+		if r.ContentLength != 0 {
+			if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+				respondWithValidationErrors(w, errs)
+				return
+			}
+		}
+		var errs validation.Errors
+		for i, task := range body.Tasks {
+			if !slices.Contains(scraper.MaintenanceTasks, task) {
+				errs = append(errs, validation.FieldError{
+					Path:     fmt.Sprintf("tasks[%d]", i),
+					Message:  "unknown maintenance task",
+					Expected: strings.Join(scraper.MaintenanceTasks, ", "),
+					Rule:     "oneof",
+				})
+			}
+		}
+		if len(errs) > 0 {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+
+		report, err := engine.RunMaintenance(body.Tasks, "api")
+		if errors.Is(err, scraper.ErrMaintenanceRunning) {
+			utils.RespondWithError(w, http.StatusConflict, "Maintenance is already running")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to run maintenance: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to run maintenance")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    report,
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// LIST THE SITE PRESETS (BUILT-IN AND FROM THE DATA DIRECTORY'S presets FOLDER)
+func GetSitePresets(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.RespondWithJSON(w, http.StatusOK, scraper.LoadSitePresets(cfg.DataPath))
+	}
+}
+
+// A JOB DRAFT FROM A SITE PRESET FOR ?url= (OPTIONALLY ?name= AND ?maxPages=), TO EDIT AND SAVE
+// WITH POST /jobs. NOTHING IS SAVED HERE
+func GetSitePresetJob(engine *scraper.Engine, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		preset, ok := scraper.FindSitePreset(cfg.DataPath, mux.Vars(r)["id"])
+		if !ok {
+			utils.RespondWithError(w, http.StatusNotFound, "Preset not found")
+			return
+		}
+		query := r.URL.Query()
+		options := scraper.PresetJobOptions{URL: query.Get("url"), Name: query.Get("name")}
+		if raw := query.Get("maxPages"); raw != "" {
+			pages, err := strconv.Atoi(raw)
+			if err != nil || pages < 1 {
+				respondWithValidationErrors(w, validation.Errors{{Path: "maxPages", Message: "must be a whole number of at least 1", Expected: "integer", Rule: "min"}})
+				return
+			}
+			options.MaxPages = pages
+		}
+
+		job, err := scraper.BuildPresetJob(preset, options)
+		if errors.Is(err, scraper.ErrInvalidInput) {
+			respondWithValidationErrors(w, validation.Errors{{Path: "url", Message: "must be an http(s) URL", Expected: "url", Rule: "url"}})
+			return
+		}
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to build job from preset")
+			return
+		}
+		// A DRAFT THAT WOULDN'T SAVE MEANS THE PRESET ITSELF IS WRONG
+		if errs := validateJob(engine, &job); errs != nil {
+			utils.RespondWithJSON(w, http.StatusUnprocessableEntity, map[string]any{
+				"error":   "Preset " + preset.ID + " makes an invalid job",
+				"details": errs,
+			})
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, job)
+	}
+}
@@ -7,10 +7,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/scraper"
 	"github.com/nickheyer/Crepes/internal/utils"
 )
 
-func ProxyHandler() http.HandlerFunc {
+var proxyDeadlines = scraper.DeadlinePolicy{
+	ConnectTimeout: 10 * time.Second,
+	StallTimeout:   10 * time.Second,
+	MaxDuration:    30 * time.Second,
+}
+
+func ProxyHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		targetURLStr := r.URL.Query().Get("url")
 		if targetURLStr == "" {
@@ -22,34 +30,47 @@ func ProxyHandler() http.HandlerFunc {
 			utils.RespondWithError(w, http.StatusBadRequest, "Invalid URL provided")
 			return
 		}
-		client := &http.Client{
-			Timeout: 10 * time.Second,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				if len(via) >= 10 {
-					return http.ErrUseLastResponse
-				}
-				return nil
-			},
+		guard := scraper.NetGuardFromConfig(cfg)
+		if err := guard.CheckURL(r.Context(), targetURLStr); scraper.IsBlocked(err) {
+			utils.RespondWithError(w, http.StatusForbidden, "URL points to a blocked address or domain")
+			return
+		}
+		client := scraper.NewHTTPClient(scraper.HTTPClientOptions{
+			Fingerprint: cfg.TLSFingerprint,
+			HTTPVersion: cfg.HTTPVersion,
+			TLS:         scraper.TLSPolicyFromConfig(cfg),
+			Guard:       guard,
+			DNS:         scraper.DNSPolicyFromConfig(cfg),
+			Egress:      scraper.EgressPolicyFromConfig(cfg),
+		})
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return http.ErrUseLastResponse
+			}
+			return nil
 		}
 		proxyReq, err := http.NewRequest(http.MethodGet, targetURLStr, nil)
 		if err != nil {
 			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create request")
 			return
 		}
-		proxyReq.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+		proxyReq.Header.Set("User-Agent", scraper.DefaultUserAgent())
 		proxyReq.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
 		proxyReq.Header.Set("Accept-Language", "en-US,en;q=0.5")
 		proxyReq.Header.Set("Connection", "keep-alive")
 		proxyReq.Header.Set("Upgrade-Insecure-Requests", "1")
-		resp, err := client.Do(proxyReq)
+		deadline := proxyDeadlines.Start(r.Context())
+		defer deadline.Stop()
+		resp, err := client.Do(proxyReq.WithContext(deadline.Context()))
 		if err != nil {
-			utils.RespondWithError(w, http.StatusBadGateway, "Failed to fetch URL: "+err.Error())
+			utils.RespondWithError(w, http.StatusBadGateway, "Failed to fetch URL: "+deadline.Err(err).Error())
 			return
 		}
 		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
+		deadline.Connected()
+		body, err := io.ReadAll(deadline.Reader(resp.Body))
 		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to read response body")
+			utils.RespondWithError(w, http.StatusBadGateway, "Failed to read response body: "+deadline.Err(err).Error())
 			return
 		}
 		for key, values := range resp.Header {
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// UPCOMING SCHEDULED RUN, WITH THE REASON IT WILL WAIT IF ONE IS ALREADY KNOWN
+type scheduledQueueRun struct {
+	scraper.ScheduledRun
+	Name           string     `json:"name"`
+	Reason         string     `json:"reason,omitempty"`
+	Detail         string     `json:"detail,omitempty"`
+	EstimatedStart *time.Time `json:"estimatedStart,omitempty"`
+}
+
+// GET QUEUED, RUNNING AND SCHEDULED RUNS
+func GetQueue(db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := engine.QueueStatus()
+
+		upcoming := scheduler.Upcoming()
+		ids := make([]string, 0, len(status.Running)+len(status.Queued)+len(upcoming))
+		for _, run := range status.Running {
+			ids = append(ids, run.JobID)
+		}
+		for _, run := range status.Queued {
+			ids = append(ids, run.JobID)
+		}
+		for _, run := range upcoming {
+			if run.JobID != "" {
+				ids = append(ids, run.JobID)
+			}
+		}
+		user := auth.UserFrom(r.Context())
+		names := make(map[string]string)
+		if len(ids) > 0 {
+			var jobs []models.Job
+			db.Scopes(auth.OwnJobs(user)).Select("id", "name").Where("id IN ?", ids).Find(&jobs)
+			for _, job := range jobs {
+				names[job.ID] = job.Name
+			}
+		}
+		if !auth.SeesAll(user) {
+			// ONLY THE USER'S OWN RUNS; POSITIONS STAY THOSE IN THE SHARED QUEUE
+			notOwn := func(jobID string) bool {
+				_, ok := names[jobID]
+				return !ok
+			}
+			status.Running = slices.DeleteFunc(status.Running, func(run scraper.RunningRun) bool { return notOwn(run.JobID) })
+			status.Queued = slices.DeleteFunc(status.Queued, func(run scraper.QueuedRun) bool { return notOwn(run.JobID) })
+			upcoming = slices.DeleteFunc(upcoming, func(run scraper.ScheduledRun) bool { return notOwn(run.JobID) })
+		}
+
+		var folders []models.Folder
+		db.Scopes(auth.Owned(user)).Select("id", "name").Find(&folders)
+		folderNames := make(map[string]string, len(folders))
+		for _, folder := range folders {
+			folderNames[folder.ID] = folder.Name
+		}
+
+		for i := range status.Running {
+			status.Running[i].Name = names[status.Running[i].JobID]
+		}
+
+		scheduled := make([]scheduledQueueRun, 0, len(upcoming))
+		for _, run := range upcoming {
+			entry := scheduledQueueRun{ScheduledRun: run, Name: names[run.JobID]}
+			if run.FolderID != "" {
+				entry.Name = folderNames[run.FolderID]
+			}
+			if until, ok := engine.BlackoutAt(run.NextRun); ok {
+				entry.Reason = scraper.WaitBlackout
+				entry.Detail = fmt.Sprintf("falls in a blackout window until %s", until.Format("15:04"))
+				entry.EstimatedStart = &until
+			}
+			scheduled = append(scheduled, entry)
+		}
+
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"running":   status.Running,
+			"queued":    status.Queued,
+			"scheduled": scheduled,
+			"limits":    status.Limits,
+		})
+	}
+}
+
+// WHERE A JOB WAITS IN THE QUEUE: ITS POSITION, PRIORITY, WHY IT WAITS AND WHEN IT SHOULD START
+func GetJobQueuePosition(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		run, queued := engine.QueuedRun(id)
+		if !queued {
+			utils.RespondWithError(w, http.StatusNotFound, "Job is not queued")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    run,
+		})
+	}
+}
+
+// MOVE A QUEUED JOB TO ANOTHER PRIORITY: {"priority": "high"}
+func UpdateJobQueuePriority(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		var body struct {
+			Priority string `json:"priority" validate:"required,oneof=low normal high"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if errs := validation.Struct(body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if _, err := engine.SetQueuePriority(id, body.Priority); errors.Is(err, scraper.ErrJobNotQueued) {
+			utils.RespondWithError(w, http.StatusNotFound, "Job is not queued")
+			return
+		} else if err != nil {
+			log.Printf("Failed to change queue priority of job %s: %v", id, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to change queue priority")
+			return
+		}
+		run, _ := engine.QueuedRun(id)
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    run,
+		})
+	}
+}
@@ -0,0 +1,526 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// FILTER RECORDS BY ?jobId= ?source= ?key= (COMMA-SEPARATED), ?since= / ?until= (RFC 3339),
+// ?changed=true (DATA CHANGED SINCE FIRST SEEN), ?pii=true (PII FLAGGED BY THE JOB'S pii RULE)
+// AND ?q= (SEARCH IN THE DATA AND URL)
+func recordQuery(db *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	values := r.URL.Query()
+	query := db.Model(&models.Record{}).Scopes(auth.OwnJobRows(auth.UserFrom(r.Context())))
+	if list := splitList(values.Get("jobId")); len(list) > 0 {
+		query = query.Where("job_id IN ?", list)
+	}
+	if list := splitList(values.Get("source")); len(list) > 0 {
+		query = query.Where("source IN ?", list)
+	}
+	if list := splitList(values.Get("key")); len(list) > 0 {
+		query = query.Where(map[string]any{"key": list})
+	}
+	if values.Get("changed") == "true" {
+		query = query.Where("changes > 0")
+	}
+	if values.Get("pii") == "true" {
+		query = query.Where("pii IS NOT NULL AND pii <> '[]'")
+	}
+	for param, op := range map[string]string{"since": ">=", "until": "<="} {
+		if value := values.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, err
+			}
+			query = query.Where("created_at "+op+" ?", t)
+		}
+	}
+	if q := strings.TrimSpace(values.Get("q")); q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		query = query.Where("(CAST(data AS TEXT) LIKE ? ESCAPE '\\' OR url LIKE ? ESCAPE '\\')", pattern, pattern)
+	}
+	return query, nil
+}
+
+// LIST RECORDS, NEWEST FIRST, WITH ?limit= AND ?offset=
+func GetRecords(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := recordQuery(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid since/until time (expected RFC 3339)")
+			return
+		}
+		var total int64
+		query.Session(&gorm.Session{}).Count(&total)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+		limit := 100
+		if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		records := []models.Record{}
+		if err := query.Order("created_at DESC").Limit(limit).Offset(max(offset, 0)).Find(&records).Error; err != nil {
+			log.Printf("Failed to fetch records: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch records")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, records)
+	}
+}
+
+// GET ONE RECORD
+func GetRecord(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var record models.Record
+		if err := db.First(&record, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Record not found")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, record)
+	}
+}
+
+// CHANGE HISTORY OF ONE RECORD, OLDEST FIRST: EACH ENTRY LISTS THE FIELDS THAT CHANGED IN A RUN
+// WITH THEIR OLD AND NEW VALUES
+func GetRecordHistory(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var record models.Record
+		if err := db.Select("id").First(&record, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Record not found")
+			return
+		}
+		changes := []models.RecordChange{}
+		if err := db.Where("record_id = ?", record.ID).Order("created_at ASC").Find(&changes).Error; err != nil {
+			log.Printf("Failed to fetch record history: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch record history")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, changes)
+	}
+}
+
+// DELETE ONE RECORD AND ITS HISTORY
+func DeleteRecord(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		result := db.Delete(&models.Record{}, "id = ?", id)
+		if result.Error == nil {
+			result.Error = db.Where("record_id = ?", id).Delete(&models.RecordChange{}).Error
+		}
+		if result.Error != nil {
+			log.Printf("Failed to delete record: %v", result.Error)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete record")
+			return
+		}
+		if result.RowsAffected == 0 {
+			utils.RespondWithError(w, http.StatusNotFound, "Record not found")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true})
+	}
+}
+
+// A RECORD AS EXPORTED, WITH ITS JOB'S COMPLIANCE METADATA AND WHEN RETENTION DELETES IT
+type exportedRecord struct {
+	models.Record
+	Compliance  *scraper.Compliance `json:"compliance,omitempty"`
+	RetainUntil *time.Time          `json:"retainUntil,omitempty"`
+}
+
+// PAIR RECORDS WITH THEIR JOBS' COMPLIANCE METADATA
+func withCompliance(db *gorm.DB, records []models.Record) []exportedRecord {
+	seen := make(map[string]bool)
+	var jobIDs []string
+	for _, record := range records {
+		if !seen[record.JobID] {
+			seen[record.JobID] = true
+			jobIDs = append(jobIDs, record.JobID)
+		}
+	}
+	compliance := make(map[string]*scraper.Compliance)
+	if len(jobIDs) > 0 {
+		var jobs []models.Job
+		db.Select("id", "rules").Where("id IN ?", jobIDs).Find(&jobs)
+		for i := range jobs {
+			compliance[jobs[i].ID] = scraper.JobCompliance(&jobs[i])
+		}
+	}
+	exported := make([]exportedRecord, len(records))
+	for i, record := range records {
+		exported[i] = exportedRecord{Record: record, Compliance: compliance[record.JobID]}
+		if until := exported[i].Compliance.RetainUntil(record.LastSeen); !until.IsZero() {
+			exported[i].RetainUntil = &until
+		}
+	}
+	return exported
+}
+
+// EXPORT THE FILTERED RECORDS AS ?format=json (DEFAULT), jsonl OR csv, EACH WITH ITS JOB'S COMPLIANCE
+// METADATA. CSV COLUMNS ARE THE RECORD METADATA (AND COMPLIANCE, WHEN ANY JOB HAS IT) FOLLOWED BY
+// EVERY DATA FIELD SEEN, SORTED; NESTED VALUES ARE WRITTEN AS JSON
+func ExportRecords(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		contentTypes := map[string]string{
+			"json":  "application/json",
+			"jsonl": "application/x-ndjson",
+			"csv":   "text/csv; charset=utf-8",
+		}
+		contentType, ok := contentTypes[format]
+		if !ok {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid format (expected json, jsonl or csv)")
+			return
+		}
+		query, err := recordQuery(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid since/until time (expected RFC 3339)")
+			return
+		}
+		records := []models.Record{}
+		if err := query.Order("created_at ASC").Find(&records).Error; err != nil {
+			log.Printf("Failed to export records: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to export records")
+			return
+		}
+
+		exported := withCompliance(db, records)
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": "records." + format,
+		}))
+		switch format {
+		case "json":
+			json.NewEncoder(w).Encode(exported)
+		case "jsonl":
+			encoder := json.NewEncoder(w)
+			for _, record := range exported {
+				encoder.Encode(record)
+			}
+		case "csv":
+			writeRecordsCSV(w, exported)
+		}
+	}
+}
+
+// WRITE RECORDS AS CSV WITH ONE COLUMN PER DATA FIELD
+func writeRecordsCSV(w http.ResponseWriter, records []exportedRecord) {
+	seen := make(map[string]bool)
+	var fields []string
+	hasCompliance := false
+	for _, record := range records {
+		for key := range record.Data {
+			if !seen[key] {
+				seen[key] = true
+				fields = append(fields, key)
+			}
+		}
+		hasCompliance = hasCompliance || record.Compliance != nil
+	}
+	sort.Strings(fields)
+
+	writer := csv.NewWriter(w)
+	header := []string{"id", "jobId", "key", "source", "url", "firstSeen", "lastSeen"}
+	if hasCompliance {
+		header = append(header, "purpose", "dataCategory", "retentionDays", "tosReviewed", "retainUntil")
+	}
+	writer.Write(append(header, fields...))
+	for _, record := range records {
+		row := []string{record.ID, record.JobID, record.Key, record.Source, record.URL, record.FirstSeen.Format(time.RFC3339), record.LastSeen.Format(time.RFC3339)}
+		if hasCompliance {
+			row = append(row, complianceColumns(record)...)
+		}
+		for _, field := range fields {
+			row = append(row, csvValue(record.Data[field]))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+}
+
+// A RECORD'S COMPLIANCE CELLS (EMPTY WHEN ITS JOB HAS NONE)
+func complianceColumns(record exportedRecord) []string {
+	c := record.Compliance
+	if c == nil {
+		return make([]string, 5)
+	}
+	retainUntil := ""
+	if record.RetainUntil != nil {
+		retainUntil = record.RetainUntil.Format(time.RFC3339)
+	}
+	return []string{c.Purpose, c.DataCategory, strconv.Itoa(c.RetentionDays), strconv.FormatBool(c.TosReviewed), retainUntil}
+}
+
+// FORMAT A DATA VALUE FOR A CSV CELL
+func csvValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	}
+}
+
+// LIST RECORDS REJECTED BY THEIR JOB'S SCHEMA, NEWEST FIRST, BY ?jobId= ?runId= WITH ?limit= AND ?offset=
+func GetRecordRejections(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		query := db.Model(&models.RecordRejection{}).Scopes(auth.OwnJobRows(auth.UserFrom(r.Context())))
+		if list := splitList(values.Get("jobId")); len(list) > 0 {
+			query = query.Where("job_id IN ?", list)
+		}
+		if list := splitList(values.Get("runId")); len(list) > 0 {
+			query = query.Where("run_id IN ?", list)
+		}
+		var total int64
+		query.Session(&gorm.Session{}).Count(&total)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+		limit := 100
+		if n, err := strconv.Atoi(values.Get("limit")); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+		offset, _ := strconv.Atoi(values.Get("offset"))
+		rejections := []models.RecordRejection{}
+		if err := query.Order("created_at DESC").Limit(limit).Offset(max(offset, 0)).Find(&rejections).Error; err != nil {
+			log.Printf("Failed to fetch record rejections: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch record rejections")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, rejections)
+	}
+}
+
+// RE-VALIDATE A REJECTED RECORD AGAINST ITS JOB'S CURRENT SCHEMA, OPTIONALLY WITH CORRECTED
+// {"data": {...}} (SCRUBBED BY THE JOB'S pii RULE LIKE EXTRACTED DATA), AND SAVE IT AS A RECORD
+// IF IT NOW PASSES
+func RetryRecordRejection(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rejection models.RecordRejection
+		if err := db.First(&rejection, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Rejected record not found")
+			return
+		}
+		var body struct {
+			Data map[string]any `json:"data"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+				return
+			}
+		}
+		data := map[string]any(rejection.Data)
+		if body.Data != nil {
+			data = body.Data
+		}
+
+		var job models.Job
+		if err := db.Select("id", "rules").First(&job, "id = ?", rejection.JobID).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		scrubber, err := scraper.NewPIIScrubber(cfg, job.ID, job.Rules["pii"])
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnprocessableEntity, "Job's pii rule is invalid: "+err.Error())
+			return
+		}
+		var findings []scraper.PIIFinding
+		if scrubber != nil {
+			data = maps.Clone(data)
+			findings = scrubber.Scrub(data)
+		}
+		if schemaDoc, ok := job.Rules["recordSchema"]; ok && schemaDoc != nil {
+			schema, err := scraper.CompileRecordSchema(schemaDoc)
+			if err != nil {
+				utils.RespondWithError(w, http.StatusUnprocessableEntity, "Job's record schema is invalid: "+err.Error())
+				return
+			}
+			if problems := scraper.RecordSchemaErrors(schema, data); problems != nil {
+				utils.RespondWithJSON(w, http.StatusUnprocessableEntity, map[string]any{
+					"error":   "Record still does not match the schema",
+					"details": problems,
+				})
+				return
+			}
+		}
+
+		keyFields, err := scraper.RecordKeyFields(job.Rules["recordKey"])
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnprocessableEntity, "Job's record key is invalid: "+err.Error())
+			return
+		}
+		record, err := scraper.NewRecord(rejection.JobID, rejection.RunID, rejection.Source, rejection.URL, data, keyFields)
+		if errors.Is(err, scraper.ErrRecordKeyMissing) {
+			utils.RespondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		record.PII = scraper.PIIColumn(findings)
+		var outcome scraper.RecordOutcome
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if outcome, err = scraper.StoreRecord(tx, &record); err != nil {
+				return err
+			}
+			return tx.Delete(&rejection).Error
+		})
+		if err != nil {
+			log.Printf("Failed to save retried record: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save record")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"record":  record,
+			"outcome": outcome, // inserted, updated OR unchanged
+		})
+	}
+}
+
+// DISCARD A REJECTED RECORD
+func DeleteRecordRejection(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := db.Delete(&models.RecordRejection{}, "id = ?", mux.Vars(r)["id"])
+		if result.Error != nil {
+			log.Printf("Failed to delete record rejection: %v", result.Error)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete rejected record")
+			return
+		}
+		if result.RowsAffected == 0 {
+			utils.RespondWithError(w, http.StatusNotFound, "Rejected record not found")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true})
+	}
+}
+
+// RECORDS SAVED AND REJECTED IN ONE RUN
+type runValidity struct {
+	RunID     string    `json:"runId"`
+	JobID     string    `json:"jobId"`
+	Valid     int64     `json:"valid"`
+	Rejected  int64     `json:"rejected"`
+	Rate      float64   `json:"rate"` // VALID / (VALID + REJECTED)
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+// SCHEMA VALIDITY RATE PER RUN FOR ?jobId= (REQUIRED), NEWEST RUN FIRST. RETRIED REJECTIONS
+// COUNT AS VALID; DUPLICATES AREN'T STORED SO THEY AREN'T COUNTED
+func GetRecordValidity(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID := r.URL.Query().Get("jobId")
+		if jobID == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "jobId is required")
+			return
+		}
+		if !auth.CanAccessJob(db, auth.UserFrom(r.Context()), jobID) {
+			utils.RespondWithError(w, http.StatusNotFound, "Job not found")
+			return
+		}
+		type runCount struct {
+			RunID string
+			Count int64
+		}
+		var valid, rejected []runCount
+		db.Model(&models.Record{}).Select("run_id, COUNT(*) AS count").Where("job_id = ?", jobID).Group("run_id").Scan(&valid)
+		db.Model(&models.RecordRejection{}).Select("run_id, COUNT(*) AS count").Where("job_id = ?", jobID).Group("run_id").Scan(&rejected)
+
+		runs := make(map[string]*runValidity)
+		get := func(runID string) *runValidity {
+			if runs[runID] == nil {
+				runs[runID] = &runValidity{RunID: runID, JobID: jobID}
+			}
+			return runs[runID]
+		}
+		for _, c := range valid {
+			get(c.RunID).Valid = c.Count
+		}
+		for _, c := range rejected {
+			get(c.RunID).Rejected = c.Count
+		}
+
+		out := make([]runValidity, 0, len(runs))
+		for _, run := range runs {
+			if total := run.Valid + run.Rejected; total > 0 {
+				run.Rate = float64(run.Valid) / float64(total)
+			}
+			// FIRST ROW OF THE RUN, FROM WHICHEVER TABLE HAS THE EARLIER ONE
+			var first models.Record
+			if db.Select("created_at").Where("job_id = ? AND run_id = ?", jobID, run.RunID).Order("created_at").Limit(1).Find(&first).RowsAffected > 0 {
+				run.FirstSeen = first.CreatedAt
+			}
+			var firstRejected models.RecordRejection
+			if db.Select("created_at").Where("job_id = ? AND run_id = ?", jobID, run.RunID).Order("created_at").Limit(1).Find(&firstRejected).RowsAffected > 0 {
+				if run.FirstSeen.IsZero() || firstRejected.CreatedAt.Before(run.FirstSeen) {
+					run.FirstSeen = firstRejected.CreatedAt
+				}
+			}
+			out = append(out, *run)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].FirstSeen.After(out[j].FirstSeen) })
+		utils.RespondWithJSON(w, http.StatusOK, out)
+	}
+}
+
+// LIST ALERTS FIRED BY JOBS' recordAlerts RULES, NEWEST FIRST, BY ?jobId= ?runId= ?recordId=
+// WITH ?limit= AND ?offset=
+func GetRecordAlerts(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		query := db.Model(&models.RecordAlert{}).Scopes(auth.OwnJobRows(auth.UserFrom(r.Context())))
+		for param, column := range map[string]string{"jobId": "job_id", "runId": "run_id", "recordId": "record_id"} {
+			if list := splitList(values.Get(param)); len(list) > 0 {
+				query = query.Where(column+" IN ?", list)
+			}
+		}
+		var total int64
+		query.Session(&gorm.Session{}).Count(&total)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+		limit := 100
+		if n, err := strconv.Atoi(values.Get("limit")); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+		offset, _ := strconv.Atoi(values.Get("offset"))
+		alerts := []models.RecordAlert{}
+		if err := query.Order("created_at DESC").Limit(limit).Offset(max(offset, 0)).Find(&alerts).Error; err != nil {
+			log.Printf("Failed to fetch record alerts: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch record alerts")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, alerts)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// VAULT ENTRY NAMES, AS TASKS REFER TO THEM
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+
+// LIST VAULT ENTRIES (NAMES AND DESCRIPTIONS ONLY; VALUES ARE NEVER RETURNED)
+func GetSecrets(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secrets := []models.Secret{}
+		if err := db.Order("name").Find(&secrets).Error; err != nil {
+			log.Printf("Failed to fetch secrets: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch secrets")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, secrets)
+	}
+}
+
+// CREATE OR REPLACE A VAULT ENTRY FROM {"value": "...", "description": "..."}
+func PutSecret(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if !secretNamePattern.MatchString(name) {
+			respondWithValidationErrors(w, validation.Errors{{Path: "name", Message: "must be 1-100 letters, digits, '.', '_' or '-'", Rule: "pattern"}})
+			return
+		}
+		var request struct {
+			Value       string `json:"value"`
+			Description string `json:"description"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &request); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if request.Value == "" {
+			respondWithValidationErrors(w, validation.Errors{{Path: "value", Message: "is required", Rule: "required"}})
+			return
+		}
+
+		sealed, err := utils.SealSecret(name, request.Value, cfg.EncryptionSecret)
+		if errors.Is(err, utils.ErrNoEncryptionSecret) {
+			utils.RespondWithError(w, http.StatusConflict, "Set encryptionSecret in the config to use the vault")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to seal secret %s: %v", name, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save secret")
+			return
+		}
+
+		secret := models.Secret{Name: name}
+		db.Limit(1).Find(&secret, "name = ?", name)
+		secret.Value = sealed
+		secret.Description = request.Description
+		if err := db.Save(&secret).Error; err != nil {
+			log.Printf("Failed to save secret %s: %v", name, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save secret")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, secret)
+	}
+}
+
+// DELETE A VAULT ENTRY
+func DeleteSecret(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := db.Delete(&models.Secret{}, "name = ?", mux.Vars(r)["name"])
+		if result.Error != nil {
+			log.Printf("Failed to delete secret: %v", result.Error)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete secret")
+			return
+		}
+		if result.RowsAffected == 0 {
+			utils.RespondWithError(w, http.StatusNotFound, "Secret not found")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"success": true})
+	}
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/storage"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// -- SCRAPED FILES ON THIS ORIGIN --
+//
+// EVERYTHING SERVED HERE CAME FROM SOMEONE ELSE'S SITE, SO NONE OF IT MAY RUN AS A PAGE OF OURS:
+// RESPONSES ARE SANDBOXED AND NEVER SNIFFED, AND TYPES A BROWSER WOULD RENDER AS A DOCUMENT (HTML,
+// XHTML, SVG, XML) ARE DOWNLOADED UNLESS storage.inlineTypes LISTS THEM.
+
+// KEEP WHATEVER THE RESPONSE TURNS OUT TO BE FROM RUNNING SCRIPTS OR BEING READ AS ANOTHER TYPE
+func sandboxResponse(h http.Header) {
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+}
+
+// SANDBOX A FILE SERVED AS contentType, MAKING IT A DOWNLOAD IF IT'S AN ACTIVE TYPE
+func guardContent(h http.Header, contentType string, cfg *config.Config) {
+	sandboxResponse(h)
+	if disposition := safeDisposition(h.Get("Content-Disposition"), contentType, cfg); disposition != "" {
+		h.Set("Content-Disposition", disposition)
+	}
+}
+
+// THE Content-Disposition TO SERVE A FILE WITH: attachment (KEEPING ITS FILENAME) FOR AN ACTIVE TYPE
+// NOT IN storage.inlineTypes, OTHERWISE disposition AS IT IS
+func safeDisposition(disposition, contentType string, cfg *config.Config) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !activeContent(mediaType) || slices.ContainsFunc(cfg.Storage.InlineTypes, func(t string) bool { return strings.EqualFold(t, mediaType) }) {
+		return disposition
+	}
+	_, params, _ := mime.ParseMediaType(disposition)
+	return mime.FormatMediaType("attachment", params)
+}
+
+// WHETHER A BROWSER SHOWING THIS TYPE INLINE WOULD RENDER IT AS A DOCUMENT THAT CAN RUN SCRIPTS
+func activeContent(mediaType string) bool {
+	switch mediaType {
+	case "text/html", "text/xml", "application/xml", "text/xsl":
+		return true
+	}
+	// application/xhtml+xml, image/svg+xml AND THE REST OF THE XML FAMILY
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
+// STREAM AN ASSET'S FILE BY ID WITH RANGE, CONDITIONAL AND CONTENT-TYPE SUPPORT. AN UPLOADED
+// ASSET IS REDIRECTED TO OR PROXIED FROM ITS BUCKET
+func StreamAsset(db *gorm.DB, cfg *config.Config, stores *storage.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sandboxResponse(w.Header())
+		id := mux.Vars(r)["id"]
+		var asset models.Asset
+		if err := db.First(&asset, "id = ?", id).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Asset not found")
+			return
+		}
+		if asset.LocalPath == "" {
+			utils.RespondWithError(w, http.StatusNotFound, "Asset does not have a local file")
+			return
+		}
+
+		filePath, ok := pathUnder(cfg.StoragePath, asset.LocalPath)
+		if !ok {
+			utils.RespondWithError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		disposition := "inline"
+		if r.URL.Query().Get("download") != "" {
+			disposition = "attachment"
+		}
+		disposition = mime.FormatMediaType(disposition, map[string]string{
+			"filename": filepath.Base(asset.LocalPath),
+		})
+		w.Header().Set("Content-Disposition", disposition)
+		if _, err := os.Stat(filePath); err != nil && asset.IsRemote() && stores.Remote() {
+			encrypted, _ := asset.Metadata["encrypted"].(bool)
+			serveRemote(w, r, stores, stores.Assets, asset.LocalPath, assetContentType(&asset), disposition, encrypted, cfg)
+			return
+		}
+		serveFile(w, r, filePath, assetContentType(&asset), cfg)
+	}
+}
+
+// SERVE FILES FROM A STORAGE DIRECTORY, USING STORED ASSET METADATA FOR THE CONTENT TYPE
+// AND TRANSPARENTLY DECRYPTING ENCRYPTED FILES (db MAY BE NIL, E.G. FOR THUMBNAILS). FILES NO
+// LONGER ON DISK COME FROM remote, THE BUCKET THE DIRECTORY UPLOADS TO
+func ServeStorage(db *gorm.DB, root string, cfg *config.Config, stores *storage.Stores, remote storage.Storage) http.Handler {
+	fileServer := http.FileServer(http.Dir(root))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sandboxResponse(w.Header())
+		// SAME CLEANING AS http.Dir SO THE PATH CAN'T ESCAPE THE ROOT
+		rel := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if rel == "" {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		name, ok := pathUnder(root, filepath.FromSlash(rel))
+		if !ok {
+			// A SYMLINK OUT OF THE ROOT
+			utils.RespondWithError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		info, err := os.Stat(name)
+		if err != nil && stores.Remote() {
+			serveUploaded(w, r, db, rel, cfg, stores, remote, fileServer)
+			return
+		}
+		if err != nil || info.IsDir() {
+			// DIRECTORY LISTINGS AND 404S
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+
+		contentType := ""
+		if db != nil {
+			var asset models.Asset
+			if db.Where("local_path = ?", filepath.FromSlash(rel)).Limit(1).Find(&asset).RowsAffected > 0 {
+				contentType = assetContentType(&asset)
+			}
+		}
+		serveFile(w, r, name, contentType, cfg)
+	})
+}
+
+// SERVE A FILE THAT ONLY EXISTS IN THE BUCKET. ASSETS ARE LOOKED UP FIRST (ONLY UPLOADED ONES ARE
+// FETCHED); THUMBNAILS, WHICH HAVE NO ROW OF THEIR OWN, ARE TRIED DIRECTLY
+func serveUploaded(w http.ResponseWriter, r *http.Request, db *gorm.DB, rel string, cfg *config.Config, stores *storage.Stores, remote storage.Storage, fileServer http.Handler) {
+	contentType := mime.TypeByExtension(path.Ext(rel))
+	// A THUMBNAIL DOESN'T SAY WHETHER IT'S ENCRYPTED, BUT IT'S SMALL ENOUGH TO FETCH AND CHECK
+	encrypted := cfg.EncryptionSecret != ""
+	if db != nil {
+		var asset models.Asset
+		if db.Where("local_path = ?", filepath.FromSlash(rel)).Limit(1).Find(&asset).RowsAffected == 0 || !asset.IsRemote() {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		contentType = assetContentType(&asset)
+		encrypted, _ = asset.Metadata["encrypted"].(bool)
+	}
+	serveRemote(w, r, stores, remote, rel, contentType, "", encrypted, cfg)
+}
+
+// SERVE A FILE FROM A BUCKET: A REDIRECT TO A SIGNED URL, OR STREAMED THROUGH WITH RANGE SUPPORT.
+// ENCRYPTED FILES ARE FETCHED AND DECRYPTED HERE, SINCE THE BUCKET ONLY HAS THE CIPHERTEXT
+func serveRemote(w http.ResponseWriter, r *http.Request, stores *storage.Stores, remote storage.Storage, name, contentType, disposition string, encrypted bool, cfg *config.Config) {
+	key := storage.Key(name)
+	if encrypted {
+		serveRemoteDecrypted(w, r, remote, key, contentType, cfg)
+		return
+	}
+
+	if stores.Serve == storage.ServeRedirect {
+		params := url.Values{}
+		if contentType != "" {
+			params.Set("response-content-type", contentType)
+		}
+		disposition = safeDisposition(disposition, cmp.Or(contentType, mime.TypeByExtension(path.Ext(key))), cfg)
+		if disposition != "" {
+			params.Set("response-content-disposition", disposition)
+		}
+		signed, err := remote.SignedURL(key, stores.URLExpiry, params)
+		if err == nil {
+			// THE URL EXPIRES, SO THE REDIRECT ITSELF MUSTN'T BE CACHED
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, signed, http.StatusFound)
+			return
+		}
+		log.Printf("Failed to sign a URL for %s, proxying it instead: %v", key, err)
+	}
+
+	body, object, err := remote.Get(r.Context(), key, r.Header.Get("Range"))
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "File not found")
+		return
+	case errors.Is(err, storage.ErrInvalidRange):
+		utils.RespondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable")
+		return
+	case err != nil:
+		log.Printf("Failed to read %s from %s: %v", key, remote.Name(), err)
+		utils.RespondWithError(w, http.StatusBadGateway, "Failed to read file from storage")
+		return
+	}
+	defer body.Close()
+
+	if object.ETag != "" {
+		w.Header().Set("ETag", object.ETag)
+		if match := r.Header.Get("If-None-Match"); match != "" && match == object.ETag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if !object.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", object.LastModified.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	contentType = cmp.Or(contentType, object.ContentType, "application/octet-stream")
+	w.Header().Set("Content-Type", contentType)
+	guardContent(w.Header(), contentType, cfg)
+	if object.Length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(object.Length, 10))
+	}
+	status := http.StatusOK
+	if object.Range != "" {
+		w.Header().Set("Content-Range", object.Range)
+		status = http.StatusPartialContent
+	}
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		io.Copy(w, body)
+	}
+}
+
+// FETCH A FILE FROM A BUCKET TO A TEMPORARY FILE AND SERVE IT FROM THERE, DECRYPTING IT IF IT'S
+// ENCRYPTED
+func serveRemoteDecrypted(w http.ResponseWriter, r *http.Request, remote storage.Storage, key, contentType string, cfg *config.Config) {
+	body, _, err := remote.Get(r.Context(), key, "")
+	if errors.Is(err, storage.ErrNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read %s from %s: %v", key, remote.Name(), err)
+		utils.RespondWithError(w, http.StatusBadGateway, "Failed to read file from storage")
+		return
+	}
+	defer body.Close()
+	tmp, err := os.CreateTemp("", "crepes-remote-*"+path.Ext(key))
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to read file from storage")
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Failed to read %s from %s: %v", key, remote.Name(), err)
+		utils.RespondWithError(w, http.StatusBadGateway, "Failed to read file from storage")
+		return
+	}
+	serveFile(w, r, tmp.Name(), contentType, cfg)
+}
+
+// SERVE ONE FILE WITH ETAG, LAST-MODIFIED AND BYTE RANGES (HANDLED BY http.ServeContent)
+func serveFile(w http.ResponseWriter, r *http.Request, name, contentType string, cfg *config.Config) {
+	info, err := os.Stat(name)
+	if err != nil || info.IsDir() {
+		utils.RespondWithError(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	var content io.ReadSeeker
+	if utils.IsEncryptedFile(name) {
+		file, err := utils.OpenDecrypted(name, cfg.EncryptionSecret)
+		if err != nil {
+			log.Printf("Failed to decrypt %s: %v", name, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to decrypt file")
+			return
+		}
+		defer file.Close()
+		content = file
+		w.Header().Set("Cache-Control", "private, no-store")
+	} else {
+		file, err := os.Open(name)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		defer file.Close()
+		content = file
+	}
+
+	// THE TYPE IS DECIDED HERE, NOT BY ServeContent, SO THE GUARD SEES WHAT THE BROWSER WILL
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if contentType == "" {
+		var head [512]byte
+		n, _ := io.ReadFull(content, head[:])
+		contentType = http.DetectContentType(head[:n])
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to read file")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	guardContent(w.Header(), contentType, cfg)
+
+	// LARGE MEDIA CAN TAKE LONGER THAN THE SERVER'S WRITE TIMEOUT TO STREAM
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// FILES ARE WRITTEN ONCE, SO SIZE + MTIME IDENTIFIES A VERSION
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+// CONTENT TYPE FROM STORED METADATA, THEN THE FILE EXTENSION; EMPTY LETS serveFile SNIFF
+func assetContentType(asset *models.Asset) string {
+	if ct, ok := asset.Metadata["contentType"].(string); ok && ct != "" && ct != "application/octet-stream" {
+		return ct
+	}
+	return mime.TypeByExtension(filepath.Ext(asset.LocalPath))
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickheyer/Crepes/internal/config"
+)
+
+// A SCRAPED PAGE OR IMAGE THAT CAN RUN SCRIPTS IS DOWNLOADED, NOT RENDERED ON THIS ORIGIN
+func TestServeFileGuardsActiveContent(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"page.html":  "<script>alert(1)</script>",
+		"image.svg":  `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`,
+		"photo.png":  "\x89PNG\r\n\x1a\n",
+		"page.bin":   "<!DOCTYPE html><script>alert(1)</script>",
+		"notes.data": "just text",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		inline      []string
+		disposition string
+	}{
+		{name: "page.html", disposition: `attachment; filename=page.html`},
+		{name: "image.svg", disposition: `attachment; filename=image.svg`},
+		{name: "image.svg", inline: []string{"IMAGE/SVG+XML"}, disposition: `inline; filename=image.svg`},
+		{name: "photo.png", disposition: `inline; filename=photo.png`},
+		{name: "page.bin", contentType: "application/xhtml+xml", disposition: `attachment; filename=page.bin`},
+		// NO STORED TYPE OR KNOWN EXTENSION: SNIFFED HERE, SINCE nosniff STOPS THE BROWSER DOING IT
+		{name: "notes.data", disposition: `inline; filename=notes.data`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Storage: config.StorageConfig{InlineTypes: tt.inline}}
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Content-Disposition", "inline; filename="+tt.name)
+			serveFile(rec, httptest.NewRequest(http.MethodGet, "/", nil), filepath.Join(dir, tt.name), tt.contentType, cfg)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Content-Disposition = %q; want %q", got, tt.disposition)
+			}
+			if rec.Header().Get("Content-Type") == "" {
+				t.Error("no Content-Type")
+			}
+			if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q", got)
+			}
+			if got := rec.Header().Get("Content-Security-Policy"); got != "sandbox" {
+				t.Errorf("Content-Security-Policy = %q", got)
+			}
+		})
+	}
+}
@@ -3,13 +3,50 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/nickheyer/Crepes/internal/config"
 	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
 	"github.com/nickheyer/Crepes/internal/utils"
 	"gorm.io/gorm"
 )
 
+// THE appConfig SETTINGS AS THE API SHOWS THEM
+func appConfigValues(cfg *config.Config) map[string]any {
+	return map[string]any{
+		"port":            cfg.Port,
+		"storagePath":     cfg.StoragePath,
+		"thumbnailsPath":  cfg.ThumbnailsPath,
+		"dataPath":        cfg.DataPath,
+		"maxConcurrent":   cfg.MaxConcurrent,
+		"defaultTimeout":  cfg.DefaultTimeout,
+		"ffmpegPath":      cfg.FFmpegPath,
+		"ffprobePath":     cfg.FFprobePath,
+		"magickPath":      cfg.MagickPath,
+		"pdftotextPath":   cfg.PdftotextPath,
+		"libreOfficePath": cfg.LibreOfficePath,
+		"browserPath":     cfg.BrowserPath,
+		"browserCacheDir": cfg.BrowserCacheDir,
+		"browserSandbox":  cfg.BrowserSandbox,
+
+		"resourceIdleTimeout": cfg.ResourceIdleTimeout,
+		"maxBrowsers":         cfg.MaxBrowsers,
+		"maxBrowserTabs":      cfg.MaxBrowserTabs,
+		"browserLifetime":     cfg.BrowserLifetime,
+		"memorySoftLimit":     cfg.MemorySoftLimit,
+		"memoryHardLimit":     cfg.MemoryHardLimit,
+		"htmlMaxBytes":        cfg.HTMLMaxBytes,
+		"htmlMaxElements":     cfg.HTMLMaxElements,
+		"dns":                 cfg.DNS,
+		"egress":              cfg.Egress,
+		"proxy":               cfg.Proxy,
+		"userAgent":           cfg.UserAgent,
+	}
+}
+
 func GetSettings(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var settings []models.Setting
@@ -17,19 +54,15 @@ func GetSettings(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch settings")
 			return
 		}
+		tools := utils.CurrentMediaTools()
 		settingsMap := make(map[string]string)
 		for _, setting := range settings {
 			settingsMap[setting.Key] = setting.Value
 		}
 		response := map[string]any{
-			"appConfig": map[string]any{
-				"port":           cfg.Port,
-				"storagePath":    cfg.StoragePath,
-				"thumbnailsPath": cfg.ThumbnailsPath,
-				"dataPath":       cfg.DataPath,
-				"maxConcurrent":  cfg.MaxConcurrent,
-				"defaultTimeout": cfg.DefaultTimeout,
-			},
+			"appConfig":    appConfigValues(cfg),
+			"mediaTools":   tools,
+			"capabilities": tools.Capabilities(),
 			"userConfig": map[string]string{
 				"theme":                settingsMap["theme"],
 				"defaultView":          settingsMap["defaultView"],
@@ -50,9 +83,33 @@ func UpdateSettings(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
 			return
 		}
+		if errs := validateSettings(request); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		var restart []string // CHANGED SETTINGS THAT WAIT FOR A RESTART
 		if appConfig, ok := request["appConfig"].(map[string]any); ok {
-			if port, ok := appConfig["port"].(string); ok && port != "" {
-				cfg.Port = port
+			before := appConfigValues(cfg)
+			var dns *scraper.DNSPolicy
+			if raw, ok := appConfig["dns"]; ok {
+				policy, err := scraper.ParseDNSPolicy(raw)
+				if err != nil {
+					utils.RespondWithError(w, http.StatusBadRequest, "Invalid dns settings: "+err.Error())
+					return
+				}
+				dns = &policy
+			}
+			var egress *scraper.EgressPolicy
+			if raw, ok := appConfig["egress"]; ok {
+				policy, err := scraper.ParseEgressPolicy(raw)
+				if err != nil {
+					utils.RespondWithError(w, http.StatusBadRequest, "Invalid egress settings: "+err.Error())
+					return
+				}
+				egress = &policy
+			}
+			if port, ok := settingInt(appConfig["port"]); ok {
+				cfg.Port = strconv.FormatInt(port, 10)
 			}
 			if storagePath, ok := appConfig["storagePath"].(string); ok && storagePath != "" {
 				cfg.StoragePath = storagePath
@@ -69,10 +126,75 @@ func UpdateSettings(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 			if defaultTimeout, ok := appConfig["defaultTimeout"].(float64); ok {
 				cfg.DefaultTimeout = int(defaultTimeout)
 			}
+			if idleTimeout, ok := appConfig["resourceIdleTimeout"].(float64); ok {
+				cfg.ResourceIdleTimeout = int(idleTimeout)
+			}
+			if maxBrowsers, ok := appConfig["maxBrowsers"].(float64); ok {
+				cfg.MaxBrowsers = int(maxBrowsers)
+			}
+			if maxTabs, ok := appConfig["maxBrowserTabs"].(float64); ok {
+				cfg.MaxBrowserTabs = int(maxTabs)
+			}
+			if lifetime, ok := appConfig["browserLifetime"].(float64); ok {
+				cfg.BrowserLifetime = int(lifetime)
+			}
+			if softLimit, ok := appConfig["memorySoftLimit"].(float64); ok {
+				cfg.MemorySoftLimit = int(softLimit)
+			}
+			if hardLimit, ok := appConfig["memoryHardLimit"].(float64); ok {
+				cfg.MemoryHardLimit = int(hardLimit)
+			}
+			if htmlMaxBytes, ok := appConfig["htmlMaxBytes"].(float64); ok {
+				cfg.HTMLMaxBytes = int64(htmlMaxBytes)
+			}
+			if htmlMaxElements, ok := appConfig["htmlMaxElements"].(float64); ok {
+				cfg.HTMLMaxElements = int(htmlMaxElements)
+			}
+			if dns != nil {
+				cfg.DNS = config.DNSConfig{Resolver: dns.Resolver, DoH: dns.DoH, Hosts: dns.Hosts}
+			}
+			if egress != nil {
+				cfg.Egress = config.EgressConfig{IPVersion: egress.IPVersion, BindAddress: egress.BindAddress, Interface: egress.Interface}
+			}
+
+			// NEW RUNS PICK THESE UP; EMPTY MEANS NO PROXY AND THE DEFAULT USER AGENT
+			if proxy, ok := appConfig["proxy"].(string); ok {
+				cfg.Proxy = strings.TrimSpace(proxy)
+			}
+			if userAgent, ok := appConfig["userAgent"].(string); ok {
+				cfg.UserAgent = strings.TrimSpace(userAgent)
+			}
+
+			// EMPTY PATHS ARE ALLOWED (LOOK ON THE PATH); CHANGES ARE CHECKED RIGHT AWAY
+			toolsChanged := false
+			for key, field := range map[string]*string{"ffmpegPath": &cfg.FFmpegPath, "ffprobePath": &cfg.FFprobePath, "magickPath": &cfg.MagickPath,
+				"pdftotextPath": &cfg.PdftotextPath, "libreOfficePath": &cfg.LibreOfficePath} {
+				if value, ok := appConfig[key].(string); ok && value != *field {
+					*field = value
+					toolsChanged = true
+				}
+			}
+			if toolsChanged {
+				scraper.DetectMediaTools(cfg)
+			}
+
+			// BROWSER RUNTIME CHANGES APPLY THE NEXT TIME PLAYWRIGHT STARTS
+			for key, field := range map[string]*string{"browserPath": &cfg.BrowserPath, "browserCacheDir": &cfg.BrowserCacheDir, "browserSandbox": &cfg.BrowserSandbox} {
+				if value, ok := appConfig[key].(string); ok {
+					*field = value
+				}
+			}
 			if err := config.SaveConfig(cfg, "config.json"); err != nil {
 				utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save app configuration")
 				return
 			}
+
+			after := appConfigValues(cfg)
+			for _, field := range settingsSchema {
+				if field.Section == settingsApp && field.RestartRequired && !reflect.DeepEqual(before[field.Key], after[field.Key]) {
+					restart = append(restart, field.Key)
+				}
+			}
 		}
 		if userConfig, ok := request["userConfig"].(map[string]any); ok {
 			for key, value := range userConfig {
@@ -94,9 +216,14 @@ func UpdateSettings(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 				}
 			}
 		}
+		message := "Settings updated successfully"
+		if len(restart) > 0 {
+			message = "Settings updated successfully; restart Crepes to apply " + strings.Join(restart, ", ")
+		}
 		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
-			"success": true,
-			"message": "Settings updated successfully",
+			"success":         true,
+			"message":         message,
+			"restartRequired": restart,
 		})
 	}
 }
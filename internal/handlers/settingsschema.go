@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// -- SETTINGS SCHEMA --
+//
+// EVERY SETTING PUT /api/settings ACCEPTS, WITH ITS TYPE, RANGE, DEFAULT AND WHETHER IT ONLY
+// TAKES EFFECT AFTER A RESTART. THE UI BUILDS ITS SETTINGS FORM FROM IT, AND UPDATES ARE CHECKED
+// AGAINST IT BEFORE ANYTHING IS SAVED. appConfig KEYS MUST BE IN IT; userConfig MAY ALSO HOLD
+// OTHER UI PREFERENCES, WHICH ARE STORED AS GIVEN.
+
+// SECTIONS OF A SETTINGS UPDATE
+const (
+	settingsApp  = "appConfig"
+	settingsUser = "userConfig"
+)
+
+// SETTING TYPES
+const (
+	settingString  = "string"
+	settingInteger = "integer"
+	settingBoolean = "boolean"
+	settingEnum    = "enum"   // ONE OF options
+	settingPort    = "port"   // TCP PORT, AS A NUMBER OR A STRING
+	settingObject  = "object" // CHECKED BY ITS OWN PARSER
+)
+
+// ONE SETTING
+type settingField struct {
+	Key             string   `json:"key"`
+	Section         string   `json:"section"`
+	Group           string   `json:"group"` // HEADING IT IS SHOWN UNDER
+	Label           string   `json:"label"`
+	Type            string   `json:"type"`
+	Description     string   `json:"description"`
+	Unit            string   `json:"unit,omitempty"`
+	Min             *int64   `json:"min,omitempty"`
+	Max             *int64   `json:"max,omitempty"`
+	Options         []string `json:"options,omitempty"`
+	Required        bool     `json:"required"`
+	Default         any      `json:"default"`
+	RestartRequired bool     `json:"restartRequired"`
+
+	check func(any) error // EXTRA CHECK FOR object, string AND integer SETTINGS
+}
+
+func bound(n int64) *int64 {
+	return &n
+}
+
+var settingsSchema = func() []settingField {
+	defaults := config.GetDefaultConfig()
+	return []settingField{
+		// SERVER
+		{Key: "port", Section: settingsApp, Group: "Server", Label: "Port", Type: settingPort, Required: true,
+			Description: "HTTP port the server listens on", Min: bound(1), Max: bound(65535), Default: defaults.Port, RestartRequired: true},
+		{Key: "maxConcurrent", Section: settingsApp, Group: "Server", Label: "Max concurrent browsers", Type: settingInteger,
+			Description: "Asset workers per job", Min: bound(1), Max: bound(64), Default: defaults.MaxConcurrent, RestartRequired: true},
+		{Key: "defaultTimeout", Section: settingsApp, Group: "Server", Label: "Default timeout", Type: settingInteger, Unit: "ms",
+			Description: "How long a job may run before it is stopped", Min: bound(1000), Max: bound(7 * 24 * 60 * 60 * 1000), Default: defaults.DefaultTimeout},
+
+		// STORAGE
+		{Key: "storagePath", Section: settingsApp, Group: "Storage", Label: "Storage path", Type: settingString, Required: true,
+			Description: "Directory downloaded assets are saved in", Default: defaults.StoragePath, RestartRequired: true},
+		{Key: "thumbnailsPath", Section: settingsApp, Group: "Storage", Label: "Thumbnails path", Type: settingString, Required: true,
+			Description: "Directory thumbnails are saved in", Default: defaults.ThumbnailsPath, RestartRequired: true},
+		{Key: "dataPath", Section: settingsApp, Group: "Storage", Label: "Data path", Type: settingString, Required: true,
+			Description: "Directory holding the database and the application log", Default: defaults.DataPath, RestartRequired: true},
+
+		// BROWSER
+		{Key: "browserPath", Section: settingsApp, Group: "Browser", Label: "Browser executable", Type: settingString,
+			Description: "Chromium or Chrome to launch instead of Playwright's own (empty to use Playwright's)", Default: "", RestartRequired: true},
+		{Key: "browserCacheDir", Section: settingsApp, Group: "Browser", Label: "Browser cache directory", Type: settingString,
+			Description: "Where the Playwright driver and browsers are installed (empty for the default)", Default: "", RestartRequired: true},
+		{Key: "browserSandbox", Section: settingsApp, Group: "Browser", Label: "Browser sandbox", Type: settingEnum, Options: []string{"auto", "on", "off"},
+			Description: "Chromium sandbox; auto turns it off in containers and when running as root", Default: "auto", RestartRequired: true},
+		{Key: "resourceIdleTimeout", Section: settingsApp, Group: "Browser", Label: "Idle browser timeout", Type: settingInteger, Unit: "ms",
+			Description: "Browsers and pages unused this long are closed (0 for 30 minutes, negative for never)", Default: 0},
+		{Key: "maxBrowsers", Section: settingsApp, Group: "Browser", Label: "Max browsers", Type: settingInteger, Min: bound(0), Max: bound(256),
+			Description: "Browsers open at once across all jobs; more wait for one to close (0 for no limit)", Default: 0},
+		{Key: "maxBrowserTabs", Section: settingsApp, Group: "Browser", Label: "Max tabs per browser", Type: settingInteger, Min: bound(0), Max: bound(100),
+			Description: "Pages open at once in one browser; more wait for one to close (0 for no limit)", Default: 0},
+		{Key: "browserLifetime", Section: settingsApp, Group: "Browser", Label: "Browser lifetime", Type: settingInteger, Unit: "ms", Min: bound(0),
+			Description: "Idle browsers this old are relaunched (0 for never, otherwise at least a minute)", Default: 0,
+			check: func(value any) error {
+				n, _ := settingInt(value)
+				return scraper.BrowserPoolLimits{BrowserLifetime: int(n)}.Validate()
+			}},
+
+		// LIMITS
+		{Key: "memorySoftLimit", Section: settingsApp, Group: "Limits", Label: "Memory soft limit", Type: settingInteger, Unit: "MB",
+			Description: "Above this, fewer workers run and downloads wait (0 for 70% of memory, negative to turn the watchdog off)", Default: 0},
+		{Key: "memoryHardLimit", Section: settingsApp, Group: "Limits", Label: "Memory hard limit", Type: settingInteger, Unit: "MB",
+			Description: "Above this, one worker runs and the heaviest browsers are recycled (0 for 85% of memory, negative to turn the watchdog off)", Default: 0},
+		{Key: "htmlMaxBytes", Section: settingsApp, Group: "Limits", Label: "HTML size limit", Type: settingInteger, Unit: "bytes", Min: bound(0),
+			Description: "Most of a page read when parsing without a browser (0 for 50MB)", Default: 0},
+		{Key: "htmlMaxElements", Section: settingsApp, Group: "Limits", Label: "HTML element limit", Type: settingInteger, Min: bound(0),
+			Description: "Most elements kept when parsing without a browser (0 for 100000)", Default: 0},
+
+		// MEDIA TOOLS
+		{Key: "ffmpegPath", Section: settingsApp, Group: "Media tools", Label: "ffmpeg", Type: settingString,
+			Description: "ffmpeg executable for video thumbnails (empty to look on the PATH)", Default: ""},
+		{Key: "ffprobePath", Section: settingsApp, Group: "Media tools", Label: "ffprobe", Type: settingString,
+			Description: "ffprobe executable for media details (empty to look on the PATH)", Default: ""},
+		{Key: "magickPath", Section: settingsApp, Group: "Media tools", Label: "ImageMagick", Type: settingString,
+			Description: "ImageMagick magick or convert executable (empty to look on the PATH)", Default: ""},
+		{Key: "pdftotextPath", Section: settingsApp, Group: "Media tools", Label: "pdftotext", Type: settingString,
+			Description: "pdftotext executable for searchable PDF text (empty to look on the PATH)", Default: ""},
+		{Key: "libreOfficePath", Section: settingsApp, Group: "Media tools", Label: "LibreOffice", Type: settingString,
+			Description: "LibreOffice soffice executable for Office document thumbnails (empty to look on the PATH)", Default: ""},
+
+		// NETWORK
+		{Key: "dns", Section: settingsApp, Group: "Network", Label: "DNS", Type: settingObject,
+			Description: `How host names are resolved: {"resolver": "1.1.1.1"} or {"doh": "https://1.1.1.1/dns-query"}, plus "hosts" overrides`, Default: config.DNSConfig{},
+			check: func(raw any) error { _, err := scraper.ParseDNSPolicy(raw); return err }},
+		{Key: "egress", Section: settingsApp, Group: "Network", Label: "Egress", Type: settingObject,
+			Description: `Which way jobs connect out: {"ipVersion": "4"}, {"bindAddress": "..."} or {"interface": "wg0"}`, Default: config.EgressConfig{},
+			check: func(raw any) error { _, err := scraper.ParseEgressPolicy(raw); return err }},
+		{Key: "proxy", Section: settingsApp, Group: "Network", Label: "Proxy", Type: settingString,
+			Description: "http, https or socks5 URL job traffic goes through (empty for the environment's HTTP_PROXY); jobs and folders can override it", Default: "",
+			check: func(raw any) error { s, _ := raw.(string); return scraper.ValidateProxy(s) }},
+		{Key: "userAgent", Section: settingsApp, Group: "Network", Label: "User agent", Type: settingString, Max: bound(512),
+			Description: "default, rotate (a different current browser per page and request) or a user agent string; jobs and folders can override it", Default: scraper.UserAgentDefault},
+
+		// PREFERENCES
+		{Key: "theme", Section: settingsUser, Group: "Preferences", Label: "Theme", Type: settingString, Max: bound(50),
+			Description: "Interface theme", Default: "default"},
+		{Key: "defaultView", Section: settingsUser, Group: "Preferences", Label: "Default asset view", Type: settingEnum, Options: []string{"grid", "list"},
+			Description: "How the asset gallery opens", Default: "grid"},
+		{Key: "notificationsEnabled", Section: settingsUser, Group: "Preferences", Label: "Notifications", Type: settingBoolean,
+			Description: "Show notifications for important events", Default: true},
+	}
+}()
+
+// THE SETTING FOR A SECTION'S KEY
+func lookupSetting(section, key string) (settingField, bool) {
+	i := slices.IndexFunc(settingsSchema, func(f settingField) bool { return f.Section == section && f.Key == key })
+	if i < 0 {
+		return settingField{}, false
+	}
+	return settingsSchema[i], true
+}
+
+// CHECK A SETTINGS UPDATE AGAINST THE SCHEMA
+func validateSettings(request map[string]any) validation.Errors {
+	var errs validation.Errors
+	for _, section := range []string{settingsApp, settingsUser} {
+		raw, present := request[section]
+		if !present || raw == nil {
+			continue
+		}
+		values, ok := raw.(map[string]any)
+		if !ok {
+			errs = append(errs, validation.FieldError{Path: section, Message: "must be an object", Expected: "object", Rule: "type"})
+			continue
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			path := section + "." + key
+			field, known := lookupSetting(section, key)
+			if !known {
+				if section == settingsApp {
+					errs = append(errs, validation.FieldError{Path: path, Message: "unknown setting", Rule: "unknown"})
+				}
+				continue
+			}
+			if err := field.validate(values[key]); err != nil {
+				err.Path = path
+				errs = append(errs, *err)
+			}
+		}
+	}
+	return errs
+}
+
+// CHECK ONE VALUE (THE CALLER FILLS IN THE PATH)
+func (f settingField) validate(value any) *validation.FieldError {
+	article := "a "
+	if strings.ContainsRune("aeiou", rune(f.typeName()[0])) {
+		article = "an "
+	}
+	typeError := &validation.FieldError{Message: "must be " + article + f.typeName(), Expected: f.typeName(), Rule: "type"}
+	switch f.Type {
+	case settingString:
+		s, ok := value.(string)
+		if !ok {
+			return typeError
+		}
+		if f.Required && strings.TrimSpace(s) == "" {
+			return &validation.FieldError{Message: "is required", Expected: "non-empty string", Rule: "required"}
+		}
+		if f.Max != nil && int64(len(s)) > *f.Max {
+			return &validation.FieldError{Message: fmt.Sprintf("must be at most %d characters", *f.Max), Expected: fmt.Sprintf("<= %d characters", *f.Max), Rule: "max"}
+		}
+		if f.check != nil {
+			if err := f.check(s); err != nil {
+				return &validation.FieldError{Message: err.Error(), Expected: "string", Rule: "config"}
+			}
+		}
+	case settingEnum:
+		s, ok := value.(string)
+		if !ok {
+			return typeError
+		}
+		if s == "" && !f.Required {
+			return nil // THE DEFAULT
+		}
+		if !slices.Contains(f.Options, s) {
+			return &validation.FieldError{Message: "must be one of " + strings.Join(f.Options, ", "), Expected: strings.Join(f.Options, ", "), Rule: "oneof"}
+		}
+	case settingBoolean:
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return typeError
+			}
+		default:
+			return typeError
+		}
+	case settingInteger, settingPort:
+		if _, isString := value.(string); isString && f.Type == settingInteger {
+			return typeError
+		}
+		n, ok := settingInt(value)
+		if !ok {
+			return typeError
+		}
+		if f.Min != nil && n < *f.Min {
+			return &validation.FieldError{Message: fmt.Sprintf("must be at least %d", *f.Min), Expected: fmt.Sprintf(">= %d", *f.Min), Rule: "min"}
+		}
+		if f.Max != nil && n > *f.Max {
+			return &validation.FieldError{Message: fmt.Sprintf("must be at most %d", *f.Max), Expected: fmt.Sprintf("<= %d", *f.Max), Rule: "max"}
+		}
+		if f.check != nil {
+			if err := f.check(value); err != nil {
+				return &validation.FieldError{Message: err.Error(), Expected: "integer", Rule: "config"}
+			}
+		}
+	case settingObject:
+		if _, ok := value.(map[string]any); !ok && value != nil {
+			return typeError
+		}
+		if f.check != nil {
+			if err := f.check(value); err != nil {
+				return &validation.FieldError{Message: err.Error(), Expected: "object", Rule: "config"}
+			}
+		}
+	}
+	return nil
+}
+
+// NAME OF A SETTING'S TYPE IN MESSAGES
+func (f settingField) typeName() string {
+	switch f.Type {
+	case settingEnum:
+		return "string"
+	case settingPort:
+		return "port number"
+	default:
+		return f.Type
+	}
+}
+
+// A WHOLE NUMBER FROM JSON; PORTS MAY ALSO COME AS STRINGS
+func settingInt(value any) (int64, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32*1024.0 {
+			return 0, false
+		}
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// THE SETTINGS SCHEMA, FOR BUILDING A SETTINGS FORM
+func GetSettingsSchema() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    map[string]any{"fields": settingsSchema},
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// ASSETS FETCHED FROM ONE DOMAIN
+type domainCount struct {
+	Domain string `json:"domain"`
+	Assets int    `json:"assets"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// AGGREGATE JOB, ASSET, STORAGE AND ERROR STATISTICS FOR THE DASHBOARD
+func GetStatsOverview(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		queue := engine.QueueStatus()
+		user := auth.UserFrom(r.Context())
+		ownJobs, ownRows := auth.OwnJobs(user), auth.OwnJobRows(user)
+
+		// JOB COUNTS BY STATUS
+		var statusRows []struct {
+			Status string
+			Count  int64
+		}
+		if err := db.Model(&models.Job{}).Scopes(ownJobs).Select("status, COUNT(*) AS count").Group("status").Scan(&statusRows).Error; err != nil {
+			log.Printf("Failed to count jobs: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to load statistics")
+			return
+		}
+		byStatus := make(map[string]int64)
+		var totalJobs int64
+		for _, row := range statusRows {
+			byStatus[row.Status] = row.Count
+			totalJobs += row.Count
+		}
+		var scheduled int64
+		db.Model(&models.Job{}).Scopes(ownJobs).Where("schedule != ''").Count(&scheduled)
+		var nextJob models.Job
+		db.Scopes(ownJobs).Select("id", "next_run").Where("schedule != '' AND next_run > ?", now).Order("next_run").Limit(1).Find(&nextJob)
+
+		// ASSET COUNTS AND RECORDED SIZE
+		var assets struct {
+			Total int64
+			Bytes int64
+		}
+		db.Model(&models.Asset{}).Scopes(ownRows).Select("COUNT(*) AS total, COALESCE(SUM(size), 0) AS bytes").Scan(&assets)
+		var last24h, last7d int64
+		db.Model(&models.Asset{}).Scopes(ownRows).Where("created_at >= ?", now.Add(-24*time.Hour)).Count(&last24h)
+		db.Model(&models.Asset{}).Scopes(ownRows).Where("created_at >= ?", now.Add(-7*24*time.Hour)).Count(&last7d)
+
+		// BUSIEST DOMAINS OVER THE LAST WEEK
+		var recent []models.Asset
+		db.Scopes(ownRows).Select("url", "size").Where("created_at >= ?", now.Add(-7*24*time.Hour)).Find(&recent)
+		domains := make(map[string]*domainCount)
+		for _, asset := range recent {
+			u, err := url.Parse(asset.URL)
+			if err != nil || u.Hostname() == "" {
+				continue
+			}
+			host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+			if _, ok := domains[host]; !ok {
+				domains[host] = &domainCount{Domain: host}
+			}
+			domains[host].Assets++
+			domains[host].Bytes += asset.Size
+		}
+		busiest := make([]domainCount, 0, len(domains))
+		for _, d := range domains {
+			busiest = append(busiest, *d)
+		}
+		sort.Slice(busiest, func(i, j int) bool {
+			if busiest[i].Assets != busiest[j].Assets {
+				return busiest[i].Assets > busiest[j].Assets
+			}
+			return busiest[i].Domain < busiest[j].Domain
+		})
+		if len(busiest) > 10 {
+			busiest = busiest[:10]
+		}
+
+		running, queued := queue.Running, queue.Queued
+		recentErrors := engine.RecentErrors(20)
+		if !auth.SeesAll(user) {
+			// ONLY THE USER'S OWN RUNS AND ERRORS
+			var own []string
+			db.Model(&models.Job{}).Scopes(ownJobs).Pluck("id", &own)
+			running = slices.DeleteFunc(running, func(run scraper.RunningRun) bool { return !slices.Contains(own, run.JobID) })
+			queued = slices.DeleteFunc(queued, func(run scraper.QueuedRun) bool { return !slices.Contains(own, run.JobID) })
+			recentErrors = slices.DeleteFunc(recentErrors, func(e scraper.JobError) bool { return !slices.Contains(own, e.JobID) })
+		}
+		jobs := map[string]any{
+			"total":     totalJobs,
+			"running":   len(running),
+			"queued":    len(queued),
+			"failed":    byStatus["failed"],
+			"scheduled": scheduled,
+			"byStatus":  byStatus,
+		}
+		if !nextJob.NextRun.IsZero() {
+			jobs["nextScheduledRun"] = nextJob.NextRun
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"jobs": jobs,
+			"assets": map[string]any{
+				"total":   assets.Total,
+				"last24h": last24h,
+				"last7d":  last7d,
+			},
+			"storage": map[string]any{
+				"bytes":     assets.Bytes,
+				"formatted": utils.FormatFileSize(uint64(assets.Bytes)),
+			},
+			"thumbnails":     queue.Thumbnails,
+			"resources":      engine.LeakStats(),
+			"recentErrors":   recentErrors,
+			"busiestDomains": busiest,
+			"generatedAt":    now,
+		})
+	}
+}
@@ -1,16 +1,20 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"syscall"
+	"sort"
 
 	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/storage"
 	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
 )
 
-func GetStorageInfo(cfg *config.Config) http.HandlerFunc {
+func GetStorageInfo(cfg *config.Config, stores *storage.Stores) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		storagePath := cfg.StoragePath
 		if !filepath.IsAbs(storagePath) {
@@ -19,19 +23,14 @@ func GetStorageInfo(cfg *config.Config) http.HandlerFunc {
 				storagePath = absPath
 			}
 		}
-		var stat syscall.Statfs_t
-		if err := syscall.Statfs(storagePath, &stat); err != nil {
+		space, err := utils.DiskUsage(storagePath)
+		if err != nil {
 			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to get storage info")
 			return
 		}
-		blockSize := uint64(stat.Bsize)
-		totalBlocks := stat.Blocks
-		freeBlocks := stat.Bfree
-		availableBlocks := stat.Bavail
-		totalSize := blockSize * totalBlocks
-		freeSize := blockSize * freeBlocks
-		availableSize := blockSize * availableBlocks
-		usedSize := totalSize - freeSize
+		totalSize := space.Total
+		availableSize := space.Available
+		usedSize := totalSize - space.Free
 		assetsSize, err := getDirSize(cfg.StoragePath)
 		if err != nil {
 			assetsSize = 0
@@ -41,6 +40,7 @@ func GetStorageInfo(cfg *config.Config) http.HandlerFunc {
 			thumbsSize = 0
 		}
 		response := map[string]any{
+			"backend":       stores.Backend,
 			"totalSpace":    utils.FormatFileSize(totalSize),
 			"usedSpace":     utils.FormatFileSize(usedSize),
 			"freeSpace":     utils.FormatFileSize(availableSize),
@@ -74,3 +74,129 @@ func getDirSize(path string) (uint64, error) {
 	})
 	return size, err
 }
+
+// BYTES ON DISK FOR ONE GROUP OF ASSETS
+type usageBucket struct {
+	Files      int   `json:"files"`
+	Originals  int64 `json:"originals"`
+	Thumbnails int64 `json:"thumbnails"`
+	Total      int64 `json:"total"`
+}
+
+func (b *usageBucket) add(original, thumbnail int64) {
+	b.Files++
+	b.Originals += original
+	b.Thumbnails += thumbnail
+	b.Total += original + thumbnail
+}
+
+// USAGE FOR ONE JOB
+type jobUsage struct {
+	JobID string `json:"jobId"`
+	Name  string `json:"name"`
+	usageBucket
+}
+
+// SUMMARIZE BYTES ON DISK PER JOB, PER ASSET TYPE, ORIGINALS VS THUMBNAILS, AND THE VOLUME'S FREE
+// SPACE. ASSETS UPLOADED TO A BUCKET ARE TOTALLED SEPARATELY, BY THEIR RECORDED SIZE
+func GetStorageUsage(db *gorm.DB, cfg *config.Config, stores *storage.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var assets []models.Asset
+		if err := db.Select("id", "job_id", "type", "local_path", "thumbnail_path", "size", "storage", "storage_status").Find(&assets).Error; err != nil {
+			log.Printf("Failed to load assets for storage usage: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to load assets")
+			return
+		}
+		var jobs []models.Job
+		db.Select("id", "name").Find(&jobs)
+		names := make(map[string]string, len(jobs))
+		for _, job := range jobs {
+			names[job.ID] = job.Name
+		}
+
+		// SIZES COME FROM THE FILES THEMSELVES; MISSING FILES COUNT AS ZERO
+		fileSize := func(root, name string) int64 {
+			full, ok := pathUnder(root, name)
+			if !ok {
+				return 0
+			}
+			info, err := os.Stat(full)
+			if err != nil || info.IsDir() {
+				return 0
+			}
+			return info.Size()
+		}
+
+		var total, remote usageBucket
+		byJob := make(map[string]*jobUsage)
+		byType := make(map[string]*usageBucket)
+		for _, asset := range assets {
+			if asset.IsRemote() {
+				remote.add(asset.Size, 0)
+			}
+			original := fileSize(cfg.StoragePath, asset.LocalPath)
+			thumbnail := fileSize(cfg.ThumbnailsPath, asset.ThumbnailPath)
+			total.add(original, thumbnail)
+
+			job, ok := byJob[asset.JobID]
+			if !ok {
+				job = &jobUsage{JobID: asset.JobID, Name: names[asset.JobID]}
+				byJob[asset.JobID] = job
+			}
+			job.add(original, thumbnail)
+
+			assetType := asset.Type
+			if assetType == "" {
+				assetType = "unknown"
+			}
+			if _, ok := byType[assetType]; !ok {
+				byType[assetType] = &usageBucket{}
+			}
+			byType[assetType].add(original, thumbnail)
+		}
+
+		jobList := make([]jobUsage, 0, len(byJob))
+		for _, job := range byJob {
+			jobList = append(jobList, *job)
+		}
+		sort.Slice(jobList, func(i, j int) bool { return jobList[i].Total > jobList[j].Total })
+
+		// FILES IN THE STORAGE DIRECTORIES THAT NO ASSET ACCOUNTS FOR (SEE POST /admin/gc)
+		storageBytes, _ := getDirSize(cfg.StoragePath)
+		thumbsBytes, _ := getDirSize(cfg.ThumbnailsPath)
+		untracked := int64(storageBytes) + int64(thumbsBytes) - total.Total
+		absThumbs, _ := filepath.Abs(cfg.ThumbnailsPath)
+		if _, nested := pathUnder(cfg.StoragePath, absThumbs); nested {
+			untracked -= int64(thumbsBytes) // THUMBNAILS LIVE INSIDE STORAGE AND WERE COUNTED TWICE
+		}
+		if untracked < 0 {
+			untracked = 0
+		}
+
+		response := map[string]any{
+			"total":     total,
+			"untracked": untracked,
+			"backend":   stores.Backend,
+			"remote":    remote,
+			"byJob":     jobList,
+			"byType":    byType,
+		}
+		if volume, err := volumeUsage(cfg.StoragePath); err == nil {
+			response["volume"] = volume
+		}
+		utils.RespondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+// SPACE ON THE VOLUME HOLDING THE GIVEN PATH
+func volumeUsage(path string) (map[string]uint64, error) {
+	space, err := utils.DiskUsage(path)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]uint64{
+		"totalBytes":     space.Total,
+		"usedBytes":      space.Total - space.Free,
+		"availableBytes": space.Available,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// REPORT THE CONTAINER, SANDBOX, BROWSER INSTALL AND MEDIA TOOL SITUATION
+func GetEnvironment(engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    engine.Environment(),
+		})
+	}
+}
+
+// REPORT MEMORY USE, PRESSURE AND WHAT THE WATCHDOG HAS DONE ABOUT IT
+func GetMemoryStatus(engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    engine.MemoryStatus(),
+		})
+	}
+}
+
+// REPORT THE BROWSER POOL: ITS LIMITS, OPEN BROWSERS AND TABS, AND WHAT'S WAITING FOR ROOM
+func GetBrowserPool(engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    engine.BrowserPoolStats(),
+		})
+	}
+}
+
+// CHANGE THE BROWSER POOL'S LIMITS WHILE IT RUNS. OMITTED FIELDS KEEP THEIR VALUE; NOTHING OPEN
+// IS CLOSED, A LOWER LIMIT ONLY HOLDS BACK NEW BROWSERS AND TABS UNTIL ENOUGH HAVE CLOSED
+func UpdateBrowserPool(engine *scraper.Engine, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MaxBrowsers     *int `json:"maxBrowsers" validate:"omitempty,gte=0,lte=256"`
+			MaxBrowserTabs  *int `json:"maxBrowserTabs" validate:"omitempty,gte=0,lte=100"`
+			BrowserLifetime *int `json:"browserLifetime" validate:"omitempty,gte=0"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if errs := validation.Struct(body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+
+		limits := engine.BrowserPoolStats().Limits
+		if body.MaxBrowsers != nil {
+			limits.MaxBrowsers = *body.MaxBrowsers
+		}
+		if body.MaxBrowserTabs != nil {
+			limits.MaxBrowserTabs = *body.MaxBrowserTabs
+		}
+		if body.BrowserLifetime != nil {
+			limits.BrowserLifetime = *body.BrowserLifetime
+		}
+		if err := engine.SetBrowserPoolLimits(limits); err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "browserLifetime", Message: err.Error(), Rule: "range"}})
+			return
+		}
+		if err := config.SaveConfig(cfg, "config.json"); err != nil {
+			log.Printf("Failed to save browser pool limits: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save app configuration")
+			return
+		}
+
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    engine.BrowserPoolStats(),
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// -- PIPELINE TEMPLATES --
+//
+// A TEMPLATE IS A JOB WITH {{name}} PLACEHOLDERS. MAKING A JOB FROM ONE FILLS THEM FROM THE CALLER'S
+// VARIABLES (OR THEIR DEFAULTS): A STRING THAT IS ONLY A PLACEHOLDER TAKES THE VALUE AS IT IS, SO
+// "maxDepth": "{{maxDepth}}" BECOMES A NUMBER, AND ANY OTHER STRING GETS IT SPLICED IN AS TEXT.
+// TEMPLATES EXPORT AS A PORTABLE JSON DOCUMENT ANOTHER INSTANCE CAN IMPORT.
+
+// THE EXPORT DOCUMENT'S FORMAT NAME AND THE NEWEST VERSION THIS BUILD READS
+const (
+	templateExportFormat  = "crepes-templates"
+	templateExportVersion = 1
+)
+
+var (
+	placeholderPattern  = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// JOB FIELDS A TEMPLATE MAY SET (IDS, STATUS AND TIMESTAMPS BELONG TO THE JOBS MADE FROM IT)
+var templateJobFields = []string{"name", "baseUrl", "description", "schedule", "selectors", "filters", "rules", "processing", "tags", "folderId", "pipeline"}
+
+// ONE {{name}} A TEMPLATE TAKES
+type templateVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     any    `json:"default,omitempty"`
+	Required    bool   `json:"required,omitempty"` // WITHOUT A DEFAULT; AN OPTIONAL ONE LEFT OUT BECOMES ""
+}
+
+// A TEMPLATE AS IT TRAVELS BETWEEN INSTANCES
+type exportedTemplate struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Variables   models.JSONArray `json:"variables"`
+	Job         models.JSONMap   `json:"job"`
+	Tags        models.JSONArray `json:"tags,omitempty"`
+}
+
+type templateExport struct {
+	Format     string             `json:"format"`
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exportedAt"`
+	Templates  []exportedTemplate `json:"templates"`
+}
+
+// LIST THE USER'S TEMPLATES BY NAME, OPTIONALLY ONLY THOSE WITH A TAG
+func GetTemplates(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		templates := []models.Template{}
+		if err := db.Scopes(auth.Owned(auth.UserFrom(r.Context()))).Order("name").Find(&templates).Error; err != nil {
+			log.Printf("Failed to fetch templates: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch templates")
+			return
+		}
+		if tag := r.URL.Query().Get("tag"); tag != "" {
+			templates = slices.DeleteFunc(templates, func(t models.Template) bool { return !slices.Contains(t.Tags, any(tag)) })
+		}
+		utils.RespondWithJSON(w, http.StatusOK, templates)
+	}
+}
+
+func GetTemplate(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := findTemplate(w, db, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, template)
+	}
+}
+
+// CREATE A TEMPLATE; PLACEHOLDERS THE JOB USES BUT variables DOESN'T LIST ARE ADDED AS REQUIRED
+func CreateTemplate(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var template models.Template
+		if errs := validation.DecodeJSON(r.Body, &template); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if errs := normalizeTemplate(&template); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		template.ID = utils.GenerateID("template")
+		template.OwnerID = ownerOf(r)
+		if err := db.Create(&template).Error; err != nil {
+			log.Printf("Failed to create template: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create template")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, template)
+	}
+}
+
+// CHANGE A TEMPLATE (OMITTED FIELDS ARE LEFT AS THEY ARE)
+func UpdateTemplate(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := findTemplate(w, db, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+		var update struct {
+			Name        *string           `json:"name"`
+			Description *string           `json:"description"`
+			Variables   *models.JSONArray `json:"variables"`
+			Job         *models.JSONMap   `json:"job"`
+			Tags        *models.JSONArray `json:"tags"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &update); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if update.Name != nil {
+			template.Name = *update.Name
+		}
+		if update.Description != nil {
+			template.Description = *update.Description
+		}
+		if update.Variables != nil {
+			template.Variables = *update.Variables
+		}
+		if update.Job != nil {
+			template.Job = *update.Job
+		}
+		if update.Tags != nil {
+			template.Tags = *update.Tags
+		}
+		if errs := normalizeTemplate(&template); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		template.UpdatedAt = time.Now()
+		if err := db.Save(&template).Error; err != nil {
+			log.Printf("Failed to update template: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update template")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, template)
+	}
+}
+
+func DeleteTemplate(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := db.Delete(&models.Template{}, "id = ?", mux.Vars(r)["id"])
+		if result.Error != nil {
+			log.Printf("Failed to delete template: %v", result.Error)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete template")
+			return
+		}
+		if result.RowsAffected == 0 {
+			utils.RespondWithError(w, http.StatusNotFound, "Template not found")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"message": "Template deleted successfully",
+		})
+	}
+}
+
+// MAKE A JOB FROM A TEMPLATE WITH THE GIVEN VARIABLES; name, folderId AND schedule OVERRIDE THE TEMPLATE'S
+func InstantiateTemplate(db *gorm.DB, engine *scraper.Engine, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := findTemplate(w, db, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+		var body struct {
+			Variables map[string]any `json:"variables"`
+			Name      string         `json:"name" validate:"max=200"`
+			FolderID  *string        `json:"folderId"`
+			Schedule  *string        `json:"schedule"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &body); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		values, errs := templateValues(&template, body.Variables)
+		if errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+
+		fields, _ := fillPlaceholders(map[string]any(template.Job), values).(map[string]any)
+		if fields == nil {
+			fields = map[string]any{}
+		}
+		// JOBS KEEP THEIR PIPELINE AS A JSON STRING
+		if pipeline, ok := fields["pipeline"]; ok {
+			raw, _ := json.Marshal(pipeline)
+			fields["pipeline"] = string(raw)
+		}
+		if body.Name != "" {
+			fields["name"] = body.Name
+		} else if name, _ := fields["name"].(string); name == "" {
+			fields["name"] = template.Name
+		}
+		if body.FolderID != nil {
+			fields["folderId"] = *body.FolderID
+		}
+		if body.Schedule != nil {
+			fields["schedule"] = *body.Schedule
+		}
+
+		raw, _ := json.Marshal(fields)
+		var job models.Job
+		if errs := validation.DecodeJSON(bytes.NewReader(raw), &job); errs != nil {
+			respondWithValidationErrors(w, errs.Prefix("job"))
+			return
+		}
+		if errs := append(validateJob(engine, &job), validateJobFolder(db, auth.UserFrom(r.Context()), job.FolderID)...); errs != nil {
+			respondWithValidationErrors(w, errs.Prefix("job"))
+			return
+		}
+		job.ID = utils.GenerateID("job")
+		job.OwnerID = ownerOf(r)
+		job.Status = "idle"
+		job.CreatedAt = time.Now()
+		job.UpdatedAt = job.CreatedAt
+		if err := db.Create(&job).Error; err != nil {
+			log.Printf("Failed to create job from template: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create job")
+			return
+		}
+		if job.Schedule != "" {
+			scheduler.ScheduleJob(&job)
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, job)
+	}
+}
+
+// DOWNLOAD THE USER'S TEMPLATES AS A PORTABLE DOCUMENT: ONE ({id}), THOSE LISTED IN ?ids=, OR ALL OF THEM
+func ExportTemplates(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := db.Scopes(auth.Owned(auth.UserFrom(r.Context()))).Order("name")
+		filename := "templates.json"
+		if id := mux.Vars(r)["id"]; id != "" {
+			query = query.Where("id = ?", id)
+			filename = id + ".json"
+		} else if ids := r.URL.Query().Get("ids"); ids != "" {
+			query = query.Where("id IN ?", strings.Split(ids, ","))
+		}
+		var templates []models.Template
+		if err := query.Find(&templates).Error; err != nil {
+			log.Printf("Failed to export templates: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to export templates")
+			return
+		}
+		if len(templates) == 0 && mux.Vars(r)["id"] != "" {
+			utils.RespondWithError(w, http.StatusNotFound, "Template not found")
+			return
+		}
+		doc := templateExport{
+			Format:     templateExportFormat,
+			Version:    templateExportVersion,
+			ExportedAt: time.Now().UTC(),
+			Templates:  make([]exportedTemplate, len(templates)),
+		}
+		for i, t := range templates {
+			doc.Templates[i] = exportedTemplate{Name: t.Name, Description: t.Description, Variables: t.Variables, Job: t.Job, Tags: t.Tags}
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		utils.RespondWithJSON(w, http.StatusOK, doc)
+	}
+}
+
+// ADD THE TEMPLATES IN AN EXPORTED DOCUMENT AS NEW TEMPLATES; NOTHING IS ADDED IF ANY IS INVALID
+func ImportTemplates(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var doc templateExport
+		if errs := validation.DecodeJSON(r.Body, &doc); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		var errs validation.Errors
+		if doc.Format != templateExportFormat {
+			errs = append(errs, validation.FieldError{Path: "format", Message: "not a template export", Expected: templateExportFormat, Rule: "oneof"})
+		}
+		if doc.Version < 1 || doc.Version > templateExportVersion {
+			errs = append(errs, validation.FieldError{Path: "version", Message: fmt.Sprintf("unsupported version %d", doc.Version),
+				Expected: fmt.Sprintf("1 to %d", templateExportVersion), Rule: "range"})
+		}
+		if len(doc.Templates) == 0 {
+			errs = append(errs, validation.FieldError{Path: "templates", Message: "no templates to import", Rule: "required"})
+		}
+		now := time.Now()
+		owner := ownerOf(r)
+		templates := make([]models.Template, len(doc.Templates))
+		for i, exported := range doc.Templates {
+			templates[i] = models.Template{
+				ID:          utils.GenerateID("template"),
+				Name:        exported.Name,
+				Description: exported.Description,
+				Variables:   exported.Variables,
+				Job:         exported.Job,
+				Tags:        exported.Tags,
+				OwnerID:     owner,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			errs = append(errs, normalizeTemplate(&templates[i]).Prefix(fmt.Sprintf("templates[%d]", i))...)
+		}
+		if len(errs) > 0 {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if err := db.Create(&templates).Error; err != nil {
+			log.Printf("Failed to import templates: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to import templates")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusCreated, templates)
+	}
+}
+
+func findTemplate(w http.ResponseWriter, db *gorm.DB, id string) (models.Template, bool) {
+	var template models.Template
+	found := db.Where("id = ?", id).Limit(1).Find(&template)
+	if found.Error != nil {
+		log.Printf("Failed to fetch template: %v", found.Error)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch template")
+		return template, false
+	}
+	if found.RowsAffected == 0 {
+		utils.RespondWithError(w, http.StatusNotFound, "Template not found")
+		return template, false
+	}
+	return template, true
+}
+
+// CHECK A TEMPLATE AND TIDY IT: THE PIPELINE IS KEPT AS JSON (NOT A STRING) SO PLACEHOLDERS
+// STAY INSIDE ITS VALUES, AND PLACEHOLDERS THE JOB USES ARE DECLARED AS VARIABLES
+func normalizeTemplate(template *models.Template) validation.Errors {
+	template.Name = strings.TrimSpace(template.Name)
+	errs := validation.Struct(template)
+	if template.Job == nil {
+		template.Job = models.JSONMap{}
+	}
+	if template.Tags == nil {
+		template.Tags = models.JSONArray{}
+	}
+	for key := range template.Job {
+		if !slices.Contains(templateJobFields, key) {
+			errs = append(errs, validation.FieldError{Path: "job." + key, Message: "not a job field a template can set",
+				Expected: strings.Join(templateJobFields, ", "), Rule: "field"})
+		}
+	}
+	switch pipeline := template.Job["pipeline"].(type) {
+	case nil, []any:
+	case string:
+		var stages []any
+		if err := json.Unmarshal([]byte(pipeline), &stages); err != nil {
+			errs = append(errs, validation.FieldError{Path: "job.pipeline", Message: "pipeline is not a JSON list of stages", Expected: "array", Rule: "type"})
+		} else {
+			template.Job["pipeline"] = stages
+		}
+	default:
+		errs = append(errs, validation.FieldError{Path: "job.pipeline", Message: "pipeline must be a list of stages", Expected: "array", Rule: "type"})
+	}
+
+	var variables []templateVariable
+	raw, _ := json.Marshal(template.Variables)
+	if err := json.Unmarshal(raw, &variables); err != nil {
+		return append(errs, validation.FieldError{Path: "variables", Message: "variables must be a list of {name, description, default, required}", Expected: "array", Rule: "type"})
+	}
+	declared := make(map[string]bool)
+	for i, v := range variables {
+		path := fmt.Sprintf("variables[%d].name", i)
+		switch {
+		case !variableNamePattern.MatchString(v.Name):
+			errs = append(errs, validation.FieldError{Path: path, Message: fmt.Sprintf("invalid variable name %q", v.Name), Expected: "letters, digits and _", Rule: "pattern"})
+		case declared[v.Name]:
+			errs = append(errs, validation.FieldError{Path: path, Message: fmt.Sprintf("variable %q is declared twice", v.Name), Rule: "unique"})
+		}
+		declared[v.Name] = true
+	}
+	used := make(map[string]bool)
+	collectPlaceholders(map[string]any(template.Job), used)
+	var undeclared []string
+	for name := range used {
+		if !declared[name] {
+			undeclared = append(undeclared, name)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		variables = append(variables, templateVariable{Name: name, Required: true})
+	}
+	raw, _ = json.Marshal(variables)
+	template.Variables = models.JSONArray{}
+	json.Unmarshal(raw, &template.Variables)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// THE VALUE OF EACH OF A TEMPLATE'S VARIABLES: THE CALLER'S, THE DEFAULT, OR "" FOR AN OPTIONAL ONE
+func templateValues(template *models.Template, supplied map[string]any) (map[string]any, validation.Errors) {
+	var variables []templateVariable
+	raw, _ := json.Marshal(template.Variables)
+	json.Unmarshal(raw, &variables)
+
+	var errs validation.Errors
+	values := make(map[string]any, len(variables))
+	for _, v := range variables {
+		if value, ok := supplied[v.Name]; ok && value != nil {
+			values[v.Name] = value
+		} else if v.Default != nil {
+			values[v.Name] = v.Default
+		} else if v.Required {
+			errs = append(errs, validation.FieldError{Path: "variables." + v.Name, Message: "variable is required", Rule: "required"})
+		} else {
+			values[v.Name] = ""
+		}
+	}
+	for name := range supplied {
+		if _, ok := values[name]; !ok && !slices.ContainsFunc(variables, func(v templateVariable) bool { return v.Name == name }) {
+			errs = append(errs, validation.FieldError{Path: "variables." + name, Message: "the template has no such variable", Rule: "unknown"})
+		}
+	}
+	return values, errs
+}
+
+// NAMES OF THE PLACEHOLDERS IN A VALUE
+func collectPlaceholders(value any, into map[string]bool) {
+	switch v := value.(type) {
+	case string:
+		for _, m := range placeholderPattern.FindAllStringSubmatch(v, -1) {
+			into[m[1]] = true
+		}
+	case map[string]any:
+		for _, item := range v {
+			collectPlaceholders(item, into)
+		}
+	case []any:
+		for _, item := range v {
+			collectPlaceholders(item, into)
+		}
+	}
+}
+
+// A COPY OF A VALUE WITH ITS PLACEHOLDERS FILLED IN
+func fillPlaceholders(value any, values map[string]any) any {
+	switch v := value.(type) {
+	case string:
+		if m := placeholderPattern.FindStringSubmatch(v); m != nil && m[0] == v {
+			return values[m[1]]
+		}
+		return placeholderPattern.ReplaceAllStringFunc(v, func(match string) string {
+			filled := values[placeholderPattern.FindStringSubmatch(match)[1]]
+			if s, ok := filled.(string); ok {
+				return s
+			}
+			raw, _ := json.Marshal(filled)
+			return string(raw)
+		})
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = fillPlaceholders(item, values)
+		}
+		return out
+	case models.JSONMap:
+		return fillPlaceholders(map[string]any(v), values)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = fillPlaceholders(item, values)
+		}
+		return out
+	}
+	return value
+}
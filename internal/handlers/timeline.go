@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// ONE RUN'S TIMELINE, LAID OUT IN LANES FOR A GANTT CHART: ?runId= (DEFAULTS TO THE RUN IN
+// PROGRESS, THEN THE JOB'S LATEST) AND ?kind= (COMMA-SEPARATED, E.G. stage,task). SPANS SHOW UP
+// AS THEY END, SO A RUN IN PROGRESS SHOWS WHAT HAS FINISHED SO FAR
+func GetJobTimeline(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		values := r.URL.Query()
+		liveRun, live := engine.LiveTimeline(id)
+		runID := values.Get("runId")
+		if runID == "" {
+			runID = liveRun
+		}
+		if runID == "" {
+			var latest models.RunEvent
+			db.Select("run_id").Where("job_id = ?", id).Order("created_at DESC").Limit(1).Find(&latest)
+			runID = latest.RunID
+		}
+		if runID == "" {
+			utils.RespondWithError(w, http.StatusNotFound, "Job has no run timeline")
+			return
+		}
+
+		// THE RUN'S OWN SPAN IS ALWAYS KEPT; IT SETS THE TIMELINE'S START, END AND STATUS
+		query := db.Where("job_id = ? AND run_id = ?", id, runID)
+		kinds := splitList(values.Get("kind"))
+		if len(kinds) > 0 {
+			kinds = append(kinds, scraper.SpanRun)
+			query = query.Where("kind IN ?", kinds)
+		}
+		spans := []models.RunEvent{}
+		if err := query.Find(&spans).Error; err != nil {
+			log.Printf("Failed to fetch timeline: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch timeline")
+			return
+		}
+		if runID == liveRun {
+			for _, span := range live {
+				if len(kinds) == 0 || slices.Contains(kinds, span.Kind) {
+					spans = append(spans, span)
+				}
+			}
+		}
+		if len(spans) == 0 && runID != liveRun {
+			utils.RespondWithError(w, http.StatusNotFound, "Run not found")
+			return
+		}
+
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    scraper.BuildTimeline(runID, spans),
+		})
+	}
+}
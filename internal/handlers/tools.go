@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm"
+)
+
+// HOW LONG A TASK REPLAY MAY RUN
+const replayTimeout = 2 * time.Minute
+
+// RUN MEDIA EXTRACTION AGAINST A URL WITHOUT CREATING A JOB
+func ExtractMedia(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			URL         string            `json:"url"`
+			Headers     map[string]string `json:"headers"`
+			Fingerprint string            `json:"fingerprint"`
+			ProbeSizes  *bool             `json:"probeSizes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		target, err := url.Parse(request.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid URL provided")
+			return
+		}
+		if err := scraper.ValidateFingerprint(request.Fingerprint); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fingerprint := cfg.TLSFingerprint
+		if request.Fingerprint != "" {
+			fingerprint = request.Fingerprint
+		}
+		probeSizes := true
+		if request.ProbeSizes != nil {
+			probeSizes = *request.ProbeSizes
+		}
+
+		guard := scraper.NetGuardFromConfig(cfg)
+		if err := guard.CheckURL(r.Context(), request.URL); scraper.IsBlocked(err) {
+			utils.RespondWithError(w, http.StatusForbidden, "URL points to a blocked address or domain")
+			return
+		}
+
+		sources, report, err := scraper.ExtractMediaStreams(r.Context(), request.URL, scraper.MediaExtractOptions{
+			Fingerprint: fingerprint,
+			HTTPVersion: cfg.HTTPVersion,
+			TLS:         scraper.TLSPolicyFromConfig(cfg),
+			Guard:       guard,
+			DNS:         scraper.DNSPolicyFromConfig(cfg),
+			Egress:      scraper.EgressPolicyFromConfig(cfg),
+			Headers:     request.Headers,
+			ProbeSizes:  probeSizes,
+			Limits:      scraper.HTMLLimitsFromConfig(cfg),
+		})
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadGateway, "Extraction failed: "+err.Error())
+			return
+		}
+		for _, warning := range report.Warnings {
+			log.Printf("Media extraction from %s: %s", request.URL, warning)
+		}
+		if sources == nil {
+			sources = []scraper.MediaSource{}
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data": map[string]any{
+				"url":     request.URL,
+				"sources": sources,
+				"count":   len(sources),
+				"parse":   report,
+			},
+		})
+	}
+}
+
+// RE-RUN THE TASK BEHIND A LOGGED ERROR AGAINST ITS CAPTURED SNAPSHOT, OPTIONALLY WITH
+// CONFIG OVERRIDES (E.G. A FIXED SELECTOR), AND REPORT WHAT IT RETURNED OR WHY IT FAILED
+func ReplayTask(db *gorm.DB, engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ErrorID string         `json:"errorId"`
+			Config  map[string]any `json:"config"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if request.ErrorID == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "errorId is required")
+			return
+		}
+		var entry models.ErrorLog
+		if err := db.First(&entry, "id = ?", request.ErrorID).Error; err != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Error log not found")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), replayTimeout)
+		defer cancel()
+		start := time.Now()
+		result, err := engine.ReplayTask(ctx, entry, request.Config)
+		if errors.Is(err, scraper.ErrReplayNoTask) || errors.Is(err, scraper.ErrReplayNoSnapshot) {
+			utils.RespondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		// A TASK THAT FAILS AGAIN IS A SUCCESSFUL REPLAY; THE ERROR IS PART OF THE RESULT
+		response := map[string]any{
+			"errorId":    entry.ID,
+			"taskType":   entry.TaskType,
+			"success":    err == nil,
+			"durationMs": time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			response["error"] = err.Error()
+		} else {
+			response["result"] = result
+		}
+		utils.RespondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+// LIMITS ON HOW MANY RUN TIMES A SCHEDULE PREVIEW RETURNS
+const (
+	defaultPreviewRuns = 10
+	maxPreviewRuns     = 100
+)
+
+// VALIDATE A CRON SCHEDULE (OPTIONALLY IN A TIMEZONE) AND LIST ITS NEXT RUN TIMES
+func PreviewSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Schedule string `json:"schedule"`
+			Timezone string `json:"timezone"`
+			Count    int    `json:"count"`
+		}
+		if errs := validation.DecodeJSON(r.Body, &request); errs != nil {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+		if strings.TrimSpace(request.Schedule) == "" {
+			respondWithValidationErrors(w, validation.Errors{{Path: "schedule", Message: "is required", Rule: "required"}})
+			return
+		}
+		count := request.Count
+		if count <= 0 {
+			count = defaultPreviewRuns
+		}
+		if count > maxPreviewRuns {
+			count = maxPreviewRuns
+		}
+
+		spec, err := scraper.ScheduleWithTimezone(request.Schedule, request.Timezone)
+		if err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "timezone", Message: err.Error(), Expected: "IANA timezone", Rule: "timezone"}})
+			return
+		}
+		// REPORT RUN TIMES IN THE SCHEDULE'S OWN TIMEZONE
+		from := time.Now()
+		if loc, err := time.LoadLocation(request.Timezone); err == nil && request.Timezone != "" {
+			from = from.In(loc)
+		}
+		runs, err := scraper.PreviewSchedule(spec, from, count)
+		if err != nil {
+			respondWithValidationErrors(w, validation.Errors{{Path: "schedule", Message: err.Error(), Expected: "cron expression", Rule: "cron"}})
+			return
+		}
+
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"valid":    true,
+			"schedule": spec, // WHAT TO STORE ON THE JOB TO GET THESE RUN TIMES
+			"timezone": request.Timezone,
+			"nextRuns": runs,
+		})
+	}
+}
+
+// SEND A TEST NOTIFICATION TO EVERY CONFIGURED WEBHOOK (OR THE ONE NAMED IN {"channel": ...})
+// AND REPORT WHICH ONES ACCEPTED IT
+func TestNotification(engine *scraper.Engine, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Channel string `json:"channel"`
+		}
+		if r.ContentLength != 0 {
+			if errs := validation.DecodeJSON(r.Body, &request); errs != nil {
+				respondWithValidationErrors(w, errs)
+				return
+			}
+		}
+		notification := scraper.Notification{
+			Type:      "test",
+			Title:     "Test notification from Crepes",
+			Message:   "If you can read this, the channel is set up correctly.",
+			Timestamp: time.Now(),
+		}
+
+		results := []map[string]any{}
+		for _, channel := range cfg.Notifications.Webhooks {
+			if request.Channel != "" && channel.Name != request.Channel {
+				continue
+			}
+			result := map[string]any{"name": channel.Name, "format": channel.Format, "ok": true}
+			if err := engine.Notifier().Send(r.Context(), channel, notification); err != nil {
+				result["ok"] = false
+				result["error"] = err.Error()
+			}
+			results = append(results, result)
+		}
+		if request.Channel != "" && len(results) == 0 {
+			utils.RespondWithError(w, http.StatusNotFound, "Notification channel not found")
+			return
+		}
+		engine.Events().Publish("notification", "", notification)
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{"channels": results})
+	}
+}
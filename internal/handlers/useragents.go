@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nickheyer/Crepes/internal/scraper"
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// THE USER AGENT POOL IN USE, WHAT THE LAST MANIFEST CHECK DID AND WHEN THE NEXT ONE RUNS
+func GetUserAgents(engine *scraper.Engine, scheduler *scraper.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]any{
+			"pool":      scraper.CurrentUserAgents(),
+			"last":      engine.LastUserAgentUpdate(),
+			"nextCheck": nil,
+		}
+		if next := scheduler.NextUserAgentUpdate(); !next.IsZero() {
+			data["nextCheck"] = next
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    data,
+		})
+	}
+}
+
+// CHECK THE USER AGENT MANIFEST NOW. A FAILED CHECK STILL ANSWERS 200 WITH ITS REPORT
+func UpdateUserAgents(engine *scraper.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := engine.UpdateUserAgents(r.Context(), "api")
+		switch {
+		case errors.Is(err, scraper.ErrUserAgentUpdatesOff):
+			utils.RespondWithError(w, http.StatusBadRequest, "User agent updates are not configured")
+			return
+		case errors.Is(err, scraper.ErrUserAgentUpdateRunning):
+			utils.RespondWithError(w, http.StatusConflict, "A user agent update is already running")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]any{
+			"success": true,
+			"data":    report,
+		})
+	}
+}
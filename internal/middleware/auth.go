@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// ROUTES ANSWERED WITHOUT A SESSION
+var publicRoutes = []string{"/api/auth/login", "/api/auth/setup", "/api/auth/status"}
+
+// ROUTES (AND EVERYTHING UNDER THEM) ONLY ADMINS MAY CALL: INSTANCE SETTINGS, SECRETS, STORAGE,
+// UPKEEP, ACCOUNTS, AND ERROR LOGS AND TOOLS THAT REACH ACROSS JOBS
+var adminRoutes = []string{
+	"/api/settings", "/api/cache", "/api/secrets", "/api/storage", "/api/admin", "/api/system",
+	"/api/users", "/api/errors", "/api/tools/replay", "/api/tools/test-notification",
+}
+
+// AUTH MIDDLEWARE: WITH auth.enabled, PUTS THE SESSION'S USER ON THE REQUEST (OR ANSWERS 401),
+// KEEPS ADMIN ROUTES TO ADMINS AND ANSWERS 404 FOR JOBS, ASSETS, THUMBNAILS, RECORDS, FOLDERS AND
+// TEMPLATES OF OTHER USERS.
+// LISTS ARE FILTERED BY THE HANDLERS. RUNS AFTER ROUTING SO IT CAN SEE THE ROUTE TEMPLATE
+func AuthMiddleware(db *gorm.DB, cfg config.AuthConfig) mux.MiddlewareFunc {
+	lifetime := cfg.SessionLifetime()
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			template := r.URL.Path
+			if route := mux.CurrentRoute(r); route != nil {
+				if t, err := route.GetPathTemplate(); err == nil {
+					template = t
+				}
+			}
+			for _, public := range publicRoutes {
+				if template == public {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			// THE RATE LIMITER MAY HAVE CHECKED THE TOKEN ALREADY
+			user := auth.UserFrom(r.Context())
+			if user == nil {
+				resolved, err := auth.ResolveSession(db, SessionToken(r), lifetime)
+				if err != nil {
+					utils.RespondWithError(w, http.StatusUnauthorized, "Authentication required")
+					return
+				}
+				user = resolved
+			}
+			if user.Role != auth.RoleAdmin {
+				for _, prefix := range adminRoutes {
+					if template == prefix || strings.HasPrefix(template, prefix+"/") {
+						utils.RespondWithError(w, http.StatusForbidden, "Admin access required")
+						return
+					}
+				}
+				if message := ownershipCheck(db, user, template, r); message != "" {
+					utils.RespondWithError(w, http.StatusNotFound, message)
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+		})
+	}
+}
+
+// THE TOKEN A REQUEST CARRIES: Authorization: Bearer, OR THE UI'S SESSION COOKIE
+func SessionToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// THE NOT-FOUND MESSAGE WHEN A NON-ADMIN ASKS FOR SOMETHING OF ANOTHER USER'S, OR ""
+func ownershipCheck(db *gorm.DB, user *models.User, template string, r *http.Request) string {
+	id := mux.Vars(r)["id"]
+	jobOf := func(model any, column string) string {
+		var jobID string
+		db.Model(model).Where(column+" = ?", id).Limit(1).Pluck("job_id", &jobID)
+		return jobID
+	}
+	// AN UNKNOWN ID PASSES SO THE HANDLER CAN 404 IT
+	ownedBy := func(model any) bool {
+		var owners []string
+		db.Model(model).Where("id = ?", id).Limit(1).Pluck("owner_id", &owners)
+		return len(owners) == 0 || owners[0] == user.ID
+	}
+	switch {
+	case strings.HasPrefix(template, "/api/jobs/filters/"), strings.HasPrefix(template, "/api/jobs/presets/"):
+		return ""
+	case strings.HasPrefix(template, "/api/jobs/{id}"):
+		if !auth.CanAccessJob(db, user, id) {
+			return "Job not found"
+		}
+	case strings.HasPrefix(template, "/api/assets/{id}"):
+		if jobID := jobOf(&models.Asset{}, "id"); jobID != "" && !auth.CanAccessJob(db, user, jobID) {
+			return "Asset not found"
+		}
+	case template == "/api/assets/":
+		// STORED FILES ARE SERVED BY PATH; ONLY THOSE OF THE USER'S OWN ASSETS
+		_, rel, _ := strings.Cut(r.URL.Path, "/api/assets/")
+		var asset models.Asset
+		if db.Select("job_id").Where("local_path = ?", filepath.FromSlash(rel)).Limit(1).Find(&asset).RowsAffected == 0 || !auth.CanAccessJob(db, user, asset.JobID) {
+			return "File not found"
+		}
+	case template == "/api/thumbnails/":
+		// THUMBNAILS ARE SERVED BY PATH TOO; ONLY THOSE OF THE USER'S OWN ASSETS
+		_, rel, _ := strings.Cut(r.URL.Path, "/api/thumbnails/")
+		var asset models.Asset
+		if db.Select("job_id").Where("thumbnail_path = ?", filepath.FromSlash(rel)).Limit(1).Find(&asset).RowsAffected == 0 || !auth.CanAccessJob(db, user, asset.JobID) {
+			return "File not found"
+		}
+	case strings.HasPrefix(template, "/api/records/rejections/{id}"):
+		if jobID := jobOf(&models.RecordRejection{}, "id"); jobID != "" && !auth.CanAccessJob(db, user, jobID) {
+			return "Rejection not found"
+		}
+	case strings.HasPrefix(template, "/api/records/{id}"):
+		if jobID := jobOf(&models.Record{}, "id"); jobID != "" && !auth.CanAccessJob(db, user, jobID) {
+			return "Record not found"
+		}
+	case strings.HasPrefix(template, "/api/folders/{id}"):
+		if !ownedBy(&models.Folder{}) {
+			return "Folder not found"
+		}
+	case strings.HasPrefix(template, "/api/templates/{id}"):
+		if !ownedBy(&models.Template{}) {
+			return "Template not found"
+		}
+	}
+	return ""
+}
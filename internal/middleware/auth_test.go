@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// ONE USER'S JOBS, ASSETS, FILES, FOLDERS AND TEMPLATES ARE NOT FOUND FOR ANOTHER; ADMINS SEE THEM ALL
+func TestOtherUsersResourcesAreNotFound(t *testing.T) {
+	db := openTestDB(t)
+	alice, aliceToken := testUser(t, db, "alice", auth.RoleUser)
+	_, bobToken := testUser(t, db, "bob", auth.RoleUser)
+	_, adminToken := testUser(t, db, "root", auth.RoleAdmin)
+
+	for _, row := range []any{
+		&models.Job{ID: "job_a", Name: "Alice's job", OwnerID: alice.ID},
+		&models.Asset{ID: "asset_a", JobID: "job_a", LocalPath: filepath.Join("job_a", "a.jpg")},
+		&models.Folder{ID: "folder_a", Name: "Alice's folder", OwnerID: alice.ID},
+		&models.Template{ID: "template_a", Name: "Alice's template", OwnerID: alice.ID},
+		// FROM BEFORE ACCOUNTS: ADMIN-ONLY
+		&models.Folder{ID: "folder_old", Name: "Old folder"},
+		&models.Template{ID: "template_old", Name: "Old template"},
+	} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(AuthMiddleware(db, config.AuthConfig{Enabled: true}))
+	api.Handle("/jobs/{id}", ok)
+	api.Handle("/jobs/{id}/start", ok)
+	api.Handle("/assets/{id}", ok)
+	api.Handle("/assets/{id}/content", ok)
+	api.PathPrefix("/assets/").Handler(ok)
+	api.Handle("/folders/{id}", ok)
+	api.Handle("/templates/{id}", ok)
+	api.Handle("/templates/{id}/instantiate", ok)
+	api.Handle("/settings", ok)
+
+	paths := []struct {
+		path    string
+		owned   bool // ALICE MAY
+		adminOK bool
+	}{
+		{"/api/jobs/job_a", true, true},
+		{"/api/jobs/job_a/start", true, true},
+		{"/api/assets/asset_a", true, true},
+		{"/api/assets/asset_a/content", true, true},
+		{"/api/assets/job_a/a.jpg", true, true},
+		{"/api/folders/folder_a", true, true},
+		{"/api/templates/template_a", true, true},
+		{"/api/templates/template_a/instantiate", true, true},
+		{"/api/folders/folder_old", false, true},
+		{"/api/templates/template_old", false, true},
+	}
+	send := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	status := func(allowed bool) int {
+		if allowed {
+			return http.StatusOK
+		}
+		return http.StatusNotFound
+	}
+	for _, p := range paths {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			if got := send(method, p.path, bobToken); got != http.StatusNotFound {
+				t.Errorf("bob %s %s = %d; want 404", method, p.path, got)
+			}
+			if got, want := send(method, p.path, aliceToken), status(p.owned); got != want {
+				t.Errorf("alice %s %s = %d; want %d", method, p.path, got, want)
+			}
+			if got, want := send(method, p.path, adminToken), status(p.adminOK); got != want {
+				t.Errorf("admin %s %s = %d; want %d", method, p.path, got, want)
+			}
+		}
+	}
+
+	// UNKNOWN IDS REACH THE HANDLER, WHICH ANSWERS THE 404 ITSELF
+	if got := send(http.MethodGet, "/api/folders/folder_missing", bobToken); got != http.StatusOK {
+		t.Errorf("unknown folder = %d; want it passed to the handler", got)
+	}
+	if got := send(http.MethodGet, "/api/settings", bobToken); got != http.StatusForbidden {
+		t.Errorf("bob GET /api/settings = %d; want 403", got)
+	}
+}
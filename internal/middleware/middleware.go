@@ -3,7 +3,13 @@ package middleware
 import (
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/utils"
 )
 
 // LOGGING MIDDLEWARE TO LOG HTTP REQUESTS
@@ -17,21 +23,100 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// CORS MIDDLEWARE TO HANDLE CROSS-ORIGIN REQUESTS
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// SET CORS HEADERS
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		// HANDLE PREFLIGHT REQUESTS
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+// CORS MIDDLEWARE; NO CONFIGURED ORIGINS, OR A BARE "*" AMONG THEM, KEEPS THE PERMISSIVE "*"
+// BEHAVIOUR (WITHOUT CREDENTIALS). ONLY ORIGINS NAMED OR MATCHED BY A WILDCARD, E.G.
+// https://*.example.com OR chrome-extension://*, ARE ECHOED BACK WITH CREDENTIALS
+func CORSMiddleware(origins []string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// SET CORS HEADERS
+			origin := r.Header.Get("Origin")
+			if len(origins) == 0 {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Add("Vary", "Origin")
+				if origin != "" && OriginAllowed(origin, origins) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				} else if slices.Contains(origins, "*") {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, X-Requested-With, Idempotency-Key, X-Crepes-User")
+			w.Header().Set("Access-Control-Expose-Headers", "Content-Range, Content-Length, Accept-Ranges, ETag, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After")
+			w.Header().Set("Access-Control-Max-Age", "600")
+
+			// HANDLE PREFLIGHT REQUESTS
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			// CALL THE NEXT HANDLER
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRF MIDDLEWARE: REJECTS CROSS-ORIGIN BROWSER REQUESTS TO STATE-CHANGING ENDPOINTS.
+// BROWSERS ALWAYS SEND Sec-Fetch-Site OR Origin ON SUCH REQUESTS; CLIENTS THAT SEND
+// NEITHER (CURL, SCRIPTS) ARE NOT SUBJECT TO CSRF AND PASS THROUGH
+func CSRFMiddleware(trustedOrigins []string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "GET", "HEAD", "OPTIONS":
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			origin := r.Header.Get("Origin")
+			if origin != "" && OriginAllowed(origin, trustedOrigins) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch r.Header.Get("Sec-Fetch-Site") {
+			case "same-origin", "none":
+				next.ServeHTTP(w, r)
+				return
+			case "":
+				// OLDER BROWSERS: FALL BACK TO COMPARING Origin WITH THE HOST
+				if origin == "" || sameHost(origin, r.Host) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			log.Printf("CSRF: REJECTED %s %s FROM ORIGIN %q", r.Method, r.URL.Path, origin)
+			utils.RespondWithError(w, http.StatusForbidden, "Cross-origin request blocked")
+		})
+	}
+}
+
+// WHETHER AN ORIGIN MATCHES ONE OF THE CONFIGURED PATTERNS. A BARE "*" MATCHES NOTHING HERE: IT
+// OPENS READS TO ANY ORIGIN WITHOUT CREDENTIALS, NEVER CREDENTIALED OR STATE-CHANGING REQUESTS
+func OriginAllowed(origin string, patterns []string) bool {
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+		if pattern == "*" {
+			continue
+		}
+		if pattern == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(pattern, "*"); ok &&
+			len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
 		}
+	}
+	return false
+}
 
-		// CALL THE NEXT HANDLER
-		next.ServeHTTP(w, r)
-	})
+func sameHost(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, host)
 }
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWildcardOriginGetsNoCredentials(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	origins := []string{"*", "https://app.example.com"}
+	cors := CORSMiddleware(origins)(ok)
+	csrf := CSRFMiddleware(origins)(ok)
+
+	tests := []struct {
+		origin      string
+		allow       string
+		credentials bool
+		csrfStatus  int
+	}{
+		{origin: "https://evil.example.net", allow: "*", csrfStatus: http.StatusForbidden},
+		{origin: "https://app.example.com", allow: "https://app.example.com", credentials: true, csrfStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/jobs", nil)
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			cors.ServeHTTP(rec, req)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Access-Control-Allow-Origin = %q; want %q", got, tt.allow)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentials {
+				t.Errorf("credentials allowed = %v; want %v", got, tt.credentials)
+			}
+
+			req = httptest.NewRequest(http.MethodPost, "/api/jobs", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Sec-Fetch-Site", "cross-site")
+			rec = httptest.NewRecorder()
+			csrf.ServeHTTP(rec, req)
+			if rec.Code != tt.csrfStatus {
+				t.Errorf("cross-site POST = %d; want %d", rec.Code, tt.csrfStatus)
+			}
+		})
+	}
+}
+
+// BROWSER CLIENTS CAN SEND THE HEADERS THE API READS AND SEE WHEN THEY'RE BEING THROTTLED
+func TestCORSHeaders(t *testing.T) {
+	cors := CORSMiddleware([]string{"https://app.example.com"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/api/jobs", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	cors.ServeHTTP(rec, req)
+
+	allowed := strings.Split(rec.Header().Get("Access-Control-Allow-Headers"), ", ")
+	for _, header := range []string{"Idempotency-Key", "X-Crepes-User"} {
+		if !slices.Contains(allowed, header) {
+			t.Errorf("%s isn't allowed: %v", header, allowed)
+		}
+	}
+	exposed := strings.Split(rec.Header().Get("Access-Control-Expose-Headers"), ", ")
+	for _, header := range []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"} {
+		if !slices.Contains(exposed, header) {
+			t.Errorf("%s isn't exposed: %v", header, exposed)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SERVE THE APP UNDER A URL PREFIX (E.G. /crepes) BY STRIPPING IT BEFORE ROUTING
+func BasePath(prefix string) func(http.Handler) http.Handler {
+	prefix = "/" + strings.Trim(prefix, "/")
+	return func(next http.Handler) http.Handler {
+		if prefix == "/" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == prefix:
+				http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+			case strings.HasPrefix(r.URL.Path, prefix+"/"):
+				r2 := r.Clone(r.Context())
+				r2.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+				if r.URL.RawPath != "" {
+					r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+				}
+				next.ServeHTTP(w, r2)
+			default:
+				http.NotFound(w, r)
+			}
+		})
+	}
+}
+
+// APPLY X-Forwarded-* HEADERS, BUT ONLY FROM TRUSTED PROXY ADDRESSES (IPS OR CIDRS)
+func ProxyHeaders(trusted []string) func(http.Handler) http.Handler {
+	var networks []*net.IPNet
+	for _, entry := range trusted {
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	isTrusted := func(addr string) bool {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(networks) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isTrusted(r.RemoteAddr) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// CLIENT IS THE RIGHTMOST X-Forwarded-For HOP THAT ISN'T ONE OF OUR PROXIES
+			if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+				hops := strings.Split(forwarded, ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop := strings.TrimSpace(hops[i])
+					if net.ParseIP(hop) == nil {
+						break
+					}
+					r.RemoteAddr = net.JoinHostPort(hop, "0")
+					if !isTrusted(r.RemoteAddr) {
+						break
+					}
+				}
+			} else if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+				r.RemoteAddr = net.JoinHostPort(realIP, "0")
+			}
+
+			if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+				r.Host = strings.TrimSpace(strings.Split(host, ",")[0])
+			}
+			if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+				r.URL.Scheme = strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// FIXED-WINDOW COUNTER FOR ONE CLIENT + POLICY
+type rateWindow struct {
+	start  time.Time
+	length time.Duration // THE POLICY'S WINDOW; ONCE IT HAS PASSED THE COUNTER CAN GO
+	count  int
+}
+
+// ONE LIMIT APPLIED TO A REQUEST
+type ratePolicy struct {
+	name   string
+	limit  int
+	window time.Duration
+}
+
+// RATE LIMIT MIDDLEWARE: A DEFAULT PER-CLIENT LIMIT PLUS PER-ROUTE QUOTAS, REPORTED WITH
+// RateLimit-Limit / RateLimit-Remaining / RateLimit-Reset / RateLimit-Policy HEADERS.
+// WITH auth.enabled IT CHECKS THE SESSION TOKEN ITSELF SO A SIGNED-IN USER OR API KEY HAS ONE
+// BUCKET WHEREVER IT CONNECTS FROM (AND AuthMiddleware REUSES THE USER). IT RUNS BEFORE AUTH SO
+// LOGINS AND INVALID TOKENS COUNT TOO, AGAINST THEIR IP
+func RateLimitMiddleware(db *gorm.DB, cfg config.RateLimitConfig, authCfg config.AuthConfig) mux.MiddlewareFunc {
+	var (
+		mu        sync.Mutex
+		windows   = make(map[string]*rateWindow)
+		lastSweep = time.Now()
+	)
+
+	lifetime := authCfg.SessionLifetime()
+	defaultPolicy := ratePolicy{name: "default", limit: cfg.RequestsPerMinute, window: time.Minute}
+	if defaultPolicy.limit <= 0 {
+		defaultPolicy.limit = 300
+	}
+
+	routes := make([]ratePolicy, len(cfg.Routes))
+	for i, route := range cfg.Routes {
+		window, err := time.ParseDuration(route.Window)
+		if err != nil || window <= 0 {
+			window = time.Minute
+		}
+		routes[i] = ratePolicy{name: fmt.Sprintf("route%d", i), limit: route.Limit, window: window}
+	}
+
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "OPTIONS" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var user *models.User
+			if authCfg.Enabled {
+				if resolved, err := auth.ResolveSession(db, SessionToken(r), lifetime); err == nil {
+					user = resolved
+					r = r.WithContext(auth.WithUser(r.Context(), user))
+				}
+			}
+			client := rateLimitClient(r, user)
+
+			// DEFAULT LIMIT PLUS EVERY MATCHING ROUTE QUOTA
+			policies := []ratePolicy{defaultPolicy}
+			for i, route := range cfg.Routes {
+				if routes[i].limit > 0 && route.Matches(r.Method, routeTemplate(r), r.URL.Path) {
+					policies = append(policies, routes[i])
+				}
+			}
+
+			now := time.Now()
+			mu.Lock()
+			// DROP WINDOWS THAT HAVE RUN OUT NOW AND THEN SO IDLE CLIENTS DON'T ACCUMULATE
+			if now.Sub(lastSweep) > time.Minute {
+				for key, win := range windows {
+					if now.Sub(win.start) >= win.length {
+						delete(windows, key)
+					}
+				}
+				lastSweep = now
+			}
+
+			// THE MOST CONSTRAINED POLICY IS THE ONE REPORTED
+			var (
+				reported  ratePolicy
+				remaining = -1
+				reset     time.Duration
+				blocked   bool
+			)
+			wins := make([]*rateWindow, len(policies))
+			for i, policy := range policies {
+				key := client + "|" + policy.name
+				win, ok := windows[key]
+				if !ok || now.Sub(win.start) >= policy.window {
+					win = &rateWindow{start: now.Truncate(policy.window), length: policy.window}
+					windows[key] = win
+				}
+				wins[i] = win
+				left := policy.limit - win.count
+				if left <= 0 {
+					blocked = true
+				}
+				if remaining == -1 || left < remaining {
+					remaining = left
+					reported = policy
+					reset = win.start.Add(policy.window).Sub(now)
+				}
+			}
+			if !blocked {
+				for _, win := range wins {
+					win.count++
+				}
+				remaining--
+			}
+			mu.Unlock()
+
+			if remaining < 0 {
+				remaining = 0
+			}
+			resetSeconds := int(reset.Seconds() + 0.999)
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(reported.limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(resetSeconds))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", reported.limit, int(reported.window.Seconds())))
+
+			if blocked {
+				w.Header().Set("Retry-After", strconv.Itoa(resetSeconds))
+				utils.RespondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CLIENT IDENTITY FOR RATE LIMITING: THE USER A VALID TOKEN BELONGS TO, OTHERWISE THE IP (AFTER
+// ProxyHeaders HAS RESOLVED TRUSTED PROXIES)
+func rateLimitClient(r *http.Request, user *models.User) string {
+	if user != nil {
+		return "user:" + user.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ROUTE TEMPLATE OF THE MATCHED ROUTE (E.G. /api/jobs/{id}/start)
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/auth"
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "crepes.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.AuthSession{}, &models.Job{}, &models.Asset{},
+		&models.Folder{}, &models.Template{}, &models.Record{}, &models.RecordRejection{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// A USER AND A SESSION TOKEN FOR THEM
+func testUser(t *testing.T, db *gorm.DB, username, role string) (*models.User, string) {
+	t.Helper()
+	user, err := auth.CreateUser(db, username, "correct horse battery staple", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := auth.CreateSession(db, user, time.Hour, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+// A SIGNED-IN CLIENT HAS ONE BUCKET WHEREVER IT CONNECTS FROM; EVERYONE ELSE IS COUNTED BY IP
+func TestRateLimitKeysByUser(t *testing.T) {
+	db := openTestDB(t)
+	_, token := testUser(t, db, "alice", auth.RoleUser)
+	authCfg := config.AuthConfig{Enabled: true}
+	limit := RateLimitMiddleware(db, config.RateLimitConfig{Enabled: true, RequestsPerMinute: 2}, authCfg)
+	handler := limit(AuthMiddleware(db, authCfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	send := func(ip, bearer string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/jobs", nil)
+		req.RemoteAddr = ip + ":1234"
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	steps := []struct {
+		ip, token string
+		want      int
+	}{
+		{"10.0.0.1", token, http.StatusOK},
+		{"10.0.0.2", token, http.StatusOK},
+		{"10.0.0.3", token, http.StatusTooManyRequests},
+		// THE USER'S BUCKET ISN'T THE IP'S
+		{"10.0.0.3", "", http.StatusUnauthorized},
+		// A FRESH MADE-UP TOKEN EACH TIME STILL COUNTS AGAINST THE IP
+		{"10.0.0.3", "guess-1", http.StatusUnauthorized},
+		{"10.0.0.3", "guess-2", http.StatusTooManyRequests},
+	}
+	for i, step := range steps {
+		if got := send(step.ip, step.token); got != step.want {
+			t.Fatalf("request %d from %s = %d; want %d", i+1, step.ip, got, step.want)
+		}
+	}
+}
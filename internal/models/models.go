@@ -21,8 +21,190 @@ type Asset struct {
 	Size          int64     `json:"size"`
 	Date          time.Time `json:"date"`
 	Metadata      JSONMap   `json:"metadata" gorm:"type:text"`
+	Sources       JSONArray `json:"sources" gorm:"type:text"` // ALTERNATE URLS (QUALITIES/CDNS) FOR THE SAME CONTENT
 	CreatedAt     time.Time `json:"createdAt"`
 	UpdatedAt     time.Time `json:"updatedAt"`
+
+	// WHERE THE THUMBNAIL QUEUE IS WITH IT: pending, done OR failed (EMPTY = NONE WANTED)
+	ThumbnailStatus   string `json:"thumbnailStatus" gorm:"index"`
+	ThumbnailAttempts int    `json:"thumbnailAttempts"`
+	ThumbnailError    string `json:"thumbnailError,omitempty"` // WHY THE LAST ATTEMPT FAILED
+	ThumbnailVersion  int    `json:"thumbnailVersion"`         // BUMPED ON EVERY RENDER AND PART OF THE FILE NAME, SO CACHES SEE A NEW URL
+
+	// A BUCKET THE FILE AND THUMBNAIL BELONG IN (EMPTY = STORAGE ON DISK), AND WHETHER THEY'RE THERE YET:
+	// pending UNTIL THE THUMBNAIL IS SETTLED AND THE UPLOAD DONE, THEN done
+	Storage       string `json:"storage,omitempty" gorm:"index"`
+	StorageStatus string `json:"storageStatus,omitempty" gorm:"index"`
+}
+
+// WHETHER THE ASSET'S FILES ARE IN ITS BUCKET RATHER THAN UNDER storagePath
+func (a *Asset) IsRemote() bool {
+	return a.Storage != "" && a.StorageStatus == "done"
+}
+
+type AssetText struct { // TEXT EXTRACTED FROM A DOCUMENT ASSET FOR SEARCH, KEPT OFF THE ASSET ROW SO LISTS STAY SMALL
+	AssetID   string    `json:"assetId" gorm:"primaryKey"`
+	JobID     string    `json:"jobId" gorm:"index"`
+	Text      string    `json:"text" gorm:"type:text"`
+	Truncated bool      `json:"truncated"` // CUT OFF AT THE EXTRACTION LIMIT
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type Record struct { // STRUCTURED DATA EXTRACTED BY A JOB (TEXT, FIELDS, SCRIPT OUTPUT)
+	ID        string    `json:"id" gorm:"primaryKey"`
+	JobID     string    `json:"jobId" gorm:"uniqueIndex:idx_records_job_key"`
+	RunID     string    `json:"runId" gorm:"index"`  // LAST RUN THAT SAVED OR SAW IT
+	Source    string    `json:"source" gorm:"index"` // TASK TYPE THAT SAVED IT
+	URL       string    `json:"url"`                 // PAGE THE DATA CAME FROM, IF ANY
+	Data      JSONMap   `json:"data" gorm:"type:text"`
+	PII       JSONArray `json:"pii,omitempty" gorm:"type:text"`             // [{field, type, count}] FOUND BY THE JOB'S pii RULE
+	Key       string    `json:"key" gorm:"uniqueIndex:idx_records_job_key"` // JOB'S recordKey FIELDS, OR THE HASH WHEN IT HAS NONE
+	Hash      string    `json:"hash" gorm:"index"`
+	Changes   int       `json:"changes"` // TIMES THE DATA CHANGED SINCE FIRST SEEN
+	FirstSeen time.Time `json:"firstSeen" gorm:"index"`
+	LastSeen  time.Time `json:"lastSeen" gorm:"index"`
+	ChangedAt time.Time `json:"changedAt"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+type RecordChange struct { // ONE UPDATE TO A KEYED RECORD, FOR TRACKING VALUES (E.G. PRICES) OVER TIME
+	ID        string    `json:"id" gorm:"primaryKey"`
+	RecordID  string    `json:"recordId" gorm:"index"`
+	JobID     string    `json:"jobId" gorm:"index"`
+	RunID     string    `json:"runId"`
+	Fields    JSONArray `json:"fields" gorm:"type:text"` // [{field, from, to}]
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+type RecordAlert struct { // A JOB'S recordAlerts RULE FIRING ON A RECORD AFTER A RUN
+	ID        string    `json:"id" gorm:"primaryKey"`
+	JobID     string    `json:"jobId" gorm:"index"`
+	RunID     string    `json:"runId" gorm:"index"`
+	RecordID  string    `json:"recordId" gorm:"index"`
+	Rule      string    `json:"rule"` // RULE NAME, OR ITS FIELD WHEN UNNAMED
+	Field     string    `json:"field"`
+	From      *float64  `json:"from"` // NULL FOR NEW RECORDS
+	To        *float64  `json:"to"`
+	Reason    string    `json:"reason"` // below, above, changePercent OR changed
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+type RecordRejection struct { // RECORD THAT FAILED ITS JOB'S RECORD SCHEMA, KEPT FOR REVIEW
+	ID        string    `json:"id" gorm:"primaryKey"`
+	JobID     string    `json:"jobId" gorm:"index"`
+	RunID     string    `json:"runId" gorm:"index"`
+	Source    string    `json:"source"`
+	URL       string    `json:"url"`
+	Data      JSONMap   `json:"data" gorm:"type:text"`
+	Errors    JSONArray `json:"errors" gorm:"type:text"` // [{path, keyword, message}]
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+type PageSnapshot struct { // LATEST NORMALIZED CONTENT OF A PAGE A JOB WATCHES, WHAT ITS NEXT CHECK IS DIFFED AGAINST
+	ID        string    `json:"id" gorm:"primaryKey"`
+	JobID     string    `json:"jobId" gorm:"uniqueIndex:idx_snapshots_job_url"`
+	URL       string    `json:"url" gorm:"uniqueIndex:idx_snapshots_job_url"`
+	RunID     string    `json:"runId"`                           // LAST RUN THAT CHECKED IT
+	Hash      string    `json:"hash"`                            // OF THE TEXT AND SELECTORS
+	Text      string    `json:"text,omitempty" gorm:"type:text"` // VISIBLE TEXT, ONE LINE PER BLOCK
+	Selectors JSONMap   `json:"selectors" gorm:"type:text"`      // NAME -> TEXT OF EACH WATCHED SELECTOR
+	Changes   int       `json:"changes"`                         // TIMES IT CHANGED SINCE FIRST CHECKED
+	CheckedAt time.Time `json:"checkedAt"`
+	ChangedAt time.Time `json:"changedAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type PageChange struct { // WHAT DIFFERED BETWEEN TWO CHECKS OF A WATCHED PAGE
+	ID        string    `json:"id" gorm:"primaryKey"`
+	JobID     string    `json:"jobId" gorm:"index"`
+	RunID     string    `json:"runId" gorm:"index"`
+	URL       string    `json:"url" gorm:"index"`
+	Added     JSONArray `json:"added" gorm:"type:text"`     // LINES THAT APPEARED
+	Removed   JSONArray `json:"removed" gorm:"type:text"`   // LINES THAT WENT AWAY
+	Changed   JSONArray `json:"changed" gorm:"type:text"`   // [{from, to}] LINES REPLACED IN PLACE
+	Selectors JSONArray `json:"selectors" gorm:"type:text"` // [{name, from, to}] WATCHED SELECTORS WHOSE TEXT CHANGED
+	Truncated bool      `json:"truncated"`                  // MORE LINES CHANGED THAN WERE KEPT
+	FromHash  string    `json:"fromHash"`
+	ToHash    string    `json:"toHash"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+type ItemResult struct { // OUTCOME OF ONE ITEM OF A WORKER-PER-ITEM OR FOR-EACH STAGE
+	ID              string          `json:"id" gorm:"primaryKey"`
+	JobID           string          `json:"jobId" gorm:"index"`
+	RunID           string          `json:"runId" gorm:"index"`
+	Stage           string          `json:"stage" gorm:"index"`
+	ItemIndex       int             `json:"itemIndex"`
+	Item            json.RawMessage `json:"item" gorm:"type:text"`
+	Status          string          `json:"status" gorm:"index"` // succeeded, failed
+	TaskID          string          `json:"taskId"`              // TASK THAT FAILED
+	Error           string          `json:"error" gorm:"type:text"`
+	DurationMs      int64           `json:"durationMs"`
+	Output          json.RawMessage `json:"output" gorm:"type:text"` // THE TASK'S RESULT, OR EACH TASK'S BY ID FOR FOR-EACH
+	OutputTruncated bool            `json:"outputTruncated"`         // OUTPUT WAS TOO LARGE TO KEEP
+	CreatedAt       time.Time       `json:"createdAt" gorm:"index"`
+}
+
+type RunEvent struct { // ONE SPAN OF A RUN'S TIMELINE: THE RUN ITSELF, A STAGE, A TASK, A RETRY OR A DOWNLOAD
+	ID         string    `json:"id" gorm:"primaryKey"`
+	JobID      string    `json:"jobId" gorm:"index"`
+	RunID      string    `json:"runId" gorm:"index"`
+	Kind       string    `json:"kind" gorm:"index"` // run, stage, task, retry, download
+	Name       string    `json:"name"`
+	Stage      string    `json:"stage,omitempty"`
+	TaskID     string    `json:"taskId,omitempty"`
+	Lane       string    `json:"lane"`              // ROW IT'S DRAWN ON: THE STAGE, ONE OF ITS WORKERS, OR A DOWNLOAD HOST
+	Attempt    int       `json:"attempt,omitempty"` // RETRY NUMBER (0 FOR THE FIRST TRY)
+	Status     string    `json:"status"`            // succeeded, failed, skipped, cancelled
+	Error      string    `json:"error,omitempty" gorm:"type:text"`
+	StartedAt  time.Time `json:"startedAt"`
+	EndedAt    time.Time `json:"endedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Detail     JSONMap   `json:"detail,omitempty" gorm:"type:text"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"index"`
+}
+
+type JobState struct { // VALUE A JOB KEEPS BETWEEN RUNS (LAST SEEN ID, PAGE NUMBER, CURSOR TOKEN)
+	JobID     string          `json:"jobId" gorm:"primaryKey"`
+	Key       string          `json:"key" gorm:"primaryKey"`
+	Value     json.RawMessage `json:"value" gorm:"type:text"`
+	RunID     string          `json:"runId"` // LAST RUN THAT WROTE IT, EMPTY WHEN SET THROUGH THE API
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+type Secret struct { // NAMED VALUE IN THE VAULT, SEALED WITH THE CONFIGURED encryptionSecret
+	Name        string    `json:"name" gorm:"primaryKey"`
+	Value       string    `json:"-"` // NEVER RETURNED BY THE API
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type Session struct { // COOKIES AND LOCAL STORAGE A JOB'S RUNS CARRY OVER (persistSession RULE)
+	JobID     string    `json:"jobId" gorm:"primaryKey"`
+	State     string    `json:"-" gorm:"type:text"` // PLAYWRIGHT STORAGE STATE JSON, SEALED WHEN AN encryptionSecret IS SET
+	Sealed    bool      `json:"sealed"`
+	Cookies   int       `json:"cookies"` // COUNTS AS OF THE LAST SAVE
+	Origins   int       `json:"origins"`
+	RunID     string    `json:"runId"` // RUN THAT LAST SAVED IT
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type PartialDownload struct { // AN UNFINISHED DOWNLOAD'S PART FILE, KEPT SO A LATER ATTEMPT OR RUN CAN RESUME IT
+	ID           string          `json:"id" gorm:"primaryKey"` // DERIVED FROM THE JOB AND URL
+	JobID        string          `json:"jobId" gorm:"index"`
+	URL          string          `json:"url"`
+	PartPath     string          `json:"partPath"`
+	ETag         string          `json:"etag"`         // VALIDATORS THE RANGE REQUESTS SEND AS If-Range
+	LastModified string          `json:"lastModified"` // (USED WHEN THERE'S NO ETag)
+	ContentType  string          `json:"contentType"`
+	Total        int64           `json:"total"`                   // -1 WHEN THE SERVER SENT NO LENGTH
+	Written      int64           `json:"written"`                 // BYTES IN THE PART FILE AS OF THE LAST SAVE
+	Chunks       json.RawMessage `json:"chunks" gorm:"type:text"` // BYTE RANGES OF A CHUNKED DOWNLOAD AND HOW FAR EACH GOT
+	CreatedAt    time.Time       `json:"createdAt"`
+	UpdatedAt    time.Time       `json:"updatedAt" gorm:"index"`
 }
 
 type Setting struct {
@@ -32,6 +214,98 @@ type Setting struct {
 	UpdatedAt time.Time
 }
 
+type IdempotencyRecord struct { // STORED RESPONSE FOR A CLIENT-SUPPLIED Idempotency-Key
+	Key         string    `json:"key" gorm:"primaryKey"` // SCOPE (METHOD + PATH) AND CLIENT KEY
+	RequestHash string    `json:"requestHash"`
+	StatusCode  int       `json:"statusCode"` // 0 WHILE THE ORIGINAL REQUEST IS IN FLIGHT
+	ContentType string    `json:"contentType"`
+	Body        string    `json:"body" gorm:"type:text"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"index"`
+}
+
+type Folder struct { // HIERARCHICAL GROUPING OF JOBS
+	ID        string    `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" validate:"required,max=100"`
+	ParentID  string    `json:"parentId" gorm:"index"`              // EMPTY FOR TOP-LEVEL FOLDERS
+	Schedule  string    `json:"schedule" validate:"omitempty,cron"` // RUNS EVERY JOB IN THE FOLDER AND ITS SUBFOLDERS
+	Settings  JSONMap   `json:"settings" gorm:"type:text"`          // SETTINGS OVERRIDDEN FOR EVERY JOB BENEATH IT (SEE scraper.SettingsOverride)
+	OwnerID   string    `json:"ownerId" gorm:"index"`               // USER WHO MADE IT; EMPTY (FOLDERS FROM BEFORE ACCOUNTS) IS ADMIN-ONLY
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type Template struct { // REUSABLE JOB BLUEPRINT; {{name}} PLACEHOLDERS ARE FILLED IN WHEN A JOB IS MADE FROM IT
+	ID          string    `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" validate:"required,max=200"`
+	Description string    `json:"description" validate:"max=2000"`
+	Variables   JSONArray `json:"variables" gorm:"type:text"` // [{name, description, default, required}]
+	Job         JSONMap   `json:"job" gorm:"type:text"`       // JOB FIELDS (baseUrl, pipeline, rules, ...) WITH PLACEHOLDERS
+	Tags        JSONArray `json:"tags" gorm:"type:text"`
+	OwnerID     string    `json:"ownerId" gorm:"index"` // USER WHO MADE IT; EMPTY (TEMPLATES FROM BEFORE ACCOUNTS) IS ADMIN-ONLY
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type User struct { // API ACCOUNT (auth.enabled); ADMINS SEE EVERYTHING, USERS THEIR OWN JOBS AND WHAT THEY PRODUCE
+	ID           string     `json:"id" gorm:"primaryKey"`
+	Username     string     `json:"username" gorm:"uniqueIndex" validate:"required,max=100"`
+	PasswordHash string     `json:"-"` // BCRYPT
+	Role         string     `json:"role" validate:"omitempty,oneof=admin user"`
+	Disabled     bool       `json:"disabled"`
+	LastLoginAt  *time.Time `json:"lastLoginAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+type AuthSession struct { // ONE LOGIN; ONLY THE CLIENT HOLDS THE TOKEN ITSELF
+	ID         string    `json:"-" gorm:"primaryKey"` // SHA-256 OF THE TOKEN
+	UserID     string    `json:"userId" gorm:"index"`
+	ExpiresAt  time.Time `json:"expiresAt" gorm:"index"` // PUSHED BACK AS THE SESSION IS USED
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type SavedFilter struct { // NAMED JOB SEARCH SAVED BY A USER
+	ID        string    `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"userId" gorm:"index"`
+	Name      string    `json:"name" validate:"required,max=100"`
+	Query     JSONMap   `json:"query" gorm:"type:text"` // q, status, tag, domain, sort
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ERROR LOG STATUSES
+const (
+	ErrorStatusOpen         = "open"
+	ErrorStatusAcknowledged = "acknowledged"
+	ErrorStatusResolved     = "resolved"
+)
+
+type ErrorLog struct { // AN ERROR RAISED WHILE RUNNING A JOB
+	ID             string     `json:"id" gorm:"primaryKey"`
+	JobID          string     `json:"jobId" gorm:"index"`
+	Stage          string     `json:"stage" gorm:"index"`
+	TaskID         string     `json:"taskId"`
+	TaskName       string     `json:"taskName"`
+	TaskType       string     `json:"taskType"`
+	Message        string     `json:"message" gorm:"type:text"`
+	StatusCode     int        `json:"statusCode" gorm:"index"`  // HTTP STATUS MENTIONED IN THE ERROR, 0 IF NONE
+	Fingerprint    string     `json:"fingerprint" gorm:"index"` // SAME FOR ERRORS THAT DIFFER ONLY IN URLS, NUMBERS, IDS
+	URL            string     `json:"url"`                      // PAGE THE TASK WAS ON, IF KNOWN
+	Screenshot     string     `json:"screenshot"`               // SCREENSHOT PATH RELATIVE TO STORAGE
+	Snapshot       string     `json:"snapshot"`                 // DOM SNAPSHOT PATH RELATIVE TO STORAGE
+	HTMLSnippet    string     `json:"htmlSnippet" gorm:"type:text"`
+	HAR            string     `json:"har"`                                // HAR PATH RELATIVE TO STORAGE (WRITTEN WHEN THE PAGE CLOSES)
+	TaskConfig     JSONMap    `json:"taskConfig" gorm:"type:text"`        // THE TASK'S CONFIG AS DEFINED IN THE PIPELINE
+	Inputs         JSONMap    `json:"inputs" gorm:"type:text"`            // CONFIG MERGED WITH RESOLVED INPUTS, AS EXECUTED
+	Status         string     `json:"status" gorm:"index;default:'open'"` // open, acknowledged, resolved
+	CreatedAt      time.Time  `json:"createdAt" gorm:"index"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt"`
+	ResolvedAt     *time.Time `json:"resolvedAt"`
+}
+
 type Selector struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
@@ -60,24 +334,24 @@ type Stage struct {
 	Description string            `json:"description"`
 	Condition   Condition         `json:"condition"`
 	Parallelism ParallelismConfig `json:"parallelism"`
-	Tasks       []Task            `json:"tasks"`
+	Tasks       []Task            `json:"tasks" validate:"dive"`
 	Config      map[string]any    `json:"config"`
 }
 
 type Condition struct { // CONDITION DEFINES WHEN A STAGE OR TASK SHOULD EXECUTE
-	Type   string         `json:"type"` // always, never, javascript, comparison
+	Type   string         `json:"type" validate:"omitempty,oneof=always never javascript comparison"` // always, never, javascript, comparison
 	Config map[string]any `json:"config"`
 }
 
 type ParallelismConfig struct { // PARALLELISM CONFIG DEFINES HOW TASKS ARE EXECUTED
-	Mode       string `json:"mode"` // sequential, parallel, worker-per-item
-	MaxWorkers int    `json:"maxWorkers"`
+	Mode       string `json:"mode" validate:"omitempty,oneof=sequential parallel worker-per-item for-each"` // sequential, parallel, worker-per-item, for-each
+	MaxWorkers int    `json:"maxWorkers" validate:"gte=0"`
 }
 
 type Task struct { // TASK DEFINES A SINGLE OPERATION IN THE PIPELINE
 	ID          string         `json:"id"`
 	Name        string         `json:"name"`
-	Type        string         `json:"type"` // Type of task (navigate, click, extract, etc.)
+	Type        string         `json:"type" validate:"required"` // Type of task (navigate, click, extract, etc.)
 	Description string         `json:"description"`
 	Config      map[string]any `json:"config"`
 	InputRefs   []string       `json:"inputRefs"` // References to outputs from other tasks
@@ -86,25 +360,27 @@ type Task struct { // TASK DEFINES A SINGLE OPERATION IN THE PIPELINE
 }
 
 type RetryConfig struct { // RETRY CONFIG DEFINES HOW TASK RETRIES ARE HANDLED
-	MaxRetries  int     `json:"maxRetries"`
-	DelayMS     int     `json:"delayMS"`
-	BackoffRate float64 `json:"backoffRate"`
+	MaxRetries  int     `json:"maxRetries" validate:"gte=0"`
+	DelayMS     int     `json:"delayMS" validate:"gte=0"`
+	BackoffRate float64 `json:"backoffRate" validate:"gte=0"`
 }
 
 type Job struct { // UPDATE JOB MODEL TO INCLUDE PIPELINE FIELD
 	ID          string    `json:"id" gorm:"primaryKey"`
-	Name        string    `json:"name"`
-	BaseURL     string    `json:"baseUrl"`
+	Name        string    `json:"name" validate:"required,max=200"`
+	BaseURL     string    `json:"baseUrl" validate:"omitempty,http_url"`
 	Description string    `json:"description"`
 	Status      string    `json:"status" gorm:"default:'idle'"`
 	LastRun     time.Time `json:"lastRun"`
 	NextRun     time.Time `json:"nextRun"`
-	Schedule    string    `json:"schedule"`
+	Schedule    string    `json:"schedule" validate:"omitempty,cron"`
 	Selectors   JSONArray `json:"selectors" gorm:"type:text"`
 	Filters     JSONArray `json:"filters" gorm:"type:text"`
 	Rules       JSONMap   `json:"rules" gorm:"type:text"`
 	Processing  JSONMap   `json:"processing" gorm:"type:text"`
 	Tags        JSONArray `json:"tags" gorm:"type:text"`
+	FolderID    string    `json:"folderId" gorm:"index"`
+	OwnerID     string    `json:"ownerId" gorm:"index"`      // USER WHO MADE IT; EMPTY (JOBS FROM BEFORE ACCOUNTS) IS ADMIN-ONLY
 	Pipeline    string    `json:"pipeline" gorm:"type:text"` // JSON STRING CONTAINING PIPELINE STAGES
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
@@ -221,6 +497,14 @@ type JSONArray []any
 
 // SCAN FROM DB VALUE
 func (j *JSONArray) Scan(value any) error {
+	// NULL COLUMNS (E.G. ROWS CREATED BEFORE THE COLUMN EXISTED)
+	if value == nil {
+		*j = make(JSONArray, 0)
+		return nil
+	}
+	if str, ok := value.(string); ok {
+		value = []byte(str)
+	}
 	bytes, ok := value.([]byte)
 	if !ok {
 		return errors.New("failed to unmarshal JSONArray value")
@@ -245,6 +529,14 @@ type JSONMap map[string]any
 
 // SCAN FROM DB VALUE
 func (j *JSONMap) Scan(value any) error {
+	// NULL COLUMNS (E.G. ROWS CREATED BEFORE THE COLUMN EXISTED)
+	if value == nil {
+		*j = make(JSONMap)
+		return nil
+	}
+	if str, ok := value.(string); ok {
+		value = []byte(str)
+	}
 	bytes, ok := value.([]byte)
 	if !ok {
 		return errors.New("failed to unmarshal JSONMap value")
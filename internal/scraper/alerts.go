@@ -0,0 +1,248 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// JOB RULE LISTING THRESHOLD ALERTS ON RECORD FIELDS, CHECKED AFTER EACH RUN
+const recordAlertsRule = "recordAlerts"
+
+// ALERTS INCLUDED IN ONE RUN'S NOTIFICATION (ALL ARE STORED)
+const maxNotifiedAlerts = 20
+
+// ONE ALERT, E.G. {"field": "price", "below": 100} OR {"field": "price", "changePercent": 10, "direction": "down"}.
+// ANY CONDITION THAT HOLDS FIRES IT; WITH NO CONDITIONS, ANY CHANGE TO THE FIELD DOES
+type RecordAlertRule struct {
+	Name          string   `json:"name"`
+	Field         string   `json:"field"`
+	Below         *float64 `json:"below"`         // VALUE DROPS BELOW (OR A NEW RECORD STARTS BELOW)
+	Above         *float64 `json:"above"`         // VALUE RISES ABOVE (OR A NEW RECORD STARTS ABOVE)
+	ChangePercent float64  `json:"changePercent"` // VALUE MOVES BY AT LEAST THIS MANY PERCENT
+	Direction     string   `json:"direction"`     // FOR changePercent: up, down OR any (DEFAULT)
+}
+
+// PARSE A recordAlerts RULE (NIL WHEN UNSET)
+func ParseRecordAlerts(raw any) ([]RecordAlertRule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var rules []RecordAlertRule
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("RECORD ALERTS MUST BE A LIST OF ALERT OBJECTS: %v", err)
+	}
+	for i, rule := range rules {
+		if rule.Field == "" {
+			return nil, fmt.Errorf("ALERT %d HAS NO field", i)
+		}
+		if rule.ChangePercent < 0 {
+			return nil, fmt.Errorf("ALERT %d: changePercent MUST BE POSITIVE", i)
+		}
+		switch rule.Direction {
+		case "", "any", "up", "down":
+		default:
+			return nil, fmt.Errorf("ALERT %d: direction MUST BE up, down OR any", i)
+		}
+	}
+	return rules, nil
+}
+
+// NAME AN ALERT IS STORED AND REPORTED UNDER
+func (r RecordAlertRule) label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Field
+}
+
+// CHECK A VALUE CHANGE AGAINST THE RULE; from IS NIL FOR NEW RECORDS. RETURNS THE REASON IT FIRED
+func (r RecordAlertRule) check(from, to *float64) (string, bool) {
+	if to == nil {
+		return "", false
+	}
+	if r.Below != nil && *to < *r.Below && (from == nil || *from >= *r.Below) {
+		return "below", true
+	}
+	if r.Above != nil && *to > *r.Above && (from == nil || *from <= *r.Above) {
+		return "above", true
+	}
+	if from == nil {
+		return "", false
+	}
+	if r.ChangePercent > 0 && *from != 0 {
+		percent := (*to - *from) / math.Abs(*from) * 100
+		moved := math.Abs(percent) >= r.ChangePercent
+		if moved && (r.Direction == "up" && percent < 0 || r.Direction == "down" && percent > 0) {
+			moved = false
+		}
+		if moved {
+			return "changePercent", true
+		}
+	}
+	if r.Below == nil && r.Above == nil && r.ChangePercent == 0 && *from != *to {
+		return "changed", true
+	}
+	return "", false
+}
+
+// NUMERIC VALUE OF A FIELD: A NUMBER, A price TRANSFORM'S {amount} OR A NUMERIC STRING
+func alertNumber(value any) *float64 {
+	switch v := value.(type) {
+	case float64:
+		return &v
+	case map[string]any:
+		return alertNumber(v["amount"])
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return &f
+		}
+	}
+	return nil
+}
+
+// EVALUATE THE JOB'S ALERTS AGAINST RECORDS THE FINISHED RUN ADDED OR CHANGED, STORE WHAT
+// FIRED AND SEND ONE NOTIFICATION FOR THE RUN
+func (e *Engine) evaluateRecordAlerts(jobID string) {
+	raw, _ := e.jobRule(jobID, recordAlertsRule)
+	rules, err := ParseRecordAlerts(raw)
+	if err != nil || len(rules) == 0 {
+		return
+	}
+	e.mu.Lock()
+	runID := e.jobProgress[jobID].RunID
+	started := e.jobStartTimes[jobID]
+	e.mu.Unlock()
+	if runID == "" {
+		return
+	}
+
+	var alerts []models.RecordAlert
+	fire := func(rule RecordAlertRule, record models.Record, from, to *float64, reason string) {
+		alert := models.RecordAlert{
+			ID:        generateID("alert"),
+			JobID:     jobID,
+			RunID:     runID,
+			RecordID:  record.ID,
+			Rule:      rule.label(),
+			Field:     rule.Field,
+			From:      from,
+			To:        to,
+			Reason:    reason,
+			CreatedAt: time.Now(),
+		}
+		alert.Message = alertMessage(rule, alert, record)
+		alerts = append(alerts, alert)
+	}
+
+	// NEW RECORDS CAN ONLY CROSS A THRESHOLD
+	var seen []models.Record
+	e.db.Where("job_id = ? AND run_id = ?", jobID, runID).Find(&seen)
+	for _, record := range seen {
+		if record.FirstSeen.Before(started) {
+			continue
+		}
+		for _, rule := range rules {
+			if reason, ok := rule.check(nil, alertNumber(record.Data[rule.Field])); ok {
+				fire(rule, record, nil, alertNumber(record.Data[rule.Field]), reason)
+			}
+		}
+	}
+
+	// CHANGES MADE BY THIS RUN
+	var changes []models.RecordChange
+	e.db.Where("job_id = ? AND run_id = ?", jobID, runID).Find(&changes)
+	for _, change := range changes {
+		var record models.Record
+		if e.db.First(&record, "id = ?", change.RecordID).Error != nil {
+			continue
+		}
+		for _, entry := range change.Fields {
+			fields, ok := entry.(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, rule := range rules {
+				if fields["field"] != rule.Field {
+					continue
+				}
+				from, to := alertNumber(fields["from"]), alertNumber(fields["to"])
+				if reason, ok := rule.check(from, to); ok {
+					fire(rule, record, from, to, reason)
+				}
+			}
+		}
+	}
+
+	if len(alerts) == 0 {
+		return
+	}
+	if err := e.db.Create(&alerts).Error; err != nil {
+		log.Printf("FAILED TO SAVE RECORD ALERTS FOR JOB %s: %v", jobID, err)
+	}
+	log.Printf("JOB %s RUN %s FIRED %d RECORD ALERTS", jobID, runID, len(alerts))
+
+	lines := make([]string, 0, maxNotifiedAlerts+1)
+	for i, alert := range alerts {
+		if i == maxNotifiedAlerts {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(alerts)-maxNotifiedAlerts))
+			break
+		}
+		lines = append(lines, alert.Message)
+	}
+	var job models.Job
+	e.db.Select("id", "name").First(&job, "id = ?", jobID)
+	e.notifier.Notify(Notification{
+		Type:    "record.alert",
+		JobID:   jobID,
+		Title:   fmt.Sprintf("%d record alerts from %s", len(alerts), jobDisplayName(job)),
+		Message: strings.Join(lines, "\n"),
+		Data:    alerts[:min(len(alerts), maxNotifiedAlerts)],
+	})
+}
+
+// ONE-LINE DESCRIPTION OF AN ALERT, E.G. "price dropped below 100: 120 -> 95 (https://...)"
+func alertMessage(rule RecordAlertRule, alert models.RecordAlert, record models.Record) string {
+	format := func(f *float64) string {
+		if f == nil {
+			return "new"
+		}
+		return strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+	what := rule.label() + " changed"
+	switch alert.Reason {
+	case "below":
+		what = rule.label() + " dropped below " + format(rule.Below)
+	case "above":
+		what = rule.label() + " rose above " + format(rule.Above)
+	case "changePercent":
+		what = fmt.Sprintf("%s changed %+.1f%%", rule.label(), (*alert.To-*alert.From)/math.Abs(*alert.From)*100)
+	}
+	// KEYED RECORDS ARE NAMED BY THEIR KEY, OTHERS BY THE PAGE THEY CAME FROM
+	subject := record.Key
+	if record.Key == record.Hash && record.URL != "" {
+		subject = record.URL
+	}
+	return fmt.Sprintf("%s: %s -> %s (%s)", what, format(alert.From), format(alert.To), subject)
+}
+
+// JOB NAME FOR MESSAGES, FALLING BACK TO ITS ID
+func jobDisplayName(job models.Job) string {
+	if job.Name != "" {
+		return job.Name
+	}
+	return job.ID
+}
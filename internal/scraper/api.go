@@ -0,0 +1,308 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// -- API REQUESTS --
+
+const (
+	apiDefaultTimeout = 30 * time.Second
+	apiMaxBody        = 10 << 20 // RESPONSES ARE HELD IN MEMORY AS TASK OUTPUT (maxBytes CHANGES IT)
+)
+
+// HTTP REQUEST TASK: CALL AN API DIRECTLY, WITHOUT A BROWSER
+type HTTPRequestTask struct{}
+
+func (t *HTTPRequestTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"url":          "string",   // REQUIRED
+		"method":       "string?",  // OPTIONAL (defaults to GET)
+		"headers":      "object?",  // OPTIONAL
+		"query":        "object?",  // OPTIONAL (ADDED TO THE URL'S QUERY STRING)
+		"body":         "any?",     // OPTIONAL (OBJECTS AND ARRAYS ARE SENT AS JSON)
+		"form":         "object?",  // OPTIONAL (SENT URL-ENCODED, INSTEAD OF body)
+		"auth":         "string?",  // OPTIONAL (NAME OF AN AUTH PROFILE IN THE JOB'S auth RULE)
+		"responseType": "string?",  // OPTIONAL (auto, json, text; defaults to auto)
+		"allowErrors":  "boolean?", // OPTIONAL (RETURN NON-2XX RESPONSES INSTEAD OF FAILING)
+		"timeout":      "number?",  // OPTIONAL (MS)
+		"fingerprint":  "string?",  // OPTIONAL (TLS FINGERPRINT TO IMPERSONATE)
+		"httpVersion":  "string?",  // OPTIONAL (auto, 1.1, 2, 3)
+		"maxBytes":     "number?",  // OPTIONAL (LARGEST BODY READ, DEFAULTS TO 10MB)
+		"truncate":     "boolean?", // OPTIONAL (KEEP THE START OF A LARGER BODY INSTEAD OF FAILING)
+	}
+}
+
+func (t *HTTPRequestTask) GetOutputSchema() string {
+	return "object" // RETURNS {status, url, headers, body, truncated}
+}
+
+func (t *HTTPRequestTask) ValidateConfig(config map[string]any) error {
+	if _, ok := config["url"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	if responseType, ok := config["responseType"].(string); ok {
+		switch responseType {
+		case "", "auto", "json", "text":
+		default:
+			return fmt.Errorf("%w: responseType MUST BE auto, json OR text", ErrInvalidInput)
+		}
+	}
+	return validateClientOptions(config)
+}
+
+func (t *HTTPRequestTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	rawURL, _ := config["url"].(string)
+	method, _ := config["method"].(string)
+	if method == "" {
+		method = "GET"
+	}
+	method = strings.ToUpper(method)
+
+	// QUERY PARAMETERS
+	if query, ok := config["query"].(map[string]any); ok && len(query) > 0 {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return TaskData{}, fmt.Errorf("%w: INVALID URL: %v", ErrInvalidInput, err)
+		}
+		values := u.Query()
+		for k, v := range query {
+			values.Set(k, fmt.Sprint(v))
+		}
+		u.RawQuery = values.Encode()
+		rawURL = u.String()
+	}
+
+	// BODY
+	var body []byte
+	var contentType string
+	if form, ok := config["form"].(map[string]any); ok {
+		values := url.Values{}
+		for k, v := range form {
+			values.Set(k, fmt.Sprint(v))
+		}
+		body, contentType = []byte(values.Encode()), "application/x-www-form-urlencoded"
+	} else if raw, ok := config["body"]; ok && raw != nil {
+		switch v := raw.(type) {
+		case string:
+			body, contentType = []byte(v), "text/plain; charset=utf-8"
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return TaskData{}, fmt.Errorf("%w: BODY CAN'T BE ENCODED: %v", ErrInvalidInput, err)
+			}
+			body, contentType = encoded, "application/json"
+		}
+	}
+
+	ctx.Logger.Printf("API REQUEST: %s %s", method, rawURL)
+	resp, err := apiRequest(ctx, config, method, rawURL, body, contentType)
+	if err != nil {
+		return TaskData{}, err
+	}
+	allowErrors := boolConfig(config, "allowErrors", false)
+	if (resp.Status < 200 || resp.Status >= 300) && !allowErrors {
+		return TaskData{}, fmt.Errorf("API REQUEST FAILED: STATUS %d: %s", resp.Status, truncate(string(resp.Body), 200))
+	}
+
+	responseType, _ := config["responseType"].(string)
+	isJSON := responseType == "json" || (responseType != "text" && strings.Contains(resp.Header.Get("Content-Type"), "json"))
+	var value any = string(resp.Body) // A CUT JSON BODY IS RETURNED AS TEXT
+	if isJSON && len(resp.Body) > 0 && !resp.Truncated {
+		if err := json.Unmarshal(resp.Body, &value); err != nil {
+			return TaskData{}, fmt.Errorf("INVALID JSON RESPONSE: %v", err)
+		}
+	}
+
+	headers := make(map[string]any, len(resp.Header))
+	for k := range resp.Header {
+		headers[k] = resp.Header.Get(k)
+	}
+	return TaskData{
+		Type: "object",
+		Value: map[string]any{
+			"status":    resp.Status,
+			"url":       resp.URL,
+			"headers":   headers,
+			"body":      value,
+			"truncated": resp.Truncated,
+		},
+	}, nil
+}
+
+// GRAPHQL QUERY TASK: POST A QUERY AND RETURN ITS data
+type GraphQLQueryTask struct{}
+
+func (t *GraphQLQueryTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"url":           "string",   // REQUIRED (GRAPHQL ENDPOINT)
+		"query":         "string",   // REQUIRED
+		"variables":     "object?",  // OPTIONAL
+		"operationName": "string?",  // OPTIONAL
+		"headers":       "object?",  // OPTIONAL
+		"auth":          "string?",  // OPTIONAL (NAME OF AN AUTH PROFILE IN THE JOB'S auth RULE)
+		"allowErrors":   "boolean?", // OPTIONAL (RETURN PARTIAL data WHEN THE RESPONSE HAS errors)
+		"timeout":       "number?",  // OPTIONAL (MS)
+		"fingerprint":   "string?",  // OPTIONAL
+		"httpVersion":   "string?",  // OPTIONAL
+	}
+}
+
+func (t *GraphQLQueryTask) GetOutputSchema() string {
+	return "object" // RETURNS THE RESPONSE'S data
+}
+
+func (t *GraphQLQueryTask) ValidateConfig(config map[string]any) error {
+	if _, ok := config["url"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	if query, _ := config["query"].(string); strings.TrimSpace(query) == "" {
+		return ErrMissingRequiredInput
+	}
+	return validateClientOptions(config)
+}
+
+func (t *GraphQLQueryTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	endpoint, _ := config["url"].(string)
+	payload := map[string]any{"query": config["query"]}
+	if variables, ok := config["variables"].(map[string]any); ok {
+		payload["variables"] = variables
+	}
+	if operation, ok := config["operationName"].(string); ok && operation != "" {
+		payload["operationName"] = operation
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return TaskData{}, fmt.Errorf("%w: QUERY CAN'T BE ENCODED: %v", ErrInvalidInput, err)
+	}
+
+	ctx.Logger.Printf("GRAPHQL QUERY: %s", endpoint)
+	resp, err := apiRequest(ctx, config, "POST", endpoint, body, "application/json")
+	if err != nil {
+		return TaskData{}, err
+	}
+
+	var result struct {
+		Data   any `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		if resp.Status < 200 || resp.Status >= 300 {
+			return TaskData{}, fmt.Errorf("GRAPHQL REQUEST FAILED: STATUS %d", resp.Status)
+		}
+		return TaskData{}, fmt.Errorf("INVALID GRAPHQL RESPONSE: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		if !boolConfig(config, "allowErrors", false) || result.Data == nil {
+			return TaskData{}, fmt.Errorf("GRAPHQL ERROR: %s", result.Errors[0].Message)
+		}
+		ctx.Logger.Printf("GRAPHQL RETURNED %d ERRORS WITH PARTIAL DATA, FIRST: %s", len(result.Errors), result.Errors[0].Message)
+	}
+	if resp.Status < 200 || resp.Status >= 300 {
+		return TaskData{}, fmt.Errorf("GRAPHQL REQUEST FAILED: STATUS %d", resp.Status)
+	}
+	return TaskData{Type: "object", Value: result.Data}, nil
+}
+
+// RESPONSE OF AN API REQUEST, READ INTO MEMORY
+type apiResponse struct {
+	Status    int
+	URL       string
+	Header    http.Header
+	Body      []byte
+	Truncated bool // CUT AT maxBytes (ONLY WITH truncate)
+}
+
+// SEND AN API REQUEST WITH THE TASK'S HEADERS, AUTH PROFILE, TIMEOUT AND CLIENT OPTIONS
+func apiRequest(ctx *TaskContext, config map[string]any, method, rawURL string, body []byte, contentType string) (*apiResponse, error) {
+	timeout := apiDefaultTimeout
+	if ms := numberConfig(config, "timeout", 0); ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	reqCtx, cancel := context.WithTimeout(ctx.Context, timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, method, rawURL, reader)
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO CREATE REQUEST: %v", err)
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	req.Header.Set("Accept", "application/json, */*;q=0.8")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	ctx.Engine.setJobHeaders(ctx.JobID, req.Header)
+	if headers, ok := config["headers"].(map[string]any); ok {
+		for key, value := range headers {
+			if strValue, ok := value.(string); ok {
+				req.Header.Set(key, strValue)
+			}
+		}
+	}
+
+	fingerprint := ctx.Engine.cfg.TLSFingerprint
+	if f, ok := config["fingerprint"].(string); ok && f != "" {
+		fingerprint = f
+	}
+	httpVersion := ctx.Engine.cfg.HTTPVersion
+	if v, ok := config["httpVersion"].(string); ok && v != "" {
+		httpVersion = v
+	}
+	client := NewHTTPClient(HTTPClientOptions{Fingerprint: fingerprint, HTTPVersion: httpVersion, TLS: ctx.Engine.tlsPolicy(ctx.JobID), Guard: ctx.Engine.guard, DNS: ctx.Engine.dnsPolicy(ctx.JobID), Egress: ctx.Engine.egressPolicy(ctx.JobID)})
+
+	authName, _ := config["auth"].(string)
+	resp, err := ctx.Engine.doWithAuth(ctx, client, authName, req)
+	if err != nil {
+		return nil, fmt.Errorf("REQUEST FAILED: %w", err)
+	}
+	defer resp.Body.Close()
+	maxBytes := int64(numberConfig(config, "maxBytes", apiMaxBody))
+	data, truncated, err := readCapped(resp.Body, maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO READ RESPONSE: %v", err)
+	}
+	if truncated {
+		if !boolConfig(config, "truncate", false) {
+			return nil, fmt.Errorf("RESPONSE LARGER THAN %d BYTES, RAISE maxBytes, SET truncate OR USE downloadAsset INSTEAD", maxBytes)
+		}
+		ctx.Logger.Printf("WARNING: RESPONSE FROM %s LARGER THAN %d BYTES, ONLY THE START WAS KEPT", rawURL, maxBytes)
+	}
+	return &apiResponse{Status: resp.StatusCode, URL: resp.Request.URL.String(), Header: resp.Header, Body: data, Truncated: truncated}, nil
+}
+
+// CHECK THE fingerprint AND httpVersion INPUTS SHARED BY DIRECT HTTP TASKS
+func validateClientOptions(config map[string]any) error {
+	if fingerprint, ok := config["fingerprint"].(string); ok {
+		if err := ValidateFingerprint(fingerprint); err != nil {
+			return err
+		}
+	}
+	if httpVersion, ok := config["httpVersion"].(string); ok {
+		if err := ValidateHTTPVersion(httpVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SHORTEN A RESPONSE BODY FOR AN ERROR MESSAGE
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,674 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- SITE ARCHIVING --
+
+// PAGES AN ARCHIVE CRAWLS AT ONCE UNLESS concurrency SAYS OTHERWISE
+const defaultArchiveConcurrency = 4
+
+// ONE ARCHIVED PAGE IN THE INDEX
+type ArchivedPage struct {
+	URL          string    `json:"url"`
+	FinalURL     string    `json:"finalUrl"`
+	Title        string    `json:"title"`
+	Depth        int       `json:"depth"`
+	StatusCode   int       `json:"statusCode"`
+	ContentType  string    `json:"contentType"`
+	LastModified string    `json:"lastModified,omitempty"` // THE Last-Modified HEADER
+	Size         int       `json:"size"`
+	Screenshot   string    `json:"screenshot,omitempty"` // RELATIVE TO THE ARCHIVE FOLDER
+	PDF          string    `json:"pdf,omitempty"`        // RELATIVE TO THE ARCHIVE FOLDER
+	Error        string    `json:"error,omitempty"`
+	Warnings     []string  `json:"warnings,omitempty"` // E.G. THE PAGE WAS TOO LARGE TO KEEP WHOLE
+	CapturedAt   time.Time `json:"capturedAt"`
+}
+
+// ARCHIVE INDEX WRITTEN AS index.json AND RENDERED AS index.html
+type ArchiveIndex struct {
+	ID         string         `json:"id"`
+	JobID      string         `json:"jobId"`
+	StartURL   string         `json:"startUrl"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	WARC       string         `json:"warc,omitempty"`
+	Pages      []ArchivedPage `json:"pages"`
+}
+
+// ARCHIVE SITE TASK (CRAWL + WARC + SCREENSHOT + PDF + BROWSABLE INDEX)
+type ArchiveSiteTask struct{}
+
+func (t *ArchiveSiteTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"url":              "string",   // REQUIRED (START PAGE)
+		"maxPages":         "number?",  // OPTIONAL (defaults to 100)
+		"maxDepth":         "number?",  // OPTIONAL (LINK DEPTH FROM THE START PAGE, defaults to 3)
+		"sameHost":         "boolean?", // OPTIONAL (STAY ON THE START HOST, defaults to true)
+		"includeSitemap":   "boolean?", // OPTIONAL (SEED FROM robots.txt/sitemap.xml, defaults to true; false IS sitemap: off)
+		"sitemap":          "string?",  // OPTIONAL (off, seed: SITEMAP URLS AS WELL AS FOLLOWED LINKS, OR only: SITEMAP URLS AND NO LINKS; defaults to seed)
+		"sitemapUrls":      "array?",   // OPTIONAL (SITEMAP OR SITEMAP INDEX URLS, INSTEAD OF LOOKING IN robots.txt AND /sitemap.xml)
+		"include":          "array?",   // OPTIONAL (REGEXES; ONLY LINKS AND SITEMAP URLS MATCHING ONE ARE CRAWLED)
+		"exclude":          "array?",   // OPTIONAL (REGEXES; LINKS AND SITEMAP URLS MATCHING ONE ARE SKIPPED)
+		"warc":             "boolean?", // OPTIONAL (WRITE A WARC FILE, defaults to true)
+		"screenshot":       "boolean?", // OPTIONAL (FULL-PAGE SCREENSHOT PER PAGE, defaults to true)
+		"pdf":              "boolean?", // OPTIONAL (PDF PRINT PER PAGE, defaults to false)
+		"delay":            "number?",  // OPTIONAL (MS BETWEEN PAGES FROM ONE HOST, defaults to 500)
+		"strategy":         "string?",  // OPTIONAL (bfs, dfs OR priority, defaults to bfs)
+		"priorityPatterns": "array?",   // OPTIONAL (REGEXES; URLS MATCHING EARLIER ONES ARE CRAWLED FIRST WITH priority)
+		"concurrency":      "number?",  // OPTIONAL (PAGES CRAWLED AT ONCE, defaults to 4)
+		"stopWhen":         "object?",  // OPTIONAL (STOP CONDITION CHECKED PER PAGE, E.G. {"knownItems": 20} OR {"olderThan": "lastRun", "dateField": "lastModified"})
+		"linkSelector":     "string?",  // OPTIONAL (CSS OR XPATH; ONLY LINKS IN OR SELECTED BY IT ARE FOLLOWED, defaults to every a[href])
+		"linkSelectorType": "string?",  // OPTIONAL (css OR xpath, DETECTED WHEN NOT GIVEN)
+	}
+}
+
+func (t *ArchiveSiteTask) GetOutputSchema() string {
+	return "object" // RETURNS THE ARCHIVE INDEX
+}
+
+func (t *ArchiveSiteTask) ValidateConfig(config map[string]any) error {
+	rawURL, ok := config["url"].(string)
+	if !ok {
+		return ErrMissingRequiredInput
+	}
+	if u, err := url.Parse(rawURL); err != nil || u.Host == "" {
+		return fmt.Errorf("INVALID START URL: %s", rawURL)
+	}
+	if _, _, err := parseCrawlOptions(config); err != nil {
+		return err
+	}
+	if _, err := parseCrawlFilter(config); err != nil {
+		return err
+	}
+	if _, _, err := parseSitemapOptions(config); err != nil {
+		return err
+	}
+	if _, err := ParseStopCondition(config["stopWhen"]); err != nil {
+		return fmt.Errorf("%w: stopWhen: %v", ErrInvalidInput, err)
+	}
+	if selector, _ := config["linkSelector"].(string); selector != "" {
+		selectorType, _ := config["linkSelectorType"].(string)
+		if err := validateSelector(selector, selectorType); err != nil {
+			return fmt.Errorf("linkSelector: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *ArchiveSiteTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	startURL, _ := config["url"].(string)
+	start, err := url.Parse(startURL)
+	if err != nil {
+		return TaskData{}, fmt.Errorf("INVALID START URL: %v", err)
+	}
+
+	maxPages := 100
+	if v, ok := config["maxPages"].(float64); ok && v > 0 {
+		maxPages = int(v)
+	}
+	if limit := ctx.Engine.sampleLimit(ctx.JobID); limit > 0 && maxPages > limit {
+		ctx.Logger.Printf("SAMPLE RUN: ARCHIVING AT MOST %d PAGES", limit)
+		maxPages = limit
+	}
+	maxDepth := 3
+	if v, ok := config["maxDepth"].(float64); ok && v >= 0 {
+		maxDepth = int(v)
+	}
+	delay := 500 * time.Millisecond
+	if v, ok := config["delay"].(float64); ok && v >= 0 {
+		delay = time.Duration(v) * time.Millisecond
+	}
+	sameHost := boolConfig(config, "sameHost", true)
+	sitemapMode, sitemaps, err := parseSitemapOptions(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	filter, err := parseCrawlFilter(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	writeWARC := boolConfig(config, "warc", true)
+	screenshots := boolConfig(config, "screenshot", true)
+	pdfs := boolConfig(config, "pdf", false)
+	strategy, patterns, err := parseCrawlOptions(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	concurrency := int(numberConfig(config, "concurrency", defaultArchiveConcurrency))
+	var linkSelector *selectorSpec
+	if selector, _ := config["linkSelector"].(string); selector != "" {
+		selectorType, _ := config["linkSelectorType"].(string)
+		if err := validateSelector(selector, selectorType); err != nil {
+			return TaskData{}, fmt.Errorf("linkSelector: %w", err)
+		}
+		spec := parseSelector(selector, selectorType)
+		linkSelector = &spec
+	}
+	var tracker *stopTracker
+	if cond, err := ParseStopCondition(config["stopWhen"]); err != nil {
+		return TaskData{}, fmt.Errorf("%w: stopWhen: %v", ErrInvalidInput, err)
+	} else if cond != nil {
+		if tracker, err = ctx.Engine.newStopTracker(ctx.JobID, *cond); err != nil {
+			return TaskData{}, err
+		}
+	}
+
+	// ARCHIVE FOLDER UNDER STORAGE
+	index := ArchiveIndex{
+		ID:        utils.GenerateID("archive"),
+		JobID:     ctx.JobID,
+		StartURL:  startURL,
+		StartedAt: time.Now(),
+		Pages:     []ArchivedPage{},
+	}
+	dir := filepath.Join(ctx.Engine.cfg.StoragePath, "archives", index.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO CREATE ARCHIVE DIRECTORY: %v", err)
+	}
+
+	client := NewHTTPClient(HTTPClientOptions{
+		Timeout:     60 * time.Second,
+		Fingerprint: ctx.Engine.cfg.TLSFingerprint,
+		HTTPVersion: ctx.Engine.cfg.HTTPVersion,
+		TLS:         ctx.Engine.tlsPolicy(ctx.JobID),
+		Guard:       ctx.Engine.guard,
+		DNS:         ctx.Engine.dnsPolicy(ctx.JobID),
+		Egress:      ctx.Engine.egressPolicy(ctx.JobID),
+	})
+
+	var warc *WARCWriter
+	if writeWARC {
+		index.WARC = "archive.warc.gz"
+		if warc, err = NewWARCWriter(filepath.Join(dir, index.WARC), "Crepes"); err != nil {
+			return TaskData{}, err
+		}
+		defer warc.Close()
+	}
+
+	// A DEDICATED BROWSER FOR RENDERED CAPTURES, WITH A PAGE PER WORKER
+	var browser playwright.Browser
+	if screenshots || pdfs {
+		launched, err := ctx.Engine.launchBrowser(ctx.Context, ctx.JobID, true)
+		if err != nil {
+			return TaskData{}, err
+		}
+		browser = *launched
+		defer browser.Close()
+	}
+
+	// SEED THE FRONTIER WITH THE START PAGE, THEN THE SITEMAP
+	frontier := newCrawlFrontier(strategy, patterns, delay, maxPages)
+	frontier.Push(startURL, 0)
+	if sitemapMode != sitemapOff {
+		locs, stats := DiscoverSitemapURLs(ctx.Context, client, startURL, SitemapOptions{
+			Sitemaps: sitemaps,
+			Limit:    maxPages,
+			Keep:     func(loc string) bool { return archiveInScope(start, loc, sameHost) && filter.allows(loc) },
+		})
+		for _, loc := range locs {
+			frontier.Push(loc, 1)
+		}
+		ctx.Logger.Printf("ARCHIVE FRONTIER SEEDED WITH %d URLS (%d OF %d SITEMAP URLS KEPT FROM %d SITEMAPS, %d UNREADABLE)",
+			frontier.Pending(), stats.Kept, stats.Found, stats.Sitemaps, stats.Failed)
+	}
+
+	// WORKERS TAKE PAGES FROM THE FRONTIER UNTIL IT RUNS DRY OR maxPages ARE CLAIMED
+	ctx.Logger.Printf("CRAWLING %s (%s, %d WORKERS)", startURL, strings.ToUpper(strategy), concurrency)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for worker := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var page playwright.Page
+			if browser != nil {
+				pageOptions := playwright.BrowserNewPageOptions{
+					ExtraHttpHeaders:  ctx.Engine.jobHeaders(ctx.JobID),
+					IgnoreHttpsErrors: playwright.Bool(!ctx.Engine.tlsPolicy(ctx.JobID).Verify),
+				}
+				created, err := browser.NewPage(pageOptions)
+				if err == nil {
+					err = ctx.Engine.guard.guardContext(created.Context(), ctx.Engine.dnsPolicy(ctx.JobID))
+				}
+				if err == nil {
+					err = ctx.Engine.routePlayback(ctx.JobID, created.Context())
+				}
+				if err != nil {
+					ctx.Logger.Printf("WORKER %d COULD NOT OPEN A PAGE, CRAWLING WITHOUT CAPTURES: %v", worker, err)
+				} else {
+					ctx.Engine.watchBudget(ctx.JobID, created.Context())
+					ctx.Engine.watchThrottle(ctx.JobID, created.Context())
+					page = created
+					defer page.Close()
+				}
+			}
+
+			for {
+				target, ok := frontier.Next(ctx.Context)
+				if !ok {
+					return
+				}
+				mu.Lock()
+				n := len(index.Pages)
+				index.Pages = append(index.Pages, ArchivedPage{URL: target.url, Depth: target.depth})
+				mu.Unlock()
+
+				archived, links := archivePage(ctx, client, warc, page, dir, target, n, screenshots, pdfs, linkSelector)
+				mu.Lock()
+				index.Pages[n] = archived
+				mu.Unlock()
+				ctx.Logger.Printf("ARCHIVED %d/%d: %s (%d)", n+1, maxPages, target.url, archived.StatusCode)
+
+				// AN INCREMENTAL CRAWL ENDS ONCE IT REACHES PAGES AN EARLIER RUN ALREADY COVERED
+				if tracker != nil && archived.Error == "" {
+					if reason := tracker.check(archivedPageItem(archived), archived.URL); reason != "" {
+						if frontier.Stop() {
+							ctx.Engine.recordEarlyExit(ctx.JobID, "ARCHIVE "+index.ID, reason)
+						}
+					}
+				}
+
+				// WITH sitemap: only THE SITEMAP IS THE WHOLE CRAWL
+				if target.depth < maxDepth && sitemapMode != sitemapOnly {
+					for _, link := range links {
+						if archiveInScope(start, link, sameHost) && filter.allows(link) {
+							frontier.Push(link, target.depth+1)
+						}
+					}
+				}
+				frontier.Done()
+			}
+		}()
+	}
+	wg.Wait()
+	index.FinishedAt = time.Now()
+
+	// WRITE THE BROWSABLE INDEX
+	if err := writeArchiveIndex(dir, &index); err != nil {
+		return TaskData{}, err
+	}
+	if err := registerArchiveAssets(ctx, dir, &index); err != nil {
+		return TaskData{}, err
+	}
+
+	ctx.Logger.Printf("ARCHIVE %s COMPLETE: %d PAGES", index.ID, len(index.Pages))
+
+	return TaskData{
+		Type: "object",
+		Value: map[string]any{
+			"id":           index.ID,
+			"folder":       dir,
+			"startUrl":     startURL,
+			"pages":        len(index.Pages),
+			"warc":         index.WARC,
+			"index":        "index.html",
+			"cancelled":    ctx.Context.Err() != nil,
+			"stoppedEarly": tracker.stopped(),
+		},
+	}, nil
+}
+
+// A CRAWLED PAGE AS A stopWhen CONDITION SEES IT
+func archivedPageItem(page ArchivedPage) map[string]any {
+	return map[string]any{
+		"url":          page.URL,
+		"finalUrl":     page.FinalURL,
+		"title":        page.Title,
+		"statusCode":   float64(page.StatusCode),
+		"contentType":  page.ContentType,
+		"lastModified": page.LastModified,
+		"depth":        float64(page.Depth),
+	}
+}
+
+// FETCH ONE PAGE INTO THE WARC, CAPTURE IT IN THE BROWSER, AND RETURN ITS OUTLINKS
+func archivePage(ctx *TaskContext, client *http.Client, warc *WARCWriter, page playwright.Page, dir string, target crawlTarget, n int, screenshots, pdfs bool, linkSelector *selectorSpec) (ArchivedPage, []string) {
+	archived := ArchivedPage{URL: target.url, Depth: target.depth, CapturedAt: time.Now()}
+
+	req, err := http.NewRequestWithContext(ctx.Context, "GET", target.url, nil)
+	if err != nil {
+		archived.Error = err.Error()
+		return archived, nil
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	ctx.Engine.setJobHeaders(ctx.JobID, req.Header)
+	resp, err := client.Do(req)
+	if err != nil {
+		archived.Error = err.Error()
+		return archived, nil
+	}
+	limits := HTMLLimitsFromConfig(ctx.Engine.cfg)
+	body, truncated, err := readCapped(resp.Body, limits.maxBytes())
+	resp.Body.Close()
+	if err != nil {
+		archived.Error = err.Error()
+		return archived, nil
+	}
+	if truncated {
+		warning := fmt.Sprintf("PAGE LARGER THAN %d BYTES, ONLY THE START WAS ARCHIVED", limits.maxBytes())
+		ctx.Logger.Printf("WARNING: %s: %s", target.url, warning)
+		archived.Warnings = append(archived.Warnings, warning)
+	}
+
+	archived.FinalURL = resp.Request.URL.String()
+	archived.StatusCode = resp.StatusCode
+	archived.ContentType = resp.Header.Get("Content-Type")
+	archived.LastModified = resp.Header.Get("Last-Modified")
+	archived.Size = len(body)
+
+	if warc != nil {
+		if err := warc.WriteExchange(resp.Request, resp, body, truncated); err != nil {
+			ctx.Logger.Printf("FAILED TO WRITE WARC RECORD FOR %s: %v", target.url, err)
+		}
+	}
+
+	// ONLY HTML PAGES ARE RENDERED AND CRAWLED FURTHER
+	if !strings.Contains(archived.ContentType, "html") {
+		return archived, nil
+	}
+	// A LINK SELECTOR CAN NAME ANY ELEMENT, SO THE WHOLE TREE IS KEPT FOR IT
+	keep := []string{"title", "a"}
+	if linkSelector != nil {
+		keep = []string{"*"}
+	}
+	var links []string
+	if doc, report, err := parseHTMLStream(bytes.NewReader(body), keep, limits); err == nil {
+		archived.Title = strings.TrimSpace(doc.Find("title").First().Text())
+		if links, err = archiveLinks(doc, resp.Request.URL, linkSelector); err != nil {
+			ctx.Logger.Printf("WARNING: %s: %v", target.url, err)
+		}
+		for _, warning := range report.Warnings {
+			ctx.Logger.Printf("WARNING: %s: %s", target.url, warning)
+			archived.Warnings = append(archived.Warnings, warning)
+		}
+	}
+
+	if page == nil || resp.StatusCode >= 400 {
+		return archived, links
+	}
+	if _, err := page.Goto(target.url, playwright.PageGotoOptions{
+		WaitUntil: playwright.WaitUntilStateNetworkidle,
+		Timeout:   playwright.Float(30000),
+	}); err != nil {
+		archived.Error = fmt.Sprintf("RENDER FAILED: %v", err)
+		return archived, links
+	}
+	if title, err := page.Title(); err == nil && title != "" {
+		archived.Title = title
+	}
+
+	name := fmt.Sprintf("page_%04d", n)
+	if screenshots {
+		file := filepath.Join("screenshots", name+".jpg")
+		os.MkdirAll(filepath.Join(dir, "screenshots"), 0755)
+		data, err := page.Screenshot(playwright.PageScreenshotOptions{
+			Path:     playwright.String(filepath.Join(dir, file)),
+			FullPage: playwright.Bool(true),
+			Type:     playwright.ScreenshotTypeJpeg,
+			Quality:  playwright.Int(80),
+		})
+		if err != nil {
+			ctx.Logger.Printf("SCREENSHOT FAILED FOR %s: %v", target.url, err)
+		} else {
+			archived.Screenshot = file
+			if warc != nil {
+				warc.WriteResource("urn:screenshot:"+target.url, "image/jpeg", data)
+			}
+		}
+	}
+	if pdfs {
+		file := filepath.Join("pdf", name+".pdf")
+		os.MkdirAll(filepath.Join(dir, "pdf"), 0755)
+		data, err := page.PDF(playwright.PagePdfOptions{
+			Path:            playwright.String(filepath.Join(dir, file)),
+			PrintBackground: playwright.Bool(true),
+		})
+		if err != nil {
+			ctx.Logger.Printf("PDF FAILED FOR %s: %v", target.url, err)
+		} else {
+			archived.PDF = file
+			if warc != nil {
+				warc.WriteResource("urn:pdf:"+target.url, "application/pdf", data)
+			}
+		}
+	}
+
+	return archived, links
+}
+
+// ABSOLUTE HTTP(S) LINKS FROM A PAGE: EVERY a[href], OR THE ONES A LINK SELECTOR PICKS (ITS MATCHES'
+// OWN href, THE LINKS INSIDE THEM, OR THE VALUE OF AN XPATH LIKE //a/@href)
+func archiveLinks(doc *goquery.Document, base *url.URL, linkSelector *selectorSpec) ([]string, error) {
+	var hrefs []string
+	if linkSelector == nil {
+		hrefs = doc.Find("a[href]").Map(func(_ int, s *goquery.Selection) string { return s.AttrOr("href", "") })
+	} else {
+		matched, err := findSelector(doc.Selection, *linkSelector)
+		if err != nil {
+			return nil, err
+		}
+		matched.Each(func(_ int, s *goquery.Selection) {
+			switch node := s.Get(0); {
+			case s.AttrOr("href", "") != "":
+				hrefs = append(hrefs, s.AttrOr("href", ""))
+			case node.Parent == nil && node.FirstChild != nil && node.FirstChild == node.LastChild:
+				hrefs = append(hrefs, s.Text()) // AN ATTRIBUTE'S VALUE
+			default:
+				hrefs = append(hrefs, s.Find("a[href]").Map(func(_ int, a *goquery.Selection) string { return a.AttrOr("href", "") })...)
+			}
+		})
+	}
+
+	var links []string
+	for _, href := range hrefs {
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			continue
+		}
+		abs := base.ResolveReference(ref)
+		if abs.Scheme != "http" && abs.Scheme != "https" {
+			continue
+		}
+		abs.Fragment = ""
+		links = append(links, abs.String())
+	}
+	return links, nil
+}
+
+// HOW AN ARCHIVE USES THE SITE'S SITEMAPS
+const (
+	sitemapOff  = "off"
+	sitemapSeed = "seed"
+	sitemapOnly = "only"
+)
+
+// THE sitemap MODE (includeSitemap: false MEANS off WHEN IT ISN'T GIVEN) AND ANY sitemapUrls
+func parseSitemapOptions(config map[string]any) (string, []string, error) {
+	mode, _ := config["sitemap"].(string)
+	switch mode {
+	case "":
+		mode = sitemapSeed
+		if !boolConfig(config, "includeSitemap", true) {
+			mode = sitemapOff
+		}
+	case sitemapOff, sitemapSeed, sitemapOnly:
+	default:
+		return "", nil, fmt.Errorf("%w: sitemap MUST BE off, seed OR only", ErrInvalidInput)
+	}
+	var sitemaps []string
+	raw, _ := config["sitemapUrls"].([]any)
+	for _, v := range raw {
+		loc, _ := v.(string)
+		if u, err := url.Parse(loc); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", nil, fmt.Errorf("%w: INVALID SITEMAP URL %v", ErrInvalidInput, v)
+		}
+		sitemaps = append(sitemaps, loc)
+	}
+	return mode, sitemaps, nil
+}
+
+// WHETHER A DISCOVERED URL BELONGS IN THIS ARCHIVE
+func archiveInScope(start *url.URL, rawURL string, sameHost bool) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return !sameHost || strings.EqualFold(strings.TrimPrefix(u.Hostname(), "www."), strings.TrimPrefix(start.Hostname(), "www."))
+}
+
+// DEDUPLICATION KEY FOR THE FRONTIER
+func normalizeArchiveURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Fragment = ""
+	u.Host = strings.ToLower(u.Host)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+var archiveIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Archive of {{.StartURL}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { border-bottom: 1px solid #ddd; padding: 6px; text-align: left; vertical-align: top; }
+img { max-width: 160px; max-height: 120px; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Archive of <a href="{{.StartURL}}">{{.StartURL}}</a></h1>
+<p>{{len .Pages}} pages captured {{.StartedAt.Format "2006-01-02 15:04:05"}} to {{.FinishedAt.Format "2006-01-02 15:04:05"}}{{if .WARC}} &middot; <a href="{{.WARC}}">WARC</a>{{end}}</p>
+<table>
+<tr><th>Preview</th><th>Page</th><th>Status</th><th>Captures</th></tr>
+{{range .Pages}}<tr>
+<td>{{if .Screenshot}}<a href="{{.Screenshot}}"><img src="{{.Screenshot}}" loading="lazy"></a>{{end}}</td>
+<td><strong>{{if .Title}}{{.Title}}{{else}}(untitled){{end}}</strong><br><a href="{{.URL}}">{{.URL}}</a></td>
+<td>{{.StatusCode}}{{if .Error}}<br><span class="error">{{.Error}}</span>{{end}}</td>
+<td>{{if .Screenshot}}<a href="{{.Screenshot}}">screenshot</a> {{end}}{{if .PDF}}<a href="{{.PDF}}">pdf</a>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WRITE index.json AND index.html INTO THE ARCHIVE FOLDER
+func writeArchiveIndex(dir string, index *ArchiveIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("FAILED TO ENCODE ARCHIVE INDEX: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+		return fmt.Errorf("FAILED TO WRITE ARCHIVE INDEX: %v", err)
+	}
+
+	var html bytes.Buffer
+	if err := archiveIndexTemplate.Execute(&html, index); err != nil {
+		return fmt.Errorf("FAILED TO RENDER ARCHIVE INDEX: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), html.Bytes(), 0644); err != nil {
+		return fmt.Errorf("FAILED TO WRITE ARCHIVE INDEX: %v", err)
+	}
+	return nil
+}
+
+// REGISTER THE INDEX, WARC, SCREENSHOTS AND PDFS AS JOB ASSETS
+func registerArchiveAssets(ctx *TaskContext, dir string, index *ArchiveIndex) error {
+	rel := func(name string) string {
+		path := filepath.Join(dir, name)
+		if r, err := filepath.Rel(ctx.Engine.cfg.StoragePath, path); err == nil && !strings.HasPrefix(r, "..") {
+			return r
+		}
+		return path
+	}
+	register := func(name, assetType, title, assetURL string, thumbnail bool, metadata models.JSONMap) error {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil // CAPTURE WAS SKIPPED OR FAILED; NOTHING TO REGISTER
+		}
+		metadata["source"] = "archive"
+		metadata["archiveId"] = index.ID
+		now := time.Now()
+		asset := models.Asset{
+			ID:        fmt.Sprintf("asset_%s", utils.GenerateID("")),
+			JobID:     ctx.JobID,
+			URL:       assetURL,
+			Type:      assetType,
+			Title:     title,
+			LocalPath: rel(name),
+			Size:      info.Size(),
+			Date:      now,
+			Metadata:  metadata,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		_, err = registerAsset(ctx, &asset, thumbnail)
+		return err
+	}
+
+	if err := register("index.html", "document", "Archive index: "+index.StartURL, index.StartURL, false, models.JSONMap{
+		"contentType": "text/html",
+		"pages":       len(index.Pages),
+	}); err != nil {
+		return err
+	}
+	if index.WARC != "" {
+		if err := register(index.WARC, "document", "WARC: "+index.StartURL, index.StartURL, false, models.JSONMap{
+			"contentType": "application/warc",
+			"pages":       len(index.Pages),
+		}); err != nil {
+			return err
+		}
+	}
+	for _, page := range index.Pages {
+		if page.Screenshot != "" {
+			if err := register(page.Screenshot, "image", page.Title, page.URL, true, models.JSONMap{
+				"contentType": "image/jpeg",
+				"pageUrl":     page.URL,
+			}); err != nil {
+				return err
+			}
+		}
+		if page.PDF != "" {
+			if err := register(page.PDF, "document", page.Title, page.URL, false, models.JSONMap{
+				"contentType": "application/pdf",
+				"pageUrl":     page.URL,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BOOLEAN TASK INPUT WITH A DEFAULT
+func boolConfig(config map[string]any, key string, fallback bool) bool {
+	if v, ok := config[key].(bool); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,279 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// -- ASSET NAMING CONFLICTS --
+//
+// WHEN A DOWNLOAD'S TARGET FILE ALREADY EXISTS (OR ANOTHER DOWNLOAD IN FLIGHT IS WRITING IT), THE
+// onConflict POLICY DECIDES WHAT HAPPENS: overwrite IT (THE DEFAULT), skip THE DOWNLOAD AND KEEP THE
+// EXISTING FILE, version THE NEW FILE AS name-1.ext, name-2.ext..., OR hash: DOWNLOAD IT AND NAME
+// IT AFTER ITS CONTENT (name-<sha256>.ext), WHICH DEDUPLICATES IDENTICAL FILES. THE DECISION RIDES
+// ON THE DOWNLOAD'S OUTPUT AND SAVEASSET RECORDS IT IN THE ASSET'S conflict METADATA.
+
+// JOB RULE WITH THE DEFAULT POLICY FOR THE JOB'S DOWNLOADS
+const assetConflictRule = "assetConflict"
+
+// ACCEPTED POLICIES
+var conflictPolicies = []string{"overwrite", "skip", "version", "hash"}
+
+// WHAT A CONFLICT POLICY DID
+const (
+	conflictOverwrote    = "overwrote"
+	conflictSkipped      = "skipped"
+	conflictRenamed      = "renamed"
+	conflictDeduplicated = "deduplicated"
+)
+
+// FAIL FOR AN UNKNOWN POLICY
+func ValidateConflictPolicy(policy string) error {
+	if !slices.Contains(conflictPolicies, policy) {
+		return fmt.Errorf("UNKNOWN CONFLICT POLICY %q (EXPECTED %s)", policy, strings.Join(conflictPolicies, ", "))
+	}
+	return nil
+}
+
+// THE TASK'S onConflict, THEN THE JOB'S assetConflict RULE, THEN overwrite
+func resolveConflictPolicy(ctx *TaskContext, config map[string]any) string {
+	if policy, ok := config["onConflict"].(string); ok && policy != "" {
+		return policy
+	}
+	if v, ok := ctx.Engine.jobRule(ctx.JobID, assetConflictRule); ok {
+		if policy, ok := v.(string); ok && policy != "" {
+			return policy
+		}
+	}
+	return "overwrite"
+}
+
+// PATHS DOWNLOADS IN FLIGHT ARE WRITING, SO TWO OF THEM DON'T PICK THE SAME FREE NAME
+type pathClaims struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newPathClaims() *pathClaims {
+	return &pathClaims{paths: make(map[string]bool)}
+}
+
+// CLAIM THE FIRST OF THE CANDIDATES NOT CLAIMED OR ON DISK (FALSE WHEN ALL ARE TAKEN)
+func (c *pathClaims) claimFree(candidates func(yield func(string) bool)) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range candidates {
+		if !c.paths[path] && !fileExists(path) {
+			c.paths[path] = true
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// CLAIM A PATH WHETHER OR NOT IT IS FREE, REPORTING WHETHER IT WAS TAKEN
+func (c *pathClaims) claim(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	taken := c.paths[path] || fileExists(path)
+	c.paths[path] = true
+	return taken
+}
+
+// CLAIM A PATH ONLY IF NO DOWNLOAD IN FLIGHT HOLDS IT, WHETHER OR NOT IT'S ON DISK
+func (c *pathClaims) claimIdle(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paths[path] {
+		return false
+	}
+	c.paths[path] = true
+	return true
+}
+
+func (c *pathClaims) release(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, path := range paths {
+		delete(c.paths, path)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// WHERE ONE DOWNLOAD WRITES, AND WHAT ITS POLICY DECIDED
+type assetTarget struct {
+	policy    string
+	requested string // THE NAME THE TASK ASKED FOR
+	path      string // WHERE TO WRITE (OR, WHEN SKIPPED, THE EXISTING FILE)
+	action    string // "" WITHOUT A CONFLICT
+	claims    *pathClaims
+	claimed   []string
+}
+
+// DECIDE WHERE A DOWNLOAD TO path GOES UNDER A POLICY. CALL release ONCE THE FILE IS WRITTEN
+func (e *Engine) claimAssetTarget(policy, path string) (*assetTarget, error) {
+	target := &assetTarget{policy: policy, requested: path, path: path, claims: e.assetPaths}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	switch policy {
+	case "skip":
+		if e.assetPaths.claim(path) {
+			target.action = conflictSkipped
+		}
+		target.claimed = append(target.claimed, path)
+	case "version":
+		if !e.assetPaths.claim(path) {
+			target.claimed = append(target.claimed, path)
+			return target, nil
+		}
+		target.claimed = append(target.claimed, path)
+		free, ok := e.assetPaths.claimFree(func(yield func(string) bool) {
+			for i := 1; i <= 10000; i++ {
+				if !yield(fmt.Sprintf("%s-%d%s", base, i, ext)) {
+					return
+				}
+			}
+		})
+		if !ok {
+			target.release()
+			return nil, fmt.Errorf("NO FREE VERSION OF %s", filepath.Base(path))
+		}
+		target.path, target.action = free, conflictRenamed
+		target.claimed = append(target.claimed, free)
+	case "hash":
+		if !e.assetPaths.claim(path) {
+			target.claimed = append(target.claimed, path)
+			return target, nil
+		}
+		target.claimed = append(target.claimed, path)
+		// DOWNLOAD UNDER A TEMPORARY NAME (KEEPING THE EXTENSION FOR MANIFESTS), RENAMED ONCE HASHED
+		temp, _ := e.assetPaths.claimFree(func(yield func(string) bool) {
+			for {
+				if !yield(base + "." + utils.GenerateID("part") + ext) {
+					return
+				}
+			}
+		})
+		target.path, target.action = temp, conflictRenamed
+		target.claimed = append(target.claimed, temp)
+	default:
+		if e.assetPaths.claim(path) {
+			target.action = conflictOverwrote
+		}
+		target.claimed = append(target.claimed, path)
+	}
+	return target, nil
+}
+
+// NAME A hash DOWNLOAD AFTER ITS CONTENT, RETURNING ITS FINAL PATH. written IS WHERE THE DOWNLOAD
+// ENDED UP (MANIFEST DOWNLOADS CAN CHANGE THE EXTENSION). WHEN THE REQUESTED FILE OR THE HASHED NAME
+// ALREADY HOLDS THE SAME CONTENT, THAT FILE IS KEPT AND THE NEW COPY REMOVED
+func (t *assetTarget) settle(written string) (string, error) {
+	if t.policy != "hash" || t.action == "" {
+		return written, nil
+	}
+	sum, err := fileSHA256(written)
+	if err != nil {
+		return "", fmt.Errorf("FAILED TO HASH DOWNLOAD: %v", err)
+	}
+	ext := filepath.Ext(written)
+	if ext == filepath.Ext(t.requested) {
+		if existing, err := fileSHA256(t.requested); err == nil && existing == sum {
+			os.Remove(written)
+			t.action = conflictDeduplicated
+			return t.requested, nil
+		}
+	}
+	final := strings.TrimSuffix(t.requested, filepath.Ext(t.requested)) + "-" + sum[:16] + ext
+	if t.claims.claim(final) {
+		t.claimed = append(t.claimed, final)
+		if existing, err := fileSHA256(final); err == nil && existing == sum {
+			os.Remove(written)
+			t.action = conflictDeduplicated
+			return final, nil
+		}
+		// SAME NAME, DIFFERENT CONTENT (A HASH PREFIX COLLISION OR A FILE EDITED ON DISK): KEEP BOTH
+		final = strings.TrimSuffix(final, ext) + "-" + sum[16:32] + ext
+	} else {
+		t.claimed = append(t.claimed, final)
+	}
+	if err := os.Rename(written, final); err != nil {
+		return "", fmt.Errorf("FAILED TO RENAME DOWNLOAD: %v", err)
+	}
+	return final, nil
+}
+
+// LET OTHER DOWNLOADS USE THE CLAIMED NAMES AGAIN
+func (t *assetTarget) release() {
+	t.claims.release(t.claimed...)
+	t.claimed = nil
+}
+
+// THE DECISION AS RECORDED ON THE ASSET (NIL WITHOUT A CONFLICT)
+func (t *assetTarget) record(storage, final string) map[string]any {
+	if t.action == "" {
+		return nil
+	}
+	requested, _ := utils.RelativeToRoot(storage, t.requested)
+	path, _ := utils.RelativeToRoot(storage, final)
+	return map[string]any{
+		"policy":    t.policy,
+		"action":    t.action,
+		"requested": requested,
+		"path":      path,
+	}
+}
+
+// HEX SHA-256 OF A FILE'S CONTENT
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// DOWNLOAD INFO FOR THE EXISTING FILE A skip POLICY KEPT (SIZE 0 WHILE ANOTHER DOWNLOAD IS STILL WRITING IT)
+func skippedDownload(ctx *TaskContext, url, filePath string, target *assetTarget) TaskData {
+	var size int64
+	if info, err := os.Stat(filePath); err == nil {
+		size = info.Size()
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(filePath))
+	return TaskData{
+		Type: "object",
+		Value: map[string]any{
+			"url":         url,
+			"sourceUrl":   url,
+			"filePath":    filePath,
+			"size":        size,
+			"contentType": contentType,
+			"type":        assetTypeOf(contentType),
+			"timestamp":   time.Now().Unix(),
+			"skipped":     true,
+			"conflict":    target.record(ctx.Engine.cfg.StoragePath, filePath),
+		},
+	}
+}
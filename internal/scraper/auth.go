@@ -0,0 +1,355 @@
+package scraper
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -- API AUTHENTICATION --
+
+// JOB RULE HOLDING NAMED AUTH PROFILES THAT TASKS REFER TO WITH THEIR auth INPUT, E.G.
+// {"api": {"type": "oauth2", "tokenUrl": "...", "clientId": "...", "clientSecret": "env:API_SECRET"}}
+const authRule = "auth"
+
+const (
+	// TOKENS ARE REFRESHED THIS LONG BEFORE THEY EXPIRE
+	tokenExpiryMargin = 30 * time.Second
+	tokenTimeout      = 30 * time.Second
+)
+
+// RETURNED WHEN A TASK NAMES AN AUTH PROFILE THE JOB DOESN'T DEFINE
+var ErrUnknownAuthProfile = errors.New("UNKNOWN AUTH PROFILE")
+
+// CREDENTIALS FOR API REQUESTS. STRING SECRETS MAY BE "env:NAME" TO READ AN ENVIRONMENT VARIABLE
+type AuthProfile struct {
+	Type string `json:"type"` // oauth2, bearer OR basic
+
+	// oauth2
+	Grant        string            `json:"grant"` // client_credentials (DEFAULT), refresh_token OR password
+	TokenURL     string            `json:"tokenUrl"`
+	ClientID     string            `json:"clientId"`
+	ClientSecret string            `json:"clientSecret"`
+	RefreshToken string            `json:"refreshToken"`
+	Scopes       []string          `json:"scopes"`
+	Audience     string            `json:"audience"`
+	Params       map[string]string `json:"params"`    // EXTRA TOKEN REQUEST FIELDS
+	AuthStyle    string            `json:"authStyle"` // header (DEFAULT, HTTP BASIC) OR body
+
+	// bearer
+	Token string `json:"token"`
+
+	// basic, AND THE oauth2 password GRANT
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// PARSE AND CHECK AN auth RULE (NIL WHEN UNSET)
+func ParseAuthProfiles(raw any) (map[string]AuthProfile, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var profiles map[string]AuthProfile
+	if err := decoder.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("AUTH MUST BE AN OBJECT OF NAMED PROFILES: %v", err)
+	}
+	for name, p := range profiles {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("AUTH PROFILE %s: %v", name, err)
+		}
+	}
+	return profiles, nil
+}
+
+func (p AuthProfile) validate() error {
+	switch p.Type {
+	case "oauth2":
+		if p.TokenURL == "" {
+			return fmt.Errorf("tokenUrl IS REQUIRED")
+		}
+		switch p.Grant {
+		case "", "client_credentials":
+			if p.ClientID == "" {
+				return fmt.Errorf("clientId IS REQUIRED")
+			}
+		case "refresh_token":
+			if p.RefreshToken == "" {
+				return fmt.Errorf("refreshToken IS REQUIRED")
+			}
+		case "password":
+			if p.Username == "" {
+				return fmt.Errorf("username IS REQUIRED")
+			}
+		default:
+			return fmt.Errorf("UNKNOWN GRANT %q", p.Grant)
+		}
+		if p.AuthStyle != "" && p.AuthStyle != "header" && p.AuthStyle != "body" {
+			return fmt.Errorf("authStyle MUST BE header OR body")
+		}
+	case "bearer":
+		if p.Token == "" {
+			return fmt.Errorf("token IS REQUIRED")
+		}
+	case "basic":
+		if p.Username == "" {
+			return fmt.Errorf("username IS REQUIRED")
+		}
+	default:
+		return fmt.Errorf("type MUST BE oauth2, bearer OR basic")
+	}
+	return nil
+}
+
+// RESOLVE AN "env:NAME" SECRET
+func secretValue(value string) string {
+	if name, ok := strings.CutPrefix(value, "env:"); ok {
+		return os.Getenv(name)
+	}
+	return value
+}
+
+// ACCESS TOKEN FROM A TOKEN ENDPOINT
+type oauthToken struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string // ROTATED BY SOME PROVIDERS ON EVERY REFRESH
+	Expiry       time.Time
+}
+
+func (t *oauthToken) valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Until(t.Expiry) > tokenExpiryMargin)
+}
+
+// CACHES OAUTH2 TOKENS PER JOB AND PROFILE ACROSS RUNS, SO ROTATED REFRESH TOKENS ARE KEPT
+type AuthManager struct {
+	mu     sync.Mutex
+	tokens map[string]*oauthToken
+	locks  map[string]*sync.Mutex // ONE TOKEN REQUEST AT A TIME PER KEY
+	client *http.Client
+}
+
+// NEW AUTH MANAGER; TOKEN ENDPOINTS COME FROM JOB RULES, SO THEY GO THROUGH THE SSRF GUARD
+func NewAuthManager(guard *NetGuard) *AuthManager {
+	client := &http.Client{Timeout: tokenTimeout}
+	if guard != nil {
+		client = NewHTTPClient(HTTPClientOptions{Timeout: tokenTimeout, Guard: guard})
+	}
+	return &AuthManager{
+		tokens: make(map[string]*oauthToken),
+		locks:  make(map[string]*sync.Mutex),
+		client: client,
+	}
+}
+
+// CACHE KEY FOR A JOB'S PROFILE; EDITING THE PROFILE STARTS A FRESH CACHE ENTRY
+func tokenKey(jobID string, profile AuthProfile) string {
+	encoded, _ := json.Marshal(profile)
+	sum := sha1.Sum(encoded)
+	return jobID + ":" + hex.EncodeToString(sum[:])
+}
+
+// VALID ACCESS TOKEN FOR A PROFILE, FETCHING OR REFRESHING IT WHEN NEEDED
+func (m *AuthManager) token(jobID string, profile AuthProfile, force bool) (*oauthToken, error) {
+	key := tokenKey(jobID, profile)
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[key] = lock
+	}
+	m.mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	m.mu.Lock()
+	cached := m.tokens[key]
+	m.mu.Unlock()
+	if cached.valid() && !force {
+		return cached, nil
+	}
+
+	// PREFER THE LATEST REFRESH TOKEN, THEN THE PROFILE'S OWN GRANT
+	var token *oauthToken
+	var err error
+	if cached != nil && cached.RefreshToken != "" {
+		token, err = m.requestToken(profile, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {cached.RefreshToken},
+		})
+	}
+	if token == nil {
+		token, err = m.requestToken(profile, profile.grantValues())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" && cached != nil {
+		token.RefreshToken = cached.RefreshToken
+	}
+	m.mu.Lock()
+	m.tokens[key] = token
+	m.mu.Unlock()
+	return token, nil
+}
+
+// FORM FIELDS FOR THE PROFILE'S CONFIGURED GRANT
+func (p AuthProfile) grantValues() url.Values {
+	values := url.Values{}
+	switch p.Grant {
+	case "refresh_token":
+		values.Set("grant_type", "refresh_token")
+		values.Set("refresh_token", secretValue(p.RefreshToken))
+	case "password":
+		values.Set("grant_type", "password")
+		values.Set("username", secretValue(p.Username))
+		values.Set("password", secretValue(p.Password))
+	default:
+		values.Set("grant_type", "client_credentials")
+	}
+	return values
+}
+
+// POST TO THE TOKEN ENDPOINT
+func (m *AuthManager) requestToken(profile AuthProfile, values url.Values) (*oauthToken, error) {
+	if len(profile.Scopes) > 0 {
+		values.Set("scope", strings.Join(profile.Scopes, " "))
+	}
+	if profile.Audience != "" {
+		values.Set("audience", profile.Audience)
+	}
+	for k, v := range profile.Params {
+		values.Set(k, secretValue(v))
+	}
+	clientID, clientSecret := secretValue(profile.ClientID), secretValue(profile.ClientSecret)
+	if profile.AuthStyle == "body" && clientID != "" {
+		values.Set("client_id", clientID)
+		values.Set("client_secret", clientSecret)
+	}
+
+	req, err := http.NewRequest("POST", profile.TokenURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO CREATE TOKEN REQUEST: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	if profile.AuthStyle != "body" && clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("TOKEN REQUEST FAILED: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.Unmarshal(body, &result)
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		if result.Error != "" {
+			return nil, fmt.Errorf("TOKEN REQUEST FAILED: %s %s", result.Error, result.ErrorDescription)
+		}
+		return nil, fmt.Errorf("TOKEN REQUEST FAILED: STATUS %d", resp.StatusCode)
+	}
+
+	token := &oauthToken{AccessToken: result.AccessToken, TokenType: result.TokenType, RefreshToken: result.RefreshToken}
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// LOOK UP ONE OF THE RUNNING JOB'S AUTH PROFILES
+func (e *Engine) authProfile(jobID, name string) (AuthProfile, error) {
+	raw, _ := e.jobRule(jobID, authRule)
+	profiles, err := ParseAuthProfiles(raw)
+	if err != nil {
+		return AuthProfile{}, err
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return AuthProfile{}, fmt.Errorf("%w: %s", ErrUnknownAuthProfile, name)
+	}
+	return profile, nil
+}
+
+// ADD A PROFILE'S CREDENTIALS TO A REQUEST
+func (e *Engine) authorize(jobID string, profile AuthProfile, req *http.Request, force bool) error {
+	switch profile.Type {
+	case "oauth2":
+		token, err := e.auth.token(jobID, profile, force)
+		if err != nil {
+			return err
+		}
+		tokenType := token.TokenType
+		if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+			tokenType = "Bearer" // SOME PROVIDERS SEND "bearer", WHICH STRICT APIS REJECT
+		}
+		req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+secretValue(profile.Token))
+	case "basic":
+		req.SetBasicAuth(secretValue(profile.Username), secretValue(profile.Password))
+	}
+	return nil
+}
+
+// SEND A REQUEST WITH THE NAMED AUTH PROFILE (NONE WHEN EMPTY). AN OAUTH2 REQUEST ANSWERED WITH
+// 401 IS RETRIED ONCE WITH A FRESH TOKEN, SO REVOKED OR EARLY-EXPIRED TOKENS DON'T FAIL THE TASK
+func (e *Engine) doWithAuth(ctx *TaskContext, client *http.Client, authName string, req *http.Request) (*http.Response, error) {
+	if authName == "" {
+		return client.Do(req)
+	}
+	profile, err := e.authProfile(ctx.JobID, authName)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.authorize(ctx.JobID, profile, req, false); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || profile.Type != "oauth2" {
+		return resp, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil // BODY ALREADY CONSUMED, CAN'T RESEND
+	}
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	ctx.Logger.Printf("TOKEN FOR AUTH PROFILE %s WAS REJECTED, REFRESHING", authName)
+	if err := e.authorize(ctx.JobID, profile, retry, true); err != nil {
+		return nil, err
+	}
+	return client.Do(retry)
+}
@@ -0,0 +1,185 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- BROWSER CRASH RECOVERY --
+//
+// A BROWSER PROCESS THAT DIES (OOM KILLER, GPU FAULT, RENDERER CRASH) TAKES ITS PAGES WITH
+// IT. BROWSERS AND PAGES MADE BY createBrowser AND createPage REMEMBER HOW THEY WERE MADE,
+// SO THE NEXT TASK THAT LOOKS ONE UP GETS A FRESH ONE UNDER THE SAME ID, BACK AT THE URL
+// THE OLD PAGE WAS ON. A TASK THAT FAILS BECAUSE ITS BROWSER DIED UNDER IT IS RUN AGAIN.
+// WHATEVER LIVED ONLY IN THE OLD BROWSER (COOKIES, FORM STATE) IS GONE.
+
+// TIMES A TASK IS RUN AGAIN AFTER ITS BROWSER OR PAGE DIED UNDER IT
+const maxCrashRetries = 2
+
+// HOW A BROWSER OR PAGE WAS MADE
+type resourceRecipe struct {
+	kind      string                           // browser OR page
+	headless  bool                             // BROWSERS
+	browserID string                           // PAGES: THE BROWSER THEY WERE OPENED IN
+	options   playwright.BrowserNewPageOptions // PAGES
+	crashed   *atomic.Bool                     // PAGES: SET BY THE RENDERER'S crash EVENT
+}
+
+// REMEMBER HOW A RESOURCE WAS MADE
+func (rm *ResourceManager) setRecipe(jobID, resourceID string, recipe resourceRecipe) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if _, ok := rm.recipes[jobID]; !ok {
+		rm.recipes[jobID] = make(map[string]resourceRecipe)
+	}
+	rm.recipes[jobID][resourceID] = recipe
+}
+
+func (rm *ResourceManager) recipe(jobID, resourceID string) (resourceRecipe, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	recipe, ok := rm.recipes[jobID][resourceID]
+	return recipe, ok
+}
+
+// REMEMBER A NEW PAGE AND WATCH ITS RENDERER
+func (e *Engine) trackPage(jobID, pageID, browserID string, page playwright.Page, options playwright.BrowserNewPageOptions) {
+	crashed := &atomic.Bool{}
+	page.OnCrash(func(playwright.Page) {
+		log.Printf("[JOB %s] PAGE %s CRASHED", jobID, pageID)
+		crashed.Store(true)
+	})
+	e.resourceManager.setRecipe(jobID, pageID, resourceRecipe{kind: "page", browserID: browserID, options: options, crashed: crashed})
+}
+
+// WHETHER AN ERROR MEANS THE BROWSER OR PAGE WENT AWAY (TASKS OFTEN FLATTEN PLAYWRIGHT'S
+// ERRORS INTO TEXT, SO THE MESSAGE IS CHECKED TOO)
+func isBrowserCrash(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, playwright.ErrTargetClosed) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, marker := range []string{"target closed", "target crashed", "page crashed", "has been closed", "browser has disconnected", "connection closed"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// WHETHER A TRACKED PAGE CAN NO LONGER BE USED
+func pageDead(page playwright.Page, recipe resourceRecipe) bool {
+	if page.IsClosed() || (recipe.crashed != nil && recipe.crashed.Load()) {
+		return true
+	}
+	browser := page.Context().Browser()
+	return browser != nil && !browser.IsConnected()
+}
+
+// WHETHER ANY BROWSER OR PAGE THE JOB MADE HAS DIED
+func (e *Engine) hasDeadResources(jobID string) bool {
+	rm := e.resourceManager
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for id, recipe := range rm.recipes[jobID] {
+		switch resource := rm.resources[jobID][id].(type) {
+		case playwright.Browser:
+			if !resource.IsConnected() {
+				return true
+			}
+		case playwright.Page:
+			if pageDead(resource, recipe) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RELAUNCH A DEAD BROWSER UNDER ITS OLD ID (A LIVE ONE IS RETURNED AS IS)
+func (e *Engine) reviveBrowser(ctx context.Context, jobID, browserID string, logger *log.Logger) (playwright.Browser, error) {
+	recipe, ok := e.resourceManager.recipe(jobID, browserID)
+	if !ok || recipe.kind != "browser" {
+		return nil, ErrBrowserNotFound
+	}
+	resource, _ := e.resourceManager.GetResource(jobID, browserID)
+	if old, ok := resource.(playwright.Browser); ok {
+		if old.IsConnected() {
+			return old, nil
+		}
+		old.Close() // RELEASE WHAT'S LEFT OF THE PROCESS
+	}
+
+	logger.Printf("BROWSER %s DIED, LAUNCHING A REPLACEMENT", browserID)
+	browser, err := e.launchBrowser(ctx, jobID, recipe.headless)
+	if err != nil {
+		return nil, fmt.Errorf("BROWSER %s DIED AND COULD NOT BE RELAUNCHED: %w", browserID, err)
+	}
+	e.resourceManager.CreateResource(jobID, browserID, "browser", *browser)
+	e.events.Publish("browser.recovered", jobID, map[string]any{"browserId": browserID})
+	return *browser, nil
+}
+
+// REOPEN A DEAD PAGE UNDER ITS OLD ID, IN ITS (POSSIBLY RELAUNCHED) BROWSER, AT THE URL IT
+// WAS ON. A LIVE PAGE IS RETURNED AS IS
+func (e *Engine) revivePage(ctx context.Context, jobID, pageID string, logger *log.Logger) (playwright.Page, error) {
+	recipe, ok := e.resourceManager.recipe(jobID, pageID)
+	if !ok || recipe.kind != "page" {
+		return nil, ErrPageNotFound
+	}
+	lastURL := ""
+	resource, _ := e.resourceManager.GetResource(jobID, pageID)
+	if old, ok := resource.(playwright.Page); ok {
+		if !pageDead(old, recipe) {
+			return old, nil
+		}
+		lastURL = old.URL()
+		old.Close()
+	}
+
+	browser, err := e.reviveBrowser(ctx, jobID, recipe.browserID, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("PAGE %s DIED, REOPENING IT", pageID)
+	if err := e.browsers.reserveTab(ctx, browser); err != nil {
+		return nil, fmt.Errorf("%w: PAGE %s DIED AND COULD NOT BE REOPENED: %v", ErrPageCreation, pageID, err)
+	}
+	page, err := browser.NewPage(recipe.options)
+	e.browsers.openedTab(browser, page)
+	if err != nil {
+		return nil, fmt.Errorf("%w: PAGE %s DIED AND COULD NOT BE REOPENED: %v", ErrPageCreation, pageID, err)
+	}
+	if err := e.guard.guardContext(page.Context(), e.dnsPolicy(jobID)); err != nil {
+		page.Close()
+		return nil, fmt.Errorf("%w: SSRF PROTECTION: %v", ErrPageCreation, err)
+	}
+	if err := e.routePlayback(jobID, page.Context()); err != nil {
+		page.Close()
+		return nil, fmt.Errorf("%w: PLAYBACK: %v", ErrPageCreation, err)
+	}
+	e.watchBudget(jobID, page.Context())
+	e.watchThrottle(jobID, page.Context())
+	e.resourceManager.CreateResource(jobID, pageID, "page", page)
+	e.trackPage(jobID, pageID, recipe.browserID, page, recipe.options)
+
+	// BACK TO WHERE IT WAS; A FAILED NAVIGATION STILL LEAVES A USABLE PAGE FOR THE TASK
+	if strings.HasPrefix(lastURL, "http://") || strings.HasPrefix(lastURL, "https://") {
+		if err := e.guard.checkURL(ctx, lastURL, e.dnsPolicy(jobID)); err == nil {
+			if _, err := page.Goto(lastURL); err != nil {
+				logger.Printf("REOPENED PAGE %s BUT COULD NOT RETURN TO %s: %v", pageID, lastURL, err)
+			}
+		}
+	}
+	e.events.Publish("page.recovered", jobID, map[string]any{"pageId": pageID, "url": lastURL})
+	return page, nil
+}
@@ -0,0 +1,347 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- BROWSER POOL --
+//
+// EVERY BROWSER THE ENGINE LAUNCHES HOLDS A SLOT IN THE POOL UNTIL IT DISCONNECTS. maxBrowsers CAPS
+// THE SLOTS ACROSS ALL JOBS: A LAUNCH OVER THE CAP WAITS FOR A BROWSER TO CLOSE (OR FOR ITS RUN TO
+// END). maxBrowserTabs CAPS THE PAGES OPEN IN ONE BROWSER THE SAME WAY. browserLifetime RELAUNCHES
+// A BROWSER THAT HAS BEEN UP THAT LONG THE NEXT TIME IT SITS UNUSED, SO A LONG RUN DOESN'T KEEP ONE
+// CHROMIUM PROCESS (AND WHATEVER IT HAS LEAKED) FOR DAYS. THE LIMITS ARE READ FROM THE CONFIG ON
+// EVERY CHECK, SO CHANGES TAKE EFFECT AT ONCE; LOWERING ONE NEVER CLOSES ANYTHING, NEW BROWSERS
+// AND PAGES JUST WAIT UNTIL ENOUGH HAVE CLOSED.
+
+const (
+	maxPoolBrowsers    = 256
+	maxPoolTabs        = 100
+	minBrowserLifetime = time.Minute
+	maxBrowserLifetime = 7 * 24 * time.Hour
+
+	browserPoolRecheck = time.Second // WAITERS LOOK AGAIN THIS OFTEN, EVEN WITHOUT A CLOSE TO WAKE THEM
+	browserIdleAfter   = time.Minute // UNUSED THIS LONG COUNTS AS IDLE (AND MAY BE RECYCLED)
+)
+
+// A LAUNCH OR PAGE WAS WAITING WHEN THE ENGINE SHUT DOWN
+var ErrBrowserPoolClosed = errors.New("BROWSER POOL IS CLOSED")
+
+// LIMITS OF THE POOL; ZERO MEANS NO LIMIT (OR, FOR THE LIFETIME, NEVER RELAUNCH)
+type BrowserPoolLimits struct {
+	MaxBrowsers     int `json:"maxBrowsers"`
+	MaxBrowserTabs  int `json:"maxBrowserTabs"`
+	BrowserLifetime int `json:"browserLifetime"` // MS
+}
+
+func (l BrowserPoolLimits) Validate() error {
+	if l.MaxBrowsers < 0 || l.MaxBrowsers > maxPoolBrowsers {
+		return fmt.Errorf("maxBrowsers MUST BE BETWEEN 0 (NO LIMIT) AND %d", maxPoolBrowsers)
+	}
+	if l.MaxBrowserTabs < 0 || l.MaxBrowserTabs > maxPoolTabs {
+		return fmt.Errorf("maxBrowserTabs MUST BE BETWEEN 0 (NO LIMIT) AND %d", maxPoolTabs)
+	}
+	lifetime := time.Duration(l.BrowserLifetime) * time.Millisecond
+	if l.BrowserLifetime < 0 || (lifetime > 0 && lifetime < minBrowserLifetime) || lifetime > maxBrowserLifetime {
+		return fmt.Errorf("browserLifetime MUST BE 0 (NEVER) OR BETWEEN %d AND %d MS", minBrowserLifetime.Milliseconds(), maxBrowserLifetime.Milliseconds())
+	}
+	return nil
+}
+
+func poolLimits(cfg *config.Config) BrowserPoolLimits {
+	return BrowserPoolLimits{MaxBrowsers: cfg.MaxBrowsers, MaxBrowserTabs: cfg.MaxBrowserTabs, BrowserLifetime: cfg.BrowserLifetime}
+}
+
+// ONE BROWSER IN THE POOL
+type PooledBrowser struct {
+	JobID       string    `json:"jobId,omitempty"`
+	BrowserID   string    `json:"browserId,omitempty"` // ITS RESOURCE ID IN THE JOB (EMPTY FOR A TASK'S OWN BROWSER)
+	Tabs        int       `json:"tabs"`
+	LaunchedAt  time.Time `json:"launchedAt"`
+	Age         int64     `json:"age"`               // MS
+	IdleFor     int64     `json:"idleFor,omitempty"` // MS SINCE A TASK LAST USED IT
+	Idle        bool      `json:"idle"`
+	Recyclable  bool      `json:"recyclable"` // RELAUNCHED ON ITS NEXT USE IF CLOSED
+	pendingTabs int
+}
+
+// SNAPSHOT OF THE POOL
+type BrowserPoolStats struct {
+	Limits          BrowserPoolLimits `json:"limits"`
+	Open            int               `json:"open"`
+	Active          int               `json:"active"` // USED BY A TASK IN THE LAST MINUTE
+	Idle            int               `json:"idle"`
+	Tabs            int               `json:"tabs"`
+	Launching       int               `json:"launching"`       // SLOTS TAKEN BY BROWSERS STILL STARTING
+	WaitingBrowsers int               `json:"waitingBrowsers"` // LAUNCHES WAITING FOR A SLOT
+	WaitingTabs     int               `json:"waitingTabs"`     // PAGES WAITING FOR ROOM IN THEIR BROWSER
+	Launched        int64             `json:"launched"`        // SINCE START
+	Waited          int64             `json:"waited"`          // LAUNCHES AND PAGES THAT HAD TO WAIT
+	Recycled        int64             `json:"recycled"`        // RELAUNCHED FOR THEIR LIFETIME
+	Browsers        []PooledBrowser   `json:"browsers"`
+}
+
+type browserPool struct {
+	cfg *config.Config
+
+	mu              sync.Mutex
+	open            map[playwright.Browser]*PooledBrowser
+	launching       int
+	waitingBrowsers int
+	waitingTabs     int
+	changed         chan struct{} // CLOSED AND REPLACED WHENEVER A SLOT OR TAB FREES UP
+	closed          bool
+
+	launched atomic.Int64
+	waited   atomic.Int64
+	recycled atomic.Int64
+}
+
+func newBrowserPool(cfg *config.Config) *browserPool {
+	return &browserPool{
+		cfg:     cfg,
+		open:    make(map[playwright.Browser]*PooledBrowser),
+		changed: make(chan struct{}),
+	}
+}
+
+// WAKE EVERYTHING WAITING (CALLER HOLDS p.mu)
+func (p *browserPool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *browserPool) wake() {
+	p.mu.Lock()
+	p.notify()
+	p.mu.Unlock()
+}
+
+// WAIT UNTIL take (RUN UNDER THE LOCK) SUCCEEDS, THE CONTEXT ENDS OR THE POOL CLOSES
+func (p *browserPool) wait(ctx context.Context, waiting *int, take func() bool) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counted := false
+	for !take() {
+		if p.closed {
+			return ErrBrowserPoolClosed
+		}
+		if !counted {
+			p.waited.Add(1)
+			counted = true
+		}
+		changed := p.changed
+		*waiting++
+		p.mu.Unlock()
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-changed:
+		case <-time.After(browserPoolRecheck):
+		}
+		p.mu.Lock()
+		*waiting--
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TAKE A SLOT FOR A BROWSER ABOUT TO LAUNCH; launched OR abandon GIVES IT BACK
+func (p *browserPool) reserve(ctx context.Context) error {
+	return p.wait(ctx, &p.waitingBrowsers, func() bool {
+		if limit := p.cfg.MaxBrowsers; limit > 0 && len(p.open)+p.launching >= limit {
+			return false
+		}
+		p.launching++
+		return true
+	})
+}
+
+// THE LAUNCH FAILED
+func (p *browserPool) abandon() {
+	p.mu.Lock()
+	p.launching--
+	p.notify()
+	p.mu.Unlock()
+}
+
+// THE RESERVED BROWSER IS UP; ITS SLOT IS HELD UNTIL IT DISCONNECTS
+func (p *browserPool) add(browser playwright.Browser, jobID string) {
+	p.mu.Lock()
+	p.launching--
+	p.open[browser] = &PooledBrowser{JobID: jobID, LaunchedAt: time.Now()}
+	p.mu.Unlock()
+	p.launched.Add(1)
+	browser.OnDisconnected(func(playwright.Browser) {
+		p.mu.Lock()
+		delete(p.open, browser)
+		p.notify()
+		p.mu.Unlock()
+	})
+}
+
+// WAIT FOR ROOM FOR ONE MORE PAGE IN A BROWSER; openedTab GIVES BACK THE RESERVATION
+func (p *browserPool) reserveTab(ctx context.Context, browser playwright.Browser) error {
+	return p.wait(ctx, &p.waitingTabs, func() bool {
+		pooled, ok := p.open[browser]
+		if !ok {
+			return true // NOT LAUNCHED THROUGH THE POOL
+		}
+		if limit := p.cfg.MaxBrowserTabs; limit > 0 && openTabs(browser)+pooled.pendingTabs >= limit {
+			return false
+		}
+		pooled.pendingTabs++
+		return true
+	})
+}
+
+// A RESERVED PAGE IS OPEN (OR FAILED TO OPEN); ITS CLOSE FREES THE TAB
+func (p *browserPool) openedTab(browser playwright.Browser, page playwright.Page) {
+	p.mu.Lock()
+	if pooled, ok := p.open[browser]; ok {
+		pooled.pendingTabs--
+	}
+	p.notify()
+	p.mu.Unlock()
+	if page != nil {
+		page.OnClose(func(playwright.Page) { p.wake() })
+	}
+}
+
+// PAGES OPEN IN A BROWSER, ACROSS ITS CONTEXTS
+func openTabs(browser playwright.Browser) int {
+	tabs := 0
+	for _, context := range browser.Contexts() {
+		tabs += len(context.Pages())
+	}
+	return tabs
+}
+
+// STOP WAITING AND CLOSE WHAT'S STILL OPEN, RETURNING HOW MANY WERE
+func (p *browserPool) close() int {
+	p.mu.Lock()
+	p.closed = true
+	p.notify()
+	browsers := make([]playwright.Browser, 0, len(p.open))
+	for browser := range p.open {
+		browsers = append(browsers, browser)
+	}
+	p.mu.Unlock()
+	for _, browser := range browsers {
+		browser.Close()
+	}
+	return len(browsers)
+}
+
+// WHEN A JOB'S BROWSER OR PAGE WAS LAST USED BY A TASK
+func (rm *ResourceManager) lastUsed(jobID, resourceID string) time.Time {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.touched[jobID][resourceID]
+}
+
+// THE POOL'S LIMITS, COUNTS AND BROWSERS, OLDEST FIRST
+func (e *Engine) BrowserPoolStats() BrowserPoolStats {
+	owners := e.resourceManager.browserOwners()
+	p := e.browsers
+	p.mu.Lock()
+	stats := BrowserPoolStats{
+		Limits:          poolLimits(e.cfg),
+		Launching:       p.launching,
+		WaitingBrowsers: p.waitingBrowsers,
+		WaitingTabs:     p.waitingTabs,
+		Browsers:        make([]PooledBrowser, 0, len(p.open)),
+	}
+	open := make(map[playwright.Browser]PooledBrowser, len(p.open))
+	for browser, pooled := range p.open {
+		open[browser] = *pooled
+	}
+	p.mu.Unlock()
+	stats.Launched = p.launched.Load()
+	stats.Waited = p.waited.Load()
+	stats.Recycled = p.recycled.Load()
+
+	now := time.Now()
+	for browser, pooled := range open {
+		pooled.Tabs = openTabs(browser)
+		pooled.Age = now.Sub(pooled.LaunchedAt).Milliseconds()
+		lastUsed := pooled.LaunchedAt
+		if owner, ok := owners[browser]; ok {
+			pooled.JobID, pooled.BrowserID = owner[0], owner[1]
+			if used := e.resourceManager.lastUsed(owner[0], owner[1]); used.After(lastUsed) {
+				lastUsed = used
+			}
+			_, pooled.Recyclable = e.resourceManager.recipe(owner[0], owner[1])
+		}
+		pooled.IdleFor = now.Sub(lastUsed).Milliseconds()
+		pooled.Idle = now.Sub(lastUsed) >= browserIdleAfter
+		if pooled.Idle {
+			stats.Idle++
+		} else {
+			stats.Active++
+		}
+		stats.Open++
+		stats.Tabs += pooled.Tabs
+		stats.Browsers = append(stats.Browsers, pooled)
+	}
+	sort.Slice(stats.Browsers, func(i, j int) bool { return stats.Browsers[i].LaunchedAt.Before(stats.Browsers[j].LaunchedAt) })
+	return stats
+}
+
+// CHANGE THE POOL'S LIMITS NOW; WAITING LAUNCHES AND PAGES ARE CHECKED AGAINST THEM RIGHT AWAY
+func (e *Engine) SetBrowserPoolLimits(limits BrowserPoolLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	e.browsers.mu.Lock()
+	e.cfg.MaxBrowsers = limits.MaxBrowsers
+	e.cfg.MaxBrowserTabs = limits.MaxBrowserTabs
+	e.cfg.BrowserLifetime = limits.BrowserLifetime
+	e.browsers.notify()
+	e.browsers.mu.Unlock()
+
+	// AN UNSET maxConcurrentJobs FOLLOWS maxBrowsers, SO QUEUED RUNS MAY START NOW
+	go e.dispatchQueue()
+	return nil
+}
+
+// CLOSE BROWSERS OLDER THAN THE LIFETIME THAT NO TASK HAS USED FOR A WHILE; THE NEXT TASK TO ASK
+// FOR ONE GETS A FRESH BROWSER (AND ITS PAGES REOPENED AT THE SAME URLS). BROWSERS THAT CAN'T BE
+// RELAUNCHED, LIKE A SITE ARCHIVE'S OWN, ARE LEFT TO THEIR TASK
+func (e *Engine) recycleAgedBrowsers() {
+	lifetime := time.Duration(e.cfg.BrowserLifetime) * time.Millisecond
+	if lifetime <= 0 {
+		return
+	}
+	for _, pooled := range e.BrowserPoolStats().Browsers {
+		if !pooled.Recyclable || !pooled.Idle || time.Duration(pooled.Age)*time.Millisecond < lifetime {
+			continue
+		}
+		resource, ok := e.resourceManager.GetResource(pooled.JobID, pooled.BrowserID)
+		browser, isBrowser := resource.(playwright.Browser)
+		if !ok || !isBrowser || !browser.IsConnected() {
+			continue
+		}
+		log.Printf("[JOB %s] BROWSER %s IS %v OLD, RECYCLING IT", pooled.JobID, pooled.BrowserID, (time.Duration(pooled.Age) * time.Millisecond).Round(time.Second))
+		e.captureSession(pooled.JobID, browser.Contexts()...)
+		browser.Close()
+		e.browsers.recycled.Add(1)
+		e.events.Publish("browser.recycled", pooled.JobID, map[string]any{"browserId": pooled.BrowserID, "reason": "lifetime", "age": pooled.Age})
+	}
+}
@@ -0,0 +1,268 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- RUN BUDGETS --
+//
+// HARD CAPS ON WHAT ONE RUN MAY DO: REQUESTS IN TOTAL AND PER HOST, RUNTIME AND BYTES DOWNLOADED.
+// DIRECT HTTP REQUESTS ARE COUNTED (AND REFUSED PAST A CAP) BY THE CLIENT, WHICH FINDS THE BUDGET
+// ON THE REQUEST'S CONTEXT; BROWSER REQUESTS ARE COUNTED AS THEIR PAGES MAKE THEM. THE FIRST CAP
+// REACHED CANCELS THE RUN, WHICH THEN ENDS AS budget_exceeded INSTEAD OF FAILING.
+
+// JOB RULE WITH THE RUN'S CAPS
+const budgetRule = "budget"
+
+// STATUS OF A RUN STOPPED BY ITS BUDGET
+const StatusBudgetExceeded = "budget_exceeded"
+
+// RETURNED FOR REQUESTS PAST A CAP (AND THE CAUSE OF THE RUN'S CANCELLATION)
+var ErrBudgetExceeded = errors.New("RUN BUDGET EXCEEDED")
+
+// BUDGET RULE, E.G. {"maxRequests": 5000, "maxRequestsPerHost": 500, "maxRuntime": 3600000, "maxBytes": 1073741824}.
+// ZERO LEAVES A CAP OFF
+type BudgetRule struct {
+	MaxRequests        int64   `json:"maxRequests"`
+	MaxRequestsPerHost int64   `json:"maxRequestsPerHost"`
+	MaxRuntime         float64 `json:"maxRuntime"` // MS
+	MaxBytes           int64   `json:"maxBytes"`
+}
+
+// WHAT A RUN HAS USED OF ITS BUDGET
+type BudgetUsage struct {
+	Requests int64      `json:"requests"`
+	Bytes    int64      `json:"bytes"`
+	Hosts    int        `json:"hosts"`
+	Limits   BudgetRule `json:"limits"`
+	Exceeded string     `json:"exceeded,omitempty"` // WHICH CAP ENDED THE RUN
+}
+
+// PARSE A budget RULE (NIL WHEN UNSET)
+func ParseBudgetRule(raw any) (*BudgetRule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var rule BudgetRule
+	if err := decoder.Decode(&rule); err != nil {
+		return nil, fmt.Errorf("BUDGET RULE MUST BE AN OBJECT: %v", err)
+	}
+	if rule.MaxRequests < 0 || rule.MaxRequestsPerHost < 0 || rule.MaxRuntime < 0 || rule.MaxBytes < 0 {
+		return nil, fmt.Errorf("BUDGET CAPS CAN'T BE NEGATIVE")
+	}
+	if rule == (BudgetRule{}) {
+		return nil, nil
+	}
+	return &rule, nil
+}
+
+// ONE RUN'S SPENDING AGAINST ITS RULE
+type runBudget struct {
+	rule     BudgetRule
+	requests atomic.Int64
+	bytes    atomic.Int64
+	mu       sync.Mutex
+	perHost  map[string]int64
+	exceeded string
+	cancel   context.CancelCauseFunc
+	timer    *time.Timer
+	onExceed func(reason, detail string)
+}
+
+type budgetKey struct{}
+
+// THE BUDGET OF THE RUN A CONTEXT BELONGS TO, IF IT HAS ONE
+func budgetFrom(ctx context.Context) *runBudget {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(budgetKey{}).(*runBudget)
+	return b
+}
+
+// START TRACKING A RUN'S BUDGET; THE RETURNED CONTEXT CARRIES IT AND IS CANCELLED WHEN A CAP IS HIT
+func (e *Engine) startBudget(ctx context.Context, jobID string, rule BudgetRule) context.Context {
+	ctx, cancel := context.WithCancelCause(ctx)
+	b := &runBudget{
+		rule:    rule,
+		perHost: make(map[string]int64),
+		cancel:  cancel,
+		onExceed: func(reason, detail string) {
+			log.Printf("[JOB %s] BUDGET EXCEEDED, ENDING RUN: %s", jobID, detail)
+			e.events.Publish("budget.exceeded", jobID, map[string]any{"cap": reason, "detail": detail})
+		},
+	}
+	if rule.MaxRuntime > 0 {
+		runtime := time.Duration(rule.MaxRuntime * float64(time.Millisecond))
+		b.timer = time.AfterFunc(runtime, func() {
+			b.exceed("maxRuntime", fmt.Sprintf("RAN FOR %v", runtime))
+		})
+	}
+	e.mu.Lock()
+	e.budgets[jobID] = b
+	e.mu.Unlock()
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// STOP TRACKING A RUN'S BUDGET AND RETURN WHAT IT USED (NIL WITHOUT A BUDGET). CALLER HOLDS e.mu
+func (e *Engine) endBudget(jobID string) *BudgetUsage {
+	b, ok := e.budgets[jobID]
+	if !ok {
+		return nil
+	}
+	delete(e.budgets, jobID)
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	usage := b.usage()
+	return &usage
+}
+
+// WHICH CAP ENDED A RUN ("" WHILE WITHIN BUDGET OR WITHOUT ONE)
+func (e *Engine) budgetExceeded(jobID string) string {
+	e.mu.Lock()
+	b, ok := e.budgets[jobID]
+	e.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded
+}
+
+// STOP THE RUN FOR A CAP (ONLY THE FIRST ONE COUNTS)
+func (b *runBudget) exceed(reason, detail string) {
+	b.mu.Lock()
+	if b.exceeded != "" {
+		b.mu.Unlock()
+		return
+	}
+	b.exceeded = reason
+	b.mu.Unlock()
+	b.onExceed(reason, detail)
+	b.cancel(fmt.Errorf("%w: %s", ErrBudgetExceeded, detail))
+}
+
+// COUNT A REQUEST TO A HOST, REFUSING IT PAST THE TOTAL OR PER-HOST CAP
+func (b *runBudget) request(host string) error {
+	b.mu.Lock()
+	if b.exceeded != "" {
+		b.mu.Unlock()
+		return fmt.Errorf("%w (%s)", ErrBudgetExceeded, b.exceeded)
+	}
+	b.perHost[host]++
+	hostCount := b.perHost[host]
+	b.mu.Unlock()
+
+	total := b.requests.Add(1)
+	if b.rule.MaxRequests > 0 && total > b.rule.MaxRequests {
+		b.exceed("maxRequests", fmt.Sprintf("MORE THAN %d REQUESTS", b.rule.MaxRequests))
+		return fmt.Errorf("%w (maxRequests)", ErrBudgetExceeded)
+	}
+	if b.rule.MaxRequestsPerHost > 0 && hostCount > b.rule.MaxRequestsPerHost {
+		b.exceed("maxRequestsPerHost", fmt.Sprintf("MORE THAN %d REQUESTS TO %s", b.rule.MaxRequestsPerHost, host))
+		return fmt.Errorf("%w (maxRequestsPerHost)", ErrBudgetExceeded)
+	}
+	return nil
+}
+
+// COUNT DOWNLOADED BYTES, FAILING ONCE THE CAP IS PASSED
+func (b *runBudget) received(n int64) error {
+	total := b.bytes.Add(n)
+	if b.rule.MaxBytes > 0 && total > b.rule.MaxBytes {
+		b.exceed("maxBytes", fmt.Sprintf("MORE THAN %d BYTES DOWNLOADED", b.rule.MaxBytes))
+		return fmt.Errorf("%w (maxBytes)", ErrBudgetExceeded)
+	}
+	return nil
+}
+
+func (b *runBudget) usage() BudgetUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BudgetUsage{
+		Requests: b.requests.Load(),
+		Bytes:    b.bytes.Load(),
+		Hosts:    len(b.perHost),
+		Limits:   b.rule,
+		Exceeded: b.exceeded,
+	}
+}
+
+// COUNT A BROWSER CONTEXT'S REQUESTS AND RESPONSE SIZES AGAINST THE JOB'S BUDGET
+func (e *Engine) watchBudget(jobID string, browserContext playwright.BrowserContext) {
+	e.mu.Lock()
+	b, ok := e.budgets[jobID]
+	e.mu.Unlock()
+	if !ok {
+		return
+	}
+	browserContext.OnRequest(func(request playwright.Request) {
+		if u, err := url.Parse(request.URL()); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			b.request(u.Hostname())
+		}
+	})
+	browserContext.OnResponse(func(response playwright.Response) {
+		// THE DECLARED LENGTH; READING EVERY BODY BACK OUT OF THE BROWSER WOULD COST MORE THAN IT SAVES
+		if size, err := strconv.ParseInt(response.Headers()["content-length"], 10, 64); err == nil && size > 0 {
+			b.received(size)
+		}
+	})
+}
+
+// TRANSPORT WRAPPER THAT CHARGES REQUESTS AND BODY BYTES TO THE BUDGET ON THE REQUEST'S CONTEXT
+type budgetTransport struct {
+	base http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := budgetFrom(req.Context())
+	if b == nil {
+		return t.base.RoundTrip(req)
+	}
+	if err := b.request(req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &budgetBody{ReadCloser: resp.Body, budget: b}
+	return resp, nil
+}
+
+// RESPONSE BODY THAT COUNTS WHAT IS READ FROM IT
+type budgetBody struct {
+	io.ReadCloser
+	budget *runBudget
+}
+
+func (r *budgetBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if budgetErr := r.budget.received(int64(n)); budgetErr != nil {
+			return n, budgetErr
+		}
+	}
+	return n, err
+}
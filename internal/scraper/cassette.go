@@ -0,0 +1,151 @@
+package scraper
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/fixtures"
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// -- CASSETTES --
+//
+// A JOB WITH A cassette RULE RECORDS EVERY RESPONSE IT GETS FROM THE NETWORK (HTTP CLIENT AND
+// BROWSER ALIKE) TO A HAR FILE, AND ON LATER RUNS ANSWERS THE SAME REQUESTS FROM THAT FILE, SO A
+// PIPELINE CAN BE DEVELOPED OFFLINE AND WITHOUT HAMMERING THE SITE. THE MODES ARE:
+//
+//	auto    REPLAY WHAT WAS RECORDED, FETCH AND RECORD ANYTHING NEW
+//	record  FETCH EVERYTHING AND REPLACE THE CASSETTE WITH THIS RUN'S RESPONSES
+//	replay  NEVER TOUCH THE NETWORK; REQUESTS THE CASSETTE DOESN'T HAVE FAIL
+//
+// CASSETTES ARE PLAIN HAR, SO ONE CAN ALSO BE DROPPED INTO A crepes test SUITE'S fixtures/.
+
+const cassetteRule = "cassette"
+
+const (
+	CassetteAuto   = "auto"
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// RETURNED FOR A REQUEST A replay CASSETTE HAS NO RESPONSE FOR
+var ErrCassetteMiss = errors.New("REQUEST IS NOT IN THE CASSETTE")
+
+// PARSE A cassette RULE INTO ITS MODE ("" WHEN UNSET OR false)
+func ParseCassetteRule(raw any) (string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil
+	case bool:
+		if !v {
+			return "", nil
+		}
+		return CassetteAuto, nil
+	case string:
+		switch v {
+		case "", "off":
+			return "", nil
+		case CassetteAuto, CassetteRecord, CassetteReplay:
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("CASSETTE RULE MUST BE auto, record, replay OR off")
+}
+
+// WHERE A JOB'S CASSETTE IS KEPT (UNDER THE DATA DIRECTORY, OUT OF STORAGE GARBAGE COLLECTION'S REACH)
+func CassettePath(cfg *config.Config, jobID string) string {
+	return filepath.Join(cfg.DataPath, "cassettes", cmp.Or(utils.SafeFilename(jobID), "_")+".har")
+}
+
+// A RUN'S CASSETTE
+type cassette struct {
+	jobID    string
+	mode     string
+	path     string
+	site     *fixtures.Site
+	recorded atomic.Int64
+	replayed atomic.Int64
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.mode != CassetteRecord {
+		if resp, ok := c.site.Replay(req); ok {
+			c.replayed.Add(1)
+			return resp, nil
+		}
+	}
+	if c.mode == CassetteReplay {
+		return nil, fmt.Errorf("%w: %s %s", ErrCassetteMiss, req.Method, req.URL)
+	}
+	return nil, ErrPlaybackMiss
+}
+
+func (c *cassette) Record(method, rawURL string, status int, header http.Header, body []byte) {
+	response := fixtures.Response{Method: method, URL: rawURL, Status: status, Header: header.Clone(), Body: body}
+	if err := c.site.Add(response); err != nil {
+		log.Printf("[JOB %s] NOT RECORDING %s %s: %v", c.jobID, method, rawURL, err)
+		return
+	}
+	c.recorded.Add(1)
+}
+
+// LOAD THE JOB'S CASSETTE AND ANSWER THE RUN'S REQUESTS FROM IT; THE RETURNED CONTEXT CARRIES IT.
+// A replay RUN WITHOUT A CASSETTE STILL STARTS, BUT EVERY REQUEST FAILS
+func (e *Engine) startCassette(ctx context.Context, jobID, mode string) (context.Context, error) {
+	c := &cassette{jobID: jobID, mode: mode, path: CassettePath(e.cfg, jobID), site: fixtures.NewSite()}
+	// ?page=2 MUST NEVER BE ANSWERED WITH PAGE 1
+	c.site.Strict = true
+
+	var err error
+	if mode != CassetteRecord {
+		if err = c.site.LoadHAR(c.path); errors.Is(err, os.ErrNotExist) {
+			err = nil
+			if mode == CassetteReplay {
+				err = fmt.Errorf("NO CASSETTE HAS BEEN RECORDED FOR THIS JOB")
+			}
+		}
+	}
+	log.Printf("[JOB %s] CASSETTE IN %s MODE WITH %d RECORDED RESPONSES", jobID, mode, c.site.Len())
+	return e.startPlayback(ctx, jobID, c), err
+}
+
+// SAVE WHAT THE RUN RECORDED. A RUN THAT RECORDED NOTHING LEAVES THE CASSETTE AS IT WAS
+func (e *Engine) endCassette(jobID string) {
+	c, ok := e.jobPlayback(jobID).(*cassette)
+	if !ok {
+		return
+	}
+	recorded, replayed := c.recorded.Load(), c.replayed.Load()
+	log.Printf("[JOB %s] CASSETTE: %d REPLAYED, %d RECORDED", jobID, replayed, recorded)
+	if recorded == 0 {
+		return
+	}
+	if err := c.site.SaveHAR(c.path, "Crepes"); err != nil {
+		log.Printf("[JOB %s] FAILED TO SAVE CASSETTE: %v", jobID, err)
+		e.addJobError(jobID, fmt.Sprintf("Cassette not saved: %v", err))
+		return
+	}
+	e.events.Publish("cassette.saved", jobID, map[string]any{
+		"mode":      c.mode,
+		"replayed":  replayed,
+		"recorded":  recorded,
+		"responses": c.site.Len(),
+	})
+}
+
+// DELETE A JOB'S CASSETTE SO ITS NEXT RUN RECORDS AFRESH (FALSE WHEN IT HAD NONE)
+func (e *Engine) ClearCassette(jobID string) (bool, error) {
+	err := os.Remove(CassettePath(e.cfg, jobID))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,585 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// -- CHANGE DETECTION --
+//
+// A watchPage TASK READS A PAGE'S VISIBLE TEXT (AND THE TEXT OF ANY NAMED SELECTORS), NORMALIZES
+// IT AND COMPARES IT WITH WHAT THE JOB SAW AT THAT URL LAST TIME. ONLY THE LATEST SNAPSHOT OF A
+// URL IS KEPT; EACH DIFFERENCE IS RECORDED AS A PAGE CHANGE (LINES ADDED, REMOVED OR REPLACED, AND
+// WATCHED SELECTORS WHOSE TEXT MOVED) AND ANNOUNCED AS A page.changed EVENT. A MONITOR JOB IS A
+// SCHEDULED PIPELINE OF THESE OVER A LIST OF URLS, SO PAGES CAN BE WATCHED FOR UPDATES INSTEAD OF
+// SCRAPED FOR ASSETS.
+
+// WHAT CHECKING A PAGE FOUND
+type SnapshotOutcome string
+
+const (
+	SnapshotNew       SnapshotOutcome = "new"       // FIRST CHECK OF THE URL, NOTHING TO COMPARE WITH
+	SnapshotUnchanged SnapshotOutcome = "unchanged" // SAME CONTENT AS LAST TIME
+	SnapshotChanged   SnapshotOutcome = "changed"   // DIFFERENT CONTENT, A CHANGE WAS RECORDED
+)
+
+// LINES KEPT PER LIST OF A CHANGE; THE REST ONLY SETS truncated
+const maxChangeLines = 200
+
+// LARGEST LINES-BEFORE TIMES LINES-AFTER LINED UP EXACTLY; BIGGER PAGES ARE COMPARED AS SETS OF LINES
+const maxDiffCells = 4_000_000
+
+// HOW OFTEN A MONITOR JOB CHECKS ITS PAGES WHEN NO SCHEDULE IS GIVEN
+const defaultMonitorSchedule = "0 * * * *"
+
+// WATCH PAGE TASK
+type WatchPageTask struct{}
+
+func (t *WatchPageTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"pageId":         "string",  // REQUIRED
+		"url":            "string?", // OPTIONAL (WHAT THE SNAPSHOT IS KEPT UNDER; DEFAULTS TO THE PAGE'S URL)
+		"scope":          "string?", // OPTIONAL (SELECTOR OF THE PART OF THE PAGE TO WATCH; DEFAULTS TO THE BODY)
+		"ignore":         "array?",  // OPTIONAL (SELECTORS OF ELEMENTS LEFT OUT, E.G. ADS OR CLOCKS)
+		"ignorePatterns": "array?",  // OPTIONAL (REGEXES BLANKED OUT OF THE TEXT, E.G. TIMESTAMPS)
+		"selectors":      "object?", // OPTIONAL (NAME -> SELECTOR WHOSE TEXT IS COMPARED ON ITS OWN)
+	}
+}
+
+func (t *WatchPageTask) GetOutputSchema() string {
+	return "object" // RETURNS {url, status, hash, changeId, added, removed, changed, selectors}
+}
+
+func (t *WatchPageTask) ValidateConfig(config map[string]any) error {
+	if _, ok := config["pageId"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	_, err := parseWatchConfig(config)
+	return err
+}
+
+// A watchPage CONFIG WITH ITS SELECTORS AND PATTERNS CHECKED
+type watchConfig struct {
+	scope     *selectorSpec
+	ignore    []selectorSpec
+	patterns  []*regexp.Regexp
+	selectors map[string]selectorSpec
+}
+
+func parseWatchConfig(config map[string]any) (watchConfig, error) {
+	var watch watchConfig
+	checked := func(selector string) (selectorSpec, error) {
+		if err := validateSelector(selector, ""); err != nil {
+			return selectorSpec{}, err
+		}
+		return parseSelector(selector, ""), nil
+	}
+	if scope, _ := config["scope"].(string); scope != "" {
+		spec, err := checked(scope)
+		if err != nil {
+			return watch, fmt.Errorf("SCOPE: %w", err)
+		}
+		watch.scope = &spec
+	}
+	if raw, ok := config["ignore"]; ok && raw != nil {
+		list, ok := raw.([]any)
+		if !ok {
+			return watch, fmt.Errorf("%w: ignore MUST BE A LIST OF SELECTORS", ErrInvalidInput)
+		}
+		for _, item := range list {
+			selector, _ := item.(string)
+			if selector == "" {
+				return watch, fmt.Errorf("%w: ignore MUST BE A LIST OF SELECTORS", ErrInvalidInput)
+			}
+			spec, err := checked(selector)
+			if err != nil {
+				return watch, fmt.Errorf("IGNORE: %w", err)
+			}
+			watch.ignore = append(watch.ignore, spec)
+		}
+	}
+	if raw, ok := config["ignorePatterns"]; ok && raw != nil {
+		list, ok := raw.([]any)
+		if !ok {
+			return watch, fmt.Errorf("%w: ignorePatterns MUST BE A LIST OF REGEXES", ErrInvalidInput)
+		}
+		for _, item := range list {
+			pattern, _ := item.(string)
+			re, err := regexp.Compile(pattern)
+			if pattern == "" || err != nil {
+				return watch, fmt.Errorf("%w: ignorePatterns HAS AN INVALID REGEX %q", ErrInvalidInput, pattern)
+			}
+			watch.patterns = append(watch.patterns, re)
+		}
+	}
+	if raw, ok := config["selectors"]; ok && raw != nil {
+		named, ok := raw.(map[string]any)
+		if !ok {
+			return watch, fmt.Errorf("%w: selectors MUST MAP NAMES TO SELECTORS", ErrInvalidInput)
+		}
+		watch.selectors = make(map[string]selectorSpec, len(named))
+		for name, value := range named {
+			selector, _ := value.(string)
+			if name == "" || selector == "" {
+				return watch, fmt.Errorf("%w: selectors MUST MAP NAMES TO SELECTORS", ErrInvalidInput)
+			}
+			spec, err := checked(selector)
+			if err != nil {
+				return watch, fmt.Errorf("SELECTOR %s: %w", name, err)
+			}
+			watch.selectors[name] = spec
+		}
+	}
+	return watch, nil
+}
+
+func (t *WatchPageTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	page, err := getPage(ctx, config["pageId"])
+	if err != nil {
+		return TaskData{}, err
+	}
+	watch, err := parseWatchConfig(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	pageURL, _ := config["url"].(string)
+	if pageURL == "" {
+		pageURL = page.URL()
+	}
+
+	jsSpec := func(spec selectorSpec) map[string]any {
+		return map[string]any{"selector": spec.Expr, "xpath": spec.xpath()}
+	}
+	var scope any
+	if watch.scope != nil {
+		scope = jsSpec(*watch.scope)
+	}
+	ignore := make([]any, len(watch.ignore))
+	for i, spec := range watch.ignore {
+		ignore[i] = jsSpec(spec)
+	}
+	named := make(map[string]any, len(watch.selectors))
+	for name, spec := range watch.selectors {
+		named[name] = jsSpec(spec)
+	}
+
+	// WATCHED SELECTORS ARE READ FIRST; IGNORED ELEMENTS ARE THEN HIDDEN WHILE THE TEXT IS READ SO
+	// innerText STILL LAYS IT OUT ONE BLOCK PER LINE
+	script := `([scope, ignore, named]) => {
+		const select = spec => {
+			if (!spec.xpath) return Array.from(document.querySelectorAll(spec.selector));
+			const found = document.evaluate(spec.selector, document, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
+			return Array.from({length: found.snapshotLength}, (_, i) => found.snapshotItem(i));
+		};
+		const textOf = el => (el.innerText !== undefined ? el.innerText : el.textContent) || '';
+		const values = {};
+		for (const [name, spec] of Object.entries(named)) {
+			values[name] = select(spec).map(el => textOf(el).trim()).join('\n');
+		}
+		const hidden = [];
+		for (const spec of ignore) {
+			for (const el of select(spec)) {
+				if (el.style) {
+					hidden.push([el, el.style.display]);
+					el.style.display = 'none';
+				}
+			}
+		}
+		try {
+			const roots = scope ? select(scope) : [document.body];
+			return {text: roots.filter(Boolean).map(textOf).join('\n'), values};
+		} finally {
+			for (const [el, display] of hidden) el.style.display = display;
+		}
+	}`
+	result, err := page.Evaluate(script, []any{scope, ignore, named})
+	if err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO READ PAGE: %v", err)
+	}
+	read, _ := result.(map[string]any)
+	text, _ := read["text"].(string)
+	values, _ := read["values"].(map[string]any)
+
+	selectors := models.JSONMap{}
+	for name := range watch.selectors {
+		value, _ := values[name].(string)
+		selectors[name] = strings.Join(normalizeSnapshotText(value, watch.patterns), "\n")
+	}
+	ctx.Engine.mu.Lock()
+	runID := ctx.Engine.jobProgress[ctx.JobID].RunID
+	ctx.Engine.mu.Unlock()
+	snapshot := NewPageSnapshot(ctx.JobID, runID, pageURL, normalizeSnapshotText(text, watch.patterns), selectors)
+
+	outcome, change, err := StorePageSnapshot(ctx.Engine.db, &snapshot)
+	if err != nil {
+		return TaskData{}, err
+	}
+	output := map[string]any{"url": pageURL, "status": string(outcome), "hash": snapshot.Hash}
+	if change != nil {
+		output["changeId"] = change.ID
+		output["added"] = len(change.Added)
+		output["removed"] = len(change.Removed)
+		output["changed"] = len(change.Changed)
+		output["selectors"] = len(change.Selectors)
+		ctx.Engine.events.Publish("page.changed", ctx.JobID, map[string]any{
+			"url":       pageURL,
+			"changeId":  change.ID,
+			"added":     len(change.Added),
+			"removed":   len(change.Removed),
+			"changed":   len(change.Changed),
+			"selectors": len(change.Selectors),
+		})
+	}
+	ctx.Logger.Printf("PAGE %s IS %s", pageURL, strings.ToUpper(string(outcome)))
+	return TaskData{Type: "object", Value: output}, nil
+}
+
+// VISIBLE TEXT AS LINES: WHITESPACE COLLAPSED, IGNORED PATTERNS BLANKED OUT, EMPTY LINES DROPPED
+func normalizeSnapshotText(text string, patterns []*regexp.Regexp) []string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		for _, pattern := range patterns {
+			line = pattern.ReplaceAllString(line, "")
+		}
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// A SNAPSHOT OF A PAGE AS CHECKED NOW
+func NewPageSnapshot(jobID, runID, pageURL string, lines []string, selectors models.JSONMap) models.PageSnapshot {
+	text := strings.Join(lines, "\n")
+	encoded, _ := json.Marshal(map[string]any{"text": text, "selectors": selectors})
+	sum := sha256.Sum256(encoded)
+	now := time.Now()
+	return models.PageSnapshot{
+		ID:        generateID("snapshot"),
+		JobID:     jobID,
+		URL:       pageURL,
+		RunID:     runID,
+		Hash:      hex.EncodeToString(sum[:]),
+		Text:      text,
+		Selectors: selectors,
+		CheckedAt: now,
+		ChangedAt: now,
+		CreatedAt: now,
+	}
+}
+
+// KEEP A PAGE'S SNAPSHOT, RECORDING HOW IT DIFFERS FROM THE ONE BEFORE. ON RETURN snapshot HOLDS
+// THE STORED ROW
+func StorePageSnapshot(db *gorm.DB, snapshot *models.PageSnapshot) (SnapshotOutcome, *models.PageChange, error) {
+	var outcome SnapshotOutcome
+	var change *models.PageChange
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing models.PageSnapshot
+		found := tx.Where(map[string]any{"job_id": snapshot.JobID, "url": snapshot.URL}).Limit(1).Find(&existing)
+		if found.Error != nil {
+			return found.Error
+		}
+		if found.RowsAffected == 0 {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(snapshot)
+			if result.Error != nil {
+				return result.Error
+			}
+			outcome = SnapshotNew
+			if result.RowsAffected == 0 {
+				outcome = SnapshotUnchanged // A CONCURRENT CHECK GOT THERE FIRST
+			}
+			return nil
+		}
+
+		updates := map[string]any{"checked_at": snapshot.CheckedAt, "run_id": snapshot.RunID}
+		outcome = SnapshotUnchanged
+		if existing.Hash != snapshot.Hash {
+			change = diffSnapshots(existing, *snapshot)
+			if err := tx.Create(change).Error; err != nil {
+				return err
+			}
+			updates["text"] = snapshot.Text
+			updates["selectors"] = snapshot.Selectors
+			updates["hash"] = snapshot.Hash
+			updates["changed_at"] = snapshot.CheckedAt
+			updates["changes"] = gorm.Expr("changes + 1")
+			outcome = SnapshotChanged
+		}
+		if err := tx.Model(&existing).Updates(updates).Error; err != nil {
+			return err
+		}
+		*snapshot = models.PageSnapshot{}
+		return tx.First(snapshot, "id = ?", existing.ID).Error
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("FAILED TO SAVE PAGE SNAPSHOT: %v", err)
+	}
+	return outcome, change, nil
+}
+
+// THE CHANGE FROM ONE SNAPSHOT OF A PAGE TO THE NEXT
+func diffSnapshots(before, after models.PageSnapshot) *models.PageChange {
+	added, removed, changed := diffLines(splitSnapshotText(before.Text), splitSnapshotText(after.Text))
+	change := &models.PageChange{
+		ID:        generateID("pagechange"),
+		JobID:     after.JobID,
+		RunID:     after.RunID,
+		URL:       after.URL,
+		Added:     models.JSONArray{},
+		Removed:   models.JSONArray{},
+		Changed:   models.JSONArray{},
+		Selectors: models.JSONArray{},
+		FromHash:  before.Hash,
+		ToHash:    after.Hash,
+		CreatedAt: after.CheckedAt,
+	}
+	for i, line := range added {
+		if i == maxChangeLines {
+			change.Truncated = true
+			break
+		}
+		change.Added = append(change.Added, line)
+	}
+	for i, line := range removed {
+		if i == maxChangeLines {
+			change.Truncated = true
+			break
+		}
+		change.Removed = append(change.Removed, line)
+	}
+	for i, pair := range changed {
+		if i == maxChangeLines {
+			change.Truncated = true
+			break
+		}
+		change.Changed = append(change.Changed, map[string]any{"from": pair[0], "to": pair[1]})
+	}
+
+	names := make([]string, 0, len(before.Selectors)+len(after.Selectors))
+	for name := range before.Selectors {
+		names = append(names, name)
+	}
+	for name := range after.Selectors {
+		if _, seen := before.Selectors[name]; !seen {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		from, to := before.Selectors[name], after.Selectors[name]
+		if from != to {
+			change.Selectors = append(change.Selectors, map[string]any{"name": name, "from": from, "to": to})
+		}
+	}
+	return change
+}
+
+func splitSnapshotText(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// LINE DIFF: LINES ONLY IN after, LINES ONLY IN before, AND [from, to] PAIRS WHERE A RUN OF
+// REMOVED LINES WAS REPLACED BY A RUN OF ADDED ONES. PAGES TOO BIG TO LINE UP ARE COMPARED AS SETS
+func diffLines(before, after []string) (added, removed []string, changed [][2]string) {
+	// LINES SHARED AT THE START AND END DON'T NEED LINING UP
+	for len(before) > 0 && len(after) > 0 && before[0] == after[0] {
+		before, after = before[1:], after[1:]
+	}
+	for len(before) > 0 && len(after) > 0 && before[len(before)-1] == after[len(after)-1] {
+		before, after = before[:len(before)-1], after[:len(after)-1]
+	}
+	if len(before)*len(after) > maxDiffCells {
+		counts := make(map[string]int, len(before))
+		for _, line := range before {
+			counts[line]++
+		}
+		for _, line := range after {
+			if counts[line] > 0 {
+				counts[line]--
+			} else {
+				added = append(added, line)
+			}
+		}
+		for _, line := range slices.Backward(before) {
+			if counts[line] > 0 {
+				counts[line]--
+				removed = append(removed, line)
+			}
+		}
+		slices.Reverse(removed)
+		return added, removed, nil
+	}
+
+	// LONGEST COMMON SUBSEQUENCE, THEN A WALK THAT GROUPS EACH RUN OF EDITS
+	n, m := len(before), len(after)
+	common := make([][]int32, n+1)
+	for i := range common {
+		common[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+	var gone, came []string
+	flush := func() {
+		pairs := min(len(gone), len(came))
+		for k := 0; k < pairs; k++ {
+			changed = append(changed, [2]string{gone[k], came[k]})
+		}
+		removed = append(removed, gone[pairs:]...)
+		added = append(added, came[pairs:]...)
+		gone, came = gone[:0], came[:0]
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && before[i] == after[j]:
+			flush()
+			i, j = i+1, j+1
+		case j < m && (i == n || common[i][j+1] >= common[i+1][j]):
+			came = append(came, after[j])
+			j++
+		default:
+			gone = append(gone, before[i])
+			i++
+		}
+	}
+	flush()
+	return added, removed, changed
+}
+
+// WHAT A MONITOR JOB IS MADE FOR
+type MonitorJobOptions struct {
+	Name           string
+	URLs           []string
+	Schedule       string            // CRON, DEFAULTS TO HOURLY
+	Scope          string            // SEE watchPage
+	Ignore         []string          // SEE watchPage
+	IgnorePatterns []string          // SEE watchPage
+	Selectors      map[string]string // SEE watchPage
+	Concurrency    int               // PAGES CHECKED AT ONCE, DEFAULTS TO 2
+	WaitUntil      string            // load, domcontentloaded OR networkidle
+}
+
+// A MONITOR JOB DRAFT (NOT SAVED, NO ID): ON ITS SCHEDULE IT LOADS EACH URL AND RECORDS WHAT
+// CHANGED SINCE THE LAST CHECK
+func BuildMonitorJob(options MonitorJobOptions) (models.Job, error) {
+	urls := []any{}
+	seen := map[string]bool{}
+	for _, raw := range options.URLs {
+		target, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return models.Job{}, fmt.Errorf("%w: %q ISN'T AN http(s) URL", ErrInvalidInput, raw)
+		}
+		if !seen[target.String()] {
+			seen[target.String()] = true
+			urls = append(urls, target.String())
+		}
+	}
+	if len(urls) == 0 {
+		return models.Job{}, fmt.Errorf("%w: A MONITOR NEEDS AT LEAST ONE URL", ErrInvalidInput)
+	}
+	waitUntil := options.WaitUntil
+	if waitUntil == "" {
+		waitUntil = "load"
+	}
+	if waitUntil != "load" && waitUntil != "domcontentloaded" && waitUntil != "networkidle" {
+		return models.Job{}, fmt.Errorf("%w: waitUntil MUST BE load, domcontentloaded OR networkidle", ErrInvalidInput)
+	}
+
+	watch := map[string]any{}
+	if options.Scope != "" {
+		watch["scope"] = options.Scope
+	}
+	if len(options.Ignore) > 0 {
+		watch["ignore"] = options.Ignore
+	}
+	if len(options.IgnorePatterns) > 0 {
+		watch["ignorePatterns"] = options.IgnorePatterns
+	}
+	if len(options.Selectors) > 0 {
+		watch["selectors"] = options.Selectors
+	}
+	// ROUND-TRIP THROUGH JSON SO THE CONFIG IS CHECKED AS THE PIPELINE WILL LOAD IT
+	raw, _ := json.Marshal(watch)
+	watch = map[string]any{}
+	json.Unmarshal(raw, &watch)
+	if _, err := parseWatchConfig(watch); err != nil {
+		return models.Job{}, err
+	}
+
+	browser := presetTask("Open Browser", "createBrowser", map[string]any{"headless": true})
+	list := presetTask("Pages To Check", "sliceItems", map[string]any{"items": urls})
+	page := presetTask("Open Page", "createPage", map[string]any{"browserId": browser.ID})
+	load := presetTask("Load Page", "navigate", map[string]any{"pageId": page.ID, "waitUntil": waitUntil})
+	load.InputRefs = []string{list.ID}
+	load.RetryConfig = models.RetryConfig{MaxRetries: 2, DelayMS: 2000}
+	watch["pageId"] = page.ID
+	check := presetTask("Compare With Last Check", "watchPage", watch)
+	closePage := presetTask("Close Page", "disposePage", map[string]any{"pageId": page.ID})
+
+	workers := options.Concurrency
+	if workers <= 0 {
+		workers = 2
+	}
+	checks := presetStage("Check Pages", "Load each page and record what changed since the last check", "for-each", workers, []models.Task{page, load, check, closePage})
+	checks.Config = map[string]any{"items": list.ID}
+	stages := []models.Stage{
+		presetStage("Setup", "Open a browser", "sequential", 1, []models.Task{browser, list}),
+		checks,
+		presetStage("Cleanup", "Close the browser", "sequential", 1, []models.Task{
+			presetTask("Close Browser", "disposeBrowser", map[string]any{"browserId": browser.ID}),
+		}),
+	}
+	pipeline, err := json.Marshal(stages)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	first, _ := url.Parse(urls[0].(string))
+	name := options.Name
+	if name == "" {
+		name = "Monitor: " + first.Host
+	}
+	schedule := options.Schedule
+	if schedule == "" {
+		schedule = defaultMonitorSchedule
+	}
+	job := models.Job{
+		Name:        name,
+		BaseURL:     first.String(),
+		Description: fmt.Sprintf("Watches %d page(s) for changes", len(urls)),
+		Status:      "idle",
+		Schedule:    schedule,
+		Selectors:   models.JSONArray{},
+		Filters:     models.JSONArray{},
+		Rules:       models.JSONMap{},
+		Processing:  models.JSONMap{"thumbnails": false},
+		Tags:        models.JSONArray{"monitor"},
+		Pipeline:    string(pipeline),
+	}
+	encoded, _ := json.Marshal(job)
+	var draft models.Job
+	json.Unmarshal(encoded, &draft)
+	return draft, nil
+}
@@ -0,0 +1,247 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// -- COMPLIANCE METADATA AND RETENTION --
+//
+// A JOB CAN CARRY AN AUDIT TRAIL FOR WHAT IT COLLECTS: WHY (purpose), WHAT KIND OF DATA
+// (dataCategory), WHETHER SOMEONE REVIEWED THE SITE'S TERMS, AND HOW LONG THE DATA MAY BE KEPT.
+// EXPORTS CARRY THE METADATA WITH EACH RECORD, AND THE RETENTION REAPER DELETES RECORDS,
+// ASSETS AND ERROR CAPTURES OLDER THAN THE RETENTION PERIOD.
+
+// JOB RULE WITH THE COMPLIANCE METADATA
+const complianceRule = "compliance"
+
+// HOW OFTEN THE REAPER LOOKS FOR DATA PAST ITS RETENTION
+const retentionSweepInterval = time.Hour
+
+// ACCEPTED dataCategory VALUES
+var complianceCategories = []string{"public", "personal", "sensitive", "proprietary", "internal"}
+
+// COMPLIANCE RULE, E.G. {"purpose": "price monitoring", "dataCategory": "public", "retentionDays": 90,
+// "tosReviewed": true, "tosReviewedBy": "legal@example.com", "tosReviewedAt": "2026-01-12T00:00:00Z"}.
+// retentionDays 0 KEEPS DATA UNTIL IT IS DELETED BY HAND
+type Compliance struct {
+	Purpose       string     `json:"purpose"`
+	DataCategory  string     `json:"dataCategory"`
+	RetentionDays int        `json:"retentionDays"`
+	TosReviewed   bool       `json:"tosReviewed"`
+	TosReviewedBy string     `json:"tosReviewedBy,omitempty"`
+	TosReviewedAt *time.Time `json:"tosReviewedAt,omitempty"`
+	Notes         string     `json:"notes,omitempty"`
+}
+
+// PARSE A compliance RULE (NIL WHEN UNSET)
+func ParseCompliance(raw any) (*Compliance, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var c Compliance
+	if err := decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("COMPLIANCE RULE MUST BE AN OBJECT: %v", err)
+	}
+	if c.DataCategory != "" && !slices.Contains(complianceCategories, c.DataCategory) {
+		return nil, fmt.Errorf("UNKNOWN DATA CATEGORY %q (EXPECTED %s)", c.DataCategory, strings.Join(complianceCategories, ", "))
+	}
+	if c.RetentionDays < 0 {
+		return nil, fmt.Errorf("RETENTION DAYS CAN'T BE NEGATIVE")
+	}
+	if !c.TosReviewed && (c.TosReviewedBy != "" || c.TosReviewedAt != nil) {
+		return nil, fmt.Errorf("TOS REVIEWER AND DATE NEED tosReviewed")
+	}
+	return &c, nil
+}
+
+// A JOB'S COMPLIANCE METADATA (NIL WHEN UNSET OR INVALID)
+func JobCompliance(job *models.Job) *Compliance {
+	c, err := ParseCompliance(job.Rules[complianceRule])
+	if err != nil {
+		return nil
+	}
+	return c
+}
+
+// WHEN DATA LAST COLLECTED AT A TIME FALLS OUT OF RETENTION (ZERO WHEN KEPT INDEFINITELY)
+func (c *Compliance) RetainUntil(collected time.Time) time.Time {
+	if c == nil || c.RetentionDays == 0 {
+		return time.Time{}
+	}
+	return collected.AddDate(0, 0, c.RetentionDays)
+}
+
+// WHAT ONE REAPER PASS DELETED FOR A JOB
+type retentionReport struct {
+	Records    int64 `json:"records"`
+	Assets     int64 `json:"assets"`
+	ErrorLogs  int64 `json:"errorLogs"`
+	FreedBytes int64 `json:"freedBytes"`
+}
+
+// DELETE EVERY JOB'S DATA THAT IS PAST ITS RETENTION PERIOD. RUNNING JOBS ARE LEFT FOR THE NEXT PASS
+func (e *Engine) reapRetention() {
+	var jobs []models.Job
+	if err := e.db.Select("id", "rules").Find(&jobs).Error; err != nil {
+		log.Printf("RETENTION: FAILED TO LOAD JOBS: %v", err)
+		return
+	}
+	for i := range jobs {
+		c := JobCompliance(&jobs[i])
+		if c == nil || c.RetentionDays == 0 {
+			continue
+		}
+		e.mu.Lock()
+		_, running := e.runningJobs[jobs[i].ID]
+		e.mu.Unlock()
+		if running {
+			continue
+		}
+		cutoff := time.Now().AddDate(0, 0, -c.RetentionDays)
+		report, err := e.reapJob(jobs[i].ID, cutoff)
+		if err != nil {
+			log.Printf("[JOB %s] RETENTION: FAILED TO DELETE EXPIRED DATA: %v", jobs[i].ID, err)
+			continue
+		}
+		if report.Records+report.Assets+report.ErrorLogs == 0 {
+			continue
+		}
+		log.Printf("[JOB %s] RETENTION: DELETED %d RECORDS, %d ASSETS AND %d ERROR LOGS OLDER THAN %d DAYS",
+			jobs[i].ID, report.Records, report.Assets, report.ErrorLogs, c.RetentionDays)
+		e.events.Publish("retention.reaped", jobs[i].ID, map[string]any{
+			"retentionDays": c.RetentionDays,
+			"cutoff":        cutoff,
+			"report":        report,
+		})
+	}
+}
+
+// DELETE A JOB'S RECORDS NOT SEEN SINCE THE CUTOFF, AND ASSETS AND ERROR LOGS CREATED BEFORE IT
+func (e *Engine) reapJob(jobID string, cutoff time.Time) (retentionReport, error) {
+	var report retentionReport
+
+	// RECORDS AND THEIR HISTORY
+	var recordIDs []string
+	if err := e.db.Model(&models.Record{}).Where("job_id = ? AND last_seen < ?", jobID, cutoff).Pluck("id", &recordIDs).Error; err != nil {
+		return report, err
+	}
+	for batch := range slices.Chunk(recordIDs, 500) {
+		if err := e.db.Where("record_id IN ?", batch).Delete(&models.RecordChange{}).Error; err != nil {
+			return report, err
+		}
+		if err := e.db.Where("record_id IN ?", batch).Delete(&models.RecordAlert{}).Error; err != nil {
+			return report, err
+		}
+		result := e.db.Where("id IN ?", batch).Delete(&models.Record{})
+		if result.Error != nil {
+			return report, result.Error
+		}
+		report.Records += result.RowsAffected
+	}
+	if err := e.db.Where("job_id = ? AND created_at < ?", jobID, cutoff).Delete(&models.RecordRejection{}).Error; err != nil {
+		return report, err
+	}
+	if err := e.db.Where("job_id = ? AND created_at < ?", jobID, cutoff).Delete(&models.ItemResult{}).Error; err != nil {
+		return report, err
+	}
+	if err := e.db.Where("job_id = ? AND created_at < ?", jobID, cutoff).Delete(&models.RunEvent{}).Error; err != nil {
+		return report, err
+	}
+
+	// CHANGES OF WATCHED PAGES, AND SNAPSHOTS OF PAGES NO LONGER CHECKED
+	if err := e.db.Where("job_id = ? AND created_at < ?", jobID, cutoff).Delete(&models.PageChange{}).Error; err != nil {
+		return report, err
+	}
+	if err := e.db.Where("job_id = ? AND checked_at < ?", jobID, cutoff).Delete(&models.PageSnapshot{}).Error; err != nil {
+		return report, err
+	}
+
+	// ASSETS AND THEIR FILES
+	var assets []models.Asset
+	if err := e.db.Select("id", "local_path", "thumbnail_path", "size", "storage", "storage_status").Where("job_id = ? AND created_at < ?", jobID, cutoff).Find(&assets).Error; err != nil {
+		return report, err
+	}
+	for _, asset := range assets {
+		report.FreedBytes += e.uploads.Remove(&asset)
+		report.FreedBytes += removeRetainedFile(e.cfg.StoragePath, asset.LocalPath)
+		report.FreedBytes += removeRetainedFile(e.cfg.ThumbnailsPath, asset.ThumbnailPath)
+		result := e.db.Delete(&models.Asset{}, "id = ?", asset.ID)
+		if result.Error != nil {
+			return report, result.Error
+		}
+		if err := e.db.Delete(&models.AssetText{}, "asset_id = ?", asset.ID).Error; err != nil {
+			return report, err
+		}
+		report.Assets += result.RowsAffected
+	}
+
+	// ERROR LOGS HOLD PAGE CAPTURES (SCREENSHOTS, SNAPSHOTS, HTML) OF THE SCRAPED SITE TOO
+	var errorLogs []models.ErrorLog
+	if err := e.db.Select("id", "screenshot", "snapshot", "har").Where("job_id = ? AND created_at < ?", jobID, cutoff).Find(&errorLogs).Error; err != nil {
+		return report, err
+	}
+	for _, entry := range errorLogs {
+		for _, capture := range []string{entry.Screenshot, entry.Snapshot, entry.HAR} {
+			report.FreedBytes += removeRetainedFile(e.cfg.StoragePath, capture)
+		}
+		result := e.db.Delete(&models.ErrorLog{}, "id = ?", entry.ID)
+		if result.Error != nil {
+			return report, result.Error
+		}
+		report.ErrorLogs += result.RowsAffected
+	}
+	return report, nil
+}
+
+// REMOVE A FILE STORED UNDER A ROOT, RETURNING THE BYTES FREED
+func removeRetainedFile(root, name string) int64 {
+	if name == "" {
+		return 0
+	}
+	full, err := utils.ConfinePath(root, name)
+	if err != nil {
+		return 0
+	}
+	info, err := os.Stat(full)
+	if err != nil || info.IsDir() {
+		return 0
+	}
+	if err := os.Remove(full); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("RETENTION: FAILED TO DELETE %s: %v", full, err)
+		return 0
+	}
+	return info.Size()
+}
+
+// REAP EXPIRED DATA ON START AND THEN EVERY SWEEP INTERVAL UNTIL THE ENGINE CLOSES
+func (e *Engine) retentionLoop() {
+	e.reapRetention()
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.queueStop:
+			return
+		case <-ticker.C:
+			e.reapRetention()
+		}
+	}
+}
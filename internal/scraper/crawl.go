@@ -0,0 +1,276 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// -- CRAWL FRONTIER --
+//
+// URLS WAITING TO BE CRAWLED ARE QUEUED PER HOST. WORKERS TAKE FROM THE HOSTS IN TURN SO ONE
+// BIG SITE CAN'T STARVE THE OTHERS, AND A HOST ISN'T ASKED AGAIN UNTIL ITS DELAY HAS PASSED.
+// WITHIN A HOST THE STRATEGY PICKS THE NEXT URL: bfs (SHALLOWEST FIRST), dfs (NEWEST FIRST) OR
+// priority (FIRST MATCHING PRIORITY PATTERN FIRST, THEN SHALLOWEST).
+
+// CRAWL STRATEGIES
+const (
+	CrawlBreadthFirst = "bfs"
+	CrawlDepthFirst   = "dfs"
+	CrawlPriority     = "priority"
+)
+
+// URL WAITING IN THE FRONTIER
+type crawlTarget struct {
+	url      string
+	depth    int
+	priority int // INDEX OF THE FIRST MATCHING PRIORITY PATTERN (LOWER GOES FIRST)
+	seq      int // ORDER ADDED, TO BREAK TIES
+}
+
+// URLS WAITING FOR ONE HOST AND WHEN IT MAY BE ASKED AGAIN
+type hostQueue struct {
+	targets []crawlTarget
+	readyAt time.Time
+}
+
+type crawlFrontier struct {
+	mu       sync.Mutex
+	strategy string
+	patterns []*regexp.Regexp
+	delay    time.Duration // BETWEEN REQUESTS TO ONE HOST
+	limit    int           // URLS HANDED OUT IN TOTAL
+	hosts    map[string]*hostQueue
+	order    []string // HOSTS IN THE ORDER THEY WERE FIRST SEEN
+	cursor   int      // NEXT HOST TO TRY
+	seen     map[string]bool
+	pending  int
+	inFlight int
+	claimed  int
+	seq      int
+	stopped  bool          // SET BY Stop; NOTHING MORE IS HANDED OUT
+	wake     chan struct{} // CLOSED (AND REPLACED) TO WAKE EVERY WAITING WORKER
+}
+
+// CHECK A STRATEGY NAME AND COMPILE PRIORITY PATTERNS
+func parseCrawlOptions(config map[string]any) (string, []*regexp.Regexp, error) {
+	strategy, _ := config["strategy"].(string)
+	switch strategy {
+	case "":
+		strategy = CrawlBreadthFirst
+	case CrawlBreadthFirst, CrawlDepthFirst, CrawlPriority:
+	default:
+		return "", nil, fmt.Errorf("%w: strategy MUST BE bfs, dfs OR priority", ErrInvalidInput)
+	}
+	patterns, err := compilePatterns(config, "priorityPatterns")
+	if err != nil {
+		return "", nil, err
+	}
+	return strategy, patterns, nil
+}
+
+// COMPILE A LIST OF REGEXES FROM THE TASK CONFIG
+func compilePatterns(config map[string]any, key string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	raw, _ := config[key].([]any)
+	for _, p := range raw {
+		pattern, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s MUST BE STRINGS", ErrInvalidInput, key)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: INVALID %s PATTERN %q: %v", ErrInvalidInput, key, pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// WHICH URLS A CRAWL MAY QUEUE: ONE MATCHING AN include PATTERN (WHEN THERE ARE ANY) AND NO exclude
+// PATTERN. APPLIES TO FOLLOWED LINKS AND SITEMAP URLS ALIKE, NOT TO THE START PAGE
+type crawlFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func parseCrawlFilter(config map[string]any) (crawlFilter, error) {
+	include, err := compilePatterns(config, "include")
+	if err != nil {
+		return crawlFilter{}, err
+	}
+	exclude, err := compilePatterns(config, "exclude")
+	if err != nil {
+		return crawlFilter{}, err
+	}
+	return crawlFilter{include: include, exclude: exclude}, nil
+}
+
+func (f crawlFilter) allows(rawURL string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(rawURL) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+func newCrawlFrontier(strategy string, patterns []*regexp.Regexp, delay time.Duration, limit int) *crawlFrontier {
+	return &crawlFrontier{
+		strategy: strategy,
+		patterns: patterns,
+		delay:    delay,
+		limit:    limit,
+		hosts:    make(map[string]*hostQueue),
+		seen:     make(map[string]bool),
+		wake:     make(chan struct{}),
+	}
+}
+
+// QUEUE A URL UNLESS IT WAS QUEUED BEFORE; REPORTS WHETHER IT WAS ADDED
+func (f *crawlFrontier) Push(rawURL string, depth int) bool {
+	key := normalizeArchiveURL(rawURL)
+	host := downloadHost(rawURL)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.seen[key] {
+		return false
+	}
+	f.seen[key] = true
+
+	target := crawlTarget{url: rawURL, depth: depth, priority: len(f.patterns), seq: f.seq}
+	f.seq++
+	for i, re := range f.patterns {
+		if re.MatchString(rawURL) {
+			target.priority = i
+			break
+		}
+	}
+
+	q, ok := f.hosts[host]
+	if !ok {
+		q = &hostQueue{}
+		f.hosts[host] = q
+		f.order = append(f.order, host)
+	}
+	// KEEP EACH HOST'S QUEUE SORTED SO THE NEXT URL IS ALWAYS AT THE FRONT
+	i := sort.Search(len(q.targets), func(i int) bool { return f.before(target, q.targets[i]) })
+	q.targets = append(q.targets, crawlTarget{})
+	copy(q.targets[i+1:], q.targets[i:])
+	q.targets[i] = target
+	f.pending++
+	f.signal()
+	return true
+}
+
+// WHETHER a IS CRAWLED BEFORE b
+func (f *crawlFrontier) before(a, b crawlTarget) bool {
+	switch f.strategy {
+	case CrawlDepthFirst:
+		return a.seq > b.seq
+	case CrawlPriority:
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+	}
+	if a.depth != b.depth {
+		return a.depth < b.depth
+	}
+	return a.seq < b.seq
+}
+
+// TAKE THE NEXT URL, WAITING FOR A HOST'S DELAY OR FOR WORKERS TO FIND MORE LINKS. FALSE ONCE
+// THE FRONTIER IS EMPTY WITH NOTHING IN FLIGHT, THE LIMIT IS REACHED, IT WAS STOPPED OR CTX ENDS
+func (f *crawlFrontier) Next(ctx context.Context) (crawlTarget, bool) {
+	for {
+		f.mu.Lock()
+		if f.stopped || f.claimed >= f.limit || (f.pending == 0 && f.inFlight == 0) {
+			f.signal() // LET OTHER WAITING WORKERS SEE IT TOO
+			f.mu.Unlock()
+			return crawlTarget{}, false
+		}
+		now := time.Now()
+		var wait time.Duration
+		for n := 0; n < len(f.order); n++ {
+			host := f.order[(f.cursor+n)%len(f.order)]
+			q := f.hosts[host]
+			if len(q.targets) == 0 {
+				continue
+			}
+			if until := q.readyAt.Sub(now); until > 0 {
+				if wait == 0 || until < wait {
+					wait = until
+				}
+				continue
+			}
+			target := q.targets[0]
+			q.targets = q.targets[1:]
+			q.readyAt = now.Add(f.delay)
+			f.cursor = (f.cursor + n + 1) % len(f.order)
+			f.pending--
+			f.inFlight++
+			f.claimed++
+			f.mu.Unlock()
+			return target, true
+		}
+		wake := f.wake
+		f.mu.Unlock()
+
+		// NOTHING READY: WAIT FOR A HOST'S DELAY, NEW LINKS OR A FINISHED PAGE
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return crawlTarget{}, false
+		case <-wake:
+		case <-timer:
+		}
+	}
+}
+
+// MARK A URL FROM Next AS DONE
+func (f *crawlFrontier) Done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight--
+	f.signal()
+}
+
+// HAND OUT NOTHING MORE; URLS ALREADY HANDED OUT STILL FINISH. FALSE IF IT WAS ALREADY STOPPED
+func (f *crawlFrontier) Stop() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		return false
+	}
+	f.stopped = true
+	f.signal()
+	return true
+}
+
+// URLS STILL QUEUED
+func (f *crawlFrontier) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pending
+}
+
+// WAKE EVERY WORKER WAITING IN Next (CALLER HOLDS f.mu)
+func (f *crawlFrontier) signal() {
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
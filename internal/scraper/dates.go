@@ -0,0 +1,471 @@
+package scraper
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// -- DATE TASKS --
+//
+// parseDate, formatDate, offsetDate, compareDates AND filterByDate LET A PIPELINE DO TIME MATH
+// WITHOUT A SCRIPT, E.G. "ONLY KEEP ITEMS NEWER THAN now-7d". A DATE IS TEXT IN ONE OF THE formats
+// (THE date TRANSFORM'S DEFAULTS UNLESS GIVEN), UNIX SECONDS (OR MILLISECONDS), OR A RELATIVE
+// EXPRESSION: now, today, yesterday OR tomorrow, OPTIONALLY FOLLOWED BY OFFSETS ("now-7d",
+// "today+1mo-1d"), OR JUST OFFSETS FROM NOW ("-36h"). timezone IS WHERE ZONE-LESS DATES, today
+// AND CALENDAR OFFSETS (d, w, mo, y) ARE RECKONED, SO +1d ACROSS A DST CHANGE KEEPS THE WALL TIME.
+
+// ONE OFFSET TERM: SIGN, AMOUNT, UNIT
+var dateOffsetTerm = regexp.MustCompile(`([+-]?)\s*(\d+)\s*(ms|mo|y|w|d|h|m|s)`)
+
+// A WHOLE OFFSET EXPRESSION, E.G. "-7d" OR "+1mo-2d 3h"
+var dateOffsetExpr = regexp.MustCompile(`^(?:\s*[+-]?\s*\d+\s*(?:ms|mo|y|w|d|h|m|s))+\s*$`)
+
+// NAMED OUTPUT FORMATS, BESIDES GO LAYOUTS
+var dateFormatPresets = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"date":     time.DateOnly,
+	"datetime": time.DateTime,
+	"time":     time.TimeOnly,
+	"rfc1123":  time.RFC1123,
+}
+
+// HOW DATES ARE READ AND WHERE THEY ARE RECKONED
+type dateOptions struct {
+	formats  []string
+	location *time.Location
+	now      time.Time
+}
+
+// THE formats AND timezone INPUTS
+func parseDateOptions(config map[string]any) (dateOptions, error) {
+	opts := dateOptions{formats: defaultDateFormats, location: time.UTC, now: time.Now()}
+	if raw, ok := config["formats"].([]any); ok && len(raw) > 0 {
+		opts.formats = make([]string, 0, len(raw))
+		for _, f := range raw {
+			layout, ok := f.(string)
+			if !ok || layout == "" {
+				return opts, fmt.Errorf("FORMATS MUST BE A LIST OF LAYOUTS")
+			}
+			opts.formats = append(opts.formats, layout)
+		}
+	}
+	if tz, ok := config["timezone"].(string); ok && tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return opts, fmt.Errorf("UNKNOWN TIMEZONE %q", tz)
+		}
+		opts.location = location
+	}
+	opts.now = opts.now.In(opts.location)
+	return opts, nil
+}
+
+// READ ONE DATE
+func (o dateOptions) parse(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case float64:
+		// PAST THE YEAR 33658 AS SECONDS, SO IT MUST BE MILLISECONDS
+		if v >= 1e12 || v <= -1e12 {
+			return time.UnixMilli(int64(v)).In(o.location), nil
+		}
+		return time.Unix(int64(v), 0).In(o.location), nil
+	case string:
+		s := trimSpace(v)
+		if t, ok, err := o.relative(s); ok {
+			return t, err
+		}
+		for _, layout := range o.formats {
+			if t, err := time.ParseInLocation(layout, s, o.location); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("%q MATCHES NONE OF THE DATE FORMATS", s)
+	}
+	return time.Time{}, fmt.Errorf("CANNOT PARSE %s AS A DATE", validation.ValueTypeName(value))
+}
+
+// READ A RELATIVE EXPRESSION (ok FALSE WHEN s ISN'T ONE)
+func (o dateOptions) relative(s string) (time.Time, bool, error) {
+	lower := strings.ToLower(s)
+	midnight := time.Date(o.now.Year(), o.now.Month(), o.now.Day(), 0, 0, 0, 0, o.location)
+	anchors := []struct {
+		name string
+		t    time.Time
+	}{
+		{"now", o.now},
+		{"today", midnight},
+		{"yesterday", midnight.AddDate(0, 0, -1)},
+		{"tomorrow", midnight.AddDate(0, 0, 1)},
+	}
+	for _, anchor := range anchors {
+		if rest, found := strings.CutPrefix(lower, anchor.name); found {
+			if strings.TrimSpace(rest) == "" {
+				return anchor.t, true, nil
+			}
+			if !dateOffsetExpr.MatchString(rest) {
+				return time.Time{}, false, nil
+			}
+			t, err := offsetTime(anchor.t, rest)
+			return t, true, err
+		}
+	}
+	if dateOffsetExpr.MatchString(lower) && strings.ContainsAny(lower[:1], "+-") {
+		t, err := offsetTime(o.now, lower)
+		return t, true, err
+	}
+	return time.Time{}, false, nil
+}
+
+// APPLY AN OFFSET EXPRESSION; CALENDAR UNITS MOVE THE WALL CLOCK IN t'S LOCATION
+func offsetTime(t time.Time, expr string) (time.Time, error) {
+	if !dateOffsetExpr.MatchString(expr) {
+		return time.Time{}, fmt.Errorf("INVALID OFFSET %q (EXPECTED E.G. -7d, +1mo OR 2h30m)", expr)
+	}
+	// A SIGN CARRIES TO THE TERMS AFTER IT UNTIL THE NEXT ONE, SO "-1d 12h" IS 36 HOURS BACK
+	sign := 1
+	for _, term := range dateOffsetTerm.FindAllStringSubmatch(expr, -1) {
+		switch term[1] {
+		case "-":
+			sign = -1
+		case "+":
+			sign = 1
+		}
+		n, err := strconv.Atoi(term[2])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("INVALID OFFSET %q", expr)
+		}
+		n *= sign
+		switch term[3] {
+		case "y":
+			t = t.AddDate(n, 0, 0)
+		case "mo":
+			t = t.AddDate(0, n, 0)
+		case "w":
+			t = t.AddDate(0, 0, 7*n)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "h":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "m":
+			t = t.Add(time.Duration(n) * time.Minute)
+		case "s":
+			t = t.Add(time.Duration(n) * time.Second)
+		case "ms":
+			t = t.Add(time.Duration(n) * time.Millisecond)
+		}
+	}
+	return t, nil
+}
+
+// WRITE A DATE: A PRESET, unix, unixMs OR A GO LAYOUT
+func formatTime(t time.Time, format string) any {
+	switch format {
+	case "", "rfc3339":
+		return t.Format(time.RFC3339)
+	case "unix":
+		return float64(t.Unix())
+	case "unixMs":
+		return float64(t.UnixMilli())
+	}
+	if layout, ok := dateFormatPresets[format]; ok {
+		return t.Format(layout)
+	}
+	return t.Format(format)
+}
+
+// APPLY fn TO THE DATE AT key (now WHEN UNSET), OR TO EACH DATE OF AN ARRAY (NULLS PASS THROUGH)
+func mapDates(config map[string]any, key string, opts dateOptions, fn func(time.Time) (any, error)) (TaskData, error) {
+	apply := func(value any) (any, error) {
+		t, err := opts.parse(value)
+		if err != nil {
+			return nil, err
+		}
+		return fn(t)
+	}
+	value, ok := config[key]
+	if !ok || value == nil {
+		value = "now"
+	}
+	if list, isList := value.([]any); isList {
+		out := make([]any, len(list))
+		for i, item := range list {
+			if item == nil {
+				continue
+			}
+			var err error
+			if out[i], err = apply(item); err != nil {
+				return TaskData{}, fmt.Errorf("%s[%d]: %v", key, i, err)
+			}
+		}
+		return TaskData{Type: "array", Value: out}, nil
+	}
+	out, err := apply(value)
+	if err != nil {
+		return TaskData{}, err
+	}
+	return TaskData{Type: validation.ValueTypeName(out), Value: out}, nil
+}
+
+// FAIL EARLY ON BAD formats, timezone OR offset CONFIG
+func validateDateConfig(config map[string]any) error {
+	if _, err := parseDateOptions(config); err != nil {
+		return err
+	}
+	if offset, ok := config["offset"].(string); ok && !dateOffsetExpr.MatchString(offset) {
+		return fmt.Errorf("INVALID OFFSET %q (EXPECTED E.G. -7d, +1mo OR 2h30m)", offset)
+	}
+	return nil
+}
+
+// PARSE DATE TASK: READ DATES INTO RFC 3339
+type ParseDateTask struct{}
+
+func (t *ParseDateTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"date":     "any",     // REQUIRED (TEXT, UNIX TIME, RELATIVE EXPRESSION, OR AN ARRAY OF THEM)
+		"formats":  "array?",  // OPTIONAL (GO LAYOUTS TO TRY; DEFAULTS TO COMMON ONES)
+		"timezone": "string?", // OPTIONAL (FOR DATES WITHOUT ONE, AND THE OUTPUT; DEFAULTS TO UTC)
+	}
+}
+
+func (t *ParseDateTask) GetOutputSchema() string {
+	return "string" // RETURNS RFC 3339 (AN ARRAY FOR ARRAY INPUT)
+}
+
+func (t *ParseDateTask) ValidateConfig(config map[string]any) error {
+	return validateDateConfig(config)
+}
+
+func (t *ParseDateTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	opts, err := parseDateOptions(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	return mapDates(config, "date", opts, func(t time.Time) (any, error) {
+		return t.In(opts.location).Format(time.RFC3339), nil
+	})
+}
+
+// FORMAT DATE TASK
+type FormatDateTask struct{}
+
+func (t *FormatDateTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"date":       "any",     // REQUIRED (TEXT, UNIX TIME, RELATIVE EXPRESSION, OR AN ARRAY OF THEM)
+		"format":     "string",  // REQUIRED (rfc3339, date, datetime, time, rfc1123, unix, unixMs OR A GO LAYOUT)
+		"formats":    "array?",  // OPTIONAL (GO LAYOUTS TO READ date WITH)
+		"timezone":   "string?", // OPTIONAL (FOR DATES WITHOUT ONE; DEFAULTS TO UTC)
+		"toTimezone": "string?", // OPTIONAL (TO WRITE THE DATE IN; DEFAULTS TO timezone)
+	}
+}
+
+func (t *FormatDateTask) GetOutputSchema() string {
+	return "string" // RETURNS THE FORMATTED DATE (A NUMBER FOR unix, AN ARRAY FOR ARRAY INPUT)
+}
+
+func (t *FormatDateTask) ValidateConfig(config map[string]any) error {
+	if format, ok := config["format"].(string); !ok || format == "" {
+		return ErrMissingRequiredInput
+	}
+	if tz, ok := config["toTimezone"].(string); ok && tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("UNKNOWN TIMEZONE %q", tz)
+		}
+	}
+	return validateDateConfig(config)
+}
+
+func (t *FormatDateTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	opts, err := parseDateOptions(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	target := opts.location
+	if tz, ok := config["toTimezone"].(string); ok && tz != "" {
+		if target, err = time.LoadLocation(tz); err != nil {
+			return TaskData{}, fmt.Errorf("UNKNOWN TIMEZONE %q", tz)
+		}
+	}
+	format, _ := config["format"].(string)
+	return mapDates(config, "date", opts, func(t time.Time) (any, error) {
+		return formatTime(t.In(target), format), nil
+	})
+}
+
+// OFFSET DATE TASK
+type OffsetDateTask struct{}
+
+func (t *OffsetDateTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"date":     "any?",    // OPTIONAL (TEXT, UNIX TIME, RELATIVE EXPRESSION, OR AN ARRAY OF THEM; DEFAULTS TO now)
+		"offset":   "string",  // REQUIRED (E.G. "-7d", "+1mo", "2h30m")
+		"format":   "string?", // OPTIONAL (AS FOR formatDate; DEFAULTS TO rfc3339)
+		"formats":  "array?",  // OPTIONAL (GO LAYOUTS TO READ date WITH)
+		"timezone": "string?", // OPTIONAL (WHERE CALENDAR OFFSETS ARE RECKONED; DEFAULTS TO UTC)
+	}
+}
+
+func (t *OffsetDateTask) GetOutputSchema() string {
+	return "string" // RETURNS THE MOVED DATE (AN ARRAY FOR ARRAY INPUT)
+}
+
+func (t *OffsetDateTask) ValidateConfig(config map[string]any) error {
+	if offset, ok := config["offset"].(string); !ok || offset == "" {
+		return ErrMissingRequiredInput
+	}
+	return validateDateConfig(config)
+}
+
+func (t *OffsetDateTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	opts, err := parseDateOptions(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	offset, _ := config["offset"].(string)
+	format, _ := config["format"].(string)
+	return mapDates(config, "date", opts, func(t time.Time) (any, error) {
+		moved, err := offsetTime(t.In(opts.location), offset)
+		if err != nil {
+			return nil, err
+		}
+		return formatTime(moved, format), nil
+	})
+}
+
+// SECONDS IN EACH difference UNIT
+var dateDiffUnits = map[string]float64{
+	"ms": 0.001, "s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800,
+}
+
+// COMPARE DATES TASK
+type CompareDatesTask struct{}
+
+func (t *CompareDatesTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"date":     "any",     // REQUIRED (TEXT, UNIX TIME, RELATIVE EXPRESSION, OR AN ARRAY OF THEM)
+		"to":       "any?",    // OPTIONAL (THE DATE TO COMPARE AGAINST; DEFAULTS TO now)
+		"unit":     "string?", // OPTIONAL (FOR difference: ms, s, m, h, d OR w; DEFAULTS TO s)
+		"formats":  "array?",  // OPTIONAL (GO LAYOUTS TO READ THE DATES WITH)
+		"timezone": "string?", // OPTIONAL (FOR DATES WITHOUT ONE; DEFAULTS TO UTC)
+	}
+}
+
+func (t *CompareDatesTask) GetOutputSchema() string {
+	return "object" // RETURNS {before, after, equal, difference} (AN ARRAY FOR ARRAY INPUT)
+}
+
+func (t *CompareDatesTask) ValidateConfig(config map[string]any) error {
+	if unit, ok := config["unit"].(string); ok && unit != "" {
+		if _, known := dateDiffUnits[unit]; !known {
+			return fmt.Errorf("UNKNOWN UNIT %q (EXPECTED ms, s, m, h, d OR w)", unit)
+		}
+	}
+	return validateDateConfig(config)
+}
+
+func (t *CompareDatesTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	opts, err := parseDateOptions(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	to := opts.now
+	if raw, ok := config["to"]; ok && raw != nil {
+		if to, err = opts.parse(raw); err != nil {
+			return TaskData{}, fmt.Errorf("TO: %v", err)
+		}
+	}
+	unit, _ := config["unit"].(string)
+	if unit == "" {
+		unit = "s"
+	}
+	return mapDates(config, "date", opts, func(t time.Time) (any, error) {
+		return map[string]any{
+			"before":     t.Before(to),
+			"after":      t.After(to),
+			"equal":      t.Equal(to),
+			"difference": t.Sub(to).Seconds() / dateDiffUnits[unit], // POSITIVE WHEN date IS LATER
+		}, nil
+	})
+}
+
+// FILTER BY DATE TASK: KEEP ITEMS WHOSE DATE FALLS IN A RANGE
+type FilterByDateTask struct{}
+
+func (t *FilterByDateTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"items":       "array",    // REQUIRED
+		"by":          "string?",  // OPTIONAL (FIELD HOLDING EACH OBJECT'S DATE; DEFAULTS TO THE ITEMS THEMSELVES)
+		"after":       "any?",     // OPTIONAL (KEEP DATES AFTER THIS, E.G. "now-7d")
+		"before":      "any?",     // OPTIONAL (KEEP DATES BEFORE THIS)
+		"keepInvalid": "boolean?", // OPTIONAL (KEEP ITEMS WITHOUT A READABLE DATE; DEFAULTS TO FALSE)
+		"formats":     "array?",   // OPTIONAL (GO LAYOUTS TO READ THE DATES WITH)
+		"timezone":    "string?",  // OPTIONAL (FOR DATES WITHOUT ONE AND RELATIVE BOUNDS; DEFAULTS TO UTC)
+	}
+}
+
+func (t *FilterByDateTask) GetOutputSchema() string {
+	return "array" // RETURNS THE ITEMS IN RANGE
+}
+
+func (t *FilterByDateTask) ValidateConfig(config map[string]any) error {
+	if config["after"] == nil && config["before"] == nil {
+		return fmt.Errorf("FILTER NEEDS after, before OR BOTH")
+	}
+	return validateDateConfig(config)
+}
+
+func (t *FilterByDateTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	items, err := itemsConfig(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	opts, err := parseDateOptions(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	bound := func(key string) (*time.Time, error) {
+		raw, ok := config[key]
+		if !ok || raw == nil {
+			return nil, nil
+		}
+		t, err := opts.parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.ToUpper(key), err)
+		}
+		return &t, nil
+	}
+	after, err := bound("after")
+	if err != nil {
+		return TaskData{}, err
+	}
+	before, err := bound("before")
+	if err != nil {
+		return TaskData{}, err
+	}
+	by, _ := config["by"].(string)
+	keepInvalid := boolConfig(config, "keepInvalid", false)
+
+	kept := make([]any, 0, len(items))
+	invalid := 0
+	for _, item := range items {
+		t, err := opts.parse(itemField(item, by))
+		if err != nil {
+			invalid++
+			if keepInvalid {
+				kept = append(kept, item)
+			}
+			continue
+		}
+		if (after != nil && !t.After(*after)) || (before != nil && !t.Before(*before)) {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	ctx.Logger.Printf("KEPT %d OF %d ITEMS IN THE DATE RANGE (%d WITHOUT A READABLE DATE)", len(kept), len(items), invalid)
+	return TaskData{Type: "array", Value: kept}, nil
+}
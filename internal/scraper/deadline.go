@@ -0,0 +1,128 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// -- DEADLINE POLICY --
+
+// DEFAULT DEADLINES FOR DIRECT HTTP TRANSFERS
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultStallTimeout   = 30 * time.Second
+)
+
+// DEADLINE ERRORS, REPORTED AS THE CANCELLATION CAUSE
+var (
+	ErrConnectTimeout  = errors.New("TIMED OUT WAITING FOR RESPONSE HEADERS")
+	ErrDownloadStalled = errors.New("DOWNLOAD STALLED: NO DATA RECEIVED")
+	ErrDownloadTimeout = errors.New("DOWNLOAD EXCEEDED MAXIMUM DURATION")
+)
+
+// DEADLINE POLICY FOR A SINGLE TRANSFER
+type DeadlinePolicy struct {
+	ConnectTimeout time.Duration `json:"connectTimeout"` // UNTIL RESPONSE HEADERS ARRIVE
+	StallTimeout   time.Duration `json:"stallTimeout"`   // MAX GAP BETWEEN BODY READS THAT RETURN DATA
+	MaxDuration    time.Duration `json:"maxDuration"`    // ABSOLUTE CAP, ZERO MEANS NONE
+}
+
+// DEADLINE TRACKS ONE TRANSFER UNDER A POLICY
+type Deadline struct {
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	stopMax    context.CancelFunc
+	policy     DeadlinePolicy
+	mu         sync.Mutex
+	connectTmr *time.Timer
+	stallTmr   *time.Timer
+}
+
+// START A DEADLINE DERIVED FROM PARENT; CANCELLING PARENT CANCELS THE TRANSFER
+func (p DeadlinePolicy) Start(parent context.Context) *Deadline {
+	ctx, cancel := context.WithCancelCause(parent)
+	d := &Deadline{ctx: ctx, cancel: cancel, policy: p, stopMax: func() {}}
+
+	if p.MaxDuration > 0 {
+		d.ctx, d.stopMax = context.WithTimeoutCause(ctx, p.MaxDuration, ErrDownloadTimeout)
+	}
+	if p.ConnectTimeout > 0 {
+		d.connectTmr = time.AfterFunc(p.ConnectTimeout, func() { cancel(ErrConnectTimeout) })
+	}
+	return d
+}
+
+// CONTEXT TO ATTACH TO THE REQUEST
+func (d *Deadline) Context() context.Context {
+	return d.ctx
+}
+
+// CONNECTED SWITCHES FROM THE CONNECT PHASE TO STALL DETECTION
+func (d *Deadline) Connected() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.connectTmr != nil {
+		d.connectTmr.Stop()
+	}
+	if d.policy.StallTimeout > 0 && d.stallTmr == nil {
+		d.stallTmr = time.AfterFunc(d.policy.StallTimeout, func() { d.cancel(ErrDownloadStalled) })
+	}
+}
+
+// WRAP A BODY SO EVERY READ THAT RETURNS DATA PUSHES THE STALL DEADLINE BACK
+func (d *Deadline) Reader(r io.Reader) io.Reader {
+	return &stallReader{r: r, d: d}
+}
+
+// ERR MAPS A TRANSFER ERROR TO THE DEADLINE THAT CAUSED IT, IF ANY
+func (d *Deadline) Err(err error) error {
+	if err == nil {
+		return nil
+	}
+	if cause := context.Cause(d.ctx); cause != nil && cause != context.Canceled {
+		return cause
+	}
+	return err
+}
+
+// STOP RELEASES TIMERS AND THE DERIVED CONTEXT
+func (d *Deadline) Stop() {
+	d.mu.Lock()
+	if d.connectTmr != nil {
+		d.connectTmr.Stop()
+	}
+	if d.stallTmr != nil {
+		d.stallTmr.Stop()
+	}
+	d.mu.Unlock()
+
+	d.stopMax()
+	d.cancel(nil)
+}
+
+func (d *Deadline) touch() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stallTmr != nil {
+		d.stallTmr.Reset(d.policy.StallTimeout)
+	}
+}
+
+// READER THAT FEEDS THE STALL TIMER
+type stallReader struct {
+	r io.Reader
+	d *Deadline
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.d.touch()
+	}
+	return n, err
+}
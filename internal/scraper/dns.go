@@ -0,0 +1,502 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// -- DNS --
+//
+// BY DEFAULT NAMES ARE RESOLVED BY THE SYSTEM. THE dns SETTING (OR A JOB'S dns RULE) CAN PIN
+// NAMES TO ADDRESSES, SEND LOOKUPS TO A SPECIFIC RESOLVER, OR SEND THEM OVER HTTPS, WHICH HELPS
+// WITH SPLIT-HORIZON TARGETS AND ISP-LEVEL BLOCKING. DIRECT HTTP REQUESTS AND THE SSRF CHECKS USE
+// THE SAME ANSWERS. BROWSERS GET THE HOSTS AND THE DOH ENDPOINT AS LAUNCH FLAGS; CHROMIUM HAS NO
+// FLAG FOR A PLAIN RESOLVER, SO THAT ONE ONLY APPLIES TO DIRECT REQUESTS.
+
+// JOB RULE OVERRIDING THE DNS SETTINGS, E.G. {"doh": "https://1.1.1.1/dns-query", "hosts": {"intranet.example": "10.0.0.5"}}
+const dnsRule = "dns"
+
+const (
+	dohTimeout  = 10 * time.Second
+	dohMaxTTL   = 5 * time.Minute // ANSWERS ARE CACHED FOR THEIR TTL, UP TO THIS LONG
+	dohMaxBody  = 64 << 10
+	dnsMimeType = "application/dns-message"
+)
+
+// HOW HOST NAMES ARE RESOLVED
+type DNSPolicy struct {
+	Resolver string            // DNS SERVER (IP OR IP:PORT) INSTEAD OF THE SYSTEM'S
+	DoH      string            // DNS-OVER-HTTPS ENDPOINT (RFC 8484), E.G. https://dns.example/dns-query
+	Hosts    map[string]string // NAME (OR *.DOMAIN) -> IP, CHECKED BEFORE ANY LOOKUP
+}
+
+// POLICY FROM THE GLOBAL SETTINGS
+func DNSPolicyFromConfig(cfg *config.Config) DNSPolicy {
+	return DNSPolicy{Resolver: cfg.DNS.Resolver, DoH: cfg.DNS.DoH, Hosts: cfg.DNS.Hosts}
+}
+
+// READ A dns RULE (OR SETTING) FROM DECODED JSON
+func ParseDNSPolicy(v any) (DNSPolicy, error) {
+	var policy DNSPolicy
+	if v == nil {
+		return policy, nil
+	}
+	raw, ok := v.(map[string]any)
+	if !ok {
+		return policy, fmt.Errorf("%w: dns MUST BE AN OBJECT", ErrInvalidInput)
+	}
+	for key, value := range raw {
+		switch key {
+		case "resolver", "doh":
+			s, ok := value.(string)
+			if !ok && value != nil {
+				return policy, fmt.Errorf("%w: dns.%s MUST BE A STRING", ErrInvalidInput, key)
+			}
+			if key == "resolver" {
+				policy.Resolver = s
+			} else {
+				policy.DoH = s
+			}
+		case "hosts":
+			hosts, ok := value.(map[string]any)
+			if !ok && value != nil {
+				return policy, fmt.Errorf("%w: dns.hosts MUST MAP NAMES TO IPS", ErrInvalidInput)
+			}
+			policy.Hosts = make(map[string]string, len(hosts))
+			for name, ip := range hosts {
+				s, ok := ip.(string)
+				if !ok {
+					return policy, fmt.Errorf("%w: dns.hosts.%s MUST BE AN IP", ErrInvalidInput, name)
+				}
+				policy.Hosts[name] = s
+			}
+		default:
+			return policy, fmt.Errorf("%w: UNKNOWN dns OPTION %q", ErrInvalidInput, key)
+		}
+	}
+	return policy, policy.Validate()
+}
+
+// CHECK THE RESOLVER, ENDPOINT AND HOST ENTRIES
+func (p DNSPolicy) Validate() error {
+	if p.Resolver != "" && p.DoH != "" {
+		return fmt.Errorf("%w: SET dns.resolver OR dns.doh, NOT BOTH", ErrInvalidInput)
+	}
+	if p.Resolver != "" {
+		if _, err := p.resolverAddr(); err != nil {
+			return err
+		}
+	}
+	if p.DoH != "" {
+		u, err := url.Parse(p.DoH)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%w: dns.doh MUST BE AN https URL", ErrInvalidInput)
+		}
+	}
+	for name, ip := range p.Hosts {
+		if strings.TrimPrefix(name, "*.") == "" || strings.ContainsAny(name, " ,/") {
+			return fmt.Errorf("%w: INVALID HOST NAME IN dns.hosts: %q", ErrInvalidInput, name)
+		}
+		if _, err := netip.ParseAddr(ip); err != nil {
+			return fmt.Errorf("%w: dns.hosts.%s IS NOT AN IP: %q", ErrInvalidInput, name, ip)
+		}
+	}
+	return nil
+}
+
+// POLICY FOR A RUNNING JOB: THE SETTINGS, WITH ITS dns RULE REPLACING THE UPSTREAM (RESOLVER OR
+// DOH) IF IT NAMES ONE AND ADDING TO THE HOSTS
+func (e *Engine) dnsPolicy(jobID string) DNSPolicy {
+	policy := DNSPolicyFromConfig(e.cfg)
+	v, ok := e.jobRule(jobID, dnsRule)
+	if !ok {
+		return policy
+	}
+	override, err := ParseDNSPolicy(v)
+	if err != nil {
+		log.Printf("[JOB %s] IGNORING dns RULE: %v", jobID, err)
+		return policy
+	}
+	if override.Resolver != "" || override.DoH != "" {
+		policy.Resolver, policy.DoH = override.Resolver, override.DoH
+	}
+	if len(override.Hosts) > 0 {
+		hosts := make(map[string]string, len(policy.Hosts)+len(override.Hosts))
+		for name, ip := range policy.Hosts {
+			hosts[name] = ip
+		}
+		for name, ip := range override.Hosts {
+			hosts[name] = ip
+		}
+		policy.Hosts = hosts
+	}
+	return policy
+}
+
+// WHETHER THE SYSTEM RESOLVER IS USED FOR EVERYTHING
+func (p DNSPolicy) isDefault() bool {
+	return p.Resolver == "" && p.DoH == "" && len(p.Hosts) == 0
+}
+
+// TRANSPORT CACHE KEY
+func (p DNSPolicy) key() string {
+	if p.isDefault() {
+		return ""
+	}
+	names := make([]string, 0, len(p.Hosts))
+	for name, ip := range p.Hosts {
+		names = append(names, strings.ToLower(name)+"="+ip)
+	}
+	slices.Sort(names)
+	return p.Resolver + "|" + p.DoH + "|" + strings.Join(names, ",")
+}
+
+// THE RESOLVER WITH ITS PORT
+func (p DNSPolicy) resolverAddr() (string, error) {
+	if addr, err := netip.ParseAddr(strings.Trim(p.Resolver, "[]")); err == nil {
+		return netip.AddrPortFrom(addr, 53).String(), nil
+	}
+	addrPort, err := netip.ParseAddrPort(p.Resolver)
+	if err != nil {
+		return "", fmt.Errorf("%w: dns.resolver MUST BE AN IP OR IP:PORT", ErrInvalidInput)
+	}
+	return addrPort.String(), nil
+}
+
+// ADDRESS PINNED FOR A NAME: AN EXACT ENTRY, ELSE THE LONGEST MATCHING *.DOMAIN
+func (p DNSPolicy) pinned(host string) (netip.Addr, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	best, bestLen := "", -1
+	for name, ip := range p.Hosts {
+		name = strings.ToLower(name)
+		if name == host {
+			best = ip
+			break
+		}
+		if domain, ok := strings.CutPrefix(name, "*."); ok && strings.HasSuffix(host, "."+domain) && len(domain) > bestLen {
+			best, bestLen = ip, len(domain)
+		}
+	}
+	if best == "" {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(best)
+	return addr, err == nil
+}
+
+// RESOLVE A NAME THROUGH THE POLICY
+func (p DNSPolicy) lookup(ctx context.Context, guard *NetGuard, egress EgressPolicy, host string) ([]netip.Addr, error) {
+	if addr, ok := p.pinned(host); ok {
+		return []netip.Addr{addr}, nil
+	}
+	switch {
+	case p.DoH != "":
+		return lookupDoH(ctx, guard, egress, p.DoH, host)
+	case p.Resolver != "":
+		addr, err := p.resolverAddr()
+		if err != nil {
+			return nil, err
+		}
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d, err := egress.dialer(&net.Dialer{}, network)
+				if err != nil {
+					return nil, err
+				}
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+		return resolver.LookupNetIP(ctx, "ip", host)
+	}
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+// DIAL FUNC FOR TRANSPORTS: NAMES ARE RESOLVED BY THE POLICY AND THE ADDRESSES THE EGRESS POLICY
+// ALLOWS ARE DIALED IN TURN FROM ITS LOCAL ADDRESS, THROUGH THE SSRF GUARD
+func (p DNSPolicy) dialContext(guard *NetGuard, egress EgressPolicy, base *net.Dialer) dialFunc {
+	if p.isDefault() && egress.isDefault() {
+		return guard.checkDomains(guard.dialContext(base))
+	}
+	return guard.checkDomains(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialer, err := egress.dialer(base, network)
+		if err != nil {
+			return nil, err
+		}
+		network = egress.network(network)
+		guarded := guard.dialContext(dialer)
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil || p.isDefault() {
+			return guarded(ctx, network, addr)
+		}
+		addrs, err := p.lookup(ctx, guard, egress, host)
+		if err != nil {
+			return nil, err
+		}
+		dial := guarded
+		if guard.allowsHost(host) {
+			dial = dialer.DialContext
+		}
+		var lastErr error
+		for _, ip := range addrs {
+			if !egress.allows(ip) {
+				continue
+			}
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if lastErr == nil {
+			lastErr = fmt.Errorf("NO USABLE ADDRESSES FOR %s", host)
+		}
+		return nil, lastErr
+	})
+}
+
+// HTTP/3 DIAL THAT RESOLVES THROUGH THE POLICY AND CONNECTS TO THE ADDRESS THE GUARD CHECKED, SO
+// IT CAN'T BE REBOUND IN BETWEEN, FROM THE EGRESS POLICY'S LOCAL ADDRESS (NIL WHEN NONE OF THEM
+// CHANGES ANYTHING)
+func (p DNSPolicy) dialQUIC(guard *NetGuard, egress EgressPolicy) func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	if guard == nil && p.isDefault() && egress.isDefault() {
+		return nil
+	}
+	socket := &egressSocket{policy: egress}
+	return func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := guard.checkHost(host); err != nil {
+			return nil, err
+		}
+		if guard.allowsHost(host) && p.isDefault() && egress.isDefault() {
+			return quic.DialAddrEarly(ctx, addr, tlsCfg, cfg)
+		}
+		var addrs []netip.Addr
+		if guard.allowsHost(host) {
+			addrs, err = p.lookup(ctx, guard, egress, host)
+		} else {
+			addrs, err = guard.resolve(ctx, host, p, egress)
+		}
+		if err != nil {
+			return nil, err
+		}
+		addrs = slices.DeleteFunc(addrs, func(ip netip.Addr) bool { return !egress.allows(ip) })
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("NO USABLE ADDRESSES FOR %s", host)
+		}
+		target := net.JoinHostPort(addrs[0].Unmap().String(), port)
+		if egress.isDefault() {
+			return quic.DialAddrEarly(ctx, target, tlsCfg, cfg)
+		}
+		return socket.dialQUIC(ctx, target, tlsCfg, cfg)
+	}
+}
+
+// CHROMIUM FLAGS FOR THE HOSTS AND DOH ENDPOINT (A PLAIN RESOLVER HAS NO FLAG)
+func (p DNSPolicy) browserArgs() []string {
+	var args []string
+	if len(p.Hosts) > 0 {
+		rules := make([]string, 0, len(p.Hosts))
+		for name, ip := range p.Hosts {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				continue
+			}
+			target := addr.String()
+			if addr.Is6() && !addr.Is4In6() {
+				target = "[" + target + "]"
+			}
+			rules = append(rules, "MAP "+strings.ToLower(name)+" "+target)
+		}
+		slices.Sort(rules)
+		args = append(args, "--host-resolver-rules="+strings.Join(rules, ","))
+	}
+	if p.DoH != "" {
+		// SECURE MODE: NO FALLING BACK TO THE SYSTEM RESOLVER WHEN THE ENDPOINT FAILS
+		args = append(args, "--enable-features=DnsOverHttps:Templates/"+url.QueryEscape(p.DoH)+"/Fallback/false")
+	}
+	return args
+}
+
+// -- DNS OVER HTTPS --
+
+type dohAnswer struct {
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+var (
+	dohCacheMu sync.Mutex
+	dohCache   = make(map[string]dohAnswer)
+)
+
+// RESOLVE A AND AAAA RECORDS FOR A NAME OVER HTTPS, CACHED FOR THE RECORDS' TTL. ENDPOINTS CAN
+// COME FROM JOB RULES, SO THEY GO THROUGH THE SSRF GUARD (AND LEAVE THROUGH THE JOB'S EGRESS)
+func lookupDoH(ctx context.Context, guard *NetGuard, egress EgressPolicy, endpoint, host string) ([]netip.Addr, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	cacheKey := endpoint + "|" + egress.key() + "|" + host
+	dohCacheMu.Lock()
+	cached, ok := dohCache[cacheKey]
+	if ok && time.Now().After(cached.expires) {
+		delete(dohCache, cacheKey)
+		ok = false
+	}
+	dohCacheMu.Unlock()
+	if ok {
+		return cached.addrs, nil
+	}
+
+	type result struct {
+		addrs []netip.Addr
+		ttl   time.Duration
+		err   error
+	}
+	results := make(chan result, 2)
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		go func() {
+			addrs, ttl, err := queryDoH(ctx, guard, egress, endpoint, host, qtype)
+			results <- result{addrs, ttl, err}
+		}()
+	}
+	var addrs []netip.Addr
+	var firstErr error
+	ttl := dohMaxTTL
+	for range 2 {
+		r := <-results
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		addrs = append(addrs, r.addrs...)
+		if len(r.addrs) > 0 {
+			ttl = min(ttl, r.ttl)
+		}
+	}
+	if len(addrs) == 0 {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: endpoint, IsNotFound: true}
+	}
+
+	dohCacheMu.Lock()
+	dohCache[cacheKey] = dohAnswer{addrs: addrs, expires: time.Now().Add(ttl)}
+	dohCacheMu.Unlock()
+	return addrs, nil
+}
+
+// ONE DOH QUERY (RFC 8484 POST) AND THE ADDRESSES IN ITS ANSWER
+func queryDoH(ctx context.Context, guard *NetGuard, egress EgressPolicy, endpoint, host string, qtype dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("INVALID HOST NAME %q: %v", host, err)
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true}) // ID 0 KEEPS ANSWERS CACHEABLE
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	query, err := builder.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(query))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", dnsMimeType)
+	req.Header.Set("Accept", dnsMimeType)
+	resp, err := NewHTTPClient(HTTPClientOptions{Timeout: dohTimeout, Guard: guard, Egress: egress}).Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DOH QUERY FOR %s FAILED: %v", host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DOH QUERY FOR %s FAILED: %s", host, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxBody))
+	if err != nil {
+		return nil, 0, fmt.Errorf("DOH QUERY FOR %s FAILED: %v", host, err)
+	}
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("INVALID DOH RESPONSE FOR %s: %v", host, err)
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("DOH QUERY FOR %s FAILED: %s", host, header.RCode)
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, 0, fmt.Errorf("INVALID DOH RESPONSE FOR %s: %v", host, err)
+	}
+
+	var addrs []netip.Addr
+	ttl := dohMaxTTL
+	for {
+		answer, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("INVALID DOH RESPONSE FOR %s: %v", host, err)
+		}
+		switch answer.Type {
+		case dnsmessage.TypeA:
+			record, err := parser.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			addrs = append(addrs, netip.AddrFrom4(record.A))
+		case dnsmessage.TypeAAAA:
+			record, err := parser.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			addrs = append(addrs, netip.AddrFrom16(record.AAAA))
+		default:
+			// CNAMES ARE FOLLOWED BY THE SERVER; THEIR TARGETS' RECORDS COME IN THE SAME ANSWER
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		ttl = min(ttl, time.Duration(answer.TTL)*time.Second)
+	}
+	return addrs, ttl, nil
+}
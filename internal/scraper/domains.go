@@ -0,0 +1,101 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/config"
+)
+
+// -- INSTANCE DOMAIN LISTS --
+//
+// ADMINISTRATORS CAN BLOCK DOMAINS FOR EVERY JOB AND TOOL ON THE INSTANCE, OR ALLOW ONLY A LIST
+// OF THEM. THE LISTS RIDE ON THE NET GUARD, SO THEY ARE CHECKED WHEREVER THE SSRF CHECKS ARE:
+// WHEN DIRECT REQUESTS DIAL (BY THE NAME IN THE URL, BEFORE ANY RESOLUTION), AND ON EVERY
+// REQUEST A GUARDED BROWSER CONTEXT MAKES. A DOMAIN COVERS ITS SUBDOMAINS.
+
+// RETURNED FOR A REQUEST TO A DOMAIN THE INSTANCE DOESN'T ALLOW
+var ErrBlockedDomain = errors.New("DOMAIN NOT ALLOWED ON THIS INSTANCE")
+
+// BLOCKED AND ALLOW-ONLY DOMAINS, LOWER CASE WITHOUT LEADING WILDCARDS
+type domainLists struct {
+	blocked   []string
+	allowOnly []string
+}
+
+// READ THE domains SETTING
+func newDomainLists(cfg config.DomainsConfig) domainLists {
+	normalize := func(entries []string) []string {
+		var out []string
+		for _, entry := range entries {
+			entry = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(entry)), "*"), ".")
+			if entry = strings.TrimSuffix(entry, "."); entry != "" {
+				out = append(out, entry)
+			}
+		}
+		slices.Sort(out)
+		return slices.Compact(out)
+	}
+	return domainLists{blocked: normalize(cfg.Block), allowOnly: normalize(cfg.AllowOnly)}
+}
+
+// WHETHER ANY LIST IS SET
+func (d domainLists) active() bool {
+	return len(d.blocked) > 0 || len(d.allowOnly) > 0
+}
+
+// TRANSPORT CACHE KEY
+func (d domainLists) key() string {
+	if !d.active() {
+		return ""
+	}
+	return strings.Join(d.blocked, ",") + "|" + strings.Join(d.allowOnly, ",")
+}
+
+// WHETHER A HOST IS ONE OF THE DOMAINS OR UNDER ONE
+func matchesDomain(host string, domains []string) bool {
+	return slices.ContainsFunc(domains, func(domain string) bool {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
+
+// FAIL FOR A HOST THE LISTS DON'T ALLOW
+func (g *NetGuard) checkHost(host string) error {
+	if g == nil || !g.domains.active() {
+		return nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	if matchesDomain(host, g.domains.blocked) {
+		return fmt.Errorf("%w: %s IS BLOCKED", ErrBlockedDomain, host)
+	}
+	if len(g.domains.allowOnly) > 0 && !matchesDomain(host, g.domains.allowOnly) {
+		return fmt.Errorf("%w: %s IS NOT ON THE ALLOW LIST", ErrBlockedDomain, host)
+	}
+	return nil
+}
+
+// CHECK THE NAME A CONNECTION IS FOR BEFORE DIALING IT
+func (g *NetGuard) checkDomains(dial dialFunc) dialFunc {
+	if g == nil || !g.domains.active() {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := g.checkHost(host); err != nil {
+			return nil, err
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// WHETHER AN ERROR IS A REFUSAL BY THE SSRF SETTINGS OR THE DOMAIN LISTS
+func IsBlocked(err error) bool {
+	return errors.Is(err, ErrBlockedAddress) || errors.Is(err, ErrBlockedDomain)
+}
@@ -0,0 +1,387 @@
+package scraper
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// -- DOWNLOAD SCHEDULING --
+
+// DEFAULT DOWNLOAD LIMITS
+const (
+	defaultMaxDownloadWorkers = 8
+	defaultMaxConnsPerHost    = 2
+)
+
+// DOWNLOAD SCHEDULER BOUNDS GLOBAL AND PER-HOST DOWNLOAD CONCURRENCY
+type DownloadScheduler struct {
+	global      chan struct{}
+	perHostSize int
+	mu          sync.Mutex
+	hosts       map[string]*hostSlots
+}
+
+// PER-HOST SEMAPHORE WITH A REFERENCE COUNT SO IDLE HOSTS CAN BE DROPPED
+type hostSlots struct {
+	slots chan struct{}
+	refs  int
+}
+
+// NEW DOWNLOAD SCHEDULER
+func NewDownloadScheduler(maxWorkers, maxPerHost int) *DownloadScheduler {
+	if maxWorkers <= 0 {
+		maxWorkers = defaultMaxDownloadWorkers
+	}
+	if maxPerHost <= 0 {
+		maxPerHost = defaultMaxConnsPerHost
+	}
+	return &DownloadScheduler{
+		global:      make(chan struct{}, maxWorkers),
+		perHostSize: maxPerHost,
+		hosts:       make(map[string]*hostSlots),
+	}
+}
+
+// ACQUIRE A DOWNLOAD SLOT FOR THE URL'S HOST, BLOCKING UNTIL ONE IS FREE OR CTX ENDS
+func (s *DownloadScheduler) Acquire(ctx context.Context, rawURL string) (func(), error) {
+	host := downloadHost(rawURL)
+
+	s.mu.Lock()
+	hs, ok := s.hosts[host]
+	if !ok {
+		hs = &hostSlots{slots: make(chan struct{}, s.perHostSize)}
+		s.hosts[host] = hs
+	}
+	hs.refs++
+	s.mu.Unlock()
+
+	// TAKE THE HOST SLOT FIRST SO A BUSY HOST DOESN'T HOLD GLOBAL SLOTS
+	select {
+	case hs.slots <- struct{}{}:
+	case <-ctx.Done():
+		s.releaseHost(host, hs, false)
+		return nil, ctx.Err()
+	}
+
+	select {
+	case s.global <- struct{}{}:
+	case <-ctx.Done():
+		s.releaseHost(host, hs, true)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-s.global
+			s.releaseHost(host, hs, true)
+		})
+	}, nil
+}
+
+// RELEASE A HOST SLOT AND DROP THE HOST ENTRY WHEN NOTHING REFERENCES IT
+func (s *DownloadScheduler) releaseHost(host string, hs *hostSlots, held bool) {
+	if held {
+		<-hs.slots
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hs.refs--
+	if hs.refs == 0 {
+		delete(s.hosts, host)
+	}
+}
+
+// ACTIVE DOWNLOAD COUNTS, GLOBAL AND PER HOST
+func (s *DownloadScheduler) Stats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hosts := make(map[string]int, len(s.hosts))
+	for host, hs := range s.hosts {
+		hosts[host] = len(hs.slots)
+	}
+	return map[string]any{
+		"active":      len(s.global),
+		"maxWorkers":  cap(s.global),
+		"maxPerHost":  s.perHostSize,
+		"activeHosts": hosts,
+	}
+}
+
+// NORMALIZE A URL TO ITS HOST FOR SLOT ACCOUNTING
+func downloadHost(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	return parsed.Host
+}
+
+// -- DOWNLOAD PROGRESS --
+
+// HOW OFTEN A TRANSFER PUBLISHES PROGRESS EVENTS
+const progressInterval = 500 * time.Millisecond
+
+// TRANSFER STATS FOR A SINGLE DOWNLOAD
+type DownloadProgress struct {
+	ID         string    `json:"id"`
+	JobID      string    `json:"jobId"`
+	URL        string    `json:"url"`
+	FilePath   string    `json:"filePath"`
+	Status     string    `json:"status"` // queued, downloading, completed, failed
+	BytesDone  int64     `json:"bytesDone"`
+	BytesTotal int64     `json:"bytesTotal"` // -1 WHEN THE SERVER SENDS NO LENGTH
+	Speed      float64   `json:"speed"`      // BYTES PER SECOND
+	ETA        float64   `json:"eta"`        // SECONDS, -1 WHEN UNKNOWN
+	Retries    int       `json:"retries"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+
+	ResumedFrom int64 `json:"resumedFrom,omitempty"` // BYTES ALREADY ON DISK WHEN THE LAST ATTEMPT STARTED
+	Chunks      int   `json:"chunks,omitempty"`      // PARALLEL RANGE REQUESTS, WHEN SPLIT
+}
+
+// DOWNLOAD TRACKER HOLDS LIVE TRANSFER STATE PER JOB
+type DownloadTracker struct {
+	mu        sync.Mutex
+	transfers map[string]map[string]*Transfer // JOB ID -> TRANSFER ID -> TRANSFER
+	events    *EventBus
+	finished  func(progress DownloadProgress, began time.Time) // CALLED AS EACH TRANSFER ENDS
+}
+
+// TRANSFER IS A TRACKED DOWNLOAD
+type Transfer struct {
+	tracker     *DownloadTracker
+	mu          sync.Mutex
+	progress    DownloadProgress
+	lastSample  time.Time
+	lastBytes   int64
+	lastPublish time.Time
+	began       time.Time // FIRST BYTES ASKED FOR, AFTER WAITING FOR A SLOT
+}
+
+// NEW DOWNLOAD TRACKER
+func NewDownloadTracker(events *EventBus) *DownloadTracker {
+	return &DownloadTracker{
+		transfers: make(map[string]map[string]*Transfer),
+		events:    events,
+	}
+}
+
+// BEGIN TRACKING A DOWNLOAD
+func (t *DownloadTracker) Start(jobID, rawURL, filePath string) *Transfer {
+	now := time.Now()
+	tr := &Transfer{
+		tracker: t,
+		progress: DownloadProgress{
+			ID:         utils.GenerateID("dl"),
+			JobID:      jobID,
+			URL:        rawURL,
+			FilePath:   filePath,
+			Status:     "queued",
+			BytesTotal: -1,
+			ETA:        -1,
+			StartedAt:  now,
+			UpdatedAt:  now,
+		},
+		lastSample: now,
+	}
+
+	t.mu.Lock()
+	if t.transfers[jobID] == nil {
+		t.transfers[jobID] = make(map[string]*Transfer)
+	}
+	t.transfers[jobID][tr.progress.ID] = tr
+	t.mu.Unlock()
+
+	tr.publish("download.queued")
+	return tr
+}
+
+// SNAPSHOT OF ALL TRANSFERS FOR A JOB
+func (t *DownloadTracker) Job(jobID string) []DownloadProgress {
+	t.mu.Lock()
+	transfers := make([]*Transfer, 0, len(t.transfers[jobID]))
+	for _, tr := range t.transfers[jobID] {
+		transfers = append(transfers, tr)
+	}
+	t.mu.Unlock()
+
+	result := make([]DownloadProgress, 0, len(transfers))
+	for _, tr := range transfers {
+		result = append(result, tr.Snapshot())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// FORGET A JOB'S TRANSFERS
+func (t *DownloadTracker) Clear(jobID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.transfers, jobID)
+}
+
+// MARK THE TRANSFER AS RECEIVING DATA
+func (tr *Transfer) Begin(total int64) {
+	tr.mu.Lock()
+	now := time.Now()
+	tr.progress.Status = "downloading"
+	tr.progress.BytesTotal = total
+	tr.progress.UpdatedAt = now
+	tr.lastSample = now
+	if tr.began.IsZero() {
+		tr.began = now
+	}
+	tr.mu.Unlock()
+
+	tr.publish("download.started")
+}
+
+// RECORD A RETRY ATTEMPT
+func (tr *Transfer) Retry(reason error) {
+	tr.mu.Lock()
+	tr.progress.Retries++
+	tr.progress.BytesDone = 0
+	tr.progress.ResumedFrom = 0
+	tr.progress.Chunks = 0
+	tr.lastBytes = 0
+	if reason != nil {
+		tr.progress.Error = reason.Error()
+	}
+	tr.mu.Unlock()
+
+	tr.publish("download.retry")
+}
+
+// RECORD AN ATTEMPT THAT PICKS UP FROM offset BYTES ALREADY ON DISK, OR STARTS OVER AT 0 (A RETRY
+// WHEN reason IS SET)
+func (tr *Transfer) Resume(offset int64, reason error) {
+	tr.mu.Lock()
+	tr.progress.BytesDone = offset
+	tr.progress.ResumedFrom = offset
+	tr.lastBytes = offset
+	if reason != nil {
+		tr.progress.Retries++
+		tr.progress.Error = reason.Error()
+	}
+	tr.mu.Unlock()
+
+	if offset > 0 {
+		tr.publish("download.resumed")
+	} else {
+		tr.publish("download.restarted")
+	}
+}
+
+// RECORD THAT THE TRANSFER WAS SPLIT INTO PARALLEL CHUNKS
+func (tr *Transfer) Split(chunks int) {
+	tr.mu.Lock()
+	tr.progress.Chunks = chunks
+	tr.mu.Unlock()
+}
+
+// FINISH THE TRANSFER WITH AN OPTIONAL ERROR
+func (tr *Transfer) Finish(err error) {
+	tr.mu.Lock()
+	tr.progress.UpdatedAt = time.Now()
+	tr.progress.Speed = 0
+	tr.progress.ETA = 0
+	if err != nil {
+		tr.progress.Status = "failed"
+		tr.progress.Error = err.Error()
+	} else {
+		tr.progress.Status = "completed"
+		tr.progress.Error = ""
+		if tr.progress.BytesTotal < 0 {
+			tr.progress.BytesTotal = tr.progress.BytesDone
+		}
+	}
+	tr.mu.Unlock()
+
+	if err != nil {
+		tr.publish("download.failed")
+	} else {
+		tr.publish("download.completed")
+	}
+	if tr.tracker.finished != nil {
+		tr.mu.Lock()
+		snapshot, began := tr.progress, tr.began
+		tr.mu.Unlock()
+		tr.tracker.finished(snapshot, began)
+	}
+}
+
+// SNAPSHOT OF THE TRANSFER'S CURRENT STATE
+func (tr *Transfer) Snapshot() DownloadProgress {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.progress
+}
+
+// WRAP A READER SO BYTES READ ARE COUNTED AGAINST THE TRANSFER
+func (tr *Transfer) Reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, tr: tr}
+}
+
+func (tr *Transfer) add(n int) {
+	tr.mu.Lock()
+	now := time.Now()
+	tr.progress.BytesDone += int64(n)
+	tr.progress.UpdatedAt = now
+
+	// RESAMPLE SPEED PERIODICALLY SO SHORT BURSTS DON'T SKEW IT
+	if elapsed := now.Sub(tr.lastSample); elapsed >= progressInterval {
+		tr.progress.Speed = float64(tr.progress.BytesDone-tr.lastBytes) / elapsed.Seconds()
+		tr.lastSample = now
+		tr.lastBytes = tr.progress.BytesDone
+		if tr.progress.BytesTotal > 0 && tr.progress.Speed > 0 {
+			tr.progress.ETA = float64(tr.progress.BytesTotal-tr.progress.BytesDone) / tr.progress.Speed
+		} else {
+			tr.progress.ETA = -1
+		}
+	}
+
+	shouldPublish := now.Sub(tr.lastPublish) >= progressInterval
+	tr.mu.Unlock()
+
+	if shouldPublish {
+		tr.publish("download.progress")
+	}
+}
+
+func (tr *Transfer) publish(eventType string) {
+	tr.mu.Lock()
+	tr.lastPublish = time.Now()
+	snapshot := tr.progress
+	tr.mu.Unlock()
+
+	if tr.tracker.events != nil {
+		tr.tracker.events.Publish(eventType, snapshot.JobID, snapshot)
+	}
+}
+
+// READER THAT REPORTS PROGRESS TO A TRANSFER
+type progressReader struct {
+	r  io.Reader
+	tr *Transfer
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.tr.add(n)
+	}
+	return n, err
+}
@@ -0,0 +1,245 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// -- EARLY EXIT --
+//
+// A stopWhen CONDITION ENDS A LOOP ONCE IT REACHES ITEMS AN EARLIER RUN ALREADY COVERED, SO AN
+// INCREMENTAL RUN DOESN'T WALK THE WHOLE HISTORY AGAIN. A WORKER-PER-ITEM STAGE CHECKS ITS ITEMS
+// IN ORDER BEFORE QUEUEING THEM AND DROPS EVERYTHING FROM THE STOPPING POINT ON; A CRAWL CHECKS
+// EACH PAGE AS IT IS FETCHED AND STOPS HANDING OUT URLS (PAGES ALREADY IN FLIGHT STILL FINISH).
+
+// stopWhen VALUE MEANING THE START OF THE JOB'S PREVIOUS RUN
+const stopLastRun = "lastRun"
+
+// STOP CONDITION, E.G. {"olderThan": "lastRun", "dateField": "published"} OR {"knownItems": 5}.
+// EITHER HALF STOPS THE LOOP
+type StopCondition struct {
+	OlderThan  string `json:"olderThan"`  // "lastRun", A DATE OR A RELATIVE EXPRESSION ("now-7d")
+	DateField  string `json:"dateField"`  // ITEM FIELD WITH THE ITEM'S DATE (DOTTED PATHS WORK)
+	KnownItems int    `json:"knownItems"` // STOP AFTER THIS MANY ITEMS IN A ROW THAT EARLIER RUNS SAVED
+	KeyField   any    `json:"keyField"`   // FIELD(S) IDENTIFYING AN ITEM; DEFAULTS TO THE JOB'S recordKey, THEN url
+}
+
+// PARSE A stopWhen CONDITION (NIL WHEN UNSET)
+func ParseStopCondition(raw any) (*StopCondition, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var cond StopCondition
+	if err := decoder.Decode(&cond); err != nil {
+		return nil, fmt.Errorf("STOP CONDITION MUST BE AN OBJECT: %v", err)
+	}
+	if cond.KnownItems < 0 {
+		return nil, fmt.Errorf("knownItems CAN'T BE NEGATIVE")
+	}
+	if cond.OlderThan != "" {
+		if cond.DateField == "" {
+			return nil, fmt.Errorf("olderThan NEEDS A dateField")
+		}
+		if cond.OlderThan != stopLastRun {
+			opts := dateOptions{formats: defaultDateFormats, location: time.UTC, now: time.Now()}
+			if _, err := opts.parse(cond.OlderThan); err != nil {
+				return nil, fmt.Errorf("olderThan MUST BE lastRun OR A DATE: %v", err)
+			}
+		}
+	}
+	if cond.KeyField != nil {
+		if _, err := RecordKeyFields(cond.KeyField); err != nil {
+			return nil, fmt.Errorf("keyField: %v", err)
+		}
+	}
+	if cond.OlderThan == "" && cond.KnownItems == 0 {
+		return nil, fmt.Errorf("STOP CONDITION NEEDS olderThan OR knownItems")
+	}
+	return &cond, nil
+}
+
+// ONE LOOP'S CHECKS AGAINST ITS CONDITION
+type stopTracker struct {
+	cond      StopCondition
+	cutoff    time.Time // ZERO WITHOUT olderThan, OR BEFORE A JOB'S FIRST RUN
+	dates     dateOptions
+	keyFields []string
+	engine    *Engine
+	jobID     string
+	runStart  time.Time
+	mu        sync.Mutex
+	streak    int
+	reason    string
+}
+
+// START CHECKING ITEMS AGAINST A CONDITION FOR THE RUNNING JOB
+func (e *Engine) newStopTracker(jobID string, cond StopCondition) (*stopTracker, error) {
+	e.mu.Lock()
+	runStart := e.jobStartTimes[jobID]
+	previousRun := e.jobProgress[jobID].PreviousRun
+	e.mu.Unlock()
+
+	t := &stopTracker{
+		cond:     cond,
+		dates:    dateOptions{formats: defaultDateFormats, location: time.UTC, now: time.Now()},
+		engine:   e,
+		jobID:    jobID,
+		runStart: runStart,
+	}
+	switch cond.OlderThan {
+	case "":
+	case stopLastRun:
+		if previousRun != nil {
+			t.cutoff = *previousRun
+		}
+	default:
+		cutoff, err := t.dates.parse(cond.OlderThan)
+		if err != nil {
+			return nil, fmt.Errorf("INVALID olderThan: %v", err)
+		}
+		t.cutoff = cutoff
+	}
+	if cond.KnownItems > 0 {
+		fields, err := RecordKeyFields(cond.KeyField)
+		if err != nil {
+			return nil, err
+		}
+		if fields == nil {
+			if fields, err = e.recordKeyFields(jobID); err != nil {
+				return nil, err
+			}
+		}
+		if fields == nil {
+			fields = []string{"url"}
+		}
+		t.keyFields = fields
+	}
+	return t, nil
+}
+
+// CHECK THE NEXT ITEM, RETURNING WHY THE LOOP SHOULD STOP ("" TO CARRY ON). pageURL STANDS IN
+// FOR A $url KEY FIELD
+func (t *stopTracker) check(item any, pageURL string) string {
+	if !t.cutoff.IsZero() {
+		if raw := itemField(item, t.cond.DateField); raw != nil && raw != "" {
+			if date, err := t.dates.parse(raw); err == nil && date.Before(t.cutoff) {
+				return t.stop(fmt.Sprintf("ITEM DATED %s IS OLDER THAN %s", date.Format(time.RFC3339), t.cutoff.Format(time.RFC3339)))
+			}
+		}
+	}
+	if t.cond.KnownItems > 0 {
+		known := t.known(item, pageURL)
+		t.mu.Lock()
+		if known {
+			t.streak++
+		} else {
+			t.streak = 0
+		}
+		streak := t.streak
+		t.mu.Unlock()
+		if streak >= t.cond.KnownItems {
+			return t.stop(fmt.Sprintf("%d KNOWN ITEMS IN A ROW", streak))
+		}
+	}
+	return ""
+}
+
+// REMEMBER THE FIRST REASON TO STOP
+func (t *stopTracker) stop(reason string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reason == "" {
+		t.reason = reason
+	}
+	return t.reason
+}
+
+// WHY THE LOOP STOPPED ("" WHILE IT HASN'T, OR WITHOUT A CONDITION)
+func (t *stopTracker) stopped() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reason
+}
+
+// WHETHER AN EARLIER RUN SAVED A RECORD WITH THE ITEM'S KEY, OR A RECORD OR ASSET FROM ITS URL
+func (t *stopTracker) known(item any, pageURL string) bool {
+	data, _ := item.(map[string]any)
+	if data == nil {
+		data = map[string]any{"url": pageURL} // A PLAIN LIST OF LINKS
+	}
+	fields := make(map[string]any, len(t.keyFields))
+	for _, field := range t.keyFields {
+		if field != recordKeyURL {
+			fields[field] = itemField(data, field)
+		}
+	}
+	key, err := recordKey(t.keyFields, pageURL, fields)
+	if err != nil {
+		return false
+	}
+	db := t.engine.db
+	var count int64
+	db.Model(&models.Record{}).
+		Where("job_id = ? AND first_seen < ? AND (key = ? OR url = ?)", t.jobID, t.runStart, key, key).
+		Count(&count)
+	if count == 0 && strings.Contains(key, "://") {
+		db.Model(&models.Asset{}).
+			Where("job_id = ? AND created_at < ? AND url = ?", t.jobID, t.runStart, key).
+			Count(&count)
+	}
+	return count > 0
+}
+
+// A WORKER-PER-ITEM STAGE'S ITEMS UP TO WHERE ITS CONDITION STOPS IT: BEFORE THE FIRST ITEM
+// OLDER THAN THE CUTOFF, OR BEFORE A RUN OF knownItems KNOWN ITEMS
+func (e *Engine) stopItems(jobID string, stage models.Stage, cond StopCondition, items []any) ([]any, error) {
+	tracker, err := e.newStopTracker(jobID, cond)
+	if err != nil {
+		return items, err
+	}
+	for i, item := range items {
+		pageURL, isLink := item.(string)
+		if !isLink {
+			pageURL = textOf(itemField(item, "url"))
+		}
+		reason := tracker.check(item, pageURL)
+		if reason == "" {
+			continue
+		}
+		cut := i
+		if tracker.streak >= cond.KnownItems && cond.KnownItems > 0 {
+			cut = i + 1 - tracker.streak
+		}
+		e.recordEarlyExit(jobID, fmt.Sprintf("STAGE %s", stage.Name), fmt.Sprintf("%s, SKIPPED %d OF %d ITEMS", reason, len(items)-cut, len(items)))
+		return items[:cut], nil
+	}
+	return items, nil
+}
+
+// NOTE A LOOP THAT ENDED EARLY ON THE RUN'S PROGRESS AND EVENTS
+func (e *Engine) recordEarlyExit(jobID, where, reason string) {
+	log.Printf("[JOB %s] %s STOPPED EARLY: %s", jobID, where, reason)
+	e.mu.Lock()
+	if progress, ok := e.jobProgress[jobID]; ok {
+		progress.StoppedEarly = append(progress.StoppedEarly, fmt.Sprintf("%s: %s", where, reason))
+		e.jobProgress[jobID] = progress
+	}
+	e.mu.Unlock()
+	e.events.Publish("job.stopped_early", jobID, map[string]any{"where": where, "reason": reason})
+}
@@ -0,0 +1,432 @@
+package scraper
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/playwright-community/playwright-go"
+	"github.com/quic-go/quic-go"
+)
+
+// -- EGRESS --
+//
+// WHICH WAY CONNECTIONS LEAVE THE MACHINE: OVER IPV4 OR IPV6 ONLY, AND FROM A GIVEN LOCAL ADDRESS
+// OR INTERFACE (E.G. A VPN TUNNEL). DIRECT HTTP REQUESTS DIAL THAT WAY THEMSELVES. CHROMIUM CAN'T
+// BE TOLD, SO BROWSERS ARE POINTED AT A SMALL PROXY ON LOOPBACK THAT DIALS FOR THEM.
+
+// JOB RULE OVERRIDING THE EGRESS SETTINGS, E.G. {"ipVersion": "4", "interface": "wg0"}
+const egressRule = "egress"
+
+// HOW OUTBOUND CONNECTIONS ARE MADE
+type EgressPolicy struct {
+	IPVersion   string // "" (EITHER), "4" OR "6"
+	BindAddress string // LOCAL ADDRESS TO CONNECT FROM
+	Interface   string // INTERFACE TO CONNECT THROUGH (ITS ADDRESS, AND SO_BINDTODEVICE ON LINUX)
+}
+
+// POLICY FROM THE GLOBAL SETTINGS
+func EgressPolicyFromConfig(cfg *config.Config) EgressPolicy {
+	return EgressPolicy{IPVersion: cfg.Egress.IPVersion, BindAddress: cfg.Egress.BindAddress, Interface: cfg.Egress.Interface}
+}
+
+// READ AN egress RULE (OR SETTING) FROM DECODED JSON
+func ParseEgressPolicy(v any) (EgressPolicy, error) {
+	var policy EgressPolicy
+	if v == nil {
+		return policy, nil
+	}
+	raw, ok := v.(map[string]any)
+	if !ok {
+		return policy, fmt.Errorf("%w: egress MUST BE AN OBJECT", ErrInvalidInput)
+	}
+	for key, value := range raw {
+		s, ok := value.(string)
+		if !ok && value != nil {
+			return policy, fmt.Errorf("%w: egress.%s MUST BE A STRING", ErrInvalidInput, key)
+		}
+		switch key {
+		case "ipVersion":
+			policy.IPVersion = s
+		case "bindAddress":
+			policy.BindAddress = s
+		case "interface":
+			policy.Interface = s
+		default:
+			return policy, fmt.Errorf("%w: UNKNOWN egress OPTION %q", ErrInvalidInput, key)
+		}
+	}
+	return policy, policy.Validate()
+}
+
+// CHECK THE IP VERSION, ADDRESS AND INTERFACE
+func (p EgressPolicy) Validate() error {
+	switch p.IPVersion {
+	case "", "auto", "4", "6":
+	default:
+		return fmt.Errorf("%w: egress.ipVersion MUST BE 4, 6 OR auto", ErrInvalidInput)
+	}
+	if p.BindAddress != "" {
+		addr, err := netip.ParseAddr(p.BindAddress)
+		if err != nil {
+			return fmt.Errorf("%w: egress.bindAddress IS NOT AN IP: %q", ErrInvalidInput, p.BindAddress)
+		}
+		if !p.allows(addr) {
+			return fmt.Errorf("%w: egress.bindAddress %s IS NOT AN IPV%s ADDRESS", ErrInvalidInput, addr, p.IPVersion)
+		}
+	}
+	if p.Interface != "" {
+		if _, err := net.InterfaceByName(p.Interface); err != nil {
+			return fmt.Errorf("%w: UNKNOWN NETWORK INTERFACE %q", ErrInvalidInput, p.Interface)
+		}
+	}
+	return nil
+}
+
+// POLICY FOR A RUNNING JOB: THE SETTINGS, WITH EACH OPTION ITS egress RULE SETS TAKING PRECEDENCE
+func (e *Engine) egressPolicy(jobID string) EgressPolicy {
+	policy := EgressPolicyFromConfig(e.cfg)
+	v, ok := e.jobRule(jobID, egressRule)
+	if !ok {
+		return policy
+	}
+	override, err := ParseEgressPolicy(v)
+	if err != nil {
+		log.Printf("[JOB %s] IGNORING egress RULE: %v", jobID, err)
+		return policy
+	}
+	if override.IPVersion != "" {
+		policy.IPVersion = override.IPVersion
+	}
+	if override.BindAddress != "" || override.Interface != "" {
+		policy.BindAddress, policy.Interface = override.BindAddress, override.Interface
+	}
+	return policy
+}
+
+// WHETHER CONNECTIONS LEAVE HOWEVER THE SYSTEM ROUTES THEM
+func (p EgressPolicy) isDefault() bool {
+	return (p.IPVersion == "" || p.IPVersion == "auto") && p.BindAddress == "" && p.Interface == ""
+}
+
+// TRANSPORT CACHE KEY
+func (p EgressPolicy) key() string {
+	if p.isDefault() {
+		return ""
+	}
+	return p.IPVersion + "|" + p.BindAddress + "|" + p.Interface
+}
+
+// WHETHER AN ADDRESS IS OF THE FORCED IP VERSION (IPV4-MAPPED ADDRESSES COUNT AS IPV4)
+func (p EgressPolicy) allows(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	switch p.IPVersion {
+	case "4":
+		return addr.Is4()
+	case "6":
+		return addr.Is6()
+	}
+	if p.BindAddress != "" {
+		if local, err := netip.ParseAddr(p.BindAddress); err == nil {
+			return local.Unmap().Is4() == addr.Is4()
+		}
+	}
+	return true
+}
+
+// NETWORK NAME PINNED TO THE FORCED IP VERSION (tcp -> tcp4)
+func (p EgressPolicy) network(network string) string {
+	if p.IPVersion != "4" && p.IPVersion != "6" {
+		return network
+	}
+	switch network {
+	case "tcp", "udp", "ip":
+		return network + p.IPVersion
+	}
+	return network
+}
+
+// ADDRESS TO CONNECT FROM: THE BIND ADDRESS, ELSE THE INTERFACE'S ADDRESS OF THE FORCED VERSION
+// (IPV4 FIRST WHEN EITHER WILL DO). INVALID WHEN NEITHER IS SET. READ ON EVERY DIAL SO A TUNNEL
+// THAT RECONNECTS WITH A NEW ADDRESS IS PICKED UP
+func (p EgressPolicy) localAddr() (netip.Addr, error) {
+	if p.BindAddress != "" {
+		addr, err := netip.ParseAddr(p.BindAddress)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("INVALID EGRESS BIND ADDRESS: %s", p.BindAddress)
+		}
+		return addr, nil
+	}
+	if p.Interface == "" {
+		return netip.Addr{}, nil
+	}
+	ifc, err := net.InterfaceByName(p.Interface)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("EGRESS INTERFACE %s: %v", p.Interface, err)
+	}
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("EGRESS INTERFACE %s: %v", p.Interface, err)
+	}
+	var v6 netip.Addr
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok || addr.IsLinkLocalUnicast() || !p.allows(addr) {
+			continue
+		}
+		addr = addr.Unmap()
+		if addr.Is4() {
+			return addr, nil
+		}
+		if !v6.IsValid() {
+			v6 = addr
+		}
+	}
+	if v6.IsValid() {
+		return v6, nil
+	}
+	version := p.IPVersion
+	if version == "" || version == "auto" {
+		version = "4 OR IPV6"
+	}
+	return netip.Addr{}, fmt.Errorf("EGRESS INTERFACE %s HAS NO IPV%s ADDRESS", p.Interface, version)
+}
+
+// COPY OF A DIALER THAT CONNECTS FROM THE LOCAL ADDRESS AND THROUGH THE INTERFACE
+func (p EgressPolicy) dialer(base *net.Dialer, network string) (*net.Dialer, error) {
+	if p.isDefault() {
+		return base, nil
+	}
+	d := *base
+	local, err := p.localAddr()
+	if err != nil {
+		return nil, err
+	}
+	if local.IsValid() {
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: local.AsSlice()}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: local.AsSlice()}
+		}
+	}
+	if p.Interface != "" {
+		d.Control = bindToDevice(p.Interface)
+	}
+	return &d, nil
+}
+
+// ONE UDP SOCKET PER TRANSPORT FOR HTTP/3, BOUND THE SAME WAY AS TCP DIALS
+type egressSocket struct {
+	policy    EgressPolicy
+	mu        sync.Mutex
+	transport *quic.Transport
+}
+
+func (s *egressSocket) dialQUIC(ctx context.Context, target string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	remote, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.transport == nil {
+		local, err := s.policy.localAddr()
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		lc := net.ListenConfig{}
+		if s.policy.Interface != "" {
+			lc.Control = bindToDevice(s.policy.Interface)
+		}
+		bind := ":0"
+		if local.IsValid() {
+			bind = net.JoinHostPort(local.String(), "0")
+		}
+		conn, err := lc.ListenPacket(ctx, s.policy.network("udp"), bind)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("COULD NOT OPEN EGRESS UDP SOCKET: %v", err)
+		}
+		s.transport = &quic.Transport{Conn: conn}
+	}
+	transport := s.transport
+	s.mu.Unlock()
+	return transport.DialEarly(ctx, remote, tlsCfg, cfg)
+}
+
+// -- BROWSER EGRESS PROXY --
+//
+// A FORWARD PROXY ON LOOPBACK, ONE PER EGRESS/DNS/SSRF COMBINATION, THAT DIALS THE WAY A DIRECT
+// REQUEST WOULD. IT TAKES CONNECT (HTTPS, WEBSOCKETS) AND PLAIN HTTP, AND ASKS FOR A RANDOM
+// PASSWORD SO OTHER LOCAL PROCESSES CAN'T USE IT AS AN OPEN RELAY.
+
+type egressProxy struct {
+	addr      string
+	username  string
+	password  string
+	dial      dialFunc
+	transport *http.Transport
+}
+
+var (
+	egressProxiesMu sync.Mutex
+	egressProxies   = make(map[string]*egressProxy)
+)
+
+// PROXY SETTINGS THAT SEND A BROWSER'S TRAFFIC OUT THROUGH THE JOB'S EGRESS (NIL WHEN IT HAS NONE)
+func (e *Engine) browserProxy(jobID string) (*playwright.Proxy, error) {
+	egress := e.egressPolicy(jobID)
+	if egress.isDefault() {
+		return nil, nil
+	}
+	dns := e.dnsPolicy(jobID)
+	key := egress.key() + "|" + dns.key() + "|" + e.guard.cacheKey()
+
+	egressProxiesMu.Lock()
+	defer egressProxiesMu.Unlock()
+	proxy, ok := egressProxies[key]
+	if !ok {
+		var err error
+		proxy, err = startEgressProxy(dns.dialContext(e.guard, egress, &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		egressProxies[key] = proxy
+	}
+	return &playwright.Proxy{
+		Server:   "http://" + proxy.addr,
+		Username: playwright.String(proxy.username),
+		Password: playwright.String(proxy.password),
+	}, nil
+}
+
+// LISTEN ON A LOOPBACK PORT AND SERVE UNTIL THE PROCESS EXITS
+func startEgressProxy(dial dialFunc) (*egressProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("COULD NOT START EGRESS PROXY: %v", err)
+	}
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	proxy := &egressProxy{
+		addr:     listener.Addr().String(),
+		username: "crepes",
+		password: hex.EncodeToString(secret),
+		dial:     dial,
+		transport: &http.Transport{
+			DialContext:       dial,
+			Proxy:             nil,
+			IdleConnTimeout:   90 * time.Second,
+			ForceAttemptHTTP2: false,
+		},
+	}
+	server := &http.Server{Handler: proxy, ReadHeaderTimeout: 30 * time.Second}
+	go server.Serve(listener)
+	log.Printf("EGRESS PROXY LISTENING ON %s", proxy.addr)
+	return proxy, nil
+}
+
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="crepes"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if r.URL.Host == "" {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, h := range []string{"Proxy-Authorization", "Proxy-Connection", "Connection", "Keep-Alive", "Te", "Trailer", "Upgrade"} {
+		out.Header.Del(h)
+	}
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// CONNECT: DIAL THE TARGET AND PIPE BYTES BOTH WAYS
+func (p *egressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	done := make(chan struct{}, 2)
+	go func() {
+		// BYTES THE CLIENT SENT RIGHT AFTER THE CONNECT ARE ALREADY BUFFERED
+		io.Copy(upstream, buffered.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	client.Close()
+	upstream.Close()
+	<-done
+}
+
+// CHECK THE BASIC PROXY CREDENTIALS
+func (p *egressProxy) authorized(r *http.Request) bool {
+	encoded, ok := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	want := p.username + ":" + p.password
+	return subtle.ConstantTimeCompare(decoded, []byte(want)) == 1
+}
@@ -0,0 +1,30 @@
+package scraper
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"syscall"
+)
+
+var bindToDeviceWarning sync.Once
+
+// PIN A SOCKET TO AN INTERFACE. WITHOUT CAP_NET_RAW (OLDER KERNELS) THE SOCKET STILL LEAVES FROM
+// THE INTERFACE'S ADDRESS, WHICH IS ENOUGH WHEN ROUTING FOLLOWS THE SOURCE ADDRESS
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); err != nil {
+			return err
+		}
+		if errors.Is(sockErr, syscall.EPERM) {
+			bindToDeviceWarning.Do(func() {
+				log.Printf("NOT ALLOWED TO BIND SOCKETS TO %s, USING ITS ADDRESS ONLY", iface)
+			})
+			return nil
+		}
+		return sockErr
+	}
+}
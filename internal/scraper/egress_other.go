@@ -0,0 +1,10 @@
+//go:build !linux
+
+package scraper
+
+import "syscall"
+
+// SOCKETS ARE ONLY PINNED TO AN INTERFACE ON LINUX; ELSEWHERE THEY LEAVE FROM ITS ADDRESS
+func bindToDevice(string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
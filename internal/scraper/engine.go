@@ -1,11 +1,14 @@
 package scraper
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -13,7 +16,9 @@ import (
 	"github.com/google/uuid"
 	"github.com/nickheyer/Crepes/internal/config"
 	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
 	"github.com/playwright-community/playwright-go"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"gorm.io/gorm"
 )
 
@@ -21,6 +26,7 @@ import (
 var (
 	ErrPlaywrightNotInitialized = errors.New("PLAYWRIGHT NOT INITIALIZED")
 	ErrJobAlreadyRunning        = errors.New("JOB IS ALREADY RUNNING")
+	ErrJobQueued                = errors.New("JOB IS QUEUED")
 	ErrJobNotFound              = errors.New("JOB NOT FOUND")
 	ErrBrowserCreation          = errors.New("FAILED TO CREATE BROWSER")
 	ErrPageCreation             = errors.New("FAILED TO CREATE PAGE")
@@ -37,13 +43,46 @@ type Engine struct {
 	jobProgress     map[string]JobProgress
 	jobStartTimes   map[string]time.Time
 	jobDurations    map[string]time.Duration
+	jobRules        map[string]models.JSONMap
+	jobSettings     map[string]*JobSettings       // RESOLVED SETTINGS OF RUNNING JOBS
+	recordSchemas   map[string]*jsonschema.Schema // COMPILED ON FIRST USE PER RUN
+	assetWorkers    map[string]*Worker
+	budgets         map[string]*runBudget        // CAPS OF RUNS WITH A budget RULE
+	throttles       map[string]*runThrottle      // PER-HOST PACING OF RUNS WITH A throttle RULE
+	sessions        map[string]*runSession       // COOKIES AND LOCAL STORAGE OF RUNS WITH A persistSession RULE
+	playbacks       map[string]http.RoundTripper // REPLAY TRANSPORTS OF RUNS THAT DON'T TOUCH THE NETWORK
+	runStates       map[string]*runState         // KEY-VALUE STATE OF EACH RUN
+	timelines       map[string]*runTimeline      // SPANS OF EACH RUN, FOR ITS TIMELINE
+	jobStateMu      sync.Mutex                   // SERIALIZES UPDATES TO PERSISTENT JOB STATE
 	mu              sync.Mutex
 	playwright      *playwright.Playwright
-	browserPool     chan browserInstance
+	browsers        *browserPool // EVERY BROWSER LAUNCHED, AGAINST THE POOL'S LIMITS
 	initialized     bool
+	initErr         error // WHY PLAYWRIGHT LAST FAILED TO START
 	initMu          sync.Mutex
+	env             RuntimeEnvironment // CONTAINER, SANDBOX AND BROWSER INSTALL DECISIONS
 	taskRegistry    *TaskRegistry
 	resourceManager *ResourceManager
+	downloads       *DownloadScheduler
+	transfers       *DownloadTracker
+	events          *EventBus
+	notifier        *Notifier
+	auth            *AuthManager
+	cursors         *cursorTracker // LAST MOUSE POSITION PER PAGE, FOR HUMANIZED MOVES
+	origins         *pageOrigins   // PAGE THAT EXTRACTED EACH URL, FOR DOWNLOADS
+	assetPaths      *pathClaims    // FILES DOWNLOADS IN FLIGHT ARE WRITING
+	guard           *NetGuard      // SSRF PROTECTION (NIL WHEN OFF)
+	leaks           leakCounters   // BROWSERS AND PAGES PIPELINES LEFT OPEN
+	memory          *memoryWatchdog
+	wayback         *WaybackSubmitter
+	thumbnails      *ThumbnailQueue
+	uploads         *AssetUploader     // FINISHED ASSETS ON THEIR WAY TO A BUCKET
+	maintenance     maintenanceState   // DATABASE AND STORAGE UPKEEP
+	userAgents      userAgentUpdates   // REFRESHING THE USER AGENT POOL FROM A SIGNED MANIFEST
+	appLog          *utils.RotatingLog // THE APPLICATION LOG FILE (NIL WHEN NOT LOGGING TO ONE)
+	queue           []QueuedRun
+	recentErrors    []JobError
+	queueStop       chan struct{}
 }
 
 // JOB PROGRESS TRACKING
@@ -55,24 +94,50 @@ type JobProgress struct {
 	Status         string              `json:"status"`
 	Errors         []string            `json:"errors"`
 	Assets         int                 `json:"assets"`
-	TaskResults    map[string]TaskData `json:"taskResults"` // Store task outputs for use as inputs to other tasks
+	RunID          string              `json:"runId"`
+	Records        int                 `json:"records"`
+	RecordsUpdated int                 `json:"recordsUpdated"` // KNOWN KEYS WHOSE DATA CHANGED
+	RecordsInvalid int                 `json:"recordsInvalid"` // REJECTED BY THE JOB'S RECORD SCHEMA OR KEY
+	RecordsDupes   int                 `json:"recordsDuplicate"`
+	RecordsPII     int                 `json:"recordsPii"` // HAD PII FOUND BY THE JOB'S pii RULE
+	Relogins       int                 `json:"relogins"`   // TIMES THE LOGIN STAGE RAN AGAIN AFTER THE SESSION EXPIRED
+	AssetQueue     WorkerStats         `json:"assetQueue"`
+	Leaked         int                 `json:"leakedResources"` // BROWSERS AND PAGES THE PIPELINE LEFT OPEN, CLOSED WHEN THE RUN ENDED
+	Budget         *BudgetUsage        `json:"budget,omitempty"`
+	Throttle       []HostPace          `json:"throttle,omitempty"`     // PER-HOST PACING WITH A throttle RULE
+	Sample         int                 `json:"sample,omitempty"`       // ITEMS PER STAGE IN A SAMPLE RUN
+	State          map[string]any      `json:"state,omitempty"`        // THE RUN'S setState/incrementState VALUES
+	PreviousRun    *time.Time          `json:"previousRun,omitempty"`  // WHEN THE JOB'S LAST RUN STARTED, FOR stopWhen's lastRun
+	StoppedEarly   []string            `json:"stoppedEarly,omitempty"` // LOOPS ENDED BY THEIR stopWhen CONDITION, AND WHY
+	ItemsProcessed int                 `json:"itemsProcessed"`         // ITEMS WORKER-PER-ITEM AND FOR-EACH STAGES HAVE FINISHED
+	ItemsFailed    int                 `json:"itemsFailed"`            // OF THOSE, THE ONES THAT FAILED (SEE /jobs/{id}/items)
+	TaskResults    map[string]TaskData `json:"taskResults"`            // Store task outputs for use as inputs to other tasks
 }
 
-// BROWSER INSTANCE
-type browserInstance struct {
-	browser *playwright.Browser
+// AN ERROR RECORDED WHILE RUNNING A JOB
+type JobError struct {
+	JobID   string    `json:"jobId"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
 }
 
+// HOW MANY ERRORS RecentErrors CAN RETURN
+const maxRecentErrors = 200
+
 // RESOURCE MANAGER HANDLES JOB RESOURCES
 type ResourceManager struct {
 	mu        sync.Mutex
-	resources map[string]map[string]any // Job ID -> Resource ID -> Resource
+	resources map[string]map[string]any            // Job ID -> Resource ID -> Resource
+	recipes   map[string]map[string]resourceRecipe // HOW BROWSERS AND PAGES WERE MADE, TO REMAKE THEM AFTER A CRASH
+	touched   map[string]map[string]time.Time      // LAST USE, FOR CLOSING IDLE BROWSERS AND PAGES
 }
 
 // NEW RESOURCE MANAGER
 func NewResourceManager() *ResourceManager {
 	return &ResourceManager{
 		resources: make(map[string]map[string]any),
+		recipes:   make(map[string]map[string]resourceRecipe),
+		touched:   make(map[string]map[string]time.Time),
 	}
 }
 
@@ -88,6 +153,7 @@ func (rm *ResourceManager) CreateResource(jobID, resourceID, resourceType string
 
 	// STORE THE RESOURCE
 	rm.resources[jobID][resourceID] = value
+	rm.touch(jobID, resourceID)
 }
 
 // GET A RESOURCE
@@ -103,6 +169,9 @@ func (rm *ResourceManager) GetResource(jobID, resourceID string) (any, bool) {
 
 	// GET THE RESOURCE
 	resource, ok := jobResources[resourceID]
+	if ok {
+		rm.touch(jobID, resourceID)
+	}
 	return resource, ok
 }
 
@@ -119,6 +188,8 @@ func (rm *ResourceManager) DeleteResource(jobID, resourceID string) {
 
 	// DELETE THE RESOURCE
 	delete(jobResources, resourceID)
+	delete(rm.recipes[jobID], resourceID)
+	delete(rm.touched[jobID], resourceID)
 }
 
 // DELETE ALL JOB RESOURCES
@@ -128,6 +199,8 @@ func (rm *ResourceManager) DeleteJobResources(jobID string) {
 
 	// DELETE ALL RESOURCES FOR JOB
 	delete(rm.resources, jobID)
+	delete(rm.recipes, jobID)
+	delete(rm.touched, jobID)
 }
 
 // TASK DATA FOR INPUT/OUTPUT
@@ -141,11 +214,18 @@ type TaskContext struct {
 	JobID           string
 	ResourceManager *ResourceManager
 	TaskResults     map[string]TaskData
+	Inputs          []TaskInput // OUTPUTS OF THE TASK'S inputRefs, IN ORDER
 	Engine          *Engine
 	Context         context.Context
 	Logger          *log.Logger
 }
 
+// ONE inputRef'S OUTPUT
+type TaskInput struct {
+	Ref  string
+	Data TaskData
+}
+
 // TASK IMPLEMENTATION INTERFACE
 type TaskImplementation interface {
 	Execute(ctx *TaskContext, config map[string]any) (TaskData, error)
@@ -213,13 +293,39 @@ func NewEngine(db *gorm.DB, cfg *config.Config) *Engine {
 		jobProgress:     make(map[string]JobProgress),
 		jobStartTimes:   make(map[string]time.Time),
 		jobDurations:    make(map[string]time.Duration),
+		jobRules:        make(map[string]models.JSONMap),
+		jobSettings:     make(map[string]*JobSettings),
+		recordSchemas:   make(map[string]*jsonschema.Schema),
+		assetWorkers:    make(map[string]*Worker),
+		budgets:         make(map[string]*runBudget),
+		throttles:       make(map[string]*runThrottle),
+		sessions:        make(map[string]*runSession),
+		playbacks:       make(map[string]http.RoundTripper),
+		runStates:       make(map[string]*runState),
+		timelines:       make(map[string]*runTimeline),
 		mu:              sync.Mutex{},
-		browserPool:     make(chan browserInstance, cfg.MaxConcurrent),
+		browsers:        newBrowserPool(cfg),
 		initialized:     false,
 		initMu:          sync.Mutex{},
 		taskRegistry:    taskRegistry,
 		resourceManager: resourceManager,
+		downloads:       NewDownloadScheduler(cfg.MaxDownloadWorkers, cfg.MaxConnsPerHost),
+		events:          NewEventBus(),
+		queueStop:       make(chan struct{}),
 	}
+	engine.transfers = NewDownloadTracker(engine.events)
+	engine.transfers.finished = engine.recordDownload
+	engine.wayback = NewWaybackSubmitter(cfg, engine.events)
+	engine.uploads = NewAssetUploader(db, cfg, engine.events)
+	engine.thumbnails = NewThumbnailQueue(db, cfg, engine.events, engine.uploads)
+	engine.notifier = NewNotifier(cfg, engine.events)
+	engine.guard = NetGuardFromConfig(cfg)
+	engine.auth = NewAuthManager(engine.guard)
+	engine.cursors = newCursorTracker()
+	engine.origins = newPageOrigins()
+	engine.assetPaths = newPathClaims()
+	engine.memory = newMemoryWatchdog()
+	engine.loadUserAgentManifest()
 
 	// INIT PLAYWRIGHT
 	log.Printf("INITIALIZING PLAYWRIGHT FOR ENGINE")
@@ -232,6 +338,18 @@ func NewEngine(db *gorm.DB, cfg *config.Config) *Engine {
 	// REGISTER TASK IMPLEMENTATIONS
 	engine.registerTasks()
 
+	// START QUEUED RUNS AS LIMITS AND BLACKOUT WINDOWS CLEAR
+	go engine.queueLoop()
+
+	// CLOSE BROWSERS AND PAGES LEFT IDLE
+	go engine.leakLoop()
+
+	// EASE OFF BEFORE THE OOM KILLER STEPS IN
+	go engine.memoryLoop()
+
+	// DELETE DATA PAST ITS JOB'S RETENTION PERIOD
+	go engine.retentionLoop()
+
 	return engine
 }
 
@@ -244,6 +362,7 @@ func (e *Engine) registerTasks() {
 	e.taskRegistry.RegisterTask("reload", &ReloadTask{})
 	e.taskRegistry.RegisterTask("waitForLoad", &WaitForLoadTask{})
 	e.taskRegistry.RegisterTask("takeScreenshot", &TakeScreenshotTask{})
+	e.taskRegistry.RegisterTask("screenshotElements", &ScreenshotElementsTask{})
 	e.taskRegistry.RegisterTask("executeScript", &ExecuteScriptTask{})
 
 	// INTERACTION TASKS
@@ -252,22 +371,61 @@ func (e *Engine) registerTasks() {
 	e.taskRegistry.RegisterTask("select", &SelectTask{})
 	e.taskRegistry.RegisterTask("hover", &HoverTask{})
 	e.taskRegistry.RegisterTask("scroll", &ScrollTask{})
+	e.taskRegistry.RegisterTask("generateTOTP", &GenerateTOTPTask{})
 
 	// EXTRACTION TASKS
 	e.taskRegistry.RegisterTask("extractText", &ExtractTextTask{})
 	e.taskRegistry.RegisterTask("extractAttribute", &ExtractAttributeTask{})
 	e.taskRegistry.RegisterTask("extractLinks", &ExtractLinksTask{})
 	e.taskRegistry.RegisterTask("extractImages", &ExtractImagesTask{})
+	e.taskRegistry.RegisterTask("extractRecords", &ExtractRecordsTask{})
 
 	// ASSET TASKS
 	e.taskRegistry.RegisterTask("downloadAsset", &DownloadAssetTask{})
 	e.taskRegistry.RegisterTask("saveAsset", &SaveAssetTask{})
+	e.taskRegistry.RegisterTask("saveRecords", &SaveRecordsTask{})
+	e.taskRegistry.RegisterTask("watchPage", &WatchPageTask{})
+	e.taskRegistry.RegisterTask("captureLiveStream", &CaptureLiveStreamTask{})
+	e.taskRegistry.RegisterTask("archiveSite", &ArchiveSiteTask{})
+
+	// API TASKS
+	e.taskRegistry.RegisterTask("httpRequest", &HTTPRequestTask{})
+	e.taskRegistry.RegisterTask("graphqlQuery", &GraphQLQueryTask{})
 
 	// FLOW CONTROL TASKS
 	e.taskRegistry.RegisterTask("conditional", &ConditionalTask{})
 	e.taskRegistry.RegisterTask("loop", &LoopTask{})
 	e.taskRegistry.RegisterTask("wait", &WaitTask{})
 
+	// DATA TASKS
+	e.taskRegistry.RegisterTask("merge", &MergeTask{})
+	e.taskRegistry.RegisterTask("zip", &ZipTask{})
+	e.taskRegistry.RegisterTask("flatten", &FlattenTask{})
+	e.taskRegistry.RegisterTask("sortItems", &SortItemsTask{})
+	e.taskRegistry.RegisterTask("uniqueItems", &UniqueItemsTask{})
+	e.taskRegistry.RegisterTask("sliceItems", &SliceItemsTask{})
+	e.taskRegistry.RegisterTask("sampleItems", &SampleItemsTask{})
+
+	// TEXT TASKS
+	e.taskRegistry.RegisterTask("splitText", &SplitTextTask{})
+	e.taskRegistry.RegisterTask("joinText", &JoinTextTask{})
+	e.taskRegistry.RegisterTask("replaceText", &ReplaceTextTask{})
+	e.taskRegistry.RegisterTask("templateText", &TemplateTextTask{})
+	e.taskRegistry.RegisterTask("slugify", &SlugifyTask{})
+	e.taskRegistry.RegisterTask("parseUrl", &ParseURLTask{})
+
+	// RUN STATE TASKS
+	e.taskRegistry.RegisterTask("setState", &SetStateTask{})
+	e.taskRegistry.RegisterTask("getState", &GetStateTask{})
+	e.taskRegistry.RegisterTask("incrementState", &IncrementStateTask{})
+
+	// DATE TASKS
+	e.taskRegistry.RegisterTask("parseDate", &ParseDateTask{})
+	e.taskRegistry.RegisterTask("formatDate", &FormatDateTask{})
+	e.taskRegistry.RegisterTask("offsetDate", &OffsetDateTask{})
+	e.taskRegistry.RegisterTask("compareDates", &CompareDatesTask{})
+	e.taskRegistry.RegisterTask("filterByDate", &FilterByDateTask{})
+
 	// RESOURCE TASKS
 	e.taskRegistry.RegisterTask("createBrowser", &CreateBrowserTask{})
 	e.taskRegistry.RegisterTask("createPage", &CreatePageTask{})
@@ -280,51 +438,46 @@ func (e *Engine) initPlaywright() error {
 	e.initMu.Lock()
 	defer e.initMu.Unlock()
 
-	log.Printf("PLAYWRIGHT INITIALIZING WITH %d BROWSERS IN POOL", len(e.browserPool))
+	log.Printf("PLAYWRIGHT INITIALIZING")
 
 	// AVOID DOUBLE INITIALIZATION
 	if e.initialized {
-		log.Printf("PLAYWRIGHT WAS ALREADY INITIALIZED WITH %d BROWSERS IN POOL", len(e.browserPool))
+		log.Printf("PLAYWRIGHT WAS ALREADY INITIALIZED")
 		return nil
 	}
 
-	// INSTALL PLAYWRIGHT IF NEEDED
-	if err := playwright.Install(); err != nil {
-		log.Printf("COULD NOT INSTALL PLAYWRIGHT: %v", err)
-		return err
-	}
-
-	// START PLAYWRIGHT
+	// INSTALL WHAT'S MISSING AND START PLAYWRIGHT
+	e.env = DetectRuntime(e.cfg)
+	logRuntime(e.env)
 	log.Printf("STARTING PLAYWRIGHT")
-	pw, err := playwright.Run()
+	pw, err := startPlaywright(e.env)
 	if err != nil {
 		log.Printf("COULD NOT START PLAYWRIGHT: %v", err)
+		e.initErr = err
 		return err
 	}
 
 	e.playwright = pw
 	e.initialized = true
-	log.Printf("PLAYWRIGHT INITIALIZED WITH %d BROWSERS IN POOL", len(e.browserPool))
+	e.initErr = nil
+	log.Printf("PLAYWRIGHT INITIALIZED")
 	return nil
 }
 
-// ENSURE PLAYWRIGHT IS INITIALIZED
+// ENSURE PLAYWRIGHT IS INITIALIZED (initPlaywright CHECKS AGAIN UNDER THE LOCK)
 func (e *Engine) ensureInitialized() error {
-	log.Printf("PLAYWRIGHT INIT CHECK STARTED")
-	if !e.initialized {
-		e.initMu.Lock()
-		defer e.initMu.Unlock()
-		if !e.initialized {
-			log.Printf("INITIALIZING PLAYWRIGHT")
-			return e.initPlaywright()
-		}
+	e.initMu.Lock()
+	initialized := e.initialized
+	e.initMu.Unlock()
+	if initialized {
+		return nil
 	}
-	log.Printf("PLAYWRIGHT ALREADY INITIALIZED")
-	return nil
+	log.Printf("INITIALIZING PLAYWRIGHT")
+	return e.initPlaywright()
 }
 
-// LAUNCH BROWSER WITH STEALTH MODE
-func (e *Engine) launchBrowser(headless bool) (*playwright.Browser, error) {
+// LAUNCH BROWSER WITH STEALTH MODE, ONCE THE POOL HAS ROOM FOR IT (ctx ENDS THE WAIT)
+func (e *Engine) launchBrowser(ctx context.Context, jobID string, headless bool) (*playwright.Browser, error) {
 	log.Printf("LAUNCHING BROWSER (HEADLESS: %v)", headless)
 	if err := e.ensureInitialized(); err != nil {
 		log.Printf("PLAYWRIGHT INIT CHECK FAILED: %v", err)
@@ -336,72 +489,173 @@ func (e *Engine) launchBrowser(headless bool) (*playwright.Browser, error) {
 		return nil, ErrPlaywrightNotInitialized
 	}
 
-	// LAUNCH BROWSER WITH STEALTH OPTIONS
+	if err := e.browsers.reserve(ctx); err != nil {
+		return nil, fmt.Errorf("COULD NOT LAUNCH BROWSER: WAITING FOR THE BROWSER POOL: %w", err)
+	}
+	launched := false
+	defer func() {
+		if !launched {
+			e.browsers.abandon()
+		}
+	}()
+
+	// LAUNCH BROWSER WITH STEALTH OPTIONS (SANDBOX, SHARED MEMORY AND EXECUTABLE PER ENVIRONMENT)
+	// THE LAUNCH TAG LETS THE MEMORY WATCHDOG FIND THE BROWSER'S PROCESSES
 	log.Printf("OPENING BROWSER")
-	browser, err := e.playwright.Chromium.Launch(playwright.BrowserTypeLaunchOptions{
-		Headless: playwright.Bool(headless),
-		Args: []string{
-			"--disable-gpu",
-			"--disable-dev-shm-usage",
-			"--disable-setuid-sandbox",
-			"--no-sandbox",
-			"--disable-blink-features=AutomationControlled",
-			"--disable-features=IsolateOrigins,site-per-process",
-			"--disable-site-isolation-trials",
-			"--ignore-certificate-errors",
-			"--disable-web-security",
-			"--allow-running-insecure-content",
-		},
-	})
+	options := launchOptions(e.env, headless)
+	tag := uuid.New().String()
+	options.Args = append(options.Args, launchTagFlag+"="+tag)
+
+	// THE JOB'S PINNED HOSTS AND DOH ENDPOINT (SEE dns.go)
+	dns := e.dnsPolicy(jobID)
+	options.Args = append(options.Args, dns.browserArgs()...)
+	// AN EGRESS SETTING SENDS THE BROWSER THROUGH A LOCAL PROXY THAT DIALS (AND RESOLVES) FOR IT
+	proxy, err := e.browserProxy(jobID)
+	if err != nil {
+		return nil, err
+	}
+	// A PROXY FROM THE JOB'S SETTINGS TAKES ITS PLACE
+	if settingsProxy := e.runSettings(jobID).browserProxy(); settingsProxy != nil {
+		if proxy != nil {
+			log.Printf("BROWSER USES THE JOB'S PROXY; ITS EGRESS SETTINGS ONLY APPLY TO DIRECT REQUESTS")
+		}
+		proxy = settingsProxy
+	}
+	options.Proxy = proxy
+	if dns.Resolver != "" && proxy == nil {
+		log.Printf("DNS RESOLVER %s ONLY APPLIES TO DIRECT REQUESTS, THE BROWSER USES THE SYSTEM'S", dns.Resolver)
+	}
+	browser, err := e.playwright.Chromium.Launch(options)
 
 	if err != nil {
 		log.Printf("BROWSER LAUNCH FAILED: %v", err)
 		return nil, fmt.Errorf("COULD NOT LAUNCH BROWSER: %v", err)
 	}
+	e.memory.track(tag, browser)
+	e.browsers.add(browser, jobID)
+	launched = true
 
 	log.Printf("BROWSER LAUNCHED SUCCESSFULLY")
 	return &browser, nil
 }
 
-// RUN JOB
+// OPTIONS FOR ONE RUN OF A JOB
+type RunOptions struct {
+	Sample   int               // CAP EACH WORKER-PER-ITEM STAGE AND CRAWL FRONTIER AT THIS MANY ITEMS (0 FOR A FULL RUN)
+	Replay   http.RoundTripper // ANSWER EVERY REQUEST FROM THIS INSTEAD OF THE NETWORK (SEE playback.go)
+	Priority string            // QUEUE PRIORITY IF THE RUN HAS TO WAIT ("" FOR THE JOB'S priority RULE)
+}
+
+// RUN JOB (OR QUEUE IT WHEN A CONCURRENCY LIMIT OR BLACKOUT WINDOW APPLIES)
 func (e *Engine) RunJob(jobID string) error {
+	return e.RunJobWithOptions(jobID, RunOptions{})
+}
+
+// RUN JOB WITH OPTIONS, E.G. AS A SAMPLE RUN FOR CHECKING A NEW PIPELINE
+func (e *Engine) RunJobWithOptions(jobID string, opts RunOptions) error {
 	log.Printf("STARTING JOB %s", jobID)
 	if err := e.ensureInitialized(); err != nil {
 		log.Printf("PLAYWRIGHT NOT INITIALIZED FOR JOB %s: %v", jobID, err)
 		return err
 	}
 
+	// GET JOB FROM DATABASE
+	var job models.Job
+	if err := e.db.First(&job, "id = ?", jobID).Error; err != nil {
+		log.Printf("JOB %s NOT FOUND: %v", jobID, err)
+		return fmt.Errorf("FAILED TO FIND JOB: %v", err)
+	}
+
 	e.mu.Lock()
-	// CHECK IF JOB IS ALREADY RUNNING
+	// CHECK IF JOB IS ALREADY RUNNING OR WAITING
 	if _, running := e.runningJobs[jobID]; running {
 		log.Printf("JOB %s IS ALREADY RUNNING", jobID)
 		e.mu.Unlock()
 		return ErrJobAlreadyRunning
 	}
+	if e.queuedIndex(jobID) >= 0 {
+		e.mu.Unlock()
+		return ErrJobQueued
+	}
+	group := jobGroup(job.Rules)
+	if reason, detail := e.waitReason(group, time.Now()); reason != "" {
+		priority := opts.Priority
+		if !slices.Contains(QueuePriorities, priority) {
+			priority = jobPriority(job.Rules)
+		}
+		position := e.enqueue(QueuedRun{
+			JobID:    jobID,
+			Name:     job.Name,
+			Group:    group,
+			Priority: priority,
+			QueuedAt: time.Now(),
+			Reason:   reason,
+			Detail:   detail,
+			Sample:   opts.Sample,
+			replay:   opts.Replay,
+		})
+		e.mu.Unlock()
+		log.Printf("JOB %s QUEUED AT %s PRIORITY (POSITION %d): %s", jobID, strings.ToUpper(priority), position, detail)
+		e.updateJobStatus(jobID, "queued")
+		return ErrJobQueued
+	}
+	// RESERVE THE SLOT SO A CONCURRENT START CAN'T SLIP IN BEFORE THE JOB IS REGISTERED; THE RUN'S
+	// OWN CANCEL HOLDS IT, SO A STOP THAT ARRIVES WHILE THE JOB IS STARTING STILL STOPS IT
+	runCtx, stop := context.WithCancel(context.Background())
+	e.runningJobs[jobID] = stop
+	e.jobRules[jobID] = job.Rules
 	e.mu.Unlock()
 
-	// GET JOB FROM DATABASE
-	var job models.Job
-	if err := e.db.First(&job, "id = ?", jobID).Error; err != nil {
-		log.Printf("JOB %s NOT FOUND: %v", jobID, err)
-		return fmt.Errorf("FAILED TO FIND JOB: %v", err)
+	e.startJob(runCtx, &job, opts)
+	return nil
+}
+
+// START A JOB WHOSE RUNNING SLOT IS ALREADY RESERVED; CANCELLING runCtx (THE SLOT'S CANCEL) STOPS THE RUN
+func (e *Engine) startJob(runCtx context.Context, job *models.Job, opts RunOptions) {
+	jobID := job.ID
+
+	// A JOB FROM BEFORE PIPELINES RUNS AS THE PIPELINE ITS SELECTORS DESCRIBE (NOT SAVED)
+	if IsLegacyJob(job) {
+		if conversion, err := ConvertLegacyJob(job); err != nil {
+			log.Printf("JOB %s HAS LEGACY SELECTORS THAT DON'T CONVERT: %v", jobID, err)
+		} else if pipeline, err := json.Marshal(conversion.Stages); err == nil {
+			log.Printf("JOB %s HAS LEGACY SELECTORS, RUNNING THEIR CONVERSION (POST /api/jobs/%s/migrate TO SAVE IT)", jobID, jobID)
+			job.Pipeline = string(pipeline)
+			job.Rules = conversion.Rules
+		}
+	}
+
+	// THE PREVIOUS RUN'S START, BEFORE last_run MOVES TO THIS ONE
+	var previousRun *time.Time
+	if !job.LastRun.IsZero() {
+		lastRun := job.LastRun
+		previousRun = &lastRun
 	}
 
 	// UPDATE JOB STATUS
 	log.Printf("UPDATING JOB %s STATUS TO RUNNING", jobID)
-	e.db.Model(&job).Updates(map[string]any{
+	e.db.Model(job).Updates(map[string]any{
 		"status":   "running",
 		"last_run": time.Now(),
 	})
 
+	// THE JOB'S SETTINGS AFTER ITS OWN AND ITS FOLDERS' OVERRIDES
+	settings, settingsErr := ResolveJobSettings(e.db, e.cfg, job)
+
 	// CREATE CONTEXT WITH TIMEOUT
-	timeout := time.Duration(e.cfg.DefaultTimeout) * time.Millisecond
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	timeout := time.Duration(settings.Timeout) * time.Millisecond
+	ctx, cancel := context.WithTimeout(runCtx, timeout)
+	ctx = context.WithValue(ctx, settingsKey{}, &settings)
 
 	// RECORD JOB START
 	e.mu.Lock()
 	e.runningJobs[jobID] = cancel
 	e.jobStartTimes[jobID] = time.Now()
+	e.jobRules[jobID] = job.Rules
+	e.jobSettings[jobID] = &settings
+	e.assetWorkers[jobID] = NewWorker(settings.Concurrency)
+	e.runStates[jobID] = newRunState()
+	e.transfers.Clear(jobID)
 
 	// INITIALIZE JOB PROGRESS
 	e.jobProgress[jobID] = JobProgress{
@@ -413,15 +667,69 @@ func (e *Engine) RunJob(jobID string) error {
 		Errors:         []string{},
 		Assets:         0,
 		TaskResults:    make(map[string]TaskData),
+		RunID:          generateID("run"),
+		Sample:         opts.Sample,
+		PreviousRun:    previousRun,
 	}
+	if runCtx.Err() != nil {
+		// STOPPED WHILE STARTING: ctx IS ALREADY DONE, SO THE RUN ENDS LIKE ANY OTHER STOPPED RUN
+		progress := e.jobProgress[jobID]
+		progress.Status = "stopped"
+		e.jobProgress[jobID] = progress
+	}
+	e.startTimeline(jobID, e.jobProgress[jobID].RunID)
 	e.mu.Unlock()
+	if opts.Sample > 0 {
+		log.Printf("JOB %s IS A SAMPLE RUN OF %d ITEMS PER STAGE", jobID, opts.Sample)
+	}
+	if settingsErr != nil {
+		log.Printf("[JOB %s] IGNORING INVALID SETTINGS: %v", jobID, settingsErr)
+		e.addJobError(jobID, fmt.Sprintf("Invalid settings: %v", settingsErr))
+	}
+
+	// HARD CAPS ON THE RUN; HITTING ONE CANCELS ctx
+	if budget, err := ParseBudgetRule(job.Rules[budgetRule]); err != nil {
+		log.Printf("[JOB %s] IGNORING INVALID BUDGET RULE: %v", jobID, err)
+		e.addJobError(jobID, fmt.Sprintf("Invalid budget rule: %v", err))
+	} else if budget != nil {
+		ctx = e.startBudget(ctx, jobID, *budget)
+	}
+
+	// PER-HOST PACING THAT BACKS OFF WHEN A SITE SLOWS DOWN OR ERRORS
+	if throttle, err := ParseThrottleRule(job.Rules[throttleRule]); err != nil {
+		log.Printf("[JOB %s] IGNORING INVALID THROTTLE RULE: %v", jobID, err)
+		e.addJobError(jobID, fmt.Sprintf("Invalid throttle rule: %v", err))
+	} else if throttle != nil {
+		ctx = e.startThrottle(ctx, jobID, *throttle)
+	}
+
+	// COOKIES AND LOCAL STORAGE FROM THE JOB'S LAST RUN
+	if persist, _ := job.Rules[persistSessionRule].(bool); persist {
+		var err error
+		if ctx, err = e.startSession(ctx, jobID); err != nil {
+			log.Printf("[JOB %s] STARTING WITHOUT THE STORED SESSION: %v", jobID, err)
+			e.addJobError(jobID, fmt.Sprintf("Stored session not restored: %v", err))
+		}
+	}
+
+	// RECORDED RESPONSES IN PLACE OF THE NETWORK: THE CALLER'S, OR THE JOB'S CASSETTE
+	if opts.Replay != nil {
+		log.Printf("[JOB %s] REPLAYING RECORDED RESPONSES", jobID)
+		ctx = e.startPlayback(ctx, jobID, opts.Replay)
+	} else if mode, err := ParseCassetteRule(job.Rules[cassetteRule]); err != nil {
+		log.Printf("[JOB %s] IGNORING INVALID CASSETTE RULE: %v", jobID, err)
+		e.addJobError(jobID, fmt.Sprintf("Invalid cassette rule: %v", err))
+	} else if mode != "" {
+		if ctx, err = e.startCassette(ctx, jobID, mode); err != nil {
+			log.Printf("[JOB %s] CASSETTE NOT LOADED: %v", jobID, err)
+			e.addJobError(jobID, fmt.Sprintf("Cassette not loaded: %v", err))
+		}
+	}
 
 	log.Printf("JOB %s REGISTERED AND STARTING", jobID)
 
 	// RUN JOB IN GOROUTINE WITH IMPROVED ERROR HANDLING
-	go e.executePipeline(ctx, cancel, jobID, &job)
-
-	return nil
+	go e.executePipeline(ctx, cancel, jobID, job)
 }
 
 // EXECUTE JOB PIPELINE
@@ -429,6 +737,13 @@ func (e *Engine) executePipeline(ctx context.Context, cancel context.CancelFunc,
 	defer cancel()
 	defer e.finishJob(jobID)
 
+	// A RUN STOPPED BY ITS BUDGET ENDS CLEANLY RATHER THAN AS CANCELLED OR FAILED
+	defer func() {
+		if reason := e.budgetExceeded(jobID); reason != "" {
+			e.updateJobStatus(jobID, StatusBudgetExceeded)
+		}
+	}()
+
 	log.Printf("JOB %s PIPELINE EXECUTION STARTED", jobID)
 
 	// CREATE LOGGER FOR THIS JOB
@@ -455,9 +770,20 @@ func (e *Engine) executePipeline(ctx context.Context, cancel context.CancelFunc,
 	e.jobProgress[jobID] = progress
 	e.mu.Unlock()
 
+	// SESSION CHECKS RUN BEFORE THE STAGES THE LOGIN PROTECTS
+	rawSession, _ := e.jobRule(jobID, sessionRule)
+	session, err := ParseSessionRule(rawSession, pipeline)
+	if err != nil {
+		jobLogger.Printf("IGNORING INVALID SESSION RULE: %v", err)
+		e.addJobError(jobID, fmt.Sprintf("Invalid session rule: %v", err))
+	}
+
 	// EXECUTE EACH STAGE IN SEQUENCE
+	timeline := e.timeline(jobID)
 	for stageIndex, stage := range pipeline {
 		jobLogger.Printf("STARTING STAGE %d: %s", stageIndex+1, stage.Name)
+		stageStarted := time.Now()
+		stageSpan := models.RunEvent{Kind: SpanStage, Name: stageLabel(stage), Stage: stageLabel(stage), Lane: stageLabel(stage), StartedAt: stageStarted}
 
 		e.mu.Lock()
 		progress := e.jobProgress[jobID]
@@ -470,59 +796,45 @@ func (e *Engine) executePipeline(ctx context.Context, cancel context.CancelFunc,
 			shouldExecute, err := e.evaluateCondition(ctx, jobID, stage.Condition)
 			if err != nil {
 				jobLogger.Printf("FAILED TO EVALUATE STAGE CONDITION: %v", err)
-				e.addJobError(jobID, fmt.Sprintf("Failed to evaluate stage condition: %v", err))
+				e.addStageError(jobID, stage, fmt.Sprintf("Failed to evaluate stage condition: %v", err))
+				stageSpan.Status, stageSpan.Error = SpanSkipped, err.Error()
+				timeline.record(stageSpan)
 				continue // SKIP THIS STAGE BUT CONTINUE PIPELINE
 			}
 
 			if !shouldExecute {
 				jobLogger.Printf("SKIPPING STAGE %s DUE TO CONDITION", stage.Name)
+				stageSpan.Status = SpanSkipped
+				timeline.record(stageSpan)
 				continue
 			}
 		}
 
-		// EXECUTE TASKS BASED ON PARALLELISM CONFIG
-		switch stage.Parallelism.Mode {
-		case "sequential":
-			err := e.executeSequentialTasks(ctx, jobID, job, stage, jobLogger)
-			if err != nil {
-				jobLogger.Printf("ERROR EXECUTING SEQUENTIAL TASKS: %v", err)
-				if ctx.Err() != nil {
-					// TIMEOUT OR CANCELLED
-					return
-				}
-			}
-
-		case "parallel":
-			err := e.executeParallelTasks(ctx, jobID, job, stage, jobLogger)
-			if err != nil {
-				jobLogger.Printf("ERROR EXECUTING PARALLEL TASKS: %v", err)
-				if ctx.Err() != nil {
-					// TIMEOUT OR CANCELLED
-					return
-				}
-			}
-
-		case "worker-per-item":
-			// SPECIAL PARALLELISM MODE WHERE EACH ITEM IN THE INPUT GETS ITS OWN WORKER
-			err := e.executeWorkerPerItemTasks(ctx, jobID, job, stage, jobLogger)
-			if err != nil {
-				jobLogger.Printf("ERROR EXECUTING WORKER-PER-ITEM TASKS: %v", err)
-				if ctx.Err() != nil {
-					// TIMEOUT OR CANCELLED
-					return
+		// MAKE SURE THE SESSION IS STILL LOGGED IN, LOGGING IN AGAIN IF IT EXPIRED
+		if session.guards(stageIndex, stage) {
+			if err := e.ensureSession(ctx, jobID, job, pipeline, session, jobLogger); err != nil {
+				jobLogger.Printf("STOPPING PIPELINE: %v", err)
+				e.addStageError(jobID, stage, err.Error())
+				stageSpan.Status, stageSpan.Error = spanStatus(ctx, err), err.Error()
+				timeline.record(stageSpan)
+				if ctx.Err() == nil {
+					e.updateJobStatus(jobID, "error")
 				}
+				return
 			}
+		}
 
-		default:
-			// DEFAULT TO SEQUENTIAL
-			err := e.executeSequentialTasks(ctx, jobID, job, stage, jobLogger)
-			if err != nil {
-				jobLogger.Printf("ERROR EXECUTING DEFAULT SEQUENTIAL TASKS: %v", err)
-				if ctx.Err() != nil {
-					// TIMEOUT OR CANCELLED
-					return
-				}
-			}
+		// EXECUTE TASKS BASED ON PARALLELISM CONFIG
+		err := e.executeStage(withTimelineStage(ctx, stageLabel(stage)), jobID, job, stage, jobLogger)
+		stageSpan.Status = spanStatus(ctx, err)
+		if err != nil {
+			stageSpan.Error = err.Error()
+		}
+		stageSpan.Detail = models.JSONMap{"mode": cmp.Or(stage.Parallelism.Mode, "sequential")}
+		timeline.record(stageSpan)
+		if err != nil && ctx.Err() != nil {
+			// TIMEOUT OR CANCELLED
+			return
 		}
 
 		// CHECK CONTEXT BEFORE CONTINUING TO NEXT STAGE
@@ -532,11 +844,44 @@ func (e *Engine) executePipeline(ctx context.Context, cancel context.CancelFunc,
 		}
 	}
 
+	// WAIT FOR QUEUED ASSET WORK BEFORE REPORTING COMPLETION
+	e.waitForAssetWork(jobID)
+
 	// PIPELINE COMPLETED SUCCESSFULLY
 	jobLogger.Printf("PIPELINE EXECUTION COMPLETED SUCCESSFULLY")
 	e.updateJobStatus(jobID, "completed")
 }
 
+// EXECUTE A STAGE'S TASKS IN ITS PARALLELISM MODE
+func (e *Engine) executeStage(ctx context.Context, jobID string, job *models.Job, stage models.Stage, logger *log.Logger) error {
+	var err error
+	switch stage.Parallelism.Mode {
+	case "parallel":
+		if err = e.executeParallelTasks(ctx, jobID, job, stage, logger); err != nil {
+			logger.Printf("ERROR EXECUTING PARALLEL TASKS: %v", err)
+		}
+
+	case "worker-per-item":
+		// SPECIAL PARALLELISM MODE WHERE EACH ITEM IN THE INPUT GETS ITS OWN WORKER
+		if err = e.executeWorkerPerItemTasks(ctx, jobID, job, stage, logger); err != nil {
+			logger.Printf("ERROR EXECUTING WORKER-PER-ITEM TASKS: %v", err)
+		}
+
+	case "for-each":
+		// EVERY TASK OF THE STAGE, IN ORDER, ONCE PER ITEM
+		if err = e.executeForEachTasks(ctx, jobID, stage, logger); err != nil {
+			logger.Printf("ERROR EXECUTING FOR-EACH TASKS: %v", err)
+		}
+
+	default:
+		// SEQUENTIAL, THE DEFAULT
+		if err = e.executeSequentialTasks(ctx, jobID, job, stage, logger); err != nil {
+			logger.Printf("ERROR EXECUTING SEQUENTIAL TASKS: %v", err)
+		}
+	}
+	return err
+}
+
 // EXECUTE TASKS SEQUENTIALLY
 func (e *Engine) executeSequentialTasks(ctx context.Context, jobID string, job *models.Job, stage models.Stage, logger *log.Logger) error {
 	logger.Printf("EXECUTING %d TASKS SEQUENTIALLY", len(stage.Tasks))
@@ -554,7 +899,7 @@ func (e *Engine) executeSequentialTasks(ctx context.Context, jobID string, job *
 				shouldExecute, err := e.evaluateCondition(ctx, jobID, task.Condition)
 				if err != nil {
 					logger.Printf("FAILED TO EVALUATE TASK CONDITION: %v", err)
-					e.addJobError(jobID, fmt.Sprintf("Failed to evaluate task condition: %v", err))
+					e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Failed to evaluate task condition: %v", err))
 					continue // SKIP THIS TASK BUT CONTINUE
 				}
 
@@ -565,10 +910,10 @@ func (e *Engine) executeSequentialTasks(ctx context.Context, jobID string, job *
 			}
 
 			// PREPARE TASK INPUTS
-			taskInputs, err := e.prepareTaskInputs(jobID, task)
+			taskInputs, err := e.prepareTaskInputs(ctx, jobID, task)
 			if err != nil {
 				logger.Printf("FAILED TO PREPARE TASK INPUTS: %v", err)
-				e.addJobError(jobID, fmt.Sprintf("Failed to prepare task inputs: %v", err))
+				e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Failed to prepare task inputs: %v", err))
 				continue
 			}
 
@@ -576,7 +921,7 @@ func (e *Engine) executeSequentialTasks(ctx context.Context, jobID string, job *
 			result, err := e.executeTask(ctx, jobID, task, taskInputs, logger)
 			if err != nil {
 				logger.Printf("TASK EXECUTION FAILED: %v", err)
-				e.addJobError(jobID, fmt.Sprintf("Task execution failed: %v", err))
+				e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Task execution failed: %v", err))
 
 				// IF TASK HAS RETRY CONFIG, ATTEMPT RETRIES
 				if task.RetryConfig.MaxRetries > 0 {
@@ -638,7 +983,7 @@ func (e *Engine) executeParallelTasks(ctx context.Context, jobID string, job *mo
 			shouldExecute, err := e.evaluateCondition(ctx, jobID, task.Condition)
 			if err != nil {
 				logger.Printf("FAILED TO EVALUATE TASK CONDITION: %v", err)
-				e.addJobError(jobID, fmt.Sprintf("Failed to evaluate task condition: %v", err))
+				e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Failed to evaluate task condition: %v", err))
 				continue
 			}
 
@@ -659,8 +1004,14 @@ func (e *Engine) executeParallelTasks(ctx context.Context, jobID string, job *mo
 			defer wg.Done()
 
 			workerLogger := log.New(logger.Writer(), fmt.Sprintf("[WORKER %d] ", workerID), 0)
+			ctx := withTimelineWorker(ctx, workerID)
 
 			for task := range taskQueue {
+				// FEWER WORKERS RUN WHILE MEMORY IS SHORT
+				if err := e.waitForMemory(ctx, workerID, maxWorkers, workerLogger); err != nil {
+					errChan <- err
+					return
+				}
 				select {
 				case <-ctx.Done():
 					errChan <- ctx.Err()
@@ -669,10 +1020,10 @@ func (e *Engine) executeParallelTasks(ctx context.Context, jobID string, job *mo
 					workerLogger.Printf("EXECUTING TASK: %s (%s)", task.Name, task.Type)
 
 					// PREPARE TASK INPUTS
-					taskInputs, err := e.prepareTaskInputs(jobID, task)
+					taskInputs, err := e.prepareTaskInputs(ctx, jobID, task)
 					if err != nil {
 						workerLogger.Printf("FAILED TO PREPARE TASK INPUTS: %v", err)
-						e.addJobError(jobID, fmt.Sprintf("Failed to prepare task inputs: %v", err))
+						e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Failed to prepare task inputs: %v", err))
 						continue
 					}
 
@@ -680,7 +1031,7 @@ func (e *Engine) executeParallelTasks(ctx context.Context, jobID string, job *mo
 					result, err := e.executeTask(ctx, jobID, task, taskInputs, workerLogger)
 					if err != nil {
 						workerLogger.Printf("TASK EXECUTION FAILED: %v", err)
-						e.addJobError(jobID, fmt.Sprintf("Task execution failed: %v", err))
+						e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Task execution failed: %v", err))
 
 						// IF TASK HAS RETRY CONFIG, ATTEMPT RETRIES
 						if task.RetryConfig.MaxRetries > 0 {
@@ -781,6 +1132,23 @@ func (e *Engine) executeWorkerPerItemTasks(ctx context.Context, jobID string, jo
 		return err
 	}
 
+	// SAMPLE RUNS ONLY TAKE THE FIRST ITEMS
+	if limit := e.sampleLimit(jobID); limit > 0 && len(items) > limit {
+		logger.Printf("SAMPLE RUN: PROCESSING %d OF %d ITEMS", limit, len(items))
+		items = items[:limit]
+	}
+
+	// INCREMENTAL RUNS STOP AT THE FIRST ITEMS AN EARLIER RUN ALREADY COVERED
+	if cond, err := ParseStopCondition(stage.Config["stopWhen"]); err != nil {
+		logger.Printf("IGNORING INVALID STOP CONDITION: %v", err)
+	} else if cond != nil {
+		if kept, err := e.stopItems(jobID, stage, *cond, items); err != nil {
+			logger.Printf("STOP CONDITION FAILED, PROCESSING EVERY ITEM: %v", err)
+		} else {
+			items = kept
+		}
+	}
+
 	logger.Printf("PROCESSING %d ITEMS WITH WORKER-PER-ITEM", len(items))
 
 	// DETERMINE MAX WORKERS
@@ -792,6 +1160,10 @@ func (e *Engine) executeWorkerPerItemTasks(ctx context.Context, jobID string, jo
 		maxWorkers = len(items)
 	}
 
+	// EACH ITEM'S OUTCOME IS KEPT FOR THE API
+	itemLog := e.newItemResultLog(jobID, stage)
+	defer itemLog.flush()
+
 	// CREATE WAIT GROUP AND ERROR CHANNEL
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(items))
@@ -820,8 +1192,14 @@ func (e *Engine) executeWorkerPerItemTasks(ctx context.Context, jobID string, jo
 			defer wg.Done()
 
 			workerLogger := log.New(logger.Writer(), fmt.Sprintf("[WORKER %d] ", workerID), 0)
+			ctx := withTimelineWorker(ctx, workerID)
 
 			for qItem := range itemQueue {
+				// FEWER WORKERS RUN WHILE MEMORY IS SHORT
+				if err := e.waitForMemory(ctx, workerID, maxWorkers, workerLogger); err != nil {
+					errChan <- err
+					return
+				}
 				select {
 				case <-ctx.Done():
 					errChan <- ctx.Err()
@@ -860,15 +1238,17 @@ func (e *Engine) executeWorkerPerItemTasks(ctx context.Context, jobID string, jo
 						}
 					}
 
-					// EXECUTE THE TASK
-					result, err := e.executeTask(ctx, jobID, taskCopy, taskInputs, workerLogger)
+					// EXECUTE THE TASK, WITH THE ITEM STANDING IN FOR ITS SOURCE
+					started := time.Now()
+					itemCtx := withItemScope(ctx, newItemScope(qItem.index, itemSourceID, qItem.item))
+					result, err := e.executeTask(itemCtx, jobID, taskCopy, taskInputs, workerLogger)
 					if err != nil {
 						workerLogger.Printf("TASK EXECUTION FAILED FOR ITEM %d: %v", qItem.index, err)
-						e.addJobError(jobID, fmt.Sprintf("Task execution failed for item %d: %v", qItem.index, err))
+						e.addTaskError(jobID, stage, taskCopy, err, fmt.Sprintf("Task execution failed for item %d: %v", qItem.index, err))
 
 						// IF TASK HAS RETRY CONFIG, ATTEMPT RETRIES
 						if taskCopy.RetryConfig.MaxRetries > 0 {
-							retryResult, retryErr := e.retryTask(ctx, jobID, taskCopy, taskInputs, workerLogger)
+							retryResult, retryErr := e.retryTask(itemCtx, jobID, taskCopy, taskInputs, workerLogger)
 							if retryErr == nil {
 								// RETRY SUCCEEDED
 								result = retryResult
@@ -881,6 +1261,7 @@ func (e *Engine) executeWorkerPerItemTasks(ctx context.Context, jobID string, jo
 							return
 						}
 					}
+					itemLog.add(qItem.index, qItem.item, started, result.Value, primaryTask.ID, err)
 
 					// STORE INDIVIDUAL RESULT
 					if err == nil {
@@ -952,7 +1333,7 @@ func (e *Engine) executeWorkerPerItemTasks(ctx context.Context, jobID string, jo
 }
 
 // PREPARE TASK INPUTS
-func (e *Engine) prepareTaskInputs(jobID string, task models.Task) (map[string]any, error) {
+func (e *Engine) prepareTaskInputs(ctx context.Context, jobID string, task models.Task) (map[string]any, error) {
 	inputs := make(map[string]any)
 
 	// GET TASK IMPLEMENTATION
@@ -975,10 +1356,7 @@ func (e *Engine) prepareTaskInputs(jobID string, task models.Task) (map[string]a
 		// LOOK FOR INPUT IN TASK REFERENCES
 		found := false
 		for _, inputRef := range task.InputRefs {
-			e.mu.Lock()
-			inputData, exists := e.jobProgress[jobID].TaskResults[inputRef]
-			e.mu.Unlock()
-
+			inputData, exists := e.taskResult(ctx, jobID, inputRef)
 			if exists {
 				// CHECK TYPE COMPATIBILITY
 				if inputData.Type == inputType || inputType == "any" {
@@ -1006,11 +1384,6 @@ func (e *Engine) executeTask(ctx context.Context, jobID string, task models.Task
 		return TaskData{}, err
 	}
 
-	// VALIDATE TASK CONFIG
-	if err := taskImpl.ValidateConfig(task.Config); err != nil {
-		return TaskData{}, fmt.Errorf("INVALID TASK CONFIG: %v", err)
-	}
-
 	// MERGE INPUTS WITH CONFIG
 	config := make(map[string]any)
 	for k, v := range task.Config {
@@ -1020,6 +1393,31 @@ func (e *Engine) executeTask(ctx context.Context, jobID string, task models.Task
 		config[k] = v
 	}
 
+	// A pageId OR browserId NAMING THE TASK THAT OPENED IT MEANS THAT TASK'S RESOURCE
+	for _, key := range []string{"pageId", "browserId"} {
+		if ref, ok := config[key].(string); ok {
+			if data, exists := e.taskResult(ctx, jobID, ref); exists {
+				config[key] = data.Value
+			}
+		}
+	}
+
+	// EACH EXECUTION IS A SPAN ON THE RUN'S TIMELINE
+	scope := timelineScopeFrom(ctx)
+	span := models.RunEvent{Kind: SpanTask, Name: task.Name, Stage: scope.stage, TaskID: task.ID, Lane: scope.lane, Attempt: scope.attempt, StartedAt: time.Now()}
+	span.Detail = models.JSONMap{"type": task.Type}
+	if item := itemScopeFrom(ctx); item != nil {
+		span.Detail["item"] = item.index
+	}
+	timeline := e.timeline(jobID)
+
+	// VALIDATE TASK CONFIG (WITH ITS INPUTS, WHICH MAY CARRY REQUIRED VALUES)
+	if err := taskImpl.ValidateConfig(config); err != nil {
+		span.Status, span.Error = SpanFailed, err.Error()
+		timeline.record(span)
+		return TaskData{}, fmt.Errorf("INVALID TASK CONFIG: %v", err)
+	}
+
 	// CREATE TASK CONTEXT
 	taskCtx := &TaskContext{
 		JobID:           jobID,
@@ -1028,10 +1426,31 @@ func (e *Engine) executeTask(ctx context.Context, jobID string, task models.Task
 		Logger:          logger,
 		Engine:          e,
 	}
+	for _, ref := range task.InputRefs {
+		if data, ok := e.taskResult(ctx, jobID, ref); ok {
+			taskCtx.Inputs = append(taskCtx.Inputs, TaskInput{Ref: ref, Data: data})
+		}
+	}
 
 	// EXECUTE TASK
 	logger.Printf("EXECUTING TASK %s (%s)", task.Name, task.Type)
-	return taskImpl.Execute(taskCtx, config)
+	result, err := taskImpl.Execute(taskCtx, config)
+
+	// A BROWSER OR PAGE THAT DIED UNDER THE TASK IS REPLACED WHEN THE TASK LOOKS IT UP AGAIN
+	for attempt := 1; err != nil && attempt <= maxCrashRetries && ctx.Err() == nil && isBrowserCrash(err) && e.hasDeadResources(jobID); attempt++ {
+		logger.Printf("TASK %s LOST ITS BROWSER (%v), RECOVERING AND RETRYING (%d/%d)", task.Name, err, attempt, maxCrashRetries)
+		result, err = taskImpl.Execute(taskCtx, config)
+	}
+	if err != nil {
+		// KEEP WHAT THE TASK RAN WITH AND WHAT ITS PAGE LOOKED LIKE SO THE FAILURE CAN BE INSPECTED AND REPLAYED
+		failure := &TaskFailure{Err: err, Inputs: config}
+		e.captureFailure(taskCtx, task, failure)
+		span.Error = err.Error()
+		err = failure
+	}
+	span.Status = spanStatus(ctx, err)
+	timeline.record(span)
+	return result, err
 }
 
 // RETRY A FAILED TASK
@@ -1051,21 +1470,33 @@ func (e *Engine) retryTask(ctx context.Context, jobID string, task models.Task,
 	var lastErr error
 	var result TaskData
 
+	scope := timelineScopeFrom(ctx)
+	timeline := e.timeline(jobID)
 	for retry := 1; retry <= maxRetries; retry++ {
 		// WAIT BEFORE RETRY WITH EXPONENTIAL BACKOFF
 		delay := time.Duration(float64(delayMS) * (float64(backoffRate) * float64(retry-1)))
 		logger.Printf("RETRYING TASK %s (ATTEMPT %d/%d) AFTER %v DELAY", task.Name, retry, maxRetries, delay)
 
+		// THE RETRY'S SPAN COVERS ITS BACKOFF AND THE ATTEMPT, WHOSE OWN SPAN IS INSIDE IT
+		span := models.RunEvent{Kind: SpanRetry, Name: task.Name, Stage: scope.stage, TaskID: task.ID, Lane: scope.lane, Attempt: retry, StartedAt: time.Now()}
 		select {
 		case <-time.After(time.Duration(delay) * time.Millisecond):
 			// CONTINUE WITH RETRY
 		case <-ctx.Done():
 			// CONTEXT CANCELLED
+			span.Status = SpanCancelled
+			timeline.record(span)
 			return TaskData{}, ctx.Err()
 		}
+		span.Detail = models.JSONMap{"backoffMs": time.Since(span.StartedAt).Milliseconds()}
 
 		// EXECUTE TASK AGAIN
-		result, lastErr = e.executeTask(ctx, jobID, task, inputs, logger)
+		result, lastErr = e.executeTask(withTimelineAttempt(ctx, retry), jobID, task, inputs, logger)
+		span.Status = spanStatus(ctx, lastErr)
+		if lastErr != nil {
+			span.Error = lastErr.Error()
+		}
+		timeline.record(span)
 		if lastErr == nil {
 			// RETRY SUCCEEDED
 			logger.Printf("TASK %s RETRY SUCCESSFUL (ATTEMPT %d)", task.Name, retry)
@@ -1131,20 +1562,151 @@ func (e *Engine) evaluateCondition(ctx context.Context, jobID string, condition
 
 // ADD JOB ERROR
 func (e *Engine) addJobError(jobID string, errorMsg string) {
+	e.recordError(models.ErrorLog{JobID: jobID, Message: errorMsg})
+}
+
+// ADD AN ERROR RAISED WHILE RUNNING A STAGE
+func (e *Engine) addStageError(jobID string, stage models.Stage, errorMsg string) {
+	e.recordError(models.ErrorLog{JobID: jobID, Stage: stageLabel(stage), Message: errorMsg})
+}
+
+// ADD AN ERROR RAISED BY A TASK, WITH ANY PAGE CAPTURE TAKEN WHEN IT FAILED
+func (e *Engine) addTaskError(jobID string, stage models.Stage, task models.Task, err error, errorMsg string) {
+	entry := models.ErrorLog{
+		JobID:    jobID,
+		Stage:    stageLabel(stage),
+		TaskID:   task.ID,
+		TaskName: task.Name,
+		TaskType: task.Type,
+		Message:  errorMsg,
+	}
+	if task.Config != nil {
+		entry.TaskConfig = models.JSONMap(jsonSafe(task.Config))
+	}
+	var failure *TaskFailure
+	if errors.As(err, &failure) {
+		entry.URL = failure.URL
+		entry.Screenshot = failure.Screenshot
+		entry.Snapshot = failure.Snapshot
+		entry.HTMLSnippet = failure.HTMLSnippet
+		entry.HAR = failure.HAR
+		entry.Inputs = models.JSONMap(jsonSafe(failure.Inputs))
+	}
+	e.recordError(entry)
+}
+
+// RECORD AN ERROR IN THE JOB'S PROGRESS, THE RECENT ERRORS BUFFER AND THE ERROR LOG TABLE
+func (e *Engine) recordError(entry models.ErrorLog) {
+	now := time.Now()
+	e.mu.Lock()
+	progress := e.jobProgress[entry.JobID]
+	progress.Errors = append(progress.Errors, entry.Message)
+	e.jobProgress[entry.JobID] = progress
+
+	// KEEP THE LATEST ERRORS ACROSS ALL JOBS FOR THE DASHBOARD
+	e.recentErrors = append(e.recentErrors, JobError{JobID: entry.JobID, Message: entry.Message, Time: now})
+	if len(e.recentErrors) > maxRecentErrors {
+		e.recentErrors = e.recentErrors[len(e.recentErrors)-maxRecentErrors:]
+	}
+	e.mu.Unlock()
+
+	entry.ID = generateID("err")
+	entry.StatusCode = errorStatusCode(entry.Message)
+	entry.Fingerprint = errorFingerprint(entry.TaskType, entry.Message)
+	entry.Status = models.ErrorStatusOpen
+	entry.CreatedAt = now
+	if err := e.db.Create(&entry).Error; err != nil {
+		log.Printf("FAILED TO SAVE ERROR LOG: %v", err)
+	}
+}
+
+// GET THE MOST RECENT JOB ERRORS, NEWEST FIRST
+func (e *Engine) RecentErrors(limit int) []JobError {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	progress := e.jobProgress[jobID]
-	progress.Errors = append(progress.Errors, errorMsg)
-	e.jobProgress[jobID] = progress
+	out := make([]JobError, 0, min(limit, len(e.recentErrors)))
+	for i := len(e.recentErrors) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, e.recentErrors[i])
+	}
+	return out
+}
+
+// SUBMIT ASSET POST-PROCESSING TO THE JOB'S WORKER POOL, RUNNING INLINE IF THE JOB HAS NONE
+func (e *Engine) submitAssetWork(jobID string, work func()) {
+	e.mu.Lock()
+	pool, ok := e.assetWorkers[jobID]
+	e.mu.Unlock()
+
+	if !ok {
+		work()
+		return
+	}
+	pool.Submit(work)
+}
+
+// BLOCK UNTIL ALL QUEUED ASSET WORK FOR A JOB HAS FINISHED
+func (e *Engine) waitForAssetWork(jobID string) {
+	e.mu.Lock()
+	pool, ok := e.assetWorkers[jobID]
+	e.mu.Unlock()
+
+	if ok {
+		log.Printf("WAITING FOR %d QUEUED ASSET TASKS FOR JOB %s", pool.Stats().Queued, jobID)
+		pool.Wait()
+	}
 }
 
 // FINISH JOB AND CLEANUP
 func (e *Engine) finishJob(jobID string) {
 	log.Printf("FINISHING JOB: %s", jobID)
+
+	// THE FREED SLOT MAY LET A QUEUED RUN START (RUNS AFTER THE LOCK IS RELEASED)
+	defer e.dispatchQueue()
+
+	// DRAIN AND SHUT DOWN THE ASSET POOL BEFORE RELEASING JOB STATE
+	e.waitForAssetWork(jobID)
+
+	// CHECK THE RUN'S RECORDS AGAINST THE JOB'S ALERTS WHILE ITS RULES ARE STILL LOADED
+	e.evaluateRecordAlerts(jobID)
+
+	// CLOSE BROWSERS AND PAGES THE PIPELINE DIDN'T DISPOSE
+	leaked := e.releaseJobResources(jobID)
+	if leaked > 0 {
+		log.Printf("JOB %s LEFT %d BROWSERS/PAGES OPEN", jobID, leaked)
+	}
+
+	// EVERY PAGE IS CLOSED NOW, SO THE SESSION HAS ALL THEIR COOKIES AND THE CASSETTE ALL THEIR RESPONSES
+	e.endSession(jobID)
+	e.endCassette(jobID)
+
+	// THE RUN'S DOWNLOADS ARE DONE TOO, SO ITS TIMELINE IS COMPLETE
+	e.endTimeline(jobID)
+
 	e.mu.Lock()
+
+	budget := e.endBudget(jobID)
+	throttle := e.endThrottle(jobID)
+	if progress, ok := e.jobProgress[jobID]; ok {
+		progress.Leaked = leaked
+		progress.Budget = budget
+		progress.Throttle = throttle
+		if state, ok := e.runStates[jobID]; ok {
+			progress.State = state.snapshot()
+		}
+		e.jobProgress[jobID] = progress
+	}
+	delete(e.runStates, jobID)
 	defer e.mu.Unlock()
 
+	if pool, ok := e.assetWorkers[jobID]; ok {
+		progress := e.jobProgress[jobID]
+		progress.AssetQueue = pool.Stats()
+		e.jobProgress[jobID] = progress
+		pool.Close()
+		delete(e.assetWorkers, jobID)
+	}
+
 	if startTime, ok := e.jobStartTimes[jobID]; ok {
 		duration := time.Since(startTime)
 		e.jobDurations[jobID] = duration
@@ -1153,13 +1715,24 @@ func (e *Engine) finishJob(jobID string) {
 	}
 
 	delete(e.runningJobs, jobID)
+	delete(e.jobRules, jobID)
+	delete(e.jobSettings, jobID)
+	delete(e.playbacks, jobID)
+	delete(e.recordSchemas, jobID)
 
 	// CLEAN UP RESOURCES
-	e.resourceManager.DeleteJobResources(jobID)
+	e.origins.clear(jobID)
 
 	log.Printf("JOB %s FINISHED AND CLEANED UP", jobID)
 }
 
+// ITEMS PER STAGE FOR A SAMPLE RUN (0 FOR A FULL RUN)
+func (e *Engine) sampleLimit(jobID string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.jobProgress[jobID].Sample
+}
+
 // UPDATE JOB STATUS
 func (e *Engine) updateJobStatus(jobID string, status string) {
 	log.Printf("UPDATING JOB %s STATUS: %s", jobID, status)
@@ -1171,6 +1744,8 @@ func (e *Engine) updateJobStatus(jobID string, status string) {
 	}
 	e.mu.Unlock()
 
+	e.events.Publish("job.status", jobID, map[string]any{"status": status})
+
 	if err := e.db.Model(&models.Job{}).Where("id = ?", jobID).Update("status", status).Error; err != nil {
 		log.Printf("STATUS UPDATE ERROR: %v", err)
 	} else {
@@ -1186,6 +1761,12 @@ func (e *Engine) StopJob(jobID string) error {
 
 	cancel, running := e.runningJobs[jobID]
 	if !running {
+		// A QUEUED RUN IS SIMPLY DROPPED
+		if i := e.queuedIndex(jobID); i >= 0 {
+			e.queue = append(e.queue[:i], e.queue[i+1:]...)
+			log.Printf("REMOVED JOB %s FROM QUEUE", jobID)
+			return nil
+		}
 		log.Printf("JOB %s IS NOT RUNNING", jobID)
 		return fmt.Errorf("JOB %s NOT RUNNING", jobID)
 	}
@@ -1201,6 +1782,14 @@ func (e *Engine) StopJob(jobID string) error {
 	return nil
 }
 
+// WHETHER A JOB IS CURRENTLY RUNNING (OR STARTING)
+func (e *Engine) IsJobRunning(jobID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, running := e.runningJobs[jobID]
+	return running
+}
+
 // GET JOB PROGRESS
 func (e *Engine) GetJobProgress(jobID string) (JobProgress, error) {
 	log.Printf("GETTING PROGRESS FOR JOB: %s", jobID)
@@ -1213,6 +1802,21 @@ func (e *Engine) GetJobProgress(jobID string) (JobProgress, error) {
 		return JobProgress{}, ErrJobNotFound
 	}
 
+	// LIVE ASSET QUEUE DEPTH, BUDGET AND PACING WHILE THE JOB IS RUNNING
+	if pool, ok := e.assetWorkers[jobID]; ok {
+		progress.AssetQueue = pool.Stats()
+	}
+	if budget, ok := e.budgets[jobID]; ok {
+		usage := budget.usage()
+		progress.Budget = &usage
+	}
+	if state, ok := e.runStates[jobID]; ok {
+		progress.State = state.snapshot()
+	}
+	if throttle, ok := e.throttles[jobID]; ok {
+		progress.Throttle = throttle.snapshot()
+	}
+
 	log.Printf("JOB %s PROGRESS: %d/%d TASKS", jobID, progress.CompletedTasks, progress.TotalTasks)
 	return progress, nil
 }
@@ -1239,6 +1843,34 @@ func (e *Engine) GetJobDuration(jobID string) (time.Duration, error) {
 	return duration, nil
 }
 
+// GET LIVE AND FINISHED DOWNLOADS FOR THE JOB'S LATEST RUN
+func (e *Engine) GetJobDownloads(jobID string) []DownloadProgress {
+	return e.transfers.Job(jobID)
+}
+
+// GET THE ENGINE'S EVENT BUS
+func (e *Engine) Events() *EventBus {
+	return e.events
+}
+
+// NOTIFIER FOR ALERTS AND OTHER NOTIFICATIONS
+func (e *Engine) Notifier() *Notifier {
+	return e.notifier
+}
+
+// GET A RULE FROM THE RUNNING JOB'S RULES MAP
+func (e *Engine) jobRule(jobID, key string) (any, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules, ok := e.jobRules[jobID]
+	if !ok || rules == nil {
+		return nil, false
+	}
+	value, ok := rules[key]
+	return value, ok
+}
+
 // GENERATE A UNIQUE ID
 func generateID(prefix string) string {
 	id := uuid.New().String()
@@ -1253,28 +1885,39 @@ func (e *Engine) Close() {
 	e.mu.Lock()
 	jobCount := len(e.runningJobs)
 	log.Printf("STOPPING %d RUNNING JOBS", jobCount)
+	var interrupted []string
 	for jobID, cancel := range e.runningJobs {
 		log.Printf("CANCELLING JOB: %s", jobID)
 		cancel()
-		e.updateJobStatus(jobID, "stopped")
+		interrupted = append(interrupted, jobID)
 	}
 	e.runningJobs = make(map[string]context.CancelFunc)
+	for _, run := range e.queue {
+		interrupted = append(interrupted, run.JobID)
+	}
+	e.queue = nil
 	e.mu.Unlock()
 
+	// RUNNING AND QUEUED RUNS ARE MARKED SO THE NEXT START CAN RESUME THEM
+	close(e.queueStop)
+	for _, jobID := range interrupted {
+		e.updateJobStatus(jobID, StatusInterrupted)
+	}
+
 	log.Printf("ALL JOBS STOPPED")
 
-	// DRAIN POOL AND CLOSE BROWSERS
-	log.Printf("DRAINING BROWSER POOL")
-	close(e.browserPool)
-	browserCount := 0
-	for browser := range e.browserPool {
-		browserCount++
-		if browser.browser != nil {
-			log.Printf("CLOSING BROWSER %d", browserCount)
-			(*browser.browser).Close()
-		}
-	}
+	// STOP WAYBACK SUBMISSIONS
+	e.wayback.Close()
+
+	// FINISH THE THUMBNAILS BEING RENDERED; THE REST STAY pending FOR THE NEXT START
+	e.thumbnails.Close()
+
+	// UPLOADS IN FLIGHT FINISH; pending ONES WAIT FOR THE NEXT START
+	e.uploads.Close()
 
+	// CLOSE THE BROWSERS STILL OPEN; LAUNCHES WAITING FOR THE POOL GIVE UP
+	log.Printf("CLOSING BROWSER POOL")
+	browserCount := e.browsers.close()
 	log.Printf("%d BROWSERS CLOSED", browserCount)
 
 	// STOP PLAYWRIGHT
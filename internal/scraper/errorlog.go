@@ -0,0 +1,54 @@
+package scraper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+var (
+	// "STATUS 404", "HTTP 503", "STATUS CODE: 429", "RETURNED 403"
+	statusCodePattern = regexp.MustCompile(`(?i)\b(?:status(?: code)?|http|returned)[:\s]+([1-5]\d\d)\b`)
+
+	// VOLATILE PARTS OF A MESSAGE, REPLACED BEFORE FINGERPRINTING
+	urlPattern    = regexp.MustCompile(`\b[a-z][a-z0-9+.-]*://\S+`)
+	uuidPattern   = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+	hexPattern    = regexp.MustCompile(`(?i)\b(?:0x)?[0-9a-f]{12,}\b`)
+	quotedPattern = regexp.MustCompile(`"[^"]*"|'[^']*'`)
+	numberPattern = regexp.MustCompile(`\d+(?:\.\d+)?`)
+)
+
+// STAGE NAME FOR ERROR LOGS, FALLING BACK TO THE ID
+func stageLabel(stage models.Stage) string {
+	if stage.Name != "" {
+		return stage.Name
+	}
+	return stage.ID
+}
+
+// HTTP STATUS CODE MENTIONED IN AN ERROR MESSAGE, 0 IF NONE
+func errorStatusCode(message string) int {
+	match := statusCodePattern.FindStringSubmatch(message)
+	if match == nil {
+		return 0
+	}
+	code, _ := strconv.Atoi(match[1])
+	return code
+}
+
+// GROUPING KEY FOR ERRORS THAT DIFFER ONLY IN URLS, IDS, QUOTED VALUES AND NUMBERS
+func errorFingerprint(taskType, message string) string {
+	normalized := strings.ToLower(message)
+	normalized = urlPattern.ReplaceAllString(normalized, "<url>")
+	normalized = uuidPattern.ReplaceAllString(normalized, "<id>")
+	normalized = hexPattern.ReplaceAllString(normalized, "<hex>")
+	normalized = quotedPattern.ReplaceAllString(normalized, "<str>")
+	normalized = numberPattern.ReplaceAllString(normalized, "<n>")
+	normalized = strings.Join(strings.Fields(normalized), " ")
+	sum := sha1.Sum([]byte(taskType + "|" + normalized))
+	return hex.EncodeToString(sum[:8])
+}
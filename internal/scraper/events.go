@@ -0,0 +1,69 @@
+package scraper
+
+import (
+	"sync"
+	"time"
+)
+
+// -- EVENT STREAM --
+
+// EVENT PUBLISHED TO LIVE SUBSCRIBERS (WEBSOCKET CLIENTS)
+type Event struct {
+	Type      string    `json:"type"`
+	JobID     string    `json:"jobId,omitempty"`
+	Data      any       `json:"data,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// EVENT BUS FANS EVENTS OUT TO SUBSCRIBERS WITHOUT BLOCKING PUBLISHERS
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]string // CHANNEL -> JOB FILTER ("" FOR ALL)
+}
+
+// NEW EVENT BUS
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]string)}
+}
+
+// SUBSCRIBE TO EVENTS, OPTIONALLY FILTERED TO ONE JOB; CALL THE RETURNED FUNC TO UNSUBSCRIBE
+func (b *EventBus) Subscribe(jobID string) (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	b.mu.Lock()
+	b.subscribers[ch] = jobID
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// PUBLISH AN EVENT; SLOW SUBSCRIBERS DROP EVENTS RATHER THAN STALLING THE ENGINE
+func (b *EventBus) Publish(eventType, jobID string, data any) {
+	event := Event{
+		Type:      eventType,
+		JobID:     jobID,
+		Data:      data,
+		Timestamp: time.Now(),
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch, filter := range b.subscribers {
+		if filter != "" && filter != jobID {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
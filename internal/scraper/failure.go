@@ -0,0 +1,139 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/playwright-community/playwright-go"
+)
+
+// LIMITS ON WHAT A FAILURE CAPTURE STORES
+const (
+	maxFailureSnapshot = 512 * 1024 // DOM SNAPSHOT FILE
+	maxFailureSnippet  = 8 * 1024   // HTML KEPT ON THE ERROR RECORD
+	failureTimeout     = 5 * time.Second
+)
+
+// A TASK ERROR WITH WHAT THE PAGE LOOKED LIKE WHEN IT FAILED
+type TaskFailure struct {
+	Err         error
+	Inputs      map[string]any // CONFIG MERGED WITH RESOLVED INPUTS
+	URL         string
+	Screenshot  string // PATHS RELATIVE TO STORAGE
+	Snapshot    string
+	HAR         string
+	HTMLSnippet string
+}
+
+func (f *TaskFailure) Error() string { return f.Err.Error() }
+func (f *TaskFailure) Unwrap() error { return f.Err }
+
+// CAPTURE A SCREENSHOT AND DOM SNAPSHOT OF THE PAGE A FAILED TASK WAS USING. NOTHING IS
+// CAPTURED FOR TASKS WITHOUT A PAGE OR FOR JOBS WITH THE screenshotOnFailure RULE SET TO false
+func (e *Engine) captureFailure(ctx *TaskContext, task models.Task, failure *TaskFailure) {
+	pageRef, ok := failure.Inputs["pageId"]
+	if !ok {
+		return
+	}
+	if enabled, ok := e.jobRule(ctx.JobID, "screenshotOnFailure"); ok {
+		if b, isBool := enabled.(bool); isBool && !b {
+			return
+		}
+	}
+	page, err := getPage(ctx, pageRef)
+	if err != nil || page.IsClosed() {
+		return
+	}
+
+	failure.URL = page.URL()
+	if pageID := resourceID(pageRef, "pageId"); pageID != "" && e.harEnabled(ctx.JobID) {
+		failure.HAR = harPath(ctx.JobID, pageID)
+	}
+	taskID := task.ID
+	if taskID == "" {
+		taskID = task.Type
+	}
+	relDir := filepath.Join("failures", ctx.JobID)
+	dir := filepath.Join(e.cfg.StoragePath, relDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		ctx.Logger.Printf("FAILED TO CREATE FAILURE CAPTURE DIRECTORY: %v", err)
+		return
+	}
+	base := fmt.Sprintf("%s_%s", time.Now().Format("20060102_150405.000"), utils.SafeFilename(taskID))
+
+	// VIEWPORT ONLY AND MODEST QUALITY TO KEEP CAPTURES SMALL
+	if data, err := page.Screenshot(playwright.PageScreenshotOptions{
+		Type:    playwright.ScreenshotTypeJpeg,
+		Quality: playwright.Int(60),
+		Timeout: playwright.Float(float64(failureTimeout.Milliseconds())),
+	}); err == nil {
+		name := base + ".jpg"
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err == nil {
+			failure.Screenshot = filepath.Join(relDir, name)
+		}
+	} else {
+		ctx.Logger.Printf("FAILURE SCREENSHOT FAILED: %v", err)
+	}
+
+	if html, err := page.Content(); err == nil {
+		if len(html) > maxFailureSnapshot {
+			html = html[:maxFailureSnapshot]
+		}
+		name := base + ".html"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(html), 0644); err == nil {
+			failure.Snapshot = filepath.Join(relDir, name)
+		}
+		if len(html) > maxFailureSnippet {
+			html = html[:maxFailureSnippet]
+		}
+		failure.HTMLSnippet = html
+	}
+
+	ctx.Logger.Printf("CAPTURED FAILURE STATE FOR TASK %s AT %s", taskID, failure.URL)
+}
+
+// HAR FILE (RELATIVE TO STORAGE) RECORDED FOR A PAGE WHEN THE JOB HAS THE recordHar RULE.
+// PLAYWRIGHT ONLY WRITES IT ONCE THE PAGE CLOSES
+func harPath(jobID, pageID string) string {
+	return filepath.Join("failures", jobID, "har", utils.SafeFilename(pageID)+".har")
+}
+
+// WHETHER THE JOB RECORDS HAR FILES FOR ITS PAGES
+func (e *Engine) harEnabled(jobID string) bool {
+	enabled, _ := e.jobRule(jobID, "recordHar")
+	b, _ := enabled.(bool)
+	return b
+}
+
+// RESOURCE ID FROM A TASK INPUT THAT IS EITHER THE ID OR AN OBJECT HOLDING IT
+func resourceID(ref any, key string) string {
+	switch v := ref.(type) {
+	case string:
+		return v
+	case map[string]any:
+		id, _ := v[key].(string)
+		return id
+	}
+	return ""
+}
+
+// ROUND-TRIP A VALUE THROUGH JSON SO IT CAN BE STORED (UNENCODABLE VALUES BECOME STRINGS)
+func jsonSafe(values map[string]any) map[string]any {
+	out := make(map[string]any, len(values))
+	for key, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			out[key] = fmt.Sprintf("%v", value)
+			continue
+		}
+		var decoded any
+		json.Unmarshal(data, &decoded)
+		out[key] = decoded
+	}
+	return out
+}
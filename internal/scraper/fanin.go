@@ -0,0 +1,277 @@
+package scraper
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// -- FAN-IN TASKS --
+//
+// merge, zip AND flatten COMBINE THE OUTPUTS OF EVERY TASK IN THEIR inputRefs (IN ORDER), OR AN
+// EXPLICIT values LIST, SO A STAGE CAN JOIN RESULTS FROM SEVERAL TASKS WITHOUT A SCRIPT, E.G. A
+// TITLES ARRAY AND A URLS ARRAY ZIPPED INTO [{title, url}].
+
+// THE VALUES A FAN-IN TASK COMBINES, WITH A NAME FOR EACH (ITS TASK ID, OR ITS INDEX IN values)
+func fanInValues(ctx *TaskContext, config map[string]any) ([]string, []any) {
+	if values, ok := config["values"].([]any); ok && len(values) > 0 {
+		names := make([]string, len(values))
+		for i := range values {
+			names[i] = strconv.Itoa(i)
+		}
+		return names, values
+	}
+	names := make([]string, len(ctx.Inputs))
+	values := make([]any, len(ctx.Inputs))
+	for i, input := range ctx.Inputs {
+		names[i], values[i] = input.Ref, input.Data.Value
+	}
+	return names, values
+}
+
+// MERGE TASK: CONCATENATE ARRAYS, OR COMBINE OBJECTS (LATER INPUTS WIN)
+type MergeTask struct{}
+
+func (t *MergeTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"values": "array?",   // OPTIONAL (VALUES TO MERGE; DEFAULTS TO THE OUTPUTS OF inputRefs)
+		"deep":   "boolean?", // OPTIONAL (MERGE NESTED OBJECTS INSTEAD OF REPLACING THEM)
+		"unique": "boolean?", // OPTIONAL (DROP REPEATED ITEMS WHEN MERGING ARRAYS)
+	}
+}
+
+func (t *MergeTask) GetOutputSchema() string {
+	return "any" // ARRAY OR OBJECT, LIKE ITS INPUTS
+}
+
+func (t *MergeTask) ValidateConfig(config map[string]any) error {
+	return nil
+}
+
+func (t *MergeTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	names, values := fanInValues(ctx, config)
+	if len(values) == 0 {
+		return TaskData{}, fmt.Errorf("MERGE NEEDS inputRefs OR values")
+	}
+	switch values[0].(type) {
+	case []any:
+		unique := boolConfig(config, "unique", false)
+		seen := make(map[string]bool)
+		merged := []any{}
+		for i, value := range values {
+			items, ok := value.([]any)
+			if !ok {
+				return TaskData{}, fmt.Errorf("CAN'T MERGE %s (%s) INTO AN ARRAY", names[i], validation.ValueTypeName(value))
+			}
+			for _, item := range items {
+				if unique {
+					key := fmt.Sprintf("%T:%v", item, item)
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+				}
+				merged = append(merged, item)
+			}
+		}
+		ctx.Logger.Printf("MERGED %d ARRAYS INTO %d ITEMS", len(values), len(merged))
+		return TaskData{Type: "array", Value: merged}, nil
+
+	case map[string]any:
+		deep := boolConfig(config, "deep", false)
+		merged := map[string]any{}
+		for i, value := range values {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return TaskData{}, fmt.Errorf("CAN'T MERGE %s (%s) INTO AN OBJECT", names[i], validation.ValueTypeName(value))
+			}
+			mergeObject(merged, object, deep)
+		}
+		ctx.Logger.Printf("MERGED %d OBJECTS INTO %d FIELDS", len(values), len(merged))
+		return TaskData{Type: "object", Value: merged}, nil
+	}
+	return TaskData{}, fmt.Errorf("CAN ONLY MERGE ARRAYS OR OBJECTS, GOT %s", validation.ValueTypeName(values[0]))
+}
+
+// COPY src'S FIELDS INTO dst, RECURSING INTO OBJECTS BOTH HAVE WHEN deep
+func mergeObject(dst, src map[string]any, deep bool) {
+	for key, value := range src {
+		if deep {
+			existing, isObject := dst[key].(map[string]any)
+			incoming, alsoObject := value.(map[string]any)
+			if isObject && alsoObject {
+				combined := maps.Clone(existing)
+				mergeObject(combined, incoming, true)
+				dst[key] = combined
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// ZIP TASK: PAIR UP ARRAYS BY POSITION INTO AN ARRAY OF OBJECTS
+type ZipTask struct{}
+
+func (t *ZipTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"values":  "array?",   // OPTIONAL (ARRAYS TO ZIP; DEFAULTS TO THE OUTPUTS OF inputRefs)
+		"keys":    "array?",   // OPTIONAL (FIELD NAME FOR EACH ARRAY, IN ORDER; DEFAULTS TO THE INPUT TASK IDS)
+		"longest": "boolean?", // OPTIONAL (RUN TO THE LONGEST ARRAY, FILLING GAPS WITH NULL; DEFAULTS TO THE SHORTEST)
+	}
+}
+
+func (t *ZipTask) GetOutputSchema() string {
+	return "array" // RETURNS ONE OBJECT PER POSITION
+}
+
+func (t *ZipTask) ValidateConfig(config map[string]any) error {
+	if keys, ok := config["keys"].([]any); ok {
+		seen := make(map[string]bool)
+		for _, key := range keys {
+			name, ok := key.(string)
+			if !ok || name == "" {
+				return fmt.Errorf("ZIP KEYS MUST BE FIELD NAMES")
+			}
+			if seen[name] {
+				return fmt.Errorf("ZIP KEY %q IS REPEATED", name)
+			}
+			seen[name] = true
+		}
+	}
+	return nil
+}
+
+func (t *ZipTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	names, values := fanInValues(ctx, config)
+	if len(values) == 0 {
+		return TaskData{}, fmt.Errorf("ZIP NEEDS inputRefs OR values")
+	}
+	if keys, ok := config["keys"].([]any); ok && len(keys) > 0 {
+		if len(keys) != len(values) {
+			return TaskData{}, fmt.Errorf("ZIP HAS %d KEYS FOR %d INPUTS", len(keys), len(values))
+		}
+		for i, key := range keys {
+			names[i], _ = key.(string)
+		}
+	}
+
+	arrays := make([][]any, len(values))
+	length := -1
+	longest := boolConfig(config, "longest", false)
+	for i, value := range values {
+		items, ok := value.([]any)
+		if !ok {
+			return TaskData{}, fmt.Errorf("CAN'T ZIP %s: EXPECTED AN ARRAY, GOT %s", names[i], validation.ValueTypeName(value))
+		}
+		arrays[i] = items
+		if length < 0 || (longest && len(items) > length) || (!longest && len(items) < length) {
+			length = len(items)
+		}
+	}
+
+	zipped := make([]any, length)
+	for row := range length {
+		object := make(map[string]any, len(arrays))
+		for i, items := range arrays {
+			if row < len(items) {
+				object[names[i]] = items[row]
+			} else {
+				object[names[i]] = nil
+			}
+		}
+		zipped[row] = object
+	}
+	ctx.Logger.Printf("ZIPPED %d ARRAYS INTO %d OBJECTS", len(arrays), length)
+	return TaskData{Type: "array", Value: zipped}, nil
+}
+
+// FLATTEN TASK: FLATTEN NESTED ARRAYS, OR NESTED OBJECT FIELDS INTO DOTTED KEYS
+type FlattenTask struct{}
+
+func (t *FlattenTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"values":    "array?",  // OPTIONAL (VALUES TO FLATTEN; DEFAULTS TO THE OUTPUTS OF inputRefs)
+		"depth":     "number?", // OPTIONAL (LEVELS OF NESTING TO REMOVE; DEFAULTS TO 1, 0 FOR ALL)
+		"separator": "string?", // OPTIONAL (BETWEEN KEYS WHEN FLATTENING OBJECTS; DEFAULTS TO ".")
+	}
+}
+
+func (t *FlattenTask) GetOutputSchema() string {
+	return "any" // ARRAY FOR ARRAY INPUTS, OBJECT FOR OBJECT INPUTS
+}
+
+func (t *FlattenTask) ValidateConfig(config map[string]any) error {
+	if depth, ok := config["depth"].(float64); ok && depth < 0 {
+		return fmt.Errorf("FLATTEN DEPTH CAN'T BE NEGATIVE")
+	}
+	return nil
+}
+
+func (t *FlattenTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	names, values := fanInValues(ctx, config)
+	if len(values) == 0 {
+		return TaskData{}, fmt.Errorf("FLATTEN NEEDS inputRefs OR values")
+	}
+	depth := 1
+	if v, ok := config["depth"].(float64); ok {
+		depth = int(v)
+	}
+	if depth == 0 {
+		depth = -1 // ALL THE WAY DOWN
+	}
+
+	// OBJECTS FLATTEN INTO ONE OBJECT OF DOTTED KEYS
+	if _, isObject := values[0].(map[string]any); isObject {
+		separator := "."
+		if s, ok := config["separator"].(string); ok && s != "" {
+			separator = s
+		}
+		flat := map[string]any{}
+		for i, value := range values {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return TaskData{}, fmt.Errorf("CAN'T FLATTEN %s (%s) WITH OBJECTS", names[i], validation.ValueTypeName(value))
+			}
+			flattenObject(flat, "", object, separator, depth)
+		}
+		return TaskData{Type: "object", Value: flat}, nil
+	}
+
+	// ANYTHING ELSE IS TREATED AS ONE LIST OF THE INPUTS (ONE EXTRA LEVEL), SO SEVERAL ARRAYS ARE ALSO CONCATENATED
+	levels := depth + 1
+	if depth < 0 {
+		levels = -1
+	}
+	flat := flattenArray([]any{}, values, levels)
+	ctx.Logger.Printf("FLATTENED %d INPUTS INTO %d ITEMS", len(values), len(flat))
+	return TaskData{Type: "array", Value: flat}, nil
+}
+
+// APPEND items TO out, SPREADING NESTED ARRAYS depth LEVELS DEEP (NEGATIVE FOR ALL)
+func flattenArray(out, items []any, depth int) []any {
+	for _, item := range items {
+		if nested, ok := item.([]any); ok && depth != 0 {
+			out = flattenArray(out, nested, depth-1)
+			continue
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// COPY AN OBJECT'S FIELDS INTO out UNDER prefix, JOINING NESTED KEYS depth LEVELS DEEP (NEGATIVE FOR ALL)
+func flattenObject(out map[string]any, prefix string, object map[string]any, separator string, depth int) {
+	for key, value := range object {
+		if prefix != "" {
+			key = prefix + separator + key
+		}
+		if nested, ok := value.(map[string]any); ok && depth != 0 && len(nested) > 0 {
+			flattenObject(out, key, nested, separator, depth-1)
+			continue
+		}
+		out[key] = value
+	}
+}
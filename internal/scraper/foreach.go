@@ -0,0 +1,257 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// -- FOR-EACH STAGES --
+//
+// A for-each STAGE RUNS ALL OF ITS TASKS, IN ORDER, ONCE PER ITEM OF AN ARRAY (NAVIGATE, EXTRACT,
+// DOWNLOAD, SAVE FOR EVERY LINK), WITH UP TO maxWorkers ITEMS AT ONCE. EACH ITEM GETS ITS OWN
+// SCRATCH SPACE: A REFERENCE TO THE ITEM SOURCE MEANS THE ITEM ITSELF, AND THE RESULTS OF THE
+// STAGE'S TASKS ARE ONLY SEEN BY THE LATER TASKS OF THE SAME ITEM. A FAILED TASK ENDS ITS ITEM
+// WITHOUT TOUCHING THE OTHERS. ONCE EVERY ITEM IS DONE, EACH TASK'S RESULTS ARE COLLECTED UNDER
+// ITS ID AS AN ARRAY IN ITEM ORDER (NULL WHERE THE ITEM DIDN'T GET THAT FAR) FOR LATER STAGES.
+
+type itemScopeKey struct{}
+
+// ONE ITEM'S TASK RESULTS. AN ITEM'S TASKS RUN ONE AT A TIME, SO IT NEEDS NO LOCK
+type itemScope struct {
+	index   int
+	results map[string]TaskData
+}
+
+// A SCOPE WHERE source MEANS THE ITEM AT index
+func newItemScope(index int, source string, item any) *itemScope {
+	return &itemScope{
+		index:   index,
+		results: map[string]TaskData{source: {Type: validation.ValueTypeName(item), Value: item}},
+	}
+}
+
+func withItemScope(ctx context.Context, scope *itemScope) context.Context {
+	return context.WithValue(ctx, itemScopeKey{}, scope)
+}
+
+// THE ITEM A CONTEXT IS RUNNING TASKS FOR, IF ANY
+func itemScopeFrom(ctx context.Context) *itemScope {
+	if ctx == nil {
+		return nil
+	}
+	scope, _ := ctx.Value(itemScopeKey{}).(*itemScope)
+	return scope
+}
+
+// A TASK RESULT AS A TASK RUNNING UNDER ctx SEES IT: ITS ITEM'S FIRST, THEN THE RUN'S
+func (e *Engine) taskResult(ctx context.Context, jobID, ref string) (TaskData, bool) {
+	if scope := itemScopeFrom(ctx); scope != nil {
+		if data, ok := scope.results[ref]; ok {
+			return data, true
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	data, ok := e.jobProgress[jobID].TaskResults[ref]
+	return data, ok
+}
+
+// THE ARRAY A for-each STAGE LOOPS OVER: THE TASK NAMED BY config.items, OR ELSE THE FIRST
+// ARRAY RESULT ONE OF ITS TASKS REFERENCES FROM AN EARLIER STAGE
+func (e *Engine) forEachSource(jobID string, stage models.Stage) (string, []any, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	results := e.jobProgress[jobID].TaskResults
+
+	if source, ok := stage.Config["items"].(string); ok && source != "" {
+		data, exists := results[source]
+		if !exists {
+			return "", nil, fmt.Errorf("ITEM SOURCE %s HAS NO RESULT", source)
+		}
+		items, isArray := data.Value.([]any)
+		if !isArray {
+			return "", nil, fmt.Errorf("ITEM SOURCE %s IS %s, NOT AN ARRAY", source, data.Type)
+		}
+		return source, items, nil
+	}
+
+	own := make([]string, 0, len(stage.Tasks))
+	for _, task := range stage.Tasks {
+		own = append(own, task.ID)
+	}
+	for _, task := range stage.Tasks {
+		for _, ref := range task.InputRefs {
+			if slices.Contains(own, ref) {
+				continue
+			}
+			if items, isArray := results[ref].Value.([]any); isArray {
+				return ref, items, nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("NO ARRAY INPUT FOUND FOR FOR-EACH STAGE")
+}
+
+// EXECUTE A for-each STAGE
+func (e *Engine) executeForEachTasks(ctx context.Context, jobID string, stage models.Stage, logger *log.Logger) error {
+	if len(stage.Tasks) == 0 {
+		logger.Printf("NO TASKS TO EXECUTE")
+		return nil
+	}
+
+	source, items, err := e.forEachSource(jobID, stage)
+	if err != nil {
+		logger.Printf("%v", err)
+		return err
+	}
+
+	// SAMPLE RUNS ONLY TAKE THE FIRST ITEMS
+	if limit := e.sampleLimit(jobID); limit > 0 && len(items) > limit {
+		logger.Printf("SAMPLE RUN: PROCESSING %d OF %d ITEMS", limit, len(items))
+		items = items[:limit]
+	}
+
+	// INCREMENTAL RUNS STOP AT THE FIRST ITEMS AN EARLIER RUN ALREADY COVERED
+	if cond, err := ParseStopCondition(stage.Config["stopWhen"]); err != nil {
+		logger.Printf("IGNORING INVALID STOP CONDITION: %v", err)
+	} else if cond != nil {
+		if kept, err := e.stopItems(jobID, stage, *cond, items); err != nil {
+			logger.Printf("STOP CONDITION FAILED, PROCESSING EVERY ITEM: %v", err)
+		} else {
+			items = kept
+		}
+	}
+
+	maxWorkers := stage.Parallelism.MaxWorkers
+	if maxWorkers <= 0 {
+		maxWorkers = 5 // DEFAULT
+	}
+	maxWorkers = min(maxWorkers, len(items))
+	logger.Printf("RUNNING %d TASKS FOR EACH OF %d ITEMS FROM %s WITH %d WORKERS", len(stage.Tasks), len(items), source, maxWorkers)
+
+	// EACH TASK'S RESULT PER ITEM; WORKERS ONLY WRITE THEIR OWN ITEMS' SLOTS
+	collected := make(map[string][]any, len(stage.Tasks))
+	for _, task := range stage.Tasks {
+		if task.ID != "" {
+			collected[task.ID] = make([]any, len(items))
+		}
+	}
+
+	queue := make(chan int, len(items))
+	for i := range items {
+		queue <- i
+	}
+	close(queue)
+
+	// EACH ITEM'S OUTCOME IS KEPT FOR THE API
+	itemLog := e.newItemResultLog(jobID, stage)
+	defer itemLog.flush()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for worker := range maxWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workerLogger := log.New(logger.Writer(), fmt.Sprintf("[WORKER %d] ", worker), 0)
+			ctx := withTimelineWorker(ctx, worker)
+			for index := range queue {
+				if err := e.waitForMemory(ctx, worker, maxWorkers, workerLogger); err != nil {
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				started := time.Now()
+				scope := newItemScope(index, source, items[index])
+				failedTask, err := e.runForEachItem(withItemScope(ctx, scope), jobID, stage, scope, workerLogger)
+				if err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+				outputs := make(map[string]any, len(scope.results))
+				for id, data := range scope.results {
+					if values, ok := collected[id]; ok {
+						values[index] = data.Value
+						outputs[id] = data.Value
+					}
+				}
+				if ctx.Err() == nil {
+					itemLog.add(index, items[index], started, outputs, failedTask, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// LATER STAGES SEE EACH TASK'S RESULTS ACROSS ALL ITEMS
+	e.mu.Lock()
+	progress := e.jobProgress[jobID]
+	for id, values := range collected {
+		progress.TaskResults[id] = TaskData{Type: "array", Value: values}
+	}
+	progress.CompletedTasks += len(stage.Tasks)
+	e.jobProgress[jobID] = progress
+	e.mu.Unlock()
+
+	logger.Printf("FOR-EACH COMPLETE: %d OF %d ITEMS SUCCEEDED", len(items)-failed, len(items))
+	return nil
+}
+
+// RUN A STAGE'S TASKS IN ORDER FOR ONE ITEM, STOPPING AT THE FIRST THAT FAILS (WHOSE ID IS RETURNED)
+func (e *Engine) runForEachItem(ctx context.Context, jobID string, stage models.Stage, scope *itemScope, logger *log.Logger) (string, error) {
+	logger.Printf("PROCESSING ITEM %d", scope.index)
+	for _, task := range stage.Tasks {
+		if ctx.Err() != nil {
+			return task.ID, ctx.Err()
+		}
+
+		if task.Condition.Type != "" && task.Condition.Type != "always" {
+			shouldExecute, err := e.evaluateCondition(ctx, jobID, task.Condition)
+			if err != nil {
+				e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Failed to evaluate task condition for item %d: %v", scope.index, err))
+				continue
+			}
+			if !shouldExecute {
+				continue
+			}
+		}
+
+		taskInputs, err := e.prepareTaskInputs(ctx, jobID, task)
+		if err != nil {
+			logger.Printf("FAILED TO PREPARE TASK INPUTS FOR ITEM %d: %v", scope.index, err)
+			e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Failed to prepare task inputs for item %d: %v", scope.index, err))
+			return task.ID, err
+		}
+
+		result, err := e.executeTask(ctx, jobID, task, taskInputs, logger)
+		if err != nil && task.RetryConfig.MaxRetries > 0 && ctx.Err() == nil {
+			if retryResult, retryErr := e.retryTask(ctx, jobID, task, taskInputs, logger); retryErr == nil {
+				result, err = retryResult, nil
+			}
+		}
+		if err != nil {
+			logger.Printf("TASK %s FAILED FOR ITEM %d, SKIPPING THE REST OF IT: %v", task.Name, scope.index, err)
+			e.addTaskError(jobID, stage, task, err, fmt.Sprintf("Task execution failed for item %d: %v", scope.index, err))
+			return task.ID, err
+		}
+		if task.ID != "" {
+			scope.results[task.ID] = result
+		}
+	}
+	logger.Printf("ITEM %d PROCESSED SUCCESSFULLY", scope.index)
+	return "", nil
+}
@@ -0,0 +1,97 @@
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// -- JOB HEADERS --
+
+// JOB RULES ADDING HEADERS TO EVERY BROWSER PAGE AND DIRECT HTTP REQUEST OF A RUN
+const (
+	headersRule       = "headers"       // {"X-Api-Key": "env:SHOP_KEY", "Referer": "https://example.com/"}
+	headerProfileRule = "headerProfile" // NAME (OR LIST OF NAMES) OF headerProfiles IN THE CONFIG
+)
+
+// MERGE A JOB'S HEADER PROFILES (IN ORDER) AND ITS OWN headers, WHICH WIN. VALUES ARE LEFT
+// UNRESOLVED, SO "env:NAME" REFERENCES CAN BE CHECKED WITHOUT READING THE ENVIRONMENT
+func JobHeaders(rules models.JSONMap, profiles map[string]map[string]string) (map[string]string, error) {
+	var names []string
+	switch v := rules[headerProfileRule].(type) {
+	case nil:
+	case string:
+		names = []string{v}
+	case []any:
+		for _, n := range v {
+			name, ok := n.(string)
+			if !ok {
+				return nil, fmt.Errorf("HEADER PROFILE MUST BE A NAME OR A LIST OF NAMES")
+			}
+			names = append(names, name)
+		}
+	default:
+		return nil, fmt.Errorf("HEADER PROFILE MUST BE A NAME OR A LIST OF NAMES")
+	}
+
+	merged := make(map[string]string)
+	for _, name := range names {
+		profile, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("UNKNOWN HEADER PROFILE %q", name)
+		}
+		for key, value := range profile {
+			merged[http.CanonicalHeaderKey(key)] = value
+		}
+	}
+
+	switch v := rules[headersRule].(type) {
+	case nil:
+	case map[string]any:
+		for key, value := range v {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("HEADER %s MUST BE A STRING", key)
+			}
+			merged[http.CanonicalHeaderKey(key)] = s
+		}
+	default:
+		return nil, fmt.Errorf("HEADERS MUST BE AN OBJECT OF HEADER NAMES TO VALUES")
+	}
+	for key := range merged {
+		if key == "" || strings.ContainsAny(key, " :\r\n") {
+			return nil, fmt.Errorf("INVALID HEADER NAME %q", key)
+		}
+	}
+	return merged, nil
+}
+
+// CHECK A JOB'S headers AND headerProfile RULES AGAINST THE CONFIGURED PROFILES
+func (e *Engine) ValidateJobHeaders(rules models.JSONMap) error {
+	_, err := JobHeaders(rules, e.cfg.HeaderProfiles)
+	return err
+}
+
+// THE RUNNING JOB'S HEADERS WITH "env:" VALUES RESOLVED (NIL WHEN IT HAS NONE)
+func (e *Engine) jobHeaders(jobID string) map[string]string {
+	e.mu.Lock()
+	rules := e.jobRules[jobID]
+	e.mu.Unlock()
+	merged, err := JobHeaders(rules, e.cfg.HeaderProfiles)
+	if err != nil || len(merged) == 0 {
+		return nil
+	}
+	for key, value := range merged {
+		merged[key] = secretValue(value)
+	}
+	return merged
+}
+
+// SET THE JOB'S HEADERS ON A REQUEST HEADER; CALLED BEFORE A TASK'S OWN headers SO THEY WIN
+func (e *Engine) setJobHeaders(jobID string, header http.Header) {
+	for key, value := range e.jobHeaders(jobID) {
+		header.Set(key, value)
+	}
+}
@@ -0,0 +1,211 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nickheyer/Crepes/internal/config"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// -- STREAMING HTML --
+//
+// PAGES FETCHED WITHOUT A BROWSER ARE TOKENIZED AS THEY ARRIVE INSTEAD OF BEING READ WHOLE AND
+// PARSED INTO A FULL DOM. ONLY THE ELEMENTS THE CALLER ASKS FOR ARE KEPT, WITH THEIR ATTRIBUTES
+// AND TEXT (INCLUDING TEXT OF ELEMENTS NESTED IN THEM), SO A HUGE PAGE COSTS THE MEMORY OF ITS
+// LINKS, NOT OF ITS MARKUP. PAST THE LIMITS PARSING STOPS OR TEXT IS CUT, AND THE REPORT SAYS SO.
+
+const (
+	defaultHTMLMaxBytes    = 50 << 20
+	defaultHTMLMaxElements = 100000
+	htmlMaxTextPerElement  = 1 << 20 // INLINE SCRIPTS CAN BE HUGE
+	htmlMaxDepth           = 1024    // DEEPER UNCLOSED MARKUP IS FLATTENED
+)
+
+// LIMITS FOR ONE DOCUMENT
+type HTMLLimits struct {
+	MaxBytes    int64 // 0 = 50MB
+	MaxElements int   // KEPT ELEMENTS, 0 = 100000
+}
+
+// WHAT PARSING A DOCUMENT COST AND WHETHER ANYTHING WAS LEFT OUT
+type HTMLParseReport struct {
+	Bytes     int64    `json:"bytes"`
+	Kept      int      `json:"elementsKept"`
+	Skipped   int      `json:"elementsSkipped"` // ELEMENTS NOT ASKED FOR
+	Truncated bool     `json:"truncated"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// LIMITS FROM THE htmlMaxBytes AND htmlMaxElements SETTINGS
+func HTMLLimitsFromConfig(cfg *config.Config) HTMLLimits {
+	return HTMLLimits{MaxBytes: cfg.HTMLMaxBytes, MaxElements: cfg.HTMLMaxElements}
+}
+
+func (l HTMLLimits) maxBytes() int64 {
+	if l.MaxBytes <= 0 {
+		return defaultHTMLMaxBytes
+	}
+	return l.MaxBytes
+}
+
+func (l HTMLLimits) maxElements() int {
+	if l.MaxElements <= 0 {
+		return defaultHTMLMaxElements
+	}
+	return l.MaxElements
+}
+
+// READER THAT STOPS AT A LIMIT AND REMEMBERS WHETHER THERE WAS MORE
+type cappedReader struct {
+	r       io.Reader
+	left    int64
+	read    int64
+	overran bool
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		var probe [1]byte
+		if n, _ := c.r.Read(probe[:]); n > 0 {
+			c.overran = true
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	c.read += int64(n)
+	return n, err
+}
+
+// READ UP TO THE BYTE LIMIT, SAYING WHETHER THE BODY WAS CUT
+func readCapped(r io.Reader, limit int64) ([]byte, bool, error) {
+	capped := &cappedReader{r: r, left: limit}
+	data, err := io.ReadAll(capped)
+	return data, capped.overran, err
+}
+
+// TOKENIZE A DOCUMENT, KEEPING ONLY THE NAMED ELEMENTS ("*" KEEPS EVERYTHING)
+func parseHTMLStream(r io.Reader, keep []string, limits HTMLLimits) (*goquery.Document, HTMLParseReport, error) {
+	var report HTMLParseReport
+	keepAll := slices.Contains(keep, "*")
+	kept := make(map[string]bool, len(keep))
+	for _, tag := range keep {
+		kept[strings.ToLower(tag)] = true
+	}
+
+	// OPEN ELEMENTS; node IS NIL FOR ONES THAT WEREN'T KEPT
+	type open struct {
+		tag  string
+		node *html.Node
+	}
+	root := &html.Node{Type: html.DocumentNode}
+	var stack []open
+	textLen := make(map[*html.Node]int)
+	cutText := make(map[string]bool)
+	maxBytes, maxElements := limits.maxBytes(), limits.maxElements()
+
+	// NEAREST KEPT ANCESTOR, OR THE DOCUMENT
+	parent := func() *html.Node {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].node != nil {
+				return stack[i].node
+			}
+		}
+		return nil
+	}
+
+	source := &cappedReader{r: r, left: maxBytes}
+	z := html.NewTokenizer(source)
+tokens:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				report.Bytes = source.read
+				return nil, report, err
+			}
+			break tokens
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			void := token.Type == html.SelfClosingTagToken || isVoidElement(token.DataAtom)
+			var node *html.Node
+			if keepAll || kept[token.Data] {
+				if report.Kept >= maxElements {
+					report.Truncated = true
+					report.Warnings = append(report.Warnings, fmt.Sprintf("STOPPED AFTER %d ELEMENTS, THE REST OF THE DOCUMENT WAS NOT PARSED", maxElements))
+					break tokens
+				}
+				node = &html.Node{Type: html.ElementNode, Data: token.Data, DataAtom: token.DataAtom, Attr: token.Attr}
+				if p := parent(); p != nil {
+					p.AppendChild(node)
+				} else {
+					root.AppendChild(node)
+				}
+				report.Kept++
+			} else {
+				report.Skipped++
+			}
+			if !void && len(stack) < htmlMaxDepth {
+				stack = append(stack, open{tag: token.Data, node: node})
+			}
+		case html.EndTagToken:
+			// CLOSE BACK TO THE MATCHING ELEMENT; STRAY END TAGS ARE IGNORED
+			name, _ := z.TagName()
+			tag := string(name)
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].tag == tag {
+					stack = stack[:i]
+					break
+				}
+			}
+		case html.TextToken:
+			p := parent()
+			if p == nil {
+				continue
+			}
+			text := string(z.Text())
+			if room := htmlMaxTextPerElement - textLen[p]; len(text) > room {
+				text = text[:max(room, 0)]
+				if !cutText[p.Data] {
+					cutText[p.Data] = true
+					report.Warnings = append(report.Warnings, fmt.Sprintf("TEXT INSIDE <%s> CUT AT %d BYTES", p.Data, htmlMaxTextPerElement))
+				}
+			}
+			if text == "" {
+				continue
+			}
+			textLen[p] += len(text)
+			if last := p.LastChild; last != nil && last.Type == html.TextNode {
+				last.Data += text
+			} else {
+				p.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+			}
+		}
+	}
+
+	report.Bytes = source.read
+	if source.overran {
+		report.Truncated = true
+		report.Warnings = append(report.Warnings, fmt.Sprintf("DOCUMENT LARGER THAN %d BYTES, ONLY THE START WAS PARSED", maxBytes))
+	}
+	return goquery.NewDocumentFromNode(root), report, nil
+}
+
+// ELEMENTS THAT NEVER HAVE CONTENT OR AN END TAG
+func isVoidElement(a atom.Atom) bool {
+	switch a {
+	case atom.Area, atom.Base, atom.Br, atom.Col, atom.Embed, atom.Hr, atom.Img, atom.Input,
+		atom.Link, atom.Meta, atom.Param, atom.Source, atom.Track, atom.Wbr:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,348 @@
+package scraper
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// HTTP CLIENT OPTIONS FOR DIRECT FETCHES AND DOWNLOADS
+type HTTPClientOptions struct {
+	Timeout     time.Duration
+	Fingerprint string // TLS CLIENTHELLO TO IMPERSONATE (chrome, firefox, safari, edge, ios, randomized)
+	HTTPVersion string // auto, 1.1, 2, 3
+	TLS         TLSPolicy
+	Guard       *NetGuard    // SSRF PROTECTION (NIL ALLOWS ANY ADDRESS)
+	DNS         DNSPolicy    // HOW NAMES ARE RESOLVED (ZERO USES THE SYSTEM RESOLVER)
+	Egress      EgressPolicy // IP VERSION AND LOCAL ADDRESS OR INTERFACE TO CONNECT FROM
+}
+
+// DIALS A NETWORK CONNECTION
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// SUPPORTED TLS FINGERPRINTS
+var tlsFingerprints = map[string]utls.ClientHelloID{
+	"chrome":     utls.HelloChrome_Auto,
+	"firefox":    utls.HelloFirefox_Auto,
+	"safari":     utls.HelloSafari_Auto,
+	"edge":       utls.HelloEdge_Auto,
+	"ios":        utls.HelloIOS_Auto,
+	"randomized": utls.HelloRandomizedNoALPN,
+}
+
+// RETURNED BY THE H2 DIALER WHEN A SERVER DOES NOT NEGOTIATE H2
+var errNoH2 = errors.New("SERVER DID NOT NEGOTIATE HTTP/2")
+
+// HOW LONG A HOST THAT REFUSED THE NEWER PROTOCOL IS SENT STRAIGHT TO HTTP/1.1
+const h1PinDuration = 30 * time.Minute
+
+// THE NEWER PROTOCOL COULDN'T BE SET UP (ALPN, TLS HANDSHAKE OR QUIC DIAL). NOTHING OF THE
+// REQUEST WAS SENT, SO IT IS SAFE TO TRY AGAIN OVER HTTP/1.1
+type negotiationError struct{ err error }
+
+func (e *negotiationError) Error() string { return e.err.Error() }
+func (e *negotiationError) Unwrap() error { return e.err }
+
+// SHARED TRANSPORTS SO CONNECTIONS ARE REUSED PER HOST ACROSS TASKS
+var (
+	transportCache   = make(map[string]http.RoundTripper)
+	transportCacheMu sync.Mutex
+)
+
+// VALIDATE A TLS FINGERPRINT NAME
+func ValidateFingerprint(name string) error {
+	name = strings.ToLower(name)
+	if name == "" || name == "go" || name == "default" {
+		return nil
+	}
+	if _, ok := tlsFingerprints[name]; !ok {
+		return fmt.Errorf("UNKNOWN TLS FINGERPRINT: %s", name)
+	}
+	return nil
+}
+
+// VALIDATE AN HTTP VERSION PREFERENCE
+func ValidateHTTPVersion(version string) error {
+	switch version {
+	case "", "auto", "1.1", "2", "3":
+		return nil
+	default:
+		return fmt.Errorf("UNKNOWN HTTP VERSION: %s", version)
+	}
+}
+
+// CREATE AN HTTP CLIENT FOR DIRECT REQUESTS
+func NewHTTPClient(opts HTTPClientOptions) *http.Client {
+	return &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: &budgetTransport{base: &throttleTransport{base: &sessionTransport{base: &userAgentTransport{base: &playbackTransport{base: sharedTransport(opts)}}}}},
+	}
+}
+
+// GET OR BUILD THE SHARED TRANSPORT FOR THE GIVEN OPTIONS
+func sharedTransport(opts HTTPClientOptions) http.RoundTripper {
+	key := strings.ToLower(opts.Fingerprint) + "|" + opts.HTTPVersion + "|" + opts.TLS.key() + "|" + opts.Guard.cacheKey() + "|" + opts.DNS.key() + "|" + opts.Egress.key()
+
+	transportCacheMu.Lock()
+	defer transportCacheMu.Unlock()
+
+	if rt, ok := transportCache[key]; ok {
+		return rt
+	}
+	rt := newTransport(opts)
+	transportCache[key] = rt
+	return rt
+}
+
+// BUILD TRANSPORT FOR THE REQUESTED PROTOCOL AND FINGERPRINT
+func newTransport(opts HTTPClientOptions) http.RoundTripper {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	dial := opts.DNS.dialContext(opts.Guard, opts.Egress, &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+	base.DialContext = dial
+	// THE RUN'S PROXY (SEE overrides.go), OR THE ENVIRONMENT'S
+	base.Proxy = settingsProxy
+	if opts.HTTPVersion == "1.1" {
+		base.DialTLSContext = opts.TLS.dialTLS(dial, []string{"http/1.1"})
+	} else {
+		base.DialTLSContext = opts.TLS.dialTLS(dial, []string{"h2", "http/1.1"})
+	}
+
+	// HTTP/3 OVER QUIC, FALLING BACK TO TCP WHEN THE HOST DOES NOT SPEAK IT
+	if opts.HTTPVersion == "3" {
+		quicTLS := opts.TLS.tlsConfig("")
+		if quicTLS == nil {
+			quicTLS = &tls.Config{}
+		}
+		dialQUIC := opts.DNS.dialQUIC(opts.Guard, opts.Egress)
+		if dialQUIC == nil {
+			dialQUIC = quic.DialAddrEarly
+		}
+		primary := &http3.Transport{
+			TLSClientConfig: quicTLS,
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+				conn, err := dialQUIC(ctx, addr, tlsCfg, cfg)
+				if err != nil {
+					return nil, &negotiationError{err}
+				}
+				return conn, nil
+			},
+		}
+		return &fallbackTransport{
+			primary:  primary,
+			fallback: base,
+			h1Hosts:  make(map[string]time.Time),
+		}
+	}
+
+	helloID, ok := tlsFingerprints[strings.ToLower(opts.Fingerprint)]
+	if !ok {
+		if opts.HTTPVersion == "1.1" {
+			// AN EMPTY NEXT PROTO MAP DISABLES HTTP/2
+			base.ForceAttemptHTTP2 = false
+			base.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		}
+		return base
+	}
+
+	// HTTP/1.1 TRANSPORT WITH ALPN PINNED SO THE HANDSHAKE NEVER PICKS H2
+	base.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialUTLS(ctx, dial, network, addr, helloID, []string{"http/1.1"}, opts.TLS)
+	}
+	if opts.HTTPVersion == "1.1" {
+		return base
+	}
+
+	// HTTP/2 TRANSPORT USING THE BROWSER'S FULL ALPN LIST
+	h2 := &http2.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			// A TCP CONNECT THAT FAILS WOULD FAIL FOR HTTP/1.1 TOO; ONLY THE HANDSHAKE IS H2'S OWN
+			var connected bool
+			tcp := func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				connected = err == nil
+				return conn, err
+			}
+			conn, err := dialUTLS(ctx, tcp, network, addr, helloID, nil, opts.TLS)
+			if err != nil {
+				if connected {
+					return nil, &negotiationError{err}
+				}
+				return nil, err
+			}
+			if conn.ConnectionState().NegotiatedProtocol != http2.NextProtoTLS {
+				conn.Close()
+				return nil, &negotiationError{errNoH2}
+			}
+			return conn, nil
+		},
+	}
+	return &fallbackTransport{
+		primary:  h2,
+		fallback: base,
+		h1Hosts:  make(map[string]time.Time),
+	}
+}
+
+// FALLBACK TRANSPORT TRIES A NEWER PROTOCOL FIRST AND, FOR A WHILE, REMEMBERS HOSTS THAT
+// REFUSED IT. ONLY A FAILURE TO SET THE PROTOCOL UP FALLS BACK; AN ERROR ONCE THE REQUEST
+// WAS ON ITS WAY (A TIMEOUT, A RESET) IS RETURNED AS IT IS, SINCE THE SERVER MAY HAVE ACTED ON IT
+type fallbackTransport struct {
+	primary  http.RoundTripper
+	fallback http.RoundTripper
+	mu       sync.Mutex
+	h1Hosts  map[string]time.Time // HOST -> WHEN IT IS TRIED OVER THE NEWER PROTOCOL AGAIN
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// ONLY THE TCP TRANSPORT KNOWS HOW TO GO THROUGH A PROXY
+	if req.URL.Scheme != "https" || usesRunProxy(req) {
+		return t.fallback.RoundTrip(req)
+	}
+
+	t.mu.Lock()
+	if t.h1Hosts == nil {
+		t.h1Hosts = make(map[string]time.Time)
+	}
+	until, pinned := t.h1Hosts[req.URL.Host]
+	if pinned && !time.Now().Before(until) {
+		delete(t.h1Hosts, req.URL.Host)
+		pinned = false
+	}
+	t.mu.Unlock()
+
+	if pinned {
+		return t.fallback.RoundTrip(req)
+	}
+
+	resp, err := t.primary.RoundTrip(req)
+	if err == nil {
+		return resp, nil
+	}
+
+	var negotiation *negotiationError
+	if !errors.As(err, &negotiation) || req.Context().Err() != nil {
+		return nil, err
+	}
+	// THE PRIMARY CLOSED THE BODY; IT CAN ONLY BE SENT AGAIN IF IT CAN BE REBUILT
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.h1Hosts[req.URL.Host] = time.Now().Add(h1PinDuration)
+	t.mu.Unlock()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return t.fallback.RoundTrip(retry)
+}
+
+// DIAL A TLS CONNECTION USING A BROWSER CLIENTHELLO
+func dialUTLS(ctx context.Context, dial dialFunc, network, addr string, helloID utls.ClientHelloID, alpn []string, policy TLSPolicy) (*utls.UConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rawConn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &utls.Config{ServerName: host}
+	policy.applyUTLS(config, host)
+
+	// OVERRIDE ALPN WHEN THE CALLER ONLY SUPPORTS A SUBSET OF PROTOCOLS
+	var conn *utls.UConn
+	spec, err := utls.UTLSIdToSpec(helloID)
+	if err == nil {
+		if alpn != nil {
+			for _, ext := range spec.Extensions {
+				if alpnExt, ok := ext.(*utls.ALPNExtension); ok {
+					alpnExt.AlpnProtocols = alpn
+				}
+			}
+		}
+		conn = utls.UClient(rawConn, config, utls.HelloCustom)
+		if err := conn.ApplyPreset(&spec); err != nil {
+			rawConn.Close()
+			return nil, fmt.Errorf("FAILED TO APPLY TLS FINGERPRINT: %v", err)
+		}
+	} else {
+		conn = utls.UClient(rawConn, config, helloID)
+	}
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// REDIRECT POLICY FOR DIRECT HTTP REQUESTS
+type RedirectPolicy struct {
+	Mode         string `json:"mode"`         // follow, never, same-host
+	MaxRedirects int    `json:"maxRedirects"` // ZERO MEANS DEFAULT (10)
+}
+
+// VALIDATE A REDIRECT MODE
+func ValidateRedirectMode(mode string) error {
+	switch mode {
+	case "", "follow", "never", "same-host":
+		return nil
+	default:
+		return fmt.Errorf("UNKNOWN REDIRECT MODE: %s", mode)
+	}
+}
+
+// BUILD A CHECKREDIRECT FUNC THAT ENFORCES THE POLICY AND RECORDS EVERY HOP
+func (p RedirectPolicy) CheckRedirect(chain *[]string) func(req *http.Request, via []*http.Request) error {
+	maxRedirects := p.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = 10
+	}
+
+	return func(req *http.Request, via []*http.Request) error {
+		// RECORD THE HOP BEFORE DECIDING SO THE CHAIN ALWAYS ENDS AT THE LAST LOCATION SEEN
+		if chain != nil {
+			if len(*chain) == 0 && len(via) > 0 {
+				*chain = append(*chain, via[0].URL.String())
+			}
+			*chain = append(*chain, req.URL.String())
+		}
+
+		switch p.Mode {
+		case "never":
+			return http.ErrUseLastResponse
+		case "same-host":
+			if len(via) > 0 && req.URL.Hostname() != via[0].URL.Hostname() {
+				return http.ErrUseLastResponse
+			}
+		}
+
+		if len(via) >= maxRedirects {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
@@ -0,0 +1,228 @@
+package scraper
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- HUMAN-LIKE INTERACTION --
+//
+// WITH humanize ON, CLICKS ARE PRECEDED BY A CURVED MOUSE PATH TO A RANDOM POINT INSIDE
+// THE ELEMENT, TEXT IS TYPED KEY BY KEY WITH A VARYING CADENCE AND SCROLLS MOVE IN UNEVEN
+// WHEEL STEPS, WITH THE OCCASIONAL IDLE PAUSE OR STRAY SCROLL IN BETWEEN.
+
+// JOB RULE TURNING HUMAN-LIKE PACING ON OR OFF FOR A JOB (OVERRIDES THE humanize SETTING)
+const humanizeRule = "humanize"
+
+// A POINT IN PAGE COORDINATES
+type humanPoint struct{ X, Y float64 }
+
+// LAST MOUSE POSITION OF EACH PAGE, SO EVERY PATH STARTS WHERE THE LAST ONE ENDED
+type cursorTracker struct {
+	mu        sync.Mutex
+	positions map[playwright.Page]humanPoint
+}
+
+func newCursorTracker() *cursorTracker {
+	return &cursorTracker{positions: make(map[playwright.Page]humanPoint)}
+}
+
+// LAST POSITION ON A PAGE; A NEW PAGE STARTS SOMEWHERE IN ITS VIEWPORT
+func (c *cursorTracker) get(page playwright.Page) humanPoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.positions[page]; ok {
+		return p
+	}
+	width, height := 1280.0, 720.0
+	if size := page.ViewportSize(); size != nil {
+		width, height = float64(size.Width), float64(size.Height)
+	}
+	p := humanPoint{X: width * (0.2 + 0.6*rand.Float64()), Y: height * (0.2 + 0.6*rand.Float64())}
+	c.positions[page] = p
+	page.OnClose(func(page playwright.Page) {
+		c.mu.Lock()
+		delete(c.positions, page)
+		c.mu.Unlock()
+	})
+	return p
+}
+
+func (c *cursorTracker) set(page playwright.Page, p humanPoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.positions[page] = p
+}
+
+// WHETHER A TASK SHOULD ACT LIKE A PERSON: ITS humanize INPUT, THEN THE JOB'S RULE, THEN THE SETTING
+func humanizeEnabled(ctx *TaskContext, config map[string]any) bool {
+	if v, ok := config["humanize"].(bool); ok {
+		return v
+	}
+	if v, ok := ctx.Engine.jobRule(ctx.JobID, humanizeRule); ok {
+		if enabled, ok := v.(bool); ok {
+			return enabled
+		}
+	}
+	return ctx.Engine.cfg.Humanize
+}
+
+// SLEEP FOR A RANDOM TIME IN [min, max), RETURNING EARLY IF THE JOB IS CANCELLED
+func humanSleep(ctx *TaskContext, min, max time.Duration) error {
+	d := min
+	if max > min {
+		d += time.Duration(rand.Int64N(int64(max - min)))
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Context.Done():
+		return ctx.Context.Err()
+	}
+}
+
+// MOVE THE MOUSE TO to ALONG A CUBIC BEZIER WITH RANDOM CONTROL POINTS, EASED AT BOTH ENDS
+// AND SLIGHTLY JITTERED, OVERSHOOTING A LITTLE ON LONG MOVES
+func humanMove(ctx *TaskContext, page playwright.Page, to humanPoint) error {
+	from := ctx.Engine.cursors.get(page)
+	distance := math.Hypot(to.X-from.X, to.Y-from.Y)
+	if distance < 2 {
+		return nil
+	}
+
+	target := to
+	if distance > 300 && rand.Float64() < 0.3 {
+		overshoot := 0.03 + 0.05*rand.Float64()
+		target = humanPoint{X: to.X + (to.X-from.X)*overshoot, Y: to.Y + (to.Y-from.Y)*overshoot}
+	}
+
+	// CONTROL POINTS OFF THE STRAIGHT LINE, ON A RANDOM SIDE
+	spread := math.Min(distance*0.4, 200)
+	c1 := humanPoint{X: from.X + (target.X-from.X)*0.3 + (rand.Float64()-0.5)*spread, Y: from.Y + (target.Y-from.Y)*0.3 + (rand.Float64()-0.5)*spread}
+	c2 := humanPoint{X: from.X + (target.X-from.X)*0.7 + (rand.Float64()-0.5)*spread, Y: from.Y + (target.Y-from.Y)*0.7 + (rand.Float64()-0.5)*spread}
+
+	steps := int(math.Max(12, math.Min(60, distance/12)))
+	for i := 1; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		t = t * t * (3 - 2*t) // EASE IN AND OUT
+		u := 1 - t
+		p := humanPoint{
+			X: u*u*u*from.X + 3*u*u*t*c1.X + 3*u*t*t*c2.X + t*t*t*target.X,
+			Y: u*u*u*from.Y + 3*u*u*t*c1.Y + 3*u*t*t*c2.Y + t*t*t*target.Y,
+		}
+		if i < steps {
+			p.X += rand.NormFloat64() * 0.8
+			p.Y += rand.NormFloat64() * 0.8
+		}
+		if err := page.Mouse().Move(p.X, p.Y); err != nil {
+			return fmt.Errorf("MOUSE MOVE FAILED: %v", err)
+		}
+		ctx.Engine.cursors.set(page, p)
+		if err := humanSleep(ctx, 4*time.Millisecond, 16*time.Millisecond); err != nil {
+			return err
+		}
+	}
+
+	// CORRECT AN OVERSHOOT WITH A SHORT, SLOWER MOVE
+	if target != to {
+		if err := humanSleep(ctx, 40*time.Millisecond, 120*time.Millisecond); err != nil {
+			return err
+		}
+		return humanMove(ctx, page, to)
+	}
+	return nil
+}
+
+// A RANDOM POINT INSIDE AN ELEMENT, FAVOURING ITS MIDDLE
+func humanTarget(ctx *TaskContext, page playwright.Page, selector string, timeout *float64) (humanPoint, error) {
+	locator := page.Locator(selector).First()
+	if err := locator.ScrollIntoViewIfNeeded(playwright.LocatorScrollIntoViewIfNeededOptions{Timeout: timeout}); err != nil {
+		return humanPoint{}, fmt.Errorf("ELEMENT NOT VISIBLE: %v", err)
+	}
+	box, err := locator.BoundingBox(playwright.LocatorBoundingBoxOptions{Timeout: timeout})
+	if err != nil || box == nil {
+		return humanPoint{}, fmt.Errorf("ELEMENT HAS NO BOUNDING BOX: %v", err)
+	}
+	offset := func() float64 { return math.Max(-0.4, math.Min(0.4, rand.NormFloat64()*0.15)) }
+	return humanPoint{X: box.X + box.Width*(0.5+offset()), Y: box.Y + box.Height*(0.5+offset())}, nil
+}
+
+// NOW AND THEN, PAUSE OR NUDGE THE PAGE BEFORE AN ACTION THE WAY A READER WOULD
+func humanIdle(ctx *TaskContext, page playwright.Page) error {
+	switch roll := rand.Float64(); {
+	case roll < 0.1:
+		delta := (40 + rand.Float64()*160) * float64(1-2*rand.IntN(2))
+		if err := page.Mouse().Wheel(0, delta); err != nil {
+			return nil // A STRAY SCROLL IS COSMETIC
+		}
+		return humanSleep(ctx, 300*time.Millisecond, 900*time.Millisecond)
+	case roll < 0.25:
+		return humanSleep(ctx, 400*time.Millisecond, 1800*time.Millisecond)
+	}
+	return nil
+}
+
+// MOVE TO AN ELEMENT AND CLICK IT WITH A HUMAN PRESS DURATION
+func humanClick(ctx *TaskContext, page playwright.Page, selector string, options playwright.MouseClickOptions, timeout *float64) error {
+	if err := humanIdle(ctx, page); err != nil {
+		return err
+	}
+	point, err := humanTarget(ctx, page, selector, timeout)
+	if err != nil {
+		return err
+	}
+	if err := humanMove(ctx, page, point); err != nil {
+		return err
+	}
+	if err := humanSleep(ctx, 60*time.Millisecond, 220*time.Millisecond); err != nil {
+		return err
+	}
+	options.Delay = playwright.Float(float64(40 + rand.IntN(90)))
+	return page.Mouse().Click(point.X, point.Y, options)
+}
+
+// TYPE TEXT INTO THE FOCUSED ELEMENT ONE KEY AT A TIME: FASTER INSIDE WORDS, SLOWER AFTER
+// SPACES AND PUNCTUATION, WITH AN OCCASIONAL LONGER HESITATION
+func humanType(ctx *TaskContext, page playwright.Page, text string) error {
+	for _, r := range text {
+		if err := page.Keyboard().Type(string(r)); err != nil {
+			return fmt.Errorf("TYPING CHAR FAILED: %v", err)
+		}
+		min, max := 50*time.Millisecond, 170*time.Millisecond
+		switch {
+		case rand.Float64() < 0.03:
+			min, max = 350*time.Millisecond, 1100*time.Millisecond
+		case unicode.IsSpace(r) || unicode.IsPunct(r):
+			min, max = 120*time.Millisecond, 320*time.Millisecond
+		}
+		if err := humanSleep(ctx, min, max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SCROLL BY (x, y) IN UNEVEN WHEEL STEPS WITH SHORT GAPS, LIKE A WHEEL OR TRACKPAD
+func humanScroll(ctx *TaskContext, page playwright.Page, x, y float64) error {
+	for math.Abs(x) > 0.5 || math.Abs(y) > 0.5 {
+		step := 60 + rand.Float64()*100
+		dx := math.Copysign(math.Min(math.Abs(x), step), x)
+		dy := math.Copysign(math.Min(math.Abs(y), step), y)
+		if err := page.Mouse().Wheel(dx, dy); err != nil {
+			return fmt.Errorf("WHEEL SCROLL FAILED: %v", err)
+		}
+		x, y = x-dx, y-dy
+		if err := humanSleep(ctx, 30*time.Millisecond, 140*time.Millisecond); err != nil {
+			return err
+		}
+	}
+	return humanSleep(ctx, 150*time.Millisecond, 600*time.Millisecond)
+}
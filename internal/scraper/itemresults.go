@@ -0,0 +1,108 @@
+package scraper
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// -- ITEM RESULTS --
+//
+// EVERY ITEM A WORKER-PER-ITEM OR FOR-EACH STAGE PROCESSES LEAVES A ROW: WHETHER IT SUCCEEDED,
+// HOW LONG IT TOOK, WHAT IT PRODUCED AND, WHEN IT FAILED, WHICH TASK FAILED AND WHY. ONE BAD
+// ITEM OUT OF THOUSANDS CAN THEN BE FOUND THROUGH THE API. ROWS ARE WRITTEN IN BATCHES.
+
+// ITEM RESULT STATUSES
+const (
+	ItemSucceeded = "succeeded"
+	ItemFailed    = "failed"
+)
+
+// ROWS HELD BEFORE THEY ARE WRITTEN
+const itemResultBatch = 200
+
+// LARGEST ITEM OR OUTPUT KEPT, ENCODED; BIGGER ONES (WHOLE PAGES OF HTML) ARE DROPPED
+const maxItemResultBytes = 64 << 10
+
+// ONE STAGE'S ITEM RESULTS, WAITING TO BE WRITTEN
+type itemResultLog struct {
+	engine  *Engine
+	jobID   string
+	runID   string
+	stage   string
+	mu      sync.Mutex
+	pending []models.ItemResult
+}
+
+func (e *Engine) newItemResultLog(jobID string, stage models.Stage) *itemResultLog {
+	e.mu.Lock()
+	runID := e.jobProgress[jobID].RunID
+	e.mu.Unlock()
+	return &itemResultLog{engine: e, jobID: jobID, runID: runID, stage: stageLabel(stage)}
+}
+
+// NOTE HOW AN ITEM WENT. taskID NAMES THE TASK THAT FAILED
+func (l *itemResultLog) add(index int, item any, started time.Time, output any, taskID string, err error) {
+	result := models.ItemResult{
+		ID:         generateID("item"),
+		JobID:      l.jobID,
+		RunID:      l.runID,
+		Stage:      l.stage,
+		ItemIndex:  index,
+		Status:     ItemSucceeded,
+		DurationMs: time.Since(started).Milliseconds(),
+		CreatedAt:  time.Now(),
+	}
+	result.Item, _ = cappedJSON(item)
+	if err != nil {
+		result.Status = ItemFailed
+		result.TaskID = taskID
+		result.Error = err.Error()
+	} else {
+		result.Output, result.OutputTruncated = cappedJSON(output)
+	}
+
+	l.engine.mu.Lock()
+	if progress, ok := l.engine.jobProgress[l.jobID]; ok {
+		progress.ItemsProcessed++
+		if err != nil {
+			progress.ItemsFailed++
+		}
+		l.engine.jobProgress[l.jobID] = progress
+	}
+	l.engine.mu.Unlock()
+
+	l.mu.Lock()
+	l.pending = append(l.pending, result)
+	full := len(l.pending) >= itemResultBatch
+	l.mu.Unlock()
+	if full {
+		l.flush()
+	}
+}
+
+// WRITE THE WAITING ROWS
+func (l *itemResultLog) flush() {
+	l.mu.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	if err := l.engine.db.CreateInBatches(batch, itemResultBatch).Error; err != nil {
+		log.Printf("[JOB %s] FAILED TO SAVE %d ITEM RESULTS: %v", l.jobID, len(batch), err)
+	}
+}
+
+// A VALUE AS JSON, OR NULL AND TRUE WHEN IT IS TOO LARGE (OR CAN'T BE ENCODED)
+func cappedJSON(value any) (json.RawMessage, bool) {
+	encoded, err := json.Marshal(value)
+	if err != nil || len(encoded) > maxItemResultBytes {
+		return json.RawMessage("null"), true
+	}
+	return encoded, false
+}
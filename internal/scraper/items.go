@@ -0,0 +1,322 @@
+package scraper
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -- ARRAY TASKS --
+//
+// sortItems, uniqueItems, sliceItems AND sampleItems RESHAPE AN ARRAY FROM AN EARLIER TASK, SO A
+// PIPELINE CAN TAKE "THE 20 NEWEST LINKS" (sortItems BY date DESCENDING, THEN sliceItems TO 20)
+// WITHOUT A SCRIPT. by NAMES A FIELD OF OBJECT ITEMS, DOTTED FOR NESTED ONES (E.G. "meta.date").
+
+// THE items INPUT
+func itemsConfig(config map[string]any) ([]any, error) {
+	items, ok := config["items"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("ITEMS MUST BE AN ARRAY")
+	}
+	return items, nil
+}
+
+// AN INTEGER INPUT THAT MAY BE ZERO OR NEGATIVE
+func intConfig(config map[string]any, key string) (int, bool) {
+	v, ok := config[key].(float64)
+	return int(v), ok
+}
+
+// AN ITEM'S FIELD AT A DOTTED PATH (THE ITEM ITSELF FOR AN EMPTY PATH, NIL WHEN MISSING)
+func itemField(item any, path string) any {
+	if path == "" {
+		return item
+	}
+	for _, part := range strings.Split(path, ".") {
+		object, ok := item.(map[string]any)
+		if !ok {
+			return nil
+		}
+		item = object[part]
+	}
+	return item
+}
+
+// SORT KEY KINDS, IN THE ORDER MIXED KINDS SORT
+const (
+	sortNumber = iota
+	sortTime
+	sortText
+	sortBool
+	sortOther
+	sortMissing
+)
+
+type sortKey struct {
+	kind   int
+	number float64
+	time   time.Time
+	text   string
+}
+
+// LAYOUTS TRIED WHEN A STRING MIGHT BE A DATE
+var sortTimeLayouts = []string{time.RFC3339Nano, time.RFC1123Z, time.RFC1123, "2006-01-02 15:04:05", time.DateOnly}
+
+// A VALUE'S SORT KEY: NUMBERS (AND NUMERIC STRINGS), THEN DATES, OTHER TEXT AND BOOLEANS, MISSING VALUES LAST
+func sortKeyOf(v any) sortKey {
+	switch v := v.(type) {
+	case nil:
+		return sortKey{kind: sortMissing}
+	case float64:
+		return sortKey{kind: sortNumber, number: v}
+	case int:
+		return sortKey{kind: sortNumber, number: float64(v)}
+	case bool:
+		if v {
+			return sortKey{kind: sortBool, number: 1}
+		}
+		return sortKey{kind: sortBool}
+	case string:
+		s := strings.TrimSpace(v)
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return sortKey{kind: sortNumber, number: n}
+		}
+		for _, layout := range sortTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return sortKey{kind: sortTime, time: t}
+			}
+		}
+		return sortKey{kind: sortText, text: v}
+	}
+	return sortKey{kind: sortOther}
+}
+
+// SORT ITEMS TASK
+type SortItemsTask struct{}
+
+func (t *SortItemsTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"items":      "array",    // REQUIRED
+		"by":         "string?",  // OPTIONAL (FIELD TO SORT OBJECTS BY; DEFAULTS TO THE ITEMS THEMSELVES)
+		"order":      "string?",  // OPTIONAL (asc OR desc; DEFAULTS TO asc)
+		"ignoreCase": "boolean?", // OPTIONAL (COMPARE TEXT CASE-INSENSITIVELY)
+	}
+}
+
+func (t *SortItemsTask) GetOutputSchema() string {
+	return "array" // RETURNS THE SORTED ITEMS
+}
+
+func (t *SortItemsTask) ValidateConfig(config map[string]any) error {
+	if order, ok := config["order"].(string); ok && order != "" && order != "asc" && order != "desc" {
+		return fmt.Errorf("SORT ORDER MUST BE asc OR desc")
+	}
+	return nil
+}
+
+func (t *SortItemsTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	items, err := itemsConfig(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	by, _ := config["by"].(string)
+	desc := config["order"] == "desc"
+	ignoreCase := boolConfig(config, "ignoreCase", false)
+
+	keys := make([]sortKey, len(items))
+	order := make([]int, len(items))
+	for i, item := range items {
+		value := itemField(item, by)
+		if s, ok := value.(string); ok && ignoreCase {
+			value = strings.ToLower(s)
+		}
+		keys[i], order[i] = sortKeyOf(value), i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		ka, kb := keys[a], keys[b]
+		// KINDS KEEP THEIR ORDER EITHER WAY (MISSING VALUES STAY LAST); ONLY VALUES OF ONE KIND FLIP
+		if ka.kind != kb.kind {
+			return cmp.Compare(ka.kind, kb.kind)
+		}
+		c := compareSortKeys(ka, kb)
+		if desc {
+			return -c
+		}
+		return c
+	})
+
+	sorted := make([]any, len(items))
+	for i, index := range order {
+		sorted[i] = items[index]
+	}
+	ctx.Logger.Printf("SORTED %d ITEMS", len(sorted))
+	return TaskData{Type: "array", Value: sorted}, nil
+}
+
+// ORDER TWO SORT KEYS, BY KIND FIRST
+func compareSortKeys(a, b sortKey) int {
+	if a.kind != b.kind {
+		return cmp.Compare(a.kind, b.kind)
+	}
+	switch a.kind {
+	case sortNumber, sortBool:
+		return cmp.Compare(a.number, b.number)
+	case sortTime:
+		return a.time.Compare(b.time)
+	case sortText:
+		return strings.Compare(a.text, b.text)
+	}
+	return 0
+}
+
+// UNIQUE ITEMS TASK: DROP REPEATS, KEEPING EACH ITEM'S FIRST OCCURRENCE
+type UniqueItemsTask struct{}
+
+func (t *UniqueItemsTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"items":      "array",    // REQUIRED
+		"by":         "string?",  // OPTIONAL (FIELD THAT MAKES OBJECTS THE SAME; DEFAULTS TO THE WHOLE ITEM)
+		"ignoreCase": "boolean?", // OPTIONAL (TREAT TEXT DIFFERING ONLY IN CASE AS THE SAME)
+	}
+}
+
+func (t *UniqueItemsTask) GetOutputSchema() string {
+	return "array" // RETURNS THE ITEMS WITHOUT REPEATS
+}
+
+func (t *UniqueItemsTask) ValidateConfig(config map[string]any) error {
+	return nil
+}
+
+func (t *UniqueItemsTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	items, err := itemsConfig(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	by, _ := config["by"].(string)
+	ignoreCase := boolConfig(config, "ignoreCase", false)
+
+	seen := make(map[string]bool, len(items))
+	unique := make([]any, 0, len(items))
+	for _, item := range items {
+		// JSON ENCODING SORTS OBJECT KEYS, SO EQUAL OBJECTS GET EQUAL KEYS
+		encoded, err := json.Marshal(itemField(item, by))
+		if err != nil {
+			return TaskData{}, fmt.Errorf("CAN'T COMPARE ITEM: %v", err)
+		}
+		key := string(encoded)
+		if ignoreCase {
+			key = strings.ToLower(key)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, item)
+	}
+	ctx.Logger.Printf("KEPT %d OF %d ITEMS (%d REPEATS)", len(unique), len(items), len(items)-len(unique))
+	return TaskData{Type: "array", Value: unique}, nil
+}
+
+// SLICE ITEMS TASK: A RANGE OF THE ITEMS BY POSITION
+type SliceItemsTask struct{}
+
+func (t *SliceItemsTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"items": "array",   // REQUIRED
+		"start": "number?", // OPTIONAL (FIRST POSITION, NEGATIVE COUNTS FROM THE END; DEFAULTS TO 0)
+		"end":   "number?", // OPTIONAL (POSITION TO STOP BEFORE, NEGATIVE COUNTS FROM THE END; DEFAULTS TO THE END)
+		"limit": "number?", // OPTIONAL (AT MOST THIS MANY ITEMS FROM start)
+	}
+}
+
+func (t *SliceItemsTask) GetOutputSchema() string {
+	return "array" // RETURNS THE ITEMS IN RANGE
+}
+
+func (t *SliceItemsTask) ValidateConfig(config map[string]any) error {
+	if limit, ok := intConfig(config, "limit"); ok && limit < 0 {
+		return fmt.Errorf("SLICE LIMIT CAN'T BE NEGATIVE")
+	}
+	return nil
+}
+
+func (t *SliceItemsTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	items, err := itemsConfig(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	// LIKE JAVASCRIPT'S Array.slice
+	position := func(key string, fallback int) int {
+		i, ok := intConfig(config, key)
+		if !ok {
+			return fallback
+		}
+		if i < 0 {
+			i += len(items)
+		}
+		return min(max(i, 0), len(items))
+	}
+	start, end := position("start", 0), position("end", len(items))
+	if limit, ok := intConfig(config, "limit"); ok {
+		end = min(end, start+limit)
+	}
+	end = max(end, start)
+
+	sliced := slices.Clone(items[start:end])
+	ctx.Logger.Printf("TOOK ITEMS %d TO %d OF %d", start, end, len(items))
+	return TaskData{Type: "array", Value: sliced}, nil
+}
+
+// SAMPLE ITEMS TASK: A RANDOM SUBSET OF THE ITEMS, IN THEIR ORIGINAL ORDER
+type SampleItemsTask struct{}
+
+func (t *SampleItemsTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"items": "array",   // REQUIRED
+		"size":  "number",  // REQUIRED (ITEMS TO PICK; ALL OF THEM WHEN THERE ARE FEWER)
+		"seed":  "number?", // OPTIONAL (PICK THE SAME ITEMS EVERY RUN)
+	}
+}
+
+func (t *SampleItemsTask) GetOutputSchema() string {
+	return "array" // RETURNS THE PICKED ITEMS
+}
+
+func (t *SampleItemsTask) ValidateConfig(config map[string]any) error {
+	if _, ok := config["size"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	if size, ok := intConfig(config, "size"); !ok || size < 0 {
+		return fmt.Errorf("SAMPLE SIZE MUST BE A NUMBER OF ITEMS")
+	}
+	return nil
+}
+
+func (t *SampleItemsTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	items, err := itemsConfig(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	size, _ := intConfig(config, "size")
+	size = min(max(size, 0), len(items))
+
+	random := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	if seed, ok := intConfig(config, "seed"); ok {
+		random = rand.New(rand.NewPCG(uint64(seed), 0))
+	}
+	// PICK size POSITIONS, THEN PUT THEM BACK IN ORDER
+	picked := random.Perm(len(items))[:size]
+	slices.Sort(picked)
+	sample := make([]any, size)
+	for i, index := range picked {
+		sample[i] = items[index]
+	}
+	ctx.Logger.Printf("SAMPLED %d OF %d ITEMS", size, len(items))
+	return TaskData{Type: "array", Value: sample}, nil
+}
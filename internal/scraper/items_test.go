@@ -0,0 +1,39 @@
+package scraper
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestSortItemsKeepsKindOrder(t *testing.T) {
+	items := []any{
+		map[string]any{"v": "banana"},
+		map[string]any{"v": 2.0},
+		map[string]any{},
+		map[string]any{"v": "apple"},
+		map[string]any{"v": 10.0},
+	}
+	tests := map[string][]any{
+		// NUMBERS BEFORE TEXT, MISSING LAST, WHICHEVER WAY THE VALUES RUN
+		"asc":  {2.0, 10.0, "apple", "banana", nil},
+		"desc": {10.0, 2.0, "banana", "apple", nil},
+	}
+	for order, want := range tests {
+		t.Run(order, func(t *testing.T) {
+			ctx := &TaskContext{Logger: log.New(io.Discard, "", 0)}
+			data, err := (&SortItemsTask{}).Execute(ctx, map[string]any{"items": items, "by": "v", "order": order})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []any
+			for _, item := range data.Value.([]any) {
+				got = append(got, item.(map[string]any)["v"])
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("sorted %v; want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/validation"
+	"gorm.io/gorm/clause"
+)
+
+// -- PERSISTENT JOB STATE --
+//
+// KEY-VALUE STATE A JOB KEEPS FROM ONE RUN TO THE NEXT (THE LAST ID SEEN, THE LAST PAGE NUMBER,
+// A CURSOR TOKEN), FOR INCREMENTAL SCRAPING. THE STATE TASKS USE IT WITH scope "job", AND THE API
+// CAN READ, SET AND CLEAR IT (CLEARING IT MAKES THE NEXT RUN START FROM SCRATCH).
+
+// LONGEST ACCEPTED STATE KEY
+const maxStateKeyLength = 200
+
+// FAIL FOR AN EMPTY OR OVERLONG KEY
+func ValidateStateKey(key string) error {
+	if key == "" || len(key) > maxStateKeyLength {
+		return fmt.Errorf("STATE KEY MUST BE 1-%d CHARACTERS", maxStateKeyLength)
+	}
+	return nil
+}
+
+// A JOB'S STATE, BY KEY
+func (e *Engine) JobState(jobID string) ([]models.JobState, error) {
+	entries := []models.JobState{}
+	err := e.db.Where("job_id = ?", jobID).Order("key").Find(&entries).Error
+	return entries, err
+}
+
+// ONE VALUE OF A JOB'S STATE (FALSE WHEN UNSET)
+func (e *Engine) GetJobState(jobID, key string) (any, bool, error) {
+	var entry models.JobState
+	result := e.db.Where("job_id = ? AND key = ?", jobID, key).Limit(1).Find(&entry)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, false, result.Error
+	}
+	var value any
+	if err := json.Unmarshal(entry.Value, &value); err != nil {
+		return nil, false, fmt.Errorf("STATE %q IS CORRUPT: %v", key, err)
+	}
+	return value, true, nil
+}
+
+// SET ONE VALUE OF A JOB'S STATE. runID IS EMPTY FOR CHANGES MADE OUTSIDE A RUN
+func (e *Engine) SetJobState(jobID, runID, key string, value any) (models.JobState, error) {
+	e.jobStateMu.Lock()
+	defer e.jobStateMu.Unlock()
+	return e.saveJobState(jobID, runID, key, value)
+}
+
+func (e *Engine) saveJobState(jobID, runID, key string, value any) (models.JobState, error) {
+	if err := ValidateStateKey(key); err != nil {
+		return models.JobState{}, err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return models.JobState{}, fmt.Errorf("STATE %q CAN'T BE STORED: %v", key, err)
+	}
+	entry := models.JobState{JobID: jobID, Key: key, Value: encoded, RunID: runID}
+	err = e.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "job_id"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "run_id", "updated_at"}),
+	}).Create(&entry).Error
+	return entry, err
+}
+
+// ADD by TO A NUMBER IN A JOB'S STATE (UNSET COUNTS AS 0), RETURNING THE NEW VALUE
+func (e *Engine) IncrementJobState(jobID, runID, key string, by float64) (float64, error) {
+	e.jobStateMu.Lock()
+	defer e.jobStateMu.Unlock()
+	current := 0.0
+	value, ok, err := e.GetJobState(jobID, key)
+	if err != nil {
+		return 0, err
+	}
+	if ok && value != nil {
+		n, isNumber := value.(float64)
+		if !isNumber {
+			return 0, fmt.Errorf("STATE %q IS %s, NOT A NUMBER", key, validation.ValueTypeName(value))
+		}
+		current = n
+	}
+	current += by
+	if _, err := e.saveJobState(jobID, runID, key, current); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+// CLEAR ONE KEY OF A JOB'S STATE, OR ALL OF IT FOR AN EMPTY KEY, RETURNING HOW MANY WERE CLEARED
+func (e *Engine) DeleteJobState(jobID, key string) (int64, error) {
+	e.jobStateMu.Lock()
+	defer e.jobStateMu.Unlock()
+	query := e.db.Where("job_id = ?", jobID)
+	if key != "" {
+		query = query.Where("key = ?", key)
+	}
+	result := query.Delete(&models.JobState{})
+	return result.RowsAffected, result.Error
+}
+
+// THE RUNNING JOB'S PERSISTENT STATE, AS THE STATE TASKS SEE IT
+type jobStateStore struct {
+	engine *Engine
+	jobID  string
+	runID  string
+}
+
+func (s *jobStateStore) get(key string) (any, bool, error) {
+	return s.engine.GetJobState(s.jobID, key)
+}
+
+func (s *jobStateStore) set(key string, value any) error {
+	_, err := s.engine.SetJobState(s.jobID, s.runID, key, value)
+	return err
+}
+
+func (s *jobStateStore) increment(key string, by float64) (float64, error) {
+	return s.engine.IncrementJobState(s.jobID, s.runID, key, by)
+}
@@ -0,0 +1,216 @@
+package scraper
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- RESOURCE LEAK GUARD --
+//
+// PIPELINES ARE EXPECTED TO DISPOSE THE BROWSERS AND PAGES THEY CREATE, BUT A FAILED STAGE OR A
+// MISSING disposeBrowser TASK LEAVES CHROMIUM PROCESSES BEHIND. WHATEVER A RUN STILL HOLDS WHEN
+// IT ENDS IS CLOSED, AND A SWEEP CLOSES BROWSERS AND PAGES NO TASK HAS USED FOR
+// resourceIdleTimeout. AN IDLE BROWSER OR PAGE FROM createBrowser/createPage IS REOPENED IF A
+// LATER TASK ASKS FOR IT AGAIN.
+
+// HOW LONG A BROWSER OR PAGE MAY GO UNUSED WHEN resourceIdleTimeout IS UNSET
+const defaultResourceIdleTimeout = 30 * time.Minute
+
+// HOW OFTEN IDLE BROWSERS AND PAGES ARE LOOKED FOR
+const leakSweepInterval = time.Minute
+
+// LEAKED BROWSERS AND PAGES CLOSED SINCE START, AND WHAT IS OPEN NOW
+type LeakStats struct {
+	OpenBrowsers    int   `json:"openBrowsers"`
+	OpenPages       int   `json:"openPages"`
+	LeakedBrowsers  int64 `json:"leakedBrowsers"`
+	LeakedPages     int64 `json:"leakedPages"`
+	ClosedAtRunEnd  int64 `json:"closedAtRunEnd"`  // STILL OPEN WHEN THEIR RUN ENDED
+	ClosedWhileIdle int64 `json:"closedWhileIdle"` // UNUSED FOR LONGER THAN THE IDLE TIMEOUT
+}
+
+type leakCounters struct {
+	browsers atomic.Int64
+	pages    atomic.Int64
+	runEnd   atomic.Int64
+	idle     atomic.Int64
+}
+
+// RECORD A USE OF A RESOURCE; USING A PAGE ALSO COUNTS AS USING ITS BROWSER (CALLER HOLDS rm.mu)
+func (rm *ResourceManager) touch(jobID, resourceID string) {
+	if _, ok := rm.touched[jobID]; !ok {
+		rm.touched[jobID] = make(map[string]time.Time)
+	}
+	now := time.Now()
+	rm.touched[jobID][resourceID] = now
+	if recipe, ok := rm.recipes[jobID][resourceID]; ok && recipe.browserID != "" {
+		if _, exists := rm.resources[jobID][recipe.browserID]; exists {
+			rm.touched[jobID][recipe.browserID] = now
+		}
+	}
+}
+
+// REMOVE EVERYTHING A JOB HOLDS AND HAND IT BACK TO BE CLOSED
+func (rm *ResourceManager) takeJobResources(jobID string) map[string]any {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	resources := rm.resources[jobID]
+	delete(rm.resources, jobID)
+	delete(rm.recipes, jobID)
+	delete(rm.touched, jobID)
+	return resources
+}
+
+// BROWSERS AND PAGES UNUSED SINCE cutoff, BY JOB. ONES THAT CAN BE REOPENED STAY REGISTERED (CLOSED)
+// SO A LATER LOOKUP REOPENS THEM; THE REST ARE REMOVED
+func (rm *ResourceManager) takeIdle(cutoff time.Time) map[string]map[string]any {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	idle := make(map[string]map[string]any)
+	for jobID, resources := range rm.resources {
+		for id, resource := range resources {
+			if !resourceOpen(resource) || !rm.touched[jobID][id].Before(cutoff) {
+				continue
+			}
+			if _, ok := idle[jobID]; !ok {
+				idle[jobID] = make(map[string]any)
+			}
+			idle[jobID][id] = resource
+			if _, recoverable := rm.recipes[jobID][id]; !recoverable {
+				delete(resources, id)
+				delete(rm.touched[jobID], id)
+			}
+		}
+	}
+	return idle
+}
+
+// OPEN BROWSERS AND PAGES ACROSS ALL JOBS
+func (rm *ResourceManager) openCounts() (browsers, pages int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for _, resources := range rm.resources {
+		for _, resource := range resources {
+			if !resourceOpen(resource) {
+				continue
+			}
+			switch resource.(type) {
+			case playwright.Browser:
+				browsers++
+			case playwright.Page:
+				pages++
+			}
+		}
+	}
+	return browsers, pages
+}
+
+// WHETHER A BROWSER OR PAGE STILL NEEDS CLOSING
+func resourceOpen(resource any) bool {
+	switch r := resource.(type) {
+	case playwright.Browser:
+		return r.IsConnected()
+	case playwright.Page:
+		return !r.IsClosed()
+	}
+	return false
+}
+
+// CLOSE LEAKED BROWSERS AND PAGES (PAGES FIRST) AND COUNT THEM; RETURNS HOW MANY WERE STILL OPEN
+func (e *Engine) closeLeaked(jobID string, resources map[string]any, reason string) int {
+	var browsers []string
+	closed := 0
+	for id, resource := range resources {
+		page, ok := resource.(playwright.Page)
+		if !ok {
+			if _, isBrowser := resource.(playwright.Browser); isBrowser {
+				browsers = append(browsers, id)
+			}
+			continue
+		}
+		if page.IsClosed() {
+			continue
+		}
+		log.Printf("[JOB %s] CLOSING LEAKED PAGE %s (%s)", jobID, id, reason)
+		e.captureSession(jobID, page.Context())
+		page.Close()
+		e.leaks.pages.Add(1)
+		closed++
+	}
+	for _, id := range browsers {
+		browser := resources[id].(playwright.Browser)
+		if !browser.IsConnected() {
+			continue
+		}
+		log.Printf("[JOB %s] CLOSING LEAKED BROWSER %s (%s)", jobID, id, reason)
+		e.captureSession(jobID, browser.Contexts()...)
+		browser.Close()
+		e.leaks.browsers.Add(1)
+		closed++
+	}
+	if closed > 0 {
+		e.events.Publish("resources.leaked", jobID, map[string]any{"closed": closed, "reason": reason})
+	}
+	return closed
+}
+
+// CLOSE WHATEVER A FINISHED RUN LEFT OPEN
+func (e *Engine) releaseJobResources(jobID string) int {
+	closed := e.closeLeaked(jobID, e.resourceManager.takeJobResources(jobID), "run ended")
+	e.leaks.runEnd.Add(int64(closed))
+	return closed
+}
+
+// IDLE TIMEOUT FROM CONFIG (NEGATIVE DISABLES THE SWEEP)
+func (e *Engine) resourceIdleTimeout() time.Duration {
+	switch {
+	case e.cfg.ResourceIdleTimeout < 0:
+		return 0
+	case e.cfg.ResourceIdleTimeout == 0:
+		return defaultResourceIdleTimeout
+	}
+	return time.Duration(e.cfg.ResourceIdleTimeout) * time.Millisecond
+}
+
+// CLOSE BROWSERS AND PAGES NO TASK HAS USED WITHIN THE IDLE TIMEOUT
+func (e *Engine) sweepIdleResources() {
+	timeout := e.resourceIdleTimeout()
+	if timeout <= 0 {
+		return
+	}
+	for jobID, resources := range e.resourceManager.takeIdle(time.Now().Add(-timeout)) {
+		closed := e.closeLeaked(jobID, resources, "idle for "+timeout.String())
+		e.leaks.idle.Add(int64(closed))
+	}
+}
+
+// SWEEP FOR IDLE BROWSERS AND PAGES UNTIL THE ENGINE CLOSES
+func (e *Engine) leakLoop() {
+	ticker := time.NewTicker(leakSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.queueStop:
+			return
+		case <-ticker.C:
+			e.sweepIdleResources()
+			e.recycleAgedBrowsers()
+		}
+	}
+}
+
+// LEAK COUNTS AND OPEN BROWSERS/PAGES
+func (e *Engine) LeakStats() LeakStats {
+	browsers, pages := e.resourceManager.openCounts()
+	return LeakStats{
+		OpenBrowsers:    browsers,
+		OpenPages:       pages,
+		LeakedBrowsers:  e.leaks.browsers.Load(),
+		LeakedPages:     e.leaks.pages.Load(),
+		ClosedAtRunEnd:  e.leaks.runEnd.Load(),
+		ClosedWhileIdle: e.leaks.idle.Load(),
+	}
+}
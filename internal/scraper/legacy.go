@@ -0,0 +1,393 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// -- LEGACY SELECTOR JOBS --
+//
+// BEFORE PIPELINES A JOB WAS A BASE URL, A LIST OF SELECTORS (EACH WITH A purpose) AND A FEW
+// RULES (maxPages, maxAssets, maxConcurrent, requestDelay...). SUCH A JOB HAS NO STAGES, SO IT IS
+// CONVERTED INTO THE PIPELINE IT DESCRIBES: LOAD THE BASE URL, WALK ITS PAGES WITH THE pagination
+// SELECTOR, FOLLOW WHAT THE links SELECTORS FIND, SAVE THE OTHER SELECTORS AS A RECORD OF EACH
+// PAGE AND DOWNLOAD WHAT THE assets SELECTORS POINT AT. A RUN CONVERTS ON THE FLY;
+// POST /jobs/{id}/migrate SAVES THE CONVERSION (OR PREVIEWS IT) SO IT CAN BE EDITED.
+
+// WHAT A LEGACY SELECTOR IS FOR, FROM ITS purpose
+const (
+	legacyLinks      = "links"
+	legacyPagination = "pagination"
+	legacyAssets     = "assets"
+	legacyField      = "field"
+)
+
+// LISTING PAGES WALKED WITH THE pagination SELECTOR WHEN THE JOB HAS NO maxPages
+const defaultLegacyPages = 10
+
+// HOW LONG TO LOOK FOR THE NEXT-PAGE LINK BEFORE DECIDING THE LAST PAGE WAS REACHED (MS)
+const legacyNextTimeout = 5000
+
+// A LEGACY JOB AS A PIPELINE
+type LegacyConversion struct {
+	Stages []models.Stage `json:"stages"`
+	Rules  models.JSONMap `json:"rules"` // THE JOB'S RULES PLUS WHAT THE OLD ONES MAP TO
+	Notes  []string       `json:"notes"` // WHAT DIDN'T CARRY OVER
+}
+
+// WHETHER A JOB ONLY HAS THE OLD SELECTORS SHAPE: SELECTORS BUT NO STAGES
+func IsLegacyJob(job *models.Job) bool {
+	if len(job.Selectors) == 0 {
+		return false
+	}
+	var stages []models.Stage
+	if strings.TrimSpace(job.Pipeline) != "" && json.Unmarshal([]byte(job.Pipeline), &stages) != nil {
+		return false
+	}
+	return len(stages) == 0
+}
+
+// THE PIPELINE AND RULES A LEGACY JOB DESCRIBES
+func ConvertLegacyJob(job *models.Job) (LegacyConversion, error) {
+	target, err := url.Parse(strings.TrimSpace(job.BaseURL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return LegacyConversion{}, fmt.Errorf("%w: baseUrl MUST BE AN http(s) URL", ErrInvalidInput)
+	}
+	selectors, err := legacySelectors(job.Selectors)
+	if err != nil {
+		return LegacyConversion{}, err
+	}
+	if len(selectors) == 0 {
+		return LegacyConversion{}, fmt.Errorf("%w: JOB HAS NO SELECTORS TO CONVERT", ErrInvalidInput)
+	}
+
+	var conversion LegacyConversion
+	note := func(format string, args ...any) {
+		conversion.Notes = append(conversion.Notes, fmt.Sprintf(format, args...))
+	}
+
+	var links, assets []models.Selector
+	var next *models.Selector
+	fields := map[string]any{}
+	for i, selector := range selectors {
+		switch legacyRole(selector.Purpose) {
+		case legacyLinks:
+			links = append(links, selector)
+		case legacyAssets:
+			assets = append(assets, selector)
+		case legacyPagination:
+			if next != nil {
+				note("Only the first pagination selector is used; %q was left out", selectorLabel(selector))
+				continue
+			}
+			next = &selectors[i]
+		default:
+			name := legacyFieldName(selector, fields)
+			spec := map[string]any{"selector": selector.Value}
+			if selector.Attribute != "" {
+				spec["attribute"] = selector.Attribute
+			}
+			if isXPathSelector(selector) {
+				spec["selectorType"] = SelectorXPath
+			}
+			fields[name] = spec
+		}
+		if selector.URLPattern != "" {
+			note("Selector %q only applied to URLs matching %q; it now applies to every page it is run on", selectorLabel(selector), selector.URLPattern)
+		}
+	}
+	for _, selector := range assets {
+		if attribute := strings.ToLower(selector.Attribute); attribute != "" && attribute != "src" && attribute != "href" {
+			note("Asset selector %q reads %s, which isn't resolved against the page URL; relative values won't download", selectorLabel(selector), selector.Attribute)
+		}
+	}
+
+	rules := job.Rules
+	maxPages := legacyInt(rules, "maxPages")
+	maxAssets := legacyInt(rules, "maxAssets")
+	workers := max(legacyInt(rules, "maxConcurrent"), 1)
+	headless := true
+	if value, ok := job.Processing["headless"].(bool); ok {
+		headless = value
+	}
+
+	// SETUP: A BROWSER ON THE BASE URL
+	browser := presetTask("Open Browser", "createBrowser", map[string]any{"headless": headless})
+	page := presetTask("Open Page", "createPage", map[string]any{"browserId": browser.ID})
+	start := presetTask("Load Start Page", "navigate", map[string]any{"pageId": page.ID, "url": target.String(), "waitUntil": "domcontentloaded"})
+	setup := []models.Task{browser, page, start}
+
+	// WHAT IS PULLED FROM EACH LISTING PAGE: THE LINKS TO FOLLOW, OR WITHOUT THEM THE PAGE'S OWN
+	// ASSETS AND FIELDS
+	var listing, linkTasks, assetTasks []models.Task
+	for _, selector := range links {
+		task := legacyURLTask("Extract Links", page.ID, selector, "href")
+		linkTasks = append(linkTasks, task)
+		listing = append(listing, task)
+	}
+	if len(links) == 0 {
+		for _, selector := range assets {
+			task := legacyURLTask("Extract Assets", page.ID, selector, "src")
+			assetTasks = append(assetTasks, task)
+			listing = append(listing, task)
+		}
+		if len(fields) > 0 {
+			listing = append(listing, legacyFieldsTask(page.ID, fields))
+		}
+	}
+
+	stages := []models.Stage{presetStage("Setup", "Open a browser on "+target.Host, "sequential", 1, setup)}
+	if next != nil {
+		pages := maxPages
+		if pages <= 0 {
+			pages = defaultLegacyPages
+			note("maxPages isn't set, so the pagination selector is followed for at most %d pages", pages)
+		}
+		numbers := make([]any, pages)
+		for i := range numbers {
+			numbers[i] = map[string]any{"page": i + 1}
+		}
+		counter := presetTask("Page Numbers", "templateText", map[string]any{"template": "{{ page }}", "data": numbers, "strict": true})
+		stages[0].Tasks = append(stages[0].Tasks, counter)
+
+		// THE LAST PAGE'S NEXT LINK IS MISSING, SO ITS CLICK FAILS AND ENDS THE WALK'S LATER ITEMS
+		// ON THE SAME PAGE; REPEATS ARE DROPPED WHEN THE RESULTS ARE COLLECTED
+		click := presetTask("Next Page", "click", map[string]any{
+			"pageId":   page.ID,
+			"selector": locatorSelector(next.Value, legacySelectorType(*next)),
+			"timeout":  legacyNextTimeout,
+		})
+		settle := presetTask("Wait For Next Page", "waitForLoad", map[string]any{"pageId": page.ID, "state": "domcontentloaded"})
+		walk := presetStage("Walk Pages", "Pull from each page, then follow the pagination selector", "for-each", 1, append(listing, click, settle))
+		walk.Config = map[string]any{"items": counter.ID}
+		stages = append(stages, walk)
+	} else if len(listing) > 0 {
+		stages = append(stages, presetStage("Scan Start Page", "Pull from the start page", "sequential", 1, listing))
+	}
+
+	// FOLLOW THE LINKS, PULLING FIELDS AND ASSETS FROM EACH
+	if len(linkTasks) > 0 {
+		collect, source := legacyCollect("Link", linkTasks, maxPages)
+		stages = append(stages, presetStage("Collect Links", "Gather the links found, without repeats", "sequential", 1, collect))
+
+		detail := presetTask("Open Link Page", "createPage", map[string]any{"browserId": browser.ID})
+		visit := presetTask("Load Link", "navigate", map[string]any{"pageId": detail.ID, "waitUntil": "domcontentloaded"})
+		visit.InputRefs = []string{source}
+		visit.RetryConfig = models.RetryConfig{MaxRetries: 2, DelayMS: 1000}
+		perLink := []models.Task{detail, visit}
+		if len(fields) > 0 {
+			perLink = append(perLink, legacyFieldsTask(detail.ID, fields))
+		}
+		for _, selector := range assets {
+			task := legacyURLTask("Extract Assets", detail.ID, selector, "src")
+			assetTasks = append(assetTasks, task)
+			perLink = append(perLink, task)
+		}
+		perLink = append(perLink, presetTask("Close Link Page", "disposePage", map[string]any{"pageId": detail.ID}))
+		visits := presetStage("Visit Links", "Load each link and pull from it", "for-each", workers, perLink)
+		visits.Config = map[string]any{"items": source}
+		stages = append(stages, visits)
+	}
+
+	// DOWNLOAD AND SAVE EVERY ASSET FOUND
+	if len(assetTasks) > 0 {
+		collect, source := legacyCollect("Asset", assetTasks, maxAssets)
+		stages = append(stages, presetStage("Collect Assets", "Gather the asset URLs found, without repeats", "sequential", 1, collect))
+
+		download := presetTask("Download Asset", "downloadAsset", map[string]any{})
+		download.InputRefs = []string{source}
+		download.RetryConfig = models.RetryConfig{MaxRetries: 2, DelayMS: 1000}
+		thumbnails := true
+		if value, ok := job.Processing["thumbnails"].(bool); ok {
+			thumbnails = value
+		}
+		save := presetTask("Save Asset", "saveAsset", map[string]any{"jobId": job.ID, "generateThumbnail": thumbnails})
+		save.InputRefs = []string{source, download.ID}
+		downloads := presetStage("Download Assets", "Download each asset and add it to the job", "for-each", workers, []models.Task{download, save})
+		downloads.Config = map[string]any{"items": source}
+		stages = append(stages, downloads)
+	}
+
+	stages = append(stages, presetStage("Cleanup", "Close the browser", "sequential", 1, []models.Task{
+		presetTask("Close Browser", "disposeBrowser", map[string]any{"browserId": browser.ID}),
+	}))
+
+	// ROUND-TRIP THROUGH JSON SO THE STAGES MATCH WHAT A SAVED PIPELINE LOADS AS
+	raw, err := json.Marshal(stages)
+	if err != nil {
+		return LegacyConversion{}, err
+	}
+	if err := json.Unmarshal(raw, &conversion.Stages); err != nil {
+		return LegacyConversion{}, err
+	}
+
+	conversion.Rules = models.JSONMap{}
+	maps.Copy(conversion.Rules, rules)
+	if delay := legacyInt(rules, "requestDelay"); delay > 0 {
+		if _, set := rules[throttleRule]; set {
+			note("requestDelay was left out because the job already has a throttle rule")
+		} else {
+			throttle := map[string]any{"minDelay": float64(delay)}
+			if randomize, _ := rules["randomizeDelay"].(bool); randomize {
+				variation := 1.0
+				if v, ok := rules["delayVariation"].(float64); ok && v > 0 {
+					variation = v
+				}
+				throttle["maxDelay"] = float64(delay) + float64(delay)*variation
+			} else {
+				throttle["maxDelay"] = float64(delay)
+			}
+			conversion.Rules[throttleRule] = throttle
+		}
+	}
+	if depth := legacyInt(rules, "maxDepth"); depth > 1 && len(links) > 0 {
+		note("maxDepth is %d but links are only followed one level from the listing pages", depth)
+	}
+	if len(job.Filters) > 0 {
+		note("%d filter(s) have no pipeline equivalent and were left out", len(job.Filters))
+	}
+	if len(links) == 0 && len(assets) == 0 && len(fields) == 0 {
+		note("No selector pulls anything, so the pipeline only walks the pages")
+	}
+	if conversion.Notes == nil {
+		conversion.Notes = []string{}
+	}
+	return conversion, nil
+}
+
+// THE JOB'S SELECTORS, SKIPPING EMPTY ONES AND CHECKING THE REST
+func legacySelectors(raw models.JSONArray) ([]models.Selector, error) {
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var all []models.Selector
+	if err := json.Unmarshal(encoded, &all); err != nil {
+		return nil, fmt.Errorf("%w: SELECTORS AREN'T IN THE LEGACY SHAPE: %v", ErrInvalidInput, err)
+	}
+	selectors := make([]models.Selector, 0, len(all))
+	for _, selector := range all {
+		selector.Value = strings.TrimSpace(selector.Value)
+		if selector.Value == "" {
+			continue
+		}
+		if err := validateSelector(selector.Value, legacySelectorType(selector)); err != nil {
+			return nil, fmt.Errorf("SELECTOR %q: %w", selectorLabel(selector), err)
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+// WHAT A SELECTOR IS FOR; ANY purpose THAT ISN'T LINKS, PAGINATION OR ASSETS NAMES A FIELD
+func legacyRole(purpose string) string {
+	switch strings.ToLower(strings.TrimSpace(purpose)) {
+	case "link", "links", "follow", "detail", "details":
+		return legacyLinks
+	case "pagination", "paging", "next", "nextpage", "next-page", "next_page":
+		return legacyPagination
+	case "asset", "assets", "media", "image", "images", "video", "videos", "file", "files", "download", "downloads":
+		return legacyAssets
+	}
+	return legacyField
+}
+
+func legacySelectorType(selector models.Selector) string {
+	if isXPathSelector(selector) {
+		return SelectorXPath
+	}
+	return ""
+}
+
+func isXPathSelector(selector models.Selector) bool {
+	return strings.EqualFold(selector.Type, SelectorXPath)
+}
+
+func selectorLabel(selector models.Selector) string {
+	if selector.Name != "" {
+		return selector.Name
+	}
+	return selector.Value
+}
+
+// A RECORD FIELD NAME FOR A SELECTOR (ITS NAME, ELSE ITS purpose), NUMBERED WHEN TAKEN
+func legacyFieldName(selector models.Selector, taken map[string]any) string {
+	name := strings.TrimSpace(selector.Name)
+	if name == "" {
+		name = strings.TrimSpace(selector.Purpose)
+	}
+	if name == "" {
+		name = "field"
+	}
+	candidate := name
+	for i := 2; taken[candidate] != nil; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	return candidate
+}
+
+// AN extractRecords PULLING ONE {url} PER MATCH; href AND src RESOLVE TO ABSOLUTE URLS
+func legacyURLTask(name, pageID string, selector models.Selector, attribute string) models.Task {
+	if selector.Attribute != "" {
+		attribute = selector.Attribute
+	}
+	config := map[string]any{
+		"pageId":   pageID,
+		"selector": selector.Value,
+		"fields":   map[string]any{"url": map[string]any{"attribute": attribute}},
+	}
+	if isXPathSelector(selector) {
+		config["selectorType"] = SelectorXPath
+	}
+	task := presetTask(name, "extractRecords", config)
+	task.Description = "Selector " + selectorLabel(selector)
+	return task
+}
+
+// ONE SAVED RECORD OF A PAGE'S FIELDS
+func legacyFieldsTask(pageID string, fields map[string]any) models.Task {
+	return presetTask("Extract Fields", "extractRecords", map[string]any{
+		"pageId":   pageID,
+		"selector": "html",
+		"fields":   fields,
+		"save":     true,
+	})
+}
+
+// FLATTEN THE {url} RESULTS OF tasks (PER-ITEM RESULTS COME AS ARRAYS OF ARRAYS), DROP REPEATS AND
+// TURN THEM INTO URL STRINGS, AT MOST limit OF THEM. RETURNS THE TASKS AND THE ONE HOLDING THE URLS
+func legacyCollect(kind string, tasks []models.Task, limit int) ([]models.Task, string) {
+	refs := make([]string, len(tasks))
+	for i, task := range tasks {
+		refs[i] = task.ID
+	}
+	flat := presetTask("All "+kind+"s", "flatten", map[string]any{"depth": 0})
+	flat.InputRefs = refs
+	unique := presetTask("Unique "+kind+"s", "uniqueItems", map[string]any{"by": "url"})
+	unique.InputRefs = []string{flat.ID}
+	urls := presetTask(kind+" URLs", "templateText", map[string]any{"template": "{{ url }}"})
+	urls.InputRefs = []string{unique.ID}
+	collect := []models.Task{flat, unique, urls}
+	if limit > 0 {
+		capped := presetTask(fmt.Sprintf("First %d %ss", limit, kind), "sliceItems", map[string]any{"limit": limit})
+		capped.InputRefs = []string{urls.ID}
+		collect = append(collect, capped)
+	}
+	return collect, collect[len(collect)-1].ID
+}
+
+// A WHOLE-NUMBER RULE (JSON NUMBERS DECODE AS float64), 0 WHEN UNSET
+func legacyInt(rules models.JSONMap, key string) int {
+	switch v := rules[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
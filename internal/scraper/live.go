@@ -0,0 +1,264 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// -- LIVE STREAM CAPTURE --
+
+// OPTIONS FOR A LIVE CAPTURE
+type LiveCaptureOptions struct {
+	Duration    time.Duration // STOP AFTER THIS MUCH MEDIA; ZERO MEANS UNTIL THE STREAM ENDS
+	WaitForLive time.Duration // KEEP RETRYING THIS LONG IF THE STREAM ISN'T UP YET
+	Variant     string        // best, worst, OR A TARGET HEIGHT
+	PartsDir    string        // WHERE ROLLING SEGMENTS ARE WRITTEN BEFORE FINALIZING
+	OutputPath  string        // FINAL CONCATENATED FILE
+	KeepParts   bool          // LEAVE ROLLING SEGMENTS ON DISK AFTER FINALIZING
+	OnSegment   func(bytes int)
+	Logf        func(format string, args ...any)
+}
+
+// RESULT OF A LIVE CAPTURE
+type LiveCaptureResult struct {
+	OutputPath  string  `json:"outputPath"`
+	Size        int64   `json:"size"`
+	Segments    int     `json:"segments"`
+	Duration    float64 `json:"duration"` // SECONDS OF MEDIA CAPTURED
+	Ended       bool    `json:"ended"`    // STREAM SENT EXT-X-ENDLIST
+	Reason      string  `json:"reason"`   // duration, ended, cancelled
+	VariantURL  string  `json:"variantUrl"`
+	ContentType string  `json:"contentType"` // video/mp2t, OR video/mp4 FOR FRAGMENTED MP4 STREAMS
+}
+
+// CAPTURE A LIVE HLS STREAM INTO ROLLING SEGMENT FILES, THEN FINALIZE INTO ONE FILE
+func CaptureLiveHLS(ctx context.Context, client *http.Client, header http.Header, rawURL string, opts LiveCaptureOptions) (LiveCaptureResult, error) {
+	logf := opts.Logf
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	result := LiveCaptureResult{OutputPath: opts.OutputPath, ContentType: "video/mp2t"}
+
+	playlistURL, err := waitForLivePlaylist(ctx, client, header, rawURL, opts, logf)
+	if err != nil {
+		return result, err
+	}
+	result.VariantURL = playlistURL
+
+	if err := os.MkdirAll(opts.PartsDir, 0755); err != nil {
+		return result, fmt.Errorf("FAILED TO CREATE PARTS DIRECTORY: %v", err)
+	}
+
+	var (
+		parts    []string
+		lastSeq  int64 = -1
+		haveInit bool
+		keys     = make(map[string][]byte)
+	)
+
+	// CAPTURE LOOP
+	for {
+		body, finalURL, _, err := fetchManifestBody(ctx, client, header, playlistURL)
+		if err != nil {
+			if ctx.Err() != nil {
+				result.Reason = "cancelled"
+				break
+			}
+			return result, fmt.Errorf("FAILED TO REFRESH LIVE PLAYLIST: %w", err)
+		}
+		playlist, err := ParseHLS(body, finalURL)
+		if err != nil {
+			return result, err
+		}
+		if playlist.Master || len(playlist.Variants) == 0 {
+			return result, errors.New("LIVE PLAYLIST IS NOT A MEDIA PLAYLIST")
+		}
+		media := playlist.Variants[0]
+		if media.Encryption != nil && media.Encryption.Method != "AES-128" {
+			return result, fmt.Errorf("%w (%s)", ErrEncryptedStream, media.Encryption.Method)
+		}
+
+		// INIT SEGMENT ONCE, AS THE FIRST PART
+		if media.Init != nil && !haveInit {
+			path, n, err := writeLivePart(ctx, client, header, *media.Init, keys, opts.PartsDir, "init")
+			if err != nil {
+				return result, err
+			}
+			parts = append(parts, path)
+			result.Size += n
+			result.ContentType = "video/mp4"
+			haveInit = true
+		}
+
+		// NEW SEGMENTS SINCE THE LAST REFRESH
+		newSegments := 0
+		for _, segment := range media.Segments {
+			if segment.Sequence <= lastSeq {
+				continue
+			}
+			path, n, err := writeLivePart(ctx, client, header, segment, keys, opts.PartsDir, fmt.Sprintf("seg_%08d", segment.Sequence))
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				// A MISSED SEGMENT IS A GAP, NOT A FAILED CAPTURE
+				logf("SKIPPING LIVE SEGMENT %d: %v", segment.Sequence, err)
+				lastSeq = segment.Sequence
+				continue
+			}
+			parts = append(parts, path)
+			lastSeq = segment.Sequence
+			newSegments++
+			result.Segments++
+			result.Size += n
+			result.Duration += segment.Duration
+			if opts.OnSegment != nil {
+				opts.OnSegment(int(n))
+			}
+			if opts.Duration > 0 && result.Duration >= opts.Duration.Seconds() {
+				break
+			}
+		}
+
+		if newSegments > 0 {
+			logf("CAPTURED %d SEGMENTS (%.1fs TOTAL)", result.Segments, result.Duration)
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			result.Reason = "cancelled"
+		case opts.Duration > 0 && result.Duration >= opts.Duration.Seconds():
+			result.Reason = "duration"
+		case !playlist.Live:
+			result.Reason = "ended"
+			result.Ended = true
+		}
+		if result.Reason != "" {
+			break
+		}
+
+		// POLL AT HALF THE TARGET DURATION, AS THE HLS SPEC SUGGESTS WHEN NOTHING CHANGED
+		interval := time.Duration(playlist.TargetDuration * float64(time.Second))
+		if interval <= 0 {
+			interval = 6 * time.Second
+		}
+		if newSegments == 0 {
+			interval /= 2
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+
+	if result.Segments == 0 {
+		return result, errors.New("NO LIVE SEGMENTS WERE CAPTURED")
+	}
+
+	// FINALIZE ROLLING PARTS INTO THE OUTPUT FILE
+	size, err := concatenateParts(parts, opts.OutputPath)
+	if err != nil {
+		return result, err
+	}
+	result.Size = size
+
+	if !opts.KeepParts {
+		os.RemoveAll(opts.PartsDir)
+	}
+	logf("LIVE CAPTURE FINALIZED: %s (%d BYTES, %d SEGMENTS, REASON: %s)", opts.OutputPath, size, result.Segments, result.Reason)
+	return result, nil
+}
+
+// RESOLVE THE MEDIA PLAYLIST TO RECORD, RETRYING UNTIL THE STREAM IS UP
+func waitForLivePlaylist(ctx context.Context, client *http.Client, header http.Header, rawURL string, opts LiveCaptureOptions, logf func(string, ...any)) (string, error) {
+	deadline := time.Now().Add(opts.WaitForLive)
+	for {
+		manifest, err := FetchManifest(ctx, client, header, rawURL)
+		if err == nil {
+			if manifest.Type != "hls" {
+				return "", errors.New("LIVE CAPTURE ONLY SUPPORTS HLS")
+			}
+			if !manifest.Master {
+				return manifest.URL, nil
+			}
+			variant, err := SelectVariant(manifest, opts.Variant)
+			if err == nil {
+				return variant.URL, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = errors.New("NO PLAYABLE VARIANTS")
+			}
+			return "", fmt.Errorf("LIVE STREAM NOT AVAILABLE: %w", err)
+		}
+
+		logf("WAITING FOR LIVE STREAM: %v", err)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(15 * time.Second):
+		}
+	}
+}
+
+// DOWNLOAD ONE SEGMENT TO ITS OWN PART FILE
+func writeLivePart(ctx context.Context, client *http.Client, header http.Header, segment MediaSegment, keys map[string][]byte, dir, name string) (string, int64, error) {
+	data, err := fetchSegment(ctx, client, header, segment)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if segment.Key != nil && segment.Key.Method == "AES-128" {
+		key, ok := keys[segment.Key.KeyURI]
+		if !ok {
+			key, err = fetchSegment(ctx, client, header, MediaSegment{URL: segment.Key.KeyURI})
+			if err != nil {
+				return "", 0, fmt.Errorf("FAILED TO FETCH KEY: %w", err)
+			}
+			keys[segment.Key.KeyURI] = key
+		}
+		if data, err = decryptAES128(data, key, segment.Key.IV, segment.Sequence); err != nil {
+			return "", 0, err
+		}
+	}
+
+	path := filepath.Join(dir, name+".part")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", 0, fmt.Errorf("FAILED TO WRITE SEGMENT: %v", err)
+	}
+	return path, int64(len(data)), nil
+}
+
+// JOIN PART FILES IN ORDER INTO ONE OUTPUT FILE
+func concatenateParts(parts []string, outputPath string) (int64, error) {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return 0, fmt.Errorf("FAILED TO CREATE OUTPUT FILE: %v", err)
+	}
+	defer out.Close()
+
+	var total int64
+	for _, part := range parts {
+		in, err := os.Open(part)
+		if err != nil {
+			return total, fmt.Errorf("FAILED TO OPEN PART %s: %v", part, err)
+		}
+		n, err := io.Copy(out, in)
+		in.Close()
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("FAILED TO FINALIZE CAPTURE: %v", err)
+		}
+	}
+	return total, nil
+}
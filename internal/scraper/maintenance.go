@@ -0,0 +1,382 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/database"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// -- MAINTENANCE --
+//
+// UPKEEP THE DATABASE AND STORAGE NEED OVER TIME: VACUUM GIVES BACK THE SPACE OF DELETED ROWS,
+// ANALYZE KEEPS THE QUERY PLANNER'S STATISTICS CURRENT, A CHECKPOINT FOLDS THE WRITE-AHEAD LOG
+// INTO THE DATABASE, AND THE REST DELETE THUMBNAILS NO ASSET USES, EXPIRED APPLICATION LOGS
+// AND WHAT OLD RUNS LEFT BEHIND. A PASS RUNS ON THE CONFIGURED SCHEDULE OR ON DEMAND,
+// ONE AT A TIME; EACH TASK REPORTS WHAT IT DID AND A FAILED TASK DOESN'T STOP THE OTHERS.
+
+// MAINTENANCE TASKS
+const (
+	MaintainVacuum     = "vacuum"
+	MaintainAnalyze    = "analyze"
+	MaintainCheckpoint = "checkpoint"
+	MaintainThumbnails = "thumbnails"
+	MaintainLogs       = "logs"
+	MaintainRuns       = "runs"
+)
+
+// EVERY TASK, IN THE ORDER A PASS RUNS THEM (PRUNING FIRST SO VACUUM GIVES THE SPACE BACK)
+var MaintenanceTasks = []string{MaintainRuns, MaintainThumbnails, MaintainLogs, MaintainVacuum, MaintainAnalyze, MaintainCheckpoint}
+
+// SCHEDULE USED WHEN THE CONFIG SETS NONE, AND THE VALUE THAT TURNS SCHEDULED PASSES OFF
+const (
+	defaultMaintenanceSchedule = "0 4 * * *"
+	maintenanceOff             = "off"
+)
+
+// THUMBNAILS NEWER THAN THIS ARE LEFT ALONE; THEIR ASSET MAY NOT BE SAVED YET
+const orphanThumbnailAge = time.Hour
+
+// TASK OUTCOMES
+const (
+	MaintenanceDone    = "done"
+	MaintenanceSkipped = "skipped"
+	MaintenanceFailed  = "failed"
+)
+
+// A PASS IS ALREADY RUNNING
+var ErrMaintenanceRunning = errors.New("MAINTENANCE IS ALREADY RUNNING")
+
+// WHAT ONE TASK DID
+type MaintenanceResult struct {
+	Task       string `json:"task"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	Removed    int64  `json:"removed,omitempty"`    // ROWS OR FILES DELETED
+	FreedBytes int64  `json:"freedBytes,omitempty"` // DISK SPACE GIVEN BACK
+	DurationMs int64  `json:"durationMs"`
+}
+
+// WHAT ONE PASS DID
+type MaintenanceReport struct {
+	Trigger    string              `json:"trigger"` // schedule OR api
+	StartedAt  time.Time           `json:"startedAt"`
+	FinishedAt time.Time           `json:"finishedAt"`
+	Results    []MaintenanceResult `json:"results"`
+}
+
+// ONE PASS AT A TIME, AND THE LAST ONE'S REPORT
+type maintenanceState struct {
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *MaintenanceReport
+}
+
+// THE TASKS A SCHEDULED PASS RUNS
+func (e *Engine) scheduledMaintenanceTasks() []string {
+	if len(e.cfg.Maintenance.Tasks) == 0 {
+		return MaintenanceTasks
+	}
+	return e.cfg.Maintenance.Tasks
+}
+
+// THE CRON SPEC OF SCHEDULED PASSES ("" WHEN THEY ARE OFF)
+func (e *Engine) maintenanceSchedule() string {
+	switch spec := strings.TrimSpace(e.cfg.Maintenance.Schedule); spec {
+	case "":
+		return defaultMaintenanceSchedule
+	case maintenanceOff:
+		return ""
+	default:
+		return spec
+	}
+}
+
+// THE LAST PASS'S REPORT, NIL BEFORE THE FIRST
+func (e *Engine) LastMaintenance() *MaintenanceReport {
+	e.maintenance.mu.Lock()
+	defer e.maintenance.mu.Unlock()
+	return e.maintenance.last
+}
+
+// RUN THE NAMED TASKS (ALL WHEN EMPTY) IN THE STANDARD ORDER AND REPORT WHAT EACH DID
+func (e *Engine) RunMaintenance(tasks []string, trigger string) (*MaintenanceReport, error) {
+	for _, task := range tasks {
+		if !slices.Contains(MaintenanceTasks, task) {
+			return nil, fmt.Errorf("UNKNOWN MAINTENANCE TASK %s", task)
+		}
+	}
+	if !e.maintenance.running.TryLock() {
+		return nil, ErrMaintenanceRunning
+	}
+	defer e.maintenance.running.Unlock()
+
+	report := &MaintenanceReport{Trigger: trigger, StartedAt: time.Now(), Results: []MaintenanceResult{}}
+	for _, task := range MaintenanceTasks {
+		if len(tasks) > 0 && !slices.Contains(tasks, task) {
+			continue
+		}
+		started := time.Now()
+		result := e.runMaintenanceTask(task)
+		result.Task = task
+		result.DurationMs = time.Since(started).Milliseconds()
+		if result.Status == MaintenanceFailed {
+			log.Printf("MAINTENANCE: %s FAILED: %s", task, result.Message)
+		} else {
+			log.Printf("MAINTENANCE: %s %s IN %dMS %s", task, strings.ToUpper(result.Status), result.DurationMs, result.Message)
+		}
+		report.Results = append(report.Results, result)
+	}
+	report.FinishedAt = time.Now()
+
+	e.maintenance.mu.Lock()
+	e.maintenance.last = report
+	e.maintenance.mu.Unlock()
+	e.events.Publish("maintenance.finished", "", map[string]any{"report": report})
+	return report, nil
+}
+
+func (e *Engine) runMaintenanceTask(task string) MaintenanceResult {
+	var result MaintenanceResult
+	var err error
+	switch task {
+	case MaintainVacuum:
+		result, err = e.vacuumDatabase()
+	case MaintainAnalyze:
+		err = e.db.Exec("ANALYZE").Error
+		result.Status = MaintenanceDone
+	case MaintainCheckpoint:
+		result, err = e.checkpointDatabase()
+	case MaintainThumbnails:
+		result, err = e.removeOrphanThumbnails()
+	case MaintainLogs:
+		result, err = e.pruneAppLogs()
+	case MaintainRuns:
+		result, err = e.pruneOldRuns()
+	}
+	if err != nil {
+		return MaintenanceResult{Status: MaintenanceFailed, Message: err.Error()}
+	}
+	return result
+}
+
+// REBUILD THE DATABASE FILE WITHOUT ITS FREE PAGES. IT LOCKS THE WHOLE DATABASE WHILE IT RUNS,
+// SO IT WAITS FOR A TIME WHEN NO JOB IS RUNNING
+func (e *Engine) vacuumDatabase() (MaintenanceResult, error) {
+	e.mu.Lock()
+	running := len(e.runningJobs)
+	e.mu.Unlock()
+	if running > 0 {
+		return MaintenanceResult{Status: MaintenanceSkipped, Message: fmt.Sprintf("%d JOBS ARE RUNNING", running)}, nil
+	}
+	path := database.Path(e.cfg.DataPath)
+	before := fileSize(path)
+	if err := e.db.Exec("VACUUM").Error; err != nil {
+		return MaintenanceResult{}, err
+	}
+	freed := before - fileSize(path)
+	return MaintenanceResult{Status: MaintenanceDone, FreedBytes: max(freed, 0)}, nil
+}
+
+// MOVE THE WRITE-AHEAD LOG INTO THE DATABASE AND TRUNCATE IT
+func (e *Engine) checkpointDatabase() (MaintenanceResult, error) {
+	var mode string
+	if err := e.db.Raw("PRAGMA journal_mode").Scan(&mode).Error; err != nil {
+		return MaintenanceResult{}, err
+	}
+	if !strings.EqualFold(mode, "wal") {
+		return MaintenanceResult{Status: MaintenanceSkipped, Message: fmt.Sprintf("JOURNAL MODE IS %s, NOT WAL", mode)}, nil
+	}
+	walPath := database.Path(e.cfg.DataPath) + "-wal"
+	before := fileSize(walPath)
+	var row struct {
+		Busy         int
+		Log          int
+		Checkpointed int
+	}
+	if err := e.db.Raw("PRAGMA wal_checkpoint(TRUNCATE)").Row().Scan(&row.Busy, &row.Log, &row.Checkpointed); err != nil {
+		return MaintenanceResult{}, err
+	}
+	if row.Busy != 0 {
+		return MaintenanceResult{Status: MaintenanceSkipped, Message: "DATABASE WAS BUSY, CHECKPOINT INCOMPLETE"}, nil
+	}
+	return MaintenanceResult{
+		Status:     MaintenanceDone,
+		Message:    fmt.Sprintf("%d PAGES CHECKPOINTED", row.Checkpointed),
+		FreedBytes: max(before-fileSize(walPath), 0),
+	}, nil
+}
+
+// DELETE FILES UNDER THE THUMBNAIL DIRECTORY THAT NO ASSET POINTS TO
+func (e *Engine) removeOrphanThumbnails() (MaintenanceResult, error) {
+	root, err := filepath.Abs(e.cfg.ThumbnailsPath)
+	if err != nil {
+		return MaintenanceResult{}, err
+	}
+	var names []string
+	if err := e.db.Model(&models.Asset{}).Where("thumbnail_path != ''").Pluck("thumbnail_path", &names).Error; err != nil {
+		return MaintenanceResult{}, err
+	}
+	used := make(map[string]bool, len(names))
+	for _, name := range names {
+		used[filepath.Join(root, filepath.Clean(name))] = true
+	}
+
+	// ASSETS, DATA AND THE DATABASE MAY SIT INSIDE THE THUMBNAIL DIRECTORY, OR BE IT
+	var skip []string
+	for _, dir := range []string{e.cfg.StoragePath, e.cfg.DataPath} {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if abs == root {
+			return MaintenanceResult{Status: MaintenanceSkipped, Message: "THUMBNAILS SHARE A DIRECTORY WITH ASSETS OR DATA"}, nil
+		}
+		skip = append(skip, abs)
+	}
+
+	result := MaintenanceResult{Status: MaintenanceDone}
+	cutoff := time.Now().Add(-orphanThumbnailAge)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if slices.Contains(skip, path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if used[path] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("MAINTENANCE: FAILED TO DELETE %s: %v", path, err)
+			return nil
+		}
+		result.Removed++
+		result.FreedBytes += info.Size()
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return result, err
+	}
+	return result, nil
+}
+
+// HAND THE ENGINE THE APPLICATION LOG FILE, FOR MAINTENANCE AND THE LOG API
+func (e *Engine) SetAppLog(appLog *utils.RotatingLog) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.appLog = appLog
+}
+
+// THE APPLICATION LOG FILE, NIL WHEN NOT LOGGING TO ONE
+func (e *Engine) AppLog() *utils.RotatingLog {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.appLog
+}
+
+// DELETE ROTATED APPLICATION LOGS PAST THEIR AGE OR BACKUP COUNT (THE LOG ROTATES ITSELF AS IT
+// IS WRITTEN; THIS CATCHES UP WHEN IT HASN'T BEEN WRITTEN FOR A WHILE)
+func (e *Engine) pruneAppLogs() (MaintenanceResult, error) {
+	appLog := e.AppLog()
+	if appLog == nil {
+		return MaintenanceResult{Status: MaintenanceSkipped, Message: "NOT LOGGING TO A FILE"}, nil
+	}
+	removed, freed := appLog.Prune()
+	return MaintenanceResult{Status: MaintenanceDone, Removed: int64(removed), FreedBytes: freed}, nil
+}
+
+// DELETE ITEM RESULTS AND TIMELINES OF ALL BUT EACH JOB'S LATEST keepRuns RUNS, ERROR LOGS (WITH THEIR
+// CAPTURES) THAT WERE ACKNOWLEDGED OR RESOLVED MORE THAN errorLogDays AGO, AND PART FILES OF
+// DOWNLOADS NOTHING HAS RESUMED IN A WEEK. OPEN ERRORS STAY
+func (e *Engine) pruneOldRuns() (MaintenanceResult, error) {
+	keepRuns := e.cfg.Maintenance.KeepRuns
+	if keepRuns <= 0 {
+		keepRuns = 20
+	}
+	days := e.cfg.Maintenance.ErrorLogDays
+	if days <= 0 {
+		days = 30
+	}
+	result := MaintenanceResult{Status: MaintenanceDone}
+
+	staleRuns := make(map[string]bool)
+	for _, model := range []any{&models.ItemResult{}, &models.RunEvent{}} {
+		var runs []struct {
+			JobID string
+			RunID string
+		}
+		if err := e.db.Model(model).Select("job_id, run_id, MAX(created_at) AS last").
+			Group("job_id, run_id").Order("job_id, last DESC").Scan(&runs).Error; err != nil {
+			return result, err
+		}
+		var stale []string
+		kept := make(map[string]int)
+		for _, run := range runs {
+			kept[run.JobID]++
+			if kept[run.JobID] > keepRuns {
+				stale = append(stale, run.RunID)
+				staleRuns[run.RunID] = true
+			}
+		}
+		for batch := range slices.Chunk(stale, 500) {
+			deleted := e.db.Where("run_id IN ?", batch).Delete(model)
+			if deleted.Error != nil {
+				return result, deleted.Error
+			}
+			result.Removed += deleted.RowsAffected
+		}
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	var errorLogs []models.ErrorLog
+	if err := e.db.Select("id", "screenshot", "snapshot", "har").
+		Where("status != ? AND created_at < ?", models.ErrorStatusOpen, cutoff).Find(&errorLogs).Error; err != nil {
+		return result, err
+	}
+	for _, entry := range errorLogs {
+		for _, capture := range []string{entry.Screenshot, entry.Snapshot, entry.HAR} {
+			result.FreedBytes += removeRetainedFile(e.cfg.StoragePath, capture)
+		}
+		deleted := e.db.Delete(&models.ErrorLog{}, "id = ?", entry.ID)
+		if deleted.Error != nil {
+			return result, deleted.Error
+		}
+		result.Removed += deleted.RowsAffected
+	}
+
+	partials, freed, err := e.pruneStalePartials()
+	if err != nil {
+		return result, err
+	}
+	result.Removed += partials
+	result.FreedBytes += freed
+	result.Message = fmt.Sprintf("%d OLD RUNS, %d ERROR LOGS, %d PARTIAL DOWNLOADS", len(staleRuns), len(errorLogs), partials)
+	return result, nil
+}
+
+// SIZE OF A FILE, 0 WHEN IT DOESN'T EXIST
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
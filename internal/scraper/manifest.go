@@ -0,0 +1,1200 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// -- STREAMING MANIFESTS (HLS / DASH) --
+
+// MAXIMUM MANIFEST SIZE WE WILL READ
+const maxManifestSize = 16 << 20
+
+// RETURNED WHEN A STREAM USES DRM WE CANNOT DECRYPT
+var ErrEncryptedStream = errors.New("STREAM IS DRM PROTECTED")
+
+// PARSED STREAMING MANIFEST
+type Manifest struct {
+	Type     string            `json:"type"` // hls, dash
+	URL      string            `json:"url"`
+	Master   bool              `json:"master"` // HLS MASTER PLAYLIST OR MULTI-VARIANT DASH
+	Live     bool              `json:"live"`
+	Duration float64           `json:"duration"` // SECONDS
+	Variants []ManifestVariant `json:"variants"`
+
+	// HLS MEDIA PLAYLIST TIMING, USED TO PACE LIVE POLLING
+	TargetDuration float64 `json:"targetDuration,omitempty"`
+}
+
+// A SINGLE DOWNLOADABLE RENDITION
+type ManifestVariant struct {
+	ID            string              `json:"id"`
+	Kind          string              `json:"kind"` // video, audio, subtitles
+	URL           string              `json:"url"`  // CHILD PLAYLIST (HLS) OR BASE URL (DASH)
+	Bandwidth     int                 `json:"bandwidth"`
+	Width         int                 `json:"width,omitempty"`
+	Height        int                 `json:"height,omitempty"`
+	Codecs        string              `json:"codecs,omitempty"`
+	MimeType      string              `json:"mimeType,omitempty"`
+	Language      string              `json:"language,omitempty"`
+	Name          string              `json:"name,omitempty"`
+	GroupID       string              `json:"groupId,omitempty"`
+	AudioGroup    string              `json:"audioGroup,omitempty"`
+	SubtitleGroup string              `json:"subtitleGroup,omitempty"`
+	Default       bool                `json:"default,omitempty"`
+	Encryption    *ManifestEncryption `json:"encryption,omitempty"`
+	Init          *MediaSegment       `json:"-"`
+	Segments      []MediaSegment      `json:"-"`
+	SegmentCount  int                 `json:"segmentCount"`
+}
+
+// ENCRYPTION SIGNALED BY THE MANIFEST
+type ManifestEncryption struct {
+	Method     string `json:"method"` // AES-128, SAMPLE-AES, cenc, ...
+	KeyURI     string `json:"keyUri,omitempty"`
+	IV         string `json:"iv,omitempty"`
+	KeyFormat  string `json:"keyFormat,omitempty"`
+	SchemeID   string `json:"schemeId,omitempty"`
+	DefaultKID string `json:"defaultKid,omitempty"`
+}
+
+// ONE MEDIA SEGMENT
+type MediaSegment struct {
+	URL      string
+	Duration float64
+	Sequence int64
+	Range    *ByteRange
+	Key      *ManifestEncryption
+}
+
+// BYTE RANGE WITHIN A RESOURCE
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// HTTP RANGE HEADER VALUE
+func (r ByteRange) Header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+}
+
+// IS THIS URL OR CONTENT TYPE A STREAMING MANIFEST
+func manifestType(rawURL, contentType string) string {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "mpegurl"):
+		return "hls"
+	case strings.Contains(contentType, "dash+xml"):
+		return "dash"
+	}
+	if _, format, ok := extensionKind(rawURL); ok && (format == "hls" || format == "dash") {
+		return format
+	}
+	return ""
+}
+
+// FETCH AND PARSE A MANIFEST; HLS MASTER PLAYLISTS HAVE THEIR CHILD PLAYLISTS RESOLVED
+func FetchManifest(ctx context.Context, client *http.Client, header http.Header, rawURL string) (*Manifest, error) {
+	body, finalURL, contentType, err := fetchManifestBody(ctx, client, header, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := manifestType(finalURL.String(), contentType)
+	if kind == "" {
+		kind = sniffManifest(body)
+	}
+
+	var manifest *Manifest
+	switch kind {
+	case "hls":
+		manifest, err = ParseHLS(body, finalURL)
+	case "dash":
+		manifest, err = ParseDASH(body, finalURL)
+	default:
+		return nil, fmt.Errorf("NOT A STREAMING MANIFEST: %s", rawURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.Type == "hls" && manifest.Master {
+		resolveHLSChildren(ctx, client, header, manifest)
+	}
+	return manifest, nil
+}
+
+// GET A MANIFEST BODY
+func fetchManifestBody(ctx context.Context, client *http.Client, header http.Header, rawURL string) ([]byte, *url.URL, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("FAILED TO CREATE REQUEST: %v", err)
+	}
+	if header != nil {
+		req.Header = header.Clone()
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", DefaultUserAgent())
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("MANIFEST REQUEST FAILED: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, "", &downloadStatusError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("FAILED TO READ MANIFEST: %v", err)
+	}
+	return body, resp.Request.URL, resp.Header.Get("Content-Type"), nil
+}
+
+// GUESS MANIFEST TYPE FROM ITS CONTENT
+func sniffManifest(body []byte) string {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")):
+		return "hls"
+	case bytes.Contains(trimmed[:min(len(trimmed), 1024)], []byte("<MPD")):
+		return "dash"
+	}
+	return ""
+}
+
+// FETCH EVERY HLS CHILD PLAYLIST SO VARIANTS CARRY THEIR SEGMENTS
+func resolveHLSChildren(ctx context.Context, client *http.Client, header http.Header, manifest *Manifest) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, 4)
+
+	for i := range manifest.Variants {
+		if manifest.Variants[i].URL == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(v *ManifestVariant) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			body, finalURL, _, err := fetchManifestBody(ctx, client, header, v.URL)
+			if err != nil {
+				return
+			}
+			child, err := ParseHLS(body, finalURL)
+			if err != nil || child.Master || len(child.Variants) == 0 {
+				return
+			}
+			media := child.Variants[0]
+			v.Init = media.Init
+			v.Segments = media.Segments
+			v.SegmentCount = len(media.Segments)
+			if v.Encryption == nil {
+				v.Encryption = media.Encryption
+			}
+		}(&manifest.Variants[i])
+	}
+	wg.Wait()
+
+	// TOTAL DURATION FROM THE FIRST VARIANT WITH SEGMENTS
+	for _, v := range manifest.Variants {
+		if len(v.Segments) > 0 {
+			manifest.Duration = segmentsDuration(v.Segments)
+			break
+		}
+	}
+}
+
+// -- HLS --
+
+// PARSE AN HLS MASTER OR MEDIA PLAYLIST
+func ParseHLS(data []byte, base *url.URL) (*Manifest, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxManifestSize)
+
+	manifest := &Manifest{Type: "hls", URL: base.String(), Live: true}
+	media := ManifestVariant{ID: "0", Kind: "video", URL: base.String()}
+
+	var (
+		first         = true
+		pendingStream map[string]string
+		pendingDur    float64
+		pendingRange  *ByteRange
+		lastRangeEnd  = map[string]int64{}
+		currentKey    *ManifestEncryption
+		sequence      int64
+		streamIndex   int
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if first {
+			if !strings.HasPrefix(strings.TrimPrefix(line, "\ufeff"), "#EXTM3U") {
+				return nil, errors.New("INVALID HLS PLAYLIST: MISSING #EXTM3U")
+			}
+			first = false
+			continue
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			// URI LINE
+			uri := resolveRef(base, line)
+			if pendingStream != nil {
+				manifest.Master = true
+				v := hlsStreamVariant(pendingStream, uri, streamIndex)
+				streamIndex++
+				manifest.Variants = append(manifest.Variants, v)
+				pendingStream = nil
+				continue
+			}
+			segment := MediaSegment{URL: uri, Duration: pendingDur, Sequence: sequence, Key: currentKey}
+			if pendingRange != nil {
+				r := *pendingRange
+				if r.Offset < 0 {
+					r.Offset = lastRangeEnd[uri]
+				}
+				lastRangeEnd[uri] = r.Offset + r.Length
+				segment.Range = &r
+			}
+			media.Segments = append(media.Segments, segment)
+			sequence++
+			pendingDur, pendingRange = 0, nil
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			pendingStream = parseAttributeList(value)
+		case "#EXT-X-MEDIA":
+			manifest.Master = true
+			attrs := parseAttributeList(value)
+			v := ManifestVariant{
+				ID:       fmt.Sprintf("media-%d", len(manifest.Variants)),
+				Kind:     strings.ToLower(attrs["TYPE"]),
+				GroupID:  attrs["GROUP-ID"],
+				Name:     attrs["NAME"],
+				Language: attrs["LANGUAGE"],
+				Default:  attrs["DEFAULT"] == "YES",
+			}
+			if v.Kind == "closed-captions" {
+				continue // IN-BAND, NOTHING TO DOWNLOAD
+			}
+			if uri, ok := attrs["URI"]; ok {
+				v.URL = resolveRef(base, uri)
+			} else {
+				continue // RENDITION MUXED INTO THE MAIN STREAM
+			}
+			manifest.Variants = append(manifest.Variants, v)
+		case "#EXTINF":
+			durStr, _, _ := strings.Cut(value, ",")
+			pendingDur, _ = strconv.ParseFloat(strings.TrimSpace(durStr), 64)
+		case "#EXT-X-BYTERANGE":
+			pendingRange = parseHLSByteRange(value)
+		case "#EXT-X-TARGETDURATION":
+			manifest.TargetDuration, _ = strconv.ParseFloat(value, 64)
+		case "#EXT-X-MEDIA-SEQUENCE":
+			sequence, _ = strconv.ParseInt(value, 10, 64)
+		case "#EXT-X-KEY":
+			attrs := parseAttributeList(value)
+			if attrs["METHOD"] == "NONE" {
+				currentKey = nil
+				continue
+			}
+			currentKey = &ManifestEncryption{
+				Method:    attrs["METHOD"],
+				KeyURI:    resolveRef(base, attrs["URI"]),
+				IV:        attrs["IV"],
+				KeyFormat: attrs["KEYFORMAT"],
+			}
+			if media.Encryption == nil {
+				media.Encryption = currentKey
+			}
+		case "#EXT-X-MAP":
+			attrs := parseAttributeList(value)
+			init := &MediaSegment{URL: resolveRef(base, attrs["URI"]), Key: currentKey}
+			if br, ok := attrs["BYTERANGE"]; ok {
+				if r := parseHLSByteRange(br); r != nil {
+					if r.Offset < 0 {
+						r.Offset = 0
+					}
+					init.Range = r
+				}
+			}
+			media.Init = init
+		case "#EXT-X-ENDLIST":
+			manifest.Live = false
+		case "#EXT-X-PLAYLIST-TYPE":
+			if value == "VOD" {
+				manifest.Live = false
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("FAILED TO READ HLS PLAYLIST: %v", err)
+	}
+	if first {
+		return nil, errors.New("INVALID HLS PLAYLIST: EMPTY")
+	}
+
+	if manifest.Master {
+		manifest.Live = false
+		linkHLSGroups(manifest)
+		return manifest, nil
+	}
+
+	media.SegmentCount = len(media.Segments)
+	manifest.Duration = segmentsDuration(media.Segments)
+	manifest.Variants = []ManifestVariant{media}
+	return manifest, nil
+}
+
+// BUILD A VARIANT FROM EXT-X-STREAM-INF ATTRIBUTES
+func hlsStreamVariant(attrs map[string]string, uri string, index int) ManifestVariant {
+	v := ManifestVariant{
+		ID:            fmt.Sprintf("stream-%d", index),
+		Kind:          "video",
+		URL:           uri,
+		Codecs:        attrs["CODECS"],
+		AudioGroup:    attrs["AUDIO"],
+		SubtitleGroup: attrs["SUBTITLES"],
+	}
+	v.Bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+	if avg, err := strconv.Atoi(attrs["AVERAGE-BANDWIDTH"]); err == nil && v.Bandwidth == 0 {
+		v.Bandwidth = avg
+	}
+	if res := attrs["RESOLUTION"]; res != "" {
+		w, h, _ := strings.Cut(res, "x")
+		v.Width, _ = strconv.Atoi(w)
+		v.Height, _ = strconv.Atoi(h)
+	}
+	// AUDIO-ONLY STREAMS ADVERTISE ONLY AUDIO CODECS
+	if v.Height == 0 && v.Codecs != "" && !hasVideoCodec(v.Codecs) {
+		v.Kind = "audio"
+	}
+	return v
+}
+
+// FILL IN GROUP BANDWIDTH FOR AUDIO RENDITIONS FROM THE STREAMS THAT REFERENCE THEM
+func linkHLSGroups(manifest *Manifest) {
+	for i := range manifest.Variants {
+		v := &manifest.Variants[i]
+		if v.GroupID == "" || v.Kind != "audio" {
+			continue
+		}
+		for _, stream := range manifest.Variants {
+			if stream.AudioGroup == v.GroupID && v.Codecs == "" {
+				for _, codec := range strings.Split(stream.Codecs, ",") {
+					if !isVideoCodec(codec) {
+						v.Codecs = strings.TrimSpace(codec)
+					}
+				}
+			}
+		}
+	}
+}
+
+// PARSE "<n>[@<o>]"; OFFSET IS -1 WHEN IT CONTINUES FROM THE PREVIOUS RANGE
+func parseHLSByteRange(value string) *ByteRange {
+	lengthStr, offsetStr, hasOffset := strings.Cut(strings.Trim(value, `"`), "@")
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length <= 0 {
+		return nil
+	}
+	r := &ByteRange{Offset: -1, Length: length}
+	if hasOffset {
+		r.Offset, _ = strconv.ParseInt(offsetStr, 10, 64)
+	}
+	return r
+}
+
+var attributePattern = regexp.MustCompile(`([A-Z0-9-]+)=("[^"]*"|[^,]*)`)
+
+// PARSE AN HLS ATTRIBUTE LIST (KEY=VALUE,KEY="QUOTED, VALUE")
+func parseAttributeList(value string) map[string]string {
+	attrs := make(map[string]string)
+	for _, match := range attributePattern.FindAllStringSubmatch(value, -1) {
+		attrs[match[1]] = strings.Trim(match[2], `"`)
+	}
+	return attrs
+}
+
+// -- DASH --
+
+type mpdDocument struct {
+	XMLName                   xml.Name    `xml:"MPD"`
+	Type                      string      `xml:"type,attr"`
+	MediaPresentationDuration string      `xml:"mediaPresentationDuration,attr"`
+	BaseURLs                  []string    `xml:"BaseURL"`
+	Periods                   []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	Duration       string             `xml:"duration,attr"`
+	BaseURLs       []string           `xml:"BaseURL"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID                 string              `xml:"id,attr"`
+	MimeType           string              `xml:"mimeType,attr"`
+	ContentType        string              `xml:"contentType,attr"`
+	Lang               string              `xml:"lang,attr"`
+	Codecs             string              `xml:"codecs,attr"`
+	Width              int                 `xml:"width,attr"`
+	Height             int                 `xml:"height,attr"`
+	BaseURLs           []string            `xml:"BaseURL"`
+	SegmentTemplate    *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList        *mpdSegmentList     `xml:"SegmentList"`
+	SegmentBase        *mpdSegmentBase     `xml:"SegmentBase"`
+	ContentProtections []mpdContentProtect `xml:"ContentProtection"`
+	Roles              []mpdDescriptor     `xml:"Role"`
+	Representations    []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID                 string              `xml:"id,attr"`
+	Bandwidth          int                 `xml:"bandwidth,attr"`
+	Width              int                 `xml:"width,attr"`
+	Height             int                 `xml:"height,attr"`
+	Codecs             string              `xml:"codecs,attr"`
+	MimeType           string              `xml:"mimeType,attr"`
+	BaseURLs           []string            `xml:"BaseURL"`
+	SegmentTemplate    *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList        *mpdSegmentList     `xml:"SegmentList"`
+	SegmentBase        *mpdSegmentBase     `xml:"SegmentBase"`
+	ContentProtections []mpdContentProtect `xml:"ContentProtection"`
+}
+
+type mpdSegmentTemplate struct {
+	Media          string              `xml:"media,attr"`
+	Initialization string              `xml:"initialization,attr"`
+	StartNumber    *int64              `xml:"startNumber,attr"`
+	Timescale      int64               `xml:"timescale,attr"`
+	Duration       int64               `xml:"duration,attr"`
+	Timeline       *mpdSegmentTimeline `xml:"SegmentTimeline"`
+}
+
+type mpdSegmentTimeline struct {
+	S []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"S"`
+}
+
+type mpdSegmentList struct {
+	Timescale      int64          `xml:"timescale,attr"`
+	Duration       int64          `xml:"duration,attr"`
+	Initialization *mpdURLElement `xml:"Initialization"`
+	SegmentURLs    []struct {
+		Media      string `xml:"media,attr"`
+		MediaRange string `xml:"mediaRange,attr"`
+	} `xml:"SegmentURL"`
+}
+
+type mpdSegmentBase struct {
+	Initialization *mpdURLElement `xml:"Initialization"`
+}
+
+type mpdURLElement struct {
+	SourceURL string `xml:"sourceURL,attr"`
+	Range     string `xml:"range,attr"`
+}
+
+type mpdContentProtect struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+	DefaultKID  string `xml:"urn:mpeg:cenc:2013 default_KID,attr"`
+}
+
+type mpdDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+// PARSE A DASH MPD INTO VARIANTS, CONCATENATING SEGMENTS ACROSS PERIODS
+func ParseDASH(data []byte, base *url.URL) (*Manifest, error) {
+	var mpd mpdDocument
+	if err := xml.Unmarshal(data, &mpd); err != nil {
+		return nil, fmt.Errorf("INVALID DASH MANIFEST: %v", err)
+	}
+	if len(mpd.Periods) == 0 {
+		return nil, errors.New("INVALID DASH MANIFEST: NO PERIODS")
+	}
+
+	manifest := &Manifest{
+		Type:     "dash",
+		URL:      base.String(),
+		Live:     mpd.Type == "dynamic",
+		Duration: parseISODuration(mpd.MediaPresentationDuration),
+	}
+	mpdBase := resolveBase(base, mpd.BaseURLs)
+
+	// PERIOD DURATIONS: EXPLICIT, OR NEXT START MINUS THIS START, OR REMAINDER OF THE PRESENTATION
+	periodDurations := make([]float64, len(mpd.Periods))
+	for i, period := range mpd.Periods {
+		if d := parseISODuration(period.Duration); d > 0 {
+			periodDurations[i] = d
+			continue
+		}
+		start := parseISODuration(period.Start)
+		if i+1 < len(mpd.Periods) && mpd.Periods[i+1].Start != "" {
+			periodDurations[i] = parseISODuration(mpd.Periods[i+1].Start) - start
+		} else {
+			periodDurations[i] = manifest.Duration - start
+		}
+	}
+
+	variants := map[string]*ManifestVariant{}
+	var order []string
+
+	for pi, period := range mpd.Periods {
+		periodBase := resolveBase(mpdBase, period.BaseURLs)
+
+		for _, set := range period.AdaptationSets {
+			setBase := resolveBase(periodBase, set.BaseURLs)
+			setKind := dashKind(set.ContentType, set.MimeType, set.Codecs, set.Roles)
+
+			for ri, rep := range set.Representations {
+				repBase := resolveBase(setBase, rep.BaseURLs)
+
+				kind := setKind
+				if kind == "" {
+					kind = dashKind("", rep.MimeType, rep.Codecs, nil)
+				}
+				v := ManifestVariant{
+					ID:        rep.ID,
+					Kind:      kind,
+					URL:       repBase.String(),
+					Bandwidth: rep.Bandwidth,
+					Width:     firstNonZero(rep.Width, set.Width),
+					Height:    firstNonZero(rep.Height, set.Height),
+					Codecs:    firstNonEmpty(rep.Codecs, set.Codecs),
+					MimeType:  firstNonEmpty(rep.MimeType, set.MimeType),
+					Language:  set.Lang,
+				}
+				if v.ID == "" {
+					v.ID = fmt.Sprintf("%s-%d", set.ID, ri)
+				}
+				if enc := dashEncryption(append(set.ContentProtections, rep.ContentProtections...)); enc != nil {
+					v.Encryption = enc
+				}
+
+				// REPRESENTATION-LEVEL SEGMENT INFO OVERRIDES ADAPTATION-SET DEFAULTS
+				template := mergeTemplates(set.SegmentTemplate, rep.SegmentTemplate)
+				list := rep.SegmentList
+				if list == nil {
+					list = set.SegmentList
+				}
+				segmentBase := rep.SegmentBase
+				if segmentBase == nil {
+					segmentBase = set.SegmentBase
+				}
+
+				switch {
+				case template != nil:
+					v.Init, v.Segments = dashTemplateSegments(template, repBase, rep, periodDurations[pi])
+				case list != nil:
+					v.Init, v.Segments = dashListSegments(list, repBase)
+				default:
+					// SINGLE FILE (SegmentBase OR BARE BaseURL)
+					v.Segments = []MediaSegment{{URL: repBase.String(), Duration: periodDurations[pi]}}
+					if segmentBase != nil && segmentBase.Initialization != nil && segmentBase.Initialization.SourceURL != "" {
+						v.Init = &MediaSegment{URL: resolveRef(repBase, segmentBase.Initialization.SourceURL)}
+					}
+				}
+
+				// JOIN PERIODS BY REPRESENTATION ID, THEN BY CLOSEST BANDWIDTH OF THE SAME KIND
+				key := kind + "|" + v.ID
+				existing, ok := variants[key]
+				if !ok && pi > 0 {
+					existing = closestVariant(variants, order, kind, v.Bandwidth)
+					ok = existing != nil
+				}
+				if ok {
+					existing.Segments = append(existing.Segments, v.Segments...)
+					existing.SegmentCount = len(existing.Segments)
+					if existing.Encryption == nil {
+						existing.Encryption = v.Encryption
+					}
+					continue
+				}
+				if pi > 0 {
+					// FIRST SEEN IN A LATER PERIOD; EARLIER CONTENT IS MISSING
+					continue
+				}
+				v.SegmentCount = len(v.Segments)
+				variants[key] = &v
+				order = append(order, key)
+			}
+		}
+	}
+
+	for _, key := range order {
+		manifest.Variants = append(manifest.Variants, *variants[key])
+	}
+	manifest.Master = len(manifest.Variants) > 1
+	return manifest, nil
+}
+
+// PICK THE PRIOR-PERIOD VARIANT OF THE SAME KIND WITH THE NEAREST BANDWIDTH
+func closestVariant(variants map[string]*ManifestVariant, order []string, kind string, bandwidth int) *ManifestVariant {
+	var best *ManifestVariant
+	bestDiff := math.MaxInt
+	for _, key := range order {
+		v := variants[key]
+		if v.Kind != kind {
+			continue
+		}
+		diff := v.Bandwidth - bandwidth
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < bestDiff {
+			best, bestDiff = v, diff
+		}
+	}
+	return best
+}
+
+// MERGE AN ADAPTATION-SET TEMPLATE WITH A REPRESENTATION TEMPLATE
+func mergeTemplates(parent, child *mpdSegmentTemplate) *mpdSegmentTemplate {
+	if parent == nil {
+		return child
+	}
+	if child == nil {
+		return parent
+	}
+	merged := *parent
+	if child.Media != "" {
+		merged.Media = child.Media
+	}
+	if child.Initialization != "" {
+		merged.Initialization = child.Initialization
+	}
+	if child.StartNumber != nil {
+		merged.StartNumber = child.StartNumber
+	}
+	if child.Timescale != 0 {
+		merged.Timescale = child.Timescale
+	}
+	if child.Duration != 0 {
+		merged.Duration = child.Duration
+	}
+	if child.Timeline != nil {
+		merged.Timeline = child.Timeline
+	}
+	return &merged
+}
+
+// EXPAND A SegmentTemplate INTO CONCRETE SEGMENT URLS
+func dashTemplateSegments(t *mpdSegmentTemplate, base *url.URL, rep mpdRepresentation, periodDuration float64) (*MediaSegment, []MediaSegment) {
+	timescale := t.Timescale
+	if timescale <= 0 {
+		timescale = 1
+	}
+	number := int64(1)
+	if t.StartNumber != nil {
+		number = *t.StartNumber
+	}
+
+	var init *MediaSegment
+	if t.Initialization != "" {
+		init = &MediaSegment{URL: resolveRef(base, expandTemplate(t.Initialization, rep, 0, 0))}
+	}
+
+	var segments []MediaSegment
+	if t.Timeline != nil {
+		var current int64
+		for _, s := range t.Timeline.S {
+			if s.T != nil {
+				current = *s.T
+			}
+			repeat := s.R
+			if repeat < 0 {
+				// REPEAT UNTIL THE END OF THE PERIOD
+				if s.D > 0 && periodDuration > 0 {
+					repeat = int64(math.Ceil(periodDuration*float64(timescale)/float64(s.D))) - 1
+				} else {
+					repeat = 0
+				}
+			}
+			for i := int64(0); i <= repeat; i++ {
+				segments = append(segments, MediaSegment{
+					URL:      resolveRef(base, expandTemplate(t.Media, rep, number, current)),
+					Duration: float64(s.D) / float64(timescale),
+					Sequence: number,
+				})
+				current += s.D
+				number++
+			}
+		}
+		return init, segments
+	}
+
+	if t.Duration <= 0 || periodDuration <= 0 {
+		return init, nil
+	}
+	segDuration := float64(t.Duration) / float64(timescale)
+	count := int64(math.Ceil(periodDuration / segDuration))
+	for i := int64(0); i < count; i++ {
+		segments = append(segments, MediaSegment{
+			URL:      resolveRef(base, expandTemplate(t.Media, rep, number, i*t.Duration)),
+			Duration: segDuration,
+			Sequence: number,
+		})
+		number++
+	}
+	return init, segments
+}
+
+// EXPAND A SegmentList
+func dashListSegments(list *mpdSegmentList, base *url.URL) (*MediaSegment, []MediaSegment) {
+	timescale := list.Timescale
+	if timescale <= 0 {
+		timescale = 1
+	}
+
+	var init *MediaSegment
+	if list.Initialization != nil {
+		init = &MediaSegment{URL: base.String(), Range: parseDASHRange(list.Initialization.Range)}
+		if list.Initialization.SourceURL != "" {
+			init.URL = resolveRef(base, list.Initialization.SourceURL)
+		}
+	}
+
+	segments := make([]MediaSegment, 0, len(list.SegmentURLs))
+	for i, s := range list.SegmentURLs {
+		segment := MediaSegment{
+			URL:      base.String(),
+			Duration: float64(list.Duration) / float64(timescale),
+			Sequence: int64(i),
+			Range:    parseDASHRange(s.MediaRange),
+		}
+		if s.Media != "" {
+			segment.URL = resolveRef(base, s.Media)
+		}
+		segments = append(segments, segment)
+	}
+	return init, segments
+}
+
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time)(%0?\d+d)?\$`)
+
+// SUBSTITUTE $IDENTIFIER$ PLACEHOLDERS IN A SEGMENT TEMPLATE
+func expandTemplate(template string, rep mpdRepresentation, number, time int64) string {
+	const escapedDollar = "\x00"
+	template = strings.ReplaceAll(template, "$$", escapedDollar)
+	out := templateIdentifier.ReplaceAllStringFunc(template, func(match string) string {
+		parts := templateIdentifier.FindStringSubmatch(match)
+		format := parts[2]
+		if format == "" {
+			format = "%d"
+		}
+		switch parts[1] {
+		case "RepresentationID":
+			return rep.ID
+		case "Number":
+			return fmt.Sprintf(format, number)
+		case "Bandwidth":
+			return fmt.Sprintf(format, rep.Bandwidth)
+		case "Time":
+			return fmt.Sprintf(format, time)
+		}
+		return match
+	})
+	return strings.ReplaceAll(out, escapedDollar, "$")
+}
+
+// PARSE A DASH "start-end" RANGE
+func parseDASHRange(value string) *ByteRange {
+	startStr, endStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil
+	}
+	start, err1 := strconv.ParseInt(startStr, 10, 64)
+	end, err2 := strconv.ParseInt(endStr, 10, 64)
+	if err1 != nil || err2 != nil || end < start {
+		return nil
+	}
+	return &ByteRange{Offset: start, Length: end - start + 1}
+}
+
+// DASH CONTENT KIND FROM ITS ATTRIBUTES
+func dashKind(contentType, mimeType, codecs string, roles []mpdDescriptor) string {
+	switch {
+	case contentType == "video", strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case contentType == "audio", strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case contentType == "text", strings.HasPrefix(mimeType, "text/"), strings.Contains(mimeType, "ttml"), codecs == "stpp", codecs == "wvtt":
+		return "subtitles"
+	}
+	for _, role := range roles {
+		if role.Value == "subtitle" || role.Value == "caption" {
+			return "subtitles"
+		}
+	}
+	if codecs != "" {
+		if hasVideoCodec(codecs) {
+			return "video"
+		}
+		return "audio"
+	}
+	return "video"
+}
+
+// ENCRYPTION FROM ContentProtection DESCRIPTORS
+func dashEncryption(protections []mpdContentProtect) *ManifestEncryption {
+	if len(protections) == 0 {
+		return nil
+	}
+	enc := &ManifestEncryption{Method: "cenc"}
+	for _, p := range protections {
+		if p.Value != "" && strings.EqualFold(p.SchemeIDURI, "urn:mpeg:dash:mp4protection:2011") {
+			enc.Method = p.Value
+		} else if enc.SchemeID == "" {
+			enc.SchemeID = p.SchemeIDURI
+		}
+		if p.DefaultKID != "" {
+			enc.DefaultKID = p.DefaultKID
+		}
+	}
+	return enc
+}
+
+// PARSE AN ISO 8601 DURATION (PnDTnHnMnS) INTO SECONDS
+func parseISODuration(value string) float64 {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "P") {
+		return 0
+	}
+	var total float64
+	inTime := false
+	num := ""
+	for _, r := range value[1:] {
+		switch {
+		case r == 'T':
+			inTime = true
+		case (r >= '0' && r <= '9') || r == '.':
+			num += string(r)
+		default:
+			n, _ := strconv.ParseFloat(num, 64)
+			num = ""
+			switch {
+			case r == 'Y':
+				total += n * 365 * 86400
+			case r == 'M' && !inTime:
+				total += n * 30 * 86400
+			case r == 'W':
+				total += n * 7 * 86400
+			case r == 'D':
+				total += n * 86400
+			case r == 'H':
+				total += n * 3600
+			case r == 'M':
+				total += n * 60
+			case r == 'S':
+				total += n
+			}
+		}
+	}
+	return total
+}
+
+// -- VARIANT SELECTION AND DOWNLOAD --
+
+// VALIDATE A VARIANT PREFERENCE
+func ValidateVariantPreference(preference string) error {
+	preference = strings.ToLower(strings.TrimSpace(preference))
+	switch preference {
+	case "", "best", "worst":
+		return nil
+	}
+	if _, err := strconv.Atoi(strings.TrimSuffix(preference, "p")); err != nil {
+		return fmt.Errorf("UNKNOWN VARIANT PREFERENCE: %s", preference)
+	}
+	return nil
+}
+
+// SELECT A VARIANT: "best" (DEFAULT), "worst", OR A TARGET HEIGHT LIKE "720"/"720p"
+func SelectVariant(manifest *Manifest, preference string) (*ManifestVariant, error) {
+	var candidates []*ManifestVariant
+	for i := range manifest.Variants {
+		v := &manifest.Variants[i]
+		if v.Kind == "video" && len(v.Segments) > 0 {
+			candidates = append(candidates, v)
+		}
+	}
+	// AUDIO-ONLY MANIFESTS
+	if len(candidates) == 0 {
+		for i := range manifest.Variants {
+			v := &manifest.Variants[i]
+			if v.Kind == "audio" && len(v.Segments) > 0 {
+				candidates = append(candidates, v)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("MANIFEST HAS NO DOWNLOADABLE VARIANTS")
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Height != candidates[j].Height {
+			return candidates[i].Height > candidates[j].Height
+		}
+		return candidates[i].Bandwidth > candidates[j].Bandwidth
+	})
+
+	preference = strings.ToLower(strings.TrimSpace(preference))
+	switch preference {
+	case "", "best":
+		return candidates[0], nil
+	case "worst":
+		return candidates[len(candidates)-1], nil
+	}
+
+	target, err := strconv.Atoi(strings.TrimSuffix(preference, "p"))
+	if err != nil {
+		return nil, fmt.Errorf("UNKNOWN VARIANT PREFERENCE: %s", preference)
+	}
+	// HIGHEST VARIANT AT OR BELOW THE TARGET, ELSE THE LOWEST AVAILABLE
+	for _, v := range candidates {
+		if v.Height <= target {
+			return v, nil
+		}
+	}
+	return candidates[len(candidates)-1], nil
+}
+
+// COMPANION AUDIO FOR A VIDEO VARIANT THAT CARRIES NO AUDIO (SEPARATE DASH/HLS AUDIO TRACKS)
+func CompanionAudio(manifest *Manifest, video *ManifestVariant) *ManifestVariant {
+	var best *ManifestVariant
+	for i := range manifest.Variants {
+		v := &manifest.Variants[i]
+		if v.Kind != "audio" || len(v.Segments) == 0 {
+			continue
+		}
+		if video.AudioGroup != "" && v.GroupID != video.AudioGroup {
+			continue
+		}
+		if best == nil || (v.Default && !best.Default) || (v.Default == best.Default && v.Bandwidth > best.Bandwidth) {
+			best = v
+		}
+	}
+	return best
+}
+
+// DOWNLOAD A VARIANT'S INIT SEGMENT AND MEDIA SEGMENTS INTO W, DECRYPTING AES-128 WHEN SIGNALED
+func DownloadVariant(ctx context.Context, client *http.Client, header http.Header, v *ManifestVariant, w io.Writer) (int64, error) {
+	if v.Encryption != nil && v.Encryption.Method != "AES-128" {
+		return 0, fmt.Errorf("%w (%s)", ErrEncryptedStream, v.Encryption.Method)
+	}
+
+	keys := make(map[string][]byte)
+	var total int64
+
+	segments := v.Segments
+	if v.Init != nil {
+		segments = append([]MediaSegment{*v.Init}, segments...)
+	}
+
+	for i, segment := range segments {
+		data, err := fetchSegment(ctx, client, header, segment)
+		if err != nil {
+			return total, fmt.Errorf("SEGMENT %d/%d FAILED: %w", i+1, len(segments), err)
+		}
+
+		if segment.Key != nil && segment.Key.Method == "AES-128" {
+			key, ok := keys[segment.Key.KeyURI]
+			if !ok {
+				key, err = fetchSegment(ctx, client, header, MediaSegment{URL: segment.Key.KeyURI})
+				if err != nil {
+					return total, fmt.Errorf("FAILED TO FETCH KEY: %w", err)
+				}
+				keys[segment.Key.KeyURI] = key
+			}
+			data, err = decryptAES128(data, key, segment.Key.IV, segment.Sequence)
+			if err != nil {
+				return total, fmt.Errorf("SEGMENT %d/%d DECRYPT FAILED: %w", i+1, len(segments), err)
+			}
+		}
+
+		n, err := w.Write(data)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// GET ONE SEGMENT, HONORING ITS BYTE RANGE
+func fetchSegment(ctx context.Context, client *http.Client, header http.Header, segment MediaSegment) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", segment.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header.Clone()
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", DefaultUserAgent())
+	}
+	if segment.Range != nil {
+		req.Header.Set("Range", segment.Range.Header())
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &downloadStatusError{StatusCode: resp.StatusCode}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// SERVER IGNORED THE RANGE HEADER; SLICE IT OURSELVES
+	if segment.Range != nil && resp.StatusCode == http.StatusOK {
+		end := segment.Range.Offset + segment.Range.Length
+		if int64(len(data)) < end {
+			return nil, errors.New("RESPONSE SHORTER THAN REQUESTED BYTE RANGE")
+		}
+		data = data[segment.Range.Offset:end]
+	}
+	return data, nil
+}
+
+// AES-128-CBC WITH PKCS7 PADDING; IV DEFAULTS TO THE MEDIA SEQUENCE NUMBER
+func decryptAES128(data, key []byte, ivHex string, sequence int64) ([]byte, error) {
+	if len(key) != 16 {
+		return nil, fmt.Errorf("INVALID KEY LENGTH: %d", len(key))
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("CIPHERTEXT IS NOT A MULTIPLE OF THE BLOCK SIZE")
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if ivHex != "" {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(ivHex, "0x"), "0X"))
+		if err != nil || len(decoded) != aes.BlockSize {
+			return nil, fmt.Errorf("INVALID IV: %s", ivHex)
+		}
+		iv = decoded
+	} else {
+		binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(out) {
+		return nil, errors.New("INVALID PADDING")
+	}
+	return out[:len(out)-pad], nil
+}
+
+// -- HELPERS --
+
+func resolveRef(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	resolved, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return resolved.String()
+}
+
+func resolveBase(base *url.URL, refs []string) *url.URL {
+	for _, ref := range refs {
+		if resolved, err := base.Parse(strings.TrimSpace(ref)); err == nil {
+			return resolved
+		}
+	}
+	return base
+}
+
+func segmentsDuration(segments []MediaSegment) float64 {
+	var total float64
+	for _, s := range segments {
+		total += s.Duration
+	}
+	return math.Round(total*1000) / 1000
+}
+
+func hasVideoCodec(codecs string) bool {
+	for _, codec := range strings.Split(codecs, ",") {
+		if isVideoCodec(codec) {
+			return true
+		}
+	}
+	return false
+}
+
+func isVideoCodec(codec string) bool {
+	codec = strings.ToLower(strings.TrimSpace(codec))
+	for _, prefix := range []string{"avc", "hvc", "hev", "vp0", "vp8", "vp9", "av01", "dvh", "dva", "mp4v"} {
+		if strings.HasPrefix(codec, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonZero(values ...int) int {
+	for _, v := range values {
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
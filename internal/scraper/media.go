@@ -0,0 +1,513 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// -- MEDIA EXTRACTION --
+
+// MEDIA SOURCE FOUND ON A PAGE
+type MediaSource struct {
+	URL      string `json:"url"`
+	Kind     string `json:"kind"`               // video, audio
+	Format   string `json:"format"`             // mp4, webm, hls, dash, mp3, ...
+	MimeType string `json:"mimeType,omitempty"` // FROM MARKUP OR HEAD RESPONSE
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Bitrate  int    `json:"bitrate,omitempty"` // BITS PER SECOND
+	Label    string `json:"label,omitempty"`   // QUALITY LABEL (E.G. 1080p)
+	Size     int64  `json:"size"`              // CONTENT-LENGTH FROM HEAD, -1 WHEN UNKNOWN
+	Origin   string `json:"origin"`            // WHERE IT WAS FOUND (video-tag, source-tag, og, json-ld, link)
+
+	// PARSED RENDITIONS FOR HLS/DASH MANIFESTS
+	Duration float64           `json:"duration,omitempty"`
+	Live     bool              `json:"live,omitempty"`
+	Variants []ManifestVariant `json:"variants,omitempty"`
+}
+
+// OPTIONS FOR AN EXTRACTION RUN
+type MediaExtractOptions struct {
+	Fingerprint string
+	HTTPVersion string
+	TLS         TLSPolicy
+	Guard       *NetGuard
+	DNS         DNSPolicy
+	Egress      EgressPolicy
+	Headers     map[string]string
+	ProbeSizes  bool // ISSUE HEAD REQUESTS FOR SIZES
+	Limits      HTMLLimits
+}
+
+// ELEMENTS MEDIA EXTRACTION LOOKS AT; THE REST OF THE PAGE IS NOT KEPT
+var mediaElements = []string{"base", "video", "audio", "source", "meta", "script", "a"}
+
+// MAXIMUM MANIFESTS EXPANDED PER EXTRACTION
+const maxManifestExpansions = 5
+
+// KNOWN MEDIA EXTENSIONS AND THEIR KIND/FORMAT
+var mediaExtensions = map[string][2]string{
+	".mp4":  {"video", "mp4"},
+	".m4v":  {"video", "mp4"},
+	".webm": {"video", "webm"},
+	".mov":  {"video", "mov"},
+	".mkv":  {"video", "mkv"},
+	".m3u8": {"video", "hls"},
+	".mpd":  {"video", "dash"},
+	".mp3":  {"audio", "mp3"},
+	".m4a":  {"audio", "m4a"},
+	".aac":  {"audio", "aac"},
+	".ogg":  {"audio", "ogg"},
+	".opus": {"audio", "opus"},
+	".flac": {"audio", "flac"},
+	".wav":  {"audio", "wav"},
+}
+
+// PREFERENCE ORDER WHEN QUALITY TIES (PROGRESSIVE FILES BEFORE MANIFESTS)
+var formatRank = map[string]int{
+	"mp4": 0, "webm": 1, "mov": 2, "mkv": 3, "hls": 4, "dash": 5,
+	"m4a": 6, "mp3": 7, "aac": 8, "opus": 9, "ogg": 10, "flac": 11, "wav": 12,
+}
+
+var (
+	mediaURLPattern   = regexp.MustCompile(`https?:\\?/\\?/[^\s"'<>]+?\.(?:mp4|m4v|webm|mov|mkv|m3u8|mpd|mp3|m4a|aac|ogg|opus|flac|wav)(?:\?[^\s"'<>]*)?`)
+	qualityPattern    = regexp.MustCompile(`(?i)(\d{3,4})p`)
+	dimensionsPattern = regexp.MustCompile(`(\d{3,4})x(\d{3,4})`)
+)
+
+// EXTRACT MEDIA STREAMS FROM A PAGE AND RETURN THEM RANKED BEST FIRST, WITH WHAT PARSING THE
+// PAGE COST (EMPTY FOR A DIRECT MEDIA URL)
+func ExtractMediaStreams(ctx context.Context, pageURL string, opts MediaExtractOptions) ([]MediaSource, HTMLParseReport, error) {
+	var report HTMLParseReport
+	base, err := url.Parse(pageURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, report, fmt.Errorf("INVALID URL: %s", pageURL)
+	}
+
+	client := NewHTTPClient(HTTPClientOptions{
+		Timeout:     30 * time.Second,
+		Fingerprint: opts.Fingerprint,
+		HTTPVersion: opts.HTTPVersion,
+		TLS:         opts.TLS,
+		Guard:       opts.Guard,
+		DNS:         opts.DNS,
+		Egress:      opts.Egress,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, report, fmt.Errorf("FAILED TO CREATE REQUEST: %v", err)
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	for key, value := range opts.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, report, fmt.Errorf("REQUEST FAILED: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, report, fmt.Errorf("BAD STATUS CODE: %d", resp.StatusCode)
+	}
+
+	// A DIRECT MEDIA URL IS ITS OWN ONLY SOURCE
+	contentType := resp.Header.Get("Content-Type")
+	if kind := mimeKind(contentType); kind != "" {
+		source := newMediaSource(resp.Request.URL.String(), "direct")
+		source.MimeType = contentType
+		source.Size = resp.ContentLength
+		return []MediaSource{source}, report, nil
+	}
+
+	doc, report, err := parseHTMLStream(resp.Body, mediaElements, opts.Limits)
+	if err != nil {
+		return nil, report, fmt.Errorf("FAILED TO PARSE HTML: %v", err)
+	}
+
+	// RESOLVE AGAINST THE FINAL URL AFTER REDIRECTS
+	base = resp.Request.URL
+	if href, ok := doc.Find("base[href]").Attr("href"); ok {
+		if resolved, err := base.Parse(href); err == nil {
+			base = resolved
+		}
+	}
+
+	sources := collectMediaSources(doc, base)
+
+	if opts.ProbeSizes {
+		probeMediaSizes(ctx, client, sources, opts.Headers)
+	}
+
+	expandManifests(ctx, client, sources, opts.Headers)
+
+	rankMediaSources(sources)
+	return sources, report, nil
+}
+
+// WALK THE DOCUMENT FOR MEDIA REFERENCES
+func collectMediaSources(doc *goquery.Document, base *url.URL) []MediaSource {
+	var sources []MediaSource
+	seen := make(map[string]int)
+
+	add := func(raw, origin string, apply func(*MediaSource)) {
+		raw = strings.TrimSpace(strings.ReplaceAll(raw, `\/`, `/`))
+		if raw == "" || strings.HasPrefix(raw, "blob:") || strings.HasPrefix(raw, "data:") {
+			return
+		}
+		resolved, err := base.Parse(raw)
+		if err != nil || (resolved.Scheme != "http" && resolved.Scheme != "https") {
+			return
+		}
+		abs := resolved.String()
+
+		// MERGE DETAILS INTO AN EXISTING ENTRY RATHER THAN DUPLICATING IT
+		if idx, ok := seen[abs]; ok {
+			if apply != nil {
+				apply(&sources[idx])
+			}
+			return
+		}
+
+		source := newMediaSource(abs, origin)
+		if apply != nil {
+			apply(&source)
+		}
+		if source.Kind == "" {
+			return
+		}
+		seen[abs] = len(sources)
+		sources = append(sources, source)
+	}
+
+	// <video>/<audio> AND THEIR <source> CHILDREN
+	doc.Find("video, audio").Each(func(_ int, media *goquery.Selection) {
+		kind := goquery.NodeName(media)
+		width, _ := strconv.Atoi(media.AttrOr("width", ""))
+		height, _ := strconv.Atoi(media.AttrOr("height", ""))
+
+		if src, ok := media.Attr("src"); ok {
+			add(src, kind+"-tag", func(s *MediaSource) {
+				s.Kind = kind
+				if height > 0 {
+					s.Width, s.Height = width, height
+				}
+			})
+		}
+		media.Find("source").Each(func(_ int, source *goquery.Selection) {
+			src, ok := source.Attr("src")
+			if !ok {
+				return
+			}
+			add(src, "source-tag", func(s *MediaSource) {
+				s.Kind = kind
+				if mime := source.AttrOr("type", ""); mime != "" {
+					s.MimeType = mime
+					if f := mimeFormat(mime); f != "" {
+						s.Format = f
+					}
+				}
+				for _, attr := range []string{"label", "size", "res", "data-quality", "title"} {
+					if label := source.AttrOr(attr, ""); label != "" {
+						s.Label = label
+						break
+					}
+				}
+				if s.Height == 0 {
+					s.Height = heightFromLabel(s.Label)
+				}
+				if s.Width == 0 {
+					s.Width = width
+				}
+			})
+		})
+	})
+
+	// OPEN GRAPH / TWITTER CARD
+	doc.Find(`meta[property^="og:video"], meta[property^="og:audio"], meta[name^="twitter:player:stream"]`).Each(func(_ int, meta *goquery.Selection) {
+		prop := meta.AttrOr("property", meta.AttrOr("name", ""))
+		if strings.HasSuffix(prop, ":type") || strings.HasSuffix(prop, ":width") || strings.HasSuffix(prop, ":height") {
+			return
+		}
+		kind := "video"
+		if strings.HasPrefix(prop, "og:audio") {
+			kind = "audio"
+		}
+		add(meta.AttrOr("content", ""), "og", func(s *MediaSource) {
+			if s.Kind == "" {
+				s.Kind = kind
+			}
+		})
+	})
+	ogWidth, _ := strconv.Atoi(doc.Find(`meta[property="og:video:width"]`).AttrOr("content", ""))
+	ogHeight, _ := strconv.Atoi(doc.Find(`meta[property="og:video:height"]`).AttrOr("content", ""))
+	for i := range sources {
+		if sources[i].Origin == "og" && sources[i].Height == 0 {
+			sources[i].Width, sources[i].Height = ogWidth, ogHeight
+		}
+	}
+
+	// JSON-LD VideoObject / AudioObject
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, script *goquery.Selection) {
+		var data any
+		if err := json.Unmarshal([]byte(script.Text()), &data); err != nil {
+			return
+		}
+		walkJSONLD(data, func(obj map[string]any) {
+			objType, _ := obj["@type"].(string)
+			kind := ""
+			switch objType {
+			case "VideoObject":
+				kind = "video"
+			case "AudioObject":
+				kind = "audio"
+			default:
+				return
+			}
+			contentURL, _ := obj["contentUrl"].(string)
+			add(contentURL, "json-ld", func(s *MediaSource) {
+				s.Kind = kind
+				if bitrate, ok := obj["bitrate"].(string); ok {
+					s.Bitrate = parseBitrate(bitrate)
+				}
+				if h, ok := obj["height"].(float64); ok {
+					s.Height = int(h)
+				}
+				if w, ok := obj["width"].(float64); ok {
+					s.Width = int(w)
+				}
+			})
+		})
+	})
+
+	// LINKS TO MEDIA FILES
+	doc.Find("a[href]").Each(func(_ int, link *goquery.Selection) {
+		href := link.AttrOr("href", "")
+		if _, _, ok := extensionKind(href); ok {
+			add(href, "link", func(s *MediaSource) {
+				if s.Label == "" {
+					s.Label = strings.TrimSpace(link.Text())
+				}
+			})
+		}
+	})
+
+	// MEDIA URLS EMBEDDED IN INLINE SCRIPTS (PLAYER CONFIGS)
+	doc.Find("script:not([src])").Each(func(_ int, script *goquery.Selection) {
+		for _, match := range mediaURLPattern.FindAllString(script.Text(), -1) {
+			add(match, "script", nil)
+		}
+	})
+
+	return sources
+}
+
+// BUILD A SOURCE WITH KIND/FORMAT GUESSED FROM THE URL
+func newMediaSource(rawURL, origin string) MediaSource {
+	source := MediaSource{URL: rawURL, Origin: origin, Size: -1}
+	if kind, format, ok := extensionKind(rawURL); ok {
+		source.Kind, source.Format = kind, format
+	}
+	source.Height = heightFromLabel(rawURL)
+	if m := dimensionsPattern.FindStringSubmatch(rawURL); m != nil {
+		source.Width, _ = strconv.Atoi(m[1])
+		source.Height, _ = strconv.Atoi(m[2])
+	}
+	return source
+}
+
+// ISSUE CONCURRENT HEAD REQUESTS TO FILL IN SIZES AND MIME TYPES
+func probeMediaSizes(ctx context.Context, client *http.Client, sources []MediaSource, headers map[string]string) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, 4)
+
+	for i := range sources {
+		// MANIFESTS DON'T HAVE A MEANINGFUL SIZE
+		if sources[i].Format == "hls" || sources[i].Format == "dash" {
+			continue
+		}
+		wg.Add(1)
+		go func(s *MediaSource) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			req, err := http.NewRequestWithContext(ctx, "HEAD", s.URL, nil)
+			if err != nil {
+				return
+			}
+			req.Header.Set("User-Agent", DefaultUserAgent())
+			for key, value := range headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return
+			}
+			s.Size = resp.ContentLength
+			if mime := resp.Header.Get("Content-Type"); mime != "" {
+				s.MimeType = mime
+				if s.Format == "" {
+					s.Format = mimeFormat(mime)
+				}
+			}
+		}(&sources[i])
+	}
+	wg.Wait()
+}
+
+// PARSE HLS/DASH SOURCES SO THEY CARRY THEIR VARIANTS AND RANK BY THEIR BEST RENDITION
+func expandManifests(ctx context.Context, client *http.Client, sources []MediaSource, headers map[string]string) {
+	header := http.Header{}
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+
+	expanded := 0
+	for i := range sources {
+		s := &sources[i]
+		if (s.Format != "hls" && s.Format != "dash") || expanded >= maxManifestExpansions {
+			continue
+		}
+		expanded++
+
+		manifest, err := FetchManifest(ctx, client, header, s.URL)
+		if err != nil {
+			continue
+		}
+		s.Variants = manifest.Variants
+		s.Duration = manifest.Duration
+		s.Live = manifest.Live
+		if best, err := SelectVariant(manifest, "best"); err == nil {
+			s.Width, s.Height = best.Width, best.Height
+			s.Bitrate = best.Bandwidth
+		}
+	}
+}
+
+// SORT BEST FIRST: VIDEO BEFORE AUDIO, THEN RESOLUTION, BITRATE, FORMAT PREFERENCE, SIZE
+func rankMediaSources(sources []MediaSource) {
+	kindRank := map[string]int{"video": 0, "audio": 1}
+	sort.SliceStable(sources, func(i, j int) bool {
+		a, b := sources[i], sources[j]
+		if kindRank[a.Kind] != kindRank[b.Kind] {
+			return kindRank[a.Kind] < kindRank[b.Kind]
+		}
+		if a.Height != b.Height {
+			return a.Height > b.Height
+		}
+		if a.Bitrate != b.Bitrate {
+			return a.Bitrate > b.Bitrate
+		}
+		ra, okA := formatRank[a.Format]
+		rb, okB := formatRank[b.Format]
+		if okA != okB {
+			return okA
+		}
+		if ra != rb {
+			return ra < rb
+		}
+		return a.Size > b.Size
+	})
+}
+
+// KIND AND FORMAT FROM A URL'S EXTENSION
+func extensionKind(rawURL string) (string, string, bool) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", false
+	}
+	info, ok := mediaExtensions[strings.ToLower(path.Ext(parsed.Path))]
+	return info[0], info[1], ok
+}
+
+// KIND FROM A MIME TYPE, EMPTY IF NOT MEDIA
+func mimeKind(mime string) string {
+	mime = strings.ToLower(mime)
+	switch {
+	case strings.HasPrefix(mime, "video/"):
+		return "video"
+	case strings.HasPrefix(mime, "audio/"):
+		return "audio"
+	case strings.Contains(mime, "mpegurl"), strings.Contains(mime, "dash+xml"):
+		return "video"
+	}
+	return ""
+}
+
+// FORMAT FROM A MIME TYPE
+func mimeFormat(mime string) string {
+	mime = strings.ToLower(strings.TrimSpace(strings.Split(mime, ";")[0]))
+	switch {
+	case strings.Contains(mime, "mpegurl"):
+		return "hls"
+	case strings.Contains(mime, "dash+xml"):
+		return "dash"
+	case mime == "audio/mpeg":
+		return "mp3"
+	case strings.Contains(mime, "/"):
+		return strings.SplitN(mime, "/", 2)[1]
+	}
+	return ""
+}
+
+// HEIGHT FROM A LABEL LIKE "720p"
+func heightFromLabel(label string) int {
+	if m := qualityPattern.FindStringSubmatch(label); m != nil {
+		height, _ := strconv.Atoi(m[1])
+		return height
+	}
+	return 0
+}
+
+// PARSE "2500 kbps" STYLE BITRATES TO BITS PER SECOND
+func parseBitrate(value string) int {
+	value = strings.ToLower(strings.TrimSpace(value))
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "mbps"):
+		multiplier, value = 1000000, strings.TrimSuffix(value, "mbps")
+	case strings.HasSuffix(value, "kbps"):
+		multiplier, value = 1000, strings.TrimSuffix(value, "kbps")
+	case strings.HasSuffix(value, "bps"):
+		value = strings.TrimSuffix(value, "bps")
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+	return int(n * float64(multiplier))
+}
+
+// VISIT EVERY OBJECT IN A JSON-LD DOCUMENT, INCLUDING @graph ENTRIES
+func walkJSONLD(data any, visit func(map[string]any)) {
+	switch v := data.(type) {
+	case map[string]any:
+		visit(v)
+		for _, child := range v {
+			walkJSONLD(child, visit)
+		}
+	case []any:
+		for _, child := range v {
+			walkJSONLD(child, visit)
+		}
+	}
+}
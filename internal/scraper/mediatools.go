@@ -0,0 +1,39 @@
+package scraper
+
+import (
+	"log"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// LOOK FOR ffmpeg, ffprobe, IMAGEMAGICK, pdftotext AND LIBREOFFICE AT THE CONFIGURED PATHS (OR ON
+// THE PATH) AND LOG WHAT EACH MISSING ONE MEANS FOR THUMBNAILS AND SEARCH
+func DetectMediaTools(cfg *config.Config) utils.MediaTools {
+	tools := utils.DetectMediaTools(utils.MediaToolPaths{
+		FFmpeg:  cfg.FFmpegPath,
+		FFprobe: cfg.FFprobePath,
+		Magick:  cfg.MagickPath,
+
+		Pdftotext:   cfg.PdftotextPath,
+		LibreOffice: cfg.LibreOfficePath,
+	})
+	for _, tool := range []struct {
+		name    string
+		status  utils.ToolStatus
+		without string
+	}{
+		{"ffmpeg", tools.FFmpeg, "VIDEO THUMBNAILS WILL BE PLACEHOLDERS"},
+		{"ffprobe", tools.FFprobe, "VIDEO THUMBNAILS WILL USE THE FRAME AT 1s"},
+		{"ImageMagick", tools.Magick, "PDF AND UNCOMMON IMAGE FORMAT THUMBNAILS WILL BE PLACEHOLDERS"},
+		{"pdftotext", tools.Pdftotext, "PDF TEXT WON'T BE SEARCHABLE"},
+		{"LibreOffice", tools.LibreOffice, "OFFICE DOCUMENT THUMBNAILS WILL BE PLACEHOLDERS"},
+	} {
+		if tool.status.Available {
+			log.Printf("%s FOUND: %s (%s)", tool.name, tool.status.Path, tool.status.Version)
+		} else {
+			log.Printf("WARNING: %s NOT AVAILABLE (%s), %s", tool.name, tool.status.Error, tool.without)
+		}
+	}
+	return tools
+}
@@ -0,0 +1,302 @@
+package scraper
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- MEMORY WATCHDOG --
+//
+// BROWSERS ARE THE FIRST THING TO EAT A MACHINE'S MEMORY, AND WHEN THE OOM KILLER STEPS IN IT
+// TAKES THE SERVER WITH THEM. THE WATCHDOG SAMPLES THE RSS OF THE SERVER AND EVERYTHING IT
+// STARTED. ABOVE THE SOFT LIMIT PARALLEL STAGES RUN WITH HALF THEIR WORKERS AND NEW DOWNLOADS
+// WAIT; ABOVE THE HARD LIMIT THEY RUN WITH ONE WORKER AND THE HEAVIEST BROWSER A JOB HOLDS IS
+// CLOSED (IT IS RELAUNCHED THE NEXT TIME A TASK NEEDS IT).
+
+// COMMAND-LINE FLAG THAT TAGS EACH LAUNCHED BROWSER SO ITS PROCESSES CAN BE FOUND
+const launchTagFlag = "--crepes-launch"
+
+const (
+	memoryCheckInterval    = 5 * time.Second
+	browserRecycleCooldown = 30 * time.Second // LET THE LAST RECYCLE SHOW UP BEFORE THE NEXT
+	maxDownloadDeferral    = 2 * time.Minute  // DOWNLOADS GO AHEAD ANYWAY AFTER WAITING THIS LONG
+	defaultMemorySoftShare = 0.70             // OF THE CONTAINER OR SYSTEM MEMORY
+	defaultMemoryHardShare = 0.85
+)
+
+// MEMORY PRESSURE LEVELS
+const (
+	memoryOK int32 = iota
+	memoryHigh
+	memoryCritical
+)
+
+var memoryLevelNames = []string{"ok", "high", "critical"}
+
+// ONE SAMPLE OF THE PROCESS TREE
+type memorySample struct {
+	Total    uint64
+	Browsers map[string]uint64 // LAUNCH TAG -> RSS OF THE BROWSER AND ITS HELPERS
+}
+
+// MEMORY USED BY ONE BROWSER
+type BrowserMemory struct {
+	JobID     string `json:"jobId,omitempty"` // EMPTY FOR BROWSERS NO JOB HOLDS (REPLAYS, TESTS)
+	BrowserID string `json:"browserId,omitempty"`
+	RSS       uint64 `json:"rss"`
+}
+
+// WHAT THE WATCHDOG LAST SAW AND DID
+type MemoryStatus struct {
+	Available         bool            `json:"available"` // FALSE WHERE PROCESS MEMORY CAN'T BE READ
+	Level             string          `json:"level"`     // ok, high OR critical
+	RSS               uint64          `json:"rss"`       // SERVER, DRIVER AND BROWSERS TOGETHER
+	SoftLimit         uint64          `json:"softLimit"`
+	HardLimit         uint64          `json:"hardLimit"`
+	LimitSource       string          `json:"limitSource"` // config, cgroup OR system
+	Browsers          []BrowserMemory `json:"browsers"`
+	RecycledBrowsers  int64           `json:"recycledBrowsers"`
+	DeferredDownloads int64           `json:"deferredDownloads"`
+	CheckedAt         time.Time       `json:"checkedAt"`
+}
+
+type memoryWatchdog struct {
+	level       atomic.Int32
+	recycled    atomic.Int64
+	deferred    atomic.Int64
+	systemLimit uint64
+	limitSource string
+	mu          sync.Mutex
+	browsers    map[string]playwright.Browser // LAUNCH TAG -> BROWSER
+	lastRecycle time.Time
+	status      MemoryStatus
+}
+
+func newMemoryWatchdog() *memoryWatchdog {
+	limit, source := systemMemoryLimit()
+	return &memoryWatchdog{
+		systemLimit: limit,
+		limitSource: source,
+		browsers:    make(map[string]playwright.Browser),
+		status:      MemoryStatus{Level: memoryLevelNames[memoryOK]},
+	}
+}
+
+// REMEMBER A LAUNCHED BROWSER UNTIL IT DISCONNECTS
+func (m *memoryWatchdog) track(tag string, browser playwright.Browser) {
+	m.mu.Lock()
+	m.browsers[tag] = browser
+	m.mu.Unlock()
+	browser.OnDisconnected(func(playwright.Browser) {
+		m.mu.Lock()
+		delete(m.browsers, tag)
+		m.mu.Unlock()
+	})
+}
+
+// WORKERS A PARALLEL STAGE OF size MAY RUN AT THE CURRENT PRESSURE
+func (m *memoryWatchdog) allowed(size int) int {
+	switch m.level.Load() {
+	case memoryHigh:
+		return max(1, size/2)
+	case memoryCritical:
+		return 1
+	}
+	return size
+}
+
+// SOFT AND HARD LIMITS IN BYTES, FROM CONFIG OR A SHARE OF THE AVAILABLE MEMORY (0 = OFF)
+func (e *Engine) memoryLimits() (soft, hard uint64, source string) {
+	if e.cfg.MemorySoftLimit < 0 || e.cfg.MemoryHardLimit < 0 {
+		return 0, 0, "config"
+	}
+	soft = uint64(float64(e.memory.systemLimit) * defaultMemorySoftShare)
+	hard = uint64(float64(e.memory.systemLimit) * defaultMemoryHardShare)
+	source = e.memory.limitSource
+	if e.cfg.MemorySoftLimit > 0 {
+		soft, source = uint64(e.cfg.MemorySoftLimit)<<20, "config"
+	}
+	if e.cfg.MemoryHardLimit > 0 {
+		hard, source = uint64(e.cfg.MemoryHardLimit)<<20, "config"
+	}
+	if hard > 0 && (soft == 0 || soft > hard) {
+		soft = hard
+	}
+	return soft, hard, source
+}
+
+// SAMPLE MEMORY, ADJUST THE PRESSURE LEVEL AND RECYCLE A BROWSER IF IT'S CRITICAL
+func (e *Engine) checkMemory() {
+	m := e.memory
+	sample, ok := sampleMemory()
+	soft, hard, source := e.memoryLimits()
+	if !ok || hard == 0 {
+		m.level.Store(memoryOK)
+		m.mu.Lock()
+		m.status = MemoryStatus{Available: ok, Level: memoryLevelNames[memoryOK], RSS: sample.Total, LimitSource: source, CheckedAt: time.Now()}
+		m.mu.Unlock()
+		return
+	}
+
+	level := memoryOK
+	switch {
+	case sample.Total >= hard:
+		level = memoryCritical
+	case sample.Total >= soft:
+		level = memoryHigh
+	}
+	if previous := m.level.Swap(level); previous != level {
+		log.Printf("MEMORY %s: %d MB IN USE (SOFT LIMIT %d MB, HARD LIMIT %d MB)", memoryLevelNames[level], sample.Total>>20, soft>>20, hard>>20)
+		e.events.Publish("memory.pressure", "", map[string]any{"level": memoryLevelNames[level], "rss": sample.Total, "softLimit": soft, "hardLimit": hard})
+		if level == memoryCritical {
+			debug.FreeOSMemory()
+		}
+	}
+
+	browsers := e.browserMemory(sample)
+	m.mu.Lock()
+	m.status = MemoryStatus{
+		Available:   true,
+		Level:       memoryLevelNames[level],
+		RSS:         sample.Total,
+		SoftLimit:   soft,
+		HardLimit:   hard,
+		LimitSource: source,
+		Browsers:    browsers,
+		CheckedAt:   time.Now(),
+	}
+	recycle := level == memoryCritical && time.Since(m.lastRecycle) >= browserRecycleCooldown
+	if recycle {
+		m.lastRecycle = time.Now()
+	}
+	m.mu.Unlock()
+
+	if recycle {
+		e.recycleHeaviestBrowser(browsers)
+	}
+}
+
+// MEMORY PER TRACKED BROWSER, HEAVIEST FIRST, WITH THE JOB HOLDING EACH ONE
+func (e *Engine) browserMemory(sample memorySample) []BrowserMemory {
+	m := e.memory
+	m.mu.Lock()
+	tracked := make(map[string]playwright.Browser, len(m.browsers))
+	for tag, browser := range m.browsers {
+		tracked[tag] = browser
+	}
+	m.mu.Unlock()
+
+	owners := e.resourceManager.browserOwners()
+	browsers := make([]BrowserMemory, 0, len(tracked))
+	for tag, browser := range tracked {
+		usage := BrowserMemory{RSS: sample.Browsers[tag]}
+		if owner, ok := owners[browser]; ok {
+			usage.JobID, usage.BrowserID = owner[0], owner[1]
+		}
+		browsers = append(browsers, usage)
+	}
+	sort.Slice(browsers, func(i, j int) bool { return browsers[i].RSS > browsers[j].RSS })
+	return browsers
+}
+
+// CLOSE THE HEAVIEST BROWSER A JOB HOLDS THAT CAN BE RELAUNCHED; A TASK THAT NEEDS IT AGAIN
+// GETS A FRESH ONE
+func (e *Engine) recycleHeaviestBrowser(browsers []BrowserMemory) {
+	for _, usage := range browsers {
+		if _, recoverable := e.resourceManager.recipe(usage.JobID, usage.BrowserID); !recoverable {
+			continue
+		}
+		resource, ok := e.resourceManager.GetResource(usage.JobID, usage.BrowserID)
+		browser, isBrowser := resource.(playwright.Browser)
+		if !ok || !isBrowser || !browser.IsConnected() {
+			continue
+		}
+		log.Printf("[JOB %s] MEMORY CRITICAL, RECYCLING BROWSER %s (%d MB)", usage.JobID, usage.BrowserID, usage.RSS>>20)
+		browser.Close()
+		e.memory.recycled.Add(1)
+		e.events.Publish("browser.recycled", usage.JobID, map[string]any{"browserId": usage.BrowserID, "rss": usage.RSS})
+		return
+	}
+}
+
+// JOB AND RESOURCE ID OF EACH BROWSER JOBS HOLD
+func (rm *ResourceManager) browserOwners() map[playwright.Browser][2]string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	owners := make(map[playwright.Browser][2]string)
+	for jobID, resources := range rm.resources {
+		for id, resource := range resources {
+			if browser, ok := resource.(playwright.Browser); ok {
+				owners[browser] = [2]string{jobID, id}
+			}
+		}
+	}
+	return owners
+}
+
+// BLOCK A PARALLEL WORKER WHILE MEMORY PRESSURE LEAVES NO ROOM FOR IT (WORKER 0 ALWAYS RUNS)
+func (e *Engine) waitForMemory(ctx context.Context, slot, size int, logger *log.Logger) error {
+	if e.memory == nil || slot < e.memory.allowed(size) {
+		return nil
+	}
+	logger.Printf("MEMORY %s, WORKER %d PAUSED", memoryLevelNames[e.memory.level.Load()], slot)
+	for slot >= e.memory.allowed(size) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(memoryCheckInterval):
+		}
+	}
+	logger.Printf("WORKER %d RESUMED", slot)
+	return nil
+}
+
+// HOLD A NEW DOWNLOAD WHILE MEMORY IS HIGH, UP TO maxDownloadDeferral
+func (e *Engine) deferDownload(ctx context.Context, logger *log.Logger) error {
+	if e.memory == nil || e.memory.level.Load() == memoryOK {
+		return nil
+	}
+	e.memory.deferred.Add(1)
+	logger.Printf("MEMORY %s, DEFERRING DOWNLOAD", memoryLevelNames[e.memory.level.Load()])
+	deadline := time.Now().Add(maxDownloadDeferral)
+	for e.memory.level.Load() != memoryOK && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(memoryCheckInterval):
+		}
+	}
+	return nil
+}
+
+// CHECK MEMORY UNTIL THE ENGINE CLOSES
+func (e *Engine) memoryLoop() {
+	e.checkMemory()
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.queueStop:
+			return
+		case <-ticker.C:
+			e.checkMemory()
+		}
+	}
+}
+
+// THE WATCHDOG'S LAST SAMPLE AND COUNTERS
+func (e *Engine) MemoryStatus() MemoryStatus {
+	e.memory.mu.Lock()
+	status := e.memory.status
+	e.memory.mu.Unlock()
+	status.RecycledBrowsers = e.memory.recycled.Load()
+	status.DeferredDownloads = e.memory.deferred.Load()
+	return status
+}
@@ -0,0 +1,121 @@
+//go:build linux
+
+package scraper
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RSS OF THIS PROCESS AND EVERYTHING IT STARTED (PLAYWRIGHT DRIVER, BROWSERS, RENDERERS), WITH
+// THE SHARE OF EACH BROWSER FOUND BY THE LAUNCH TAG IN ITS COMMAND LINE. SHARED PAGES ARE
+// COUNTED ONCE PER PROCESS, SO THIS OVERESTIMATES A LITTLE
+func sampleMemory() (memorySample, bool) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return memorySample{}, false
+	}
+	pageSize := uint64(os.Getpagesize())
+	parents := make(map[int]int)
+	rss := make(map[int]uint64)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue // EXITED WHILE SCANNING
+		}
+		// FIELDS AFTER THE PARENTHESISED NAME: state ppid ... rss IS THE 22ND
+		end := bytes.LastIndexByte(data, ')')
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(string(data[end+1:]))
+		if len(fields) < 22 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		pages, _ := strconv.ParseUint(fields[21], 10, 64)
+		parents[pid] = ppid
+		rss[pid] = pages * pageSize
+	}
+
+	children := make(map[int][]int)
+	for pid, ppid := range parents {
+		children[ppid] = append(children[ppid], pid)
+	}
+
+	sample := memorySample{Browsers: make(map[string]uint64)}
+	var walk func(pid int, tag string)
+	walk = func(pid int, tag string) {
+		sample.Total += rss[pid]
+		// A BROWSER'S OWN PROCESS CARRIES ITS TAG; ITS HELPERS ARE COUNTED UNDER IT
+		if tag == "" {
+			tag = launchTagOf(pid)
+		}
+		if tag != "" {
+			sample.Browsers[tag] += rss[pid]
+		}
+		for _, child := range children[pid] {
+			walk(child, tag)
+		}
+	}
+	walk(os.Getpid(), "")
+	return sample, true
+}
+
+// THE LAUNCH TAG IN A PROCESS'S COMMAND LINE, IF ANY
+func launchTagOf(pid int) string {
+	cmdline, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return ""
+	}
+	for _, arg := range bytes.Split(cmdline, []byte{0}) {
+		if tag, ok := strings.CutPrefix(string(arg), launchTagFlag+"="); ok {
+			return tag
+		}
+	}
+	return ""
+}
+
+// MEMORY THE PROCESS MAY USE: THE CGROUP LIMIT IN A CONTAINER, OTHERWISE PHYSICAL MEMORY
+func systemMemoryLimit() (uint64, string) {
+	total := memTotal()
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		// "max" OR A HUGE v1 VALUE MEANS NO LIMIT
+		if err == nil && limit > 0 && (total == 0 || limit < total) {
+			return limit, "cgroup"
+		}
+	}
+	if total > 0 {
+		return total, "system"
+	}
+	return 0, ""
+}
+
+// PHYSICAL MEMORY FROM /proc/meminfo
+func memTotal() uint64 {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rest, ok := strings.CutPrefix(line, "MemTotal:"); ok {
+			fields := strings.Fields(rest)
+			if len(fields) > 0 {
+				kb, _ := strconv.ParseUint(fields[0], 10, 64)
+				return kb * 1024
+			}
+		}
+	}
+	return 0
+}
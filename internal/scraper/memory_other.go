@@ -0,0 +1,12 @@
+//go:build !linux
+
+package scraper
+
+// PROCESS MEMORY IS ONLY SAMPLED ON LINUX
+func sampleMemory() (memorySample, bool) {
+	return memorySample{}, false
+}
+
+func systemMemoryLimit() (uint64, string) {
+	return 0, ""
+}
@@ -0,0 +1,114 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+)
+
+// -- NOTIFICATIONS --
+
+const notifyTimeout = 10 * time.Second
+
+// MESSAGE SENT TO THE UI AND EVERY CONFIGURED CHANNEL
+type Notification struct {
+	Type      string    `json:"type"` // E.G. record.alert
+	JobID     string    `json:"jobId,omitempty"`
+	Title     string    `json:"title"`
+	Message   string    `json:"message"`
+	Data      any       `json:"data,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DELIVERS NOTIFICATIONS OVER THE EVENT STREAM AND TO CONFIGURED WEBHOOKS
+type Notifier struct {
+	cfg    *config.Config
+	events *EventBus
+	client *http.Client
+}
+
+// NEW NOTIFIER
+func NewNotifier(cfg *config.Config, events *EventBus) *Notifier {
+	return &Notifier{cfg: cfg, events: events, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+// PUBLISH A NOTIFICATION AND POST IT TO EVERY CHANNEL THAT ACCEPTS ITS TYPE IN THE BACKGROUND
+func (n *Notifier) Notify(notification Notification) {
+	if notification.Timestamp.IsZero() {
+		notification.Timestamp = time.Now()
+	}
+	n.events.Publish("notification", notification.JobID, notification)
+	for _, channel := range n.cfg.Notifications.Webhooks {
+		if !channel.Accepts(notification.Type) {
+			continue
+		}
+		go func(channel config.WebhookChannel) {
+			if err := n.Send(context.Background(), channel, notification); err != nil {
+				log.Printf("NOTIFICATION TO %s FAILED: %v", channelName(channel), err)
+			}
+		}(channel)
+	}
+}
+
+// POST A NOTIFICATION TO ONE WEBHOOK IN ITS FORMAT
+func (n *Notifier) Send(ctx context.Context, channel config.WebhookChannel, notification Notification) error {
+	text := notification.Title
+	if notification.Message != "" {
+		text += "\n" + notification.Message
+	}
+	var payload any
+	switch channel.Format {
+	case "slack":
+		payload = map[string]string{"text": text}
+	case "discord":
+		payload = map[string]string{"content": text}
+	case "", "json":
+		payload = notification
+	default:
+		return fmt.Errorf("UNKNOWN WEBHOOK FORMAT %q", channel.Format)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", channel.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Crepes")
+	if channel.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(channel.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Crepes-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("STATUS %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CHANNEL NAME FOR LOGS; NEVER THE URL, WHICH OFTEN EMBEDS A TOKEN
+func channelName(channel config.WebhookChannel) string {
+	if channel.Name != "" {
+		return channel.Name
+	}
+	return "unnamed webhook"
+}
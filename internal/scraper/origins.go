@@ -0,0 +1,147 @@
+package scraper
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- DISCOVERING PAGES --
+//
+// HOTLINK-PROTECTED HOSTS CHECK THE REFERER, COOKIES AND USER AGENT OF ASSET REQUESTS, SO
+// DOWNLOADS BORROW THEM FROM THE PAGE THAT FOUND THE URL.
+
+// URLS REMEMBERED PER JOB; PAST THIS, DOWNLOADS FALL BACK TO ANY OPEN PAGE
+const maxOriginsPerJob = 100000
+
+// WHERE A URL WAS FOUND
+type pageOrigin struct {
+	Page    playwright.Page
+	PageURL string // THE PAGE'S ADDRESS AT THE TIME, IN CASE IT HAS NAVIGATED ON SINCE
+}
+
+// WHICH PAGE EXTRACTED EACH URL, PER JOB
+type pageOrigins struct {
+	mu    sync.Mutex
+	byJob map[string]map[string]pageOrigin
+}
+
+func newPageOrigins() *pageOrigins {
+	return &pageOrigins{byJob: make(map[string]map[string]pageOrigin)}
+}
+
+// REMEMBER THE PAGE FOR EXTRACTED ITEMS: URL STRINGS OR OBJECTS WITH A url OR src
+func (o *pageOrigins) record(jobID string, page playwright.Page, items []any) {
+	origin := pageOrigin{Page: page, PageURL: page.URL()}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	urls, ok := o.byJob[jobID]
+	if !ok {
+		urls = make(map[string]pageOrigin)
+		o.byJob[jobID] = urls
+	}
+	for _, item := range items {
+		var u string
+		switch v := item.(type) {
+		case string:
+			u = v
+		case map[string]any:
+			if u, _ = v["url"].(string); u == "" {
+				u, _ = v["src"].(string)
+			}
+		}
+		if u == "" || len(urls) >= maxOriginsPerJob {
+			continue
+		}
+		urls[u] = origin
+	}
+}
+
+// PAGE THAT EXTRACTED A URL, IF IT'S STILL OPEN
+func (o *pageOrigins) lookup(jobID, rawURL string) (pageOrigin, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	origin, ok := o.byJob[jobID][rawURL]
+	if !ok || origin.Page.IsClosed() {
+		return pageOrigin{}, false
+	}
+	return origin, true
+}
+
+func (o *pageOrigins) clear(jobID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.byJob, jobID)
+}
+
+// WHAT A DOWNLOAD BORROWS FROM ITS PAGE
+type pageIdentity struct {
+	UserAgent string
+	Referer   string
+	Jar       http.CookieJar
+}
+
+// PAGE A DOWNLOAD SHOULD ACT AS: THE TASK'S pageId, THEN THE PAGE THAT FOUND THE URL, THEN ANY OPEN PAGE
+func downloadPage(ctx *TaskContext, config map[string]any, rawURL string) (pageOrigin, bool) {
+	if pageID, ok := config["pageId"]; ok {
+		if page, err := getPage(ctx, pageID); err == nil {
+			return pageOrigin{Page: page, PageURL: page.URL()}, true
+		}
+		ctx.Logger.Printf("PAGE %v NOT FOUND, LOOKING FOR THE DISCOVERING PAGE", pageID)
+	}
+	if origin, ok := ctx.Engine.origins.lookup(ctx.JobID, rawURL); ok {
+		return origin, true
+	}
+	if page := ctx.ResourceManager.anyPage(ctx.JobID); page != nil {
+		return pageOrigin{Page: page, PageURL: page.URL()}, true
+	}
+	return pageOrigin{}, false
+}
+
+// USER AGENT, REFERER AND COOKIES OF THE PAGE A URL CAME FROM
+func identityOf(origin pageOrigin) (pageIdentity, error) {
+	var identity pageIdentity
+	page := origin.Page
+	if current, err := url.Parse(origin.PageURL); err == nil && (current.Scheme == "http" || current.Scheme == "https") {
+		current.Fragment = ""
+		identity.Referer = current.String()
+	}
+	if ua, err := page.Evaluate("() => navigator.userAgent"); err == nil {
+		identity.UserAgent, _ = ua.(string)
+	}
+
+	cookies, err := page.Context().Cookies()
+	if err != nil {
+		return identity, err
+	}
+	jar, _ := cookiejar.New(nil)
+	for _, c := range cookies {
+		cookie := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		}
+		if c.Expires > 0 {
+			cookie.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		// A LEADING DOT MEANS THE COOKIE IS SENT TO SUBDOMAINS TOO; WITHOUT IT, HOST-ONLY
+		host := strings.TrimPrefix(c.Domain, ".")
+		if strings.HasPrefix(c.Domain, ".") {
+			cookie.Domain = host
+		}
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: c.Path}, []*http.Cookie{cookie})
+	}
+	identity.Jar = jar
+	return identity, nil
+}
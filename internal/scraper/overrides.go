@@ -0,0 +1,316 @@
+package scraper
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/database"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/storage"
+	"github.com/playwright-community/playwright-go"
+	"gorm.io/gorm"
+)
+
+// -- SETTINGS OVERRIDES --
+//
+// A FEW GLOBAL SETTINGS CAN BE OVERRIDDEN PER JOB WITH THE settings RULE, AND FOR EVERY JOB IN A
+// FOLDER (AND ITS SUBFOLDERS) WITH THE FOLDER'S settings. EACH SETTING COMES FROM THE FIRST PLACE
+// THAT SETS IT: THE JOB, THEN ITS FOLDER AND THAT FOLDER'S PARENTS NEAREST FIRST, THEN THE GLOBAL
+// CONFIG. A RUN RESOLVES ITS SETTINGS ONCE WHEN IT STARTS.
+
+// JOB RULE (AND FOLDER FIELD) HOLDING A SettingsOverride
+const settingsRule = "settings"
+
+// WHERE A RESOLVED SETTING CAME FROM (FOLDERS ARE folder:<ID>)
+const (
+	SettingSourceJob    = "job"
+	SettingSourceGlobal = "global"
+)
+
+// USER AGENT STRATEGIES (ANYTHING ELSE IS SENT AS THE USER AGENT ITSELF)
+const (
+	UserAgentDefault = "default" // THE BROWSER'S OWN, AND THE POOL'S DEFAULT FOR DIRECT REQUESTS
+	UserAgentRotate  = "rotate"  // A DIFFERENT CURRENT BROWSER'S FOR EACH PAGE AND REQUEST
+)
+
+// PROXY VALUE THAT TURNS A PROXY SET FURTHER UP OFF
+const proxyDirect = "direct"
+
+// STORAGE BACKENDS ASSETS CAN BE SAVED TO
+var storageBackends = []string{storage.BackendLocal, storage.BackendS3}
+
+// MOST ASSET WORKERS A RUN MAY ASK FOR (THE SAME CAP AS maxConcurrent)
+const maxSettingsConcurrency = 64
+
+// SETTINGS A JOB OR FOLDER OVERRIDES, E.G. {"timeout": 600000, "proxy": "socks5://10.0.0.2:1080", "userAgent": "rotate"}
+// ZERO VALUES LEAVE THE SETTING TO THE NEXT LEVEL UP
+type SettingsOverride struct {
+	Timeout     int    `json:"timeout,omitempty"`     // MS A RUN MAY TAKE
+	Concurrency int    `json:"concurrency,omitempty"` // ASSET WORKERS PER RUN
+	Storage     string `json:"storage,omitempty"`     // STORAGE BACKEND ASSETS ARE SAVED TO
+	Proxy       string `json:"proxy,omitempty"`       // http, https OR socks5 URL, OR direct
+	UserAgent   string `json:"userAgent,omitempty"`   // default, rotate OR A USER AGENT
+}
+
+// PARSE A settings RULE OR FOLDER SETTINGS (NIL WHEN NOTHING IS OVERRIDDEN)
+func ParseSettingsOverride(raw any) (*SettingsOverride, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var override SettingsOverride
+	if err := decoder.Decode(&override); err != nil {
+		return nil, fmt.Errorf("SETTINGS MUST BE AN OBJECT: %v", err)
+	}
+	override.Storage = strings.ToLower(strings.TrimSpace(override.Storage))
+	override.Proxy = strings.TrimSpace(override.Proxy)
+	override.UserAgent = strings.TrimSpace(override.UserAgent)
+	if override.Timeout < 0 {
+		return nil, fmt.Errorf("timeout CAN'T BE NEGATIVE")
+	}
+	if override.Timeout > 0 && override.Timeout < 1000 {
+		return nil, fmt.Errorf("timeout MUST BE AT LEAST 1000 MS")
+	}
+	if override.Concurrency < 0 || override.Concurrency > maxSettingsConcurrency {
+		return nil, fmt.Errorf("concurrency MUST BE BETWEEN 1 AND %d", maxSettingsConcurrency)
+	}
+	if override.Storage != "" && !validStorageBackend(override.Storage) {
+		return nil, fmt.Errorf("UNKNOWN STORAGE BACKEND %q (SUPPORTED: %s)", override.Storage, strings.Join(storageBackends, ", "))
+	}
+	if err := ValidateProxy(override.Proxy); err != nil {
+		return nil, err
+	}
+	if override == (SettingsOverride{}) {
+		return nil, nil
+	}
+	return &override, nil
+}
+
+func validStorageBackend(name string) bool {
+	for _, backend := range storageBackends {
+		if backend == name {
+			return true
+		}
+	}
+	return false
+}
+
+// VALIDATE A PROXY SETTING (EMPTY OR direct MEAN NO PROXY)
+func ValidateProxy(proxy string) error {
+	if proxy == "" || proxy == proxyDirect {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("INVALID PROXY URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("PROXY MUST BE AN http, https OR socks5 URL, OR direct")
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("PROXY URL HAS NO HOST")
+	}
+	if u.Path != "" && u.Path != "/" {
+		return fmt.Errorf("PROXY URL CAN'T HAVE A PATH")
+	}
+	return nil
+}
+
+// A RUN'S EFFECTIVE SETTINGS
+type JobSettings struct {
+	Timeout     int               `json:"timeout"` // MS
+	Concurrency int               `json:"concurrency"`
+	Storage     string            `json:"storage"`
+	Proxy       string            `json:"proxy"` // EMPTY FOR NONE
+	UserAgent   string            `json:"userAgent"`
+	Sources     map[string]string `json:"sources"` // SETTING -> job, folder:<ID> OR global
+}
+
+// THE GLOBAL SETTINGS, BEFORE ANY OVERRIDE
+func globalJobSettings(cfg *config.Config) JobSettings {
+	userAgent := cfg.UserAgent
+	if userAgent == "" {
+		userAgent = UserAgentDefault
+	}
+	settings := JobSettings{
+		Timeout:     cfg.DefaultTimeout,
+		Concurrency: cfg.MaxConcurrent,
+		Storage:     cmp.Or(strings.ToLower(strings.TrimSpace(cfg.Storage.Backend)), storage.BackendLocal),
+		Proxy:       cfg.Proxy,
+		UserAgent:   userAgent,
+		Sources:     make(map[string]string),
+	}
+	for _, key := range []string{"timeout", "concurrency", "storage", "proxy", "userAgent"} {
+		settings.Sources[key] = SettingSourceGlobal
+	}
+	return settings
+}
+
+// APPLY THE SETTINGS AN OVERRIDE SETS THAT A CLOSER LEVEL HASN'T
+func (s *JobSettings) apply(override *SettingsOverride, source string, taken map[string]bool) {
+	if override == nil {
+		return
+	}
+	set := func(key string, ok bool, assign func()) {
+		if ok && !taken[key] {
+			assign()
+			taken[key] = true
+			s.Sources[key] = source
+		}
+	}
+	set("timeout", override.Timeout > 0, func() { s.Timeout = override.Timeout })
+	set("concurrency", override.Concurrency > 0, func() { s.Concurrency = override.Concurrency })
+	set("storage", override.Storage != "", func() { s.Storage = override.Storage })
+	set("proxy", override.Proxy != "", func() { s.Proxy = override.Proxy })
+	set("userAgent", override.UserAgent != "", func() { s.UserAgent = override.UserAgent })
+}
+
+// RESOLVE A JOB'S SETTINGS: JOB, THEN FOLDERS NEAREST FIRST, THEN GLOBAL. INVALID OVERRIDES ARE
+// SKIPPED AND REPORTED IN THE ERROR ALONGSIDE THE SETTINGS THAT COULD BE RESOLVED
+func ResolveJobSettings(db *gorm.DB, cfg *config.Config, job *models.Job) (JobSettings, error) {
+	settings := globalJobSettings(cfg)
+	taken := make(map[string]bool)
+	var errs []error
+
+	override, err := ParseSettingsOverride(job.Rules[settingsRule])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("JOB SETTINGS: %v", err))
+	}
+	settings.apply(override, SettingSourceJob, taken)
+
+	if job.FolderID != "" && db != nil {
+		folders, err := database.FolderAncestors(db, job.FolderID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("COULD NOT LOAD FOLDERS: %v", err))
+		}
+		for _, folder := range folders {
+			override, err := ParseSettingsOverride(folder.Settings)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("FOLDER %s SETTINGS: %v", folder.ID, err))
+				continue
+			}
+			settings.apply(override, "folder:"+folder.ID, taken)
+		}
+	}
+	return settings, errors.Join(errs...)
+}
+
+// THE SETTINGS WITH PROXY CREDENTIALS MASKED, FOR THE API
+func (s JobSettings) Redacted() JobSettings {
+	if u, err := url.Parse(s.Proxy); err == nil && u.User != nil {
+		s.Proxy = u.Redacted()
+	}
+	return s
+}
+
+// THE PROXY REQUESTS GO THROUGH (NIL FOR NONE)
+func (s *JobSettings) proxyURL() *url.URL {
+	if s == nil || s.Proxy == "" || s.Proxy == proxyDirect {
+		return nil
+	}
+	u, err := url.Parse(s.Proxy)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// THE PROXY AS A BROWSER LAUNCH OPTION (NIL FOR NONE). CHROMIUM TAKES THE CREDENTIALS SEPARATELY
+func (s *JobSettings) browserProxy() *playwright.Proxy {
+	u := s.proxyURL()
+	if u == nil {
+		return nil
+	}
+	proxy := &playwright.Proxy{Server: u.Scheme + "://" + u.Host}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		proxy.Username = playwright.String(u.User.Username())
+		proxy.Password = playwright.String(password)
+	}
+	return proxy
+}
+
+// THE USER AGENT FOR A NEW PAGE OR REQUEST (EMPTY TO KEEP THE DEFAULT)
+func (s *JobSettings) pickUserAgent() string {
+	if s == nil {
+		return ""
+	}
+	switch s.UserAgent {
+	case "", UserAgentDefault:
+		return ""
+	case UserAgentRotate:
+		return rotatingUserAgent()
+	default:
+		return s.UserAgent
+	}
+}
+
+type settingsKey struct{}
+
+// THE SETTINGS OF THE RUN A CONTEXT BELONGS TO, IF ANY
+func settingsFrom(ctx context.Context) *JobSettings {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(settingsKey{}).(*JobSettings)
+	return s
+}
+
+// THE RUNNING JOB'S SETTINGS (THE GLOBAL ONES WHEN IT ISN'T RUNNING)
+func (e *Engine) runSettings(jobID string) *JobSettings {
+	e.mu.Lock()
+	s, ok := e.jobSettings[jobID]
+	e.mu.Unlock()
+	if !ok {
+		global := globalJobSettings(e.cfg)
+		return &global
+	}
+	return s
+}
+
+// PROXY FOR A DIRECT REQUEST: THE RUN'S WHEN IT SETS ONE, OTHERWISE THE ENVIRONMENT'S
+func settingsProxy(req *http.Request) (*url.URL, error) {
+	if s := settingsFrom(req.Context()); s != nil && s.Proxy != "" {
+		return s.proxyURL(), nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// WHETHER A REQUEST GOES THROUGH ITS RUN'S PROXY
+func usesRunProxy(req *http.Request) bool {
+	return settingsFrom(req.Context()).proxyURL() != nil
+}
+
+// SWAPS THE DEFAULT USER AGENT FOR THE ONE THE RUN'S STRATEGY PICKS. USER AGENTS A TASK SET
+// ITSELF (OR COPIED FROM ITS BROWSER) ARE LEFT ALONE
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != DefaultUserAgent() {
+		return t.base.RoundTrip(req)
+	}
+	userAgent := settingsFrom(req.Context()).pickUserAgent()
+	if userAgent == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+	return t.base.RoundTrip(req)
+}
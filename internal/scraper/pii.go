@@ -0,0 +1,272 @@
+package scraper
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// -- PII SCRUBBING --
+//
+// A JOB'S pii RULE RUNS EVERY EXTRACTED RECORD THROUGH A SCRUBBER BEFORE IT IS VALIDATED, KEYED
+// AND STORED. IT FINDS EMAIL ADDRESSES, PHONE NUMBERS, NATIONAL ID NUMBERS AND ANY CUSTOM
+// PATTERNS IN STRING VALUES (NESTED ONES TOO) AND, PER THE RULE'S action, REPLACES THEM WITH A
+// PLACEHOLDER (redact), WITH A KEYED HASH THAT STAYS THE SAME ACROSS RUNS SO KEYS AND DEDUPING
+// STILL WORK (hash), OR LEAVES THEM AND LISTS WHAT WAS FOUND ON THE RECORD (flag).
+
+// JOB RULE WITH THE SCRUBBER POLICY
+const piiRule = "pii"
+
+// BUILT-IN DETECTORS, IN THE ORDER THEY RUN (NATIONAL IDS FIRST SO THEY AREN'T TAKEN FOR PHONE NUMBERS)
+var piiDetectors = []struct {
+	name    string
+	pattern *regexp.Regexp
+	valid   func(string) bool
+}{
+	{"nationalId", regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), validSSN},
+	{"nationalId", regexp.MustCompile(`\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`), nil}, // UK NATIONAL INSURANCE
+	{"nationalId", regexp.MustCompile(`\b\d{3}[ -]\d{3}[ -]\d{3}\b`), luhnValid},                                   // CANADIAN SIN
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`), nil},
+	{"phone", regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?)?(?:\(\d{1,4}\)[ .\-]?)?\d{2,4}(?:[ .\-]?\d{2,4}){2,4}`), validPhone},
+}
+
+// BUILT-IN TYPE NAMES
+var piiTypes = []string{"email", "phone", "nationalId"}
+
+// PII RULE, E.G. {"action": "hash", "types": ["email", "phone"], "fields": ["contact", "bio"],
+// "patterns": {"memberId": "M-\\d{8}"}}. types DEFAULTS TO EVERY BUILT-IN TYPE AND fields TO
+// EVERY FIELD OF THE RECORD
+type PIIPolicy struct {
+	Action   string            `json:"action"` // redact, hash OR flag
+	Types    []string          `json:"types"`
+	Fields   []string          `json:"fields"`
+	Patterns map[string]string `json:"patterns"` // EXTRA TYPES: NAME TO REGULAR EXPRESSION
+
+	custom []customPII
+	key    []byte
+}
+
+type customPII struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// ONE KIND OF PII FOUND IN A FIELD
+type PIIFinding struct {
+	Field string `json:"field"`
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// PARSE A pii RULE (NIL WHEN UNSET)
+func ParsePIIPolicy(raw any) (*PIIPolicy, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var policy PIIPolicy
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("PII RULE MUST BE AN OBJECT: %v", err)
+	}
+	switch policy.Action {
+	case "redact", "hash", "flag":
+	case "":
+		return nil, fmt.Errorf("PII RULE NEEDS AN action (redact, hash OR flag)")
+	default:
+		return nil, fmt.Errorf("UNKNOWN PII ACTION %q (EXPECTED redact, hash OR flag)", policy.Action)
+	}
+	for _, t := range policy.Types {
+		if !slices.Contains(piiTypes, t) && policy.Patterns[t] == "" {
+			return nil, fmt.Errorf("UNKNOWN PII TYPE %q (EXPECTED %s OR A NAME FROM patterns)", t, strings.Join(piiTypes, ", "))
+		}
+	}
+	names := make([]string, 0, len(policy.Patterns))
+	for name := range policy.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if slices.Contains(piiTypes, name) {
+			return nil, fmt.Errorf("PII PATTERN %q SHADOWS A BUILT-IN TYPE", name)
+		}
+		pattern, err := regexp.Compile(policy.Patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("INVALID PII PATTERN %q: %v", name, err)
+		}
+		policy.custom = append(policy.custom, customPII{name: name, pattern: pattern})
+	}
+	return &policy, nil
+}
+
+// A JOB'S SCRUBBER FROM ITS pii RULE (NIL WHEN IT HAS NONE)
+func NewPIIScrubber(cfg *config.Config, jobID string, raw any) (*PIIPolicy, error) {
+	policy, err := ParsePIIPolicy(raw)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	// HASHES ARE KEYED SO SHORT VALUES LIKE PHONE NUMBERS CAN'T BE REVERSED BY GUESSING
+	policy.key = []byte(cfg.EncryptionSecret + "\x00" + jobID)
+	return policy, nil
+}
+
+// THE RUNNING JOB'S SCRUBBER (NIL WHEN IT HAS NONE)
+func (e *Engine) piiPolicy(jobID string) (*PIIPolicy, error) {
+	raw, _ := e.jobRule(jobID, piiRule)
+	return NewPIIScrubber(e.cfg, jobID, raw)
+}
+
+// WHETHER A TYPE IS SCRUBBED
+func (p *PIIPolicy) wants(name string) bool {
+	if len(p.Types) == 0 {
+		return slices.Contains(piiTypes, name) || p.Patterns[name] != ""
+	}
+	return slices.Contains(p.Types, name)
+}
+
+// SCRUB A RECORD'S DATA IN PLACE, RETURNING WHAT WAS FOUND, BY FIELD AND TYPE
+func (p *PIIPolicy) Scrub(data map[string]any) []PIIFinding {
+	var findings []PIIFinding
+	fields := make([]string, 0, len(data))
+	for field := range data {
+		if len(p.Fields) == 0 || slices.Contains(p.Fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		counts := make(map[string]int)
+		scrubbed := p.scrubValue(data[field], counts)
+		if p.Action != "flag" {
+			data[field] = scrubbed
+		}
+		types := make([]string, 0, len(counts))
+		for t := range counts {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			findings = append(findings, PIIFinding{Field: field, Type: t, Count: counts[t]})
+		}
+	}
+	return findings
+}
+
+// SCRUB STRINGS INSIDE A VALUE, RETURNING THE SCRUBBED COPY
+func (p *PIIPolicy) scrubValue(value any, counts map[string]int) any {
+	switch v := value.(type) {
+	case string:
+		return p.scrubString(v, counts)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = p.scrubValue(item, counts)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = p.scrubValue(item, counts)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// REPLACE EVERY MATCH IN A STRING. MATCHES ARE REPLACED EVEN IN flag MODE SO LATER DETECTORS
+// DON'T COUNT THEM AGAIN; THE CALLER KEEPS THE ORIGINAL
+func (p *PIIPolicy) scrubString(s string, counts map[string]int) string {
+	replace := func(name string, pattern *regexp.Regexp, valid func(string) bool) {
+		if !p.wants(name) {
+			return
+		}
+		s = pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if valid != nil && !valid(match) {
+				return match
+			}
+			counts[name]++
+			return p.replacement(name, match)
+		})
+	}
+	for _, detector := range piiDetectors {
+		replace(detector.name, detector.pattern, detector.valid)
+	}
+	for _, c := range p.custom {
+		replace(c.name, c.pattern, nil)
+	}
+	return s
+}
+
+// WHAT A MATCH BECOMES
+func (p *PIIPolicy) replacement(name, match string) string {
+	if p.Action != "hash" {
+		return "[redacted:" + name + "]"
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(strings.ToLower(match)))
+	return "[" + name + ":" + hex.EncodeToString(mac.Sum(nil))[:16] + "]"
+}
+
+// US SOCIAL SECURITY NUMBERS NEVER START WITH 000, 666 OR 9, NOR HAVE A ZERO GROUP
+func validSSN(match string) bool {
+	parts := strings.Split(match, "-")
+	return parts[0] != "000" && parts[0] != "666" && parts[0][0] != '9' && parts[1] != "00" && parts[2] != "0000"
+}
+
+// PHONE NUMBERS HAVE 9 TO 15 DIGITS (E.164); SHORTER RUNS ARE USUALLY PRICES, DATES OR IDS
+func validPhone(match string) bool {
+	digits := 0
+	for _, r := range match {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15
+}
+
+// LUHN CHECKSUM OVER THE DIGITS OF A MATCH
+func luhnValid(match string) bool {
+	sum, double := 0, false
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// FINDINGS AS STORED ON A RECORD (NIL WHEN THERE ARE NONE)
+func PIIColumn(findings []PIIFinding) models.JSONArray {
+	if len(findings) == 0 {
+		return nil
+	}
+	out := make(models.JSONArray, len(findings))
+	for i, f := range findings {
+		out[i] = map[string]any{"field": f.Field, "type": f.Type, "count": f.Count}
+	}
+	return out
+}
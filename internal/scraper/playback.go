@@ -0,0 +1,187 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- PLAYBACK --
+//
+// A RUN STARTED WITH RunOptions.Replay DOESN'T TOUCH THE NETWORK: EVERY REQUEST ITS HTTP CLIENT
+// AND ITS BROWSER PAGES MAKE IS ANSWERED BY THE REPLAY TRANSPORT INSTEAD, E.G. A FIXTURE SITE OF
+// RECORDED RESPONSES (SEE internal/fixtures). BUDGETS, THROTTLES, SESSIONS AND HEADERS STILL APPLY,
+// SO THE RUN BEHAVES AS IT WOULD AGAINST THE REAL SITE.
+//
+// A REPLAY TRANSPORT MAY ANSWER ErrPlaybackMiss TO SEND A REQUEST TO THE NETWORK AFTER ALL; IF IT
+// ALSO IMPLEMENTS playbackRecorder IT IS HANDED THE LIVE RESPONSE (SEE cassette.go).
+
+// RETURNED BY A REPLAY TRANSPORT FOR A REQUEST THE NETWORK SHOULD ANSWER
+var ErrPlaybackMiss = errors.New("NO RECORDED RESPONSE")
+
+// LARGEST LIVE RESPONSE BODY HANDED TO A RECORDER; BIGGER ONES (E.G. VIDEOS) PASS THROUGH UNRECORDED
+const maxRecordedBody = 32 << 20
+
+// A REPLAY TRANSPORT THAT KEEPS THE LIVE RESPONSES OF ITS MISSES
+type playbackRecorder interface {
+	Record(method, rawURL string, status int, header http.Header, body []byte)
+}
+
+type playbackKey struct{}
+
+// THE REPLAY TRANSPORT OF THE RUN A CONTEXT BELONGS TO, IF IT HAS ONE
+func playbackFrom(ctx context.Context) http.RoundTripper {
+	if ctx == nil {
+		return nil
+	}
+	rt, _ := ctx.Value(playbackKey{}).(http.RoundTripper)
+	return rt
+}
+
+// THE RUNNING JOB'S REPLAY TRANSPORT (NIL FOR A LIVE RUN)
+func (e *Engine) jobPlayback(jobID string) http.RoundTripper {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.playbacks[jobID]
+}
+
+// ANSWER A RUN'S REQUESTS FROM ITS REPLAY TRANSPORT; THE RETURNED CONTEXT CARRIES IT
+func (e *Engine) startPlayback(ctx context.Context, jobID string, rt http.RoundTripper) context.Context {
+	e.mu.Lock()
+	e.playbacks[jobID] = rt
+	e.mu.Unlock()
+	return context.WithValue(ctx, playbackKey{}, rt)
+}
+
+// SEND A REPLAYING RUN'S BROWSER REQUESTS TO ITS REPLAY TRANSPORT. REGISTERED AFTER THE SSRF
+// GUARD SO IT RUNS FIRST: A REPLAYED REQUEST ISN'T FETCHED, SO THERE IS NOTHING TO GUARD
+func (e *Engine) routePlayback(jobID string, browserContext playwright.BrowserContext) error {
+	rt := e.jobPlayback(jobID)
+	if rt == nil {
+		return nil
+	}
+	return browserContext.Route("**/*", func(route playwright.Route) {
+		request := route.Request()
+		var body io.Reader
+		if data, err := request.PostDataBuffer(); err == nil && len(data) > 0 {
+			body = bytes.NewReader(data)
+		}
+		req, err := http.NewRequest(request.Method(), request.URL(), body)
+		if err != nil {
+			route.Abort("failed")
+			return
+		}
+		if headers, err := request.AllHeaders(); err == nil {
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+		}
+		resp, err := rt.RoundTrip(req)
+		if errors.Is(err, ErrPlaybackMiss) {
+			e.fetchLive(jobID, route, rt)
+			return
+		}
+		if err != nil {
+			log.Printf("[JOB %s] NO REPLAY FOR %s %s: %v", jobID, request.Method(), request.URL(), err)
+			route.Abort("failed")
+			return
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			route.Abort("failed")
+			return
+		}
+		headers := make(map[string]string, len(resp.Header))
+		for name := range resp.Header {
+			headers[name] = resp.Header.Get(name)
+		}
+		route.Fulfill(playwright.RouteFulfillOptions{
+			Status:  playwright.Int(resp.StatusCode),
+			Headers: headers,
+			Body:    data,
+		})
+	})
+}
+
+// LET A BROWSER REQUEST THE REPLAY TRANSPORT PASSED ON THROUGH TO THE NETWORK, HANDING THE
+// RESPONSE TO ITS RECORDER. THIS HANDLER RUNS INSTEAD OF THE SSRF GUARD'S, SO IT CHECKS FIRST
+func (e *Engine) fetchLive(jobID string, route playwright.Route, rt http.RoundTripper) {
+	request := route.Request()
+	if err := e.guard.checkURL(context.Background(), request.URL(), e.dnsPolicy(jobID)); err != nil {
+		route.Abort("blockedbyclient")
+		return
+	}
+	fetched, err := route.Fetch()
+	if err != nil {
+		route.Abort("failed")
+		return
+	}
+	if recorder, ok := rt.(playbackRecorder); ok {
+		if body, err := fetched.Body(); err == nil && len(body) <= maxRecordedBody {
+			header := http.Header{}
+			for _, h := range fetched.HeadersArray() {
+				header.Add(h.Name, h.Value)
+			}
+			recorder.Record(request.Method(), request.URL(), fetched.Status(), header, body)
+		}
+	}
+	route.Fulfill(playwright.RouteFulfillOptions{Response: fetched})
+}
+
+// SITS IN FRONT OF THE SHARED TRANSPORT, SO A REPLAYING RUN'S REQUESTS STOP HERE
+type playbackTransport struct {
+	base http.RoundTripper
+}
+
+func (t *playbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := playbackFrom(req.Context())
+	if rt == nil {
+		return t.base.RoundTrip(req)
+	}
+	resp, err := rt.RoundTrip(req)
+	if !errors.Is(err, ErrPlaybackMiss) {
+		return resp, err
+	}
+	resp, err = t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if recorder, ok := rt.(playbackRecorder); ok && resp.ContentLength <= maxRecordedBody {
+		resp.Body = &recordingBody{ReadCloser: resp.Body, resp: resp, recorder: recorder}
+	}
+	return resp, nil
+}
+
+// HANDS A LIVE RESPONSE TO ITS RECORDER ONCE IT HAS BEEN READ TO THE END
+type recordingBody struct {
+	io.ReadCloser
+	resp     *http.Response
+	recorder playbackRecorder
+	buf      bytes.Buffer
+	overflow bool
+	done     bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if b.buf.Len()+n > maxRecordedBody {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.done && !b.overflow {
+		b.done = true
+		req := b.resp.Request
+		b.recorder.Record(req.Method, req.URL.String(), b.resp.StatusCode, b.resp.Header.Clone(), b.buf.Bytes())
+	}
+	return n, err
+}
@@ -0,0 +1,354 @@
+package scraper
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// -- SITE PRESETS --
+//
+// A SITE PRESET IS WHAT WE KNOW ABOUT A KIND OF SITE (WORDPRESS GALLERIES, PHPBB FORUMS, SHOPIFY
+// STORES): WHICH SELECTORS FIND ITS IMAGES, LINKS AND LISTINGS, HOW ITS LISTING PAGES ARE NUMBERED,
+// HOW HARD IT CAN BE ASKED AND WHICH HEADERS IT WANTS. PICKING ONE AT JOB CREATION TURNS IT INTO A
+// JOB DRAFT FOR A GIVEN URL (A BROWSER PIPELINE WALKING THE LISTING PAGES, PLUS throttle AND
+// headers RULES) TO EDIT AND SAVE. THE CURATED PRESETS SHIP IN presets/; A .json FILE IN THE DATA
+// DIRECTORY'S presets FOLDER WITH THE SAME id REPLACES ONE, AND ANY OTHER id ADDS ONE, SO THE SET
+// CAN BE UPDATED WITHOUT A NEW BUILD.
+
+//go:embed presets/*.json
+var builtinPresets embed.FS
+
+// WHERE A PRESET CAME FROM
+const (
+	PresetBuiltin = "builtin"
+	PresetCustom  = "custom"
+)
+
+// LISTING PAGES A PRESET JOB WALKS WHEN NEITHER THE PRESET NOR THE CALLER SAYS
+const defaultPresetPages = 5
+
+var presetIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+type SitePreset struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	TargetType  string            `json:"targetType"` // gallery, forum, store, ...
+	Version     int               `json:"version"`
+	Source      string            `json:"source"` // builtin OR custom, SET WHEN LOADED
+	Selectors   PresetSelectors   `json:"selectors"`
+	Pagination  PresetPagination  `json:"pagination"`
+	RateLimit   PresetRateLimit   `json:"rateLimit"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	RecordKey   string            `json:"recordKey,omitempty"` // FIELD IDENTIFYING A RECORD ACROSS RUNS
+	Tags        []string          `json:"tags,omitempty"`
+}
+
+// WHAT TO PULL FROM EACH LISTING PAGE; AT LEAST ONE OF images, links OR records
+type PresetSelectors struct {
+	Images   string         `json:"images,omitempty"`
+	MinWidth int            `json:"minWidth,omitempty"` // SKIP ICONS AND AVATARS
+	Links    string         `json:"links,omitempty"`    // DETAIL PAGES (POSTS, TOPICS, PRODUCTS)
+	Records  string         `json:"records,omitempty"`  // ONE SAVED RECORD PER MATCH...
+	Fields   map[string]any `json:"fields,omitempty"`   // ...WITH THESE FIELDS (AS IN extractRecords)
+}
+
+// HOW LISTING PAGES ARE NUMBERED. pattern IS FILLED WITH {{ url }} (THE JOB'S URL WITHOUT A
+// TRAILING SLASH) WHEN THE JOB IS MADE, AND WITH {{ page }} (FROM 1) AND {{ offset }} (ITEMS
+// BEFORE THE PAGE, perPage EACH) WHEN IT RUNS
+type PresetPagination struct {
+	Pattern  string `json:"pattern"`
+	PerPage  int    `json:"perPage,omitempty"`
+	MaxPages int    `json:"maxPages,omitempty"`
+	Next     string `json:"next,omitempty"` // THE NEXT-PAGE LINK, FOR WHOEVER EDITS THE PIPELINE
+}
+
+// HOW HARD TO ASK: THE throttle RULE'S DELAYS (MS) AND LISTING PAGES LOADED AT ONCE
+type PresetRateLimit struct {
+	MinDelay    int `json:"minDelay"`
+	MaxDelay    int `json:"maxDelay,omitempty"`
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// WHAT A PRESET JOB IS MADE FOR
+type PresetJobOptions struct {
+	URL      string
+	Name     string
+	MaxPages int
+}
+
+// EVERY PRESET BY NAME: THE BUILT-IN ONES WITH THOSE IN dataPath/presets LAID OVER THEM. A CUSTOM
+// FILE THAT DOESN'T PARSE OR CHECK OUT IS LOGGED AND SKIPPED
+func LoadSitePresets(dataPath string) []SitePreset {
+	presets := make(map[string]SitePreset)
+	builtin, _ := builtinPresets.ReadDir("presets")
+	for _, entry := range builtin {
+		data, err := builtinPresets.ReadFile("presets/" + entry.Name())
+		if err != nil {
+			continue
+		}
+		preset, err := ParseSitePreset(data)
+		if err != nil {
+			log.Printf("BUILT-IN PRESET %s IS INVALID: %v", entry.Name(), err)
+			continue
+		}
+		preset.Source = PresetBuiltin
+		presets[preset.ID] = preset
+	}
+
+	if dataPath != "" {
+		files, _ := filepath.Glob(filepath.Join(dataPath, "presets", "*.json"))
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				log.Printf("SKIPPING PRESET %s: %v", file, err)
+				continue
+			}
+			preset, err := ParseSitePreset(data)
+			if err != nil {
+				log.Printf("SKIPPING PRESET %s: %v", file, err)
+				continue
+			}
+			preset.Source = PresetCustom
+			presets[preset.ID] = preset
+		}
+	}
+
+	list := make([]SitePreset, 0, len(presets))
+	for _, preset := range presets {
+		list = append(list, preset)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// FIND ONE PRESET
+func FindSitePreset(dataPath, id string) (SitePreset, bool) {
+	for _, preset := range LoadSitePresets(dataPath) {
+		if preset.ID == id {
+			return preset, true
+		}
+	}
+	return SitePreset{}, false
+}
+
+// PARSE AND CHECK A PRESET FILE
+func ParseSitePreset(data []byte) (SitePreset, error) {
+	var preset SitePreset
+	if err := json.Unmarshal(data, &preset); err != nil {
+		return preset, fmt.Errorf("NOT A PRESET: %v", err)
+	}
+	if !presetIDPattern.MatchString(preset.ID) {
+		return preset, fmt.Errorf("PRESET ID %q MUST BE LOWERCASE LETTERS, DIGITS AND DASHES", preset.ID)
+	}
+	if strings.TrimSpace(preset.Name) == "" {
+		return preset, fmt.Errorf("PRESET %s HAS NO NAME", preset.ID)
+	}
+	s := preset.Selectors
+	if s.Images == "" && s.Links == "" && s.Records == "" {
+		return preset, fmt.Errorf("PRESET %s SELECTS NOTHING: SET images, links OR records", preset.ID)
+	}
+	for _, selector := range []string{s.Images, s.Links, s.Records} {
+		if selector == "" {
+			continue
+		}
+		if err := validateSelector(selector, ""); err != nil {
+			return preset, fmt.Errorf("PRESET %s: %w", preset.ID, err)
+		}
+	}
+	if s.Records != "" {
+		probe := map[string]any{"pageId": "", "selector": s.Records, "fields": map[string]any(s.Fields)}
+		if err := (&ExtractRecordsTask{}).ValidateConfig(probe); err != nil {
+			return preset, fmt.Errorf("PRESET %s RECORDS: %w", preset.ID, err)
+		}
+	}
+	p := preset.Pagination
+	if !strings.Contains(p.Pattern, "{{ url }}") || !(strings.Contains(p.Pattern, "{{ page }}") || strings.Contains(p.Pattern, "{{ offset }}")) {
+		return preset, fmt.Errorf("PRESET %s PAGINATION PATTERN NEEDS {{ url }} AND {{ page }} OR {{ offset }}", preset.ID)
+	}
+	if strings.Contains(p.Pattern, "{{ offset }}") && p.PerPage <= 0 {
+		return preset, fmt.Errorf("PRESET %s NEEDS perPage FOR {{ offset }}", preset.ID)
+	}
+	if p.MaxPages < 0 || preset.RateLimit.Concurrency < 0 {
+		return preset, fmt.Errorf("PRESET %s maxPages AND concurrency CAN'T BE NEGATIVE", preset.ID)
+	}
+	if _, err := ParseThrottleRule(preset.throttleRule()); err != nil {
+		return preset, fmt.Errorf("PRESET %s RATE LIMIT: %w", preset.ID, err)
+	}
+	return preset, nil
+}
+
+func (p SitePreset) throttleRule() map[string]any {
+	rule := map[string]any{"minDelay": p.RateLimit.MinDelay}
+	if p.RateLimit.MaxDelay > 0 {
+		rule["maxDelay"] = p.RateLimit.MaxDelay
+	}
+	return rule
+}
+
+// A JOB DRAFT (NOT SAVED, NO ID) FOR A SITE OF THE PRESET'S KIND. THE PIPELINE OPENS A BROWSER,
+// FILLS THE PAGINATION PATTERN FOR EACH LISTING PAGE, THEN LOADS THE PAGES (UP TO concurrency AT
+// ONCE) AND PULLS WHAT THE SELECTORS FIND FROM EACH
+func BuildPresetJob(preset SitePreset, options PresetJobOptions) (models.Job, error) {
+	target, err := url.Parse(strings.TrimSpace(options.URL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return models.Job{}, fmt.Errorf("%w: url MUST BE AN http(s) URL", ErrInvalidInput)
+	}
+	base := strings.TrimSuffix(target.String(), "/")
+	pages := options.MaxPages
+	if pages <= 0 {
+		pages = preset.Pagination.MaxPages
+	}
+	if pages <= 0 {
+		pages = defaultPresetPages
+	}
+	workers := max(preset.RateLimit.Concurrency, 1)
+
+	numbers := make([]any, pages)
+	for i := range numbers {
+		numbers[i] = map[string]any{"page": i + 1, "offset": i * preset.Pagination.PerPage}
+	}
+
+	browser := presetTask("Open Browser", "createBrowser", map[string]any{"headless": true})
+	page := presetTask("Open Page", "createPage", map[string]any{"browserId": browser.ID})
+	listing := presetTask("Listing Pages", "templateText", map[string]any{
+		"template": strings.ReplaceAll(preset.Pagination.Pattern, "{{ url }}", base),
+		"data":     numbers,
+		"strict":   true,
+	})
+	listing.Description = "Fills the " + preset.Name + " pagination pattern for each listing page"
+
+	navigate := presetTask("Load Listing Page", "navigate", map[string]any{"pageId": page.ID, "waitUntil": "domcontentloaded"})
+	navigate.InputRefs = []string{listing.ID}
+	navigate.RetryConfig = models.RetryConfig{MaxRetries: 2, DelayMS: max(preset.RateLimit.MinDelay, 1000)}
+	perPage := []models.Task{navigate}
+	s := preset.Selectors
+	if s.Records != "" {
+		perPage = append(perPage, presetTask("Extract Listings", "extractRecords", map[string]any{
+			"pageId":   page.ID,
+			"selector": s.Records,
+			"fields":   s.Fields,
+			"save":     true,
+		}))
+	}
+	if s.Images != "" {
+		config := map[string]any{"pageId": page.ID, "selector": s.Images, "normalizeUrls": true, "includeMetadata": true}
+		if s.MinWidth > 0 {
+			config["minWidth"] = s.MinWidth
+		}
+		perPage = append(perPage, presetTask("Extract Images", "extractImages", config))
+	}
+	if s.Links != "" {
+		perPage = append(perPage, presetTask("Extract Links", "extractLinks", map[string]any{
+			"pageId":        page.ID,
+			"selector":      s.Links,
+			"normalizeUrls": true,
+			"includeText":   true,
+		}))
+	}
+
+	stages := []models.Stage{
+		{
+			ID:          utils.GenerateID("stage"),
+			Name:        "Setup",
+			Description: "Open a browser and work out the listing page URLs",
+			Condition:   models.Condition{Type: "always"},
+			Parallelism: models.ParallelismConfig{Mode: "sequential", MaxWorkers: 1},
+			Tasks:       []models.Task{browser, page, listing},
+		},
+		{
+			ID:          utils.GenerateID("stage"),
+			Name:        "Walk Listing Pages",
+			Description: "Load each listing page and pull what the " + preset.Name + " selectors find",
+			Condition:   models.Condition{Type: "always"},
+			Parallelism: models.ParallelismConfig{Mode: "for-each", MaxWorkers: workers},
+			Tasks:       perPage,
+			Config:      map[string]any{"items": listing.ID},
+		},
+		{
+			ID:          utils.GenerateID("stage"),
+			Name:        "Cleanup",
+			Description: "Close the browser",
+			Condition:   models.Condition{Type: "always"},
+			Parallelism: models.ParallelismConfig{Mode: "sequential", MaxWorkers: 1},
+			Tasks:       []models.Task{presetTask("Close Browser", "disposeBrowser", map[string]any{"browserId": browser.ID})},
+		},
+	}
+	pipeline, err := json.Marshal(stages)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	rules := models.JSONMap{
+		throttleRule: preset.throttleRule(),
+	}
+	if len(preset.Headers) > 0 {
+		headers := make(map[string]any, len(preset.Headers))
+		for key, value := range preset.Headers {
+			headers[key] = value
+		}
+		rules[headersRule] = headers
+	}
+	if preset.RecordKey != "" && s.Records != "" {
+		rules[recordKeyRule] = preset.RecordKey
+	}
+	tags := models.JSONArray{"preset:" + preset.ID}
+	for _, tag := range preset.Tags {
+		tags = append(tags, tag)
+	}
+	name := options.Name
+	if name == "" {
+		name = preset.Name + ": " + target.Host
+	}
+
+	// ROUND-TRIP THROUGH JSON SO THE DRAFT MATCHES WHAT A SAVED JOB LOADS AS
+	job := models.Job{
+		Name:        name,
+		BaseURL:     target.String(),
+		Description: preset.Description,
+		Status:      "idle",
+		Selectors:   models.JSONArray{},
+		Filters:     models.JSONArray{},
+		Rules:       rules,
+		Processing:  models.JSONMap{"thumbnails": true},
+		Tags:        tags,
+		Pipeline:    string(pipeline),
+	}
+	raw, _ := json.Marshal(job)
+	var draft models.Job
+	json.Unmarshal(raw, &draft)
+	return draft, nil
+}
+
+func presetTask(name, taskType string, config map[string]any) models.Task {
+	return models.Task{
+		ID:        utils.GenerateID("task"),
+		Name:      name,
+		Type:      taskType,
+		Config:    config,
+		InputRefs: []string{},
+		Condition: models.Condition{Type: "always"},
+	}
+}
+
+func presetStage(name, description, mode string, workers int, tasks []models.Task) models.Stage {
+	return models.Stage{
+		ID:          utils.GenerateID("stage"),
+		Name:        name,
+		Description: description,
+		Condition:   models.Condition{Type: "always"},
+		Parallelism: models.ParallelismConfig{Mode: mode, MaxWorkers: workers},
+		Tasks:       tasks,
+	}
+}
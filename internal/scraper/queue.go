@@ -0,0 +1,406 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/playwright-community/playwright-go"
+)
+
+// WHY A RUN IS WAITING
+const (
+	WaitBlackout         = "blackoutWindow"
+	WaitGlobalLimit      = "globalLimit"
+	WaitConcurrencyGroup = "concurrencyGroup"
+)
+
+// QUEUE PRIORITIES, LOWEST FIRST. A QUEUED RUN STARTS AHEAD OF EVERY LOWER-PRIORITY RUN AND
+// BEHIND THE RUNS OF ITS OWN PRIORITY QUEUED BEFORE IT
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+var QueuePriorities = []string{PriorityLow, PriorityNormal, PriorityHigh}
+
+// THE JOB ISN'T WAITING IN THE QUEUE
+var ErrJobNotQueued = errors.New("JOB IS NOT QUEUED")
+
+// A RUN WAITING TO START
+type QueuedRun struct {
+	JobID          string     `json:"jobId"`
+	Name           string     `json:"name"`
+	Group          string     `json:"group,omitempty"`
+	Priority       string     `json:"priority"` // low, normal OR high
+	QueuedAt       time.Time  `json:"queuedAt"`
+	Position       int        `json:"position"`
+	Reason         string     `json:"reason"` // blackoutWindow, globalLimit, concurrencyGroup
+	Detail         string     `json:"detail"`
+	EstimatedStart *time.Time `json:"estimatedStart,omitempty"`
+	Sample         int        `json:"sample,omitempty"` // ITEMS PER STAGE WHEN QUEUED AS A SAMPLE RUN
+
+	replay http.RoundTripper // RunOptions.Replay OF THE QUEUED RUN
+}
+
+// A RUN IN PROGRESS
+type RunningRun struct {
+	JobID          string      `json:"jobId"`
+	Name           string      `json:"name"`
+	Group          string      `json:"group,omitempty"`
+	Status         string      `json:"status"`
+	StartedAt      time.Time   `json:"startedAt"`
+	Elapsed        float64     `json:"elapsed"` // SECONDS
+	CompletedTasks int         `json:"completedTasks"`
+	TotalTasks     int         `json:"totalTasks"`
+	ETA            *time.Time  `json:"eta,omitempty"`
+	AssetWorkers   WorkerStats `json:"assetWorkers"`
+	Downloads      int         `json:"downloads"` // ACTIVE TRANSFERS
+	Browsers       int         `json:"browsers"`
+	Pages          int         `json:"pages"`
+}
+
+// RUNNING COUNT AGAINST A GROUP'S LIMIT
+type GroupUsage struct {
+	Limit   int `json:"limit"`
+	Running int `json:"running"`
+}
+
+// CURRENT LIMITS AND HOW MUCH OF THEM IS USED
+type QueueLimits struct {
+	MaxConcurrentJobs  int                   `json:"maxConcurrentJobs"` // IN EFFECT (0 = UNLIMITED)
+	Running            int                   `json:"running"`
+	Groups             map[string]GroupUsage `json:"groups"`
+	Blackout           bool                  `json:"blackout"`
+	BlackoutUntil      *time.Time            `json:"blackoutUntil,omitempty"`
+	AssetWorkersPerJob int                   `json:"assetWorkersPerJob"` // maxConcurrent
+	MaxBrowsers        int                   `json:"maxBrowsers"`        // OPEN BROWSERS ACROSS ALL JOBS (0 = NO LIMIT)
+}
+
+// SNAPSHOT OF RUNNING AND QUEUED RUNS
+type QueueStatus struct {
+	Running    []RunningRun        `json:"running"`
+	Queued     []QueuedRun         `json:"queued"`
+	Limits     QueueLimits         `json:"limits"`
+	Thumbnails ThumbnailQueueStats `json:"thumbnails"`
+}
+
+// CONCURRENCY GROUP A JOB JOINS THROUGH ITS concurrencyGroup RULE
+func jobGroup(rules models.JSONMap) string {
+	group, _ := rules["concurrencyGroup"].(string)
+	return strings.TrimSpace(group)
+}
+
+// PRIORITY A JOB QUEUES WITH THROUGH ITS priority RULE (normal WHEN UNSET OR UNKNOWN)
+func jobPriority(rules models.JSONMap) string {
+	priority, _ := rules["priority"].(string)
+	if !slices.Contains(QueuePriorities, priority) {
+		return PriorityNormal
+	}
+	return priority
+}
+
+// ADD A RUN BY PRIORITY, THEN BY WHEN IT WAS QUEUED, AND RETURN ITS POSITION (CALLER HOLDS e.mu)
+func (e *Engine) enqueue(run QueuedRun) int {
+	rank := slices.Index(QueuePriorities, run.Priority)
+	i := slices.IndexFunc(e.queue, func(queued QueuedRun) bool {
+		queuedRank := slices.Index(QueuePriorities, queued.Priority)
+		return queuedRank < rank || (queuedRank == rank && queued.QueuedAt.After(run.QueuedAt))
+	})
+	if i < 0 {
+		i = len(e.queue)
+	}
+	e.queue = slices.Insert(e.queue, i, run)
+	return i + 1
+}
+
+// MOVE A QUEUED RUN TO ANOTHER PRIORITY, KEEPING WHEN IT WAS QUEUED, AND RETURN ITS NEW POSITION
+func (e *Engine) SetQueuePriority(jobID, priority string) (int, error) {
+	if !slices.Contains(QueuePriorities, priority) {
+		return 0, fmt.Errorf("UNKNOWN QUEUE PRIORITY %s", priority)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	i := e.queuedIndex(jobID)
+	if i < 0 {
+		return 0, ErrJobNotQueued
+	}
+	run := e.queue[i]
+	e.queue = slices.Delete(e.queue, i, i+1)
+	run.Priority = priority
+	position := e.enqueue(run)
+	log.Printf("JOB %s NOW QUEUED AT %s PRIORITY (POSITION %d)", jobID, strings.ToUpper(priority), position)
+	return position, nil
+}
+
+// A JOB'S ENTRY IN THE QUEUE WITH ITS POSITION AND ESTIMATED START
+func (e *Engine) QueuedRun(jobID string) (QueuedRun, bool) {
+	for _, run := range e.QueueStatus().Queued {
+		if run.JobID == jobID {
+			return run, true
+		}
+	}
+	return QueuedRun{}, false
+}
+
+// INDEX OF A JOB IN THE QUEUE, -1 IF NOT QUEUED (CALLER HOLDS e.mu)
+func (e *Engine) queuedIndex(jobID string) int {
+	for i, run := range e.queue {
+		if run.JobID == jobID {
+			return i
+		}
+	}
+	return -1
+}
+
+// WHY A RUN IN THE GIVEN GROUP CAN'T START NOW, EMPTY IF IT CAN (CALLER HOLDS e.mu)
+func (e *Engine) waitReason(group string, now time.Time) (string, string) {
+	if until, ok := blackoutUntil(e.cfg.BlackoutWindows, now); ok {
+		return WaitBlackout, fmt.Sprintf("blackout window until %s", until.Format("15:04"))
+	}
+	if limit := e.cfg.ConcurrentJobLimit(); limit > 0 && len(e.runningJobs) >= limit {
+		return WaitGlobalLimit, fmt.Sprintf("global limit reached (%d/%d running)", len(e.runningJobs), limit)
+	}
+	if limit := e.cfg.ConcurrencyGroups[group]; group != "" && limit > 0 {
+		if running := e.groupRunning(group); running >= limit {
+			return WaitConcurrencyGroup, fmt.Sprintf("concurrency group %q is full (%d/%d running)", group, running, limit)
+		}
+	}
+	return "", ""
+}
+
+// RUNNING JOBS IN A GROUP (CALLER HOLDS e.mu)
+func (e *Engine) groupRunning(group string) int {
+	count := 0
+	for jobID := range e.runningJobs {
+		if jobGroup(e.jobRules[jobID]) == group {
+			count++
+		}
+	}
+	return count
+}
+
+// START EVERY QUEUED RUN THAT NO LONGER HAS TO WAIT, IN QUEUE (PRIORITY) ORDER
+func (e *Engine) dispatchQueue() {
+	now := time.Now()
+	var ready []QueuedRun
+	var runCtxs []context.Context
+
+	e.mu.Lock()
+	remaining := e.queue[:0]
+	for _, run := range e.queue {
+		if reason, detail := e.waitReason(run.Group, now); reason != "" {
+			run.Reason, run.Detail = reason, detail
+			remaining = append(remaining, run)
+			continue
+		}
+		// RESERVE NOW SO LATER ENTRIES SEE THE SLOT AS TAKEN (AND A STOP CAN CANCEL THE RUN BEFORE IT STARTS)
+		runCtx, stop := context.WithCancel(context.Background())
+		e.runningJobs[run.JobID] = stop
+		e.jobRules[run.JobID] = models.JSONMap{"concurrencyGroup": run.Group}
+		ready = append(ready, run)
+		runCtxs = append(runCtxs, runCtx)
+	}
+	e.queue = remaining
+	e.mu.Unlock()
+
+	for i, run := range ready {
+		jobID := run.JobID
+		var job models.Job
+		if err := e.db.First(&job, "id = ?", jobID).Error; err != nil {
+			log.Printf("QUEUED JOB %s NOT FOUND: %v", jobID, err)
+			e.mu.Lock()
+			delete(e.runningJobs, jobID)
+			delete(e.jobRules, jobID)
+			e.mu.Unlock()
+			continue
+		}
+		log.Printf("STARTING QUEUED JOB %s", jobID)
+		e.startJob(runCtxs[i], &job, RunOptions{Sample: run.Sample, Replay: run.replay})
+	}
+}
+
+// RE-CHECK THE QUEUE PERIODICALLY SO BLACKOUT WINDOWS ENDING RELEASE WAITING RUNS
+func (e *Engine) queueLoop() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.queueStop:
+			return
+		case <-ticker.C:
+			e.mu.Lock()
+			waiting := len(e.queue)
+			e.mu.Unlock()
+			if waiting > 0 {
+				e.dispatchQueue()
+			}
+		}
+	}
+}
+
+// WHETHER A BLACKOUT WINDOW COVERS THE GIVEN TIME, AND WHEN IT ENDS
+func (e *Engine) BlackoutAt(t time.Time) (time.Time, bool) {
+	return blackoutUntil(e.cfg.BlackoutWindows, t)
+}
+
+// END OF THE BLACKOUT WINDOW COVERING t (WINDOWS MAY CROSS MIDNIGHT)
+func blackoutUntil(windows []config.BlackoutWindow, t time.Time) (time.Time, bool) {
+	var until time.Time
+	for _, window := range windows {
+		start, err1 := time.Parse("15:04", window.Start)
+		end, err2 := time.Parse("15:04", window.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// A WINDOW STARTING YESTERDAY CAN STILL BE OPEN TODAY
+		for _, offset := range []int{-1, 0} {
+			day := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, 0, 0, 0, t.Location())
+			if !window.OnDay(day.Weekday()) {
+				continue
+			}
+			from := day.Add(time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute)
+			to := day.Add(time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute)
+			if !to.After(from) {
+				to = to.Add(24 * time.Hour)
+			}
+			if !t.Before(from) && t.Before(to) && to.After(until) {
+				until = to
+			}
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// SNAPSHOT OF RUNNING AND QUEUED RUNS WITH ETAS
+func (e *Engine) QueueStatus() QueueStatus {
+	now := time.Now()
+	status := QueueStatus{
+		Running: []RunningRun{},
+		Queued:  []QueuedRun{},
+		Limits: QueueLimits{
+			MaxConcurrentJobs:  e.cfg.ConcurrentJobLimit(),
+			Groups:             make(map[string]GroupUsage),
+			AssetWorkersPerJob: e.cfg.MaxConcurrent,
+			MaxBrowsers:        e.cfg.MaxBrowsers,
+		},
+	}
+	if until, ok := blackoutUntil(e.cfg.BlackoutWindows, now); ok {
+		status.Limits.Blackout = true
+		status.Limits.BlackoutUntil = &until
+	}
+
+	e.mu.Lock()
+	status.Limits.Running = len(e.runningJobs)
+	for group, limit := range e.cfg.ConcurrencyGroups {
+		status.Limits.Groups[group] = GroupUsage{Limit: limit, Running: e.groupRunning(group)}
+	}
+
+	for jobID := range e.runningJobs {
+		run := RunningRun{JobID: jobID, Group: jobGroup(e.jobRules[jobID]), Status: "starting"}
+		if startedAt, ok := e.jobStartTimes[jobID]; ok {
+			run.StartedAt = startedAt
+			run.Elapsed = now.Sub(startedAt).Seconds()
+		}
+		if progress, ok := e.jobProgress[jobID]; ok && !run.StartedAt.IsZero() {
+			run.Status = progress.Status
+			run.CompletedTasks = progress.CompletedTasks
+			run.TotalTasks = progress.TotalTasks
+		}
+		if pool, ok := e.assetWorkers[jobID]; ok {
+			run.AssetWorkers = pool.Stats()
+		}
+		// ETA FROM TASK PROGRESS, FALLING BACK TO THE PREVIOUS RUN'S DURATION
+		if !run.StartedAt.IsZero() {
+			elapsed := now.Sub(run.StartedAt)
+			switch {
+			case run.CompletedTasks > 0 && run.TotalTasks > run.CompletedTasks:
+				remaining := time.Duration(float64(elapsed) * float64(run.TotalTasks-run.CompletedTasks) / float64(run.CompletedTasks))
+				eta := now.Add(remaining)
+				run.ETA = &eta
+			case e.jobDurations[jobID] > elapsed:
+				eta := run.StartedAt.Add(e.jobDurations[jobID])
+				run.ETA = &eta
+			}
+		}
+		status.Running = append(status.Running, run)
+	}
+
+	for i, run := range e.queue {
+		run.Position = i + 1
+		status.Queued = append(status.Queued, run)
+	}
+	e.mu.Unlock()
+
+	sort.Slice(status.Running, func(i, j int) bool {
+		return status.Running[i].StartedAt.Before(status.Running[j].StartedAt)
+	})
+
+	for i := range status.Running {
+		run := &status.Running[i]
+		for _, download := range e.transfers.Job(run.JobID) {
+			if download.Status == "queued" || download.Status == "downloading" {
+				run.Downloads++
+			}
+		}
+		run.Browsers, run.Pages = e.resourceManager.CountBrowsers(run.JobID)
+	}
+
+	// ESTIMATED START: END OF THE BLACKOUT, OR WHEN ENOUGH RUNNING JOBS ARE EXPECTED TO FINISH
+	var finishes []time.Time
+	for _, run := range status.Running {
+		if run.ETA != nil {
+			finishes = append(finishes, *run.ETA)
+		}
+	}
+	sort.Slice(finishes, func(i, j int) bool { return finishes[i].Before(finishes[j]) })
+	slotsAhead := 0
+	for i := range status.Queued {
+		run := &status.Queued[i]
+		switch run.Reason {
+		case WaitBlackout:
+			run.EstimatedStart = status.Limits.BlackoutUntil
+		case WaitGlobalLimit:
+			if slotsAhead < len(finishes) {
+				start := finishes[slotsAhead]
+				run.EstimatedStart = &start
+			}
+			slotsAhead++
+		}
+	}
+	status.Thumbnails = e.thumbnails.Stats()
+	return status
+}
+
+// BROWSERS AND PAGES A JOB HAS OPEN
+func (rm *ResourceManager) CountBrowsers(jobID string) (int, int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	browsers, pages := 0, 0
+	for _, resource := range rm.resources[jobID] {
+		switch resource.(type) {
+		case playwright.Browser:
+			browsers++
+		case playwright.Page:
+			pages++
+		}
+	}
+	return browsers, pages
+}
+
+// WHETHER A JOB IS WAITING IN THE QUEUE
+func (e *Engine) IsJobQueued(jobID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.queuedIndex(jobID) >= 0
+}
@@ -0,0 +1,309 @@
+package scraper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// EXTRACT RECORDS TASK: ONE OBJECT PER MATCHING ELEMENT, WITH FIELDS READ FROM SUB-SELECTORS
+type ExtractRecordsTask struct{}
+
+func (t *ExtractRecordsTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"pageId":       "string",   // REQUIRED
+		"selector":     "string",   // REQUIRED (ONE RECORD PER MATCH, CSS OR XPATH)
+		"selectorType": "string?",  // OPTIONAL (css OR xpath, DETECTED WHEN NOT GIVEN)
+		"fields":       "object",   // REQUIRED (NAME -> SELECTOR, OR {selector, selectorType, attribute, multiple, transform})
+		"transforms":   "object?",  // OPTIONAL (FIELD -> TRANSFORM OR LIST, SEE transforms.go)
+		"limit":        "number?",  // OPTIONAL (MAX RECORDS)
+		"save":         "boolean?", // OPTIONAL (PERSIST AS RECORDS, defaults to false)
+		"timeout":      "number?",  // OPTIONAL
+	}
+}
+
+func (t *ExtractRecordsTask) GetOutputSchema() string {
+	return "array" // RETURNS ARRAY OF OBJECTS
+}
+
+func (t *ExtractRecordsTask) ValidateConfig(config map[string]any) error {
+	if _, ok := config["pageId"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	if _, ok := config["selector"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	if err := validateSelectorConfig(config); err != nil {
+		return err
+	}
+	fields, err := recordFields(config["fields"])
+	if err != nil {
+		return err
+	}
+	for name, spec := range fields {
+		selector, _ := spec["selector"].(string)
+		selectorType, _ := spec["selectorType"].(string)
+		if selector == "" {
+			continue
+		}
+		if err := validateSelector(selector, selectorType); err != nil {
+			return fmt.Errorf("FIELD %s: %w", name, err)
+		}
+	}
+	_, err = fieldSpecTransforms(fields, config["transforms"])
+	return err
+}
+
+func (t *ExtractRecordsTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	page, err := getPage(ctx, config["pageId"])
+	if err != nil {
+		return TaskData{}, err
+	}
+	selector := configSelector(config, "")
+	fields, err := recordFields(config["fields"])
+	if err != nil {
+		return TaskData{}, err
+	}
+	transforms, err := fieldSpecTransforms(fields, config["transforms"])
+	if err != nil {
+		return TaskData{}, err
+	}
+	limit := int(numberConfig(config, "limit", 0))
+	timeout := numberConfig(config, "timeout", 5000)
+
+	ctx.Logger.Printf("EXTRACTING RECORDS FROM: %s", selector)
+	err = page.Locator(selector).First().WaitFor(playwright.LocatorWaitForOptions{
+		Timeout: playwright.Float(timeout),
+	})
+	if err != nil {
+		return TaskData{}, fmt.Errorf("WAIT FOR SELECTOR FAILED: %v", err)
+	}
+
+	// FIELDS WITHOUT A SELECTOR READ THE MATCHED ELEMENT ITSELF; href/src RESOLVE TO ABSOLUTE URLS.
+	// XPATH FIELDS ARE EVALUATED FROM THE MATCHED ELEMENT, SO .//span STAYS INSIDE IT
+	script := `(elements, [fields, limit]) => {
+		if (limit > 0) elements = elements.slice(0, limit);
+		const select = (el, spec) => {
+			if (!spec.xpath) return Array.from(el.querySelectorAll(spec.selector));
+			const found = document.evaluate(spec.selector, el, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
+			return Array.from({length: found.snapshotLength}, (_, i) => found.snapshotItem(i));
+		};
+		return elements.map(el => {
+			const record = {};
+			for (const [name, spec] of Object.entries(fields)) {
+				const targets = spec.selector ? select(el, spec) : [el];
+				const read = node => {
+					if (!spec.attribute) return (node.textContent || '').trim();
+					if ((spec.attribute === 'href' || spec.attribute === 'src') && node[spec.attribute]) return node[spec.attribute];
+					return node.getAttribute(spec.attribute);
+				};
+				record[name] = spec.multiple ? targets.map(read) : (targets.length ? read(targets[0]) : null);
+			}
+			return record;
+		});
+	}`
+	result, err := page.Locator(selector).EvaluateAll(script, []any{recordFieldSelectors(fields), limit})
+	if err != nil {
+		return TaskData{}, fmt.Errorf("RECORD EXTRACTION FAILED: %v", err)
+	}
+	records, ok := result.([]any)
+	if !ok {
+		return TaskData{}, fmt.Errorf("UNEXPECTED RESULT TYPE: %T", result)
+	}
+	ctx.Logger.Printf("EXTRACTED %d RECORDS", len(records))
+	transforms.apply(records, ctx.Logger)
+
+	if boolConfig(config, "save", false) {
+		if _, err := saveRecords(ctx, "extractRecords", page.URL(), records); err != nil {
+			return TaskData{}, err
+		}
+	}
+	return TaskData{Type: "array", Value: records}, nil
+}
+
+// NORMALIZE THE fields INPUT TO NAME -> {selector, attribute, multiple}
+func recordFields(raw any) (map[string]map[string]any, error) {
+	input, ok := raw.(map[string]any)
+	if !ok || len(input) == 0 {
+		return nil, fmt.Errorf("%w: fields MUST BE A NON-EMPTY OBJECT", ErrInvalidInput)
+	}
+	fields := make(map[string]map[string]any, len(input))
+	for name, spec := range input {
+		switch v := spec.(type) {
+		case string:
+			fields[name] = map[string]any{"selector": v}
+		case map[string]any:
+			fields[name] = v
+		default:
+			return nil, fmt.Errorf("%w: FIELD %s MUST BE A SELECTOR OR AN OBJECT", ErrInvalidInput, name)
+		}
+	}
+	return fields, nil
+}
+
+// SAVE RECORDS TASK: PERSIST ANY TASK OUTPUT (OBJECT, ARRAY, TEXT) AS RECORDS
+type SaveRecordsTask struct{}
+
+func (t *SaveRecordsTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"records":    "any",     // REQUIRED (OBJECT, ARRAY OF OBJECTS OR VALUES)
+		"url":        "string?", // OPTIONAL (SOURCE PAGE)
+		"source":     "string?", // OPTIONAL (LABEL, defaults to 'saveRecords')
+		"transforms": "object?", // OPTIONAL (FIELD -> TRANSFORM OR LIST, APPLIED BEFORE SAVING)
+	}
+}
+
+func (t *SaveRecordsTask) GetOutputSchema() string {
+	return "object" // RETURNS SAVED, UPDATED, DUPLICATE, REJECTED AND PII COUNTS
+}
+
+func (t *SaveRecordsTask) ValidateConfig(config map[string]any) error {
+	_, err := parseTransforms(config["transforms"]) // RECORDS USUALLY ARRIVE THROUGH inputRefs
+	return err
+}
+
+func (t *SaveRecordsTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	raw, ok := config["records"]
+	if !ok {
+		return TaskData{}, ErrMissingRequiredInput
+	}
+	items, isList := raw.([]any)
+	if !isList {
+		items = []any{raw}
+	}
+	source, _ := config["source"].(string)
+	if source == "" {
+		source = "saveRecords"
+	}
+	pageURL, _ := config["url"].(string)
+	transforms, err := parseTransforms(config["transforms"])
+	if err != nil {
+		return TaskData{}, err
+	}
+	transforms.apply(items, ctx.Logger)
+
+	counts, err := saveRecords(ctx, source, pageURL, items)
+	if err != nil {
+		return TaskData{}, err
+	}
+	return TaskData{
+		Type: "object",
+		Value: map[string]any{
+			"saved":      counts.Saved,
+			"updated":    counts.Updated,
+			"duplicates": counts.Duplicates,
+			"rejected":   counts.Rejected,
+			"pii":        counts.PII,
+		},
+	}, nil
+}
+
+// OUTCOME OF SAVING A BATCH OF RECORDS
+type RecordCounts struct {
+	Saved      int // NEW KEYS
+	Updated    int // KNOWN KEYS WHOSE DATA CHANGED
+	Duplicates int // KNOWN KEYS WITH THE SAME DATA
+	Rejected   int // FAILED THE JOB'S RECORD SCHEMA OR LACKED A KEY FIELD
+	PII        int // HAD PII FOUND BY THE JOB'S pii RULE
+}
+
+// PERSIST EXTRACTED ITEMS AS RECORDS; OBJECTS ARE STORED AS-IS, OTHER VALUES UNDER "value".
+// ITEMS FAILING THE JOB'S RECORD SCHEMA OR MISSING A KEY FIELD GO TO THE REJECTION QUEUE
+// INSTEAD, AND AN ITEM WHOSE KEY THE JOB ALREADY HAS UPDATES THAT RECORD (SEE StoreRecord)
+func saveRecords(ctx *TaskContext, source, pageURL string, items []any) (RecordCounts, error) {
+	var counts RecordCounts
+	schema, err := ctx.Engine.recordSchema(ctx.JobID)
+	if err != nil {
+		return counts, err
+	}
+	keyFields, err := ctx.Engine.recordKeyFields(ctx.JobID)
+	if err != nil {
+		return counts, err
+	}
+	pii, err := ctx.Engine.piiPolicy(ctx.JobID)
+	if err != nil {
+		return counts, err
+	}
+	ctx.Engine.mu.Lock()
+	runID := ctx.Engine.jobProgress[ctx.JobID].RunID
+	ctx.Engine.mu.Unlock()
+
+	for _, item := range items {
+		data, ok := item.(map[string]any)
+		if !ok {
+			data = map[string]any{"value": item}
+		}
+		var findings []PIIFinding
+		if pii != nil {
+			data = maps.Clone(data) // THE ITEM MAY STILL BE ANOTHER TASK'S OUTPUT
+			if findings = pii.Scrub(data); findings != nil {
+				counts.PII++
+			}
+		}
+		var problems []RecordSchemaError
+		if schema != nil {
+			problems = RecordSchemaErrors(schema, data)
+		}
+		record, err := NewRecord(ctx.JobID, runID, source, pageURL, data, keyFields)
+		if errors.Is(err, ErrRecordKeyMissing) {
+			problems = append(problems, RecordSchemaError{Keyword: recordKeyRule, Message: err.Error()})
+			record, err = NewRecord(ctx.JobID, runID, source, pageURL, data, nil)
+		}
+		if err != nil {
+			return counts, err
+		}
+		record.PII = PIIColumn(findings)
+		if problems != nil {
+			if err := rejectRecord(ctx.Engine.db, record, problems); err != nil {
+				return counts, err
+			}
+			counts.Rejected++
+			continue
+		}
+
+		outcome, err := StoreRecord(ctx.Engine.db, &record)
+		if err != nil {
+			return counts, err
+		}
+		switch outcome {
+		case RecordInserted:
+			counts.Saved++
+		case RecordUpdated:
+			counts.Updated++
+		default:
+			counts.Duplicates++
+		}
+	}
+	ctx.Logger.Printf("SAVED %d RECORDS (%d UPDATED, %d UNCHANGED, %d REJECTED)", counts.Saved, counts.Updated, counts.Duplicates, counts.Rejected)
+	if counts.PII > 0 {
+		ctx.Logger.Printf("FOUND PII IN %d RECORDS (ACTION: %s)", counts.PII, pii.Action)
+	}
+
+	// UPDATE JOB PROGRESS RECORD COUNTS
+	ctx.Engine.mu.Lock()
+	if progress, ok := ctx.Engine.jobProgress[ctx.JobID]; ok {
+		progress.Records += counts.Saved
+		progress.RecordsUpdated += counts.Updated
+		progress.RecordsDupes += counts.Duplicates
+		progress.RecordsInvalid += counts.Rejected
+		progress.RecordsPII += counts.PII
+		ctx.Engine.jobProgress[ctx.JobID] = progress
+	}
+	ctx.Engine.mu.Unlock()
+	return counts, nil
+}
+
+// CONTENT HASH OF A RECORD (encoding/json SORTS MAP KEYS, SO FIELD ORDER DOESN'T MATTER)
+func recordHash(data map[string]any) (string, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,109 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gorm.io/gorm"
+)
+
+// JOB RULE HOLDING THE JSON SCHEMA EXTRACTED RECORDS MUST MATCH
+const recordSchemaRule = "recordSchema"
+
+// ONE REASON A RECORD DOESN'T MATCH ITS JOB'S SCHEMA
+type RecordSchemaError struct {
+	Path    string `json:"path"`    // JSON POINTER INTO THE RECORD, E.G. /price
+	Keyword string `json:"keyword"` // SCHEMA LOCATION THAT FAILED, E.G. /properties/price/type
+	Message string `json:"message"`
+}
+
+// COMPILE A JOB'S RECORD SCHEMA. ONLY REFERENCES INSIDE THE SCHEMA ARE RESOLVED; EXTERNAL
+// $refs ARE REFUSED SO A SCHEMA CAN'T MAKE THE SERVER READ FILES OR FETCH URLS
+func CompileRecordSchema(schema any) (*jsonschema.Schema, error) {
+	doc, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("INVALID RECORD SCHEMA: %v", err)
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("EXTERNAL SCHEMA REFERENCES ARE NOT ALLOWED: %s", url)
+	}
+	if err := compiler.AddResource("record.json", bytes.NewReader(doc)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile("record.json")
+}
+
+// VALIDATE A RECORD, RETURNING EVERY LEAF ERROR (NIL WHEN IT MATCHES)
+func RecordSchemaErrors(schema *jsonschema.Schema, data map[string]any) []RecordSchemaError {
+	err := schema.Validate(jsonSafe(data))
+	if err == nil {
+		return nil
+	}
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return []RecordSchemaError{{Message: err.Error()}}
+	}
+	var out []RecordSchemaError
+	var walk func(*jsonschema.ValidationError)
+	walk = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			out = append(out, RecordSchemaError{Path: ve.InstanceLocation, Keyword: ve.KeywordLocation, Message: ve.Message})
+			return
+		}
+		for _, cause := range ve.Causes {
+			walk(cause)
+		}
+	}
+	walk(validationErr)
+	return out
+}
+
+// THE RUNNING JOB'S COMPILED RECORD SCHEMA, COMPILED ON FIRST USE (NIL WHEN THE JOB HAS NONE)
+func (e *Engine) recordSchema(jobID string) (*jsonschema.Schema, error) {
+	e.mu.Lock()
+	schema, cached := e.recordSchemas[jobID]
+	e.mu.Unlock()
+	if cached {
+		return schema, nil
+	}
+	raw, ok := e.jobRule(jobID, recordSchemaRule)
+	if ok && raw != nil {
+		var err error
+		if schema, err = CompileRecordSchema(raw); err != nil {
+			return nil, err
+		}
+	}
+	e.mu.Lock()
+	e.recordSchemas[jobID] = schema
+	e.mu.Unlock()
+	return schema, nil
+}
+
+// QUEUE A RECORD THAT FAILED ITS SCHEMA FOR REVIEW
+func rejectRecord(db *gorm.DB, record models.Record, problems []RecordSchemaError) error {
+	errs := make(models.JSONArray, len(problems))
+	for i, problem := range problems {
+		errs[i] = map[string]any{"path": problem.Path, "keyword": problem.Keyword, "message": problem.Message}
+	}
+	rejection := models.RecordRejection{
+		ID:        generateID("rejection"),
+		JobID:     record.JobID,
+		RunID:     record.RunID,
+		Source:    record.Source,
+		URL:       record.URL,
+		Data:      record.Data,
+		Errors:    errs,
+		CreatedAt: time.Now(),
+	}
+	if err := db.Create(&rejection).Error; err != nil {
+		return fmt.Errorf("FAILED TO SAVE REJECTED RECORD: %v", err)
+	}
+	return nil
+}
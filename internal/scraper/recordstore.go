@@ -0,0 +1,204 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JOB RULE NAMING THE FIELDS THAT IDENTIFY A RECORD ACROSS RUNS, E.G. "sku" OR ["$url", "variant"]
+const recordKeyRule = "recordKey"
+
+// KEY FIELD THAT MEANS THE PAGE THE RECORD CAME FROM RATHER THAN A DATA FIELD
+const recordKeyURL = "$url"
+
+// RETURNED WHEN A RECORD LACKS ONE OF ITS JOB'S KEY FIELDS
+var ErrRecordKeyMissing = errors.New("RECORD IS MISSING A KEY FIELD")
+
+// WHAT STORING A RECORD DID
+type RecordOutcome string
+
+const (
+	RecordInserted  RecordOutcome = "inserted"  // FIRST TIME THE JOB SAW THIS KEY
+	RecordUpdated   RecordOutcome = "updated"   // KNOWN KEY, DATA CHANGED
+	RecordUnchanged RecordOutcome = "unchanged" // KNOWN KEY, SAME DATA; ONLY lastSeen MOVES
+)
+
+// PARSE A recordKey RULE: A FIELD NAME OR A LIST OF THEM (NIL WHEN UNSET)
+func RecordKeyFields(raw any) ([]string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, fmt.Errorf("RECORD KEY FIELD CAN'T BE EMPTY")
+		}
+		return []string{v}, nil
+	case []any:
+		fields := make([]string, 0, len(v))
+		for _, f := range v {
+			name, ok := f.(string)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("RECORD KEY MUST BE A FIELD NAME OR A LIST OF FIELD NAMES")
+			}
+			fields = append(fields, name)
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("RECORD KEY LIST CAN'T BE EMPTY")
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("RECORD KEY MUST BE A FIELD NAME OR A LIST OF FIELD NAMES")
+}
+
+// THE RUNNING JOB'S KEY FIELDS (NIL WHEN IT HAS NONE)
+func (e *Engine) recordKeyFields(jobID string) ([]string, error) {
+	raw, _ := e.jobRule(jobID, recordKeyRule)
+	return RecordKeyFields(raw)
+}
+
+// KEY FOR A RECORD: THE SINGLE FIELD'S VALUE, OR A JSON LIST OF THE VALUES FOR COMPOUND KEYS
+func recordKey(fields []string, pageURL string, data map[string]any) (string, error) {
+	values := make([]any, len(fields))
+	for i, field := range fields {
+		value := data[field]
+		if field == recordKeyURL {
+			value = pageURL
+		}
+		if value == nil || value == "" {
+			return "", fmt.Errorf("%w: %s", ErrRecordKeyMissing, field)
+		}
+		values[i] = value
+	}
+	if s, ok := values[0].(string); ok && len(values) == 1 {
+		return s, nil
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// BUILD A RECORD FOR A JOB, WITH ITS ID, CONTENT HASH AND KEY SET. WITHOUT KEY FIELDS THE
+// HASH IS THE KEY, SO IDENTICAL DATA IS STORED ONCE PER JOB
+func NewRecord(jobID, runID, source, pageURL string, data map[string]any, keyFields []string) (models.Record, error) {
+	hash, err := recordHash(data)
+	if err != nil {
+		return models.Record{}, fmt.Errorf("FAILED TO ENCODE RECORD: %v", err)
+	}
+	key := hash
+	if len(keyFields) > 0 {
+		if key, err = recordKey(keyFields, pageURL, data); err != nil {
+			return models.Record{}, err
+		}
+	}
+	now := time.Now()
+	return models.Record{
+		ID:        fmt.Sprintf("record_%s", utils.GenerateID("")),
+		JobID:     jobID,
+		RunID:     runID,
+		Source:    source,
+		URL:       pageURL,
+		Data:      models.JSONMap(data),
+		Key:       key,
+		Hash:      hash,
+		FirstSeen: now,
+		LastSeen:  now,
+		ChangedAt: now,
+		CreatedAt: now,
+	}, nil
+}
+
+// INSERT A RECORD, OR UPDATE THE JOB'S RECORD WITH THE SAME KEY AND LOG WHICH FIELDS CHANGED.
+// ON RETURN record HOLDS THE STORED ROW
+func StoreRecord(db *gorm.DB, record *models.Record) (RecordOutcome, error) {
+	var outcome RecordOutcome
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing models.Record
+		found := tx.Where(map[string]any{"job_id": record.JobID, "key": record.Key}).Limit(1).Find(&existing)
+		if found.Error != nil {
+			return found.Error
+		}
+		if found.RowsAffected == 0 {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+			if result.Error != nil {
+				return result.Error
+			}
+			outcome = RecordInserted
+			if result.RowsAffected == 0 {
+				outcome = RecordUnchanged // A CONCURRENT SAVE GOT THERE FIRST
+			}
+			return nil
+		}
+
+		updates := map[string]any{"last_seen": record.LastSeen, "run_id": record.RunID}
+		outcome = RecordUnchanged
+		if existing.Hash != record.Hash {
+			change := models.RecordChange{
+				ID:        generateID("change"),
+				RecordID:  existing.ID,
+				JobID:     existing.JobID,
+				RunID:     record.RunID,
+				Fields:    diffRecordData(existing.Data, record.Data),
+				CreatedAt: record.LastSeen,
+			}
+			if err := tx.Create(&change).Error; err != nil {
+				return err
+			}
+			updates["data"] = record.Data
+			updates["pii"] = record.PII
+			updates["hash"] = record.Hash
+			updates["url"] = record.URL
+			updates["source"] = record.Source
+			updates["changed_at"] = record.LastSeen
+			updates["changes"] = gorm.Expr("changes + 1")
+			outcome = RecordUpdated
+		}
+		if err := tx.Model(&existing).Updates(updates).Error; err != nil {
+			return err
+		}
+		*record = models.Record{}
+		return tx.First(record, "id = ?", existing.ID).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("FAILED TO SAVE RECORD TO DATABASE: %v", err)
+	}
+	return outcome, nil
+}
+
+// FIELDS THAT DIFFER BETWEEN TWO VERSIONS OF A RECORD, AS [{field, from, to}] SORTED BY FIELD;
+// ADDED AND REMOVED FIELDS HAVE A NULL from OR to
+func diffRecordData(before, after map[string]any) models.JSONArray {
+	fields := make(map[string]bool)
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	changes := models.JSONArray{}
+	for _, name := range names {
+		from, to := before[name], after[name]
+		a, _ := json.Marshal(from)
+		b, _ := json.Marshal(to)
+		if !bytes.Equal(a, b) {
+			changes = append(changes, map[string]any{"field": name, "from": from, "to": to})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,53 @@
+package scraper
+
+import (
+	"log"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// -- RESTART RECOVERY --
+
+// STATUS OF A RUN CUT SHORT BY A SHUTDOWN OR CRASH
+const StatusInterrupted = "interrupted"
+
+// JOB RULE: RESUME AFTER A RESTART (OVERRIDES THE resumeInterruptedJobs SETTING)
+const resumeOnRestartRule = "resumeOnRestart"
+
+// RUNS THE LAST PROCESS LEFT "running" OR "queued" DIED WITH IT: MARK THEM INTERRUPTED, THEN
+// START AGAIN THE INTERRUPTED JOBS THAT RESUME ON RESTART. RETURNS HOW MANY WERE RESUMED
+func (e *Engine) RecoverInterruptedJobs() int {
+	var jobs []models.Job
+	if err := e.db.Where("status IN ?", []string{"running", "queued", StatusInterrupted}).Find(&jobs).Error; err != nil {
+		log.Printf("FAILED TO LOAD INTERRUPTED JOBS: %v", err)
+		return 0
+	}
+
+	resumed := 0
+	for _, job := range jobs {
+		if job.Status != StatusInterrupted {
+			// A CLEAN SHUTDOWN MARKS ITS OWN RUNS, SO THESE WERE LEFT BY A CRASH
+			log.Printf("JOB %s WAS %s WHEN CREPES LAST EXITED, MARKING INTERRUPTED", job.ID, job.Status)
+			e.updateJobStatus(job.ID, StatusInterrupted)
+			e.addJobError(job.ID, "Run interrupted: Crepes exited unexpectedly while the job was "+job.Status)
+		}
+		if !e.resumesOnRestart(job) {
+			continue
+		}
+		log.Printf("RESUMING INTERRUPTED JOB %s", job.ID)
+		if err := e.RunJob(job.ID); err != nil && err != ErrJobQueued {
+			log.Printf("FAILED TO RESUME JOB %s: %v", job.ID, err)
+			continue
+		}
+		resumed++
+	}
+	return resumed
+}
+
+// THE JOB'S resumeOnRestart RULE, OR THE GLOBAL SETTING
+func (e *Engine) resumesOnRestart(job models.Job) bool {
+	if resume, ok := job.Rules[resumeOnRestartRule].(bool); ok {
+		return resume
+	}
+	return e.cfg.ResumeInterruptedJobs
+}
@@ -0,0 +1,140 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/playwright-community/playwright-go"
+)
+
+// ERRORS RETURNED WHEN A FAILURE CAN'T BE REPLAYED
+var (
+	ErrReplayNoTask     = errors.New("ERROR HAS NO TASK TO REPLAY")
+	ErrReplayNoSnapshot = errors.New("TASK NEEDS A PAGE BUT NO SNAPSHOT WAS CAPTURED")
+)
+
+// RE-RUN THE TASK BEHIND A LOGGED FAILURE WITH THE INPUTS IT FAILED WITH (PLUS ANY
+// OVERRIDES). TASKS THAT USE A PAGE GET A FRESH ONE SERVING THE CAPTURED DOM SNAPSHOT
+// AT THE ORIGINAL URL, SO SELECTORS CAN BE DEBUGGED WITHOUT RE-RUNNING THE JOB
+func (e *Engine) ReplayTask(ctx context.Context, entry models.ErrorLog, overrides map[string]any) (TaskData, error) {
+	if entry.TaskType == "" {
+		return TaskData{}, ErrReplayNoTask
+	}
+	taskImpl, err := e.taskRegistry.GetTask(entry.TaskType)
+	if err != nil {
+		return TaskData{}, err
+	}
+
+	// START FROM WHAT THE TASK RAN WITH; OLDER ENTRIES ONLY HAVE THE TASK'S OWN CONFIG
+	config := make(map[string]any)
+	source := entry.Inputs
+	if len(source) == 0 {
+		source = entry.TaskConfig
+	}
+	for k, v := range source {
+		config[k] = v
+	}
+	for k, v := range overrides {
+		config[k] = v
+	}
+	if err := taskImpl.ValidateConfig(config); err != nil {
+		return TaskData{}, fmt.Errorf("INVALID TASK CONFIG: %v", err)
+	}
+
+	// REPLAYS GET THEIR OWN RESOURCE SCOPE SO THEY NEVER TOUCH A RUNNING JOB'S PAGES
+	replayID := generateID("replay")
+	defer e.releaseJobResources(replayID)
+	logger := log.New(os.Stdout, fmt.Sprintf("[REPLAY %s] ", entry.ID), log.LstdFlags)
+
+	if _, usesPage := config["pageId"]; usesPage {
+		browser, page, err := e.snapshotPage(ctx, entry)
+		if err != nil {
+			return TaskData{}, err
+		}
+		browserID := fmt.Sprintf("browser_%s", utils.GenerateID(""))
+		pageID := fmt.Sprintf("page_%s", utils.GenerateID(""))
+		e.resourceManager.CreateResource(replayID, browserID, "browser", browser)
+		e.resourceManager.CreateResource(replayID, pageID, "page", page)
+
+		// THE SNAPSHOT BROWSER IS OURS TO CLOSE, NOT A LEAK
+		defer func() {
+			e.resourceManager.DeleteResource(replayID, pageID)
+			e.resourceManager.DeleteResource(replayID, browserID)
+			browser.Close()
+		}()
+		config["pageId"] = pageID
+		if _, ok := config["browserId"]; ok {
+			config["browserId"] = browserID
+		}
+	}
+
+	taskCtx := &TaskContext{
+		JobID:           replayID,
+		ResourceManager: e.resourceManager,
+		TaskResults:     make(map[string]TaskData),
+		Context:         ctx,
+		Logger:          logger,
+		Engine:          e,
+	}
+	logger.Printf("REPLAYING TASK %s (%s) FROM JOB %s", entry.TaskName, entry.TaskType, entry.JobID)
+	return taskImpl.Execute(taskCtx, config)
+}
+
+// LAUNCH A HEADLESS BROWSER WITH A PAGE SHOWING THE ENTRY'S DOM SNAPSHOT AT ITS ORIGINAL URL
+func (e *Engine) snapshotPage(ctx context.Context, entry models.ErrorLog) (playwright.Browser, playwright.Page, error) {
+	if entry.Snapshot == "" {
+		return nil, nil, ErrReplayNoSnapshot
+	}
+	root, err := filepath.Abs(e.cfg.StoragePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	full := filepath.Join(root, filepath.Clean(entry.Snapshot))
+	if !strings.HasPrefix(full, root+string(filepath.Separator)) {
+		return nil, nil, ErrReplayNoSnapshot
+	}
+	html, err := os.ReadFile(full)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrReplayNoSnapshot, err)
+	}
+
+	browserPtr, err := e.launchBrowser(ctx, "", true)
+	if err != nil {
+		return nil, nil, err
+	}
+	browser := *browserPtr
+	page, err := browser.NewPage()
+	if err != nil {
+		browser.Close()
+		return nil, nil, fmt.Errorf("%w: %v", ErrPageCreation, err)
+	}
+
+	// ENTRIES WITHOUT A URL STILL NEED AN HTTP ORIGIN FOR THE ROUTE TO MATCH
+	url := entry.URL
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "https://replay.invalid/"
+	}
+	err = page.Route(url, func(route playwright.Route) {
+		route.Fulfill(playwright.RouteFulfillOptions{
+			Body:        html,
+			ContentType: playwright.String("text/html; charset=utf-8"),
+		})
+	})
+	if err == nil {
+		_, err = page.Goto(url, playwright.PageGotoOptions{
+			WaitUntil: playwright.WaitUntilStateDomcontentloaded,
+		})
+	}
+	if err != nil {
+		browser.Close()
+		return nil, nil, fmt.Errorf("COULD NOT LOAD SNAPSHOT: %v", err)
+	}
+	return browser, page, nil
+}
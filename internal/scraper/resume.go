@@ -0,0 +1,689 @@
+package scraper
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// -- RESUMABLE DOWNLOADS --
+//
+// A DIRECT DOWNLOAD IS WRITTEN TO A PART FILE UNDER THE DATA DIRECTORY AND ONLY MOVED INTO STORAGE
+// ONCE IT'S WHOLE. A PartialDownload ROW, KEYED BY JOB AND URL, REMEMBERS THE PART FILE, THE
+// SERVER'S VALIDATORS AND HOW FAR IT GOT: A DROPPED CONNECTION IS PICKED UP WITH A Range REQUEST
+// FROM THE LAST BYTE WRITTEN, AND SO IS THE SAME URL IN A LATER RUN OF THE JOB. LARGE FILES FROM
+// SERVERS THAT TAKE RANGES CAN BE FETCHED AS PARALLEL CHUNKS, EACH RESUMING FROM WHERE IT GOT TO.
+// A SERVER THAT IGNORES THE RANGE, OR WHOSE FILE CHANGED (If-Range), SENDS THE WHOLE BODY AND THE
+// DOWNLOAD STARTS OVER.
+
+const (
+	defaultResumeRetries = 3
+	defaultChunkMinSize  = 64 << 20 // FILES SMALLER THAN THIS ARE NEVER SPLIT
+	maxDownloadChunks    = 16
+	partialSaveInterval  = 5 * time.Second
+	stalePartialAge      = 7 * 24 * time.Hour // PART FILES UNTOUCHED THIS LONG ARE DELETED BY MAINTENANCE
+)
+
+// THE SERVER WOULDN'T CONTINUE FROM THE PART FILE (416, OR A RANGE OTHER THAN THE ONE ASKED FOR)
+var errRangeRestart = errors.New("SERVER REJECTED THE RANGE, STARTING OVER")
+
+// HOW A DOWNLOAD RESUMES AND SPLITS
+type resumePolicy struct {
+	Enabled  bool  // KEEP THE PART FILE AND RANGE-REQUEST THE REST AFTER A FAILURE
+	Retries  int   // RESUMES PER SOURCE BEFORE GIVING UP ON IT
+	Chunks   int   // PARALLEL RANGE REQUESTS FOR LARGE FILES (1 NEVER SPLITS)
+	ChunkMin int64 // SMALLEST FILE WORTH SPLITTING
+}
+
+// THE TASK'S resume, resumeRetries, chunks AND chunkMinSize INPUTS
+func resolveResumePolicy(config map[string]any) resumePolicy {
+	policy := resumePolicy{
+		Enabled:  boolConfig(config, "resume", true),
+		Retries:  defaultResumeRetries,
+		Chunks:   min(int(numberConfig(config, "chunks", 1)), maxDownloadChunks),
+		ChunkMin: int64(numberConfig(config, "chunkMinSize", defaultChunkMinSize)),
+	}
+	if retries, ok := config["resumeRetries"].(float64); ok && retries >= 0 {
+		policy.Retries = int(retries)
+	}
+	if !policy.Enabled {
+		policy.Retries = 0
+	}
+	return policy
+}
+
+// FAIL FOR RESUME SETTINGS OUT OF RANGE
+func validateResumeConfig(config map[string]any) error {
+	if chunks, ok := config["chunks"].(float64); ok && (chunks < 1 || chunks > maxDownloadChunks) {
+		return fmt.Errorf("%w: chunks MUST BE BETWEEN 1 AND %d", ErrInvalidInput, maxDownloadChunks)
+	}
+	if retries, ok := config["resumeRetries"].(float64); ok && retries < 0 {
+		return fmt.Errorf("%w: resumeRetries CAN'T BE NEGATIVE", ErrInvalidInput)
+	}
+	return nil
+}
+
+// ONE BYTE RANGE OF A CHUNKED DOWNLOAD
+type downloadChunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`  // INCLUSIVE
+	Done  int64 `json:"done"` // BYTES WRITTEN FROM Start
+}
+
+func (c downloadChunk) remaining() int64 {
+	return c.End - c.Start + 1 - c.Done
+}
+
+// A DOWNLOAD'S PART FILE AND WHAT'S KNOWN ABOUT IT
+type partialDownload struct {
+	db      *gorm.DB
+	mu      sync.Mutex
+	row     models.PartialDownload
+	chunks  []downloadChunk
+	persist bool // FALSE WHEN resume IS OFF OR THE URL IS ALREADY DOWNLOADING
+	whole   bool // THE BODY CAME DECOMPRESSED, SO ITS OFFSETS CAN'T BE RANGE-REQUESTED
+	release func()
+}
+
+// WHERE A JOB'S UNFINISHED DOWNLOADS KEEP THEIR PART FILES (NEVER THE SHARED partials DIRECTORY ITSELF)
+func PartialsDir(cfg *config.Config, jobID string) string {
+	return filepath.Join(cfg.DataPath, "partials", cmp.Or(utils.SafeFilename(jobID), "_"))
+}
+
+// THE PART FILE A JOB'S DOWNLOAD OF A URL WRITES TO, HOLDING WHATEVER AN EARLIER ATTEMPT OR RUN
+// LEFT OF IT. CALL close ONCE THE DOWNLOAD IS OVER
+func (e *Engine) openPartial(jobID, rawURL string, resume bool) (*partialDownload, error) {
+	dir := PartialsDir(e.cfg, jobID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("FAILED TO CREATE DIRECTORY: %v", err)
+	}
+	sum := sha256.Sum256([]byte(jobID + "\n" + rawURL))
+	id := hex.EncodeToString(sum[:16])
+	p := &partialDownload{
+		db:      e.db,
+		row:     models.PartialDownload{ID: id, JobID: jobID, URL: rawURL, PartPath: filepath.Join(dir, id+".part"), Total: -1},
+		persist: resume,
+		release: func() {},
+	}
+
+	// THE SAME URL DOWNLOADING TWICE AT ONCE: THE SECOND COPY GETS A THROWAWAY PART FILE
+	if !e.assetPaths.claimIdle(p.row.PartPath) {
+		p.row.ID = ""
+		p.row.PartPath = filepath.Join(dir, utils.GenerateID("part")+".part")
+		p.persist = false
+		return p, nil
+	}
+	p.release = func() { e.assetPaths.release(filepath.Join(dir, id+".part")) }
+	if !resume {
+		p.discard()
+		return p, nil
+	}
+
+	var row models.PartialDownload
+	if found := e.db.Where("id = ?", id).Limit(1).Find(&row); found.Error != nil || found.RowsAffected == 0 {
+		os.Remove(p.row.PartPath) // NOTHING RECORDED ABOUT IT, SO NOTHING TO TRUST
+		return p, nil
+	}
+	info, err := os.Stat(row.PartPath)
+	if err != nil || (row.ETag == "" && row.LastModified == "" && row.Total < 0) {
+		// FILE GONE, OR NO WAY TO TELL WHETHER THE SERVER'S COPY CHANGED SINCE
+		p.discard()
+		return p, nil
+	}
+	p.row = row
+	if len(row.Chunks) > 0 {
+		json.Unmarshal(row.Chunks, &p.chunks)
+	}
+	if len(p.chunks) == 0 {
+		p.row.Written = info.Size()
+	}
+	return p, nil
+}
+
+// AFTER THE LAST ATTEMPT: KEEP A FAILED DOWNLOAD'S BYTES FOR NEXT TIME, OR CLEAN UP
+func (p *partialDownload) close(err error) {
+	defer p.release()
+	if err != nil && p.persist && !p.whole && p.written() > 0 {
+		p.save()
+		return
+	}
+	p.discard()
+}
+
+// MOVE THE FINISHED PART FILE TO filePath AND FORGET IT
+func (p *partialDownload) complete(filePath string) error {
+	if err := moveFile(p.row.PartPath, filePath); err != nil {
+		return fmt.Errorf("FAILED TO MOVE DOWNLOAD INTO PLACE: %v", err)
+	}
+	p.forget()
+	return nil
+}
+
+// DELETE THE PART FILE AND ITS ROW
+func (p *partialDownload) discard() {
+	os.Remove(p.row.PartPath)
+	p.forget()
+}
+
+func (p *partialDownload) forget() {
+	if p.row.ID != "" {
+		p.db.Delete(&models.PartialDownload{}, "id = ?", p.row.ID)
+	}
+}
+
+// RECORD HOW FAR THE DOWNLOAD GOT
+func (p *partialDownload) save() {
+	if !p.persist || p.whole {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.row.Chunks = nil
+	if len(p.chunks) > 0 {
+		p.row.Chunks, _ = json.Marshal(p.chunks)
+		p.row.Written = 0
+		for _, chunk := range p.chunks {
+			p.row.Written += chunk.Done
+		}
+	} else {
+		p.row.Written = fileSize(p.row.PartPath)
+	}
+	if err := p.db.Save(&p.row).Error; err != nil {
+		log.Printf("FAILED TO SAVE PARTIAL DOWNLOAD OF %s: %v", p.row.URL, err)
+	}
+}
+
+// START THE PART FILE OVER FOR A RESPONSE CARRYING THE WHOLE FILE
+func (p *partialDownload) restart(resp *http.Response) error {
+	p.mu.Lock()
+	p.row.ETag = resp.Header.Get("ETag")
+	p.row.LastModified = resp.Header.Get("Last-Modified")
+	p.row.ContentType = resp.Header.Get("Content-Type")
+	p.row.Total = resp.ContentLength
+	p.row.Written = 0
+	p.chunks = nil
+	p.whole = resp.Uncompressed
+	p.mu.Unlock()
+
+	file, err := os.Create(p.row.PartPath)
+	if err != nil {
+		return fmt.Errorf("FAILED TO CREATE FILE: %v", err)
+	}
+	file.Close()
+	p.save()
+	return nil
+}
+
+// FORGET WHAT THE PART FILE HOLDS SO THE NEXT ATTEMPT STARTS FROM NOTHING
+func (p *partialDownload) reset() {
+	p.mu.Lock()
+	p.row.ETag, p.row.LastModified, p.row.Total, p.row.Written = "", "", -1, 0
+	p.chunks = nil
+	p.mu.Unlock()
+	os.Truncate(p.row.PartPath, 0)
+}
+
+// LAY OUT n CHUNKS OVER THE WHOLE FILE
+func (p *partialDownload) split(n int) error {
+	p.mu.Lock()
+	total := p.row.Total
+	size := (total + int64(n) - 1) / int64(n)
+	p.chunks = nil
+	for start := int64(0); start < total; start += size {
+		p.chunks = append(p.chunks, downloadChunk{Start: start, End: min(start+size, total) - 1})
+	}
+	p.mu.Unlock()
+
+	// CHUNKS WRITE AT THEIR OFFSETS, SO THE FILE TAKES ITS FULL LENGTH UP FRONT
+	if err := os.Truncate(p.row.PartPath, total); err != nil {
+		return fmt.Errorf("FAILED TO SIZE PART FILE: %v", err)
+	}
+	p.save()
+	return nil
+}
+
+func (p *partialDownload) chunked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.chunks) > 0
+}
+
+// WHERE A SINGLE-STREAM DOWNLOAD CONTINUES FROM (CHUNKED ONES CONTINUE PER CHUNK)
+func (p *partialDownload) offset() int64 {
+	if p.chunked() || p.whole {
+		return 0
+	}
+	return fileSize(p.row.PartPath)
+}
+
+// BYTES ON DISK SO FAR
+func (p *partialDownload) written() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.chunks) == 0 {
+		return fileSize(p.row.PartPath)
+	}
+	var done int64
+	for _, chunk := range p.chunks {
+		done += chunk.Done
+	}
+	return done
+}
+
+// THE If-Range VALIDATOR: A STRONG ETag, ELSE Last-Modified
+func (p *partialDownload) validator() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.row.ETag != "" && !strings.HasPrefix(p.row.ETag, "W/") {
+		return p.row.ETag
+	}
+	return p.row.LastModified
+}
+
+// WHETHER A WHOLE RESPONSE IS STILL THE FILE A CHUNKED PART FILE HOLDS PIECES OF
+func (p *partialDownload) matches(resp *http.Response) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case resp.ContentLength != p.row.Total:
+		return false
+	case p.row.ETag != "":
+		return resp.Header.Get("ETag") == p.row.ETag
+	case p.row.LastModified != "":
+		return resp.Header.Get("Last-Modified") == p.row.LastModified
+	}
+	return false
+}
+
+func (p *partialDownload) chunk(i int) downloadChunk {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.chunks[i]
+}
+
+func (p *partialDownload) advance(i int, n int64) {
+	p.mu.Lock()
+	p.chunks[i].Done += n
+	p.mu.Unlock()
+}
+
+// ONE SOURCE'S DOWNLOAD INTO ITS PART FILE
+type rangedFetch struct {
+	ctx        *TaskContext
+	client     *http.Client
+	header     http.Header
+	authName   string
+	redirects  RedirectPolicy
+	transfer   *Transfer
+	partial    *partialDownload
+	policy     resumePolicy
+	overall    *Deadline      // THE ABSOLUTE CAP, ACROSS ATTEMPTS
+	perAttempt DeadlinePolicy // CONNECT AND STALL TIMEOUTS OF EACH REQUEST
+}
+
+// WRITE THE FIRST RESPONSE TO THE PART FILE, THEN KEEP ASKING FOR WHAT'S MISSING AFTER DROPPED
+// CONNECTIONS UNTIL THE FILE IS WHOLE OR THE RETRIES RUN OUT. RETURNS THE FILE'S SIZE
+func (f *rangedFetch) run(resp *http.Response, deadline *Deadline) (int64, error) {
+	source := resp.Request.URL.String() // LATER REQUESTS SKIP THE REDIRECTS
+	err := f.receive(resp, deadline)
+	for attempt := 1; err != nil; attempt++ {
+		if attempt > f.policy.Retries || !f.resumable(err) {
+			return 0, err
+		}
+		offset := f.partial.written()
+		f.ctx.Logger.Printf("DOWNLOAD OF %s FAILED WITH %d BYTES WRITTEN, RESUMING (%d OF %d): %v", source, offset, attempt, f.policy.Retries, err)
+		f.partial.save()
+		f.transfer.Resume(offset, err)
+		err = f.resume(source)
+	}
+	return fileSize(f.partial.row.PartPath), nil
+}
+
+// WHETHER A FAILED ATTEMPT IS WORTH ANOTHER: DROPPED CONNECTIONS, STALLS AND SERVER ERRORS ARE; A
+// CANCELLED JOB, THE ABSOLUTE CAP AND CLIENT ERRORS ARE NOT
+func (f *rangedFetch) resumable(err error) bool {
+	if f.overall.Context().Err() != nil {
+		return false
+	}
+	var statusErr *downloadStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// ONE MORE ATTEMPT AT WHAT THE PART FILE STILL LACKS
+func (f *rangedFetch) resume(source string) error {
+	if f.partial.chunked() {
+		return f.fetchChunks(source)
+	}
+	deadline := f.perAttempt.Start(f.overall.Context())
+	defer deadline.Stop()
+	resp, err := f.request(deadline.Context(), source, f.partial.offset(), -1)
+	if err != nil {
+		return fmt.Errorf("REQUEST FAILED: %w", deadline.Err(err))
+	}
+	defer resp.Body.Close()
+	deadline.Connected()
+	return f.receive(resp, deadline)
+}
+
+// GET source (WITH THE TASK'S AUTH PROFILE, IF ANY), ASKING FOR BYTES start TO end (-1 FOR THE
+// REST) UNLESS THAT'S THE WHOLE FILE. If-Range MAKES A CHANGED FILE COME BACK WHOLE INSTEAD
+func (f *rangedFetch) request(ctx context.Context, source string, start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = f.header.Clone()
+	if start > 0 || end >= 0 {
+		if end >= 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+		}
+		if validator := f.partial.validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+
+	// REDIRECTS STILL FOLLOW THE TASK'S POLICY, BUT ONLY THE FIRST REQUEST'S CHAIN IS REPORTED
+	client := *f.client
+	var chain []string
+	client.CheckRedirect = f.redirects.CheckRedirect(&chain)
+	return f.ctx.Engine.doWithAuth(f.ctx, &client, f.authName, req)
+}
+
+// WRITE A RESPONSE TO THE PART FILE: THE REST OF THE FILE FOR A 206, ALL OF IT (STARTING OVER) FOR
+// A 200, OR NOTHING AT ALL WHEN IT'S LARGE ENOUGH TO SPLIT AND THE SERVER TAKES RANGES
+func (f *rangedFetch) receive(resp *http.Response, deadline *Deadline) error {
+	offset := f.partial.offset()
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// THE PART FILE IS ALREADY WHOLE, OR THE FILE SHRANK
+		if _, _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && total == offset {
+			f.transfer.Begin(total)
+			return nil
+		}
+		f.partial.reset()
+		return errRangeRestart
+
+	case resp.StatusCode == http.StatusPartialContent:
+		start, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		known := f.partial.row.Total
+		if !ok || start != offset || (total >= 0 && known >= 0 && total != known) {
+			f.partial.reset()
+			return errRangeRestart
+		}
+		f.transfer.Begin(total)
+		return f.append(resp, deadline)
+
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return &downloadStatusError{StatusCode: resp.StatusCode}
+	}
+
+	// A WHOLE BODY. A CHUNKED PART FILE OF THE SAME FILE CARRIES ON; ANYTHING ELSE STARTS OVER
+	source := resp.Request.URL.String()
+	if f.partial.chunked() && f.partial.matches(resp) {
+		resp.Body.Close()
+		return f.fetchChunks(source)
+	}
+	if offset > 0 || f.partial.chunked() {
+		f.ctx.Logger.Printf("SERVER SENT ALL OF %s, DISCARDING %d BYTES ALREADY DOWNLOADED", source, f.partial.written())
+		f.transfer.Resume(0, nil)
+	}
+	if err := f.partial.restart(resp); err != nil {
+		return err
+	}
+	if f.policy.Chunks > 1 && resp.ContentLength >= f.policy.ChunkMin && !resp.Uncompressed &&
+		resp.Header.Get("Accept-Ranges") == "bytes" {
+		resp.Body.Close()
+		if err := f.partial.split(f.policy.Chunks); err != nil {
+			return err
+		}
+		f.ctx.Logger.Printf("SPLITTING %s (%d BYTES) INTO %d CHUNKS", source, resp.ContentLength, f.policy.Chunks)
+		return f.fetchChunks(source)
+	}
+	f.transfer.Begin(resp.ContentLength)
+	return f.append(resp, deadline)
+}
+
+// APPEND A BODY TO THE PART FILE
+func (f *rangedFetch) append(resp *http.Response, deadline *Deadline) error {
+	file, err := os.OpenFile(f.partial.row.PartPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("FAILED TO CREATE FILE: %v", err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, f.transfer.Reader(deadline.Reader(resp.Body))); err != nil {
+		return fmt.Errorf("FAILED TO DOWNLOAD FILE: %w", deadline.Err(err))
+	}
+	return nil
+}
+
+// FETCH THE CHUNKS THAT AREN'T DONE, IN PARALLEL, EACH FROM WHERE IT GOT TO. THEY SHARE THE
+// DOWNLOAD'S SLOT, SO chunks CAPS ONE DOWNLOAD'S CONNECTIONS WITHOUT TAKING OTHERS' SLOTS
+func (f *rangedFetch) fetchChunks(source string) error {
+	file, err := os.OpenFile(f.partial.row.PartPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("FAILED TO OPEN PART FILE: %v", err)
+	}
+	defer file.Close()
+
+	f.partial.mu.Lock()
+	count := len(f.partial.chunks)
+	total := f.partial.row.Total
+	f.partial.mu.Unlock()
+	f.transfer.Split(count)
+	f.transfer.Begin(total)
+
+	// SAVE PROGRESS NOW AND THEN SO A CRASH COSTS LITTLE
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(partialSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				f.partial.save()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, count)
+	for i := range count {
+		if f.partial.chunk(i).remaining() <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.fetchChunk(source, file, i)
+		}()
+	}
+	wg.Wait()
+
+	err = errors.Join(errs...)
+	if errors.Is(err, errRangeRestart) {
+		f.partial.reset()
+		return errRangeRestart
+	}
+	return err
+}
+
+// FETCH WHAT ONE CHUNK STILL LACKS
+func (f *rangedFetch) fetchChunk(source string, file *os.File, i int) error {
+	chunk := f.partial.chunk(i)
+	start := chunk.Start + chunk.Done
+
+	deadline := f.perAttempt.Start(f.overall.Context())
+	defer deadline.Stop()
+	resp, err := f.request(deadline.Context(), source, start, chunk.End)
+	if err != nil {
+		return fmt.Errorf("REQUEST FAILED: %w", deadline.Err(err))
+	}
+	defer resp.Body.Close()
+	deadline.Connected()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return errRangeRestart // THE FILE CHANGED, OR THE SERVER STOPPED TAKING RANGES
+	case resp.StatusCode != http.StatusPartialContent:
+		return &downloadStatusError{StatusCode: resp.StatusCode}
+	}
+	if got, _, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || got != start {
+		return errRangeRestart
+	}
+
+	body := io.LimitReader(f.transfer.Reader(deadline.Reader(resp.Body)), chunk.End-start+1)
+	if _, err := io.Copy(&chunkWriter{file: file, partial: f.partial, index: i, offset: start}, body); err != nil {
+		return fmt.Errorf("FAILED TO DOWNLOAD CHUNK %d: %w", i+1, deadline.Err(err))
+	}
+	if f.partial.chunk(i).remaining() > 0 {
+		return fmt.Errorf("FAILED TO DOWNLOAD CHUNK %d: %w", i+1, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// WRITER THAT FILLS IN ONE CHUNK OF THE PART FILE, COUNTING WHAT IT WROTE
+type chunkWriter struct {
+	file    *os.File
+	partial *partialDownload
+	index   int
+	offset  int64
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	n, err := w.file.WriteAt(b, w.offset)
+	w.offset += int64(n)
+	w.partial.advance(w.index, int64(n))
+	return n, err
+}
+
+// PARSE "bytes start-end/total" (OR "bytes */total" ON A 416, GIVING A start OF -1). total IS -1
+// WHEN THE SERVER DOESN'T KNOW IT
+func parseContentRange(value string) (start, end, total int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(value), "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	span, size, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	total = -1
+	if size != "*" {
+		if total, ok = parseByteOffset(size); !ok {
+			return 0, 0, 0, false
+		}
+	}
+	if span == "*" {
+		return -1, -1, total, true
+	}
+	first, last, found := strings.Cut(span, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	if start, ok = parseByteOffset(first); !ok {
+		return 0, 0, 0, false
+	}
+	if end, ok = parseByteOffset(last); !ok || end < start {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
+
+func parseByteOffset(value string) (int64, bool) {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	return n, err == nil && n >= 0
+}
+
+// RENAME A FILE, COPYING IT WHEN THE TWO PATHS ARE ON DIFFERENT FILESYSTEMS
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+	in.Close()
+	return os.Remove(from)
+}
+
+// DELETE PART FILES (AND THEIR ROWS) NO DOWNLOAD HAS TOUCHED IN stalePartialAge, AND PART FILES
+// WITH NO ROW AT ALL
+func (e *Engine) pruneStalePartials() (removed, freed int64, err error) {
+	cutoff := time.Now().Add(-stalePartialAge)
+	var stale []models.PartialDownload
+	if err := e.db.Where("updated_at < ?", cutoff).Find(&stale).Error; err != nil {
+		return 0, 0, err
+	}
+	for _, row := range stale {
+		if !e.assetPaths.claimIdle(row.PartPath) {
+			continue // RESUMED SINCE THE QUERY
+		}
+		freed += fileSize(row.PartPath)
+		os.Remove(row.PartPath)
+		e.db.Delete(&models.PartialDownload{}, "id = ?", row.ID)
+		e.assetPaths.release(row.PartPath)
+		removed++
+	}
+
+	var paths []string
+	if err := e.db.Model(&models.PartialDownload{}).Pluck("part_path", &paths).Error; err != nil {
+		return removed, freed, err
+	}
+	known := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		known[filepath.Clean(path)] = true
+	}
+	files, _ := filepath.Glob(filepath.Join(e.cfg.DataPath, "partials", "*", "*.part"))
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil || known[filepath.Clean(path)] || info.ModTime().After(cutoff) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			removed++
+			freed += info.Size()
+		}
+	}
+	return removed, freed, nil
+}
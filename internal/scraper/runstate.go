@@ -0,0 +1,238 @@
+package scraper
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// -- RUN STATE --
+//
+// A KEY-VALUE STORE THAT LIVES FOR ONE RUN, SHARED BY EVERY TASK (WORKERS INCLUDED), SO A PIPELINE
+// CAN COUNT PROCESSED ITEMS OR ACCUMULATE TOTALS WITH setState, getState AND incrementState. THE
+// VALUES SHOW IN THE RUN'S PROGRESS AS state, AND STAY THERE ONCE THE RUN ENDS. WITH scope "job"
+// THE SAME TASKS USE THE JOB'S PERSISTENT STATE INSTEAD, WHICH OUTLIVES THE RUN.
+
+// WHERE THE STATE TASKS READ AND WRITE
+type stateStore interface {
+	get(key string) (any, bool, error)
+	set(key string, value any) error
+	increment(key string, by float64) (float64, error)
+}
+
+// ONE RUN'S VALUES
+type runState struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+func newRunState() *runState {
+	return &runState{values: make(map[string]any)}
+}
+
+func (s *runState) get(key string) (any, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok, nil
+}
+
+func (s *runState) set(key string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+// ADD by TO A NUMBER (UNSET COUNTS AS 0), RETURNING THE NEW VALUE
+func (s *runState) increment(key string, by float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := 0.0
+	if value, ok := s.values[key]; ok && value != nil {
+		n, isNumber := value.(float64)
+		if !isNumber {
+			return 0, fmt.Errorf("STATE %q IS %s, NOT A NUMBER", key, validation.ValueTypeName(value))
+		}
+		current = n
+	}
+	current += by
+	s.values[key] = current
+	return current, nil
+}
+
+func (s *runState) snapshot() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) == 0 {
+		return nil
+	}
+	return maps.Clone(s.values)
+}
+
+// THE RUNNING JOB'S STATE
+func (e *Engine) runState(jobID string) (stateStore, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state, ok := e.runStates[jobID]
+	if !ok {
+		return nil, fmt.Errorf("JOB %s ISN'T RUNNING", jobID)
+	}
+	return state, nil
+}
+
+// THE STORE A STATE TASK'S scope NAMES: THE RUN'S (THE DEFAULT) OR THE JOB'S
+func taskStateStore(ctx *TaskContext, config map[string]any) (stateStore, error) {
+	scope, _ := config["scope"].(string)
+	switch scope {
+	case "", "run":
+		return ctx.Engine.runState(ctx.JobID)
+	case "job":
+		ctx.Engine.mu.Lock()
+		runID := ctx.Engine.jobProgress[ctx.JobID].RunID
+		ctx.Engine.mu.Unlock()
+		return &jobStateStore{engine: ctx.Engine, jobID: ctx.JobID, runID: runID}, nil
+	}
+	return nil, fmt.Errorf("UNKNOWN STATE SCOPE %q (EXPECTED run OR job)", scope)
+}
+
+// THE key AND scope INPUTS
+func validateStateConfig(config map[string]any) error {
+	key, ok := config["key"].(string)
+	if !ok || key == "" {
+		return ErrMissingRequiredInput
+	}
+	if err := ValidateStateKey(key); err != nil {
+		return err
+	}
+	if scope, ok := config["scope"].(string); ok && scope != "" && scope != "run" && scope != "job" {
+		return fmt.Errorf("UNKNOWN STATE SCOPE %q (EXPECTED run OR job)", scope)
+	}
+	return nil
+}
+
+// SET STATE TASK
+type SetStateTask struct{}
+
+func (t *SetStateTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"key":   "string",  // REQUIRED
+		"value": "any?",    // OPTIONAL (DEFAULTS TO THE OUTPUT OF THE FIRST inputRef, OR NULL)
+		"scope": "string?", // OPTIONAL (run OR job; DEFAULTS TO run)
+	}
+}
+
+func (t *SetStateTask) GetOutputSchema() string {
+	return "any" // RETURNS THE VALUE SET
+}
+
+func (t *SetStateTask) ValidateConfig(config map[string]any) error {
+	return validateStateConfig(config)
+}
+
+func (t *SetStateTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	key, _ := config["key"].(string)
+	state, err := taskStateStore(ctx, config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	value, ok := config["value"]
+	if !ok && len(ctx.Inputs) > 0 {
+		value = ctx.Inputs[0].Data.Value
+	}
+	if err := state.set(key, value); err != nil {
+		return TaskData{}, err
+	}
+	ctx.Logger.Printf("SET STATE %s", key)
+	return TaskData{Type: validation.ValueTypeName(value), Value: value}, nil
+}
+
+// GET STATE TASK
+type GetStateTask struct{}
+
+func (t *GetStateTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"key":     "string",  // REQUIRED
+		"default": "any?",    // OPTIONAL (RETURNED WHILE THE KEY IS UNSET; DEFAULTS TO NULL)
+		"scope":   "string?", // OPTIONAL (run OR job; DEFAULTS TO run)
+	}
+}
+
+func (t *GetStateTask) GetOutputSchema() string {
+	return "any" // RETURNS THE STORED VALUE
+}
+
+func (t *GetStateTask) ValidateConfig(config map[string]any) error {
+	return validateStateConfig(config)
+}
+
+func (t *GetStateTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	key, _ := config["key"].(string)
+	state, err := taskStateStore(ctx, config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	value, ok, err := state.get(key)
+	if err != nil {
+		return TaskData{}, err
+	}
+	if !ok {
+		value = config["default"]
+	}
+	return TaskData{Type: validation.ValueTypeName(value), Value: value}, nil
+}
+
+// INCREMENT STATE TASK
+type IncrementStateTask struct{}
+
+func (t *IncrementStateTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"key":   "string",  // REQUIRED
+		"by":    "number?", // OPTIONAL (NEGATIVE TO DECREMENT; DEFAULTS TO THE FIRST inputRef: A NUMBER'S VALUE OR AN ARRAY'S LENGTH, ELSE 1)
+		"scope": "string?", // OPTIONAL (run OR job; DEFAULTS TO run)
+	}
+}
+
+func (t *IncrementStateTask) GetOutputSchema() string {
+	return "number" // RETURNS THE NEW VALUE
+}
+
+func (t *IncrementStateTask) ValidateConfig(config map[string]any) error {
+	if err := validateStateConfig(config); err != nil {
+		return err
+	}
+	if by, ok := config["by"]; ok {
+		if _, isNumber := by.(float64); !isNumber {
+			return fmt.Errorf("BY MUST BE A NUMBER")
+		}
+	}
+	return nil
+}
+
+func (t *IncrementStateTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	key, _ := config["key"].(string)
+	state, err := taskStateStore(ctx, config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	by := 1.0
+	if n, ok := config["by"].(float64); ok {
+		by = n
+	} else if len(ctx.Inputs) > 0 {
+		// COUNT WHAT THE INPUT TASK PRODUCED, OR ADD ITS TOTAL
+		switch v := ctx.Inputs[0].Data.Value.(type) {
+		case float64:
+			by = v
+		case []any:
+			by = float64(len(v))
+		}
+	}
+	value, err := state.increment(key, by)
+	if err != nil {
+		return TaskData{}, err
+	}
+	ctx.Logger.Printf("STATE %s IS NOW %v", key, value)
+	return TaskData{Type: "number", Value: value}, nil
+}
@@ -0,0 +1,330 @@
+package scraper
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- RUNTIME ENVIRONMENT --
+//
+// WHERE CREPES IS RUNNING DECIDES HOW CHROMIUM CAN BE STARTED: INSIDE A CONTAINER, OR AS
+// ROOT, ITS SANDBOX USUALLY CAN'T WORK, AND DOCKER'S DEFAULT 64MB /dev/shm CRASHES TABS.
+// THE SAME CHECKS DRIVE THE BROWSER LAUNCH AND THE /api/system/environment REPORT.
+
+// SETTINGS FOR browserSandbox
+const (
+	sandboxAuto = "auto"
+	sandboxOn   = "on"
+	sandboxOff  = "off"
+)
+
+// BELOW THIS, CHROMIUM IS TOLD TO KEEP SHARED MEMORY IN /tmp INSTEAD OF /dev/shm
+const minSharedMemory = 512 << 20
+
+// THE PROCESS'S SURROUNDINGS
+type RuntimeEnvironment struct {
+	OS           string             `json:"os"`
+	Arch         string             `json:"arch"`
+	CPUs         int                `json:"cpus"`
+	GoVersion    string             `json:"goVersion"`
+	UID          int                `json:"uid"` // -1 ON WINDOWS
+	Root         bool               `json:"root"`
+	Container    ContainerInfo      `json:"container"`
+	Sandbox      SandboxInfo        `json:"sandbox"`
+	SharedMemory SharedMemoryInfo   `json:"sharedMemory"`
+	Browser      BrowserInstallInfo `json:"browser"`
+	MediaTools   utils.MediaTools   `json:"mediaTools"`
+	Warnings     []string           `json:"warnings"`
+}
+
+// WHETHER WE'RE IN A CONTAINER, AND WHAT GAVE IT AWAY
+type ContainerInfo struct {
+	Detected bool     `json:"detected"`
+	Runtime  string   `json:"runtime,omitempty"` // docker, podman, kubernetes, containerd, lxc
+	Signals  []string `json:"signals,omitempty"`
+}
+
+// WHETHER CHROMIUM RUNS WITH ITS SANDBOX, AND WHY
+type SandboxInfo struct {
+	Mode           string `json:"mode"` // THE browserSandbox SETTING
+	Enabled        bool   `json:"enabled"`
+	Reason         string `json:"reason"`
+	UserNamespaces bool   `json:"userNamespaces"` // UNPRIVILEGED USER NAMESPACES LOOK AVAILABLE
+}
+
+// SIZE OF /dev/shm
+type SharedMemoryInfo struct {
+	Known bool  `json:"known"`
+	Bytes int64 `json:"bytes,omitempty"`
+	Low   bool  `json:"low"`
+}
+
+// WHICH BROWSER IS LAUNCHED AND WHERE PLAYWRIGHT KEEPS ITS FILES
+type BrowserInstallInfo struct {
+	Source            string `json:"source"` // config, env (CHROMIUM_PATH), manifest (userAgentUpdates) OR bundled
+	ExecutablePath    string `json:"executablePath,omitempty"`
+	ExecutableFound   bool   `json:"executableFound"`
+	DriverVersion     string `json:"driverVersion"`
+	DriverDirectory   string `json:"driverDirectory"`
+	DriverInstalled   bool   `json:"driverInstalled"`
+	BrowsersDirectory string `json:"browsersDirectory"`
+	BrowsersInstalled bool   `json:"browsersInstalled"`
+	Initialized       bool   `json:"initialized"`
+	Error             string `json:"error,omitempty"` // WHY PLAYWRIGHT LAST FAILED TO START
+}
+
+// LOOK AT THE ENVIRONMENT AND WORK OUT HOW TO RUN THE BROWSER
+func DetectRuntime(cfg *config.Config) RuntimeEnvironment {
+	env := RuntimeEnvironment{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		GoVersion: runtime.Version(),
+		UID:       os.Geteuid(),
+	}
+	env.Root = env.UID == 0
+	env.Container = detectContainer()
+	env.SharedMemory = detectSharedMemory()
+	env.Sandbox = resolveSandbox(cfg.BrowserSandbox, env)
+	env.Browser = browserInstall(cfg, env.Container.Detected)
+	env.MediaTools = utils.CurrentMediaTools()
+
+	if env.SharedMemory.Low {
+		env.Warnings = append(env.Warnings, fmt.Sprintf("/dev/shm is only %d MB; Chromium will use /tmp instead (run the container with --shm-size=1g to avoid this)", env.SharedMemory.Bytes>>20))
+	}
+	if env.Browser.ExecutablePath != "" && !env.Browser.ExecutableFound {
+		env.Warnings = append(env.Warnings, "browser executable "+env.Browser.ExecutablePath+" does not exist")
+	}
+	if env.Browser.Source == "bundled" && !env.Browser.BrowsersInstalled {
+		env.Warnings = append(env.Warnings, "Chromium is not installed yet; it will be downloaded to "+env.Browser.BrowsersDirectory+" on first start")
+	}
+	if env.Sandbox.Mode == sandboxOn && !env.Sandbox.UserNamespaces && runtime.GOOS == "linux" {
+		env.Warnings = append(env.Warnings, "browserSandbox is on but user namespaces look unavailable; Chromium may fail to start")
+	}
+	if !env.Sandbox.Enabled && !env.Container.Detected {
+		env.Warnings = append(env.Warnings, "Chromium's sandbox is off outside a container: "+env.Sandbox.Reason)
+	}
+	return env
+}
+
+// KNOWN CONTAINER MARKERS; NONE IS CONCLUSIVE ON ITS OWN, SO EVERY ONE FOUND IS LISTED
+func detectContainer() ContainerInfo {
+	var info ContainerInfo
+	found := func(runtime, signal string) {
+		info.Detected = true
+		info.Runtime = cmp.Or(info.Runtime, runtime)
+		info.Signals = append(info.Signals, signal)
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		found("kubernetes", "KUBERNETES_SERVICE_HOST is set")
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		found("docker", "/.dockerenv exists")
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		found("podman", "/run/.containerenv exists")
+	}
+	if name := os.Getenv("container"); name != "" { // SET BY PODMAN, LXC AND systemd-nspawn
+		found(name, "container="+name)
+	}
+	if cgroup, err := os.ReadFile("/proc/1/cgroup"); err == nil {
+		for _, marker := range [][2]string{{"kubepods", "kubernetes"}, {"docker", "docker"}, {"containerd", "containerd"}, {"lxc", "lxc"}} {
+			if strings.Contains(string(cgroup), marker[0]) {
+				found(marker[1], "/proc/1/cgroup mentions "+marker[0])
+				break
+			}
+		}
+	}
+	return info
+}
+
+func detectSharedMemory() SharedMemoryInfo {
+	space, err := utils.DiskUsage("/dev/shm")
+	if err != nil {
+		return SharedMemoryInfo{}
+	}
+	size := int64(space.Total)
+	return SharedMemoryInfo{Known: true, Bytes: size, Low: size < minSharedMemory}
+}
+
+// WHETHER UNPRIVILEGED PROCESSES CAN CREATE USER NAMESPACES, WHICH THE LINUX SANDBOX NEEDS
+// WITHOUT A SETUID HELPER
+func userNamespacesAvailable() bool {
+	for file, disabled := range map[string]string{
+		"/proc/sys/kernel/unprivileged_userns_clone":             "0", // DEBIAN KERNELS
+		"/proc/sys/user/max_user_namespaces":                     "0",
+		"/proc/sys/kernel/apparmor_restrict_unprivileged_userns": "1", // UBUNTU 23.10+
+	} {
+		if value, err := os.ReadFile(file); err == nil && strings.TrimSpace(string(value)) == disabled {
+			return false
+		}
+	}
+	_, err := os.Stat("/proc/self/ns/user")
+	return err == nil
+}
+
+// APPLY THE browserSandbox SETTING; auto TURNS THE SANDBOX OFF WHERE IT WOULD KEEP CHROMIUM
+// FROM STARTING
+func resolveSandbox(mode string, env RuntimeEnvironment) SandboxInfo {
+	info := SandboxInfo{Mode: cmp.Or(strings.ToLower(mode), sandboxAuto), UserNamespaces: true}
+	if env.OS == "linux" {
+		info.UserNamespaces = userNamespacesAvailable()
+	}
+	switch info.Mode {
+	case sandboxOn:
+		info.Enabled, info.Reason = true, "browserSandbox is on"
+		return info
+	case sandboxOff:
+		info.Enabled, info.Reason = false, "browserSandbox is off"
+		return info
+	case sandboxAuto:
+	default:
+		log.Printf("WARNING: UNKNOWN browserSandbox %q, USING auto", mode)
+		info.Mode = sandboxAuto
+	}
+	switch {
+	case env.OS != "linux":
+		info.Enabled, info.Reason = true, "the sandbox needs no special privileges on "+env.OS
+	case env.Root:
+		info.Enabled, info.Reason = false, "running as root, where Chromium refuses to start its sandbox"
+	case !info.UserNamespaces:
+		info.Enabled, info.Reason = false, "unprivileged user namespaces are disabled"
+	case env.Container.Detected:
+		info.Enabled, info.Reason = false, "container seccomp profiles usually block the namespaces the sandbox needs (set browserSandbox to on if yours allows them)"
+	default:
+		info.Enabled, info.Reason = true, "user namespaces are available"
+	}
+	return info
+}
+
+// THE BROWSER TO LAUNCH AND PLAYWRIGHT'S DIRECTORIES. browserCacheDir HOLDS BOTH THE DRIVER
+// AND THE BROWSERS; IN A CONTAINER IT DEFAULTS TO THE DATA DIRECTORY SO A MOUNTED VOLUME
+// KEEPS THE DOWNLOAD ACROSS RESTARTS
+func browserInstall(cfg *config.Config, inContainer bool) BrowserInstallInfo {
+	info := BrowserInstallInfo{Source: "bundled"}
+	if cfg.BrowserPath != "" {
+		info.Source, info.ExecutablePath = "config", cfg.BrowserPath
+	} else if path := os.Getenv("CHROMIUM_PATH"); path != "" {
+		info.Source, info.ExecutablePath = "env", path
+	} else if cfg.UserAgentUpdates.UpdateBrowsers {
+		if installed, ok := installedManifestBrowser(cfg.DataPath); ok {
+			info.Source, info.ExecutablePath = "manifest", installed.Executable
+		}
+	}
+	if info.ExecutablePath != "" {
+		if path, err := exec.LookPath(info.ExecutablePath); err == nil {
+			info.ExecutablePath, info.ExecutableFound = path, true
+		}
+	}
+
+	if driver, err := playwright.NewDriver(&playwright.RunOptions{}); err == nil {
+		info.DriverVersion = driver.Version
+	}
+	cacheDir := cfg.BrowserCacheDir
+	if cacheDir == "" && inContainer {
+		cacheDir = filepath.Join(cfg.DataPath, "playwright")
+	}
+	if cacheDir != "" {
+		cacheDir, _ = filepath.Abs(cacheDir)
+		info.DriverDirectory = filepath.Join(cacheDir, "driver", info.DriverVersion)
+		info.BrowsersDirectory = filepath.Join(cacheDir, "browsers")
+	} else {
+		// PLAYWRIGHT'S OWN DEFAULTS
+		home, _ := os.UserHomeDir()
+		userCache, _ := os.UserCacheDir()
+		if runtime.GOOS == "linux" {
+			userCache = filepath.Join(home, ".cache") // PLAYWRIGHT IGNORES XDG_CACHE_HOME
+		}
+		info.DriverDirectory = cmp.Or(os.Getenv("PLAYWRIGHT_DRIVER_PATH"), filepath.Join(userCache, "ms-playwright-go", info.DriverVersion))
+		info.BrowsersDirectory = cmp.Or(os.Getenv("PLAYWRIGHT_BROWSERS_PATH"), filepath.Join(userCache, "ms-playwright"))
+	}
+	_, err := os.Stat(filepath.Join(info.DriverDirectory, "package", "cli.js"))
+	info.DriverInstalled = err == nil
+	installed, _ := filepath.Glob(filepath.Join(info.BrowsersDirectory, "chromium*-*"))
+	info.BrowsersInstalled = len(installed) > 0
+	return info
+}
+
+// INSTALL WHAT'S MISSING AND START PLAYWRIGHT: ONLY CHROMIUM IS DOWNLOADED, AND NOTHING
+// BUT THE DRIVER WHEN A BROWSER EXECUTABLE IS CONFIGURED
+func startPlaywright(env RuntimeEnvironment) (*playwright.Playwright, error) {
+	info := env.Browser
+	if os.Getenv("PLAYWRIGHT_BROWSERS_PATH") != info.BrowsersDirectory {
+		os.Setenv("PLAYWRIGHT_BROWSERS_PATH", info.BrowsersDirectory) // READ BY THE DRIVER PROCESS
+	}
+	options := &playwright.RunOptions{
+		DriverDirectory:     info.DriverDirectory,
+		Browsers:            []string{"chromium"},
+		SkipInstallBrowsers: info.ExecutablePath != "",
+		Verbose:             true,
+	}
+	if !info.DriverInstalled || (!options.SkipInstallBrowsers && !info.BrowsersInstalled) {
+		log.Printf("INSTALLING PLAYWRIGHT %s (DRIVER: %s, BROWSERS: %s)", info.DriverVersion, info.DriverDirectory, info.BrowsersDirectory)
+		if err := playwright.Install(options); err != nil {
+			return nil, fmt.Errorf("COULD NOT INSTALL PLAYWRIGHT: %v", err)
+		}
+	}
+	return playwright.Run(options)
+}
+
+// LAUNCH OPTIONS FOR CHROMIUM IN THIS ENVIRONMENT
+func launchOptions(env RuntimeEnvironment, headless bool) playwright.BrowserTypeLaunchOptions {
+	args := []string{
+		"--disable-gpu",
+		"--disable-blink-features=AutomationControlled",
+		"--disable-features=IsolateOrigins,site-per-process",
+		"--disable-site-isolation-trials",
+		"--ignore-certificate-errors",
+		"--disable-web-security",
+		"--allow-running-insecure-content",
+	}
+	if !env.SharedMemory.Known || env.SharedMemory.Low {
+		args = append(args, "--disable-dev-shm-usage")
+	}
+	options := playwright.BrowserTypeLaunchOptions{
+		Headless:        playwright.Bool(headless),
+		ChromiumSandbox: playwright.Bool(env.Sandbox.Enabled), // PLAYWRIGHT PASSES --no-sandbox WHEN FALSE
+		Args:            args,
+	}
+	if env.Browser.ExecutablePath != "" {
+		options.ExecutablePath = playwright.String(env.Browser.ExecutablePath)
+	}
+	return options
+}
+
+// LOG THE DECISIONS THAT AFFECT THE BROWSER
+func logRuntime(env RuntimeEnvironment) {
+	container := "NONE"
+	if env.Container.Detected {
+		container = strings.ToUpper(env.Container.Runtime)
+	}
+	log.Printf("RUNTIME: %s/%s, UID %d, CONTAINER %s, SANDBOX %v (%s)", env.OS, env.Arch, env.UID, container, env.Sandbox.Enabled, env.Sandbox.Reason)
+	if env.Browser.ExecutablePath != "" {
+		log.Printf("RUNTIME: USING BROWSER %s (FROM %s)", env.Browser.ExecutablePath, env.Browser.Source)
+	}
+	for _, warning := range env.Warnings {
+		log.Printf("WARNING: %s", warning)
+	}
+}
+
+// A FRESH REPORT WITH THE ENGINE'S PLAYWRIGHT STATE
+func (e *Engine) Environment() RuntimeEnvironment {
+	env := DetectRuntime(e.cfg)
+	e.initMu.Lock()
+	env.Browser.Initialized = e.initialized
+	if e.initErr != nil {
+		env.Browser.Error = e.initErr.Error()
+	}
+	e.initMu.Unlock()
+	return env
+}
@@ -1,9 +1,16 @@
 package scraper
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/nickheyer/Crepes/internal/database"
 	"github.com/nickheyer/Crepes/internal/models"
 	"github.com/robfig/cron/v3"
 	"gorm.io/gorm"
@@ -11,21 +18,25 @@ import (
 
 // JOB SCHEDULER
 type Scheduler struct {
-	db     *gorm.DB
-	engine *Engine
-	cron   *cron.Cron
-	jobs   map[string]cron.EntryID
-	mu     sync.Mutex
+	db      *gorm.DB
+	engine  *Engine
+	cron    *cron.Cron
+	jobs    map[string]cron.EntryID
+	folders map[string]cron.EntryID
+	upkeep  cron.EntryID // SCHEDULED MAINTENANCE, 0 WHEN OFF
+	agents  cron.EntryID // SCHEDULED USER AGENT CHECKS, 0 WHEN OFF
+	mu      sync.Mutex
 }
 
 // CREATE NEW SCHEDULER
 func NewScheduler(db *gorm.DB, engine *Engine) *Scheduler {
 	return &Scheduler{
-		db:     db,
-		engine: engine,
-		cron:   cron.New(),
-		jobs:   make(map[string]cron.EntryID),
-		mu:     sync.Mutex{},
+		db:      db,
+		engine:  engine,
+		cron:    cron.New(),
+		jobs:    make(map[string]cron.EntryID),
+		folders: make(map[string]cron.EntryID),
+		mu:      sync.Mutex{},
 	}
 }
 
@@ -43,7 +54,83 @@ func (s *Scheduler) Start() {
 		s.ScheduleJob(&job)
 	}
 
-	log.Printf("Job scheduler started with %d scheduled jobs", len(jobs))
+	// SCHEDULE EACH FOLDER
+	var folders []models.Folder
+	s.db.Where("schedule != ''").Find(&folders)
+	for _, folder := range folders {
+		s.ScheduleFolder(&folder)
+	}
+
+	s.scheduleMaintenance()
+	s.scheduleUserAgentUpdates()
+
+	log.Printf("Job scheduler started with %d scheduled jobs and %d scheduled folders", len(jobs), len(folders))
+}
+
+// RUN MAINTENANCE ON THE CONFIGURED SCHEDULE
+func (s *Scheduler) scheduleMaintenance() {
+	spec := s.engine.maintenanceSchedule()
+	if spec == "" {
+		log.Println("Scheduled maintenance is off")
+		return
+	}
+	entryID, err := s.cron.AddFunc(spec, func() {
+		_, err := s.engine.RunMaintenance(s.engine.scheduledMaintenanceTasks(), "schedule")
+		if err != nil {
+			log.Printf("Scheduled maintenance did not run: %v", err)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to schedule maintenance %q: %v", spec, err)
+		return
+	}
+	s.mu.Lock()
+	s.upkeep = entryID
+	s.mu.Unlock()
+}
+
+// WHEN SCHEDULED MAINTENANCE RUNS NEXT (ZERO WHEN IT IS OFF)
+func (s *Scheduler) NextMaintenance() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.upkeep == 0 {
+		return time.Time{}
+	}
+	return s.cron.Entry(s.upkeep).Next
+}
+
+// CHECK FOR A NEWER USER AGENT MANIFEST ON THE CONFIGURED SCHEDULE, AND ONCE AT START WHEN
+// NONE HAS BEEN LOADED YET
+func (s *Scheduler) scheduleUserAgentUpdates() {
+	if s.engine.cfg.UserAgentUpdates.URL != "" && CurrentUserAgents().Source == UserAgentsBundled {
+		go s.engine.UpdateUserAgents(context.Background(), "startup")
+	}
+	spec := s.engine.userAgentSchedule()
+	if spec == "" {
+		return
+	}
+	entryID, err := s.cron.AddFunc(spec, func() {
+		if _, err := s.engine.UpdateUserAgents(context.Background(), "schedule"); err != nil {
+			log.Printf("Scheduled user agent check did not run: %v", err)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to schedule user agent checks %q: %v", spec, err)
+		return
+	}
+	s.mu.Lock()
+	s.agents = entryID
+	s.mu.Unlock()
+}
+
+// WHEN THE NEXT SCHEDULED USER AGENT CHECK RUNS (ZERO WHEN IT IS OFF)
+func (s *Scheduler) NextUserAgentUpdate() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.agents == 0 {
+		return time.Time{}
+	}
+	return s.cron.Entry(s.agents).Next
 }
 
 // STOP THE SCHEDULER
@@ -74,7 +161,9 @@ func (s *Scheduler) ScheduleJob(job *models.Job) {
 	entryID, err := s.cron.AddFunc(job.Schedule, func() {
 		log.Printf("Running scheduled job: %s", job.ID)
 		err := s.engine.RunJob(job.ID)
-		if err != nil {
+		if errors.Is(err, ErrJobQueued) {
+			log.Printf("Scheduled job %s queued", job.ID)
+		} else if err != nil {
 			log.Printf("Failed to run scheduled job %s: %v", job.ID, err)
 		}
 	})
@@ -109,6 +198,51 @@ func (s *Scheduler) RemoveJob(jobID string) {
 	}
 }
 
+// SCHEDULE A FOLDER: EACH RUN STARTS EVERY JOB IN THE FOLDER AND ITS SUBFOLDERS
+func (s *Scheduler) ScheduleFolder(folder *models.Folder) {
+	s.RemoveFolder(folder.ID)
+	if folder.Schedule == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	folderID := folder.ID
+	entryID, err := s.cron.AddFunc(folder.Schedule, func() {
+		jobIDs, err := database.FolderJobIDs(s.db, folderID, true)
+		if err != nil {
+			log.Printf("Failed to load jobs for scheduled folder %s: %v", folderID, err)
+			return
+		}
+		log.Printf("Running scheduled folder %s (%d jobs)", folderID, len(jobIDs))
+		for _, jobID := range jobIDs {
+			err := s.engine.RunJob(jobID)
+			if err != nil && !errors.Is(err, ErrJobQueued) {
+				log.Printf("Failed to run job %s from folder %s: %v", jobID, folderID, err)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to schedule folder %s: %v", folderID, err)
+		return
+	}
+	s.folders[folderID] = entryID
+	log.Printf("Folder %s scheduled with cron: %s", folderID, folder.Schedule)
+}
+
+// REMOVE A FOLDER FROM THE SCHEDULER
+func (s *Scheduler) RemoveFolder(folderID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entryID, exists := s.folders[folderID]; exists {
+		s.cron.Remove(entryID)
+		delete(s.folders, folderID)
+		log.Printf("Folder %s removed from scheduler", folderID)
+	}
+}
+
 // UPDATE NEXT RUN TIMES FOR ALL JOBS
 func (s *Scheduler) UpdateNextRunTimes() {
 	s.mu.Lock()
@@ -121,3 +255,65 @@ func (s *Scheduler) UpdateNextRunTimes() {
 		}
 	}
 }
+
+// A SCHEDULED RUN
+type ScheduledRun struct {
+	JobID    string    `json:"jobId,omitempty"`
+	FolderID string    `json:"folderId,omitempty"` // SET FOR FOLDER SCHEDULES
+	NextRun  time.Time `json:"nextRun"`
+}
+
+// NEXT RUN OF EVERY SCHEDULED JOB, SOONEST FIRST
+func (s *Scheduler) Upcoming() []ScheduledRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := make([]ScheduledRun, 0, len(s.jobs))
+	for jobID, entryID := range s.jobs {
+		entry := s.cron.Entry(entryID)
+		if !entry.Next.IsZero() {
+			runs = append(runs, ScheduledRun{JobID: jobID, NextRun: entry.Next})
+		}
+	}
+	for folderID, entryID := range s.folders {
+		entry := s.cron.Entry(entryID)
+		if !entry.Next.IsZero() {
+			runs = append(runs, ScheduledRun{FolderID: folderID, NextRun: entry.Next})
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].NextRun.Before(runs[j].NextRun) })
+	return runs
+}
+
+// APPLY A TIMEZONE TO A CRON SPEC THE WAY THE CRON LIBRARY EXPECTS (CRON_TZ= PREFIX)
+func ScheduleWithTimezone(spec, timezone string) (string, error) {
+	spec = strings.TrimSpace(spec)
+	if timezone == "" {
+		return spec, nil
+	}
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		return "", fmt.Errorf("SCHEDULE ALREADY SETS A TIMEZONE")
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return "", fmt.Errorf("UNKNOWN TIMEZONE %q", timezone)
+	}
+	return "CRON_TZ=" + timezone + " " + spec, nil
+}
+
+// NEXT count RUN TIMES OF A CRON SPEC AFTER from, PARSED THE SAME WAY THE SCHEDULER PARSES IT
+func PreviewSchedule(spec string, from time.Time, count int) ([]time.Time, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]time.Time, 0, count)
+	next := from
+	for len(runs) < count {
+		next = schedule.Next(next)
+		if next.IsZero() {
+			break // E.G. FEBRUARY 30TH NEVER OCCURS
+		}
+		runs = append(runs, next)
+	}
+	return runs, nil
+}
@@ -0,0 +1,147 @@
+package scraper
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// -- SELECTORS --
+//
+// TASKS TAKE CSS SELECTORS UNLESS TOLD OTHERWISE. AN XPATH IS GIVEN WITH selectorType "xpath", AN
+// xpath= PREFIX, OR JUST BY STARTING IT WITH / OR ( (E.G. //a[@rel='next'] OR (//h2)[1]). BROWSER
+// TASKS HAND XPATHS TO PLAYWRIGHT'S xpath ENGINE; PAGES PARSED WITHOUT A BROWSER ARE QUERIED WITH
+// htmlquery.
+
+const (
+	SelectorCSS   = "css"
+	SelectorXPath = "xpath"
+)
+
+// A SELECTOR AND THE LANGUAGE IT'S WRITTEN IN
+type selectorSpec struct {
+	Kind string // css OR xpath
+	Expr string // WITHOUT ANY css= OR xpath= PREFIX
+}
+
+func (s selectorSpec) xpath() bool {
+	return s.Kind == SelectorXPath
+}
+
+// WORK OUT WHICH LANGUAGE A SELECTOR IS IN. AN EXPLICIT selectorType WINS OVER A PREFIX, WHICH WINS
+// OVER THE SHAPE OF THE EXPRESSION
+func parseSelector(selector, selectorType string) selectorSpec {
+	selector = strings.TrimSpace(selector)
+	kind := strings.ToLower(strings.TrimSpace(selectorType))
+	for _, prefix := range []string{SelectorXPath, SelectorCSS} {
+		if rest, ok := strings.CutPrefix(selector, prefix+"="); ok {
+			if kind == "" {
+				kind = prefix
+			}
+			selector = rest
+			break
+		}
+	}
+	if kind == "" {
+		kind = SelectorCSS
+		if strings.HasPrefix(selector, "/") || strings.HasPrefix(selector, "(") || strings.HasPrefix(selector, "..") {
+			kind = SelectorXPath
+		}
+	}
+	return selectorSpec{Kind: kind, Expr: selector}
+}
+
+// CHECK A SELECTOR BEFORE THE RUN: THE TYPE HAS TO BE KNOWN AND AN XPATH HAS TO COMPILE
+// (CSS IS LEFT TO PLAYWRIGHT, WHICH ACCEPTS MORE THAN PLAIN CSS)
+func validateSelector(selector, selectorType string) error {
+	spec := parseSelector(selector, selectorType)
+	if spec.Kind != SelectorCSS && spec.Kind != SelectorXPath {
+		return fmt.Errorf("%w: selectorType MUST BE css OR xpath", ErrInvalidInput)
+	}
+	if spec.xpath() {
+		if _, err := xpath.Compile(spec.Expr); err != nil {
+			return fmt.Errorf("%w: INVALID XPATH %q: %v", ErrInvalidInput, spec.Expr, err)
+		}
+	}
+	return nil
+}
+
+// VALIDATE A TASK'S selector AND selectorType INPUTS
+func validateSelectorConfig(config map[string]any) error {
+	selector, _ := config["selector"].(string)
+	if selector == "" {
+		return nil
+	}
+	selectorType, _ := config["selectorType"].(string)
+	return validateSelector(selector, selectorType)
+}
+
+// THE SELECTOR A TASK'S selector AND selectorType INPUTS NAME, READY FOR PLAYWRIGHT (fallback WHEN
+// selector ISN'T GIVEN)
+func configSelector(config map[string]any, fallback string) string {
+	selector, _ := config["selector"].(string)
+	if selector == "" {
+		selector = fallback
+	}
+	selectorType, _ := config["selectorType"].(string)
+	return locatorSelector(selector, selectorType)
+}
+
+// A SELECTOR FOR PLAYWRIGHT'S SELECTOR ENGINE: XPATHS GET THEIR xpath= PREFIX, ANYTHING ELSE
+// (INCLUDING PLAYWRIGHT'S OWN text=, >> AND :has-text() SYNTAX) IS PASSED THROUGH
+func locatorSelector(selector, selectorType string) string {
+	spec := parseSelector(selector, selectorType)
+	if spec.xpath() {
+		return "xpath=" + spec.Expr
+	}
+	if selectorType != "" {
+		return spec.Expr
+	}
+	return selector
+}
+
+// NORMALIZE extractRecords FIELDS SO THE PAGE SCRIPT KNOWS WHICH SUB-SELECTORS ARE XPATHS
+func recordFieldSelectors(fields map[string]map[string]any) map[string]map[string]any {
+	out := make(map[string]map[string]any, len(fields))
+	for name, spec := range fields {
+		spec = maps.Clone(spec)
+		if selector, _ := spec["selector"].(string); selector != "" {
+			selectorType, _ := spec["selectorType"].(string)
+			parsed := parseSelector(selector, selectorType)
+			spec["selector"] = parsed.Expr
+			spec["xpath"] = parsed.xpath()
+		}
+		out[name] = spec
+	}
+	return out
+}
+
+// ELEMENTS UNDER sel MATCHING A SELECTOR. XPATHS ARE EVALUATED FROM EACH NODE OF sel, SO A RELATIVE
+// ONE (.//a) STAYS INSIDE IT; ONE THAT SELECTS AN ATTRIBUTE (//a/@href) GIVES A DETACHED ELEMENT
+// NAMED AFTER THE ATTRIBUTE, HOLDING ITS VALUE AS TEXT
+func findSelector(sel *goquery.Selection, spec selectorSpec) (*goquery.Selection, error) {
+	if !spec.xpath() {
+		return sel.Find(spec.Expr), nil
+	}
+	expr, err := xpath.Compile(spec.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("INVALID XPATH %q: %v", spec.Expr, err)
+	}
+	var nodes []*html.Node
+	seen := make(map[*html.Node]bool)
+	for _, node := range sel.Nodes {
+		for _, match := range htmlquery.QuerySelectorAll(node, expr) {
+			if !seen[match] {
+				seen[match] = true
+				nodes = append(nodes, match)
+			}
+		}
+	}
+	// A NEW SELECTION RATHER THAN sel.Slice(0, 0).AddNodes, WHICH WOULD WRITE INTO sel'S NODES
+	return &goquery.Selection{Nodes: nodes}, nil
+}
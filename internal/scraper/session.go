@@ -0,0 +1,216 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- SESSION HEALTH --
+
+// JOB RULE DESCRIBING HOW TO TELL THE LOGIN SESSION EXPIRED AND WHICH STAGE LOGS BACK IN
+const sessionRule = "session"
+
+const (
+	defaultMaxRelogins    = 2
+	defaultSessionTimeout = 15000 // MS
+)
+
+// SESSION RULE, E.G. {"loginStage": "login", "url": "https://example.com/account", "loggedOutSelector": "form#login"}.
+// THE SESSION IS EXPIRED WHEN ANY CHECK FAILS; A LOGIN STAGE WITH A "never" CONDITION ONLY RUNS WHEN IT DOES
+type SessionRule struct {
+	LoginStage        string   `json:"loginStage"`        // REQUIRED (ID OR NAME OF THE STAGE THAT LOGS IN)
+	Page              string   `json:"page"`              // ID OF THE TASK THAT CREATED THE PAGE TO CHECK (DEFAULTS TO ANY OPEN PAGE)
+	URL               string   `json:"url"`               // PROBE URL, OPENED IN A NEW TAB SHARING THE PAGE'S COOKIES
+	Selector          string   `json:"selector"`          // ONLY PRESENT WHEN LOGGED IN (E.G. A LOGOUT LINK)
+	LoggedOutSelector string   `json:"loggedOutSelector"` // ONLY PRESENT WHEN LOGGED OUT (E.G. THE LOGIN FORM)
+	LoginURL          string   `json:"loginUrl"`          // REGEXP; LANDING ON A MATCHING URL MEANS LOGGED OUT
+	Stages            []string `json:"stages"`            // STAGES TO CHECK BEFORE (DEFAULTS TO EVERY STAGE AFTER THE LOGIN STAGE)
+	MaxRelogins       int      `json:"maxRelogins"`       // PER RUN (DEFAULTS TO 2)
+	Timeout           float64  `json:"timeout"`           // MS, FOR THE PROBE (DEFAULTS TO 15000)
+
+	loginIndex int
+	loginURL   *regexp.Regexp
+}
+
+// PARSE A session RULE AGAINST THE JOB'S PIPELINE (NIL WHEN UNSET)
+func ParseSessionRule(raw any, pipeline []models.Stage) (*SessionRule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var rule SessionRule
+	if err := decoder.Decode(&rule); err != nil {
+		return nil, fmt.Errorf("SESSION RULE MUST BE AN OBJECT: %v", err)
+	}
+	if rule.LoginStage == "" {
+		return nil, fmt.Errorf("SESSION RULE HAS NO loginStage")
+	}
+	if rule.URL == "" && rule.Selector == "" && rule.LoggedOutSelector == "" && rule.LoginURL == "" {
+		return nil, fmt.Errorf("SESSION RULE NEEDS AT LEAST ONE OF url, selector, loggedOutSelector OR loginUrl")
+	}
+	if rule.LoginURL != "" {
+		if rule.loginURL, err = regexp.Compile(rule.LoginURL); err != nil {
+			return nil, fmt.Errorf("INVALID loginUrl PATTERN: %v", err)
+		}
+	}
+	if rule.MaxRelogins < 0 || rule.Timeout < 0 {
+		return nil, fmt.Errorf("maxRelogins AND timeout CAN'T BE NEGATIVE")
+	}
+	if rule.MaxRelogins == 0 {
+		rule.MaxRelogins = defaultMaxRelogins
+	}
+	if rule.Timeout == 0 {
+		rule.Timeout = defaultSessionTimeout
+	}
+
+	rule.loginIndex = -1
+	for i, stage := range pipeline {
+		if stage.ID == rule.LoginStage || stage.Name == rule.LoginStage {
+			rule.loginIndex = i
+			break
+		}
+	}
+	if rule.loginIndex < 0 {
+		return nil, fmt.Errorf("LOGIN STAGE %q IS NOT IN THE PIPELINE", rule.LoginStage)
+	}
+	for _, name := range rule.Stages {
+		if !slices.ContainsFunc(pipeline, func(s models.Stage) bool { return s.ID == name || s.Name == name }) {
+			return nil, fmt.Errorf("STAGE %q IS NOT IN THE PIPELINE", name)
+		}
+	}
+	return &rule, nil
+}
+
+// WHETHER THE SESSION MUST BE CHECKED BEFORE A STAGE
+func (r *SessionRule) guards(index int, stage models.Stage) bool {
+	if r == nil || index == r.loginIndex {
+		return false
+	}
+	if len(r.Stages) == 0 {
+		return index > r.loginIndex
+	}
+	return slices.Contains(r.Stages, stage.ID) || slices.Contains(r.Stages, stage.Name)
+}
+
+// CHECK THE SESSION AND RUN THE LOGIN STAGE UNTIL IT'S VALID OR THE RUN'S RE-LOGINS ARE USED UP
+func (e *Engine) ensureSession(ctx context.Context, jobID string, job *models.Job, pipeline []models.Stage, rule *SessionRule, logger *log.Logger) error {
+	for {
+		valid, reason := e.checkSession(ctx, jobID, rule, logger)
+		if valid {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		e.mu.Lock()
+		progress := e.jobProgress[jobID]
+		relogins := progress.Relogins
+		if relogins < rule.MaxRelogins {
+			progress.Relogins++
+			e.jobProgress[jobID] = progress
+		}
+		e.mu.Unlock()
+		if relogins >= rule.MaxRelogins {
+			return fmt.Errorf("SESSION STILL EXPIRED AFTER %d RE-LOGINS: %s", relogins, reason)
+		}
+
+		login := pipeline[rule.loginIndex]
+		logger.Printf("SESSION EXPIRED (%s), RUNNING LOGIN STAGE %s", reason, stageLabel(login))
+		e.events.Publish("session.expired", jobID, map[string]any{"reason": reason, "relogins": relogins + 1})
+		if err := e.executeStage(withTimelineStage(ctx, stageLabel(login)), jobID, job, login, logger); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// RUN THE RULE'S CHECKS, RETURNING WHY THE SESSION LOOKS EXPIRED. WITH NO PAGE OPEN THERE'S
+// NO SESSION TO CHECK, SO IT'S TREATED AS VALID
+func (e *Engine) checkSession(ctx context.Context, jobID string, rule *SessionRule, logger *log.Logger) (bool, string) {
+	page := e.sessionPage(jobID, rule)
+	if page == nil {
+		logger.Printf("NO OPEN PAGE TO CHECK THE SESSION ON, SKIPPING")
+		return true, ""
+	}
+
+	target := page
+	if rule.URL != "" {
+		probe, err := page.Context().NewPage()
+		if err != nil {
+			return false, fmt.Sprintf("can't open probe tab: %v", err)
+		}
+		defer probe.Close()
+		stop := context.AfterFunc(ctx, func() { probe.Close() })
+		defer stop()
+		response, err := probe.Goto(rule.URL, playwright.PageGotoOptions{
+			WaitUntil: playwright.WaitUntilStateDomcontentloaded,
+			Timeout:   playwright.Float(rule.Timeout),
+		})
+		if err != nil {
+			return false, fmt.Sprintf("probe failed: %v", err)
+		}
+		if response != nil && (response.Status() == 401 || response.Status() == 403) {
+			return false, fmt.Sprintf("probe returned status %d", response.Status())
+		}
+		target = probe
+	}
+
+	if rule.loginURL != nil && rule.loginURL.MatchString(target.URL()) {
+		return false, "landed on the login page " + target.URL()
+	}
+	if rule.LoggedOutSelector != "" {
+		if count, err := target.Locator(rule.LoggedOutSelector).Count(); err == nil && count > 0 {
+			return false, "logged-out marker " + rule.LoggedOutSelector + " is on the page"
+		}
+	}
+	if rule.Selector != "" {
+		count, err := target.Locator(rule.Selector).Count()
+		if err != nil || count == 0 {
+			return false, "logged-in marker " + rule.Selector + " is missing"
+		}
+	}
+	return true, ""
+}
+
+// PAGE THE SESSION IS CHECKED ON: THE ONE CREATED BY THE RULE'S page TASK, OR ANY OPEN PAGE
+func (e *Engine) sessionPage(jobID string, rule *SessionRule) playwright.Page {
+	if rule.Page != "" {
+		e.mu.Lock()
+		result, ok := e.jobProgress[jobID].TaskResults[rule.Page]
+		e.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		page, err := getPage(&TaskContext{JobID: jobID, ResourceManager: e.resourceManager}, result.Value)
+		if err != nil {
+			return nil
+		}
+		return page
+	}
+	return e.resourceManager.anyPage(jobID)
+}
+
+// ANY OPEN PAGE OF A JOB (NIL WHEN IT HAS NONE)
+func (rm *ResourceManager) anyPage(jobID string) playwright.Page {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for _, resource := range rm.resources[jobID] {
+		if page, ok := resource.(playwright.Page); ok && !page.IsClosed() {
+			return page
+		}
+	}
+	return nil
+}
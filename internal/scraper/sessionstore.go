@@ -0,0 +1,372 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"github.com/playwright-community/playwright-go"
+	"gorm.io/gorm/clause"
+)
+
+// -- PERSISTENT SESSIONS --
+//
+// A JOB WITH THE persistSession RULE KEEPS ITS COOKIES AND LOCAL STORAGE FROM ONE RUN TO THE NEXT,
+// SO A LOGIN SURVIVES BETWEEN RUNS. THE RUN LOADS THE STORED SESSION WHEN IT STARTS: NEW PAGES OPEN
+// WITH IT, AND DIRECT HTTP REQUESTS SEND ITS COOKIES AND KEEP THE ONES THEY'RE SET. A PAGE'S
+// COOKIES AND LOCAL STORAGE ARE TAKEN BACK WHEN IT (OR ITS BROWSER) CLOSES, AND THE SESSION IS
+// SAVED WHEN THE RUN ENDS, SEALED WITH THE encryptionSecret WHEN ONE IS SET.
+
+// JOB RULE TURNING SESSION PERSISTENCE ON
+const persistSessionRule = "persistSession"
+
+// ONE RUN'S SESSION
+type runSession struct {
+	mu      sync.Mutex
+	cookies map[string]playwright.Cookie      // BY NAME, DOMAIN AND PATH
+	origins map[string][]playwright.NameValue // LOCAL STORAGE BY ORIGIN
+}
+
+func newRunSession(state *playwright.StorageState) *runSession {
+	s := &runSession{cookies: make(map[string]playwright.Cookie), origins: make(map[string][]playwright.NameValue)}
+	if state != nil {
+		s.absorb(state)
+	}
+	return s
+}
+
+func cookieKey(c playwright.Cookie) string {
+	return c.Name + "|" + strings.ToLower(c.Domain) + "|" + c.Path
+}
+
+// WHETHER A COOKIE'S EXPIRY HAS PASSED (SESSION COOKIES HAVE NONE)
+func cookieExpired(c playwright.Cookie, now time.Time) bool {
+	return c.Expires > 0 && int64(c.Expires) <= now.Unix()
+}
+
+// TAKE IN A BROWSER CONTEXT'S COOKIES AND LOCAL STORAGE, REPLACING WHAT THE SESSION HAD FOR THEM
+func (s *runSession) absorb(state *playwright.StorageState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, c := range state.Cookies {
+		if cookieExpired(c, now) {
+			delete(s.cookies, cookieKey(c))
+			continue
+		}
+		s.cookies[cookieKey(c)] = c
+	}
+	for _, origin := range state.Origins {
+		s.origins[origin.Origin] = origin.LocalStorage
+	}
+}
+
+// THE SESSION AS A STORAGE STATE, WITHOUT EXPIRED COOKIES
+func (s *runSession) state() *playwright.StorageState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := &playwright.StorageState{Cookies: []playwright.Cookie{}, Origins: []playwright.Origin{}}
+	now := time.Now()
+	for _, c := range s.cookies {
+		if !cookieExpired(c, now) {
+			state.Cookies = append(state.Cookies, c)
+		}
+	}
+	sort.Slice(state.Cookies, func(i, j int) bool { return cookieKey(state.Cookies[i]) < cookieKey(state.Cookies[j]) })
+	for origin, items := range s.origins {
+		state.Origins = append(state.Origins, playwright.Origin{Origin: origin, LocalStorage: items})
+	}
+	sort.Slice(state.Origins, func(i, j int) bool { return state.Origins[i].Origin < state.Origins[j].Origin })
+	return state
+}
+
+// THE SESSION AS A NEW PAGE'S STARTING STATE
+func (s *runSession) pageState() *playwright.OptionalStorageState {
+	state := s.state()
+	optional := &playwright.OptionalStorageState{Cookies: make([]playwright.OptionalCookie, 0, len(state.Cookies)), Origins: state.Origins}
+	for _, c := range state.Cookies {
+		cookie := playwright.OptionalCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   playwright.String(c.Domain),
+			Path:     playwright.String(c.Path),
+			HttpOnly: playwright.Bool(c.HttpOnly),
+			Secure:   playwright.Bool(c.Secure),
+			SameSite: c.SameSite,
+		}
+		if c.Expires > 0 {
+			cookie.Expires = playwright.Float(c.Expires)
+		}
+		optional.Cookies = append(optional.Cookies, cookie)
+	}
+	return optional
+}
+
+// WHETHER A COOKIE IS SENT TO A URL
+func cookieMatches(c playwright.Cookie, u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	domain := strings.ToLower(c.Domain)
+	if suffix, ok := strings.CutPrefix(domain, "."); ok {
+		if host != suffix && !strings.HasSuffix(host, "."+suffix) {
+			return false
+		}
+	} else if host != domain {
+		return false
+	}
+	if c.Secure && u.Scheme != "https" {
+		return false
+	}
+	requestPath := u.EscapedPath()
+	if requestPath == "" {
+		requestPath = "/"
+	}
+	if c.Path != "" && c.Path != "/" && requestPath != c.Path {
+		if !strings.HasPrefix(requestPath, c.Path) || (!strings.HasSuffix(c.Path, "/") && requestPath[len(c.Path)] != '/') {
+			return false
+		}
+	}
+	return true
+}
+
+// COOKIES TO SEND TO A URL, LONGEST PATH FIRST
+func (s *runSession) cookiesFor(u *url.URL) []*http.Cookie {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var matched []playwright.Cookie
+	for _, c := range s.cookies {
+		if !cookieExpired(c, now) && cookieMatches(c, u) {
+			matched = append(matched, c)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return len(matched[i].Path) > len(matched[j].Path) })
+	cookies := make([]*http.Cookie, len(matched))
+	for i, c := range matched {
+		cookies[i] = &http.Cookie{Name: c.Name, Value: c.Value}
+	}
+	return cookies
+}
+
+// KEEP THE COOKIES A RESPONSE SETS, FOLLOWING THE BROWSER'S DOMAIN AND PATH RULES
+func (s *runSession) setCookies(u *url.URL, cookies []*http.Cookie) {
+	if len(cookies) == 0 {
+		return
+	}
+	host := strings.ToLower(u.Hostname())
+	defaultPath := path.Dir(u.EscapedPath())
+	if !strings.HasPrefix(defaultPath, "/") {
+		defaultPath = "/"
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hc := range cookies {
+		c := playwright.Cookie{Name: hc.Name, Value: hc.Value, Domain: host, Path: hc.Path, Expires: -1, HttpOnly: hc.HttpOnly, Secure: hc.Secure}
+		if hc.Domain != "" {
+			// A DOMAIN ATTRIBUTE MUST COVER THE HOST THAT SET IT
+			domain := strings.TrimPrefix(strings.ToLower(hc.Domain), ".")
+			if host != domain && !strings.HasSuffix(host, "."+domain) {
+				continue
+			}
+			c.Domain = "." + domain
+		}
+		if !strings.HasPrefix(c.Path, "/") {
+			c.Path = defaultPath
+		}
+		switch hc.SameSite {
+		case http.SameSiteStrictMode:
+			c.SameSite = playwright.SameSiteAttributeStrict
+		case http.SameSiteNoneMode:
+			c.SameSite = playwright.SameSiteAttributeNone
+		default:
+			c.SameSite = playwright.SameSiteAttributeLax
+		}
+		switch {
+		case hc.MaxAge < 0:
+			delete(s.cookies, cookieKey(c))
+			continue
+		case hc.MaxAge > 0:
+			c.Expires = float64(now.Add(time.Duration(hc.MaxAge) * time.Second).Unix())
+		case !hc.Expires.IsZero():
+			if !hc.Expires.After(now) {
+				delete(s.cookies, cookieKey(c))
+				continue
+			}
+			c.Expires = float64(hc.Expires.Unix())
+		}
+		s.cookies[cookieKey(c)] = c
+	}
+}
+
+type sessionKey struct{}
+
+// THE PERSISTED SESSION OF THE RUN A CONTEXT BELONGS TO, IF IT HAS ONE
+func sessionFrom(ctx context.Context) *runSession {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(sessionKey{}).(*runSession)
+	return s
+}
+
+// THE RUNNING JOB'S PERSISTED SESSION (NIL WITHOUT THE RULE)
+func (e *Engine) jobSession(jobID string) *runSession {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.sessions[jobID]
+}
+
+// LOAD THE JOB'S STORED SESSION INTO A NEW RUN; THE RETURNED CONTEXT CARRIES IT
+func (e *Engine) startSession(ctx context.Context, jobID string) (context.Context, error) {
+	state, _, err := e.LoadJobSession(jobID)
+	session := newRunSession(state)
+	e.mu.Lock()
+	e.sessions[jobID] = session
+	e.mu.Unlock()
+	if state != nil {
+		log.Printf("[JOB %s] RESTORED SESSION WITH %d COOKIES AND %d ORIGINS", jobID, len(state.Cookies), len(state.Origins))
+	}
+	return context.WithValue(ctx, sessionKey{}, session), err
+}
+
+// TAKE BACK THE COOKIES AND LOCAL STORAGE OF BROWSER CONTEXTS ABOUT TO CLOSE
+func (e *Engine) captureSession(jobID string, contexts ...playwright.BrowserContext) {
+	session := e.jobSession(jobID)
+	if session == nil {
+		return
+	}
+	for _, browserContext := range contexts {
+		state, err := browserContext.StorageState()
+		if err != nil {
+			log.Printf("[JOB %s] COULD NOT READ THE PAGE'S SESSION: %v", jobID, err)
+			continue
+		}
+		session.absorb(state)
+	}
+}
+
+// TAKE BACK THE SESSION OF A PAGE OR BROWSER ABOUT TO CLOSE
+func (e *Engine) captureResourceSession(jobID string, resource any) {
+	switch r := resource.(type) {
+	case playwright.Page:
+		if !r.IsClosed() {
+			e.captureSession(jobID, r.Context())
+		}
+	case playwright.Browser:
+		if r.IsConnected() {
+			e.captureSession(jobID, r.Contexts()...)
+		}
+	}
+}
+
+// SAVE THE RUN'S SESSION FOR THE NEXT RUN AND FORGET IT. CALLED ONCE THE RUN'S PAGES ARE CLOSED
+func (e *Engine) endSession(jobID string) {
+	e.mu.Lock()
+	session, ok := e.sessions[jobID]
+	runID := e.jobProgress[jobID].RunID
+	delete(e.sessions, jobID)
+	e.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := e.saveJobSession(jobID, runID, session.state()); err != nil {
+		log.Printf("[JOB %s] FAILED TO SAVE SESSION: %v", jobID, err)
+		return
+	}
+	e.events.Publish("session.saved", jobID, map[string]any{"runId": runID})
+}
+
+// NAME A JOB'S SEALED SESSION IS BOUND TO
+func sessionSealName(jobID string) string {
+	return "session:" + jobID
+}
+
+func (e *Engine) saveJobSession(jobID, runID string, state *playwright.StorageState) error {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	record := models.Session{
+		JobID:   jobID,
+		State:   string(encoded),
+		Cookies: len(state.Cookies),
+		Origins: len(state.Origins),
+		RunID:   runID,
+	}
+	if e.cfg.EncryptionSecret != "" {
+		sealed, err := utils.SealSecret(sessionSealName(jobID), record.State, e.cfg.EncryptionSecret)
+		if err != nil {
+			return err
+		}
+		record.State, record.Sealed = sealed, true
+	}
+	return e.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "job_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"state", "sealed", "cookies", "origins", "run_id", "updated_at"}),
+	}).Create(&record).Error
+}
+
+// A JOB'S STORED SESSION (NIL WHEN IT HAS NONE)
+func (e *Engine) LoadJobSession(jobID string) (*playwright.StorageState, *models.Session, error) {
+	var record models.Session
+	result := e.db.Where("job_id = ?", jobID).Limit(1).Find(&record)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, nil, result.Error
+	}
+	raw := record.State
+	if record.Sealed {
+		plain, err := utils.OpenSecret(sessionSealName(jobID), raw, e.cfg.EncryptionSecret)
+		if err != nil {
+			return nil, &record, fmt.Errorf("COULD NOT UNSEAL THE STORED SESSION: %v", err)
+		}
+		raw = plain
+	}
+	var state playwright.StorageState
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return nil, &record, fmt.Errorf("STORED SESSION IS CORRUPT: %v", err)
+	}
+	return &state, &record, nil
+}
+
+// FORGET A JOB'S STORED SESSION SO THE NEXT RUN STARTS LOGGED OUT
+func (e *Engine) ClearJobSession(jobID string) (bool, error) {
+	result := e.db.Where("job_id = ?", jobID).Delete(&models.Session{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// SENDS THE RUN'S SESSION COOKIES WITH DIRECT REQUESTS THAT DON'T CARRY COOKIES OF THEIR OWN,
+// AND KEEPS THE COOKIES RESPONSES SET
+type sessionTransport struct {
+	base http.RoundTripper
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	session := sessionFrom(req.Context())
+	if session == nil {
+		return t.base.RoundTrip(req)
+	}
+	if req.Header.Get("Cookie") == "" {
+		if cookies := session.cookiesFor(req.URL); len(cookies) > 0 {
+			req = req.Clone(req.Context())
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+		}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		session.setCookies(req.URL, resp.Cookies())
+	}
+	return resp, err
+}
@@ -0,0 +1,279 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// WHAT A SIMULATION ASSUMES WHEN THERE IS NO HISTORY OR CONFIG TO GO ON
+const (
+	simDefaultItems        = 25 // ITEMS AN EXTRACTION TASK IS ASSUMED TO RETURN
+	simDefaultWorkers      = 5  // SAME DEFAULT AS WORKER-PER-ITEM STAGES
+	simDefaultArchivePages = 100
+	simDefaultArchiveDelay = 500 * time.Millisecond
+	simDefaultPageTime     = 2 * time.Second
+	simDefaultDownloadTime = 5 * time.Second
+	simDynamicHost         = "(dynamic)" // URL ONLY KNOWN AT RUN TIME
+)
+
+// TASK TYPES THAT LOAD A PAGE OR DOWNLOAD A FILE
+var (
+	simPageTasks     = map[string]bool{"navigate": true, "reload": true, "back": true, "forward": true}
+	simDownloadTasks = map[string]bool{"downloadAsset": true, "captureLiveStream": true}
+	simListTasks     = map[string]bool{"extractLinks": true, "extractImages": true, "extractRecords": true}
+)
+
+// KNOBS FOR A SIMULATION
+type SimulateOptions struct {
+	ItemsPerList int `json:"itemsPerList"` // ASSUMED SIZE OF EXTRACTED LISTS (DEFAULTS TO 25)
+}
+
+// ONE TASK'S PROJECTED WORK
+type SimulatedTask struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+	Runs      int     `json:"runs"`
+	Pages     int     `json:"pages"`
+	Downloads int     `json:"downloads"`
+	Seconds   float64 `json:"seconds"` // SUMMED OVER ALL RUNS, BEFORE PARALLELISM
+}
+
+// ONE STAGE'S PROJECTED WORK
+type SimulatedStage struct {
+	Name    string          `json:"name"`
+	Mode    string          `json:"mode"`
+	Items   int             `json:"items,omitempty"` // WORKER-PER-ITEM STAGES
+	Workers int             `json:"workers"`
+	Skipped bool            `json:"skipped,omitempty"`
+	Seconds float64         `json:"seconds"`
+	Tasks   []SimulatedTask `json:"tasks"`
+}
+
+// PROJECTED COST OF RUNNING A JOB, WORKED OUT WITHOUT LAUNCHING A BROWSER OR MAKING REQUESTS
+type Simulation struct {
+	JobID           string           `json:"jobId,omitempty"`
+	Stages          []SimulatedStage `json:"stages"`
+	Pages           int              `json:"pages"`
+	Downloads       int              `json:"downloads"`
+	Requests        int              `json:"requests"`
+	RequestsPerHost map[string]int   `json:"requestsPerHost"`
+	DurationSeconds float64          `json:"durationSeconds"`
+	LastRunSeconds  float64          `json:"lastRunSeconds,omitempty"`
+	ProjectedBytes  int64            `json:"projectedBytes"`
+	Timings         SimulationTiming `json:"timings"`
+	Assumptions     []string         `json:"assumptions"`
+}
+
+// PER-REQUEST FIGURES A SIMULATION USED AND WHERE THEY CAME FROM
+type SimulationTiming struct {
+	PageSeconds     float64 `json:"pageSeconds"`
+	DownloadSeconds float64 `json:"downloadSeconds"`
+	BytesPerAsset   int64   `json:"bytesPerAsset"`
+	DownloadSource  string  `json:"downloadSource"` // history, default
+	StorageSource   string  `json:"storageSource"`  // job, all, none
+}
+
+// WALK A JOB'S PIPELINE WITHOUT SIDE EFFECTS, ESTIMATING PAGES, REQUESTS PER HOST,
+// DURATION (FROM THE JOB'S PAST DOWNLOADS AND ASSETS WHERE AVAILABLE) AND STORAGE
+func (e *Engine) SimulateJob(job *models.Job, opts SimulateOptions) (*Simulation, error) {
+	var pipeline []models.Stage
+	if strings.TrimSpace(job.Pipeline) != "" {
+		if err := json.Unmarshal([]byte(job.Pipeline), &pipeline); err != nil {
+			return nil, fmt.Errorf("FAILED TO PARSE PIPELINE: %v", err)
+		}
+	}
+	itemsPerList := opts.ItemsPerList
+	if itemsPerList <= 0 {
+		itemsPerList = simDefaultItems
+	}
+
+	sim := &Simulation{
+		JobID:           job.ID,
+		Stages:          []SimulatedStage{},
+		RequestsPerHost: make(map[string]int),
+		Timings:         e.simulationTimings(job.ID),
+		Assumptions: []string{
+			fmt.Sprintf("extraction tasks return %d items", itemsPerList),
+			"conditions other than \"never\" pass",
+			"retries are not counted",
+		},
+	}
+	if job.ID != "" {
+		e.mu.Lock()
+		sim.LastRunSeconds = e.jobDurations[job.ID].Seconds()
+		e.mu.Unlock()
+	}
+
+	// ESTIMATED LENGTH OF EACH ARRAY-PRODUCING TASK'S OUTPUT
+	listSizes := make(map[string]int)
+	for _, stage := range pipeline {
+		simStage := SimulatedStage{Name: stage.Name, Mode: stage.Parallelism.Mode, Workers: 1, Tasks: []SimulatedTask{}}
+		if simStage.Mode == "" {
+			simStage.Mode = "sequential"
+		}
+		if stage.Condition.Type == "never" {
+			simStage.Skipped = true
+			sim.Stages = append(sim.Stages, simStage)
+			continue
+		}
+
+		runs := 1
+		if (simStage.Mode == "worker-per-item" || simStage.Mode == "for-each") && len(stage.Tasks) > 0 {
+			runs = itemsPerList
+			refs := stage.Tasks[0].InputRefs
+			if source, ok := stage.Config["items"].(string); ok && source != "" {
+				refs = []string{source}
+			}
+			for _, ref := range refs {
+				if size, ok := listSizes[ref]; ok {
+					runs = size
+					break
+				}
+			}
+			simStage.Items = runs
+			simStage.Workers = stage.Parallelism.MaxWorkers
+			if simStage.Workers <= 0 {
+				simStage.Workers = simDefaultWorkers
+			}
+			if simStage.Workers > runs {
+				simStage.Workers = max(runs, 1)
+			}
+		}
+
+		var longest, total float64
+		for _, task := range stage.Tasks {
+			if task.Condition.Type == "never" {
+				continue
+			}
+			simTask := e.simulateTask(job, task, runs, sim)
+			if simListTasks[task.Type] && task.ID != "" {
+				listSizes[task.ID] = itemsPerList * runs
+			}
+			total += simTask.Seconds
+			longest = max(longest, simTask.Seconds)
+			simStage.Tasks = append(simStage.Tasks, simTask)
+		}
+
+		switch simStage.Mode {
+		case "parallel":
+			simStage.Seconds = longest
+			simStage.Workers = len(simStage.Tasks)
+		case "worker-per-item", "for-each":
+			simStage.Seconds = total / float64(simStage.Workers)
+		default:
+			simStage.Seconds = total
+		}
+		sim.DurationSeconds += simStage.Seconds
+		sim.Stages = append(sim.Stages, simStage)
+	}
+
+	sim.Requests = sim.Pages + sim.Downloads
+	sim.ProjectedBytes = int64(sim.Downloads) * sim.Timings.BytesPerAsset
+	return sim, nil
+}
+
+// PROJECT ONE TASK RUN runs TIMES, ADDING ITS REQUESTS TO THE SIMULATION TOTALS
+func (e *Engine) simulateTask(job *models.Job, task models.Task, runs int, sim *Simulation) SimulatedTask {
+	simTask := SimulatedTask{ID: task.ID, Name: task.Name, Type: task.Type, Runs: runs}
+	host := simHost(task, job.BaseURL)
+
+	switch {
+	case simPageTasks[task.Type]:
+		simTask.Pages = runs
+		simTask.Seconds = float64(runs) * sim.Timings.PageSeconds
+	case simDownloadTasks[task.Type]:
+		simTask.Downloads = runs
+		seconds := sim.Timings.DownloadSeconds
+		if task.Type == "captureLiveStream" {
+			seconds = numberConfig(task.Config, "duration", seconds)
+		}
+		simTask.Seconds = float64(runs) * seconds
+	case task.Type == "archiveSite":
+		pages := int(numberConfig(task.Config, "maxPages", simDefaultArchivePages))
+		delay := numberConfig(task.Config, "delay", float64(simDefaultArchiveDelay.Milliseconds())) / 1000
+		simTask.Pages = pages * runs
+		// WORKERS OVERLAP PAGES, BUT ONE HOST STILL WAITS delay BETWEEN THEM
+		workers := numberConfig(task.Config, "concurrency", defaultArchiveConcurrency)
+		simTask.Seconds = float64(simTask.Pages) * max(sim.Timings.PageSeconds/workers, delay)
+	case task.Type == "wait":
+		simTask.Seconds = float64(runs) * numberConfig(task.Config, "duration", 0) / 1000
+	}
+
+	sim.Pages += simTask.Pages
+	sim.Downloads += simTask.Downloads
+	if requests := simTask.Pages + simTask.Downloads; requests > 0 {
+		sim.RequestsPerHost[host] += requests
+	}
+	return simTask
+}
+
+// PER-REQUEST TIMINGS AND SIZES, PREFERRING THE JOB'S OWN HISTORY
+func (e *Engine) simulationTimings(jobID string) SimulationTiming {
+	timing := SimulationTiming{
+		PageSeconds:     simDefaultPageTime.Seconds(),
+		DownloadSeconds: simDefaultDownloadTime.Seconds(),
+		DownloadSource:  "default",
+		StorageSource:   "none",
+	}
+
+	// AVERAGE COMPLETED DOWNLOAD TIME FROM THE JOB'S LATEST RUN
+	if jobID != "" && e.transfers != nil {
+		var seconds float64
+		var count int
+		for _, transfer := range e.transfers.Job(jobID) {
+			if transfer.Status == "completed" && transfer.UpdatedAt.After(transfer.StartedAt) {
+				seconds += transfer.UpdatedAt.Sub(transfer.StartedAt).Seconds()
+				count++
+			}
+		}
+		if count > 0 {
+			timing.DownloadSeconds = seconds / float64(count)
+			timing.DownloadSource = "history"
+		}
+	}
+
+	// AVERAGE ASSET SIZE FOR THE JOB, FALLING BACK TO ALL ASSETS
+	if e.db != nil {
+		var avg struct{ Size float64 }
+		if jobID != "" {
+			e.db.Model(&models.Asset{}).Select("COALESCE(AVG(size), 0) AS size").Where("job_id = ? AND size > 0", jobID).Scan(&avg)
+			if avg.Size > 0 {
+				timing.BytesPerAsset, timing.StorageSource = int64(avg.Size), "job"
+			}
+		}
+		if timing.BytesPerAsset == 0 {
+			e.db.Model(&models.Asset{}).Select("COALESCE(AVG(size), 0) AS size").Where("size > 0").Scan(&avg)
+			if avg.Size > 0 {
+				timing.BytesPerAsset, timing.StorageSource = int64(avg.Size), "all"
+			}
+		}
+	}
+	return timing
+}
+
+// HOST A TASK WILL HIT: ITS CONFIGURED URL, THE JOB'S BASE URL FOR TASKS THAT TAKE NO URL
+// FROM OTHER TASKS, OR "(dynamic)" WHEN THE URL IS ONLY KNOWN AT RUN TIME
+func simHost(task models.Task, baseURL string) string {
+	rawURL, _ := task.Config["url"].(string)
+	if rawURL == "" && len(task.InputRefs) == 0 {
+		rawURL = baseURL
+	}
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return simDynamicHost
+}
+
+// NUMERIC TASK INPUT WITH A DEFAULT (JSON NUMBERS DECODE AS float64)
+func numberConfig(config map[string]any, key string, fallback float64) float64 {
+	if v, ok := config[key].(float64); ok && v > 0 {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,177 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// -- SITEMAPS --
+
+// MAXIMUM SITEMAP SIZE WE WILL READ (THE PROTOCOL CAPS FILES AT 50MB UNCOMPRESSED), AND HOW MANY
+// SITEMAP FILES ONE DISCOVERY READS ACROSS NESTED INDEXES
+const (
+	maxSitemapSize  = 50 << 20
+	maxSitemapFiles = 50
+)
+
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// WHERE TO LOOK AND WHAT TO KEEP
+type SitemapOptions struct {
+	Sitemaps []string          // SITEMAP OR INDEX URLS (EMPTY = robots.txt Sitemap: LINES, THEN /sitemap.xml)
+	Limit    int               // MOST PAGE URLS RETURNED (0 = ALL)
+	Keep     func(string) bool // PAGE URLS TO RETURN, CHECKED BEFORE THE LIMIT (NIL = ALL)
+}
+
+// WHAT ONE DISCOVERY READ
+type SitemapStats struct {
+	Sitemaps int // SITEMAP FILES READ
+	Failed   int // SITEMAP FILES THAT COULDN'T BE FETCHED OR PARSED
+	Found    int // PAGE URLS LISTED
+	Kept     int // PAGE URLS RETURNED
+}
+
+// DISCOVER PAGE URLS FROM A SITE'S SITEMAPS, FOLLOWING SITEMAP INDEXES AND GUNZIPPING .gz SITEMAPS
+func DiscoverSitemapURLs(ctx context.Context, client *http.Client, siteURL string, options SitemapOptions) ([]string, SitemapStats) {
+	var stats SitemapStats
+	base, err := url.Parse(siteURL)
+	if err != nil {
+		return nil, stats
+	}
+	root := &url.URL{Scheme: base.Scheme, Host: base.Host}
+
+	// SITEMAP LOCATIONS FROM THE OPTIONS OR robots.txt, FALLING BACK TO THE CONVENTIONAL PATH
+	queue := options.Sitemaps
+	if len(queue) == 0 {
+		queue = robotsSitemaps(ctx, client, root.ResolveReference(&url.URL{Path: "/robots.txt"}).String())
+	}
+	if len(queue) == 0 {
+		queue = []string{root.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String()}
+	}
+
+	seenMaps := make(map[string]bool)
+	seenURLs := make(map[string]bool)
+	var urls []string
+
+	// BREADTH-FIRST OVER NESTED INDEXES, BOUNDED SO A HUGE INDEX CAN'T RUN AWAY
+	for len(queue) > 0 && len(seenMaps) < maxSitemapFiles && ctx.Err() == nil {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		if seenMaps[sitemapURL] {
+			continue
+		}
+		seenMaps[sitemapURL] = true
+
+		doc, err := fetchSitemap(ctx, client, sitemapURL)
+		if err != nil {
+			stats.Failed++
+			continue
+		}
+		stats.Sitemaps++
+		for _, entry := range doc.Sitemaps {
+			if loc := strings.TrimSpace(entry.Loc); loc != "" {
+				queue = append(queue, loc)
+			}
+		}
+		for _, entry := range doc.URLs {
+			loc := strings.TrimSpace(entry.Loc)
+			if loc == "" || seenURLs[loc] {
+				continue
+			}
+			seenURLs[loc] = true
+			stats.Found++
+			if options.Keep != nil && !options.Keep(loc) {
+				continue
+			}
+			urls = append(urls, loc)
+			stats.Kept++
+			if options.Limit > 0 && len(urls) >= options.Limit {
+				return urls, stats
+			}
+		}
+	}
+	return urls, stats
+}
+
+// READ Sitemap: LINES FROM robots.txt
+func robotsSitemaps(ctx context.Context, client *http.Client, robotsURL string) []string {
+	req, err := http.NewRequestWithContext(ctx, "GET", robotsURL, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var sitemaps []string
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1<<20))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "sitemap") {
+			if loc := strings.TrimSpace(value); loc != "" {
+				sitemaps = append(sitemaps, loc)
+			}
+		}
+	}
+	return sitemaps
+}
+
+// FETCH AND PARSE ONE SITEMAP OR SITEMAP INDEX, TRANSPARENTLY GUNZIPPING
+func fetchSitemap(ctx context.Context, client *http.Client, sitemapURL string) (*sitemapDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sitemapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &downloadStatusError{StatusCode: resp.StatusCode}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSitemapSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(io.LimitReader(gz, maxSitemapSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var doc sitemapDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
@@ -0,0 +1,270 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- SSRF PROTECTION --
+//
+// DIRECT HTTP REQUESTS CHECK THE ADDRESS THEY ACTUALLY CONNECT TO, AFTER DNS, SO A NAME
+// THAT RESOLVES TO A PUBLIC ADDRESS WHEN CHECKED AND A PRIVATE ONE WHEN DIALED (DNS
+// REBINDING) IS STILL CAUGHT. BROWSERS RESOLVE NAMES THEMSELVES, SO THEIR REQUESTS ARE
+// CHECKED BY NAME BEFORE THEY ARE SENT, WHICH IS BEST EFFORT.
+
+// RETURNED FOR A REQUEST TO A BLOCKED ADDRESS
+var ErrBlockedAddress = errors.New("ADDRESS BLOCKED BY SSRF PROTECTION")
+
+// NAMED GROUPS OF ADDRESSES FOR THE ssrf.block SETTING
+var ssrfGroups = map[string][]string{
+	"loopback":    {"127.0.0.0/8", "::1/128"},
+	"private":     {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"},
+	"link-local":  {"169.254.0.0/16", "fe80::/10"},
+	"metadata":    {"169.254.169.254/32", "100.100.100.200/32", "fd00:ec2::254/128"},
+	"unspecified": {"0.0.0.0/8", "::/128"},
+	"multicast":   {"224.0.0.0/4", "ff00::/8"},
+}
+
+// CHECKS DESTINATIONS AGAINST THE ssrf SETTINGS; A NIL GUARD ALLOWS EVERYTHING
+type NetGuard struct {
+	blocked      []netip.Prefix
+	allowed      []netip.Prefix
+	allowedHosts []string
+	domains      domainLists // INSTANCE BLOCK/ALLOW-ONLY DOMAINS (SEE domains.go)
+	key          string
+}
+
+// BUILD A GUARD FROM THE SETTINGS (NIL WHEN PROTECTION IS OFF). ENTRIES THAT CAN'T BE
+// PARSED ARE REPORTED; THE REST STILL APPLY
+func NewNetGuard(cfg config.SSRFConfig) (*NetGuard, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	guard := &NetGuard{}
+	var errs []error
+
+	block := cfg.Block
+	if len(block) == 0 {
+		for group := range ssrfGroups {
+			block = append(block, group)
+		}
+		slices.Sort(block)
+	}
+	for _, entry := range block {
+		cidrs, ok := ssrfGroups[strings.ToLower(entry)]
+		if !ok {
+			cidrs = []string{entry}
+		}
+		for _, cidr := range cidrs {
+			prefix, err := parsePrefix(cidr)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("ssrf.block: %v", err))
+				continue
+			}
+			guard.blocked = append(guard.blocked, prefix)
+		}
+	}
+	for _, entry := range cfg.Allow {
+		if prefix, err := parsePrefix(entry); err == nil {
+			guard.allowed = append(guard.allowed, prefix)
+		} else {
+			guard.allowedHosts = append(guard.allowedHosts, strings.ToLower(entry))
+		}
+	}
+	guard.key = fmt.Sprint(guard.blocked, guard.allowed, guard.allowedHosts)
+	return guard, errors.Join(errs...)
+}
+
+// GUARD FROM THE GLOBAL SETTINGS, LOGGING ENTRIES THAT ARE SKIPPED
+func NetGuardFromConfig(cfg *config.Config) *NetGuard {
+	guard, err := NewNetGuard(cfg.SSRF)
+	if err != nil {
+		log.Printf("INVALID SSRF SETTINGS, SKIPPING: %v", err)
+	}
+	// DOMAIN LISTS APPLY EVEN WITH SSRF PROTECTION OFF
+	if domains := newDomainLists(cfg.Domains); domains.active() {
+		if guard == nil {
+			guard = &NetGuard{}
+		}
+		guard.domains = domains
+		guard.key += "|" + domains.key()
+	}
+	return guard
+}
+
+// A CIDR, OR A SINGLE ADDRESS AS A ONE-ADDRESS PREFIX
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// TRANSPORT CACHE KEY
+func (g *NetGuard) cacheKey() string {
+	if g == nil {
+		return ""
+	}
+	return g.key
+}
+
+// WHETHER AN ADDRESS IS BLOCKED (IPV4-MAPPED IPV6 ADDRESSES ARE CHECKED AS IPV4)
+func (g *NetGuard) blocks(addr netip.Addr) bool {
+	if g == nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range g.allowed {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	for _, prefix := range g.blocked {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WHETHER A HOST NAME IS EXEMPT
+func (g *NetGuard) allowsHost(host string) bool {
+	if g == nil {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return slices.Contains(g.allowedHosts, host)
+}
+
+// DIALER HOOK: REFUSE TO CONNECT TO A BLOCKED ADDRESS, WHATEVER NAME LED THERE
+func (g *NetGuard) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	if g.blocks(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// DIAL FUNC FOR TRANSPORTS: EXEMPT HOSTS DIAL AS USUAL, EVERYTHING ELSE THROUGH THE CHECK
+func (g *NetGuard) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if g == nil {
+		return dialer.DialContext
+	}
+	guarded := *dialer
+	guarded.Control = g.control
+	if previous := dialer.Control; previous != nil {
+		guarded.Control = func(network, address string, c syscall.RawConn) error {
+			if err := g.control(network, address, c); err != nil {
+				return err
+			}
+			return previous(network, address, c)
+		}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && g.allowsHost(host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// RESOLVE A HOST THROUGH THE DNS POLICY AND FAIL IF ANY OF ITS ADDRESSES IS BLOCKED
+func (g *NetGuard) resolve(ctx context.Context, host string, dns DNSPolicy, egress EgressPolicy) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		if g.blocks(addr) {
+			return nil, fmt.Errorf("%w: %s", ErrBlockedAddress, addr)
+		}
+		return []netip.Addr{addr}, nil
+	}
+	addrs, err := dns.lookup(ctx, g, egress, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if g.blocks(addr) {
+			return nil, fmt.Errorf("%w: %s RESOLVES TO %s", ErrBlockedAddress, host, addr.Unmap())
+		}
+	}
+	return addrs, nil
+}
+
+// CHECK A URL BEFORE HANDING IT TO SOMETHING THAT RESOLVES IT ITSELF, LIKE A BROWSER
+func (g *NetGuard) CheckURL(ctx context.Context, rawURL string) error {
+	return g.checkURL(ctx, rawURL, DNSPolicy{})
+}
+
+// CHECK A URL, RESOLVING ITS HOST THE WAY THE CALLER WILL
+func (g *NetGuard) checkURL(ctx context.Context, rawURL string, dns DNSPolicy) error {
+	if g == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		return nil // data:, blob:, about: ETC. DON'T LEAVE THE BROWSER
+	}
+	if err := g.checkHost(u.Hostname()); err != nil {
+		return err
+	}
+	if g.allowsHost(u.Hostname()) || len(g.blocked) == 0 {
+		return nil
+	}
+	_, err = g.resolve(ctx, u.Hostname(), dns, EgressPolicy{})
+	return err
+}
+
+// ABORT A BROWSER CONTEXT'S REQUESTS TO BLOCKED HOSTS. VERDICTS ARE KEPT PER HOST FOR THE
+// CONTEXT'S LIFETIME SO A PAGE FULL OF ASSETS DOESN'T RESOLVE THE SAME NAME OVER AND OVER
+func (g *NetGuard) guardContext(browserContext playwright.BrowserContext, dns DNSPolicy) error {
+	if g == nil {
+		return nil
+	}
+	var verdicts sync.Map
+	return browserContext.Route("**/*", func(route playwright.Route) {
+		u, err := url.Parse(route.Request().URL())
+		if err != nil {
+			route.Abort("blockedbyclient")
+			return
+		}
+		key := u.Scheme + "://" + u.Host
+		verdict, ok := verdicts.Load(key)
+		if !ok {
+			verdict = g.checkURL(context.Background(), u.String(), dns)
+			verdicts.Store(key, verdict)
+		}
+		if verdict != nil {
+			route.Abort("blockedbyclient")
+			return
+		}
+		route.Continue()
+	})
+}
+
+// CHECK A USER-SUPPLIED URL AGAINST THE ENGINE'S SSRF SETTINGS (NIL WHEN ALLOWED OR OFF)
+func (e *Engine) CheckURL(ctx context.Context, rawURL string) error {
+	return e.guard.CheckURL(ctx, rawURL)
+}
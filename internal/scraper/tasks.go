@@ -1,17 +1,21 @@
 package scraper
 
 import (
+	"cmp"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/nickheyer/Crepes/internal/config"
 	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/storage"
 	"github.com/nickheyer/Crepes/internal/utils"
 	"github.com/playwright-community/playwright-go"
 )
@@ -26,22 +30,27 @@ var (
 	ErrOperationFailed      = errors.New("OPERATION FAILED")
 )
 
-// HELPER FUNCTION TO GET PAGE FROM RESOURCE MANAGER
-func getPage(ctx *TaskContext, pageIdInput any) (playwright.Page, error) {
-	var pageId string
-
-	switch id := pageIdInput.(type) {
+// RESOURCE ID FROM A TASK INPUT, REJECTING ANYTHING THAT ISN'T THE ID OR AN OBJECT HOLDING IT
+func parseResourceID(input any, key, kind string) (string, error) {
+	switch id := input.(type) {
 	case string:
-		pageId = id
+		return id, nil
 	case map[string]any:
-		// HANDLE CASE WHERE PAGE ID IS NESTED IN JSON
-		if val, ok := id["pageId"].(string); ok {
-			pageId = val
-		} else {
-			return nil, fmt.Errorf("INVALID PAGE ID FORMAT")
+		// HANDLE CASE WHERE THE ID IS NESTED IN JSON
+		if val, ok := id[key].(string); ok {
+			return val, nil
 		}
+		return "", fmt.Errorf("INVALID %s ID FORMAT", kind)
 	default:
-		return nil, fmt.Errorf("INVALID PAGE ID TYPE: %T", pageIdInput)
+		return "", fmt.Errorf("INVALID %s ID TYPE: %T", kind, input)
+	}
+}
+
+// HELPER FUNCTION TO GET PAGE FROM RESOURCE MANAGER
+func getPage(ctx *TaskContext, pageIdInput any) (playwright.Page, error) {
+	pageId, err := parseResourceID(pageIdInput, "pageId", "PAGE")
+	if err != nil {
+		return nil, err
 	}
 
 	// GET PAGE FROM RESOURCE MANAGER
@@ -52,7 +61,12 @@ func getPage(ctx *TaskContext, pageIdInput any) (playwright.Page, error) {
 
 	page, ok := resource.(playwright.Page)
 	if !ok {
-		return nil, fmt.Errorf("RESOURCE IS NOT A PAGE")
+		return nil, fmt.Errorf("RESOURCE %s IS NOT A PAGE", pageId)
+	}
+
+	// A PAGE WHOSE BROWSER OR RENDERER DIED IS REOPENED UNDER THE SAME ID
+	if recipe, tracked := ctx.ResourceManager.recipe(ctx.JobID, pageId); tracked && ctx.Engine != nil && pageDead(page, recipe) {
+		return ctx.Engine.revivePage(ctx.Context, ctx.JobID, pageId, ctx.Logger)
 	}
 
 	return page, nil
@@ -60,20 +74,9 @@ func getPage(ctx *TaskContext, pageIdInput any) (playwright.Page, error) {
 
 // HELPER FUNCTION TO GET BROWSER FROM RESOURCE MANAGER
 func getBrowser(ctx *TaskContext, browserIdInput any) (playwright.Browser, error) {
-	var browserId string
-
-	switch id := browserIdInput.(type) {
-	case string:
-		browserId = id
-	case map[string]any:
-		// HANDLE CASE WHERE BROWSER ID IS NESTED IN JSON
-		if val, ok := id["browserId"].(string); ok {
-			browserId = val
-		} else {
-			return nil, fmt.Errorf("INVALID BROWSER ID FORMAT")
-		}
-	default:
-		return nil, fmt.Errorf("INVALID BROWSER ID TYPE: %T", browserIdInput)
+	browserId, err := parseResourceID(browserIdInput, "browserId", "BROWSER")
+	if err != nil {
+		return nil, err
 	}
 
 	// GET BROWSER FROM RESOURCE MANAGER
@@ -84,7 +87,12 @@ func getBrowser(ctx *TaskContext, browserIdInput any) (playwright.Browser, error
 
 	browser, ok := resource.(playwright.Browser)
 	if !ok {
-		return nil, fmt.Errorf("RESOURCE IS NOT A BROWSER")
+		return nil, fmt.Errorf("RESOURCE %s IS NOT A BROWSER", browserId)
+	}
+
+	// A BROWSER THAT DIED IS RELAUNCHED UNDER THE SAME ID
+	if _, tracked := ctx.ResourceManager.recipe(ctx.JobID, browserId); tracked && ctx.Engine != nil && !browser.IsConnected() {
+		return ctx.Engine.reviveBrowser(ctx.Context, ctx.JobID, browserId, ctx.Logger)
 	}
 
 	return browser, nil
@@ -150,13 +158,14 @@ func (t *CreateBrowserTask) Execute(ctx *TaskContext, config map[string]any) (Ta
 	browserId := fmt.Sprintf("browser_%s", utils.GenerateID(""))
 
 	// LAUNCH BROWSER WITH STEALTH MODE
-	browser, err := ctx.Engine.launchBrowser(headless)
+	browser, err := ctx.Engine.launchBrowser(ctx.Context, ctx.JobID, headless)
 	if err != nil {
 		return TaskData{}, err
 	}
 
-	// STORE BROWSER IN RESOURCE MANAGER
+	// STORE BROWSER IN RESOURCE MANAGER, WITH HOW TO RELAUNCH IT IF IT DIES
 	ctx.ResourceManager.CreateResource(ctx.JobID, browserId, "browser", *browser)
+	ctx.ResourceManager.setRecipe(ctx.JobID, browserId, resourceRecipe{kind: "browser", headless: headless})
 
 	ctx.Logger.Printf("BROWSER CREATED WITH ID: %s", browserId)
 
@@ -179,6 +188,7 @@ func (t *CreatePageTask) GetInputSchema() map[string]string {
 		"viewport":    "object?",  // OPTIONAL
 		"locale":      "string?",  // OPTIONAL
 		"recordVideo": "boolean?", // OPTIONAL
+		"headers":     "object?",  // OPTIONAL (EXTRA HTTP HEADERS, ON TOP OF THE JOB'S)
 	}
 }
 
@@ -205,9 +215,11 @@ func (t *CreatePageTask) Execute(ctx *TaskContext, config map[string]any) (TaskD
 	// PAGE OPTIONS
 	pageOptions := playwright.BrowserNewPageOptions{}
 
-	// SET USER AGENT IF PROVIDED
+	// SET USER AGENT IF PROVIDED, OTHERWISE AS THE JOB'S USER AGENT STRATEGY PICKS
 	if userAgent, ok := config["userAgent"].(string); ok && userAgent != "" {
 		pageOptions.UserAgent = playwright.String(userAgent)
+	} else if userAgent := ctx.Engine.runSettings(ctx.JobID).pickUserAgent(); userAgent != "" {
+		pageOptions.UserAgent = playwright.String(userAgent)
 	}
 
 	// SET VIEWPORT IF PROVIDED
@@ -227,8 +239,12 @@ func (t *CreatePageTask) Execute(ctx *TaskContext, config map[string]any) (TaskD
 
 	// SET RECORD VIDEO IF PROVIDED
 	if recordVideo, ok := config["recordVideo"].(bool); ok && recordVideo {
+		dir := "videos"
+		if ctx.Engine != nil {
+			dir = filepath.Join(ctx.Engine.cfg.StoragePath, "videos")
+		}
 		pageOptions.RecordVideo = &playwright.RecordVideo{
-			Dir: "videos",
+			Dir: dir,
 			Size: &playwright.Size{
 				Width:  1280,
 				Height: 720,
@@ -236,17 +252,85 @@ func (t *CreatePageTask) Execute(ctx *TaskContext, config map[string]any) (TaskD
 		}
 	}
 
-	// CREATE PAGE
-	page, err := browser.NewPage(pageOptions)
-	if err != nil {
-		return TaskData{}, fmt.Errorf("%w: %v", ErrPageCreation, err)
+	// OPEN WITH THE JOB'S PERSISTED COOKIES AND LOCAL STORAGE
+	if ctx.Engine != nil {
+		if session := ctx.Engine.jobSession(ctx.JobID); session != nil {
+			pageOptions.StorageState = session.pageState()
+		}
+	}
+
+	// SEND THE JOB'S HEADERS, THEN THE TASK'S, WITH EVERY REQUEST THE PAGE MAKES
+	extraHeaders := http.Header{}
+	if ctx.Engine != nil {
+		ctx.Engine.setJobHeaders(ctx.JobID, extraHeaders)
+
+		// BROWSERS CAN ONLY SKIP CERTIFICATE CHECKS FOR EVERY HOST, SO ONLY A JOB THAT
+		// TURNS tlsVerify OFF ENTIRELY DOES
+		if !ctx.Engine.tlsPolicy(ctx.JobID).Verify {
+			pageOptions.IgnoreHttpsErrors = playwright.Bool(true)
+		}
+	}
+	if headers, ok := config["headers"].(map[string]any); ok {
+		for key, value := range headers {
+			if strValue, ok := value.(string); ok {
+				extraHeaders.Set(key, strValue)
+			}
+		}
+	}
+	if len(extraHeaders) > 0 {
+		pageOptions.ExtraHttpHeaders = make(map[string]string, len(extraHeaders))
+		for key := range extraHeaders {
+			pageOptions.ExtraHttpHeaders[key] = extraHeaders.Get(key)
+		}
 	}
 
 	// GENERATE PAGE ID
 	pageId := fmt.Sprintf("page_%s", utils.GenerateID(""))
 
-	// STORE PAGE IN RESOURCE MANAGER
+	// RECORD NETWORK TRAFFIC SO FAILURE BUNDLES CAN INCLUDE A HAR
+	if ctx.Engine != nil && ctx.Engine.harEnabled(ctx.JobID) {
+		harFile := filepath.Join(ctx.Engine.cfg.StoragePath, harPath(ctx.JobID, pageId))
+		if err := os.MkdirAll(filepath.Dir(harFile), 0755); err == nil {
+			pageOptions.RecordHarPath = playwright.String(harFile)
+		} else {
+			ctx.Logger.Printf("FAILED TO CREATE HAR DIRECTORY: %v", err)
+		}
+	}
+
+	// CREATE PAGE, ONCE THE BROWSER HAS ROOM FOR ANOTHER TAB
+	var page playwright.Page
+	if ctx.Engine != nil {
+		if err := ctx.Engine.browsers.reserveTab(ctx.Context, browser); err != nil {
+			return TaskData{}, fmt.Errorf("%w: WAITING FOR A TAB: %v", ErrPageCreation, err)
+		}
+		page, err = browser.NewPage(pageOptions)
+		ctx.Engine.browsers.openedTab(browser, page)
+	} else {
+		page, err = browser.NewPage(pageOptions)
+	}
+	if err != nil {
+		return TaskData{}, fmt.Errorf("%w: %v", ErrPageCreation, err)
+	}
+
+	// KEEP THE PAGE OFF BLOCKED ADDRESSES
+	if ctx.Engine != nil {
+		if err := ctx.Engine.guard.guardContext(page.Context(), ctx.Engine.dnsPolicy(ctx.JobID)); err != nil {
+			page.Close()
+			return TaskData{}, fmt.Errorf("%w: SSRF PROTECTION: %v", ErrPageCreation, err)
+		}
+		ctx.Engine.watchBudget(ctx.JobID, page.Context())
+		ctx.Engine.watchThrottle(ctx.JobID, page.Context())
+		if err := ctx.Engine.routePlayback(ctx.JobID, page.Context()); err != nil {
+			page.Close()
+			return TaskData{}, fmt.Errorf("%w: PLAYBACK: %v", ErrPageCreation, err)
+		}
+	}
+
+	// STORE PAGE IN RESOURCE MANAGER, WITH HOW TO REOPEN IT IF IT DIES
 	ctx.ResourceManager.CreateResource(ctx.JobID, pageId, "page", page)
+	if ctx.Engine != nil {
+		ctx.Engine.trackPage(ctx.JobID, pageId, resourceID(config["browserId"], "browserId"), page, pageOptions)
+	}
 
 	ctx.Logger.Printf("PAGE CREATED WITH ID: %s", pageId)
 
@@ -286,10 +370,13 @@ func (t *DisposeBrowserTask) Execute(ctx *TaskContext, config map[string]any) (T
 		return TaskData{}, err
 	}
 
-	browserId, _ := config["browserId"].(string)
+	browserId := resourceID(config["browserId"], "browserId")
 	ctx.Logger.Printf("DISPOSING BROWSER: %s", browserId)
 
-	// CLOSE BROWSER
+	// KEEP THE SESSION OF ITS PAGES, THEN CLOSE BROWSER
+	if ctx.Engine != nil {
+		ctx.Engine.captureResourceSession(ctx.JobID, browser)
+	}
 	err = browser.Close()
 	if err != nil {
 		return TaskData{}, fmt.Errorf("FAILED TO CLOSE BROWSER: %v", err)
@@ -333,10 +420,13 @@ func (t *DisposePageTask) Execute(ctx *TaskContext, config map[string]any) (Task
 		return TaskData{}, err
 	}
 
-	pageId, _ := config["pageId"].(string)
+	pageId := resourceID(config["pageId"], "pageId")
 	ctx.Logger.Printf("DISPOSING PAGE: %s", pageId)
 
-	// CLOSE PAGE
+	// KEEP THE PAGE'S SESSION, THEN CLOSE PAGE
+	if ctx.Engine != nil {
+		ctx.Engine.captureResourceSession(ctx.JobID, page)
+	}
 	err = page.Close()
 	if err != nil {
 		return TaskData{}, fmt.Errorf("FAILED TO CLOSE PAGE: %v", err)
@@ -362,10 +452,12 @@ type NavigateTask struct{}
 
 func (t *NavigateTask) GetInputSchema() map[string]string {
 	return map[string]string{
-		"pageId":    "string",  // REQUIRED
-		"url":       "string",  // REQUIRED
-		"waitUntil": "string?", // OPTIONAL (load, domcontentloaded, networkidle)
-		"timeout":   "number?", // OPTIONAL
+		"pageId":          "string",   // REQUIRED
+		"url":             "string",   // REQUIRED
+		"waitUntil":       "string?",  // OPTIONAL (load, domcontentloaded, networkidle)
+		"timeout":         "number?",  // OPTIONAL
+		"waybackFallback": "boolean?", // OPTIONAL (LOAD THE LATEST WAYBACK SNAPSHOT ON 404/410)
+		"waybackSubmit":   "boolean?", // OPTIONAL (SUBMIT THE URL TO SAVEPAGENOW ON SUCCESS)
 	}
 }
 
@@ -417,6 +509,17 @@ func (t *NavigateTask) Execute(ctx *TaskContext, config map[string]any) (TaskDat
 		options.Timeout = playwright.Float(timeout)
 	}
 
+	// REFUSE BLOCKED ADDRESSES UP FRONT FOR A CLEAR ERROR (THE PAGE'S ROUTE WOULD ABORT THEM ANYWAY)
+	if ctx.Engine != nil {
+		if err := ctx.Engine.guard.checkURL(ctx.Context, url, ctx.Engine.dnsPolicy(ctx.JobID)); err != nil {
+			return TaskData{}, fmt.Errorf("NAVIGATION FAILED: %w", err)
+		}
+		// WAIT FOR THE HOST'S TURN WHEN THE RUN IS THROTTLED
+		if err := ctx.Engine.awaitThrottle(ctx.Context, ctx.JobID, url); err != nil {
+			return TaskData{}, fmt.Errorf("NAVIGATION FAILED: %w", err)
+		}
+	}
+
 	// PERFORM NAVIGATION
 	response, err := page.Goto(url, options)
 	if err != nil {
@@ -425,22 +528,61 @@ func (t *NavigateTask) Execute(ctx *TaskContext, config map[string]any) (TaskDat
 
 	// GET RESULT INFORMATION
 	status := 0
+	redirectChain := []any{}
 	if response != nil {
 		status = response.Status()
+
+		// WALK BACK THROUGH THE REDIRECTS THAT LED TO THIS RESPONSE
+		for req := response.Request().RedirectedFrom(); req != nil; req = req.RedirectedFrom() {
+			redirectChain = append([]any{req.URL()}, redirectChain...)
+		}
+		if len(redirectChain) > 0 {
+			redirectChain = append(redirectChain, response.URL())
+		}
+	}
+
+	// FALL BACK TO THE LATEST WAYBACK SNAPSHOT FOR MISSING PAGES
+	var snapshot *WaybackSnapshot
+	if (status == http.StatusNotFound || status == http.StatusGone) && waybackOption(ctx, config, "waybackFallback") {
+		client := NewHTTPClient(HTTPClientOptions{Timeout: 30 * time.Second})
+		if found, err := LatestSnapshot(ctx.Context, client, url); err != nil {
+			ctx.Logger.Printf("NO WAYBACK FALLBACK FOR %s: %v", url, err)
+		} else {
+			ctx.Logger.Printf("PAGE RETURNED %d, LOADING WAYBACK SNAPSHOT %s", status, found.Timestamp)
+			if response, err = page.Goto(found.FrameURL(), options); err != nil {
+				return TaskData{}, fmt.Errorf("WAYBACK NAVIGATION FAILED: %v", err)
+			}
+			if response != nil {
+				status = response.Status()
+			}
+			snapshot = &found
+		}
 	}
 
 	currentUrl := page.URL()
+	ok := status >= 200 && status < 400
 
 	ctx.Logger.Printf("NAVIGATION COMPLETE: %s (STATUS: %d)", currentUrl, status)
 
+	// SUBMIT LIVE PAGES TO THE WAYBACK MACHINE
+	if ok && snapshot == nil && waybackOption(ctx, config, "waybackSubmit") {
+		ctx.Engine.wayback.Submit(ctx.JobID, currentUrl)
+	}
+
+	result := map[string]any{
+		"status":        status,
+		"url":           currentUrl,
+		"ok":            ok,
+		"redirectChain": redirectChain,
+	}
+	if snapshot != nil {
+		result["waybackSnapshot"] = snapshot
+	}
+
 	// RETURN NAVIGATION RESULT
 	return TaskData{
-		Type: "object",
-		Value: map[string]any{
-			"status": status,
-			"url":    currentUrl,
-			"ok":     status >= 200 && status < 400,
-		},
+		Type:  "object",
+		Value: result,
 	}, nil
 }
 
@@ -712,12 +854,14 @@ type TakeScreenshotTask struct{}
 
 func (t *TakeScreenshotTask) GetInputSchema() map[string]string {
 	return map[string]string{
-		"pageId":   "string",   // REQUIRED
-		"selector": "string?",  // OPTIONAL (if provided, screenshots just that element)
-		"fullPage": "boolean?", // OPTIONAL
-		"path":     "string?",  // OPTIONAL
-		"quality":  "number?",  // OPTIONAL (0-100, for jpeg only)
-		"type":     "string?",  // OPTIONAL (png, jpeg)
+		"pageId":    "string",   // REQUIRED
+		"selector":  "string?",  // OPTIONAL (if provided, screenshots just that element)
+		"fullPage":  "boolean?", // OPTIONAL
+		"path":      "string?",  // OPTIONAL
+		"quality":   "number?",  // OPTIONAL (0-100, for jpeg only)
+		"type":      "string?",  // OPTIONAL (png, jpeg)
+		"saveAsset": "boolean?", // OPTIONAL (REGISTER THE SCREENSHOT AS A JOB ASSET)
+		"title":     "string?",  // OPTIONAL (ASSET TITLE, DEFAULTS TO THE PAGE TITLE)
 	}
 }
 
@@ -762,9 +906,25 @@ func (t *TakeScreenshotTask) Execute(ctx *TaskContext, config map[string]any) (T
 		options.Quality = playwright.Int(int(quality))
 	}
 
+	// ASSET SCREENSHOTS LIVE IN STORAGE SO THEY CAN BE SERVED
+	saveAsset, _ := config["saveAsset"].(bool)
+
 	// SET PATH IF PROVIDED
 	var screenshotPath string
-	if path, ok := config["path"].(string); ok && path != "" {
+	if saveAsset {
+		dir := filepath.Join(ctx.Engine.cfg.StoragePath, "screenshots")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return TaskData{}, fmt.Errorf("FAILED TO CREATE DIRECTORY: %v", err)
+		}
+		screenshotPath = filepath.Join(dir, fmt.Sprintf("screenshot_%s.%s", utils.GenerateID(""), screenshotType))
+		options.Path = playwright.String(screenshotPath)
+	} else if path, ok := config["path"].(string); ok && path != "" {
+		// PATHS ARE RESOLVED INSIDE STORAGE
+		path, err := ctx.Engine.storageFile(path)
+		if err != nil {
+			return TaskData{}, err
+		}
+
 		// ENSURE DIRECTORY EXISTS
 		dir := filepath.Dir(path)
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -785,7 +945,8 @@ func (t *TakeScreenshotTask) Execute(ctx *TaskContext, config map[string]any) (T
 	// TAKE SCREENSHOT
 	var screenshotData []byte
 
-	if selector, ok := config["selector"].(string); ok && selector != "" {
+	selector, _ := config["selector"].(string)
+	if selector != "" {
 		// TAKE SCREENSHOT OF SPECIFIC ELEMENT
 		ctx.Logger.Printf("TAKING SCREENSHOT OF ELEMENT: %s", selector)
 
@@ -816,19 +977,244 @@ func (t *TakeScreenshotTask) Execute(ctx *TaskContext, config map[string]any) (T
 
 	ctx.Logger.Printf("SCREENSHOT SAVED TO: %s", screenshotPath)
 
+	result := map[string]any{
+		"path":      screenshotPath,
+		"type":      screenshotType,
+		"data":      base64Data,
+		"size":      len(screenshotData),
+		"timestamp": time.Now().Unix(),
+	}
+
+	// REGISTER AS AN ASSET WHEN REQUESTED
+	if saveAsset {
+		title, _ := config["title"].(string)
+		fullPage, _ := config["fullPage"].(bool)
+		asset, err := saveScreenshotAsset(ctx, page, screenshotPath, screenshotType, int64(len(screenshotData)), title, "", models.JSONMap{
+			"selector": selector,
+			"fullPage": fullPage,
+		})
+		if err != nil {
+			return TaskData{}, err
+		}
+		result["assetId"] = asset.ID
+	}
+
 	// RETURN SCREENSHOT DATA
 	return TaskData{
-		Type: "object",
-		Value: map[string]any{
-			"path":      screenshotPath,
-			"type":      screenshotType,
-			"data":      base64Data,
-			"size":      len(screenshotData),
-			"timestamp": time.Now().Unix(),
-		},
+		Type:  "object",
+		Value: result,
+	}, nil
+}
+
+// REGISTER A SCREENSHOT FILE AS A JOB ASSET
+func saveScreenshotAsset(ctx *TaskContext, page playwright.Page, path, imageType string, size int64, title, assetURL string, metadata models.JSONMap) (models.Asset, error) {
+	pageTitle, _ := page.Title()
+	if title == "" {
+		title = pageTitle
+	}
+	if assetURL == "" {
+		assetURL = page.URL()
+	}
+
+	// STORE THE PATH RELATIVE TO STORAGE LIKE OTHER SERVED ASSETS
+	localPath, err := utils.RelativeToRoot(ctx.Engine.cfg.StoragePath, path)
+	if err != nil {
+		return models.Asset{}, err
+	}
+
+	metadata["source"] = "screenshot"
+	metadata["contentType"] = "image/" + imageType
+	metadata["pageUrl"] = page.URL()
+	metadata["pageTitle"] = pageTitle
+
+	now := time.Now()
+	asset := models.Asset{
+		ID:        fmt.Sprintf("asset_%s", utils.GenerateID("")),
+		JobID:     ctx.JobID,
+		URL:       assetURL,
+		Type:      "image",
+		Title:     title,
+		LocalPath: localPath,
+		Size:      size,
+		Date:      now,
+		Metadata:  metadata,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := registerAsset(ctx, &asset, true); err != nil {
+		return models.Asset{}, err
+	}
+	return asset, nil
+}
+
+// SCREENSHOT ELEMENTS TASK (ONE ASSET PER MATCHING ELEMENT)
+type ScreenshotElementsTask struct{}
+
+func (t *ScreenshotElementsTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"pageId":        "string",   // REQUIRED
+		"selector":      "string",   // REQUIRED (EVERY MATCH IS CAPTURED)
+		"titleSelector": "string?",  // OPTIONAL (CHILD SELECTOR WHOSE TEXT BECOMES THE ASSET TITLE)
+		"linkSelector":  "string?",  // OPTIONAL (CHILD SELECTOR WHOSE HREF IS RECORDED AS THE ASSET URL)
+		"limit":         "number?",  // OPTIONAL (MAX ELEMENTS TO CAPTURE)
+		"quality":       "number?",  // OPTIONAL (0-100, for jpeg only)
+		"type":          "string?",  // OPTIONAL (png, jpeg)
+		"saveAsset":     "boolean?", // OPTIONAL (defaults to true)
+	}
+}
+
+func (t *ScreenshotElementsTask) GetOutputSchema() string {
+	return "array" // RETURNS ONE ENTRY PER CAPTURED ELEMENT
+}
+
+func (t *ScreenshotElementsTask) ValidateConfig(config map[string]any) error {
+	if _, ok := config["pageId"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	if _, ok := config["selector"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	return nil
+}
+
+func (t *ScreenshotElementsTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	// GET PAGE FROM RESOURCE MANAGER
+	page, err := getPage(ctx, config["pageId"])
+	if err != nil {
+		return TaskData{}, err
+	}
+
+	selector, _ := config["selector"].(string)
+	titleSelector, _ := config["titleSelector"].(string)
+	linkSelector, _ := config["linkSelector"].(string)
+
+	saveAsset := true
+	if sa, ok := config["saveAsset"].(bool); ok {
+		saveAsset = sa
+	}
+
+	screenshotType := "png"
+	if typeVal, ok := config["type"].(string); ok && (typeVal == "png" || typeVal == "jpeg") {
+		screenshotType = typeVal
+	}
+	options := playwright.ElementHandleScreenshotOptions{
+		Type: playwright.ScreenshotTypePng,
+	}
+	if screenshotType == "jpeg" {
+		options.Type = playwright.ScreenshotTypeJpeg
+		if quality, ok := config["quality"].(float64); ok && quality >= 0 && quality <= 100 {
+			options.Quality = playwright.Int(int(quality))
+		}
+	}
+
+	// FIND ALL MATCHING ELEMENTS
+	elements, err := page.QuerySelectorAll(selector)
+	if err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO QUERY ELEMENTS: %v", err)
+	}
+	if limit, ok := config["limit"].(float64); ok && limit > 0 && int(limit) < len(elements) {
+		elements = elements[:int(limit)]
+	}
+
+	ctx.Logger.Printf("CAPTURING %d ELEMENTS MATCHING: %s", len(elements), selector)
+
+	dir := filepath.Join(ctx.Engine.cfg.StoragePath, "screenshots")
+	if !saveAsset {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO CREATE DIRECTORY: %v", err)
+	}
+
+	results := make([]any, 0, len(elements))
+	for i, element := range elements {
+		// STOP PROMPTLY IF THE JOB IS CANCELLED
+		if ctx.Context.Err() != nil {
+			return TaskData{}, ctx.Context.Err()
+		}
+
+		// ELEMENTS OUTSIDE THE VIEWPORT MUST BE SCROLLED IN BEFORE CAPTURE
+		element.ScrollIntoViewIfNeeded()
+
+		path := filepath.Join(dir, fmt.Sprintf("element_%s.%s", utils.GenerateID(""), screenshotType))
+		options.Path = playwright.String(path)
+		data, err := element.Screenshot(options)
+		if err != nil {
+			// HIDDEN OR DETACHED ELEMENTS ARE SKIPPED, NOT FATAL
+			ctx.Logger.Printf("SKIPPING ELEMENT %d: %v", i, err)
+			continue
+		}
+
+		title := elementChildText(element, titleSelector)
+		href := elementChildAttr(element, linkSelector, "href")
+
+		entry := map[string]any{
+			"index": i,
+			"path":  path,
+			"size":  len(data),
+			"title": title,
+			"href":  href,
+		}
+
+		if saveAsset {
+			text, _ := element.InnerText()
+			if len(text) > 500 {
+				text = text[:500]
+			}
+			asset, err := saveScreenshotAsset(ctx, page, path, screenshotType, int64(len(data)), title, href, models.JSONMap{
+				"selector": selector,
+				"index":    i,
+				"text":     text,
+				"href":     href,
+			})
+			if err != nil {
+				return TaskData{}, err
+			}
+			entry["assetId"] = asset.ID
+		}
+
+		results = append(results, entry)
+	}
+
+	ctx.Logger.Printf("CAPTURED %d/%d ELEMENTS", len(results), len(elements))
+
+	return TaskData{
+		Type:  "array",
+		Value: results,
 	}, nil
 }
 
+// TEXT OF A CHILD ELEMENT (OR THE ELEMENT ITSELF WHEN NO SELECTOR IS GIVEN)
+func elementChildText(element playwright.ElementHandle, selector string) string {
+	if selector == "" {
+		return ""
+	}
+	child, err := element.QuerySelector(selector)
+	if err != nil || child == nil {
+		return ""
+	}
+	text, _ := child.InnerText()
+	return strings.TrimSpace(text)
+}
+
+// ATTRIBUTE OF A CHILD ELEMENT, RESOLVED AGAINST THE PAGE FOR LINKS
+func elementChildAttr(element playwright.ElementHandle, selector, attr string) string {
+	if selector == "" {
+		return ""
+	}
+	child, err := element.QuerySelector(selector)
+	if err != nil || child == nil {
+		return ""
+	}
+	// READ THE PROPERTY SO RELATIVE HREFS COME BACK ABSOLUTE
+	value, err := child.Evaluate("(el, attr) => el[attr] || el.getAttribute(attr) || ''", attr)
+	if err != nil {
+		return ""
+	}
+	str, _ := value.(string)
+	return str
+}
+
 // EXECUTE SCRIPT TASK
 type ExecuteScriptTask struct{}
 
@@ -921,6 +1307,7 @@ func (t *ClickTask) GetInputSchema() map[string]string {
 		"clickCount": "number?",  // OPTIONAL
 		"timeout":    "number?",  // OPTIONAL
 		"force":      "boolean?", // OPTIONAL
+		"humanize":   "boolean?", // OPTIONAL (CURVED MOUSE PATH AND PAUSES; DEFAULTS TO THE JOB'S RULE OR SETTING)
 	}
 }
 
@@ -981,7 +1368,11 @@ func (t *ClickTask) Execute(ctx *TaskContext, config map[string]any) (TaskData,
 	}
 
 	// PERFORM CLICK
-	err = page.Click(selector, options)
+	if humanizeEnabled(ctx, config) {
+		err = humanClick(ctx, page, selector, playwright.MouseClickOptions{Button: options.Button, ClickCount: options.ClickCount}, options.Timeout)
+	} else {
+		err = page.Click(selector, options)
+	}
 	if err != nil {
 		return TaskData{}, fmt.Errorf("CLICK FAILED: %v", err)
 	}
@@ -1005,6 +1396,7 @@ func (t *TypeTask) GetInputSchema() map[string]string {
 		"delay":    "number?",  // OPTIONAL
 		"clear":    "boolean?", // OPTIONAL (clear field before typing)
 		"timeout":  "number?",  // OPTIONAL
+		"humanize": "boolean?", // OPTIONAL (CLICK INTO THE FIELD AND TYPE WITH A VARYING CADENCE)
 	}
 }
 
@@ -1038,6 +1430,30 @@ func (t *TypeTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, e
 
 	ctx.Logger.Printf("TYPING TEXT INTO ELEMENT: %s", selector)
 
+	// TYPE LIKE A PERSON: CLICK INTO THE FIELD, CLEAR IT IF ASKED AND TYPE KEY BY KEY
+	if humanizeEnabled(ctx, config) {
+		var timeout *float64
+		if t, ok := config["timeout"].(float64); ok && t > 0 {
+			timeout = playwright.Float(t)
+		}
+		if err := humanClick(ctx, page, selector, playwright.MouseClickOptions{}, timeout); err != nil {
+			return TaskData{}, fmt.Errorf("FIELD CLICK FAILED: %v", err)
+		}
+		if clear, ok := config["clear"].(bool); ok && clear {
+			if err := page.Keyboard().Press("ControlOrMeta+A"); err != nil {
+				return TaskData{}, fmt.Errorf("SELECT ALL FAILED: %v", err)
+			}
+			if err := page.Keyboard().Press("Backspace"); err != nil {
+				return TaskData{}, fmt.Errorf("DELETE FAILED: %v", err)
+			}
+		}
+		if err := humanType(ctx, page, text); err != nil {
+			return TaskData{}, err
+		}
+		ctx.Logger.Printf("TEXT TYPED SUCCESSFULLY")
+		return TaskData{Type: "boolean", Value: true}, nil
+	}
+
 	// CLEAR FIELD FIRST IF REQUESTED
 	if clear, ok := config["clear"].(bool); ok && clear {
 		// CLICK THE FIELD FIRST
@@ -1199,10 +1615,11 @@ type HoverTask struct{}
 
 func (t *HoverTask) GetInputSchema() map[string]string {
 	return map[string]string{
-		"pageId":   "string",  // REQUIRED
-		"selector": "string",  // REQUIRED
-		"timeout":  "number?", // OPTIONAL
-		"position": "object?", // OPTIONAL (x, y coordinates)
+		"pageId":   "string",   // REQUIRED
+		"selector": "string",   // REQUIRED
+		"timeout":  "number?",  // OPTIONAL
+		"position": "object?",  // OPTIONAL (x, y coordinates)
+		"humanize": "boolean?", // OPTIONAL (CURVED MOUSE PATH TO A RANDOM POINT ON THE ELEMENT)
 	}
 }
 
@@ -1253,7 +1670,14 @@ func (t *HoverTask) Execute(ctx *TaskContext, config map[string]any) (TaskData,
 	}
 
 	// PERFORM HOVER
-	err = page.Hover(selector, options)
+	if humanizeEnabled(ctx, config) && options.Position == nil {
+		var point humanPoint
+		if point, err = humanTarget(ctx, page, selector, options.Timeout); err == nil {
+			err = humanMove(ctx, page, point)
+		}
+	} else {
+		err = page.Hover(selector, options)
+	}
 	if err != nil {
 		return TaskData{}, fmt.Errorf("HOVER FAILED: %v", err)
 	}
@@ -1271,12 +1695,13 @@ type ScrollTask struct{}
 
 func (t *ScrollTask) GetInputSchema() map[string]string {
 	return map[string]string{
-		"pageId":    "string",  // REQUIRED
-		"selector":  "string?", // OPTIONAL (if not provided, scrolls the page)
-		"direction": "string?", // OPTIONAL (up, down, left, right)
-		"distance":  "number?", // OPTIONAL (pixels to scroll)
-		"behavior":  "string?", // OPTIONAL (auto, smooth)
-		"toElement": "string?", // OPTIONAL (selector of element to scroll to)
+		"pageId":    "string",   // REQUIRED
+		"selector":  "string?",  // OPTIONAL (if not provided, scrolls the page)
+		"direction": "string?",  // OPTIONAL (up, down, left, right)
+		"distance":  "number?",  // OPTIONAL (pixels to scroll)
+		"behavior":  "string?",  // OPTIONAL (auto, smooth)
+		"toElement": "string?",  // OPTIONAL (selector of element to scroll to)
+		"humanize":  "boolean?", // OPTIONAL (UNEVEN WHEEL STEPS OVER THE ELEMENT OR PAGE)
 	}
 }
 
@@ -1350,6 +1775,24 @@ func (t *ScrollTask) Execute(ctx *TaskContext, config map[string]any) (TaskData,
 		x = distance
 	}
 
+	// WHEEL THE MOUSE, OVER THE ELEMENT IF ONE IS GIVEN
+	if humanizeEnabled(ctx, config) {
+		if selector, ok := config["selector"].(string); ok && selector != "" {
+			point, err := humanTarget(ctx, page, selector, nil)
+			if err != nil {
+				return TaskData{}, fmt.Errorf("ELEMENT SCROLL FAILED: %v", err)
+			}
+			if err := humanMove(ctx, page, point); err != nil {
+				return TaskData{}, err
+			}
+		}
+		if err := humanScroll(ctx, page, x, y); err != nil {
+			return TaskData{}, err
+		}
+		ctx.Logger.Printf("SCROLL PERFORMED SUCCESSFULLY")
+		return TaskData{Type: "boolean", Value: true}, nil
+	}
+
 	// IF SELECTOR IS PROVIDED, SCROLL THAT ELEMENT
 	if selector, ok := config["selector"].(string); ok && selector != "" {
 		ctx.Logger.Printf("SCROLLING ELEMENT: %s", selector)
@@ -1406,11 +1849,13 @@ type ExtractTextTask struct{}
 
 func (t *ExtractTextTask) GetInputSchema() map[string]string {
 	return map[string]string{
-		"pageId":   "string",   // REQUIRED
-		"selector": "string",   // REQUIRED
-		"multiple": "boolean?", // OPTIONAL (get text from multiple elements)
-		"trim":     "boolean?", // OPTIONAL
-		"timeout":  "number?",  // OPTIONAL
+		"pageId":       "string",   // REQUIRED
+		"selector":     "string",   // REQUIRED (CSS OR XPATH)
+		"selectorType": "string?",  // OPTIONAL (css OR xpath, DETECTED WHEN NOT GIVEN)
+		"multiple":     "boolean?", // OPTIONAL (get text from multiple elements)
+		"trim":         "boolean?", // OPTIONAL
+		"timeout":      "number?",  // OPTIONAL
+		"save":         "boolean?", // OPTIONAL (PERSIST AS RECORDS, defaults to false)
 	}
 }
 
@@ -1425,7 +1870,7 @@ func (t *ExtractTextTask) ValidateConfig(config map[string]any) error {
 	if _, ok := config["selector"]; !ok {
 		return ErrMissingRequiredInput
 	}
-	return nil
+	return validateSelectorConfig(config)
 }
 
 func (t *ExtractTextTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
@@ -1436,7 +1881,7 @@ func (t *ExtractTextTask) Execute(ctx *TaskContext, config map[string]any) (Task
 	}
 
 	// GET SELECTOR
-	selector, _ := config["selector"].(string)
+	selector := configSelector(config, "")
 
 	// CHECK IF EXTRACTING MULTIPLE
 	multiple := false
@@ -1469,12 +1914,9 @@ func (t *ExtractTextTask) Execute(ctx *TaskContext, config map[string]any) (Task
 		}
 
 		// EXTRACT TEXT FROM ALL MATCHING ELEMENTS
-		script := `(selector, trim) => {
-			const elements = Array.from(document.querySelectorAll(selector));
-			return elements.map(el => trim ? el.textContent.trim() : el.textContent);
-		}`
+		script := `(elements, trim) => elements.map(el => trim ? el.textContent.trim() : el.textContent)`
 
-		result, err := page.Evaluate(script, []any{selector, trim})
+		result, err := page.Locator(selector).EvaluateAll(script, trim)
 		if err != nil {
 			return TaskData{}, fmt.Errorf("TEXT EXTRACTION FAILED: %v", err)
 		}
@@ -1487,6 +1929,13 @@ func (t *ExtractTextTask) Execute(ctx *TaskContext, config map[string]any) (Task
 
 		ctx.Logger.Printf("EXTRACTED %d TEXT ITEMS", len(textArray))
 
+		// PERSIST AS RECORDS IF REQUESTED
+		if boolConfig(config, "save", false) {
+			if _, err := saveRecords(ctx, "extractText", page.URL(), textArray); err != nil {
+				return TaskData{}, err
+			}
+		}
+
 		return TaskData{
 			Type:  "array",
 			Value: textArray,
@@ -1515,6 +1964,13 @@ func (t *ExtractTextTask) Execute(ctx *TaskContext, config map[string]any) (Task
 
 		ctx.Logger.Printf("EXTRACTED TEXT: %s", text)
 
+		// PERSIST AS A RECORD IF REQUESTED
+		if boolConfig(config, "save", false) {
+			if _, err := saveRecords(ctx, "extractText", page.URL(), []any{text}); err != nil {
+				return TaskData{}, err
+			}
+		}
+
 		return TaskData{
 			Type:  "string",
 			Value: text,
@@ -1527,11 +1983,12 @@ type ExtractAttributeTask struct{}
 
 func (t *ExtractAttributeTask) GetInputSchema() map[string]string {
 	return map[string]string{
-		"pageId":    "string",   // REQUIRED
-		"selector":  "string",   // REQUIRED
-		"attribute": "string",   // REQUIRED
-		"multiple":  "boolean?", // OPTIONAL (get attribute from multiple elements)
-		"timeout":   "number?",  // OPTIONAL
+		"pageId":       "string",   // REQUIRED
+		"selector":     "string",   // REQUIRED (CSS OR XPATH)
+		"selectorType": "string?",  // OPTIONAL (css OR xpath, DETECTED WHEN NOT GIVEN)
+		"attribute":    "string",   // REQUIRED
+		"multiple":     "boolean?", // OPTIONAL (get attribute from multiple elements)
+		"timeout":      "number?",  // OPTIONAL
 	}
 }
 
@@ -1549,7 +2006,7 @@ func (t *ExtractAttributeTask) ValidateConfig(config map[string]any) error {
 	if _, ok := config["attribute"]; !ok {
 		return ErrMissingRequiredInput
 	}
-	return nil
+	return validateSelectorConfig(config)
 }
 
 func (t *ExtractAttributeTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
@@ -1560,7 +2017,7 @@ func (t *ExtractAttributeTask) Execute(ctx *TaskContext, config map[string]any)
 	}
 
 	// GET SELECTOR AND ATTRIBUTE
-	selector, _ := config["selector"].(string)
+	selector := configSelector(config, "")
 	attribute, _ := config["attribute"].(string)
 
 	// CHECK IF EXTRACTING MULTIPLE
@@ -1587,12 +2044,9 @@ func (t *ExtractAttributeTask) Execute(ctx *TaskContext, config map[string]any)
 		}
 
 		// EXTRACT ATTRIBUTE FROM ALL MATCHING ELEMENTS
-		script := fmt.Sprintf(`(selector) => {
-			const elements = Array.from(document.querySelectorAll(selector));
-			return elements.map(el => el.getAttribute('%s') || '');
-		}`, attribute)
+		script := `(elements, attribute) => elements.map(el => el.getAttribute(attribute) || '')`
 
-		result, err := page.Evaluate(script, []any{selector})
+		result, err := page.Locator(selector).EvaluateAll(script, attribute)
 		if err != nil {
 			return TaskData{}, fmt.Errorf("ATTRIBUTE EXTRACTION FAILED: %v", err)
 		}
@@ -1604,6 +2058,7 @@ func (t *ExtractAttributeTask) Execute(ctx *TaskContext, config map[string]any)
 		}
 
 		ctx.Logger.Printf("EXTRACTED %d ATTRIBUTE VALUES", len(attrArray))
+		ctx.Engine.origins.record(ctx.JobID, page, attrArray)
 
 		return TaskData{
 			Type:  "array",
@@ -1627,6 +2082,7 @@ func (t *ExtractAttributeTask) Execute(ctx *TaskContext, config map[string]any)
 		}
 
 		ctx.Logger.Printf("EXTRACTED ATTRIBUTE VALUE: %s", attrValue)
+		ctx.Engine.origins.record(ctx.JobID, page, []any{attrValue})
 
 		return TaskData{
 			Type:  "string",
@@ -1641,7 +2097,8 @@ type ExtractLinksTask struct{}
 func (t *ExtractLinksTask) GetInputSchema() map[string]string {
 	return map[string]string{
 		"pageId":        "string",   // REQUIRED
-		"selector":      "string?",  // OPTIONAL (defaults to 'a')
+		"selector":      "string?",  // OPTIONAL (CSS OR XPATH, defaults to 'a')
+		"selectorType":  "string?",  // OPTIONAL (css OR xpath, DETECTED WHEN NOT GIVEN)
 		"baseUrl":       "string?",  // OPTIONAL (for resolving relative URLs)
 		"normalizeUrls": "boolean?", // OPTIONAL
 		"includeText":   "boolean?", // OPTIONAL (include link text)
@@ -1657,7 +2114,7 @@ func (t *ExtractLinksTask) ValidateConfig(config map[string]any) error {
 	if _, ok := config["pageId"]; !ok {
 		return ErrMissingRequiredInput
 	}
-	return nil
+	return validateSelectorConfig(config)
 }
 
 func (t *ExtractLinksTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
@@ -1668,10 +2125,7 @@ func (t *ExtractLinksTask) Execute(ctx *TaskContext, config map[string]any) (Tas
 	}
 
 	// GET SELECTOR (DEFAULT TO ALL LINKS)
-	selector := "a"
-	if sel, ok := config["selector"].(string); ok && sel != "" {
-		selector = sel
-	}
+	selector := configSelector(config, "a")
 
 	// GET BASE URL FOR RESOLVING RELATIVE LINKS
 	baseUrl := ""
@@ -1713,8 +2167,7 @@ func (t *ExtractLinksTask) Execute(ctx *TaskContext, config map[string]any) (Tas
 	// CREATE SCRIPT TO EXTRACT LINKS
 	var script string
 	if includeText {
-		script = `(selector, baseUrl, normalize) => {
-			const elements = Array.from(document.querySelectorAll(selector));
+		script = `(elements, [baseUrl, normalize]) => {
 			return elements.map(el => {
 				const href = el.getAttribute('href') || '';
 				const url = href ? (normalize ? new URL(href, baseUrl).href : href) : '';
@@ -1726,8 +2179,7 @@ func (t *ExtractLinksTask) Execute(ctx *TaskContext, config map[string]any) (Tas
 			}).filter(link => link.url);
 		}`
 	} else {
-		script = `(selector, baseUrl, normalize) => {
-			const elements = Array.from(document.querySelectorAll(selector));
+		script = `(elements, [baseUrl, normalize]) => {
 			return elements.map(el => {
 				const href = el.getAttribute('href') || '';
 				return href ? (normalize ? new URL(href, baseUrl).href : href) : '';
@@ -1736,7 +2188,7 @@ func (t *ExtractLinksTask) Execute(ctx *TaskContext, config map[string]any) (Tas
 	}
 
 	// EXECUTE SCRIPT TO EXTRACT LINKS
-	result, err := page.Evaluate(script, []any{selector, baseUrl, normalizeUrls})
+	result, err := page.Locator(selector).EvaluateAll(script, []any{baseUrl, normalizeUrls})
 	if err != nil {
 		return TaskData{}, fmt.Errorf("LINK EXTRACTION FAILED: %v", err)
 	}
@@ -1748,6 +2200,7 @@ func (t *ExtractLinksTask) Execute(ctx *TaskContext, config map[string]any) (Tas
 	}
 
 	ctx.Logger.Printf("EXTRACTED %d LINKS", len(links))
+	ctx.Engine.origins.record(ctx.JobID, page, links)
 
 	return TaskData{
 		Type:  "array",
@@ -1761,7 +2214,8 @@ type ExtractImagesTask struct{}
 func (t *ExtractImagesTask) GetInputSchema() map[string]string {
 	return map[string]string{
 		"pageId":          "string",   // REQUIRED
-		"selector":        "string?",  // OPTIONAL (defaults to 'img')
+		"selector":        "string?",  // OPTIONAL (CSS OR XPATH, defaults to 'img')
+		"selectorType":    "string?",  // OPTIONAL (css OR xpath, DETECTED WHEN NOT GIVEN)
 		"baseUrl":         "string?",  // OPTIONAL (for resolving relative URLs)
 		"normalizeUrls":   "boolean?", // OPTIONAL
 		"includeMetadata": "boolean?", // OPTIONAL (include alt, title, dimensions)
@@ -1779,7 +2233,7 @@ func (t *ExtractImagesTask) ValidateConfig(config map[string]any) error {
 	if _, ok := config["pageId"]; !ok {
 		return ErrMissingRequiredInput
 	}
-	return nil
+	return validateSelectorConfig(config)
 }
 
 func (t *ExtractImagesTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
@@ -1790,10 +2244,7 @@ func (t *ExtractImagesTask) Execute(ctx *TaskContext, config map[string]any) (Ta
 	}
 
 	// GET SELECTOR (DEFAULT TO ALL IMAGES)
-	selector := "img"
-	if sel, ok := config["selector"].(string); ok && sel != "" {
-		selector = sel
-	}
+	selector := configSelector(config, "img")
 
 	// GET BASE URL FOR RESOLVING RELATIVE LINKS
 	baseUrl := ""
@@ -1853,8 +2304,7 @@ func (t *ExtractImagesTask) Execute(ctx *TaskContext, config map[string]any) (Ta
 	}
 
 	// CREATE SCRIPT TO EXTRACT IMAGES
-	script := fmt.Sprintf(`(selector, baseUrl, normalize, minWidth, minHeight) => {
-		const elements = Array.from(document.querySelectorAll(selector));
+	script := fmt.Sprintf(`(elements, [baseUrl, normalize, minWidth, minHeight]) => {
 		return elements
 			.filter(img => img.naturalWidth >= minWidth && img.naturalHeight >= minHeight)
 			.map(img => {
@@ -1873,7 +2323,7 @@ func (t *ExtractImagesTask) Execute(ctx *TaskContext, config map[string]any) (Ta
 	}`, resultStruct)
 
 	// EXECUTE SCRIPT TO EXTRACT IMAGES
-	result, err := page.Evaluate(script, []any{selector, baseUrl, normalizeUrls, minWidth, minHeight})
+	result, err := page.Locator(selector).EvaluateAll(script, []any{baseUrl, normalizeUrls, minWidth, minHeight})
 	if err != nil {
 		return TaskData{}, fmt.Errorf("IMAGE EXTRACTION FAILED: %v", err)
 	}
@@ -1885,6 +2335,7 @@ func (t *ExtractImagesTask) Execute(ctx *TaskContext, config map[string]any) (Ta
 	}
 
 	ctx.Logger.Printf("EXTRACTED %d IMAGES", len(images))
+	ctx.Engine.origins.record(ctx.JobID, page, images)
 
 	return TaskData{
 		Type:  "array",
@@ -1901,11 +2352,29 @@ type DownloadAssetTask struct{}
 
 func (t *DownloadAssetTask) GetInputSchema() map[string]string {
 	return map[string]string{
-		"url":      "string",  // REQUIRED
-		"folder":   "string?", // OPTIONAL (defaults to 'downloads')
-		"filename": "string?", // OPTIONAL (auto-generated if not provided)
-		"headers":  "object?", // OPTIONAL (custom headers)
-		"timeout":  "number?", // OPTIONAL
+		"url":             "string",   // REQUIRED
+		"folder":          "string?",  // OPTIONAL (UNDER STORAGE, defaults to 'downloads')
+		"filename":        "string?",  // OPTIONAL (auto-generated if not provided)
+		"headers":         "object?",  // OPTIONAL (custom headers)
+		"auth":            "string?",  // OPTIONAL (NAME OF AN AUTH PROFILE IN THE JOB'S auth RULE)
+		"pageId":          "string?",  // OPTIONAL (PAGE TO BORROW COOKIES, REFERER AND USER AGENT FROM; DEFAULTS TO THE ONE THAT FOUND THE URL)
+		"inheritPage":     "boolean?", // OPTIONAL (DEFAULTS TO TRUE; FALSE SENDS NO PAGE COOKIES OR REFERER)
+		"timeout":         "number?",  // OPTIONAL (ABSOLUTE CAP IN MS)
+		"connectTimeout":  "number?",  // OPTIONAL (MS UNTIL RESPONSE HEADERS)
+		"stallTimeout":    "number?",  // OPTIONAL (MS WITHOUT DATA BEFORE ABORTING)
+		"fingerprint":     "string?",  // OPTIONAL (TLS FINGERPRINT TO IMPERSONATE)
+		"httpVersion":     "string?",  // OPTIONAL (auto, 1.1, 2, 3)
+		"redirectMode":    "string?",  // OPTIONAL (follow, never, same-host)
+		"maxRedirects":    "number?",  // OPTIONAL
+		"fallbackUrls":    "array?",   // OPTIONAL (ALTERNATE SOURCES TRIED IN ORDER)
+		"variant":         "string?",  // OPTIONAL (MANIFEST RENDITION: best, worst, OR A HEIGHT)
+		"waybackFallback": "boolean?", // OPTIONAL (TRY THE LATEST WAYBACK SNAPSHOT ON 404/410)
+		"waybackSubmit":   "boolean?", // OPTIONAL (SUBMIT THE URL TO SAVEPAGENOW ON SUCCESS)
+		"onConflict":      "string?",  // OPTIONAL (overwrite, skip, version OR hash WHEN THE FILE EXISTS; DEFAULTS TO THE JOB'S assetConflict RULE, THEN overwrite)
+		"resume":          "boolean?", // OPTIONAL (DEFAULTS TO TRUE, OR FALSE WHEN THE RUN STORES TO A BUCKET; FALSE NEVER RANGE-REQUESTS AND DROPS PART FILES ON FAILURE)
+		"resumeRetries":   "number?",  // OPTIONAL (RESUMES FROM THE LAST BYTE WRITTEN BEFORE GIVING UP ON A SOURCE, DEFAULTS TO 3)
+		"chunks":          "number?",  // OPTIONAL (PARALLEL RANGE REQUESTS FOR LARGE FILES, 1-16, DEFAULTS TO 1)
+		"chunkMinSize":    "number?",  // OPTIONAL (SMALLEST FILE IN BYTES WORTH SPLITTING, DEFAULTS TO 64MB)
 	}
 }
 
@@ -1917,7 +2386,32 @@ func (t *DownloadAssetTask) ValidateConfig(config map[string]any) error {
 	if _, ok := config["url"]; !ok {
 		return ErrMissingRequiredInput
 	}
-	return nil
+	if fingerprint, ok := config["fingerprint"].(string); ok {
+		if err := ValidateFingerprint(fingerprint); err != nil {
+			return err
+		}
+	}
+	if httpVersion, ok := config["httpVersion"].(string); ok {
+		if err := ValidateHTTPVersion(httpVersion); err != nil {
+			return err
+		}
+	}
+	if redirectMode, ok := config["redirectMode"].(string); ok {
+		if err := ValidateRedirectMode(redirectMode); err != nil {
+			return err
+		}
+	}
+	if variant, ok := config["variant"].(string); ok {
+		if err := ValidateVariantPreference(variant); err != nil {
+			return err
+		}
+	}
+	if policy, ok := config["onConflict"].(string); ok {
+		if err := ValidateConflictPolicy(policy); err != nil {
+			return err
+		}
+	}
+	return validateResumeConfig(config)
 }
 
 func (t *DownloadAssetTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
@@ -1930,127 +2424,637 @@ func (t *DownloadAssetTask) Execute(ctx *TaskContext, config map[string]any) (Ta
 		folder = f
 	}
 
+	// GET FILENAME (AUTO-GENERATE IF NOT PROVIDED)
+	var filename string
+	if f, ok := config["filename"].(string); ok && utils.SafeFilename(f) != "" {
+		filename = utils.SafeFilename(f) // ONE PATH ELEMENT; SUBFOLDERS GO IN folder
+	} else {
+		// GENERATE FILENAME WITH THE URL'S EXTENSION, OR .bin
+		filename = utils.GenerateID("asset") + cmp.Or(urlExtension(url), ".bin")
+	}
+
+	// COMBINE FOLDER AND FILENAME, KEEPING THE RESULT INSIDE STORAGE
+	filePath, err := ctx.Engine.storageFile(filepath.Join(folder, filename))
+	if err != nil {
+		return TaskData{}, err
+	}
+
 	// ENSURE FOLDER EXISTS
-	if err := os.MkdirAll(folder, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return TaskData{}, fmt.Errorf("FAILED TO CREATE DIRECTORY: %v", err)
 	}
 
-	// GET FILENAME (AUTO-GENERATE IF NOT PROVIDED)
-	var filename string
-	if f, ok := config["filename"].(string); ok && f != "" {
-		filename = f
-	} else {
-		// GENERATE FILENAME BASED ON URL
-		filename = utils.GenerateID("asset")
-
-		// TRY TO EXTRACT EXTENSION FROM URL
-		urlParts := strings.Split(url, "?")[0] // REMOVE QUERY PARAMETERS
-		urlPath := strings.Split(urlParts, "/")
-		if len(urlPath) > 0 {
-			lastPart := urlPath[len(urlPath)-1]
-			if strings.Contains(lastPart, ".") {
-				// EXTRACT EXTENSION
-				ext := filepath.Ext(lastPart)
-				if ext != "" {
-					filename += ext
-				}
+	// APPLY THE NAMING CONFLICT POLICY IF THE FILE EXISTS OR IS BEING WRITTEN
+	target, err := ctx.Engine.claimAssetTarget(resolveConflictPolicy(ctx, config), filePath)
+	if err != nil {
+		return TaskData{}, err
+	}
+	defer target.release()
+	if target.action == conflictSkipped {
+		ctx.Logger.Printf("SKIPPING DOWNLOAD OF %s: %s ALREADY EXISTS", url, filePath)
+		return skippedDownload(ctx, url, filePath, target), nil
+	}
+	if target.action != "" {
+		ctx.Logger.Printf("%s EXISTS, WRITING TO %s INSTEAD", filePath, target.path)
+	}
+	filePath = target.path
+
+	// A RUN STORING TO A BUCKET STREAMS THE BODY STRAIGHT INTO IT
+	backend := streamingBackend(ctx, config, target)
+
+	// GET DEADLINE POLICY
+	deadlines := resolveDeadlinePolicy(ctx, config)
+
+	ctx.Logger.Printf("DOWNLOADING ASSET FROM URL: %s TO %s", url, filePath)
+
+	// GET TLS FINGERPRINT (FALL BACK TO GLOBAL SETTING)
+	fingerprint := ctx.Engine.cfg.TLSFingerprint
+	if f, ok := config["fingerprint"].(string); ok && f != "" {
+		fingerprint = f
+	}
+
+	// GET HTTP VERSION PREFERENCE (FALL BACK TO GLOBAL SETTING)
+	httpVersion := ctx.Engine.cfg.HTTPVersion
+	if v, ok := config["httpVersion"].(string); ok && v != "" {
+		httpVersion = v
+	}
+
+	// CREATE HTTP CLIENT (DEADLINES ARE ENFORCED THROUGH THE REQUEST CONTEXT)
+	client := NewHTTPClient(HTTPClientOptions{
+		Fingerprint: fingerprint,
+		HTTPVersion: httpVersion,
+		TLS:         ctx.Engine.tlsPolicy(ctx.JobID),
+		Guard:       ctx.Engine.guard,
+		DNS:         ctx.Engine.dnsPolicy(ctx.JobID),
+		Egress:      ctx.Engine.egressPolicy(ctx.JobID),
+	})
+
+	// BUILD REQUEST HEADERS: THE DISCOVERING PAGE'S IDENTITY, THEN THE JOB'S HEADERS, THEN THE TASK'S
+	header := http.Header{}
+	header.Set("User-Agent", DefaultUserAgent())
+	if boolConfig(config, "inheritPage", true) {
+		if origin, ok := downloadPage(ctx, config, url); ok {
+			identity, err := identityOf(origin)
+			if err != nil {
+				ctx.Logger.Printf("FAILED TO READ PAGE COOKIES: %v", err)
+			}
+			if identity.UserAgent != "" {
+				header.Set("User-Agent", identity.UserAgent)
+			}
+			if identity.Referer != "" {
+				header.Set("Referer", identity.Referer)
+			}
+			if identity.Jar != nil {
+				client.Jar = identity.Jar
+			}
+		}
+	}
+	ctx.Engine.setJobHeaders(ctx.JobID, header)
+	if headers, ok := config["headers"].(map[string]any); ok {
+		for key, value := range headers {
+			if strValue, ok := value.(string); ok {
+				header.Set(key, strValue)
+			}
+		}
+	}
+
+	// PREFERRED SOURCE FIRST, THEN ALTERNATES (OTHER QUALITIES/CDNS)
+	sources := downloadSources(url, config)
+	authName, _ := config["auth"].(string)
+	redirectPolicy := resolveRedirectPolicy(ctx, config)
+	resume := resolveResumePolicy(config)
+
+	// TRACK TRANSFER PROGRESS FOR THE DOWNLOADS API AND EVENT STREAM
+	transfer := ctx.Engine.transfers.Start(ctx.JobID, url, filePath)
+
+	var attempts []any
+	var data TaskData
+	for i, source := range sources {
+		if i > 0 {
+			ctx.Logger.Printf("FALLING BACK TO ALTERNATE SOURCE %d/%d: %s", i+1, len(sources), source)
+			transfer.Retry(err)
+		}
+
+		// APPLY REDIRECT POLICY AND CAPTURE THE CHAIN PER SOURCE
+		var redirectChain []string
+		client.CheckRedirect = redirectPolicy.CheckRedirect(&redirectChain)
+
+		variant, _ := config["variant"].(string)
+		data, err = t.download(ctx, client, source, header, transfer, deadlines, resume, redirectPolicy, filePath, variant, authName, backend, &redirectChain)
+		if err == nil {
+			break
+		}
+		attempts = append(attempts, map[string]any{"url": source, "error": err.Error()})
+		if !shouldTryAlternate(ctx, err) {
+			break
+		}
+	}
+
+	// LAST RESORT: THE WAYBACK MACHINE'S ORIGINAL BYTES FOR MISSING FILES
+	var snapshot *WaybackSnapshot
+	if err != nil && isWaybackFallbackError(err) && waybackOption(ctx, config, "waybackFallback") {
+		if found, lookupErr := LatestSnapshot(ctx.Context, client, url); lookupErr != nil {
+			ctx.Logger.Printf("NO WAYBACK FALLBACK FOR %s: %v", url, lookupErr)
+		} else {
+			ctx.Logger.Printf("FALLING BACK TO WAYBACK SNAPSHOT %s", found.Timestamp)
+			transfer.Retry(err)
+			var redirectChain []string
+			client.CheckRedirect = redirectPolicy.CheckRedirect(&redirectChain)
+			variant, _ := config["variant"].(string)
+			// NO AUTH PROFILE OR REFERER: THE JOB'S CREDENTIALS AND PAGES ARE NOT FOR THE ARCHIVE
+			archiveHeader := header.Clone()
+			archiveHeader.Del("Referer")
+			if data, err = t.download(ctx, client, found.RawURL(), archiveHeader, transfer, deadlines, resume, redirectPolicy, filePath, variant, "", backend, &redirectChain); err == nil {
+				snapshot = &found
+			} else {
+				attempts = append(attempts, map[string]any{"url": found.RawURL(), "error": err.Error()})
 			}
 		}
+	}
+	transfer.Finish(err)
+	if err != nil {
+		if target.policy == "hash" && target.action != "" {
+			os.Remove(filePath) // PARTIAL DOWNLOAD UNDER ITS TEMPORARY NAME
+		}
+		if len(attempts) > 1 {
+			return TaskData{}, fmt.Errorf("ALL %d SOURCES FAILED, LAST ERROR: %w", len(attempts), err)
+		}
+		return TaskData{}, err
+	}
+
+	// RECORD EVERY KNOWN SOURCE AND WHICH ONE WAS USED
+	if info, ok := data.Value.(map[string]any); ok {
+		info["url"] = url
+		info["sources"] = toAnySlice(sources)
+		info["failedSources"] = attempts
+		if snapshot != nil {
+			info["waybackSnapshot"] = snapshot
+		}
 
-		// ENSURE WE HAVE AN EXTENSION
-		if !strings.Contains(filename, ".") {
-			filename += ".bin" // DEFAULT EXTENSION
+		// NAME hash DOWNLOADS AFTER THEIR CONTENT AND RECORD WHAT THE CONFLICT POLICY DID
+		written, _ := info["filePath"].(string)
+		final, err := target.settle(written)
+		if err != nil {
+			return TaskData{}, err
+		}
+		info["filePath"] = final
+		if conflict := target.record(ctx.Engine.cfg.StoragePath, final); conflict != nil {
+			info["conflict"] = conflict
+			ctx.Logger.Printf("NAMING CONFLICT ON %s: %s (%s)", target.requested, target.action, final)
 		}
 	}
 
-	// COMBINE FOLDER AND FILENAME
-	filePath := filepath.Join(folder, filename)
+	// SUBMIT LIVE FILES TO THE WAYBACK MACHINE
+	if snapshot == nil && waybackOption(ctx, config, "waybackSubmit") {
+		ctx.Engine.wayback.Submit(ctx.JobID, url)
+	}
+	return data, nil
+}
 
-	// GET TIMEOUT
-	timeout := float64(60000) // DEFAULT 60 SECONDS
-	if timeoutVal, ok := config["timeout"].(float64); ok && timeoutVal > 0 {
-		timeout = timeoutVal
+// HTTP STATUS ERROR FROM A DOWNLOAD SOURCE
+type downloadStatusError struct {
+	StatusCode int
+	Chain      []string
+}
+
+func (e *downloadStatusError) Error() string {
+	if len(e.Chain) > 0 {
+		return fmt.Sprintf("BAD STATUS CODE: %d (REDIRECT CHAIN: %s)", e.StatusCode, strings.Join(e.Chain, " -> "))
 	}
+	return fmt.Sprintf("BAD STATUS CODE: %d", e.StatusCode)
+}
 
-	ctx.Logger.Printf("DOWNLOADING ASSET FROM URL: %s TO %s", url, filePath)
+// COLLECT THE PREFERRED URL PLUS DEDUPLICATED ALTERNATES FROM fallbackUrls
+func downloadSources(preferred string, config map[string]any) []string {
+	sources := []string{preferred}
+	seen := map[string]bool{preferred: true}
+
+	alternates, _ := config["fallbackUrls"].([]any)
+	for _, alt := range alternates {
+		var altURL string
+		switch v := alt.(type) {
+		case string:
+			altURL = v
+		case map[string]any:
+			altURL, _ = v["url"].(string)
+		}
+		if altURL != "" && !seen[altURL] {
+			seen[altURL] = true
+			sources = append(sources, altURL)
+		}
+	}
+	return sources
+}
+
+// DECIDE WHETHER A FAILED SOURCE SHOULD FALL THROUGH TO THE NEXT ONE
+func shouldTryAlternate(ctx *TaskContext, err error) bool {
+	// JOB CANCELLED, NOT THE SOURCE'S FAULT
+	if ctx.Context.Err() != nil {
+		return false
+	}
+
+	var statusErr *downloadStatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.StatusCode == http.StatusUnauthorized,
+			statusErr.StatusCode == http.StatusForbidden,
+			statusErr.StatusCode == http.StatusNotFound,
+			statusErr.StatusCode == http.StatusGone,
+			statusErr.StatusCode == http.StatusTooManyRequests,
+			statusErr.StatusCode >= 500:
+			return true
+		}
+		return false
+	}
+
+	// STALLS, TIMEOUTS AND NETWORK ERRORS ARE SOURCE-SPECIFIC
+	return true
+}
+
+// THE BUCKET BACKEND A DOWNLOAD STREAMS INTO INSTEAD OF storagePath, OR "" TO WRITE IT TO DISK.
+// FILES THAT HAVE TO BE ON DISK FIRST ARE WRITTEN THERE AND MOVED BY THE UPLOADER: ENCRYPTED AT
+// REST, NAMED BY THEIR HASH, DOWNLOADED IN RESUMABLE PARTS (resume SET, OR SEVERAL chunks) OR
+// KEPT LOCALLY AS WELL (keepLocal)
+func streamingBackend(ctx *TaskContext, config map[string]any, target *assetTarget) string {
+	backend := ctx.Engine.runSettings(ctx.JobID).Storage
+	if backend == "" || backend == storage.BackendLocal || !ctx.Engine.uploads.Accepts(backend) {
+		return ""
+	}
+	if resume, _ := config["resume"].(bool); resume || numberConfig(config, "chunks", 1) > 1 {
+		return ""
+	}
+	if target.policy == "hash" || ctx.Engine.uploads.stores.KeepLocal || assetEncryptionEnabled(ctx) {
+		return ""
+	}
+	return backend
+}
+
+// PERFORM A TRACKED DOWNLOAD FROM ONE SOURCE, TO DISK OR (WITH A backend) STRAIGHT TO A BUCKET
+func (t *DownloadAssetTask) download(ctx *TaskContext, client *http.Client, url string, header http.Header, transfer *Transfer, deadlines DeadlinePolicy, resume resumePolicy, redirects RedirectPolicy, filePath, variant, authName, backend string, chain *[]string) (data TaskData, err error) {
+	// NEW DOWNLOADS WAIT OUT HIGH MEMORY, THEN FOR A GLOBAL AND PER-HOST SLOT
+	if err := ctx.Engine.deferDownload(ctx.Context, ctx.Logger); err != nil {
+		return TaskData{}, fmt.Errorf("WAITING FOR MEMORY: %v", err)
+	}
+	release, err := ctx.Engine.downloads.Acquire(ctx.Context, url)
+	if err != nil {
+		return TaskData{}, fmt.Errorf("WAITING FOR DOWNLOAD SLOT: %v", err)
+	}
+	defer release()
+
+	// START DEADLINES ONCE A SLOT IS HELD SO QUEUE TIME DOESN'T COUNT AGAINST THE TRANSFER. THE
+	// ABSOLUTE CAP COVERS EVERY ATTEMPT; CONNECT AND STALL TIMEOUTS ARE PER REQUEST
+	overall := DeadlinePolicy{MaxDuration: deadlines.MaxDuration}.Start(ctx.Context)
+	defer overall.Stop()
+	perAttempt := DeadlinePolicy{ConnectTimeout: deadlines.ConnectTimeout, StallTimeout: deadlines.StallTimeout}
+	deadline := perAttempt.Start(overall.Context())
+	defer deadline.Stop()
+
+	if backend != "" {
+		return t.downloadToBucket(ctx, client, url, header, transfer, deadline, filePath, variant, authName, backend, chain)
+	}
 
-	// CREATE HTTP CLIENT WITH TIMEOUT
-	client := &http.Client{
-		Timeout: time.Duration(timeout) * time.Millisecond,
+	// PICK UP WHAT AN EARLIER ATTEMPT OR RUN LEFT OF THIS URL
+	partial, err := ctx.Engine.openPartial(ctx.JobID, url, resume.Enabled)
+	if err != nil {
+		return TaskData{}, err
+	}
+	defer func() { partial.close(err) }()
+	if written := partial.written(); written > 0 {
+		ctx.Logger.Printf("RESUMING DOWNLOAD OF %s FROM %d BYTES", url, written)
+		transfer.Resume(written, nil)
 	}
 
-	// CREATE REQUEST
+	fetch := &rangedFetch{
+		ctx:        ctx,
+		client:     client,
+		header:     header,
+		authName:   authName,
+		redirects:  redirects,
+		transfer:   transfer,
+		partial:    partial,
+		policy:     resume,
+		overall:    overall,
+		perAttempt: perAttempt,
+	}
+
+	// PERFORM REQUEST (WITH THE TASK'S AUTH PROFILE, IF ANY), ASKING ONLY FOR WHAT THE PART FILE LACKS
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return TaskData{}, fmt.Errorf("FAILED TO CREATE REQUEST: %v", err)
 	}
+	req.Header = header.Clone()
+	if offset := partial.offset(); offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator := partial.validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+	resp, err := ctx.Engine.doWithAuth(ctx, client, authName, req.WithContext(deadline.Context()))
+	if err != nil {
+		return TaskData{}, fmt.Errorf("REQUEST FAILED: %w", deadline.Err(err))
+	}
+	defer resp.Body.Close()
+	deadline.Connected()
 
-	// SET DEFAULT HEADERS
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+	// CHECK STATUS CODE (A 416 MEANS THE PART FILE IS ALREADY WHOLE, OR HAS TO START OVER)
+	if (resp.StatusCode < 200 || resp.StatusCode >= 300) && (resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || partial.offset() == 0) {
+		return TaskData{}, &downloadStatusError{StatusCode: resp.StatusCode, Chain: *chain}
+	}
 
-	// SET CUSTOM HEADERS IF PROVIDED
-	if headers, ok := config["headers"].(map[string]any); ok {
-		for key, value := range headers {
-			if strValue, ok := value.(string); ok {
-				req.Header.Set(key, strValue)
-			}
+	// STREAMING MANIFESTS ARE DOWNLOADED SEGMENT BY SEGMENT
+	if resp.StatusCode == http.StatusOK {
+		if kind := manifestType(resp.Request.URL.String(), resp.Header.Get("Content-Type")); kind != "" {
+			return t.downloadManifest(ctx, client, header, resp, kind, transfer, deadline, filePath, variant, "", *chain)
 		}
 	}
 
-	// PERFORM REQUEST
-	resp, err := client.Do(req)
+	// WRITE THE BODY TO THE PART FILE, RESUMING AFTER DROPPED CONNECTIONS, THEN MOVE IT INTO PLACE
+	size, err := fetch.run(resp, deadline)
+	if err != nil {
+		return TaskData{}, err
+	}
+	if err := partial.complete(filePath); err != nil {
+		return TaskData{}, err
+	}
+
+	ctx.Logger.Printf("DOWNLOADED %d BYTES TO %s", size, filePath)
+
+	// GET CONTENT TYPE (A RESUMED DOWNLOAD'S FIRST RESPONSE MAY HAVE BEEN IN AN EARLIER RUN)
+	contentType := cmp.Or(resp.Header.Get("Content-Type"), partial.row.ContentType)
+
+	// RETURN DOWNLOAD INFO
+	progress := transfer.Snapshot()
+	info := downloadInfo(url, resp, progress, *chain, filePath, size, contentType)
+	if progress.ResumedFrom > 0 {
+		info["resumedFrom"] = progress.ResumedFrom
+	}
+	if progress.Chunks > 0 {
+		info["chunks"] = progress.Chunks
+	}
+	return TaskData{Type: "object", Value: info}, nil
+}
+
+// STREAM ONE SOURCE INTO backend UNDER filePath'S KEY. NOTHING IS KEPT TO RESUME FROM, SO A
+// DROPPED CONNECTION FAILS THE SOURCE AND ABORTS THE UPLOAD
+func (t *DownloadAssetTask) downloadToBucket(ctx *TaskContext, client *http.Client, url string, header http.Header, transfer *Transfer, deadline *Deadline, filePath, variant, authName, backend string, chain *[]string) (TaskData, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO CREATE REQUEST: %v", err)
+	}
+	req.Header = header.Clone()
+	resp, err := ctx.Engine.doWithAuth(ctx, client, authName, req.WithContext(deadline.Context()))
 	if err != nil {
-		return TaskData{}, fmt.Errorf("REQUEST FAILED: %v", err)
+		return TaskData{}, fmt.Errorf("REQUEST FAILED: %w", deadline.Err(err))
 	}
 	defer resp.Body.Close()
+	deadline.Connected()
 
-	// CHECK STATUS CODE
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return TaskData{}, fmt.Errorf("BAD STATUS CODE: %d", resp.StatusCode)
+		return TaskData{}, &downloadStatusError{StatusCode: resp.StatusCode, Chain: *chain}
+	}
+	if kind := manifestType(resp.Request.URL.String(), resp.Header.Get("Content-Type")); kind != "" {
+		return t.downloadManifest(ctx, client, header, resp, kind, transfer, deadline, filePath, variant, backend, *chain)
+	}
+
+	key, err := ctx.Engine.uploads.key(filePath)
+	if err != nil {
+		return TaskData{}, err
+	}
+	// A BODY WITHOUT A LENGTH (OR DECOMPRESSED ON THE WAY) GOES UP IN PARTS
+	contentType := resp.Header.Get("Content-Type")
+	transfer.Begin(resp.ContentLength)
+	body := deadline.Reader(transfer.Reader(resp.Body))
+	if err := ctx.Engine.uploads.bucket(backend).Put(deadline.Context(), key, body, resp.ContentLength, contentType); err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO STREAM TO %s: %w", backend, deadline.Err(err))
+	}
+
+	progress := transfer.Snapshot()
+	ctx.Logger.Printf("STREAMED %d BYTES TO %s IN %s", progress.BytesDone, key, backend)
+	info := downloadInfo(url, resp, progress, *chain, filePath, progress.BytesDone, contentType)
+	info["storage"] = backend
+	return TaskData{Type: "object", Value: info}, nil
+}
+
+// WHAT A FINISHED DOWNLOAD REPORTS
+func downloadInfo(url string, resp *http.Response, progress DownloadProgress, chain []string, filePath string, size int64, contentType string) map[string]any {
+	return map[string]any{
+		"url":           url,
+		"sourceUrl":     url,
+		"downloadId":    progress.ID,
+		"finalUrl":      resp.Request.URL.String(),
+		"redirectChain": toAnySlice(chain),
+		"filePath":      filePath,
+		"size":          size,
+		"contentType":   contentType,
+		"type":          assetTypeOf(contentType),
+		"timestamp":     time.Now().Unix(),
+	}
+}
+
+// ASSET TYPE FOR A CONTENT TYPE
+func assetTypeOf(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "image/"):
+		return "image"
+	case strings.Contains(contentType, "video/"):
+		return "video"
+	case strings.Contains(contentType, "audio/"):
+		return "audio"
+	case strings.Contains(contentType, "text/"), strings.Contains(contentType, "application/"):
+		return "document"
+	}
+	return "unknown"
+}
+
+// DOWNLOAD THE SELECTED RENDITION OF AN HLS/DASH MANIFEST INTO A SINGLE FILE, OR A SINGLE OBJECT
+// IN backend
+func (t *DownloadAssetTask) downloadManifest(ctx *TaskContext, client *http.Client, header http.Header, resp *http.Response, kind string, transfer *Transfer, deadline *Deadline, filePath, preference, backend string, chain []string) (TaskData, error) {
+	body, err := io.ReadAll(io.LimitReader(deadline.Reader(resp.Body), maxManifestSize))
+	if err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO READ MANIFEST: %w", deadline.Err(err))
 	}
 
-	// CREATE FILE
-	file, err := os.Create(filePath)
+	var manifest *Manifest
+	if kind == "hls" {
+		manifest, err = ParseHLS(body, resp.Request.URL)
+	} else {
+		manifest, err = ParseDASH(body, resp.Request.URL)
+	}
 	if err != nil {
-		return TaskData{}, fmt.Errorf("FAILED TO CREATE FILE: %v", err)
+		return TaskData{}, err
+	}
+	if manifest.Live {
+		return TaskData{}, errors.New("LIVE MANIFESTS CANNOT BE DOWNLOADED AS A SINGLE ASSET")
+	}
+	if manifest.Type == "hls" && manifest.Master {
+		resolveHLSChildren(deadline.Context(), client, header, manifest)
 	}
-	defer file.Close()
 
-	// COPY RESPONSE BODY TO FILE
-	size, err := io.Copy(file, resp.Body)
+	selected, err := SelectVariant(manifest, preference)
 	if err != nil {
-		return TaskData{}, fmt.Errorf("FAILED TO DOWNLOAD FILE: %v", err)
+		return TaskData{}, err
 	}
+	ctx.Logger.Printf("DOWNLOADING %s VARIANT %s (%dp, %d BPS, %d SEGMENTS)", strings.ToUpper(kind), selected.ID, selected.Height, selected.Bandwidth, len(selected.Segments))
 
-	ctx.Logger.Printf("DOWNLOADED %d BYTES TO %s", size, filePath)
+	// SEGMENTS ARE CONCATENATED, SO THE MANIFEST EXTENSION NO LONGER APPLIES
+	contentType := selected.MimeType
+	if contentType == "" {
+		contentType = "video/mp2t"
+		if selected.Init != nil {
+			contentType = "video/mp4"
+		}
+	}
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".m3u8", ".mpd", ".bin":
+		ext := ".ts"
+		if strings.HasSuffix(contentType, "mp4") {
+			ext = ".mp4"
+		} else if strings.HasPrefix(contentType, "audio/") {
+			ext = ".m4a"
+		}
+		filePath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ext
+	}
 
-	// GET CONTENT TYPE
-	contentType := resp.Header.Get("Content-Type")
+	var out io.Writer
+	finish := func(err error) error { return err }
+	if backend == "" {
+		file, err := os.Create(filePath)
+		if err != nil {
+			return TaskData{}, fmt.Errorf("FAILED TO CREATE FILE: %v", err)
+		}
+		defer file.Close()
+		out = file
+	} else {
+		// THE SEGMENTS' TOTAL ISN'T KNOWN UP FRONT, SO THEY GO UP IN PARTS AS THEY ARRIVE
+		key, err := ctx.Engine.uploads.key(filePath)
+		if err != nil {
+			return TaskData{}, err
+		}
+		out, finish = streamTo(deadline.Context(), ctx.Engine.uploads.bucket(backend), key, contentType)
+	}
 
-	// DETECT ASSET TYPE FROM CONTENT TYPE
-	assetType := "unknown"
-	if strings.Contains(contentType, "image/") {
-		assetType = "image"
-	} else if strings.Contains(contentType, "video/") {
-		assetType = "video"
-	} else if strings.Contains(contentType, "audio/") {
-		assetType = "audio"
-	} else if strings.Contains(contentType, "text/") || strings.Contains(contentType, "application/") {
-		assetType = "document"
+	// EACH SEGMENT WRITTEN COUNTS AS PROGRESS FOR STALL DETECTION AND THE DOWNLOADS API
+	transfer.Begin(-1)
+	writer := &segmentWriter{w: out, deadline: deadline, transfer: transfer}
+	size, err := DownloadVariant(deadline.Context(), client, header, selected, writer)
+	if err = finish(err); err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO DOWNLOAD STREAM: %w", deadline.Err(err))
 	}
 
-	// RETURN DOWNLOAD INFO
-	return TaskData{
-		Type: "object",
-		Value: map[string]any{
-			"url":         url,
-			"filePath":    filePath,
-			"size":        size,
-			"contentType": contentType,
-			"type":        assetType,
-			"timestamp":   time.Now().Unix(),
+	ctx.Logger.Printf("DOWNLOADED %d BYTES TO %s", size, filePath)
+
+	result := map[string]any{
+		"url":           transfer.Snapshot().URL,
+		"sourceUrl":     resp.Request.URL.String(),
+		"downloadId":    transfer.Snapshot().ID,
+		"finalUrl":      resp.Request.URL.String(),
+		"redirectChain": toAnySlice(chain),
+		"filePath":      filePath,
+		"size":          size,
+		"contentType":   contentType,
+		"type":          selected.Kind,
+		"timestamp":     time.Now().Unix(),
+		"manifest":      kind,
+		"storage":       backend,
+		"variant": map[string]any{
+			"id":        selected.ID,
+			"width":     selected.Width,
+			"height":    selected.Height,
+			"bandwidth": selected.Bandwidth,
+			"codecs":    selected.Codecs,
+			"segments":  len(selected.Segments),
 		},
-	}, nil
+		"duration": manifest.Duration,
+	}
+
+	// SEPARATE AUDIO TRACKS ARE REPORTED SO A LATER TASK CAN FETCH AND MUX THEM
+	if selected.Kind == "video" {
+		if audio := CompanionAudio(manifest, selected); audio != nil {
+			result["audioUrl"] = audio.URL
+		}
+	}
+
+	return TaskData{Type: "object", Value: result}, nil
+}
+
+// WRITER THAT FEEDS THE DEADLINE AND TRANSFER AS SEGMENTS LAND
+type segmentWriter struct {
+	w        io.Writer
+	deadline *Deadline
+	transfer *Transfer
+}
+
+func (s *segmentWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if n > 0 {
+		s.deadline.touch()
+		s.transfer.add(n)
+	}
+	return n, err
+}
+
+// RESOLVE DEADLINE POLICY FROM TASK CONFIG, FALLING BACK TO JOB RULES AND GLOBAL DEFAULTS
+func resolveDeadlinePolicy(ctx *TaskContext, config map[string]any) DeadlinePolicy {
+	policy := DeadlinePolicy{
+		ConnectTimeout: defaultConnectTimeout,
+		StallTimeout:   defaultStallTimeout,
+		MaxDuration:    time.Duration(ctx.Engine.runSettings(ctx.JobID).Timeout) * time.Millisecond,
+	}
+
+	apply := func(values map[string]any, maxKey string) {
+		if ms, ok := values["connectTimeout"].(float64); ok && ms > 0 {
+			policy.ConnectTimeout = time.Duration(ms) * time.Millisecond
+		}
+		if ms, ok := values["stallTimeout"].(float64); ok && ms > 0 {
+			policy.StallTimeout = time.Duration(ms) * time.Millisecond
+		}
+		if ms, ok := values[maxKey].(float64); ok && ms > 0 {
+			policy.MaxDuration = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	if rule, ok := ctx.Engine.jobRule(ctx.JobID, "deadlines"); ok {
+		if ruleMap, ok := rule.(map[string]any); ok {
+			apply(ruleMap, "maxDuration")
+		}
+	}
+	apply(config, "timeout")
+
+	return policy
+}
+
+// RESOLVE REDIRECT POLICY FROM TASK CONFIG, FALLING BACK TO JOB RULES
+func resolveRedirectPolicy(ctx *TaskContext, config map[string]any) RedirectPolicy {
+	policy := RedirectPolicy{Mode: "follow"}
+
+	if rule, ok := ctx.Engine.jobRule(ctx.JobID, "redirectPolicy"); ok {
+		if ruleMap, ok := rule.(map[string]any); ok {
+			if mode, ok := ruleMap["mode"].(string); ok && ValidateRedirectMode(mode) == nil && mode != "" {
+				policy.Mode = mode
+			}
+			if maxRedirects, ok := ruleMap["maxRedirects"].(float64); ok && maxRedirects > 0 {
+				policy.MaxRedirects = int(maxRedirects)
+			}
+		}
+	}
+
+	if mode, ok := config["redirectMode"].(string); ok && mode != "" {
+		policy.Mode = mode
+	}
+	if maxRedirects, ok := config["maxRedirects"].(float64); ok && maxRedirects > 0 {
+		policy.MaxRedirects = int(maxRedirects)
+	}
+
+	return policy
+}
+
+// CONVERT A STRING SLICE TO AN ANY SLICE FOR TASK DATA
+func toAnySlice(values []string) []any {
+	result := make([]any, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
 }
 
 // SAVE ASSET TASK
@@ -2062,7 +3066,8 @@ func (t *SaveAssetTask) GetInputSchema() map[string]string {
 		"url":               "string",   // REQUIRED
 		"title":             "string?",  // OPTIONAL
 		"description":       "string?",  // OPTIONAL
-		"assetInfo":         "object?",  // OPTIONAL (properties from download task)
+		"assetInfo":         "object?",  // OPTIONAL (properties from download task, DEFAULTS TO A DOWNLOAD IN inputRefs)
+		"sources":           "array?",   // OPTIONAL (ALL KNOWN SOURCES FOR THIS CONTENT)
 		"generateThumbnail": "boolean?", // OPTIONAL
 	}
 }
@@ -2097,10 +3102,17 @@ func (t *SaveAssetTask) Execute(ctx *TaskContext, config map[string]any) (TaskDa
 		description = d
 	}
 
-	// GET ASSET INFO IF PROVIDED
+	// GET ASSET INFO IF PROVIDED, OTHERWISE FROM A DOWNLOAD IN inputRefs
 	var assetInfo map[string]any
 	if ai, ok := config["assetInfo"].(map[string]any); ok {
 		assetInfo = ai
+	} else {
+		for _, input := range ctx.Inputs {
+			if info, ok := input.Data.Value.(map[string]any); ok && info["filePath"] != nil {
+				assetInfo = info
+				break
+			}
+		}
 	}
 
 	// GET GENERATE THUMBNAIL FLAG
@@ -2129,8 +3141,13 @@ func (t *SaveAssetTask) Execute(ctx *TaskContext, config map[string]any) (TaskDa
 			asset.Type = assetType
 		}
 
-		if filePath, ok := assetInfo["filePath"].(string); ok {
-			asset.LocalPath = filePath
+		// FILES ARE RECORDED RELATIVE TO STORAGE AND MUST BE INSIDE IT
+		if filePath, ok := assetInfo["filePath"].(string); ok && filePath != "" {
+			localPath, err := utils.RelativeToRoot(ctx.Engine.cfg.StoragePath, filePath)
+			if err != nil {
+				return TaskData{}, err
+			}
+			asset.LocalPath = localPath
 		}
 
 		if size, ok := assetInfo["size"].(int64); ok {
@@ -2147,71 +3164,469 @@ func (t *SaveAssetTask) Execute(ctx *TaskContext, config map[string]any) (TaskDa
 		if timestamp, ok := assetInfo["timestamp"].(int64); ok {
 			metadata["timestamp"] = timestamp
 		}
+		if chain, ok := assetInfo["redirectChain"].([]any); ok && len(chain) > 0 {
+			metadata["redirectChain"] = chain
+		}
+		if finalURL, ok := assetInfo["finalUrl"].(string); ok && finalURL != "" {
+			metadata["finalUrl"] = finalURL
+		}
+		if sourceURL, ok := assetInfo["sourceUrl"].(string); ok && sourceURL != "" {
+			metadata["sourceUrl"] = sourceURL
+		}
+		if failed, ok := assetInfo["failedSources"].([]any); ok && len(failed) > 0 {
+			metadata["failedSources"] = failed
+		}
+		if sources, ok := assetInfo["sources"].([]any); ok {
+			asset.Sources = models.JSONArray(sources)
+		}
+		if conflict, ok := assetInfo["conflict"].(map[string]any); ok {
+			metadata["conflict"] = conflict
+		}
+
+		// STREAMED STRAIGHT TO A BUCKET, SO ALREADY WHERE IT'S KEPT
+		if backend, ok := assetInfo["storage"].(string); ok && backend != "" && asset.LocalPath != "" {
+			asset.Storage = backend
+			asset.StorageStatus = StorageDone
+		}
 
 		asset.Metadata = metadata
+
+		// A SKIPPED DOWNLOAD KEEPS THE ASSET THAT ALREADY HAS THE FILE, NOTING THE DECISION ON IT
+		if skipped, _ := assetInfo["skipped"].(bool); skipped && asset.LocalPath != "" {
+			if existing, ok := skipToExistingAsset(ctx, asset.LocalPath, metadata["conflict"]); ok {
+				return existing, nil
+			}
+		}
 	}
 
-	// GENERATE THUMBNAIL IF REQUESTED
-	if generateThumbnail && asset.LocalPath != "" {
-		ctx.Logger.Printf("GENERATING THUMBNAIL FOR ASSET")
+	// EXPLICIT SOURCES OVERRIDE WHAT THE DOWNLOAD REPORTED
+	if sources, ok := config["sources"].([]any); ok && len(sources) > 0 {
+		asset.Sources = models.JSONArray(sources)
+	}
+
+	// SAVE ASSET AND QUEUE ITS THUMBNAIL
+	thumbnailPending, err := registerAsset(ctx, &asset, generateThumbnail)
+	if err != nil {
+		return TaskData{}, err
+	}
 
-		// GENERATE THUMBNAIL FILENAME
-		thumbnailFilename := fmt.Sprintf("thumb_%s.jpg", asset.ID)
-		thumbnailPath := filepath.Join("thumbnails", thumbnailFilename)
+	// RETURN ASSET INFO
+	return TaskData{
+		Type: "object",
+		Value: map[string]any{
+			"id":               asset.ID,
+			"url":              asset.URL,
+			"type":             asset.Type,
+			"title":            asset.Title,
+			"description":      asset.Description,
+			"localPath":        asset.LocalPath,
+			"thumbnailPath":    asset.ThumbnailPath,
+			"thumbnailPending": thumbnailPending,
+			"size":             asset.Size,
+			"sources":          []any(asset.Sources),
+		},
+	}, nil
+}
 
-		// ENSURE THUMBNAILS DIRECTORY EXISTS
-		os.MkdirAll("thumbnails", 0755)
+// RECORD A SKIP ON THE ASSET ALREADY STORED AT A PATH AND RETURN IT AS SAVEASSET'S OUTPUT
+func skipToExistingAsset(ctx *TaskContext, localPath string, conflict any) (TaskData, bool) {
+	var existing models.Asset
+	if err := ctx.Engine.db.Where("local_path = ?", localPath).Order("created_at DESC").Limit(1).Find(&existing).Error; err != nil || existing.ID == "" {
+		return TaskData{}, false
+	}
+	if existing.Metadata == nil {
+		existing.Metadata = models.JSONMap{}
+	}
+	existing.Metadata["conflict"] = conflict
+	if err := ctx.Engine.db.Model(&existing).Update("metadata", existing.Metadata).Error; err != nil {
+		ctx.Logger.Printf("FAILED TO RECORD SKIP ON ASSET %s: %v", existing.ID, err)
+	}
+	ctx.Logger.Printf("KEPT EXISTING ASSET %s FOR %s", existing.ID, localPath)
+	return TaskData{
+		Type: "object",
+		Value: map[string]any{
+			"id":               existing.ID,
+			"url":              existing.URL,
+			"type":             existing.Type,
+			"title":            existing.Title,
+			"description":      existing.Description,
+			"localPath":        existing.LocalPath,
+			"thumbnailPath":    existing.ThumbnailPath,
+			"thumbnailPending": false,
+			"size":             existing.Size,
+			"sources":          []any(existing.Sources),
+			"skipped":          true,
+		},
+	}, true
+}
 
-		// GENERATE THUMBNAIL BASED ON ASSET TYPE
-		var err error
-		switch {
-		case strings.HasPrefix(asset.Type, "image"):
-			err = utils.GenerateImageThumbnail(asset.LocalPath, thumbnailPath)
-		case strings.HasPrefix(asset.Type, "video"):
-			err = utils.GenerateVideoThumbnail(asset.LocalPath, thumbnailPath)
-		case strings.HasPrefix(asset.Type, "audio"):
-			err = utils.GenerateAudioThumbnail(thumbnailPath) // GENERIC AUDIO ICON
-		case strings.HasPrefix(asset.Type, "document"):
-			err = utils.GenerateDocumentThumbnail(thumbnailPath) // GENERIC DOCUMENT ICON
-		default:
-			err = utils.GenerateGenericThumbnail(thumbnailPath) // GENERIC ICON
+// SAVE AN ASSET, QUEUE ITS THUMBNAIL ON THE JOB'S ASSET POOL, AND COUNT IT TOWARD JOB PROGRESS
+func registerAsset(ctx *TaskContext, asset *models.Asset, generateThumbnail bool) (bool, error) {
+	// FLAG ASSETS THAT WILL BE ENCRYPTED AT REST (ONES ALREADY IN A BUCKET NEVER TOUCHED THE DISK)
+	encrypt := asset.LocalPath != "" && !asset.IsRemote() && assetEncryptionEnabled(ctx)
+	if encrypt {
+		if asset.Metadata == nil {
+			asset.Metadata = models.JSONMap{}
 		}
+		asset.Metadata["encrypted"] = true
+	}
 
-		if err != nil {
-			ctx.Logger.Printf("FAILED TO GENERATE THUMBNAIL: %v", err)
-		} else {
-			asset.ThumbnailPath = thumbnailFilename
-			ctx.Logger.Printf("THUMBNAIL GENERATED: %s", thumbnailFilename)
+	thumbnailPending := generateThumbnail && asset.LocalPath != ""
+	if thumbnailPending {
+		asset.ThumbnailStatus = ThumbnailPending
+	}
+
+	// ASSETS GOING TO A BUCKET STAY ON DISK UNTIL THEY'RE SETTLED
+	upload := false
+	if asset.LocalPath != "" && asset.Storage == "" {
+		if backend := assetUploadBackend(ctx, asset); backend != "" {
+			asset.Storage = backend
+			asset.StorageStatus = StoragePending
+			upload = true
 		}
 	}
 
 	// SAVE ASSET TO DATABASE
-	if err := ctx.Engine.db.Create(&asset).Error; err != nil {
-		return TaskData{}, fmt.Errorf("FAILED TO SAVE ASSET TO DATABASE: %v", err)
+	if err := ctx.Engine.db.Create(asset).Error; err != nil {
+		return false, fmt.Errorf("FAILED TO SAVE ASSET TO DATABASE: %v", err)
 	}
 
 	ctx.Logger.Printf("ASSET SAVED WITH ID: %s", asset.ID)
 
+	// THE THUMBNAIL QUEUE ENCRYPTS AND UPLOADS THE ASSET ONCE ITS THUMBNAIL IS SETTLED (IT NEEDS THE
+	// PLAINTEXT ON DISK); WITHOUT A THUMBNAIL, BOTH GO ON THE JOB'S ASSET POOL
+	if thumbnailPending {
+		if !ctx.Engine.thumbnails.Enqueue(asset.ID) {
+			ctx.Logger.Printf("THUMBNAIL QUEUE FULL, ASSET %s WAITS FOR THE NEXT SWEEP", asset.ID)
+		}
+	} else if encrypt || upload {
+		queued := *asset
+		logger := ctx.Logger
+		ctx.Engine.submitAssetWork(ctx.JobID, func() {
+			if encrypt {
+				encryptAssetFiles(ctx.Engine.cfg, &queued, logger)
+			}
+			if upload {
+				ctx.Engine.uploads.Upload(queued.ID)
+			}
+		})
+	}
+
 	// UPDATE JOB PROGRESS ASSET COUNT
 	ctx.Engine.mu.Lock()
-	if progress, ok := ctx.Engine.jobProgress[jobId]; ok {
+	if progress, ok := ctx.Engine.jobProgress[asset.JobID]; ok {
 		progress.Assets++
-		ctx.Engine.jobProgress[jobId] = progress
+		ctx.Engine.jobProgress[asset.JobID] = progress
 	}
 	ctx.Engine.mu.Unlock()
 
-	// RETURN ASSET INFO
+	return thumbnailPending, nil
+}
+
+// WHETHER THE JOB OPTED INTO ENCRYPTION AT REST (REQUIRES A CONFIGURED SECRET)
+func assetEncryptionEnabled(ctx *TaskContext) bool {
+	value, ok := ctx.Engine.jobRule(ctx.JobID, "encryptAssets")
+	if enabled, _ := value.(bool); !ok || !enabled {
+		return false
+	}
+	if ctx.Engine.cfg.EncryptionSecret == "" {
+		ctx.Logger.Printf("WARNING: encryptAssets IS SET BUT NO ENCRYPTION SECRET IS CONFIGURED, STORING PLAINTEXT")
+		return false
+	}
+	return true
+}
+
+// THE BUCKET BACKEND A NEW ASSET GOES TO, OR "" TO KEEP IT ON DISK. SITE ARCHIVES STAY ON DISK
+// WHATEVER THE SETTING, SINCE THEIR PAGES LINK TO EACH OTHER BY RELATIVE PATH
+func assetUploadBackend(ctx *TaskContext, asset *models.Asset) string {
+	backend := ctx.Engine.runSettings(ctx.JobID).Storage
+	if backend == "" || backend == storage.BackendLocal {
+		return ""
+	}
+	if _, archived := asset.Metadata["archiveId"]; archived {
+		return ""
+	}
+	if !ctx.Engine.uploads.Accepts(backend) {
+		ctx.Logger.Printf("WARNING: STORAGE %s IS NOT CONFIGURED, KEEPING ASSET ON DISK", backend)
+		return ""
+	}
+	return backend
+}
+
+// ENCRYPT A SAVED ASSET AND ITS THUMBNAIL IN PLACE
+func encryptAssetFiles(cfg *config.Config, asset *models.Asset, logger *log.Logger) {
+	sourcePath, err := resolveAssetPath(cfg.StoragePath, asset.LocalPath)
+	if err != nil {
+		logger.Printf("NOT ENCRYPTING ASSET %s: %v", asset.ID, err)
+		return
+	}
+	paths := []string{sourcePath}
+	if asset.ThumbnailPath != "" {
+		thumbnailPath, err := utils.ConfinePath(cfg.ThumbnailsPath, asset.ThumbnailPath)
+		if err != nil {
+			logger.Printf("NOT ENCRYPTING ASSET %s: %v", asset.ID, err)
+			return
+		}
+		paths = append(paths, thumbnailPath)
+	}
+	for _, path := range paths {
+		if err := utils.EncryptFile(path, cfg.EncryptionSecret); err != nil {
+			logger.Printf("FAILED TO ENCRYPT %s: %v", path, err)
+			return
+		}
+	}
+	logger.Printf("ASSET %s ENCRYPTED AT REST", asset.ID)
+}
+
+// ASSET PATHS ARE RELATIVE TO STORAGE, BUT OLDER DOWNLOADS ARE RELATIVE TO THE WORKING
+// DIRECTORY; EITHER WAY THE FILE HAS TO BE INSIDE ONE OF THEM
+func resolveAssetPath(storagePath, localPath string) (string, error) {
+	if localPath == "" {
+		return "", fmt.Errorf("ASSET HAS NO LOCAL FILE")
+	}
+	// OLDER ROWS HOLD THE PATH FROM THE WORKING DIRECTORY (E.G. storage/photo.jpg); IT STILL
+	// HAS TO BE INSIDE STORAGE
+	if !filepath.IsAbs(localPath) {
+		if abs, err := filepath.Abs(localPath); err == nil {
+			if legacy, err := utils.ConfinePath(storagePath, abs); err == nil {
+				if _, err := os.Stat(legacy); err == nil {
+					return legacy, nil
+				}
+			}
+		}
+	}
+	return utils.ConfinePath(storagePath, localPath)
+}
+
+// WHERE A TASK WRITES name: INSIDE STORAGE, WHETHER IT'S GIVEN RELATIVE OR ABSOLUTE
+func (e *Engine) storageFile(name string) (string, error) {
+	return utils.ConfinePath(e.cfg.StoragePath, name)
+}
+
+// EXTENSION OF A URL'S LAST PATH SEGMENT, IF IT LOOKS LIKE ONE
+func urlExtension(rawURL string) string {
+	urlPath := strings.Split(rawURL, "?")[0] // REMOVE QUERY PARAMETERS
+	lastPart := urlPath[strings.LastIndex(urlPath, "/")+1:]
+	ext := filepath.Ext(lastPart)
+	if len(ext) < 2 || len(ext) > 10 {
+		return ""
+	}
+	for _, r := range ext[1:] {
+		if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// RENDER A SAVED ASSET'S THUMBNAIL, RETURNING ITS FILENAME UNDER THE THUMBNAILS PATH. A PLACEHOLDER IS
+// STILL A THUMBNAIL; fallback SAYS WHY ONE WAS NEEDED
+func renderAssetThumbnail(cfg *config.Config, asset *models.Asset, options thumbnailOptions) (string, *utils.ThumbnailFallbackError, error) {
+	// GENERATE THUMBNAIL FILENAME (VERSIONED, SO A RE-RENDER NEVER REUSES A CACHED URL)
+	thumbnailFilename := fmt.Sprintf("thumb_%s_v%d.jpg", asset.ID, asset.ThumbnailVersion+1)
+	thumbnailPath := filepath.Join(cfg.ThumbnailsPath, thumbnailFilename)
+
+	// ENSURE THUMBNAILS DIRECTORY EXISTS
+	os.MkdirAll(cfg.ThumbnailsPath, 0755)
+
+	// GENERATE THUMBNAIL BASED ON ASSET TYPE (AN ASSET ENCRYPTED AT REST GETS ITS THUMBNAIL ENCRYPTED TOO)
+	sourcePath, encrypted, done, err := openAssetSource(cfg, asset)
+	if err != nil {
+		return "", nil, err
+	}
+	defer done()
+	switch {
+	case strings.HasPrefix(asset.Type, "image"):
+		err = utils.GenerateImageThumbnail(sourcePath, thumbnailPath)
+	case strings.HasPrefix(asset.Type, "video"):
+		err = utils.GenerateVideoThumbnailWith(sourcePath, thumbnailPath, options.video) // A SCENE-DETECTED FRAME, OR THE JOB'S TIMESTAMP
+	case strings.HasPrefix(asset.Type, "audio"):
+		if info, probeErr := utils.ProbeMedia(sourcePath); probeErr == nil {
+			recordMediaInfo(asset, info)
+		}
+		err = utils.GenerateAudioThumbnailWith(sourcePath, thumbnailPath, options.audio) // WAVEFORM OR SPECTROGRAM, OR AN AUDIO ICON
+	case strings.HasPrefix(asset.Type, "document"):
+		err = utils.GenerateDocumentThumbnail(sourcePath, thumbnailPath) // FIRST PDF PAGE, OR A DOCUMENT ICON
+	default:
+		err = utils.GenerateGenericThumbnail(sourcePath, thumbnailPath) // FILE ICON LABELLED WITH THE EXTENSION
+	}
+
+	var fallback *utils.ThumbnailFallbackError
+	if errors.As(err, &fallback) {
+		log.Printf("USING A PLACEHOLDER THUMBNAIL FOR ASSET %s: %s", asset.ID, fallback.Reason)
+		err = nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if encrypted {
+		if err := utils.EncryptFile(thumbnailPath, cfg.EncryptionSecret); err != nil {
+			os.Remove(thumbnailPath)
+			return "", nil, fmt.Errorf("FAILED TO ENCRYPT THUMBNAIL: %v", err)
+		}
+	}
+	return thumbnailFilename, fallback, nil
+}
+
+// AN ASSET'S FILE AS TOOLS CAN READ IT: A DECRYPTED TEMPORARY COPY WHEN IT'S ENCRYPTED AT REST, AND
+// "" WHEN IT HAS NO FILE. CALL done ONCE FINISHED WITH IT
+func openAssetSource(cfg *config.Config, asset *models.Asset) (string, bool, func(), error) {
+	sourcePath, err := resolveAssetPath(cfg.StoragePath, asset.LocalPath)
+	if err != nil {
+		if asset.LocalPath != "" {
+			return "", false, nil, err
+		}
+		return "", false, func() {}, nil
+	}
+	if !utils.IsEncryptedFile(sourcePath) {
+		return sourcePath, false, func() {}, nil
+	}
+	if cfg.EncryptionSecret == "" {
+		return "", false, nil, errors.New("ASSET IS ENCRYPTED BUT NO ENCRYPTION SECRET IS SET")
+	}
+	plainPath := filepath.Join(os.TempDir(), "crepes_"+utils.GenerateID("plain")+filepath.Ext(sourcePath))
+	if err := utils.DecryptFileTo(sourcePath, plainPath, cfg.EncryptionSecret); err != nil {
+		return "", false, nil, fmt.Errorf("FAILED TO DECRYPT ASSET: %v", err)
+	}
+	return plainPath, true, func() { os.Remove(plainPath) }, nil
+}
+
+// RECORD (OR CLEAR) WHY AN ASSET'S THUMBNAIL IS A PLACEHOLDER, REPORTING WHETHER ITS METADATA CHANGED
+func SetThumbnailNote(asset *models.Asset, fallback *utils.ThumbnailFallbackError) bool {
+	if fallback == nil {
+		if _, ok := asset.Metadata["thumbnailNote"]; !ok {
+			return false
+		}
+		delete(asset.Metadata, "thumbnailNote")
+		return true
+	}
+	if asset.Metadata == nil {
+		asset.Metadata = models.JSONMap{}
+	}
+	asset.Metadata["thumbnailNote"] = fallback.Reason
+	return true
+}
+
+// CAPTURE LIVE STREAM TASK
+type CaptureLiveStreamTask struct{}
+
+func (t *CaptureLiveStreamTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"url":         "string",   // REQUIRED (HLS MASTER OR MEDIA PLAYLIST)
+		"duration":    "number?",  // OPTIONAL (SECONDS OF MEDIA TO RECORD, 0 = UNTIL STREAM ENDS)
+		"waitForLive": "number?",  // OPTIONAL (SECONDS TO WAIT FOR THE STREAM TO START)
+		"variant":     "string?",  // OPTIONAL (best, worst, OR A HEIGHT LIKE 720)
+		"folder":      "string?",  // OPTIONAL (UNDER STORAGE, defaults to 'downloads')
+		"filename":    "string?",  // OPTIONAL (auto-generated if not provided)
+		"headers":     "object?",  // OPTIONAL (custom headers)
+		"keepParts":   "boolean?", // OPTIONAL (KEEP ROLLING SEGMENT FILES)
+	}
+}
+
+func (t *CaptureLiveStreamTask) GetOutputSchema() string {
+	return "object" // RETURNS DOWNLOAD INFO (COMPATIBLE WITH saveAsset)
+}
+
+func (t *CaptureLiveStreamTask) ValidateConfig(config map[string]any) error {
+	if _, ok := config["url"]; !ok {
+		return ErrMissingRequiredInput
+	}
+	if variant, ok := config["variant"].(string); ok {
+		if err := ValidateVariantPreference(variant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *CaptureLiveStreamTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	url, _ := config["url"].(string)
+
+	// GET FOLDER (DEFAULT TO 'downloads')
+	folder := "downloads"
+	if f, ok := config["folder"].(string); ok && f != "" {
+		folder = f
+	}
+
+	// GET FILENAME (AUTO-GENERATE IF NOT PROVIDED)
+	filename := utils.GenerateID("live") + ".ts"
+	if f, ok := config["filename"].(string); ok && utils.SafeFilename(f) != "" {
+		filename = utils.SafeFilename(f)
+	}
+	filePath, err := ctx.Engine.storageFile(filepath.Join(folder, filename))
+	if err != nil {
+		return TaskData{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return TaskData{}, fmt.Errorf("FAILED TO CREATE DIRECTORY: %v", err)
+	}
+
+	opts := LiveCaptureOptions{
+		PartsDir:   filePath + ".parts",
+		OutputPath: filePath,
+		Logf:       ctx.Logger.Printf,
+	}
+	if d, ok := config["duration"].(float64); ok && d > 0 {
+		opts.Duration = time.Duration(d * float64(time.Second))
+	}
+	if w, ok := config["waitForLive"].(float64); ok && w > 0 {
+		opts.WaitForLive = time.Duration(w * float64(time.Second))
+	}
+	if v, ok := config["variant"].(string); ok {
+		opts.Variant = v
+	}
+	if keep, ok := config["keepParts"].(bool); ok {
+		opts.KeepParts = keep
+	}
+
+	header := http.Header{}
+	header.Set("User-Agent", DefaultUserAgent())
+	ctx.Engine.setJobHeaders(ctx.JobID, header)
+	if headers, ok := config["headers"].(map[string]any); ok {
+		for key, value := range headers {
+			if strValue, ok := value.(string); ok {
+				header.Set(key, strValue)
+			}
+		}
+	}
+
+	client := NewHTTPClient(HTTPClientOptions{
+		Timeout:     60 * time.Second, // PER REQUEST; THE CAPTURE ITSELF IS BOUNDED BY duration
+		Fingerprint: ctx.Engine.cfg.TLSFingerprint,
+		HTTPVersion: ctx.Engine.cfg.HTTPVersion,
+		TLS:         ctx.Engine.tlsPolicy(ctx.JobID),
+		Guard:       ctx.Engine.guard,
+		DNS:         ctx.Engine.dnsPolicy(ctx.JobID),
+		Egress:      ctx.Engine.egressPolicy(ctx.JobID),
+	})
+
+	// TRACK AS A DOWNLOAD SO PROGRESS SHOWS UP IN THE DOWNLOADS API
+	transfer := ctx.Engine.transfers.Start(ctx.JobID, url, filePath)
+	transfer.Begin(-1)
+	opts.OnSegment = transfer.add
+
+	ctx.Logger.Printf("CAPTURING LIVE STREAM %s TO %s", url, filePath)
+	result, err := CaptureLiveHLS(ctx.Context, client, header, url, opts)
+	transfer.Finish(err)
+	if err != nil {
+		return TaskData{}, fmt.Errorf("LIVE CAPTURE FAILED: %w", err)
+	}
+
 	return TaskData{
 		Type: "object",
 		Value: map[string]any{
-			"id":            asset.ID,
-			"url":           asset.URL,
-			"type":          asset.Type,
-			"title":         asset.Title,
-			"description":   asset.Description,
-			"localPath":     asset.LocalPath,
-			"thumbnailPath": asset.ThumbnailPath,
-			"size":          asset.Size,
+			"url":         url,
+			"downloadId":  transfer.Snapshot().ID,
+			"sourceUrl":   result.VariantURL,
+			"filePath":    result.OutputPath,
+			"size":        result.Size,
+			"contentType": result.ContentType,
+			"type":        "video",
+			"timestamp":   time.Now().Unix(),
+			"duration":    result.Duration,
+			"segments":    result.Segments,
+			"ended":       result.Ended,
+			"stopReason":  result.Reason,
 		},
 	}, nil
 }
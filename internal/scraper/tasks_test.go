@@ -0,0 +1,41 @@
+package scraper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveAssetPathStaysInStorage(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, name := range []string{"storage/photo.jpg", "data/crepes.db"} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage := filepath.Join(dir, "storage")
+
+	// A LEGACY ROW'S PATH FROM THE WORKING DIRECTORY, AND THE CURRENT FORM RELATIVE TO STORAGE
+	for _, localPath := range []string{"storage/photo.jpg", "photo.jpg"} {
+		got, err := resolveAssetPath(storage, localPath)
+		if err != nil {
+			t.Fatalf("resolveAssetPath(%q): %v", localPath, err)
+		}
+		if want := filepath.Join(storage, "photo.jpg"); got != want {
+			t.Fatalf("resolveAssetPath(%q) = %q; want %q", localPath, got, want)
+		}
+	}
+
+	// FILES ELSEWHERE UNDER THE WORKING DIRECTORY ARE NEVER REACHED
+	for _, localPath := range []string{"data/crepes.db", "../data/crepes.db", filepath.Join(dir, "data", "crepes.db")} {
+		if got, err := resolveAssetPath(storage, localPath); err == nil {
+			if _, statErr := os.Stat(got); statErr == nil {
+				t.Fatalf("resolveAssetPath(%q) = %q; want it refused", localPath, got)
+			}
+		}
+	}
+}
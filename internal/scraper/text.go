@@ -0,0 +1,482 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nickheyer/Crepes/internal/validation"
+	"golang.org/x/text/unicode/norm"
+)
+
+// -- TEXT TASKS --
+//
+// SMALL PURE TASKS FOR STRING MUNGING (SPLITTING, JOINING, REPLACING, FILLING TEMPLATES, SLUGS AND
+// URL PARTS) SO A PIPELINE DOESN'T NEED A PAGE AND evaluate FOR THEM. TASKS TAKING text ALSO
+// ACCEPT AN ARRAY OF STRINGS (E.G. extractText WITH multiple) AND RETURN ONE RESULT PER STRING.
+
+// APPLY fn TO text, OR TO EACH STRING OF AN ARRAY (NULLS PASS THROUGH)
+func mapText(config map[string]any, key string, fn func(string) (any, error)) (TaskData, error) {
+	switch v := config[key].(type) {
+	case string:
+		out, err := fn(v)
+		if err != nil {
+			return TaskData{}, err
+		}
+		return TaskData{Type: validation.ValueTypeName(out), Value: out}, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			if item == nil {
+				continue
+			}
+			s, ok := item.(string)
+			if !ok {
+				return TaskData{}, fmt.Errorf("%s[%d] IS %s, NOT TEXT", key, i, validation.ValueTypeName(item))
+			}
+			var err error
+			if out[i], err = fn(s); err != nil {
+				return TaskData{}, fmt.Errorf("%s[%d]: %v", key, i, err)
+			}
+		}
+		return TaskData{Type: "array", Value: out}, nil
+	}
+	return TaskData{}, fmt.Errorf("%s MUST BE TEXT OR AN ARRAY OF TEXT", strings.ToUpper(key))
+}
+
+// COMPILE A find/separator PATTERN, LITERAL UNLESS regex
+func textPattern(pattern string, regex, ignoreCase bool) (*regexp.Regexp, error) {
+	if !regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("INVALID PATTERN: %v", err)
+	}
+	return re, nil
+}
+
+// SPLIT TEXT TASK
+type SplitTextTask struct{}
+
+func (t *SplitTextTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"text":      "any",      // REQUIRED (STRING OR ARRAY OF STRINGS)
+		"separator": "string?",  // OPTIONAL (DEFAULTS TO ",")
+		"regex":     "boolean?", // OPTIONAL (separator IS A REGULAR EXPRESSION)
+		"trim":      "boolean?", // OPTIONAL (TRIM EACH PART; DEFAULTS TO TRUE)
+		"keepEmpty": "boolean?", // OPTIONAL (KEEP EMPTY PARTS)
+		"limit":     "number?",  // OPTIONAL (AT MOST THIS MANY PARTS, THE LAST HOLDING THE REST)
+	}
+}
+
+func (t *SplitTextTask) GetOutputSchema() string {
+	return "array" // RETURNS THE PARTS (AN ARRAY OF PARTS PER STRING FOR ARRAY INPUT)
+}
+
+func (t *SplitTextTask) ValidateConfig(config map[string]any) error {
+	if !boolConfig(config, "regex", false) {
+		return nil
+	}
+	separator, _ := config["separator"].(string)
+	_, err := textPattern(separator, true, false)
+	return err
+}
+
+func (t *SplitTextTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	separator := ","
+	if s, ok := config["separator"].(string); ok {
+		separator = s
+	}
+	re, err := textPattern(separator, boolConfig(config, "regex", false), false)
+	if err != nil {
+		return TaskData{}, err
+	}
+	limit := -1
+	if n, ok := intConfig(config, "limit"); ok && n > 0 {
+		limit = n
+	}
+	trim := boolConfig(config, "trim", true)
+	keepEmpty := boolConfig(config, "keepEmpty", false)
+
+	return mapText(config, "text", func(text string) (any, error) {
+		var pieces []string
+		if separator == "" {
+			pieces = strings.Split(text, "") // ONE PART PER CHARACTER
+			if limit > 0 && len(pieces) > limit {
+				pieces = append(pieces[:limit-1], strings.Join(pieces[limit-1:], ""))
+			}
+		} else {
+			pieces = re.Split(text, limit)
+		}
+		parts := make([]any, 0, len(pieces))
+		for _, piece := range pieces {
+			if trim {
+				piece = strings.TrimSpace(piece)
+			}
+			if piece == "" && !keepEmpty {
+				continue
+			}
+			parts = append(parts, piece)
+		}
+		return parts, nil
+	})
+}
+
+// JOIN TEXT TASK
+type JoinTextTask struct{}
+
+func (t *JoinTextTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"items":     "array",   // REQUIRED
+		"separator": "string?", // OPTIONAL (DEFAULTS TO ", ")
+		"by":        "string?", // OPTIONAL (FIELD TO JOIN FROM OBJECT ITEMS)
+	}
+}
+
+func (t *JoinTextTask) GetOutputSchema() string {
+	return "string" // RETURNS THE JOINED TEXT
+}
+
+func (t *JoinTextTask) ValidateConfig(config map[string]any) error {
+	return nil
+}
+
+func (t *JoinTextTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	items, err := itemsConfig(config)
+	if err != nil {
+		return TaskData{}, err
+	}
+	separator := ", "
+	if s, ok := config["separator"].(string); ok {
+		separator = s
+	}
+	by, _ := config["by"].(string)
+
+	// NULLS AND MISSING FIELDS ARE LEFT OUT RATHER THAN JOINED AS EMPTY PARTS
+	parts := make([]string, 0, len(items))
+	for _, item := range items {
+		if value := itemField(item, by); value != nil {
+			parts = append(parts, textOf(value))
+		}
+	}
+	return TaskData{Type: "string", Value: strings.Join(parts, separator)}, nil
+}
+
+// A VALUE AS TEXT: STRINGS AS THEY ARE, WHOLE NUMBERS WITHOUT A DECIMAL POINT, OBJECTS AND ARRAYS AS JSON
+func textOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]any, []any:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+	return fmt.Sprint(value)
+}
+
+// REPLACE TEXT TASK
+type ReplaceTextTask struct{}
+
+func (t *ReplaceTextTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"text":       "any",      // REQUIRED (STRING OR ARRAY OF STRINGS)
+		"find":       "string",   // REQUIRED
+		"replace":    "string?",  // OPTIONAL (DEFAULTS TO "", SO MATCHES ARE REMOVED; $1 ETC. WITH regex)
+		"regex":      "boolean?", // OPTIONAL (find IS A REGULAR EXPRESSION)
+		"ignoreCase": "boolean?", // OPTIONAL
+		"limit":      "number?",  // OPTIONAL (REPLACE ONLY THE FIRST N MATCHES)
+	}
+}
+
+func (t *ReplaceTextTask) GetOutputSchema() string {
+	return "string" // RETURNS THE NEW TEXT (AN ARRAY FOR ARRAY INPUT)
+}
+
+func (t *ReplaceTextTask) ValidateConfig(config map[string]any) error {
+	find, ok := config["find"].(string)
+	if !ok || find == "" {
+		return ErrMissingRequiredInput
+	}
+	_, err := textPattern(find, boolConfig(config, "regex", false), boolConfig(config, "ignoreCase", false))
+	return err
+}
+
+func (t *ReplaceTextTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	find, _ := config["find"].(string)
+	regex := boolConfig(config, "regex", false)
+	re, err := textPattern(find, regex, boolConfig(config, "ignoreCase", false))
+	if err != nil {
+		return TaskData{}, err
+	}
+	replacement, _ := config["replace"].(string)
+	limit := -1
+	if n, ok := intConfig(config, "limit"); ok && n > 0 {
+		limit = n
+	}
+
+	return mapText(config, "text", func(text string) (any, error) {
+		var out []byte
+		last := 0
+		for _, match := range re.FindAllStringSubmatchIndex(text, limit) {
+			out = append(out, text[last:match[0]]...)
+			if regex {
+				out = re.ExpandString(out, replacement, text, match) // $1, ${name}
+			} else {
+				out = append(out, replacement...)
+			}
+			last = match[1]
+		}
+		return string(append(out, text[last:]...)), nil
+	})
+}
+
+// {{ field }} PLACEHOLDERS, DOTTED FOR NESTED FIELDS
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([^{}\s]*)\s*\}\}`)
+
+// TEMPLATE TEXT TASK
+type TemplateTextTask struct{}
+
+func (t *TemplateTextTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"template": "string",   // REQUIRED (E.G. "https://example.com/items/{{ id }}?page={{ page }}")
+		"data":     "any?",     // OPTIONAL (OBJECT, OR ARRAY OF OBJECTS FOR ONE STRING EACH; DEFAULTS TO THE inputRefs OUTPUTS)
+		"strict":   "boolean?", // OPTIONAL (FAIL ON A MISSING FIELD INSTEAD OF LEAVING IT EMPTY)
+	}
+}
+
+func (t *TemplateTextTask) GetOutputSchema() string {
+	return "string" // RETURNS THE FILLED TEMPLATE (AN ARRAY FOR ARRAY DATA)
+}
+
+func (t *TemplateTextTask) ValidateConfig(config map[string]any) error {
+	if template, ok := config["template"].(string); !ok || template == "" {
+		return ErrMissingRequiredInput
+	}
+	return nil
+}
+
+func (t *TemplateTextTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	template, _ := config["template"].(string)
+	strict := boolConfig(config, "strict", false)
+	data, ok := config["data"]
+	if !ok {
+		data = templateInputs(ctx)
+	}
+
+	fill := func(data any) (string, error) {
+		var missing string
+		out := templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+			path := templatePlaceholder.FindStringSubmatch(placeholder)[1]
+			value := itemField(data, path)
+			if value == nil && missing == "" {
+				missing = path
+			}
+			return textOf(value)
+		})
+		if strict && missing != "" {
+			return "", fmt.Errorf("TEMPLATE FIELD %q IS MISSING", missing)
+		}
+		return out, nil
+	}
+
+	if items, ok := data.([]any); ok {
+		filled := make([]any, len(items))
+		for i, item := range items {
+			var err error
+			if filled[i], err = fill(item); err != nil {
+				return TaskData{}, fmt.Errorf("ITEM %d: %v", i, err)
+			}
+		}
+		ctx.Logger.Printf("FILLED TEMPLATE FOR %d ITEMS", len(filled))
+		return TaskData{Type: "array", Value: filled}, nil
+	}
+	filled, err := fill(data)
+	if err != nil {
+		return TaskData{}, err
+	}
+	return TaskData{Type: "string", Value: filled}, nil
+}
+
+// TEMPLATE DATA FROM inputRefs: A SINGLE INPUT AS IT IS, OTHERWISE AN OBJECT WITH EACH INPUT
+// UNDER ITS TASK ID AND THE FIELDS OF OBJECT INPUTS AT THE TOP
+func templateInputs(ctx *TaskContext) any {
+	if len(ctx.Inputs) == 1 {
+		return ctx.Inputs[0].Data.Value
+	}
+	data := make(map[string]any)
+	for _, input := range ctx.Inputs {
+		if object, ok := input.Data.Value.(map[string]any); ok {
+			for key, value := range object {
+				data[key] = value
+			}
+		}
+	}
+	for _, input := range ctx.Inputs {
+		data[input.Ref] = input.Data.Value
+	}
+	return data
+}
+
+// SLUGIFY TASK
+type SlugifyTask struct{}
+
+func (t *SlugifyTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"text":      "any",     // REQUIRED (STRING OR ARRAY OF STRINGS)
+		"separator": "string?", // OPTIONAL (DEFAULTS TO "-")
+		"maxLength": "number?", // OPTIONAL (CUT AT A WORD BOUNDARY TO FIT)
+	}
+}
+
+func (t *SlugifyTask) GetOutputSchema() string {
+	return "string" // RETURNS THE SLUG (AN ARRAY FOR ARRAY INPUT)
+}
+
+func (t *SlugifyTask) ValidateConfig(config map[string]any) error {
+	return nil
+}
+
+func (t *SlugifyTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	separator := "-"
+	if s, ok := config["separator"].(string); ok {
+		separator = s
+	}
+	maxLength := int(numberConfig(config, "maxLength", 0))
+	return mapText(config, "text", func(text string) (any, error) {
+		return slugify(text, separator, maxLength), nil
+	})
+}
+
+// LOWERCASE ASCII-FOLDED WORDS JOINED BY separator ("Crème Brûlée!" -> "creme-brulee").
+// LETTERS WITHOUT AN ASCII FORM (CJK, CYRILLIC...) ARE KEPT; maxLength 0 IS UNLIMITED
+func slugify(text, separator string, maxLength int) string {
+	var words []string
+	var word strings.Builder
+	for _, r := range norm.NFKD.String(text) {
+		switch {
+		case unicode.Is(unicode.Mn, r): // ACCENTS SPLIT OFF BY NFKD
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(unicode.ToLower(r))
+		case r == '\'' || r == '’': // "don't" -> "dont"
+		default:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	slug := strings.Join(words, separator)
+	if maxLength <= 0 || len(slug) <= maxLength {
+		return slug
+	}
+	for len(words) > 1 {
+		words = words[:len(words)-1]
+		if slug = strings.Join(words, separator); len(slug) <= maxLength {
+			return slug
+		}
+	}
+	// ONE WORD LONGER THAN THE LIMIT: CUT IT, ON A RUNE BOUNDARY
+	cut := 0
+	for i, r := range words[0] {
+		if i+utf8.RuneLen(r) > maxLength {
+			break
+		}
+		cut = i + utf8.RuneLen(r)
+	}
+	return words[0][:cut]
+}
+
+// THE slugify FIELD TRANSFORM
+func slugTransform(s string) string {
+	return slugify(s, "-", 0)
+}
+
+// PARSE URL TASK
+type ParseURLTask struct{}
+
+func (t *ParseURLTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"url":  "any",     // REQUIRED (STRING OR ARRAY OF STRINGS)
+		"base": "string?", // OPTIONAL (RESOLVE RELATIVE URLS AGAINST THIS)
+	}
+}
+
+func (t *ParseURLTask) GetOutputSchema() string {
+	return "object" // RETURNS THE URL'S PARTS (AN ARRAY OF THEM FOR ARRAY INPUT)
+}
+
+func (t *ParseURLTask) ValidateConfig(config map[string]any) error {
+	if base, ok := config["base"].(string); ok && base != "" {
+		if u, err := url.Parse(base); err != nil || !u.IsAbs() {
+			return fmt.Errorf("BASE MUST BE AN ABSOLUTE URL")
+		}
+	}
+	return nil
+}
+
+func (t *ParseURLTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	var base *url.URL
+	if raw, ok := config["base"].(string); ok && raw != "" {
+		var err error
+		if base, err = url.Parse(raw); err != nil {
+			return TaskData{}, fmt.Errorf("INVALID BASE URL: %v", err)
+		}
+	}
+	return mapText(config, "url", func(raw string) (any, error) {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("INVALID URL: %v", err)
+		}
+		if base != nil {
+			u = base.ResolveReference(u)
+		}
+		return urlParts(u), nil
+	})
+}
+
+// A URL'S PARTS; QUERY PARAMETERS ARE STRINGS, OR ARRAYS WHEN REPEATED
+func urlParts(u *url.URL) map[string]any {
+	query := make(map[string]any)
+	for key, values := range u.Query() {
+		if len(values) == 1 {
+			query[key] = values[0]
+			continue
+		}
+		list := make([]any, len(values))
+		for i, v := range values {
+			list[i] = v
+		}
+		query[key] = list
+	}
+	parts := map[string]any{
+		"href":     u.String(),
+		"scheme":   u.Scheme,
+		"host":     u.Host,
+		"hostname": u.Hostname(),
+		"port":     u.Port(),
+		"path":     u.Path,
+		"query":    query,
+		"fragment": u.Fragment,
+		"origin":   "",
+	}
+	if u.Scheme != "" && u.Host != "" {
+		parts["origin"] = u.Scheme + "://" + u.Host
+	}
+	return parts
+}
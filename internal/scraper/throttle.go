@@ -0,0 +1,360 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// -- ADAPTIVE THROTTLING --
+//
+// PACES A RUN'S REQUESTS PER HOST FROM HOW THE HOST IS HOLDING UP. EVERY RESPONSE UPDATES THE
+// HOST'S AVERAGE LATENCY AND ERROR RATE; WHEN LATENCY CLIMBS WELL ABOVE THE HOST'S BASELINE OR
+// ERRORS PILE UP THE DELAY BETWEEN REQUESTS WIDENS (429 AND 503 DOUBLE IT AT ONCE, AND A
+// Retry-After IS HONOURED), AND WHILE THE HOST IS HEALTHY IT TIGHTENS AGAIN DOWN TO minDelay.
+// DIRECT HTTP REQUESTS WAIT FOR THEIR HOST'S TURN IN THE CLIENT; BROWSER NAVIGATIONS WAIT IN THE
+// NAVIGATE TASK, AND EVERYTHING THE BROWSER LOADS FEEDS THE MEASUREMENTS.
+
+// JOB RULE TURNING ADAPTIVE THROTTLING ON: true FOR THE DEFAULTS, OR A ThrottleRule
+const throttleRule = "throttle"
+
+// THROTTLE DEFAULTS AND TUNING
+const (
+	defaultThrottleMaxDelay   = 30000 // MS
+	defaultThrottleSlowFactor = 2
+	defaultThrottleErrorRate  = 0.2
+	throttleSmoothing         = 0.3                    // WEIGHT OF THE NEWEST SAMPLE IN THE AVERAGES
+	throttleWarmup            = 5                      // RESPONSES BEFORE A HOST CAN BE CALLED SLOW
+	throttleStep              = 250 * time.Millisecond // SMALLEST WIDENED DELAY
+	throttleBackoffStep       = time.Second            // SMALLEST DELAY AFTER A 429 OR 503
+)
+
+// THROTTLE RULE, E.G. {"minDelay": 0, "maxDelay": 30000, "slowFactor": 2, "maxErrorRate": 0.2}
+type ThrottleRule struct {
+	MinDelay     float64 `json:"minDelay"`     // MS BETWEEN REQUESTS TO A HOST, EVEN WHEN HEALTHY
+	MaxDelay     float64 `json:"maxDelay"`     // MS; THE DELAY NEVER WIDENS PAST THIS
+	SlowFactor   float64 `json:"slowFactor"`   // LATENCY THIS MANY TIMES THE HOST'S BASELINE IS SLOW
+	MaxErrorRate float64 `json:"maxErrorRate"` // SHARE OF RECENT RESPONSES THAT MAY FAIL (0-1)
+}
+
+// PARSE A throttle RULE (NIL WHEN UNSET OR false)
+func ParseThrottleRule(raw any) (*ThrottleRule, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if !v {
+			return nil, nil
+		}
+		raw = map[string]any{}
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var rule ThrottleRule
+	if err := decoder.Decode(&rule); err != nil {
+		return nil, fmt.Errorf("THROTTLE RULE MUST BE true OR AN OBJECT: %v", err)
+	}
+	if rule.MinDelay < 0 || rule.MaxDelay < 0 || rule.SlowFactor < 0 {
+		return nil, fmt.Errorf("THROTTLE DELAYS AND slowFactor CAN'T BE NEGATIVE")
+	}
+	if rule.MaxErrorRate < 0 || rule.MaxErrorRate > 1 {
+		return nil, fmt.Errorf("maxErrorRate MUST BE BETWEEN 0 AND 1")
+	}
+	if rule.MaxDelay == 0 {
+		rule.MaxDelay = max(defaultThrottleMaxDelay, rule.MinDelay)
+	}
+	if rule.MaxDelay < rule.MinDelay {
+		return nil, fmt.Errorf("maxDelay CAN'T BE BELOW minDelay")
+	}
+	if rule.SlowFactor == 0 {
+		rule.SlowFactor = defaultThrottleSlowFactor
+	}
+	if rule.SlowFactor <= 1 {
+		return nil, fmt.Errorf("slowFactor MUST BE ABOVE 1")
+	}
+	if rule.MaxErrorRate == 0 {
+		rule.MaxErrorRate = defaultThrottleErrorRate
+	}
+	return &rule, nil
+}
+
+// HOW ONE HOST IS BEING PACED
+type HostPace struct {
+	Host      string  `json:"host"`
+	DelayMs   int64   `json:"delayMs"`
+	LatencyMs float64 `json:"latencyMs"`  // RECENT AVERAGE
+	Baseline  float64 `json:"baselineMs"` // WHAT THE HOST MANAGES WHEN HEALTHY
+	ErrorRate float64 `json:"errorRate"`  // RECENT AVERAGE
+	Responses int64   `json:"responses"`
+	Widened   int64   `json:"widened"` // TIMES THE DELAY WAS WIDENED
+}
+
+type hostPace struct {
+	HostPace
+	delay time.Duration
+	next  time.Time // WHEN THE NEXT REQUEST MAY START
+}
+
+// ONE RUN'S PACING
+type runThrottle struct {
+	rule  ThrottleRule
+	jobID string
+	mu    sync.Mutex
+	hosts map[string]*hostPace
+}
+
+type throttleKey struct{}
+
+// THE THROTTLE OF THE RUN A CONTEXT BELONGS TO, IF IT HAS ONE
+func throttleFrom(ctx context.Context) *runThrottle {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(throttleKey{}).(*runThrottle)
+	return t
+}
+
+// START PACING A RUN; THE RETURNED CONTEXT CARRIES THE THROTTLE
+func (e *Engine) startThrottle(ctx context.Context, jobID string, rule ThrottleRule) context.Context {
+	t := &runThrottle{rule: rule, jobID: jobID, hosts: make(map[string]*hostPace)}
+	e.mu.Lock()
+	e.throttles[jobID] = t
+	e.mu.Unlock()
+	return context.WithValue(ctx, throttleKey{}, t)
+}
+
+// STOP PACING A RUN AND RETURN HOW EACH HOST WAS PACED (NIL WITHOUT A THROTTLE). CALLER HOLDS e.mu
+func (e *Engine) endThrottle(jobID string) []HostPace {
+	t, ok := e.throttles[jobID]
+	if !ok {
+		return nil
+	}
+	delete(e.throttles, jobID)
+	return t.snapshot()
+}
+
+// THE RUNNING JOB'S THROTTLE (NIL WITHOUT ONE)
+func (e *Engine) jobThrottle(jobID string) *runThrottle {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.throttles[jobID]
+}
+
+func (t *runThrottle) host(name string) *hostPace {
+	h, ok := t.hosts[name]
+	if !ok {
+		h = &hostPace{HostPace: HostPace{Host: name}, delay: time.Duration(t.rule.MinDelay * float64(time.Millisecond))}
+		t.hosts[name] = h
+	}
+	return h
+}
+
+// WAIT FOR THE HOST'S TURN. CONCURRENT CALLERS ARE SPACED delay APART
+func (t *runThrottle) wait(ctx context.Context, host string) error {
+	t.mu.Lock()
+	h := t.host(host)
+	now := time.Now()
+	at := h.next
+	if at.Before(now) {
+		at = now
+	}
+	h.next = at.Add(h.delay)
+	t.mu.Unlock()
+
+	pause := time.Until(at)
+	if pause <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FEED BACK ONE RESPONSE (status 0 WHEN THE REQUEST FAILED OUTRIGHT) AND ADJUST THE HOST'S DELAY
+func (t *runThrottle) observe(host string, latency time.Duration, status int, retryAfter time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.host(host)
+	h.Responses++
+
+	failed := 0.0
+	if status == 0 || status == http.StatusTooManyRequests || status >= 500 {
+		failed = 1
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+	if h.Responses == 1 {
+		h.LatencyMs, h.Baseline, h.ErrorRate = ms, ms, failed
+	} else {
+		h.LatencyMs += throttleSmoothing * (ms - h.LatencyMs)
+		h.ErrorRate += throttleSmoothing * (failed - h.ErrorRate)
+	}
+
+	before := h.delay
+	overloaded := status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+	slow := h.Responses >= throttleWarmup && h.LatencyMs > h.Baseline*t.rule.SlowFactor
+	switch {
+	case overloaded:
+		h.delay = max(h.delay*2, throttleBackoffStep, retryAfter)
+	case h.ErrorRate > t.rule.MaxErrorRate || slow:
+		h.delay = max(h.delay*3/2, throttleStep)
+	default:
+		h.delay = h.delay * 9 / 10 // HEALTHY: TIGHTEN
+	}
+
+	// THE BASELINE DROPS TO THE FASTEST THE HOST HAS BEEN, AND CREEPS UP SO A HOST THAT HAS
+	// SETTLED AT A SLOWER SPEED ISN'T CALLED SLOW FOREVER
+	if h.LatencyMs < h.Baseline {
+		h.Baseline = h.LatencyMs
+	} else {
+		h.Baseline += (h.LatencyMs - h.Baseline) * 0.02
+	}
+	minDelay := time.Duration(t.rule.MinDelay * float64(time.Millisecond))
+	maxDelay := time.Duration(t.rule.MaxDelay * float64(time.Millisecond))
+	if h.delay < before && h.delay-minDelay < 10*time.Millisecond {
+		h.delay = minDelay // CLOSE ENOUGH; DON'T CREEP DOWN FOREVER
+	}
+	h.delay = min(max(h.delay, minDelay), maxDelay)
+	h.DelayMs = h.delay.Milliseconds()
+
+	if h.delay > before {
+		h.Widened++
+		// ONLY LOG THE FIRST STEP AND EVERY DOUBLING, NOT EVERY RESPONSE OF A SLOW SPELL
+		if before == minDelay || h.delay >= before*2 {
+			log.Printf("[JOB %s] SLOWING DOWN FOR %s: %v BETWEEN REQUESTS (LATENCY %.0fMS, BASELINE %.0fMS, ERRORS %.0f%%, STATUS %d)",
+				t.jobID, host, h.delay, h.LatencyMs, h.Baseline, h.ErrorRate*100, status)
+		}
+	} else if h.delay == minDelay && before > minDelay {
+		log.Printf("[JOB %s] %s IS HEALTHY AGAIN, BACK TO %v BETWEEN REQUESTS", t.jobID, host, h.delay)
+	}
+}
+
+// EVERY HOST'S PACING, SLOWEST FIRST
+func (t *runThrottle) snapshot() []HostPace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	paces := make([]HostPace, 0, len(t.hosts))
+	for _, h := range t.hosts {
+		pace := h.HostPace
+		pace.LatencyMs = math.Round(pace.LatencyMs)
+		pace.Baseline = math.Round(pace.Baseline)
+		pace.ErrorRate = math.Round(pace.ErrorRate*1000) / 1000
+		paces = append(paces, pace)
+	}
+	sort.Slice(paces, func(i, j int) bool {
+		if paces[i].DelayMs != paces[j].DelayMs {
+			return paces[i].DelayMs > paces[j].DelayMs
+		}
+		return paces[i].Host < paces[j].Host
+	})
+	return paces
+}
+
+// HOW LONG A Retry-After HEADER ASKS TO WAIT (SECONDS OR AN HTTP DATE; 0 WHEN ABSENT)
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// WAIT FOR A URL'S HOST IN THE RUNNING JOB'S THROTTLE (A NO-OP WITHOUT ONE)
+func (e *Engine) awaitThrottle(ctx context.Context, jobID, rawURL string) error {
+	t := e.jobThrottle(jobID)
+	if t == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	return t.wait(ctx, u.Hostname())
+}
+
+// FEED A BROWSER CONTEXT'S RESPONSE TIMES AND FAILURES TO THE JOB'S THROTTLE
+func (e *Engine) watchThrottle(jobID string, browserContext playwright.BrowserContext) {
+	t := e.jobThrottle(jobID)
+	if t == nil {
+		return
+	}
+	var mu sync.Mutex
+	started := make(map[playwright.Request]time.Time)
+	took := func(request playwright.Request) (string, time.Duration, bool) {
+		mu.Lock()
+		start, ok := started[request]
+		delete(started, request)
+		mu.Unlock()
+		u, err := url.Parse(request.URL())
+		if !ok || err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return "", 0, false
+		}
+		return u.Hostname(), time.Since(start), true
+	}
+	browserContext.OnRequest(func(request playwright.Request) {
+		mu.Lock()
+		started[request] = time.Now()
+		mu.Unlock()
+	})
+	browserContext.OnResponse(func(response playwright.Response) {
+		if host, latency, ok := took(response.Request()); ok {
+			t.observe(host, latency, response.Status(), retryAfter(response.Headers()["retry-after"]))
+		}
+	})
+	browserContext.OnRequestFailed(func(request playwright.Request) {
+		if host, latency, ok := took(request); ok {
+			t.observe(host, latency, 0, 0)
+		}
+	})
+}
+
+// TRANSPORT WRAPPER THAT PACES REQUESTS BY THE THROTTLE ON THE REQUEST'S CONTEXT AND REPORTS
+// HOW EACH ONE WENT
+type throttleTransport struct {
+	base http.RoundTripper
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	throttle := throttleFrom(req.Context())
+	if throttle == nil {
+		return t.base.RoundTrip(req)
+	}
+	host := req.URL.Hostname()
+	if err := throttle.wait(req.Context(), host); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		if req.Context().Err() == nil {
+			throttle.observe(host, time.Since(start), 0, 0)
+		}
+		return nil, err
+	}
+	throttle.observe(host, time.Since(start), resp.StatusCode, retryAfter(resp.Header.Get("Retry-After")))
+	return resp, nil
+}
@@ -0,0 +1,601 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// -- THUMBNAIL QUEUE --
+//
+// THUMBNAILS ARE RENDERED BY A FEW ENGINE-WIDE WORKERS, OFF THE JOB'S PATH, SO A SLOW ffmpeg OR A
+// BURST OF VIDEOS NEVER HOLDS UP DOWNLOADS OR THE END OF A RUN. AN ASSET'S thumbnailStatus SAYS
+// WHERE IT IS: pending UNTIL RENDERED, THEN done, OR failed ONCE maxThumbnailAttempts HAVE FAILED.
+// THE DATABASE IS THE REAL QUEUE: ASSETS THAT DIDN'T FIT IN MEMORY, OR WERE STILL pending WHEN THE
+// SERVER STOPPED, ARE PICKED UP BY THE SWEEP.
+
+// AN ASSET'S thumbnailStatus (EMPTY WHEN NO THUMBNAIL WAS WANTED, OR IT PREDATES THE QUEUE)
+const (
+	ThumbnailPending = "pending"
+	ThumbnailDone    = "done"
+	ThumbnailFailed  = "failed"
+)
+
+const (
+	defaultThumbnailWorkers   = 2
+	defaultThumbnailQueueSize = 1000
+	maxThumbnailAttempts      = 3
+	thumbnailRetryDelay       = 30 * time.Second // DOUBLED AFTER EACH FAILED ATTEMPT
+	thumbnailSweepInterval    = time.Minute
+)
+
+// THE QUEUE'S BACKLOG AND THROUGHPUT
+type ThumbnailQueueStats struct {
+	Backlog   int64 `json:"backlog"`   // ASSETS STILL pending: QUEUED, RENDERING, RETRYING OR WAITING FOR THE SWEEP
+	Queued    int   `json:"queued"`    // WAITING FOR A WORKER
+	Active    int64 `json:"active"`    // RENDERING NOW
+	Retrying  int   `json:"retrying"`  // WAITING OUT A RETRY DELAY
+	Failed    int64 `json:"failed"`    // ASSETS WHOSE THUMBNAIL GAVE UP
+	Completed int64 `json:"completed"` // RENDERED SINCE THE SERVER STARTED
+	Retries   int64 `json:"retries"`   // FAILED ATTEMPTS SINCE THE SERVER STARTED THAT WILL BE RETRIED
+	Overflow  int64 `json:"overflow"`  // ASSETS SINCE THE SERVER STARTED LEFT FOR THE SWEEP BECAUSE THE QUEUE WAS FULL
+	Workers   int   `json:"workers"`
+	Capacity  int   `json:"capacity"`
+}
+
+// RENDERS pending THUMBNAILS WITH RETRIES, NEVER BLOCKING WHOEVER QUEUES THEM
+type ThumbnailQueue struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	events  *EventBus
+	uploads *AssetUploader
+	queue   chan string // ASSET IDS
+	workers int
+
+	mu       sync.Mutex
+	tracked  map[string]bool        // QUEUED, RENDERING OR RETRYING; THE SWEEP LEAVES THESE ALONE
+	retrying map[string]*time.Timer // RETRIES WAITING OUT THEIR DELAY
+
+	active    atomic.Int64
+	completed atomic.Int64
+	retries   atomic.Int64
+	overflow  atomic.Int64
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// CREATE A QUEUE, START ITS WORKERS AND PICK UP ASSETS LEFT pending BY THE LAST RUN OF THE SERVER
+func NewThumbnailQueue(db *gorm.DB, cfg *config.Config, events *EventBus, uploads *AssetUploader) *ThumbnailQueue {
+	workers := cfg.ThumbnailWorkers
+	if workers <= 0 {
+		workers = defaultThumbnailWorkers
+	}
+	size := cfg.ThumbnailQueueSize
+	if size <= 0 {
+		size = defaultThumbnailQueueSize
+	}
+	q := &ThumbnailQueue{
+		db:       db,
+		cfg:      cfg,
+		events:   events,
+		uploads:  uploads,
+		queue:    make(chan string, size),
+		workers:  workers,
+		tracked:  make(map[string]bool),
+		retrying: make(map[string]*time.Timer),
+		done:     make(chan struct{}),
+	}
+	for range workers {
+		q.wg.Add(1)
+		go q.work()
+	}
+	go q.sweepLoop()
+	return q
+}
+
+// QUEUE AN ASSET WHOSE thumbnailStatus IS pending. WHEN THE QUEUE IS FULL THE ASSET STAYS pending
+// FOR THE SWEEP INSTEAD OF HOLDING UP THE CALLER
+func (q *ThumbnailQueue) Enqueue(assetID string) bool {
+	if q.offer(assetID) {
+		return true
+	}
+	q.overflow.Add(1)
+	return false
+}
+
+func (q *ThumbnailQueue) offer(assetID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.tracked[assetID] {
+		return true
+	}
+	select {
+	case <-q.done:
+		return false
+	default:
+	}
+	select {
+	case q.queue <- assetID:
+		q.tracked[assetID] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// STOP THE WORKERS AFTER THE THUMBNAILS BEING RENDERED; QUEUED ASSETS STAY pending FOR NEXT TIME
+func (q *ThumbnailQueue) Close() {
+	q.closeOnce.Do(func() {
+		q.mu.Lock()
+		close(q.done)
+		for _, timer := range q.retrying {
+			timer.Stop()
+		}
+		q.mu.Unlock()
+		q.wg.Wait()
+	})
+}
+
+func (q *ThumbnailQueue) Stats() ThumbnailQueueStats {
+	q.mu.Lock()
+	retrying := len(q.retrying)
+	q.mu.Unlock()
+	stats := ThumbnailQueueStats{
+		Queued:    len(q.queue),
+		Active:    q.active.Load(),
+		Retrying:  retrying,
+		Completed: q.completed.Load(),
+		Retries:   q.retries.Load(),
+		Overflow:  q.overflow.Load(),
+		Workers:   q.workers,
+		Capacity:  cap(q.queue),
+	}
+	q.db.Model(&models.Asset{}).Where("thumbnail_status = ?", ThumbnailPending).Count(&stats.Backlog)
+	q.db.Model(&models.Asset{}).Where("thumbnail_status = ?", ThumbnailFailed).Count(&stats.Failed)
+	return stats
+}
+
+func (q *ThumbnailQueue) work() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.done:
+			return
+		case assetID := <-q.queue:
+			q.active.Add(1)
+			q.render(assetID)
+			q.active.Add(-1)
+		}
+	}
+}
+
+// RENDER ONE ASSET'S THUMBNAIL, SCHEDULING A RETRY OR GIVING UP WHEN IT FAILS
+func (q *ThumbnailQueue) render(assetID string) {
+	var asset models.Asset
+	if err := q.db.First(&asset, "id = ?", assetID).Error; err != nil || asset.ThumbnailStatus != ThumbnailPending {
+		// DELETED, OR REGENERATED BY HAND, WHILE IT WAITED
+		q.untrack(assetID)
+		return
+	}
+
+	attempt := asset.ThumbnailAttempts + 1
+	release, err := q.uploads.fetch(&asset) // AN UPLOADED ASSET RE-RENDERED
+	var filename string
+	var fallback *utils.ThumbnailFallbackError
+	if err == nil {
+		filename, fallback, err = renderAssetThumbnail(q.cfg, &asset, thumbnailOptionsFor(q.db, &asset))
+	}
+	if err != nil {
+		release()
+		updates := map[string]any{"thumbnail_attempts": attempt, "thumbnail_error": err.Error()}
+		if attempt < maxThumbnailAttempts {
+			delay := thumbnailRetryDelay << (attempt - 1)
+			log.Printf("THUMBNAIL FOR ASSET %s FAILED (ATTEMPT %d OF %d), RETRYING IN %v: %v", assetID, attempt, maxThumbnailAttempts, delay, err)
+			q.db.Model(&models.Asset{}).Where("id = ?", assetID).Updates(updates)
+			q.retries.Add(1)
+			q.retryAfter(assetID, delay)
+			return
+		}
+		log.Printf("GIVING UP ON THUMBNAIL FOR ASSET %s AFTER %d ATTEMPTS: %v", assetID, attempt, err)
+		updates["thumbnail_status"] = ThumbnailFailed
+		q.db.Model(&models.Asset{}).Where("id = ?", assetID).Updates(updates)
+		q.untrack(assetID)
+		q.events.Publish("thumbnail.failed", asset.JobID, map[string]any{"assetId": assetID, "error": err.Error()})
+		q.settled(&asset)
+		return
+	}
+
+	previous := asset.ThumbnailPath
+	asset.ThumbnailPath = filename
+	asset.ThumbnailVersion++
+	updates := map[string]any{
+		"thumbnail_path":     filename,
+		"thumbnail_status":   ThumbnailDone,
+		"thumbnail_attempts": attempt,
+		"thumbnail_error":    "",
+		"thumbnail_version":  asset.ThumbnailVersion,
+	}
+	// AUDIO RENDERS ALSO RECORD THE DURATION AND BITRATE, DOCUMENTS THEIR TEXT
+	indexed := indexAssetText(q.db, q.cfg, &asset)
+	release()
+	if SetThumbnailNote(&asset, fallback) || indexed || strings.HasPrefix(asset.Type, "audio") {
+		updates["metadata"] = asset.Metadata
+	}
+	if err := q.db.Model(&models.Asset{}).Where("id = ?", assetID).Updates(updates).Error; err != nil {
+		log.Printf("FAILED TO RECORD THUMBNAIL FOR ASSET %s: %v", assetID, err)
+	} else {
+		removeThumbnail(q.cfg, previous, filename)
+		q.uploads.replaceThumbnail(&asset, previous)
+	}
+	q.untrack(assetID)
+	q.completed.Add(1)
+	q.events.Publish("thumbnail.generated", asset.JobID, map[string]any{"assetId": assetID, "thumbnailPath": filename})
+	q.settled(&asset)
+}
+
+// AN ASSET SAVED FOR ENCRYPTION AT REST IS KEPT PLAINTEXT, AND ONE GOING TO A BUCKET KEPT ON DISK,
+// UNTIL ITS THUMBNAIL IS DONE OR HAS FAILED
+func (q *ThumbnailQueue) settled(asset *models.Asset) {
+	if encrypted, _ := asset.Metadata["encrypted"].(bool); encrypted && q.cfg.EncryptionSecret != "" {
+		sourcePath, err := resolveAssetPath(q.cfg.StoragePath, asset.LocalPath)
+		if err == nil && !utils.IsEncryptedFile(sourcePath) {
+			encryptAssetFiles(q.cfg, asset, log.Default())
+		}
+	}
+	if asset.StorageStatus == StoragePending {
+		q.uploads.Upload(asset.ID)
+	}
+}
+
+func (q *ThumbnailQueue) retryAfter(assetID string, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.retrying[assetID] = time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		delete(q.retrying, assetID)
+		delete(q.tracked, assetID)
+		q.mu.Unlock()
+		q.Enqueue(assetID)
+	})
+}
+
+func (q *ThumbnailQueue) untrack(assetID string) {
+	q.mu.Lock()
+	delete(q.tracked, assetID)
+	q.mu.Unlock()
+}
+
+func (q *ThumbnailQueue) sweepLoop() {
+	ticker := time.NewTicker(thumbnailSweepInterval)
+	defer ticker.Stop()
+	for {
+		q.sweep()
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// QUEUE pending ASSETS NOTHING IS WORKING ON, OLDEST FIRST, AS FAR AS THE QUEUE HAS ROOM
+func (q *ThumbnailQueue) sweep() {
+	q.mu.Lock()
+	free := cap(q.queue) - len(q.queue)
+	tracked := len(q.tracked)
+	q.mu.Unlock()
+	if free <= 0 {
+		return
+	}
+	var ids []string
+	if err := q.db.Model(&models.Asset{}).Where("thumbnail_status = ?", ThumbnailPending).
+		Order("created_at").Limit(free+tracked).Pluck("id", &ids).Error; err != nil {
+		log.Printf("FAILED TO LOOK FOR PENDING THUMBNAILS: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if !q.offer(id) {
+			return
+		}
+	}
+}
+
+// SNAPSHOT OF THE ENGINE'S THUMBNAIL QUEUE
+func (e *Engine) ThumbnailStats() ThumbnailQueueStats {
+	return e.thumbnails.Stats()
+}
+
+// DELETE A THUMBNAIL A NEWER VERSION REPLACED
+func removeThumbnail(cfg *config.Config, previous, current string) {
+	if previous == "" || previous == current {
+		return
+	}
+	path, err := utils.ConfinePath(cfg.ThumbnailsPath, previous)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("FAILED TO DELETE OLD THUMBNAIL %s: %v", previous, err)
+	}
+}
+
+// -- REGENERATION --
+
+// A REGENERATION ASKED FOR AN ASSET THAT ISN'T THERE
+var ErrAssetNotFound = errors.New("ASSET NOT FOUND")
+
+// RE-RENDER ONE ASSET'S THUMBNAIL NOW, UNDER THE NEXT VERSION, AND RETURN THE UPDATED ASSET. A
+// PLACEHOLDER STILL COUNTS; ITS REASON IS RETURNED ALONG WITH IT
+func (e *Engine) RegenerateThumbnail(assetID string) (*models.Asset, *utils.ThumbnailFallbackError, error) {
+	var asset models.Asset
+	if found := e.db.Where("id = ?", assetID).Limit(1).Find(&asset); found.Error != nil {
+		return nil, nil, found.Error
+	} else if found.RowsAffected == 0 {
+		return nil, nil, ErrAssetNotFound
+	}
+	release, err := e.uploads.fetch(&asset)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	filename, fallback, err := renderAssetThumbnail(e.cfg, &asset, thumbnailOptionsFor(e.db, &asset))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previous := asset.ThumbnailPath
+	asset.ThumbnailPath = filename
+	asset.ThumbnailVersion++
+	asset.ThumbnailStatus = ThumbnailDone
+	asset.ThumbnailAttempts = 0
+	asset.ThumbnailError = ""
+	SetThumbnailNote(&asset, fallback)
+	indexAssetText(e.db, e.cfg, &asset)
+	if err := e.db.Save(&asset).Error; err != nil {
+		return nil, nil, err
+	}
+	removeThumbnail(e.cfg, previous, filename)
+	e.uploads.replaceThumbnail(&asset, previous)
+	e.events.Publish("thumbnail.generated", asset.JobID, map[string]any{"assetId": asset.ID, "thumbnailPath": filename})
+	return &asset, fallback, nil
+}
+
+// WHICH ASSETS A BULK REGENERATION COVERS (AT LEAST ONE FIELD SET; SET FIELDS ALL HAVE TO MATCH)
+type ThumbnailSelection struct {
+	AssetIDs []string                `json:"assetIds"`
+	JobID    string                  `json:"jobId"`
+	Type     string                  `json:"type"`   // image, video, audio, document...
+	Status   string                  `json:"status"` // ONLY ASSETS WHOSE thumbnailStatus IS THIS (E.G. failed)
+	Scope    func(*gorm.DB) *gorm.DB `json:"-"`      // LIMITS THE ASSETS TO THOSE THE CALLER MAY SEE (NIL FOR ALL)
+}
+
+// NOTHING SELECTED, WHICH WOULD OTHERWISE MEAN EVERY ASSET
+var ErrEmptyThumbnailSelection = errors.New("SELECT ASSETS BY assetIds, jobId, type OR status")
+
+// MARK THE SELECTED ASSETS' THUMBNAILS pending AGAIN AND HAND THEM TO THE QUEUE, RETURNING HOW MANY
+// WERE QUEUED. ASSETS ALREADY pending ARE LEFT WHERE THEY ARE; THOSE THE QUEUE HAS NO ROOM FOR
+// WAIT FOR THE SWEEP
+func (e *Engine) RequeueThumbnails(selection ThumbnailSelection) (int, error) {
+	query := e.db.Model(&models.Asset{}).Where("thumbnail_status != ?", ThumbnailPending)
+	if len(selection.AssetIDs) == 0 && selection.JobID == "" && selection.Type == "" && selection.Status == "" {
+		return 0, ErrEmptyThumbnailSelection
+	}
+	if len(selection.AssetIDs) > 0 {
+		query = query.Where("id IN ?", selection.AssetIDs)
+	}
+	if selection.JobID != "" {
+		query = query.Where("job_id = ?", selection.JobID)
+	}
+	if selection.Type != "" {
+		query = query.Where("type = ?", selection.Type)
+	}
+	if selection.Status != "" {
+		query = query.Where("thumbnail_status = ?", selection.Status)
+	}
+	if selection.Scope != nil {
+		query = query.Scopes(selection.Scope)
+	}
+
+	var ids []string
+	if err := query.Order("created_at").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	for batch := range slices.Chunk(ids, 500) {
+		err := e.db.Model(&models.Asset{}).Where("id IN ?", batch).Updates(map[string]any{
+			"thumbnail_status":   ThumbnailPending,
+			"thumbnail_attempts": 0,
+			"thumbnail_error":    "",
+		}).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, id := range ids {
+		e.thumbnails.Enqueue(id)
+	}
+	return len(ids), nil
+}
+
+// -- VIDEO FRAMES AND AUDIO PREVIEWS --
+
+// JOB RULE PINNING WHERE ITS VIDEO THUMBNAILS ARE TAKEN: SECONDS (90) OR A CLOCK TIME ("1:30",
+// "00:01:30.5"). WITHOUT IT, OR WHEN A VIDEO IS SHORTER, SCENE DETECTION PICKS THE FRAME
+const thumbnailTimestampRule = "thumbnailTimestamp"
+
+// READ A thumbnailTimestamp RULE AS SECONDS (0 WHEN UNSET)
+func ParseThumbnailTimestamp(value any) (float64, error) {
+	var seconds float64
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		seconds = v
+	case int:
+		seconds = float64(v)
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return 0, nil
+		}
+		parts := strings.Split(v, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("INVALID THUMBNAIL TIMESTAMP %q", v)
+		}
+		for _, part := range parts {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("INVALID THUMBNAIL TIMESTAMP %q", v)
+			}
+			seconds = seconds*60 + n
+		}
+	default:
+		return 0, fmt.Errorf("THUMBNAIL TIMESTAMP MUST BE SECONDS OR A CLOCK TIME, GOT %T", value)
+	}
+	if seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, fmt.Errorf("THUMBNAIL TIMESTAMP MUST BE 0 OR MORE SECONDS")
+	}
+	return seconds, nil
+}
+
+// JOB RULE CHOOSING HOW ITS AUDIO IS DRAWN: waveform (THE DEFAULT) OR spectrogram
+const audioThumbnailRule = "audioThumbnail"
+
+// FAIL FOR AN UNKNOWN audioThumbnail STYLE
+func ValidateAudioThumbnailStyle(style string) error {
+	if style != utils.AudioPreviewWaveform && style != utils.AudioPreviewSpectrogram {
+		return fmt.Errorf("UNKNOWN AUDIO THUMBNAIL STYLE %q (EXPECTED %s OR %s)", style, utils.AudioPreviewWaveform, utils.AudioPreviewSpectrogram)
+	}
+	return nil
+}
+
+// HOW ONE ASSET'S THUMBNAIL IS DRAWN
+type thumbnailOptions struct {
+	video utils.VideoFrameOptions
+	audio utils.AudioPreviewOptions
+}
+
+// THE THUMBNAIL OPTIONS FROM AN ASSET'S JOB RULES (READ FRESH, SO A CHANGED RULE APPLIES TO THE
+// NEXT REGENERATION)
+func thumbnailOptionsFor(db *gorm.DB, asset *models.Asset) thumbnailOptions {
+	var options thumbnailOptions
+	video, audio := strings.HasPrefix(asset.Type, "video"), strings.HasPrefix(asset.Type, "audio")
+	if (!video && !audio) || asset.JobID == "" {
+		return options
+	}
+	var job models.Job
+	if found := db.Select("id", "rules").Where("id = ?", asset.JobID).Limit(1).Find(&job); found.Error != nil || found.RowsAffected == 0 {
+		return options
+	}
+	if video {
+		seconds, err := ParseThumbnailTimestamp(job.Rules[thumbnailTimestampRule])
+		if err != nil {
+			log.Printf("IGNORING THUMBNAIL TIMESTAMP FOR JOB %s: %v", asset.JobID, err)
+		}
+		options.video.Timestamp = seconds
+	}
+	if style, _ := job.Rules[audioThumbnailRule].(string); audio && style != "" {
+		if err := ValidateAudioThumbnailStyle(style); err != nil {
+			log.Printf("IGNORING AUDIO THUMBNAIL STYLE FOR JOB %s: %v", asset.JobID, err)
+		} else {
+			options.audio.Style = style
+		}
+	}
+	return options
+}
+
+// RECORD WHAT ffprobe SAYS ABOUT AN AUDIO ASSET (DURATION, BITRATE, FORMAT) IN ITS METADATA
+func recordMediaInfo(asset *models.Asset, info utils.MediaInfo) {
+	if asset.Metadata == nil {
+		asset.Metadata = models.JSONMap{}
+	}
+	if info.Duration > 0 {
+		asset.Metadata["duration"] = math.Round(info.Duration*100) / 100
+	}
+	if info.Bitrate > 0 {
+		asset.Metadata["bitrate"] = info.Bitrate
+	}
+	if info.Codec != "" {
+		asset.Metadata["codec"] = info.Codec
+	}
+	if info.SampleRate > 0 {
+		asset.Metadata["sampleRate"] = info.SampleRate
+	}
+	if info.Channels > 0 {
+		asset.Metadata["channels"] = info.Channels
+	}
+}
+
+// -- DOCUMENT TEXT --
+
+// HOW MUCH OF A DOCUMENT'S TEXT ITS METADATA SHOWS
+const textExcerptLength = 280
+
+// EXTRACT A DOCUMENT ASSET'S TEXT INTO ITS AssetText ROW FOR SEARCH, NOTING AN EXCERPT AND WORD
+// COUNT IN ITS METADATA (OR WHY THERE'S NO TEXT). REPORTS WHETHER THE METADATA CHANGED
+func indexAssetText(db *gorm.DB, cfg *config.Config, asset *models.Asset) bool {
+	if !strings.HasPrefix(asset.Type, "document") || asset.LocalPath == "" {
+		return false
+	}
+	sourcePath, _, done, err := openAssetSource(cfg, asset)
+	if err != nil {
+		return setTextNote(asset, err.Error())
+	}
+	text, truncated, err := utils.ExtractDocumentText(sourcePath)
+	done()
+	if errors.Is(err, utils.ErrNoDocumentText) {
+		return false
+	}
+	if err != nil {
+		log.Printf("NO TEXT FOR ASSET %s: %v", asset.ID, err)
+		return setTextNote(asset, err.Error())
+	}
+
+	row := models.AssetText{AssetID: asset.ID, JobID: asset.JobID, Text: text, Truncated: truncated}
+	if err := db.Save(&row).Error; err != nil {
+		log.Printf("FAILED TO SAVE TEXT FOR ASSET %s: %v", asset.ID, err)
+		return false
+	}
+	if asset.Metadata == nil {
+		asset.Metadata = models.JSONMap{}
+	}
+	excerpt := text
+	if runes := []rune(excerpt); len(runes) > textExcerptLength {
+		excerpt = string(runes[:textExcerptLength]) + "…"
+	}
+	asset.Metadata["textExcerpt"] = excerpt
+	asset.Metadata["wordCount"] = len(strings.Fields(text))
+	if truncated {
+		asset.Metadata["textTruncated"] = true
+	} else {
+		delete(asset.Metadata, "textTruncated")
+	}
+	delete(asset.Metadata, "textNote")
+	return true
+}
+
+// RECORD WHY A DOCUMENT HAS NO SEARCHABLE TEXT
+func setTextNote(asset *models.Asset, reason string) bool {
+	if asset.Metadata == nil {
+		asset.Metadata = models.JSONMap{}
+	}
+	if asset.Metadata["textNote"] == reason {
+		return false
+	}
+	asset.Metadata["textNote"] = reason
+	return true
+}
@@ -0,0 +1,359 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+)
+
+// -- RUN TIMELINE --
+//
+// A RUN LEAVES A SPAN FOR ITSELF, EACH STAGE, EACH TASK EXECUTION (RETRIES AND WORKER-PER-ITEM
+// COPIES INCLUDED), EACH RETRY'S BACKOFF AND EACH DOWNLOAD, WITH WHEN IT STARTED AND ENDED AND
+// HOW IT WENT. SPANS ARE WRITTEN IN BATCHES AS THEY END; BuildTimeline GROUPS A RUN'S SPANS INTO
+// LANES (THE RUN, EACH STAGE, EACH OF A STAGE'S WORKERS, EACH DOWNLOAD HOST) FOR A GANTT CHART.
+
+// SPAN KINDS
+const (
+	SpanRun      = "run"
+	SpanStage    = "stage"
+	SpanTask     = "task"
+	SpanRetry    = "retry"
+	SpanDownload = "download"
+)
+
+// SPAN STATUSES
+const (
+	SpanSucceeded = "succeeded"
+	SpanFailed    = "failed"
+	SpanSkipped   = "skipped"
+	SpanCancelled = "cancelled"
+)
+
+// SPANS HELD BEFORE THEY ARE WRITTEN
+const timelineBatch = 200
+
+// SPANS KEPT PER RUN; A RUN OVER THIS (A HUGE CRAWL) ONLY NOTES HOW MANY MORE IT DROPPED
+const maxTimelineSpans = 50000
+
+// ONE RUN'S SPANS, WAITING TO BE WRITTEN
+type runTimeline struct {
+	engine  *Engine
+	jobID   string
+	runID   string
+	mu      sync.Mutex
+	pending []models.RunEvent
+	kept    int
+	dropped int
+}
+
+// WHERE A TASK RUNS, CARRIED ON ITS CONTEXT: ITS STAGE, ITS LANE AND WHICH RETRY IT IS
+type timelineScope struct {
+	stage   string
+	lane    string
+	attempt int
+}
+
+type timelineScopeKey struct{}
+
+// THE SCOPE ctx CARRIES; TASKS RUN OUTSIDE A STAGE (A RE-LOGIN) GO ON A "tasks" LANE
+func timelineScopeFrom(ctx context.Context) timelineScope {
+	scope, _ := ctx.Value(timelineScopeKey{}).(timelineScope)
+	if scope.lane == "" {
+		scope.lane = "tasks"
+	}
+	return scope
+}
+
+// TASKS UNDER ctx BELONG TO stage, ON THE LANE UNDER THE STAGE'S OWN
+func withTimelineStage(ctx context.Context, stage string) context.Context {
+	return context.WithValue(ctx, timelineScopeKey{}, timelineScope{stage: stage, lane: stage + " / tasks"})
+}
+
+// TASKS UNDER ctx RUN ON ONE OF THEIR STAGE'S WORKERS
+func withTimelineWorker(ctx context.Context, worker int) context.Context {
+	scope := timelineScopeFrom(ctx)
+	scope.lane = fmt.Sprintf("%s / worker %d", scope.stage, worker)
+	return context.WithValue(ctx, timelineScopeKey{}, scope)
+}
+
+// TASKS UNDER ctx ARE RETRY attempt
+func withTimelineAttempt(ctx context.Context, attempt int) context.Context {
+	scope := timelineScopeFrom(ctx)
+	scope.attempt = attempt
+	return context.WithValue(ctx, timelineScopeKey{}, scope)
+}
+
+// STATUS OF A SPAN THAT ENDED WITH err
+func spanStatus(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return SpanSucceeded
+	case ctx.Err() != nil:
+		return SpanCancelled
+	default:
+		return SpanFailed
+	}
+}
+
+// START COLLECTING A RUN'S SPANS (CALLER HOLDS e.mu)
+func (e *Engine) startTimeline(jobID, runID string) {
+	e.timelines[jobID] = &runTimeline{engine: e, jobID: jobID, runID: runID}
+}
+
+// THE TIMELINE OF A JOB'S RUNNING RUN, NIL WHEN IT ISN'T RUNNING
+func (e *Engine) timeline(jobID string) *runTimeline {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.timelines[jobID]
+}
+
+// ADD A SPAN THAT ENDED JUST NOW (OR AT span.EndedAt)
+func (t *runTimeline) record(span models.RunEvent) {
+	if t == nil {
+		return
+	}
+	if span.EndedAt.IsZero() {
+		span.EndedAt = time.Now()
+	}
+	span.ID = generateID("span")
+	span.JobID = t.jobID
+	span.RunID = t.runID
+	span.DurationMs = span.EndedAt.Sub(span.StartedAt).Milliseconds()
+	span.CreatedAt = time.Now()
+
+	t.mu.Lock()
+	if t.kept >= maxTimelineSpans && span.Kind != SpanRun {
+		t.dropped++
+		t.mu.Unlock()
+		return
+	}
+	t.kept++
+	t.pending = append(t.pending, span)
+	full := len(t.pending) >= timelineBatch
+	t.mu.Unlock()
+	if full {
+		t.flush()
+	}
+}
+
+// WRITE THE WAITING SPANS
+func (t *runTimeline) flush() {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	if err := t.engine.db.CreateInBatches(batch, timelineBatch).Error; err != nil {
+		log.Printf("[JOB %s] FAILED TO SAVE %d TIMELINE SPANS: %v", t.jobID, len(batch), err)
+	}
+}
+
+// SPANS NOT WRITTEN YET
+func (t *runTimeline) unwritten() []models.RunEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]models.RunEvent(nil), t.pending...)
+}
+
+// CLOSE A RUN'S TIMELINE WITH ITS OWN SPAN AND WRITE WHAT'S LEFT
+func (e *Engine) endTimeline(jobID string) {
+	e.mu.Lock()
+	t := e.timelines[jobID]
+	delete(e.timelines, jobID)
+	started := e.jobStartTimes[jobID]
+	jobStatus := e.jobProgress[jobID].Status
+	e.mu.Unlock()
+	if t == nil {
+		return
+	}
+
+	status := SpanCancelled
+	switch jobStatus {
+	case "completed":
+		status = SpanSucceeded
+	case "error", "failed":
+		status = SpanFailed
+	}
+	detail := models.JSONMap{"jobStatus": jobStatus}
+	t.mu.Lock()
+	if t.dropped > 0 {
+		detail["droppedSpans"] = t.dropped
+		log.Printf("[JOB %s] TIMELINE KEPT %d SPANS, DROPPED %d", jobID, t.kept, t.dropped)
+	}
+	t.mu.Unlock()
+	t.record(models.RunEvent{Kind: SpanRun, Name: "run", Lane: SpanRun, Status: status, StartedAt: started, Detail: detail})
+	t.flush()
+}
+
+// A FINISHED DOWNLOAD, ON ITS HOST'S LANE OF ITS RUN'S TIMELINE
+func (e *Engine) recordDownload(progress DownloadProgress, began time.Time) {
+	t := e.timeline(progress.JobID)
+	if t == nil {
+		return
+	}
+	status := SpanSucceeded
+	if progress.Status == "failed" {
+		status = SpanFailed
+	}
+	detail := models.JSONMap{"url": progress.URL, "bytes": progress.BytesDone, "retries": progress.Retries}
+	if !began.IsZero() {
+		detail["queuedMs"] = began.Sub(progress.StartedAt).Milliseconds()
+	}
+	if progress.Chunks > 0 {
+		detail["chunks"] = progress.Chunks
+	}
+	t.record(models.RunEvent{
+		Kind:      SpanDownload,
+		Name:      path.Base(progress.FilePath),
+		Lane:      "downloads / " + downloadHost(progress.URL),
+		Status:    status,
+		Error:     progress.Error,
+		StartedAt: progress.StartedAt,
+		EndedAt:   progress.UpdatedAt,
+		Detail:    detail,
+	})
+}
+
+// THE RUN ID AND UNWRITTEN SPANS OF A JOB'S RUNNING RUN ("" WHEN IT ISN'T RUNNING)
+func (e *Engine) LiveTimeline(jobID string) (string, []models.RunEvent) {
+	t := e.timeline(jobID)
+	if t == nil {
+		return "", nil
+	}
+	return t.runID, t.unwritten()
+}
+
+// A RUN'S SPANS LAID OUT FOR A GANTT CHART. TIMES IN SPANS ARE MS FROM THE RUN'S START
+type Timeline struct {
+	RunID      string                   `json:"runId"`
+	StartedAt  time.Time                `json:"startedAt"`
+	EndedAt    time.Time                `json:"endedAt"`
+	DurationMs int64                    `json:"durationMs"`
+	Status     string                   `json:"status,omitempty"` // EMPTY WHILE THE RUN IS GOING
+	Running    bool                     `json:"running"`
+	Dropped    int                      `json:"droppedSpans,omitempty"`
+	Lanes      []TimelineLane           `json:"lanes"`
+	Totals     map[string]TimelineTotal `json:"totals"` // BY SPAN KIND
+}
+
+// ONE ROW OF THE CHART
+type TimelineLane struct {
+	Name  string         `json:"name"`
+	Kind  string         `json:"kind"` // run, stage, task OR download
+	Spans []TimelineSpan `json:"spans"`
+}
+
+type TimelineSpan struct {
+	ID         string         `json:"id"`
+	Kind       string         `json:"kind"`
+	Name       string         `json:"name"`
+	Stage      string         `json:"stage,omitempty"`
+	TaskID     string         `json:"taskId,omitempty"`
+	Attempt    int            `json:"attempt,omitempty"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	Start      int64          `json:"start"`
+	End        int64          `json:"end"`
+	DurationMs int64          `json:"durationMs"`
+	Detail     models.JSONMap `json:"detail,omitempty"`
+}
+
+// HOW MANY SPANS OF A KIND THERE WERE, HOW MANY FAILED AND THE TIME THEY TOOK ALTOGETHER
+type TimelineTotal struct {
+	Count         int    `json:"count"`
+	Failed        int    `json:"failed"`
+	TotalMs       int64  `json:"totalMs"`
+	LongestMs     int64  `json:"longestMs"`
+	LongestSpanID string `json:"longestSpanId,omitempty"`
+}
+
+// LAY A RUN'S SPANS OUT IN LANES: THE RUN FIRST, THEN EACH STAGE FOLLOWED BY ITS TASKS' LANES IN
+// THE ORDER THEY STARTED, THEN DOWNLOAD HOSTS. WITHOUT A RUN SPAN (STILL RUNNING) THE RUN ENDS AT ITS LAST SPAN
+func BuildTimeline(runID string, spans []models.RunEvent) Timeline {
+	timeline := Timeline{RunID: runID, Lanes: []TimelineLane{}, Totals: map[string]TimelineTotal{}, Running: true}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].StartedAt.Before(spans[j].StartedAt) })
+	for _, span := range spans {
+		if span.Kind == SpanRun {
+			timeline.StartedAt, timeline.EndedAt = span.StartedAt, span.EndedAt
+			timeline.Status = span.Status
+			timeline.Running = false
+			if dropped, ok := span.Detail["droppedSpans"].(float64); ok {
+				timeline.Dropped = int(dropped)
+			} else if dropped, ok := span.Detail["droppedSpans"].(int); ok {
+				timeline.Dropped = dropped
+			}
+		}
+	}
+	if timeline.Running {
+		for _, span := range spans {
+			if timeline.StartedAt.IsZero() || span.StartedAt.Before(timeline.StartedAt) {
+				timeline.StartedAt = span.StartedAt
+			}
+			if span.EndedAt.After(timeline.EndedAt) {
+				timeline.EndedAt = span.EndedAt
+			}
+		}
+	}
+	timeline.DurationMs = timeline.EndedAt.Sub(timeline.StartedAt).Milliseconds()
+
+	lanes := make(map[string]int)
+	for _, span := range spans {
+		index, ok := lanes[span.Lane]
+		if !ok {
+			kind := span.Kind
+			if kind == SpanRetry {
+				kind = SpanTask
+			}
+			index = len(timeline.Lanes)
+			lanes[span.Lane] = index
+			timeline.Lanes = append(timeline.Lanes, TimelineLane{Name: span.Lane, Kind: kind})
+		}
+		timeline.Lanes[index].Spans = append(timeline.Lanes[index].Spans, TimelineSpan{
+			ID:         span.ID,
+			Kind:       span.Kind,
+			Name:       span.Name,
+			Stage:      span.Stage,
+			TaskID:     span.TaskID,
+			Attempt:    span.Attempt,
+			Status:     span.Status,
+			Error:      span.Error,
+			Start:      span.StartedAt.Sub(timeline.StartedAt).Milliseconds(),
+			End:        span.EndedAt.Sub(timeline.StartedAt).Milliseconds(),
+			DurationMs: span.DurationMs,
+			Detail:     span.Detail,
+		})
+
+		total := timeline.Totals[span.Kind]
+		total.Count++
+		if span.Status == SpanFailed {
+			total.Failed++
+		}
+		total.TotalMs += span.DurationMs
+		if span.DurationMs > total.LongestMs || total.LongestSpanID == "" {
+			total.LongestMs, total.LongestSpanID = span.DurationMs, span.ID
+		}
+		timeline.Totals[span.Kind] = total
+	}
+
+	// LANES ARE IN ORDER OF THEIR FIRST SPAN ALREADY; THE RUN GOES ON TOP AND DOWNLOADS AT THE BOTTOM
+	rank := func(kind string) int {
+		switch kind {
+		case SpanRun:
+			return 0
+		case SpanDownload:
+			return 2
+		}
+		return 1
+	}
+	sort.SliceStable(timeline.Lanes, func(i, j int) bool { return rank(timeline.Lanes[i].Kind) < rank(timeline.Lanes[j].Kind) })
+	return timeline
+}
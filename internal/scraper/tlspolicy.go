@@ -0,0 +1,179 @@
+package scraper
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	utls "github.com/refraction-networking/utls"
+)
+
+// -- TLS VERIFICATION --
+
+// JOB RULE TURNING CERTIFICATE VERIFICATION OFF (OR BACK ON) FOR ONE JOB
+const tlsVerifyRule = "tlsVerify"
+
+// HOW DIRECT HTTP REQUESTS CHECK SERVER CERTIFICATES
+type TLSPolicy struct {
+	Verify        bool     // CHECK CERTIFICATES AT ALL
+	CAFile        string   // PEM BUNDLE TRUSTED ON TOP OF THE SYSTEM ROOTS
+	InsecureHosts []string // HOSTS (OR *.DOMAINS) WHOSE CERTIFICATES ARE NOT CHECKED
+}
+
+// POLICY FROM THE GLOBAL SETTINGS
+func TLSPolicyFromConfig(cfg *config.Config) TLSPolicy {
+	return TLSPolicy{Verify: cfg.VerifyTLS(), CAFile: cfg.TLSCAFile, InsecureHosts: cfg.TLSInsecureHosts}
+}
+
+// POLICY FOR A RUNNING JOB: THE SETTINGS, WITH ITS tlsVerify RULE TAKING PRECEDENCE
+func (e *Engine) tlsPolicy(jobID string) TLSPolicy {
+	policy := TLSPolicyFromConfig(e.cfg)
+	if v, ok := e.jobRule(jobID, tlsVerifyRule); ok {
+		if verify, ok := v.(bool); ok {
+			policy.Verify = verify
+		}
+	}
+	return policy
+}
+
+// WHETHER THE GO DEFAULTS (SYSTEM ROOTS, EVERY HOST CHECKED) ALREADY IMPLEMENT THE POLICY
+func (p TLSPolicy) isDefault() bool {
+	return p.Verify && p.CAFile == "" && len(p.InsecureHosts) == 0
+}
+
+// TRANSPORT CACHE KEY
+func (p TLSPolicy) key() string {
+	if p.isDefault() {
+		return ""
+	}
+	return fmt.Sprintf("%t|%s|%s", p.Verify, p.CAFile, strings.Join(p.InsecureHosts, ","))
+}
+
+// WHETHER A HOST'S CERTIFICATE GOES UNCHECKED
+func (p TLSPolicy) skips(host string) bool {
+	if !p.Verify {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.InsecureHosts {
+		pattern = strings.ToLower(pattern)
+		if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// CA BUNDLES ARE READ ONCE PER PATH
+var (
+	caPoolsMu sync.Mutex
+	caPools   = make(map[string]*x509.CertPool)
+)
+
+// SYSTEM ROOTS PLUS THE POLICY'S BUNDLE (NIL MEANS THE SYSTEM ROOTS)
+func (p TLSPolicy) roots() (*x509.CertPool, error) {
+	if p.CAFile == "" {
+		return nil, nil
+	}
+	caPoolsMu.Lock()
+	defer caPoolsMu.Unlock()
+	if pool, ok := caPools[p.CAFile]; ok {
+		return pool, nil
+	}
+	pem, err := os.ReadFile(p.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO READ CA BUNDLE: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA BUNDLE %s HAS NO CERTIFICATES", p.CAFile)
+	}
+	caPools[p.CAFile] = pool
+	return pool, nil
+}
+
+// CHECK A SERVER'S CHAIN AGAINST THE POLICY; AN UNREADABLE BUNDLE FAILS CLOSED
+func (p TLSPolicy) verify(serverName string, certs []*x509.Certificate) error {
+	if p.skips(serverName) {
+		return nil
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("SERVER SENT NO CERTIFICATE")
+	}
+	roots, err := p.roots()
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{DNSName: serverName, Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+// crypto/tls CONFIG FOR THE POLICY (NIL FOR THE DEFAULTS). GO'S OWN CHECK IS SKIPPED SO
+// THE POLICY CAN DECIDE PER HOST, THEN RUN IN VerifyConnection INSTEAD. host IS THE NAME
+// DIALED; WITHOUT IT THE SNI IS USED, WHICH IS EMPTY FOR IP ADDRESSES
+func (p TLSPolicy) tlsConfig(host string) *tls.Config {
+	if p.isDefault() {
+		return nil
+	}
+	return &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return p.verify(cmp.Or(host, cs.ServerName), cs.PeerCertificates)
+		},
+	}
+}
+
+// DIAL TLS CONNECTIONS FOR A STANDARD TRANSPORT UNDER THE POLICY (NIL FOR THE DEFAULTS)
+func (p TLSPolicy) dialTLS(dial dialFunc, nextProtos []string) dialFunc {
+	if p.isDefault() {
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		rawConn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		config := p.tlsConfig(host)
+		config.NextProtos = nextProtos
+		conn := tls.Client(rawConn, config)
+		if err := conn.HandshakeContext(ctx); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// THE SAME FOR FINGERPRINTED (uTLS) CONNECTIONS TO host
+func (p TLSPolicy) applyUTLS(config *utls.Config, host string) {
+	if p.isDefault() {
+		return
+	}
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs utls.ConnectionState) error {
+		return p.verify(host, cs.PeerCertificates)
+	}
+}
@@ -0,0 +1,195 @@
+package scraper
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// -- TWO-FACTOR CODES --
+
+// SECRET FROM THE VAULT BY NAME
+func (e *Engine) vaultSecret(name string) (string, error) {
+	var secret models.Secret
+	if err := e.db.First(&secret, "name = ?", name).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("%w: NO SECRET NAMED %q IN THE VAULT", ErrInvalidInput, name)
+		}
+		return "", fmt.Errorf("FAILED TO READ SECRET %q: %v", name, err)
+	}
+	value, err := utils.OpenSecret(secret.Name, secret.Value, e.cfg.EncryptionSecret)
+	if err != nil {
+		return "", fmt.Errorf("FAILED TO OPEN SECRET %q: %v", name, err)
+	}
+	return value, nil
+}
+
+// TOTP PARAMETERS (RFC 6238)
+type totpParams struct {
+	Key       []byte
+	Digits    int
+	Period    int
+	Algorithm string
+}
+
+// GENERATE TOTP TASK: THE CURRENT CODE FOR A SEED STORED IN THE VAULT, FOR TWO-FACTOR PROMPTS
+type GenerateTOTPTask struct{}
+
+func (t *GenerateTOTPTask) GetInputSchema() map[string]string {
+	return map[string]string{
+		"secret":      "string",  // REQUIRED (VAULT NAME OF A BASE32 SEED OR otpauth:// URI)
+		"digits":      "number?", // OPTIONAL (DEFAULTS TO 6, OR THE URI'S)
+		"period":      "number?", // OPTIONAL (SECONDS, DEFAULTS TO 30, OR THE URI'S)
+		"algorithm":   "string?", // OPTIONAL (SHA1, SHA256, SHA512; DEFAULTS TO SHA1, OR THE URI'S)
+		"minValidity": "number?", // OPTIONAL (SECONDS; WAIT FOR THE NEXT CODE IF THIS ONE EXPIRES SOONER)
+	}
+}
+
+func (t *GenerateTOTPTask) GetOutputSchema() string {
+	return "string"
+}
+
+func (t *GenerateTOTPTask) ValidateConfig(config map[string]any) error {
+	if name, _ := config["secret"].(string); name == "" {
+		return ErrMissingRequiredInput
+	}
+	if digits := numberConfig(config, "digits", 6); digits < 6 || digits > 10 {
+		return fmt.Errorf("%w: digits MUST BE BETWEEN 6 AND 10", ErrInvalidInput)
+	}
+	if period := numberConfig(config, "period", 30); period < 1 {
+		return fmt.Errorf("%w: period MUST BE POSITIVE", ErrInvalidInput)
+	}
+	if algorithm, ok := config["algorithm"].(string); ok && totpHash(algorithm) == nil {
+		return fmt.Errorf("%w: algorithm MUST BE SHA1, SHA256 OR SHA512", ErrInvalidInput)
+	}
+	if minValidity := numberConfig(config, "minValidity", 0); minValidity < 0 || minValidity >= numberConfig(config, "period", 30) {
+		return fmt.Errorf("%w: minValidity MUST BE LESS THAN THE PERIOD", ErrInvalidInput)
+	}
+	return nil
+}
+
+func (t *GenerateTOTPTask) Execute(ctx *TaskContext, config map[string]any) (TaskData, error) {
+	name, _ := config["secret"].(string)
+	seed, err := ctx.Engine.vaultSecret(name)
+	if err != nil {
+		return TaskData{}, err
+	}
+	params, err := parseTOTPSeed(seed)
+	if err != nil {
+		return TaskData{}, fmt.Errorf("SECRET %q: %v", name, err)
+	}
+	if _, ok := config["digits"]; ok {
+		params.Digits = int(numberConfig(config, "digits", 6))
+	}
+	if _, ok := config["period"]; ok {
+		params.Period = int(numberConfig(config, "period", 30))
+	}
+	if algorithm, ok := config["algorithm"].(string); ok && algorithm != "" {
+		params.Algorithm = algorithm
+	}
+	if totpHash(params.Algorithm) == nil {
+		return TaskData{}, fmt.Errorf("%w: UNSUPPORTED TOTP ALGORITHM %q", ErrInvalidInput, params.Algorithm)
+	}
+
+	// A CODE ABOUT TO EXPIRE MAY BE STALE BY THE TIME THE FORM IS SUBMITTED
+	now := time.Now()
+	period := time.Duration(params.Period) * time.Second
+	remaining := period - time.Duration(now.UnixNano()%int64(period))
+	if minValidity := time.Duration(numberConfig(config, "minValidity", 0) * float64(time.Second)); remaining < minValidity {
+		ctx.Logger.Printf("TOTP CODE EXPIRES IN %s, WAITING FOR THE NEXT ONE", remaining.Round(time.Millisecond))
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-ctx.Context.Done():
+			timer.Stop()
+			return TaskData{}, ctx.Context.Err()
+		}
+		now = time.Now()
+	}
+
+	// THE CODE ITSELF IS NEVER LOGGED
+	ctx.Logger.Printf("GENERATED TOTP CODE FROM SECRET %q", name)
+	return TaskData{Type: "string", Value: totpCode(params, now)}, nil
+}
+
+// PARSE A BASE32 SEED OR AN otpauth://totp/ URI INTO TOTP PARAMETERS
+func parseTOTPSeed(seed string) (totpParams, error) {
+	params := totpParams{Digits: 6, Period: 30, Algorithm: "SHA1"}
+	seed = strings.TrimSpace(seed)
+	if strings.HasPrefix(strings.ToLower(seed), "otpauth://") {
+		u, err := url.Parse(seed)
+		if err != nil {
+			return params, fmt.Errorf("INVALID otpauth URI: %v", err)
+		}
+		if u.Host != "totp" {
+			return params, fmt.Errorf("ONLY otpauth://totp URIS ARE SUPPORTED")
+		}
+		query := u.Query()
+		seed = query.Get("secret")
+		if v := query.Get("digits"); v != "" {
+			if params.Digits, err = strconv.Atoi(v); err != nil || params.Digits < 6 || params.Digits > 10 {
+				return params, fmt.Errorf("INVALID digits IN otpauth URI")
+			}
+		}
+		if v := query.Get("period"); v != "" {
+			if params.Period, err = strconv.Atoi(v); err != nil || params.Period < 1 {
+				return params, fmt.Errorf("INVALID period IN otpauth URI")
+			}
+		}
+		if v := query.Get("algorithm"); v != "" {
+			params.Algorithm = v
+		}
+	}
+
+	// SEEDS ARE OFTEN SHOWN IN LOWER CASE, GROUPED BY SPACES OR WITHOUT PADDING
+	seed = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(seed))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(seed, "="))
+	if err != nil || len(key) == 0 {
+		return params, fmt.Errorf("TOTP SEED IS NOT VALID BASE32")
+	}
+	params.Key = key
+	return params, nil
+}
+
+// HASH FOR A TOTP ALGORITHM NAME (NIL WHEN UNSUPPORTED)
+func totpHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(strings.ReplaceAll(algorithm, "-", "")) {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// CODE FOR THE PERIOD CONTAINING at (RFC 4226 DYNAMIC TRUNCATION)
+func totpCode(params totpParams, at time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix()/int64(params.Period)))
+	mac := hmac.New(totpHash(params.Algorithm), params.Key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+	modulus := uint64(1)
+	for range params.Digits {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", params.Digits, value%modulus)
+}
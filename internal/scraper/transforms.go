@@ -0,0 +1,536 @@
+package scraper
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ONE STEP OF A FIELD'S TRANSFORM CHAIN, WITH ITS OPTIONS ALREADY PARSED
+type fieldTransform struct {
+	name  string
+	apply func(value any) (any, error)
+}
+
+// FIELD NAME -> TRANSFORMS APPLIED IN ORDER
+type recordTransforms map[string][]fieldTransform
+
+// BUILDERS FOR EACH TRANSFORM TYPE; OPTIONS COME FROM THE OBJECT FORM, E.G.
+// {"type": "number", "locale": "de-DE"}
+var transformBuilders = map[string]func(opts map[string]any) (func(any) (any, error), error){
+	"trim":      func(map[string]any) (func(any) (any, error), error) { return stringTransform(trimSpace), nil },
+	"lowercase": func(map[string]any) (func(any) (any, error), error) { return stringTransform(strings.ToLower), nil },
+	"uppercase": func(map[string]any) (func(any) (any, error), error) { return stringTransform(strings.ToUpper), nil },
+	"slugify":   func(map[string]any) (func(any) (any, error), error) { return stringTransform(slugTransform), nil },
+	"regex":     regexTransform,
+	"number":    numberTransform,
+	"price":     priceTransform,
+	"date":      dateTransform,
+	"unit":      unitTransform,
+}
+
+// PARSE A transforms INPUT: FIELD -> "type", {"type": ..., OPTIONS} OR A LIST OF EITHER
+func parseTransforms(raw any) (recordTransforms, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	input, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: transforms MUST BE AN OBJECT OF FIELD -> TRANSFORM", ErrInvalidInput)
+	}
+	transforms := make(recordTransforms, len(input))
+	for field, spec := range input {
+		steps, err := parseTransformChain(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%w: FIELD %s: %v", ErrInvalidInput, field, err)
+		}
+		transforms[field] = steps
+	}
+	return transforms, nil
+}
+
+// PARSE ONE FIELD'S TRANSFORM OR LIST OF TRANSFORMS
+func parseTransformChain(spec any) ([]fieldTransform, error) {
+	specs, isList := spec.([]any)
+	if !isList {
+		specs = []any{spec}
+	}
+	steps := make([]fieldTransform, 0, len(specs))
+	for _, s := range specs {
+		var name string
+		opts := map[string]any{}
+		switch v := s.(type) {
+		case string:
+			name = v
+		case map[string]any:
+			name, _ = v["type"].(string)
+			opts = v
+		default:
+			return nil, fmt.Errorf("TRANSFORM MUST BE A NAME OR AN OBJECT WITH A type")
+		}
+		build, ok := transformBuilders[name]
+		if !ok {
+			return nil, fmt.Errorf("UNKNOWN TRANSFORM %q (EXPECTED ONE OF: %s)", name, strings.Join(transformNames(), ", "))
+		}
+		apply, err := build(opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		steps = append(steps, fieldTransform{name: name, apply: apply})
+	}
+	return steps, nil
+}
+
+// SORTED TRANSFORM TYPE NAMES FOR ERROR MESSAGES
+func transformNames() []string {
+	names := make([]string, 0, len(transformBuilders))
+	for name := range transformBuilders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MERGE THE PER-FIELD transform KEYS OF extractRecords FIELDS WITH ITS transforms INPUT;
+// A FIELD'S OWN transform RUNS FIRST
+func fieldSpecTransforms(fields map[string]map[string]any, raw any) (recordTransforms, error) {
+	transforms, err := parseTransforms(raw)
+	if err != nil {
+		return nil, err
+	}
+	for name, spec := range fields {
+		inline, ok := spec["transform"]
+		if !ok {
+			continue
+		}
+		steps, err := parseTransformChain(inline)
+		if err != nil {
+			return nil, fmt.Errorf("%w: FIELD %s: %v", ErrInvalidInput, name, err)
+		}
+		if transforms == nil {
+			transforms = make(recordTransforms)
+		}
+		transforms[name] = append(steps, transforms[name]...)
+	}
+	return transforms, nil
+}
+
+// APPLY TRANSFORMS TO EVERY OBJECT IN items IN PLACE. LIST VALUES ARE TRANSFORMED ELEMENT BY
+// ELEMENT; A VALUE THAT FAILS A STEP BECOMES NULL SO A RECORD SCHEMA CAN CATCH IT
+func (t recordTransforms) apply(items []any, logger *log.Logger) {
+	if len(t) == 0 {
+		return
+	}
+	failures := make(map[string]int)
+	lastErr := make(map[string]error)
+	for _, item := range items {
+		record, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		for field, steps := range t {
+			value, ok := record[field]
+			if !ok {
+				continue
+			}
+			if list, isList := value.([]any); isList {
+				out := make([]any, len(list))
+				for i, element := range list {
+					var err error
+					if out[i], err = runTransforms(steps, element); err != nil {
+						failures[field]++
+						lastErr[field] = err
+					}
+				}
+				record[field] = out
+				continue
+			}
+			transformed, err := runTransforms(steps, value)
+			if err != nil {
+				failures[field]++
+				lastErr[field] = err
+			}
+			record[field] = transformed
+		}
+	}
+	for field, count := range failures {
+		logger.Printf("TRANSFORM FAILED FOR %d VALUES OF FIELD %s (LAST ERROR: %v)", count, field, lastErr[field])
+	}
+}
+
+// RUN A CHAIN ON ONE VALUE; NULLS PASS THROUGH UNTOUCHED
+func runTransforms(steps []fieldTransform, value any) (any, error) {
+	for _, step := range steps {
+		if value == nil {
+			return nil, nil
+		}
+		var err error
+		if value, err = step.apply(value); err != nil {
+			return nil, fmt.Errorf("%s: %v", step.name, err)
+		}
+	}
+	return value, nil
+}
+
+// TRANSFORM STRINGS, LEAVING OTHER VALUES AS THEY ARE
+func stringTransform(fn func(string) string) func(any) (any, error) {
+	return func(value any) (any, error) {
+		if s, ok := value.(string); ok {
+			return fn(s), nil
+		}
+		return value, nil
+	}
+}
+
+// TRIM AND COLLAPSE RUNS OF WHITESPACE (INCLUDING NON-BREAKING SPACES) TO ONE SPACE
+func trimSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// REGEX: {"pattern": "...", "group": 1}; KEEPS THE FIRST MATCH (OR CAPTURE GROUP), NULL IF NONE
+func regexTransform(opts map[string]any) (func(any) (any, error), error) {
+	pattern, _ := opts["pattern"].(string)
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern IS REQUIRED")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("INVALID PATTERN: %v", err)
+	}
+	group := 0
+	if re.NumSubexp() > 0 {
+		group = 1
+	}
+	if g, ok := opts["group"].(float64); ok {
+		group = int(g)
+	}
+	if group < 0 || group > re.NumSubexp() {
+		return nil, fmt.Errorf("PATTERN HAS NO GROUP %d", group)
+	}
+	return func(value any) (any, error) {
+		match := re.FindStringSubmatch(fmt.Sprint(value))
+		if match == nil {
+			return nil, nil
+		}
+		return match[group], nil
+	}, nil
+}
+
+// LANGUAGES THAT WRITE 1.234,56 RATHER THAN 1,234.56
+var commaDecimalLanguages = map[string]bool{
+	"de": true, "fr": true, "es": true, "it": true, "nl": true, "pt": true, "ru": true, "pl": true,
+	"sv": true, "da": true, "fi": true, "nb": true, "no": true, "cs": true, "sk": true, "tr": true,
+	"id": true, "ro": true, "hu": true, "el": true, "uk": true, "vi": true, "bg": true, "hr": true,
+}
+
+// DECIMAL SEPARATOR FOR A LOCALE LIKE "de-DE" OR "en_US" (SWISS LOCALES USE A POINT)
+func localeDecimal(locale string) string {
+	locale = strings.ReplaceAll(strings.ToLower(locale), "_", "-")
+	lang, region, _ := strings.Cut(locale, "-")
+	if commaDecimalLanguages[lang] && region != "ch" && region != "li" {
+		return ","
+	}
+	return "."
+}
+
+// NUMBERS WITH ANY MIX OF GROUPING SEPARATORS: 1,234.56 / 1.234,56 / 1 234,56 / 1'234.56
+var localeNumberPattern = regexp.MustCompile(`[-−]?\d[\d.,'’\s\x{00a0}\x{202f}]*`)
+
+// PARSE THE FIRST NUMBER IN s USING decimal AS THE DECIMAL SEPARATOR
+func parseLocaleNumber(s, decimal string) (float64, error) {
+	match := localeNumberPattern.FindString(s)
+	if match == "" {
+		return 0, fmt.Errorf("NO NUMBER IN %q", s)
+	}
+	match = strings.TrimRight(match, ".,'’   ")
+	if strings.Count(match, decimal) > 1 {
+		return 0, fmt.Errorf("AMBIGUOUS NUMBER %q", match)
+	}
+	var b strings.Builder
+	for _, r := range match {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '-' || r == '−':
+			b.WriteByte('-')
+		case string(r) == decimal:
+			b.WriteByte('.')
+		}
+	}
+	return strconv.ParseFloat(b.String(), 64)
+}
+
+// DECIMAL SEPARATOR FROM {"decimal": ","} OR {"locale": "de-DE"}, DEFAULTING TO A POINT
+func decimalOption(opts map[string]any) (string, error) {
+	if decimal, ok := opts["decimal"].(string); ok {
+		if decimal != "." && decimal != "," {
+			return "", fmt.Errorf("decimal MUST BE \".\" OR \",\"")
+		}
+		return decimal, nil
+	}
+	locale, _ := opts["locale"].(string)
+	return localeDecimal(locale), nil
+}
+
+// NUMBER: {"locale": "de-DE"} OR {"decimal": ","}; PARSES THE FIRST NUMBER IN THE TEXT
+func numberTransform(opts map[string]any) (func(any) (any, error), error) {
+	decimal, err := decimalOption(opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(value any) (any, error) {
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case string:
+			return parseLocaleNumber(v, decimal)
+		}
+		return nil, fmt.Errorf("CANNOT PARSE %T AS A NUMBER", value)
+	}, nil
+}
+
+// CURRENCY SYMBOLS AND THE ISO 4217 CODES THEY NORMALIZE TO, LONGEST FIRST SO "US$" WINS OVER "$"
+var currencySymbols = []struct{ symbol, code string }{
+	{"US$", "USD"}, {"CA$", "CAD"}, {"AU$", "AUD"}, {"NZ$", "NZD"}, {"HK$", "HKD"}, {"R$", "BRL"},
+	{"C$", "CAD"}, {"A$", "AUD"}, {"S$", "SGD"}, {"zł", "PLN"}, {"kr", "SEK"},
+	{"$", "USD"}, {"€", "EUR"}, {"£", "GBP"}, {"¥", "JPY"}, {"₹", "INR"}, {"₩", "KRW"},
+	{"₽", "RUB"}, {"₺", "TRY"}, {"₪", "ILS"}, {"₫", "VND"}, {"₴", "UAH"}, {"฿", "THB"},
+}
+
+// THREE-LETTER CODES WRITTEN NEXT TO AN AMOUNT, E.G. "12.50 EUR"
+var currencyCodePattern = regexp.MustCompile(`\b[A-Z]{3}\b`)
+
+// CURRENCY CODE FOR A PRICE STRING, OR "" IF NONE IS SHOWN
+func detectCurrency(s string) string {
+	if code := currencyCodePattern.FindString(s); code != "" {
+		return code
+	}
+	for _, c := range currencySymbols {
+		if strings.Contains(s, c.symbol) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// PRICE: {"locale", "decimal", "currency" (WHEN THE TEXT SHOWS NONE), "to" + "rates" (CONVERT;
+// rates GIVES THE VALUE OF ONE UNIT OF EACH CURRENCY IN to)}. PRODUCES {"amount", "currency"}
+func priceTransform(opts map[string]any) (func(any) (any, error), error) {
+	decimal, err := decimalOption(opts)
+	if err != nil {
+		return nil, err
+	}
+	fallback, _ := opts["currency"].(string)
+	fallback = strings.ToUpper(fallback)
+	to, _ := opts["to"].(string)
+	to = strings.ToUpper(to)
+	rates := make(map[string]float64)
+	if raw, ok := opts["rates"].(map[string]any); ok {
+		for code, rate := range raw {
+			r, ok := rate.(float64)
+			if !ok || r <= 0 {
+				return nil, fmt.Errorf("RATE FOR %s MUST BE A POSITIVE NUMBER", code)
+			}
+			rates[strings.ToUpper(code)] = r
+		}
+	}
+	if len(rates) > 0 && to == "" {
+		return nil, fmt.Errorf("rates NEED A to CURRENCY")
+	}
+
+	return func(value any) (any, error) {
+		var amount float64
+		currency := fallback
+		switch v := value.(type) {
+		case float64:
+			amount = v
+		case string:
+			var err error
+			if amount, err = parseLocaleNumber(v, decimal); err != nil {
+				return nil, err
+			}
+			if code := detectCurrency(v); code != "" {
+				currency = code
+			}
+		default:
+			return nil, fmt.Errorf("CANNOT PARSE %T AS A PRICE", value)
+		}
+		if to != "" && currency != to {
+			rate, ok := rates[currency]
+			if !ok {
+				return nil, fmt.Errorf("NO RATE TO CONVERT %q TO %s", currency, to)
+			}
+			amount = math.Round(amount*rate*100) / 100
+			currency = to
+		}
+		return map[string]any{"amount": amount, "currency": currency}, nil
+	}, nil
+}
+
+// LAYOUTS TRIED WHEN A date TRANSFORM LISTS NO formats
+var defaultDateFormats = []string{
+	time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02",
+	time.RFC1123Z, time.RFC1123, "January 2, 2006", "Jan 2, 2006", "2 January 2006", "2 Jan 2006",
+	"01/02/2006", "02.01.2006",
+}
+
+// DATE: {"formats": [GO LAYOUTS...], "timezone": "Europe/Berlin", "output": LAYOUT}. NUMBERS ARE
+// UNIX SECONDS; OUTPUT DEFAULTS TO RFC 3339
+func dateTransform(opts map[string]any) (func(any) (any, error), error) {
+	formats := defaultDateFormats
+	if raw, ok := opts["formats"].([]any); ok && len(raw) > 0 {
+		formats = make([]string, 0, len(raw))
+		for _, f := range raw {
+			layout, ok := f.(string)
+			if !ok || layout == "" {
+				return nil, fmt.Errorf("formats MUST BE A LIST OF LAYOUTS")
+			}
+			formats = append(formats, layout)
+		}
+	}
+	location := time.UTC
+	if tz, ok := opts["timezone"].(string); ok && tz != "" {
+		var err error
+		if location, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("UNKNOWN TIMEZONE %q", tz)
+		}
+	}
+	output, _ := opts["output"].(string)
+	if output == "" {
+		output = time.RFC3339
+	}
+
+	return func(value any) (any, error) {
+		switch v := value.(type) {
+		case float64:
+			return time.Unix(int64(v), 0).In(location).Format(output), nil
+		case string:
+			v = trimSpace(v)
+			for _, layout := range formats {
+				if t, err := time.ParseInLocation(layout, v, location); err == nil {
+					return t.Format(output), nil
+				}
+			}
+			return nil, fmt.Errorf("%q MATCHES NONE OF THE DATE FORMATS", v)
+		}
+		return nil, fmt.Errorf("CANNOT PARSE %T AS A DATE", value)
+	}, nil
+}
+
+// UNITS BY DIMENSION, AS A FACTOR TO THE DIMENSION'S BASE UNIT (METRE, GRAM, LITRE)
+var unitFactors = map[string]struct {
+	dimension string
+	factor    float64
+}{
+	"mm": {"length", 0.001}, "cm": {"length", 0.01}, "m": {"length", 1}, "km": {"length", 1000},
+	"in": {"length", 0.0254}, "ft": {"length", 0.3048}, "yd": {"length", 0.9144}, "mi": {"length", 1609.344},
+	"mg": {"mass", 0.001}, "g": {"mass", 1}, "kg": {"mass", 1000}, "t": {"mass", 1e6},
+	"oz": {"mass", 28.349523125}, "lb": {"mass", 453.59237},
+	"ml": {"volume", 0.001}, "cl": {"volume", 0.01}, "l": {"volume", 1},
+	"floz": {"volume", 0.0295735295625}, "gal": {"volume", 3.785411784},
+}
+
+// SPELLINGS NORMALIZED TO THE KEYS ABOVE
+var unitAliases = map[string]string{
+	"\"": "in", "inch": "in", "inches": "in", "'": "ft", "feet": "ft", "foot": "ft",
+	"lbs": "lb", "pound": "lb", "pounds": "lb", "ounce": "oz", "ounces": "oz",
+	"kilo": "kg", "kilos": "kg", "gram": "g", "grams": "g", "litre": "l", "liter": "l", "litres": "l", "liters": "l",
+	"fl oz": "floz", "fl. oz": "floz", "gallon": "gal", "gallons": "gal",
+	"meter": "m", "metre": "m", "meters": "m", "metres": "m", "mile": "mi", "miles": "mi",
+}
+
+// TEMPERATURES AREN'T A SIMPLE FACTOR, SO THEY CONVERT THROUGH CELSIUS
+var temperatureUnits = map[string]bool{"c": true, "f": true, "k": true}
+
+// CANONICAL NAME OF A UNIT, OR "" IF UNKNOWN
+func normalizeUnit(unit string) string {
+	unit = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(unit, "°")))
+	if alias, ok := unitAliases[unit]; ok {
+		unit = alias
+	}
+	if _, ok := unitFactors[unit]; ok || temperatureUnits[unit] {
+		return unit
+	}
+	return ""
+}
+
+// CONVERT BETWEEN TWO CANONICAL UNITS OF THE SAME DIMENSION
+func convertUnit(value float64, from, to string) (float64, error) {
+	if temperatureUnits[from] || temperatureUnits[to] {
+		if !temperatureUnits[from] || !temperatureUnits[to] {
+			return 0, fmt.Errorf("CANNOT CONVERT %s TO %s", from, to)
+		}
+		celsius := map[string]func(float64) float64{
+			"c": func(v float64) float64 { return v },
+			"f": func(v float64) float64 { return (v - 32) * 5 / 9 },
+			"k": func(v float64) float64 { return v - 273.15 },
+		}[from](value)
+		return map[string]func(float64) float64{
+			"c": func(v float64) float64 { return v },
+			"f": func(v float64) float64 { return v*9/5 + 32 },
+			"k": func(v float64) float64 { return v + 273.15 },
+		}[to](celsius), nil
+	}
+	a, b := unitFactors[from], unitFactors[to]
+	if a.dimension != b.dimension {
+		return 0, fmt.Errorf("CANNOT CONVERT %s TO %s", from, to)
+	}
+	return value * a.factor / b.factor, nil
+}
+
+// UNIT: {"to": "kg", "from": "lb" (WHEN THE TEXT SHOWS NO UNIT), "locale"/"decimal"}.
+// READS "12.5 lbs", "3'" OR A BARE NUMBER AND PRODUCES THE NUMBER IN to
+func unitTransform(opts map[string]any) (func(any) (any, error), error) {
+	rawTo, _ := opts["to"].(string)
+	to := normalizeUnit(rawTo)
+	if to == "" {
+		return nil, fmt.Errorf("UNKNOWN OR MISSING to UNIT %q", rawTo)
+	}
+	from := ""
+	if rawFrom, ok := opts["from"].(string); ok {
+		if from = normalizeUnit(rawFrom); from == "" {
+			return nil, fmt.Errorf("UNKNOWN from UNIT %q", rawFrom)
+		}
+	}
+	decimal, err := decimalOption(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(value any) (any, error) {
+		var amount float64
+		unit := from
+		switch v := value.(type) {
+		case float64:
+			amount = v
+		case string:
+			loc := localeNumberPattern.FindStringIndex(v)
+			if loc == nil {
+				return nil, fmt.Errorf("NO NUMBER IN %q", v)
+			}
+			var err error
+			if amount, err = parseLocaleNumber(v, decimal); err != nil {
+				return nil, err
+			}
+			rest := strings.TrimSpace(v[loc[1]:])
+			shown := normalizeUnit(rest)
+			if words := strings.Fields(rest); shown == "" && len(words) > 1 {
+				shown = normalizeUnit(words[0])
+			}
+			if shown != "" {
+				unit = shown
+			}
+		default:
+			return nil, fmt.Errorf("CANNOT PARSE %T AS A QUANTITY", value)
+		}
+		if unit == "" {
+			return nil, fmt.Errorf("NO UNIT IN %v AND NO from UNIT SET", value)
+		}
+		return convertUnit(amount, unit, to)
+	}, nil
+}
@@ -0,0 +1,347 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/storage"
+	"github.com/nickheyer/Crepes/internal/utils"
+	"gorm.io/gorm"
+)
+
+// -- REMOTE STORAGE --
+//
+// A RUN WHOSE storage SETTING NAMES A BUCKET STREAMS ITS DOWNLOADS STRAIGHT INTO IT, AND THE
+// ASSET IS SAVED done. ONE THAT HAS TO BE ON DISK FIRST (SEE streamingBackend) STAYS THERE
+// (storageStatus pending) WHILE ITS THUMBNAIL IS RENDERED AND IT IS ENCRYPTED, IF THE JOB WANTS
+// THAT. THEN THE FILE AND THUMBNAIL ARE STREAMED TO THE BUCKET, THE ASSET IS MARKED done AND THE
+// LOCAL COPIES GO. AN UPLOAD THAT FAILS STAYS pending FOR THE SWEEP. RENDERING THE THUMBNAIL OF AN
+// ASSET IN A BUCKET FETCHES THE FILE BACK FOR AS LONG AS IT TAKES.
+
+// AN ASSET'S storageStatus
+const (
+	StoragePending = "pending"
+	StorageDone    = "done"
+)
+
+const (
+	uploadSweepInterval = 5 * time.Minute
+	uploadSweepBatch    = 100
+	uploadTimeout       = time.Hour // ONE FILE, HOWEVER LARGE
+)
+
+// MOVES FINISHED ASSETS TO THE CONFIGURED BUCKET
+type AssetUploader struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	stores *storage.Stores
+	events *EventBus
+
+	mu     sync.Mutex
+	active map[string]bool // ASSETS BEING UPLOADED
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// AN UPLOADER FOR THE CONFIGURED BACKEND, SWEEPING FOR pending ASSETS WHEN IT'S A BUCKET. A
+// BACKEND THE CONFIG GETS WRONG LEAVES EVERYTHING ON DISK (THE SERVER REFUSES TO START WITH ONE)
+func NewAssetUploader(db *gorm.DB, cfg *config.Config, events *EventBus) *AssetUploader {
+	stores, err := storage.Open(cfg)
+	if err != nil {
+		log.Printf("ASSET STORAGE: %v, KEEPING ASSETS ON DISK", err)
+		stores, _ = storage.Open(&config.Config{StoragePath: cfg.StoragePath, ThumbnailsPath: cfg.ThumbnailsPath})
+	}
+	u := &AssetUploader{
+		db:     db,
+		cfg:    cfg,
+		stores: stores,
+		events: events,
+		active: make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+	if stores.Remote() {
+		go u.sweepLoop()
+	}
+	return u
+}
+
+func (u *AssetUploader) Close() {
+	u.closeOnce.Do(func() { close(u.done) })
+}
+
+// WHETHER NEW ASSETS CAN GO TO backend
+func (u *AssetUploader) Accepts(backend string) bool {
+	return u.stores.Remote() && u.stores.Backend == backend
+}
+
+// WHERE FILES FOR backend GO, OR NIL WHEN IT ISN'T THE CONFIGURED BUCKET
+func (u *AssetUploader) bucket(backend string) storage.Storage {
+	if !u.Accepts(backend) {
+		return nil
+	}
+	return u.stores.Assets
+}
+
+// THE KEY OF A FILE UNDER storagePath, AS ITS ASSET'S LocalPath IS STORED
+func (u *AssetUploader) key(filePath string) (string, error) {
+	localPath, err := utils.RelativeToRoot(u.cfg.StoragePath, filePath)
+	if err != nil {
+		return "", err
+	}
+	return storage.Key(localPath), nil
+}
+
+// A WRITER WHOSE BYTES GO TO key IN store AS THEY'RE WRITTEN. finish ENDS THE BODY, ABORTING THE
+// UPLOAD WHEN THE WRITER FAILED, AND WAITS FOR THE BUCKET TO HAVE IT
+func streamTo(ctx context.Context, store storage.Storage, key, contentType string) (io.Writer, func(error) error) {
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := store.Put(ctx, key, reader, -1, contentType)
+		// A FAILED UPLOAD STOPS THE WRITER TOO
+		if err != nil {
+			reader.CloseWithError(err)
+		}
+		done <- err
+	}()
+	return writer, func(err error) error {
+		writer.CloseWithError(err)
+		if putErr := <-done; err == nil {
+			return putErr
+		}
+		return err
+	}
+}
+
+// UPLOAD A pending ASSET'S FILE AND THUMBNAIL, THEN DROP THE LOCAL COPIES. ANYTHING ELSE (ALREADY
+// UPLOADED, ON DISK FOR GOOD, DELETED, BEING UPLOADED) IS LEFT ALONE
+func (u *AssetUploader) Upload(assetID string) error {
+	u.mu.Lock()
+	if u.active[assetID] {
+		u.mu.Unlock()
+		return nil
+	}
+	u.active[assetID] = true
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		delete(u.active, assetID)
+		u.mu.Unlock()
+	}()
+
+	var asset models.Asset
+	if found := u.db.Where("id = ?", assetID).Limit(1).Find(&asset); found.Error != nil || found.RowsAffected == 0 {
+		return found.Error
+	}
+	if asset.StorageStatus != StoragePending || !u.Accepts(asset.Storage) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	sourcePath, err := resolveAssetPath(u.cfg.StoragePath, asset.LocalPath)
+	if err != nil {
+		return u.failed(&asset, err)
+	}
+	if err := putFile(ctx, u.stores.Assets, storage.Key(asset.LocalPath), sourcePath, assetMimeType(&asset)); err != nil {
+		return u.failed(&asset, err)
+	}
+	thumbnailPath := ""
+	if asset.ThumbnailPath != "" {
+		if path, err := utils.ConfinePath(u.cfg.ThumbnailsPath, asset.ThumbnailPath); err == nil {
+			if _, err := os.Stat(path); err == nil {
+				if err := putFile(ctx, u.stores.Thumbnails, storage.Key(asset.ThumbnailPath), path, "image/jpeg"); err != nil {
+					return u.failed(&asset, err)
+				}
+				thumbnailPath = path
+			}
+		}
+	}
+
+	recorded := u.db.Model(&models.Asset{}).Where("id = ?", asset.ID).UpdateColumn("storage_status", StorageDone)
+	if recorded.Error != nil {
+		return fmt.Errorf("FAILED TO RECORD UPLOAD OF ASSET %s: %w", asset.ID, recorded.Error)
+	}
+	if recorded.RowsAffected == 0 {
+		// DELETED WHILE IT WAS UPLOADING; ITS FILES ON DISK WENT WITH IT
+		u.Remove(&asset)
+		return nil
+	}
+	if !u.stores.KeepLocal {
+		for _, path := range []string{sourcePath, thumbnailPath} {
+			if path != "" {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					log.Printf("UPLOADED ASSET %s BUT COULDN'T DELETE %s: %v", asset.ID, path, err)
+				}
+			}
+		}
+	}
+	u.events.Publish("asset.uploaded", asset.JobID, map[string]any{"assetId": asset.ID, "storage": asset.Storage})
+	return nil
+}
+
+func (u *AssetUploader) failed(asset *models.Asset, err error) error {
+	log.Printf("UPLOADING ASSET %s TO %s FAILED, RETRYING ON THE NEXT SWEEP: %v", asset.ID, asset.Storage, err)
+	return err
+}
+
+// PUT A LOCAL FILE UNDER key, STREAMED FROM DISK
+func putFile(ctx context.Context, store storage.Storage, key, path, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, file, info.Size(), contentType)
+}
+
+// CONTENT TYPE TO STORE A FILE UNDER: THE ONE IT WAS DOWNLOADED WITH, OR ITS EXTENSION'S
+func assetMimeType(asset *models.Asset) string {
+	if ct, ok := asset.Metadata["contentType"].(string); ok && ct != "" {
+		return ct
+	}
+	return mime.TypeByExtension(filepath.Ext(asset.LocalPath))
+}
+
+// BRING A REMOTE ASSET'S FILE BACK TO ITS LOCAL PATH FOR RENDERING; release REMOVES IT AGAIN
+// (UNLESS LOCAL COPIES ARE KEPT). A LOCAL ASSET, OR ONE ALREADY ON DISK, IS LEFT AS IT IS
+func (u *AssetUploader) fetch(asset *models.Asset) (release func(), err error) {
+	release = func() {}
+	if !asset.IsRemote() || !u.Accepts(asset.Storage) {
+		return release, nil
+	}
+	target, err := utils.ConfinePath(u.cfg.StoragePath, asset.LocalPath)
+	if err != nil {
+		return release, err
+	}
+	if _, err := os.Stat(target); err == nil {
+		return release, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	body, _, err := u.stores.Assets.Get(ctx, storage.Key(asset.LocalPath), "")
+	if err != nil {
+		return release, fmt.Errorf("FAILED TO FETCH ASSET FROM %s: %w", asset.Storage, err)
+	}
+	defer body.Close()
+	if err := storage.NewLocal(u.cfg.StoragePath).Put(ctx, storage.Key(asset.LocalPath), body, -1, ""); err != nil {
+		return release, fmt.Errorf("FAILED TO FETCH ASSET FROM %s: %w", asset.Storage, err)
+	}
+	if u.stores.KeepLocal {
+		return release, nil
+	}
+	return func() { os.Remove(target) }, nil
+}
+
+// A REMOTE ASSET'S THUMBNAIL WAS RE-RENDERED: UPLOAD THE NEW ONE AND DELETE previous FROM THE BUCKET
+func (u *AssetUploader) replaceThumbnail(asset *models.Asset, previous string) {
+	if !asset.IsRemote() || !u.Accepts(asset.Storage) || asset.ThumbnailPath == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	path, err := utils.ConfinePath(u.cfg.ThumbnailsPath, asset.ThumbnailPath)
+	if err != nil {
+		return
+	}
+	if err := putFile(ctx, u.stores.Thumbnails, storage.Key(asset.ThumbnailPath), path, "image/jpeg"); err != nil {
+		// SERVED FROM DISK UNTIL THE NEXT RENDER
+		log.Printf("FAILED TO UPLOAD THE NEW THUMBNAIL OF ASSET %s: %v", asset.ID, err)
+		return
+	}
+	if !u.stores.KeepLocal {
+		os.Remove(path)
+	}
+	if previous != "" && previous != asset.ThumbnailPath {
+		if err := u.stores.Thumbnails.Delete(ctx, storage.Key(previous)); err != nil {
+			log.Printf("FAILED TO DELETE OLD THUMBNAIL %s FROM %s: %v", previous, asset.Storage, err)
+		}
+	}
+}
+
+// DELETE AN ASSET'S FILES FROM ITS BUCKET, RETURNING THE BYTES FREED THERE
+func (u *AssetUploader) Remove(asset *models.Asset) int64 {
+	if asset.Storage == "" || !u.Accepts(asset.Storage) {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var errs []error
+	if asset.LocalPath != "" {
+		errs = append(errs, u.stores.Assets.Delete(ctx, storage.Key(asset.LocalPath)))
+	}
+	if asset.ThumbnailPath != "" {
+		errs = append(errs, u.stores.Thumbnails.Delete(ctx, storage.Key(asset.ThumbnailPath)))
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("FAILED TO DELETE ASSET %s FROM %s: %v", asset.ID, asset.Storage, err)
+		return 0
+	}
+	if !asset.IsRemote() {
+		return 0
+	}
+	return asset.Size
+}
+
+func (u *AssetUploader) sweepLoop() {
+	ticker := time.NewTicker(uploadSweepInterval)
+	defer ticker.Stop()
+	for {
+		u.sweep()
+		select {
+		case <-u.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// UPLOAD pending ASSETS NOTHING IS WORKING ON: THEIR THUMBNAIL IS SETTLED, AND ANY ENCRYPTION DONE
+func (u *AssetUploader) sweep() {
+	var assets []models.Asset
+	err := u.db.Select("id", "local_path", "metadata").
+		Where("storage = ? AND storage_status = ? AND (thumbnail_status IS NULL OR thumbnail_status != ?)", u.stores.Backend, StoragePending, ThumbnailPending).
+		Order("created_at").Limit(uploadSweepBatch).Find(&assets).Error
+	if err != nil {
+		log.Printf("FAILED TO LOOK FOR PENDING UPLOADS: %v", err)
+		return
+	}
+	for _, asset := range assets {
+		if encrypted, _ := asset.Metadata["encrypted"].(bool); encrypted {
+			if path, err := resolveAssetPath(u.cfg.StoragePath, asset.LocalPath); err == nil && !utils.IsEncryptedFile(path) {
+				continue // THE JOB'S ASSET POOL IS STILL ENCRYPTING IT
+			}
+		}
+		select {
+		case <-u.done:
+			return
+		default:
+		}
+		u.Upload(asset.ID)
+	}
+}
+
+// WHERE FINISHED ASSETS ARE KEPT
+func (e *Engine) Storage() *storage.Stores {
+	return e.uploads.stores
+}
+
+// DELETE AN ASSET'S FILES FROM ITS BUCKET (FILES ON DISK ARE THE CALLER'S), RETURNING THE BYTES FREED
+func (e *Engine) RemoveRemoteFiles(asset *models.Asset) int64 {
+	return e.uploads.Remove(asset)
+}
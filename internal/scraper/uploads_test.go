@@ -0,0 +1,42 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickheyer/Crepes/internal/storage"
+)
+
+func TestStreamTo(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewLocal(dir)
+
+	w, finish := streamTo(context.Background(), store, "job/video.ts", "video/mp2t")
+	for _, segment := range []string{"first ", "second ", "third"} {
+		if _, err := io.WriteString(w, segment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "job", "video.ts"))
+	if err != nil || string(got) != "first second third" {
+		t.Fatalf("stored %q, %v", got, err)
+	}
+
+	// A DOWNLOAD THAT FAILS PARTWAY STORES NOTHING
+	failed := errors.New("segment 3 failed")
+	w, finish = streamTo(context.Background(), store, "job/broken.ts", "video/mp2t")
+	io.WriteString(w, "first ")
+	if err := finish(failed); !errors.Is(err, failed) {
+		t.Fatalf("finish = %v; want the download's error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "job", "broken.ts")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("a failed download was stored: %v", err)
+	}
+}
@@ -0,0 +1,587 @@
+package scraper
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// -- USER AGENT UPDATES --
+//
+// DIRECT REQUESTS AND THE rotate STRATEGY SEND USER AGENTS FROM A POOL. THE BUILD SHIPS ONE, BUT
+// BROWSERS RELEASE EVERY FEW WEEKS AND AN OUTDATED USER AGENT IS ITSELF A BOT SIGNAL, SO WITH
+// userAgentUpdates.url SET THE POOL IS REFRESHED FROM A MANIFEST ON A SCHEDULE. THE MANIFEST MUST
+// BE SIGNED WITH THE CONFIGURED ED25519 KEY, IS NEVER OLDER THAN THE ONE IN USE, AND STOPS
+// COUNTING ONCE IT EXPIRES. IT CAN ALSO NAME A CHROMIUM BUILD PER PLATFORM; WITH updateBrowsers
+// ON THAT BUILD IS DOWNLOADED, CHECKED AGAINST ITS SHA-256 AND LAUNCHED FROM THE NEXT START.
+
+// SCHEDULE USED WHEN THE CONFIG SETS NONE, AND THE VALUE THAT TURNS SCHEDULED CHECKS OFF
+const (
+	defaultUserAgentSchedule = "30 3 * * *"
+	userAgentsOff            = "off"
+)
+
+// WHERE THE LAST VERIFIED MANIFEST AND THE BROWSERS IT NAMED ARE KEPT IN THE DATA DIRECTORY
+const (
+	userAgentManifestFile = "user-agents.json"
+	manifestBrowsersDir   = "browsers"
+	installedBrowserFile  = "installed.json"
+)
+
+// LIMITS ON WHAT A MANIFEST MAY HOLD
+const (
+	maxManifestBytes       = 1 << 20
+	maxManifestUserAgents  = 200
+	maxUserAgentLength     = 512
+	maxBrowserArchiveBytes = 1 << 30
+	manifestClockSkew      = time.Hour // HOW FAR IN THE FUTURE issuedAt MAY BE
+)
+
+// POOL SOURCES
+const (
+	UserAgentsBundled  = "bundled"
+	UserAgentsManifest = "manifest"
+)
+
+// CHECK OUTCOMES
+const (
+	UserAgentsUpdated = "updated"
+	UserAgentsCurrent = "current"
+	UserAgentsFailed  = "failed"
+)
+
+// BROWSER VERSIONS ARE USED IN DIRECTORY NAMES
+var browserVersionPattern = regexp.MustCompile(`^[0-9A-Za-z._-]{1,64}$`)
+
+var (
+	ErrUserAgentUpdatesOff     = errors.New("USER AGENT UPDATES ARE NOT CONFIGURED")
+	ErrUserAgentUpdateRunning  = errors.New("A USER AGENT UPDATE IS ALREADY RUNNING")
+	errManifestSignature       = errors.New("MANIFEST SIGNATURE DOES NOT MATCH THE PUBLIC KEY")
+	errManifestBrowserChecksum = errors.New("BROWSER ARCHIVE DOES NOT MATCH THE MANIFEST'S SHA-256")
+)
+
+// THE POOL THE BUILD SHIPS WITH: CURRENT CHROME, EDGE, FIREFOX AND SAFARI ON THE COMMON DESKTOPS
+var bundledUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36 Edg/141.0.0.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:144.0) Gecko/20100101 Firefox/144.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:144.0) Gecko/20100101 Firefox/144.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/26.0 Safari/605.1.15",
+}
+
+// THE USER AGENTS IN USE
+type UserAgentPool struct {
+	Source     string     `json:"source"`            // bundled OR manifest
+	Version    int64      `json:"version,omitempty"` // THE MANIFEST'S
+	IssuedAt   *time.Time `json:"issuedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	Default    string     `json:"default"` // SENT BY DIRECT REQUESTS
+	UserAgents []string   `json:"userAgents"`
+}
+
+var (
+	bundledPool   = &UserAgentPool{Source: UserAgentsBundled, Default: bundledUserAgents[0], UserAgents: bundledUserAgents}
+	userAgentPool atomic.Pointer[UserAgentPool]
+)
+
+func init() {
+	userAgentPool.Store(bundledPool)
+}
+
+// THE POOL IN USE; A MANIFEST THAT HAS EXPIRED SINCE IT WAS LOADED FALLS BACK TO THE BUNDLED ONE
+func CurrentUserAgents() *UserAgentPool {
+	pool := userAgentPool.Load()
+	if pool.ExpiresAt != nil && time.Now().After(*pool.ExpiresAt) {
+		return bundledPool
+	}
+	return pool
+}
+
+// USER AGENT FOR DIRECT HTTP REQUESTS
+func DefaultUserAgent() string {
+	return CurrentUserAgents().Default
+}
+
+// A USER AGENT FOR THE rotate STRATEGY
+func rotatingUserAgent() string {
+	agents := CurrentUserAgents().UserAgents
+	return agents[rand.Intn(len(agents))]
+}
+
+// WHAT A SIGNED MANIFEST FILE HOLDS
+type signedManifest struct {
+	Manifest  string `json:"manifest"`  // BASE64 OF THE MANIFEST'S JSON
+	Signature string `json:"signature"` // BASE64 ED25519 SIGNATURE OF THOSE BYTES
+}
+
+// THE MANIFEST ITSELF, E.G. {"version": 42, "issuedAt": "...", "expiresAt": "...", "userAgents": [...],
+// "browsers": {"linux-amd64": {"version": "141.0.7390.54", "url": "...", "sha256": "...", "executable": "chrome-linux/chrome"}}}
+type userAgentManifest struct {
+	Version    int64                      `json:"version"` // ONLY EVER INCREASES
+	IssuedAt   time.Time                  `json:"issuedAt"`
+	ExpiresAt  time.Time                  `json:"expiresAt"`
+	Default    string                     `json:"default"` // EMPTY = THE FIRST OF userAgents
+	UserAgents []string                   `json:"userAgents"`
+	Browsers   map[string]manifestBrowser `json:"browsers"` // BY PLATFORM, E.G. linux-amd64
+}
+
+// A CHROMIUM BUILD TO INSTALL
+type manifestBrowser struct {
+	Version    string `json:"version"`
+	URL        string `json:"url"`        // ZIP ARCHIVE
+	SHA256     string `json:"sha256"`     // HEX DIGEST OF THE ARCHIVE
+	Executable string `json:"executable"` // PATH OF THE BROWSER INSIDE THE ARCHIVE
+}
+
+// THE BROWSER BUILD LAST INSTALLED FROM A MANIFEST
+type installedBrowser struct {
+	Version     string    `json:"version"`
+	Executable  string    `json:"executable"`
+	InstalledAt time.Time `json:"installedAt"`
+}
+
+// WHAT ONE CHECK DID
+type UserAgentUpdateReport struct {
+	Trigger    string               `json:"trigger"` // startup, schedule OR api
+	CheckedAt  time.Time            `json:"checkedAt"`
+	Status     string               `json:"status"` // updated, current OR failed
+	Message    string               `json:"message,omitempty"`
+	Version    int64                `json:"version,omitempty"`
+	UserAgents int                  `json:"userAgents,omitempty"`
+	Browser    *BrowserUpdateReport `json:"browser,omitempty"`
+}
+
+// WHAT THE CHECK DID WITH THE MANIFEST'S BROWSER FOR THIS PLATFORM
+type BrowserUpdateReport struct {
+	Platform string `json:"platform"`
+	Version  string `json:"version"`
+	Status   string `json:"status"` // updated, current OR failed
+	Message  string `json:"message,omitempty"`
+}
+
+// ONE CHECK AT A TIME, AND THE LAST ONE'S REPORT
+type userAgentUpdates struct {
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *UserAgentUpdateReport
+}
+
+// THE CRON SPEC OF SCHEDULED CHECKS ("" WHEN THEY ARE OFF OR NOT CONFIGURED)
+func (e *Engine) userAgentSchedule() string {
+	if strings.TrimSpace(e.cfg.UserAgentUpdates.URL) == "" {
+		return ""
+	}
+	switch spec := strings.TrimSpace(e.cfg.UserAgentUpdates.Schedule); spec {
+	case "":
+		return defaultUserAgentSchedule
+	case userAgentsOff:
+		return ""
+	default:
+		return spec
+	}
+}
+
+// THE LAST CHECK'S REPORT, NIL BEFORE THE FIRST
+func (e *Engine) LastUserAgentUpdate() *UserAgentUpdateReport {
+	e.userAgents.mu.Lock()
+	defer e.userAgents.mu.Unlock()
+	return e.userAgents.last
+}
+
+// USE THE MANIFEST SAVED BY AN EARLIER CHECK, IF IT STILL VERIFIES AND HASN'T EXPIRED
+func (e *Engine) loadUserAgentManifest() {
+	data, err := os.ReadFile(filepath.Join(e.cfg.DataPath, userAgentManifestFile))
+	if err != nil {
+		return
+	}
+	key, err := manifestPublicKey(e.cfg.UserAgentUpdates.PublicKey)
+	if err != nil {
+		log.Printf("USER AGENTS: IGNORING SAVED MANIFEST: %v", err)
+		return
+	}
+	manifest, err := verifyUserAgentManifest(data, key, time.Now())
+	if err != nil {
+		log.Printf("USER AGENTS: IGNORING SAVED MANIFEST: %v", err)
+		return
+	}
+	userAgentPool.Store(manifest.pool())
+	log.Printf("USER AGENTS: USING MANIFEST VERSION %d (%d USER AGENTS)", manifest.Version, len(manifest.UserAgents))
+}
+
+// FETCH THE MANIFEST AND, WHEN IT IS NEWER THAN THE ONE IN USE, SWITCH TO ITS USER AGENTS AND
+// INSTALL ITS BROWSER. A FAILED CHECK LEAVES EVERYTHING AS IT WAS AND IS REPORTED, NOT RETURNED
+func (e *Engine) UpdateUserAgents(ctx context.Context, trigger string) (*UserAgentUpdateReport, error) {
+	if strings.TrimSpace(e.cfg.UserAgentUpdates.URL) == "" {
+		return nil, ErrUserAgentUpdatesOff
+	}
+	if !e.userAgents.running.TryLock() {
+		return nil, ErrUserAgentUpdateRunning
+	}
+	defer e.userAgents.running.Unlock()
+
+	report := &UserAgentUpdateReport{Trigger: trigger, CheckedAt: time.Now()}
+	if err := e.checkUserAgentManifest(ctx, report); err != nil {
+		report.Status, report.Message = UserAgentsFailed, err.Error()
+		log.Printf("USER AGENTS: CHECK FAILED: %v", err)
+	}
+
+	e.userAgents.mu.Lock()
+	e.userAgents.last = report
+	e.userAgents.mu.Unlock()
+	e.events.Publish("useragents.checked", "", map[string]any{"report": report})
+	return report, nil
+}
+
+func (e *Engine) checkUserAgentManifest(ctx context.Context, report *UserAgentUpdateReport) error {
+	key, err := manifestPublicKey(e.cfg.UserAgentUpdates.PublicKey)
+	if err != nil {
+		return err
+	}
+	data, err := fetchUserAgentManifest(ctx, e.cfg.UserAgentUpdates.URL)
+	if err != nil {
+		return err
+	}
+	manifest, err := verifyUserAgentManifest(data, key, time.Now())
+	if err != nil {
+		return err
+	}
+	report.Version, report.UserAgents = manifest.Version, len(manifest.UserAgents)
+
+	// NEVER GO BACK TO AN OLDER MANIFEST, EVEN A VALIDLY SIGNED ONE
+	current := userAgentPool.Load()
+	if current.Source == UserAgentsManifest && manifest.Version <= current.Version {
+		report.Status = UserAgentsCurrent
+		report.Message = fmt.Sprintf("VERSION %d IS IN USE", current.Version)
+	} else {
+		if err := writeFileAtomic(filepath.Join(e.cfg.DataPath, userAgentManifestFile), data); err != nil {
+			return fmt.Errorf("FAILED TO SAVE MANIFEST: %w", err)
+		}
+		userAgentPool.Store(manifest.pool())
+		report.Status = UserAgentsUpdated
+		log.Printf("USER AGENTS: UPDATED TO MANIFEST VERSION %d (%d USER AGENTS)", manifest.Version, len(manifest.UserAgents))
+	}
+
+	if e.cfg.UserAgentUpdates.UpdateBrowsers {
+		platform := runtime.GOOS + "-" + runtime.GOARCH
+		if browser, ok := manifest.Browsers[platform]; ok {
+			report.Browser = &BrowserUpdateReport{Platform: platform, Version: browser.Version}
+			report.Browser.Status, err = e.installManifestBrowser(ctx, browser)
+			if err != nil {
+				report.Browser.Status, report.Browser.Message = UserAgentsFailed, err.Error()
+				log.Printf("USER AGENTS: FAILED TO INSTALL CHROMIUM %s: %v", browser.Version, err)
+			}
+		}
+	}
+	return nil
+}
+
+// THE POOL A VERIFIED MANIFEST DESCRIBES
+func (m *userAgentManifest) pool() *UserAgentPool {
+	issued, expires := m.IssuedAt, m.ExpiresAt
+	return &UserAgentPool{
+		Source:     UserAgentsManifest,
+		Version:    m.Version,
+		IssuedAt:   &issued,
+		ExpiresAt:  &expires,
+		Default:    m.Default,
+		UserAgents: m.UserAgents,
+	}
+}
+
+// DECODE THE CONFIGURED PUBLIC KEY
+func manifestPublicKey(encoded string) (ed25519.PublicKey, error) {
+	if strings.TrimSpace(encoded) == "" {
+		return nil, errors.New("NO PUBLIC KEY IS CONFIGURED TO VERIFY THE MANIFEST WITH")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("PUBLIC KEY MUST BE A BASE64 ED25519 KEY")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func fetchUserAgentManifest(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("INVALID MANIFEST URL: %w", err)
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	resp, err := NewHTTPClient(HTTPClientOptions{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO FETCH MANIFEST: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MANIFEST FETCH RETURNED HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO READ MANIFEST: %w", err)
+	}
+	if len(data) > maxManifestBytes {
+		return nil, fmt.Errorf("MANIFEST IS LARGER THAN %d BYTES", maxManifestBytes)
+	}
+	return data, nil
+}
+
+// CHECK A MANIFEST'S SIGNATURE, DATES AND CONTENTS
+func verifyUserAgentManifest(data []byte, key ed25519.PublicKey, now time.Time) (*userAgentManifest, error) {
+	var envelope signedManifest
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("MANIFEST IS NOT VALID JSON: %w", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Manifest)
+	if err != nil {
+		return nil, errors.New("MANIFEST IS NOT VALID BASE64")
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+	if err != nil || !ed25519.Verify(key, payload, signature) {
+		return nil, errManifestSignature
+	}
+
+	var manifest userAgentManifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return nil, fmt.Errorf("SIGNED MANIFEST IS NOT VALID JSON: %w", err)
+	}
+	switch {
+	case manifest.Version <= 0:
+		return nil, errors.New("MANIFEST HAS NO VERSION")
+	case manifest.IssuedAt.IsZero() || manifest.ExpiresAt.IsZero():
+		return nil, errors.New("MANIFEST MUST HAVE issuedAt AND expiresAt")
+	case manifest.IssuedAt.After(now.Add(manifestClockSkew)):
+		return nil, fmt.Errorf("MANIFEST IS ISSUED IN THE FUTURE (%s)", manifest.IssuedAt.Format(time.RFC3339))
+	case !now.Before(manifest.ExpiresAt):
+		return nil, fmt.Errorf("MANIFEST EXPIRED %s", manifest.ExpiresAt.Format(time.RFC3339))
+	case len(manifest.UserAgents) == 0:
+		return nil, errors.New("MANIFEST HAS NO USER AGENTS")
+	case len(manifest.UserAgents) > maxManifestUserAgents:
+		return nil, fmt.Errorf("MANIFEST HAS MORE THAN %d USER AGENTS", maxManifestUserAgents)
+	}
+	for _, agent := range manifest.UserAgents {
+		if err := checkUserAgent(agent); err != nil {
+			return nil, err
+		}
+	}
+	if manifest.Default == "" {
+		manifest.Default = manifest.UserAgents[0]
+	} else if err := checkUserAgent(manifest.Default); err != nil {
+		return nil, err
+	}
+	for platform, browser := range manifest.Browsers {
+		if !browserVersionPattern.MatchString(browser.Version) {
+			return nil, fmt.Errorf("BROWSER FOR %s HAS AN INVALID VERSION %q", platform, browser.Version)
+		}
+		if !strings.HasPrefix(browser.URL, "https://") {
+			return nil, fmt.Errorf("BROWSER FOR %s MUST BE DOWNLOADED OVER HTTPS", platform)
+		}
+		if digest, err := hex.DecodeString(browser.SHA256); err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("BROWSER FOR %s HAS AN INVALID SHA-256", platform)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(browser.Executable)) {
+			return nil, fmt.Errorf("BROWSER FOR %s HAS AN INVALID EXECUTABLE PATH", platform)
+		}
+	}
+	return &manifest, nil
+}
+
+// A USER AGENT MUST LOOK LIKE ONE AND BE SAFE TO PUT IN A HEADER
+func checkUserAgent(agent string) error {
+	if !strings.HasPrefix(agent, "Mozilla/5.0 ") || len(agent) > maxUserAgentLength {
+		return fmt.Errorf("MANIFEST HAS AN INVALID USER AGENT %q", agent)
+	}
+	if strings.IndexFunc(agent, unicode.IsControl) >= 0 {
+		return errors.New("MANIFEST HAS A USER AGENT WITH CONTROL CHARACTERS")
+	}
+	return nil
+}
+
+// THE BROWSER BUILD LAST INSTALLED FROM A MANIFEST, IF ITS EXECUTABLE IS STILL THERE
+func installedManifestBrowser(dataPath string) (installedBrowser, bool) {
+	var installed installedBrowser
+	data, err := os.ReadFile(filepath.Join(dataPath, manifestBrowsersDir, installedBrowserFile))
+	if err != nil || json.Unmarshal(data, &installed) != nil || installed.Executable == "" {
+		return installedBrowser{}, false
+	}
+	if _, err := os.Stat(installed.Executable); err != nil {
+		return installedBrowser{}, false
+	}
+	return installed, true
+}
+
+// DOWNLOAD, VERIFY AND UNPACK A BROWSER BUILD, THEN POINT installed.json AT IT. THE BUILD IN
+// USE IS KEPT (THE RUNNING BROWSER MAY STILL NEED ITS FILES); ANY OLDER ONE IS REMOVED
+func (e *Engine) installManifestBrowser(ctx context.Context, browser manifestBrowser) (string, error) {
+	root := filepath.Join(e.cfg.DataPath, manifestBrowsersDir)
+	previous, hasPrevious := installedManifestBrowser(e.cfg.DataPath)
+	if hasPrevious && previous.Version == browser.Version {
+		return UserAgentsCurrent, nil
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", err
+	}
+
+	archive, err := downloadBrowserArchive(ctx, root, browser)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archive)
+
+	unpacked, err := os.MkdirTemp(root, "unpack-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(unpacked) // GONE ALREADY ONCE IT IS MOVED INTO PLACE
+	if err := unzipArchive(archive, unpacked); err != nil {
+		return "", err
+	}
+	executable := filepath.Join(unpacked, filepath.FromSlash(browser.Executable))
+	if info, err := os.Stat(executable); err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("ARCHIVE HAS NO EXECUTABLE AT %s", browser.Executable)
+	}
+
+	dest := filepath.Join(root, "chromium-"+browser.Version)
+	os.RemoveAll(dest)
+	if err := os.Rename(unpacked, dest); err != nil {
+		return "", err
+	}
+	installed := installedBrowser{
+		Version:     browser.Version,
+		Executable:  filepath.Join(dest, filepath.FromSlash(browser.Executable)),
+		InstalledAt: time.Now(),
+	}
+	data, _ := json.MarshalIndent(installed, "", "  ")
+	if err := writeFileAtomic(filepath.Join(root, installedBrowserFile), data); err != nil {
+		return "", err
+	}
+
+	keep := map[string]bool{dest: true}
+	if hasPrevious {
+		if rel, err := filepath.Rel(root, previous.Executable); err == nil {
+			keep[filepath.Join(root, strings.Split(filepath.ToSlash(rel), "/")[0])] = true
+		}
+	}
+	builds, _ := filepath.Glob(filepath.Join(root, "chromium-*"))
+	for _, build := range builds {
+		if !keep[build] {
+			os.RemoveAll(build)
+		}
+	}
+	log.Printf("USER AGENTS: INSTALLED CHROMIUM %s; IT IS USED FROM THE NEXT START", browser.Version)
+	return UserAgentsUpdated, nil
+}
+
+// DOWNLOAD THE ARCHIVE INTO dir, CHECKING ITS SIZE AND DIGEST ON THE WAY
+func downloadBrowserArchive(ctx context.Context, dir string, browser manifestBrowser) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, browser.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	resp, err := NewHTTPClient(HTTPClientOptions{Timeout: 30 * time.Minute}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("FAILED TO DOWNLOAD BROWSER: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("BROWSER DOWNLOAD RETURNED HTTP %d", resp.StatusCode)
+	}
+
+	file, err := os.CreateTemp(dir, "download-*.zip")
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(resp.Body, maxBrowserArchiveBytes+1))
+	file.Close()
+	switch {
+	case err != nil:
+		err = fmt.Errorf("FAILED TO DOWNLOAD BROWSER: %w", err)
+	case written > maxBrowserArchiveBytes:
+		err = fmt.Errorf("BROWSER ARCHIVE IS LARGER THAN %d BYTES", maxBrowserArchiveBytes)
+	case !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), browser.SHA256):
+		err = errManifestBrowserChecksum
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// UNPACK A ZIP INTO dest, REFUSING ENTRIES THAT WOULD LAND OUTSIDE IT. SYMLINKS ARE SKIPPED
+func unzipArchive(path, dest string) error {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("BROWSER ARCHIVE IS NOT A ZIP: %w", err)
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if !filepath.IsLocal(filepath.FromSlash(file.Name)) {
+			return fmt.Errorf("ARCHIVE ENTRY %s IS OUTSIDE THE ARCHIVE", file.Name)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(file.Name))
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := unzipFile(file, target, mode.Perm()|0o600); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unzipFile(file *zip.File, target string, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// WRITE A FILE SO A CRASH NEVER LEAVES HALF OF IT BEHIND
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,185 @@
+package scraper
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/nickheyer/Crepes/internal/models"
+	"github.com/nickheyer/Crepes/internal/validation"
+)
+
+// VALIDATE A JOB'S PIPELINE JSON AGAINST THE STAGE/TASK MODELS AND THE REGISTERED TASK SCHEMAS
+func (e *Engine) ValidatePipeline(pipeline string) validation.Errors {
+	if strings.TrimSpace(pipeline) == "" {
+		return nil
+	}
+
+	var stages []models.Stage
+	if errs := validation.DecodeJSON(strings.NewReader(pipeline), &stages); errs != nil {
+		return errs.Prefix("pipeline")
+	}
+
+	// TASK IDS ACROSS THE WHOLE PIPELINE, FOR DUPLICATE AND REFERENCE CHECKS
+	taskIDs := make(map[string]string)
+	var errs validation.Errors
+	for i, stage := range stages {
+		for j, task := range stage.Tasks {
+			if task.ID == "" {
+				continue
+			}
+			path := fmt.Sprintf("pipeline[%d].tasks[%d].id", i, j)
+			if first, dup := taskIDs[task.ID]; dup {
+				errs = append(errs, validation.FieldError{
+					Path:    path,
+					Message: fmt.Sprintf("duplicate task id %q (also used at %s)", task.ID, first),
+					Rule:    "unique",
+				})
+				continue
+			}
+			taskIDs[task.ID] = path
+		}
+	}
+
+	for i, stage := range stages {
+		stagePath := fmt.Sprintf("pipeline[%d]", i)
+		errs = append(errs, validation.Struct(stage).Prefix(stagePath)...)
+
+		if stage.Parallelism.Mode == "worker-per-item" {
+			if len(stage.Tasks) == 0 || len(stage.Tasks[0].InputRefs) == 0 {
+				errs = append(errs, validation.FieldError{
+					Path:     stagePath + ".tasks[0].inputRefs",
+					Message:  "worker-per-item stages need a first task that references an array-producing task",
+					Expected: "array",
+					Rule:     "required",
+				})
+			}
+		}
+		if stage.Parallelism.Mode == "for-each" {
+			source, hasSource := stage.Config["items"].(string)
+			hasRefs := slices.ContainsFunc(stage.Tasks, func(t models.Task) bool { return len(t.InputRefs) > 0 })
+			switch {
+			case len(stage.Tasks) == 0:
+				errs = append(errs, validation.FieldError{
+					Path:    stagePath + ".tasks",
+					Message: "for-each stages need at least one task",
+					Rule:    "required",
+				})
+			case hasSource && source != "":
+				if _, ok := taskIDs[source]; !ok {
+					errs = append(errs, validation.FieldError{
+						Path:     stagePath + ".config.items",
+						Message:  fmt.Sprintf("references unknown task id %q", source),
+						Expected: "task id",
+						Rule:     "ref",
+					})
+				}
+			case !hasRefs:
+				errs = append(errs, validation.FieldError{
+					Path:     stagePath + ".config.items",
+					Message:  "for-each stages need config.items or a task that references an array-producing task",
+					Expected: "task id",
+					Rule:     "required",
+				})
+			}
+		}
+		if _, err := ParseStopCondition(stage.Config["stopWhen"]); err != nil {
+			errs = append(errs, validation.FieldError{
+				Path:     stagePath + ".config.stopWhen",
+				Message:  err.Error(),
+				Expected: "object with olderThan (lastRun or a date) and dateField, and/or knownItems and keyField",
+				Rule:     "type",
+			})
+		}
+
+		for j, task := range stage.Tasks {
+			errs = append(errs, e.validateTask(task, fmt.Sprintf("%s.tasks[%d]", stagePath, j), taskIDs)...)
+		}
+	}
+	return errs
+}
+
+// VALIDATE ONE TASK'S TYPE, INPUT REFERENCES AND CONFIG
+func (e *Engine) validateTask(task models.Task, path string, taskIDs map[string]string) validation.Errors {
+	var errs validation.Errors
+
+	for k, ref := range task.InputRefs {
+		if _, ok := taskIDs[ref]; !ok {
+			errs = append(errs, validation.FieldError{
+				Path:     fmt.Sprintf("%s.inputRefs[%d]", path, k),
+				Message:  fmt.Sprintf("references unknown task id %q", ref),
+				Expected: "task id",
+				Rule:     "ref",
+			})
+		}
+	}
+
+	if task.Type == "" {
+		return errs // ALREADY REPORTED BY THE STRUCT RULES
+	}
+	impl, err := e.taskRegistry.GetTask(task.Type)
+	if err != nil {
+		return append(errs, validation.FieldError{
+			Path:     path + ".type",
+			Message:  fmt.Sprintf("unknown task type %q", task.Type),
+			Expected: "one of: " + strings.Join(e.sortedTaskTypes(), ", "),
+			Rule:     "oneof",
+		})
+	}
+
+	// CHECK EACH SCHEMA INPUT; REQUIRED INPUTS MAY ALSO ARRIVE THROUGH inputRefs AT RUNTIME
+	schema := impl.GetInputSchema()
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schemaErrs := 0
+	for _, name := range names {
+		inputType := schema[name]
+		expected := strings.TrimSuffix(inputType, "?")
+		value, ok := task.Config[name]
+		switch {
+		case !ok:
+			if !strings.HasSuffix(inputType, "?") && len(task.InputRefs) == 0 {
+				errs = append(errs, validation.FieldError{
+					Path:     path + ".config." + name,
+					Message:  "is required",
+					Expected: expected,
+					Rule:     "required",
+				})
+				schemaErrs++
+			}
+		case expected != "any" && value != nil:
+			if got := validation.ValueTypeName(value); got != expected {
+				errs = append(errs, validation.FieldError{
+					Path:     path + ".config." + name,
+					Message:  fmt.Sprintf("expected %s but got %s", expected, got),
+					Expected: expected,
+					Rule:     "type",
+				})
+				schemaErrs++
+			}
+		}
+	}
+
+	// TASK-SPECIFIC CHECKS (THE ENGINE RUNS THE SAME ONES BEFORE EXECUTION)
+	if schemaErrs == 0 && len(task.InputRefs) == 0 {
+		if err := impl.ValidateConfig(task.Config); err != nil {
+			errs = append(errs, validation.FieldError{
+				Path:    path + ".config",
+				Message: err.Error(),
+				Rule:    "config",
+			})
+		}
+	}
+	return errs
+}
+
+func (e *Engine) sortedTaskTypes() []string {
+	types := e.taskRegistry.ListTaskTypes()
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,156 @@
+package scraper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// -- WARC WRITER --
+
+// WARC WRITER APPENDS WARC/1.1 RECORDS, ONE GZIP MEMBER PER RECORD FOR .warc.gz
+type WARCWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	gzip bool
+	path string
+}
+
+// CREATE A WARC FILE; A .gz SUFFIX ENABLES PER-RECORD COMPRESSION
+func NewWARCWriter(path, software string) (*WARCWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO CREATE WARC FILE: %v", err)
+	}
+	w := &WARCWriter{file: file, gzip: strings.HasSuffix(path, ".gz"), path: path}
+
+	info := fmt.Sprintf("software: %s\r\nformat: WARC File Format 1.1\r\nconformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n", software)
+	if err := w.writeRecord("warcinfo", "", "application/warc-fields", nil, []byte(info)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// RECORD A REQUEST/RESPONSE PAIR; THE RESPONSE BODY IS PASSED SEPARATELY SINCE IT HAS BEEN READ
+func (w *WARCWriter) WriteExchange(req *http.Request, resp *http.Response, body []byte, truncated bool) error {
+	targetURI := req.URL.String()
+
+	// RAW HTTP REQUEST
+	rawReq, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return fmt.Errorf("FAILED TO SERIALIZE REQUEST: %v", err)
+	}
+
+	// RAW HTTP RESPONSE (HEADERS AS RECEIVED, BODY DECODED BY THE CLIENT)
+	var rawResp bytes.Buffer
+	fmt.Fprintf(&rawResp, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	header := resp.Header.Clone()
+	header.Del("Content-Encoding") // BODY IS STORED DECODED
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", fmt.Sprint(len(body)))
+	header.Write(&rawResp)
+	rawResp.WriteString("\r\n")
+	rawResp.Write(body)
+
+	responseID := newWARCRecordID()
+	extra := map[string]string{
+		"WARC-Record-ID":      responseID,
+		"WARC-Payload-Digest": warcDigest(body),
+	}
+	if truncated {
+		extra["WARC-Truncated"] = "length" // BODY CUT AT THE SIZE LIMIT
+	}
+	if err := w.writeRecord("response", targetURI, "application/http;msgtype=response", extra, rawResp.Bytes()); err != nil {
+		return err
+	}
+
+	return w.writeRecord("request", targetURI, "application/http;msgtype=request", map[string]string{
+		"WARC-Concurrent-To": responseID,
+	}, rawReq)
+}
+
+// RECORD A DERIVED RESOURCE (SCREENSHOT, PDF) AS A WARC "resource" RECORD
+func (w *WARCWriter) WriteResource(targetURI, contentType string, data []byte) error {
+	return w.writeRecord("resource", targetURI, contentType, map[string]string{
+		"WARC-Payload-Digest": warcDigest(data),
+	}, data)
+}
+
+// PATH OF THE WARC FILE
+func (w *WARCWriter) Path() string {
+	return w.path
+}
+
+// CLOSE THE WARC FILE
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+func (w *WARCWriter) writeRecord(recordType, targetURI, contentType string, extra map[string]string, block []byte) error {
+	var record bytes.Buffer
+	record.WriteString("WARC/1.1\r\n")
+	fmt.Fprintf(&record, "WARC-Type: %s\r\n", recordType)
+
+	recordID := extra["WARC-Record-ID"]
+	if recordID == "" {
+		recordID = newWARCRecordID()
+	}
+	fmt.Fprintf(&record, "WARC-Record-ID: %s\r\n", recordID)
+	fmt.Fprintf(&record, "WARC-Date: %s\r\n", time.Now().UTC().Format(time.RFC3339))
+	if targetURI != "" {
+		fmt.Fprintf(&record, "WARC-Target-URI: %s\r\n", targetURI)
+	}
+	for key, value := range extra {
+		if key != "WARC-Record-ID" {
+			fmt.Fprintf(&record, "%s: %s\r\n", key, value)
+		}
+	}
+	fmt.Fprintf(&record, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(&record, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&record, "Content-Length: %d\r\n", len(block))
+	record.WriteString("\r\n")
+	record.Write(block)
+	record.WriteString("\r\n\r\n")
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var out io.Writer = w.file
+	var gz *gzip.Writer
+	if w.gzip {
+		gz = gzip.NewWriter(w.file)
+		out = gz
+	}
+	if _, err := out.Write(record.Bytes()); err != nil {
+		return fmt.Errorf("FAILED TO WRITE WARC RECORD: %v", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("FAILED TO WRITE WARC RECORD: %v", err)
+		}
+	}
+	return nil
+}
+
+func newWARCRecordID() string {
+	return "<urn:uuid:" + uuid.NewString() + ">"
+}
+
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,241 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+)
+
+// -- WAYBACK MACHINE --
+
+const (
+	waybackAvailableAPI = "https://archive.org/wayback/available"
+	waybackSaveAPI      = "https://web.archive.org/save/"
+
+	// SAVEPAGENOW ALLOWS ROUGHLY ONE CAPTURE EVERY FEW SECONDS PER CLIENT
+	waybackSubmitInterval = 5 * time.Second
+	// DON'T RESUBMIT THE SAME URL WITHIN THIS WINDOW
+	waybackResubmitWindow = time.Hour
+)
+
+// RETURNED WHEN THE WAYBACK MACHINE HAS NO CAPTURE OF A URL
+var ErrNoSnapshot = errors.New("NO WAYBACK SNAPSHOT AVAILABLE")
+
+// A WAYBACK MACHINE CAPTURE
+type WaybackSnapshot struct {
+	URL       string `json:"url"`       // PLAYBACK URL
+	Timestamp string `json:"timestamp"` // YYYYMMDDhhmmss
+	Status    string `json:"status"`    // ORIGINAL HTTP STATUS
+}
+
+// ORIGINAL BYTES WITHOUT THE WAYBACK TOOLBAR OR LINK REWRITING (FOR DOWNLOADS)
+func (s WaybackSnapshot) RawURL() string {
+	return s.withModifier("id_")
+}
+
+// RENDERABLE PAGE WITHOUT THE WAYBACK TOOLBAR, LINKS STILL POINT INTO THE ARCHIVE (FOR BROWSING)
+func (s WaybackSnapshot) FrameURL() string {
+	return s.withModifier("if_")
+}
+
+func (s WaybackSnapshot) withModifier(modifier string) string {
+	if s.Timestamp == "" {
+		return s.URL
+	}
+	return strings.Replace(s.URL, "/"+s.Timestamp+"/", "/"+s.Timestamp+modifier+"/", 1)
+}
+
+// LOOK UP THE CLOSEST (LATEST BY DEFAULT) SNAPSHOT OF A URL
+func LatestSnapshot(ctx context.Context, client *http.Client, rawURL string) (WaybackSnapshot, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", waybackAvailableAPI+"?url="+url.QueryEscape(rawURL), nil)
+	if err != nil {
+		return WaybackSnapshot{}, err
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	resp, err := client.Do(req)
+	if err != nil {
+		return WaybackSnapshot{}, fmt.Errorf("WAYBACK LOOKUP FAILED: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return WaybackSnapshot{}, fmt.Errorf("WAYBACK LOOKUP FAILED: STATUS %d", resp.StatusCode)
+	}
+
+	var result struct {
+		ArchivedSnapshots struct {
+			Closest struct {
+				Available bool   `json:"available"`
+				URL       string `json:"url"`
+				Timestamp string `json:"timestamp"`
+				Status    string `json:"status"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return WaybackSnapshot{}, fmt.Errorf("WAYBACK LOOKUP FAILED: %v", err)
+	}
+
+	closest := result.ArchivedSnapshots.Closest
+	if !closest.Available || closest.URL == "" {
+		return WaybackSnapshot{}, ErrNoSnapshot
+	}
+	return WaybackSnapshot{
+		URL:       strings.Replace(closest.URL, "http://", "https://", 1),
+		Timestamp: closest.Timestamp,
+		Status:    closest.Status,
+	}, nil
+}
+
+// WHETHER A FAILED DOWNLOAD SHOULD BE RETRIED FROM THE WAYBACK MACHINE
+func isWaybackFallbackError(err error) bool {
+	var statusErr *downloadStatusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone)
+}
+
+// -- SAVEPAGENOW SUBMISSION --
+
+type waybackSubmission struct {
+	jobID string
+	url   string
+}
+
+// QUEUES URLS FOR SAVEPAGENOW, SPACED TO RESPECT ITS RATE LIMIT
+type WaybackSubmitter struct {
+	cfg    *config.Config
+	events *EventBus
+	client *http.Client
+	queue  chan waybackSubmission
+
+	mu        sync.Mutex
+	submitted map[string]time.Time
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// CREATE A SUBMITTER AND START ITS QUEUE
+func NewWaybackSubmitter(cfg *config.Config, events *EventBus) *WaybackSubmitter {
+	s := &WaybackSubmitter{
+		cfg:       cfg,
+		events:    events,
+		client:    NewHTTPClient(HTTPClientOptions{Timeout: 2 * time.Minute}),
+		queue:     make(chan waybackSubmission, 1000),
+		submitted: make(map[string]time.Time),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// QUEUE A URL; DUPLICATES WITHIN THE RESUBMIT WINDOW AND OVERFLOW ARE DROPPED
+func (s *WaybackSubmitter) Submit(jobID, rawURL string) bool {
+	s.mu.Lock()
+	if last, ok := s.submitted[rawURL]; ok && time.Since(last) < waybackResubmitWindow {
+		s.mu.Unlock()
+		return false
+	}
+	s.submitted[rawURL] = time.Now()
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- waybackSubmission{jobID: jobID, url: rawURL}:
+		s.events.Publish("wayback.queued", jobID, map[string]any{"url": rawURL})
+		return true
+	default:
+		log.Printf("WAYBACK SUBMIT QUEUE FULL, DROPPING %s", rawURL)
+		return false
+	}
+}
+
+// STOP THE QUEUE; PENDING SUBMISSIONS ARE DISCARDED
+func (s *WaybackSubmitter) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+func (s *WaybackSubmitter) run() {
+	ticker := time.NewTicker(waybackSubmitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case item := <-s.queue:
+			snapshot, err := s.save(item.url)
+			if err != nil {
+				log.Printf("WAYBACK SUBMIT FAILED FOR %s: %v", item.url, err)
+				s.events.Publish("wayback.failed", item.jobID, map[string]any{"url": item.url, "error": err.Error()})
+			} else {
+				log.Printf("WAYBACK SUBMITTED %s", item.url)
+				s.events.Publish("wayback.submitted", item.jobID, map[string]any{"url": item.url, "snapshot": snapshot})
+			}
+
+			// SPACE OUT REQUESTS
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// REQUEST A CAPTURE; AUTHENTICATED WITH ARCHIVE.ORG S3 KEYS WHEN CONFIGURED
+func (s *WaybackSubmitter) save(rawURL string) (string, error) {
+	var req *http.Request
+	var err error
+	if s.cfg.WaybackAccessKey != "" && s.cfg.WaybackSecretKey != "" {
+		form := url.Values{"url": {rawURL}}
+		req, err = http.NewRequest("POST", waybackSaveAPI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("LOW %s:%s", s.cfg.WaybackAccessKey, s.cfg.WaybackSecretKey))
+	} else {
+		req, err = http.NewRequest("GET", waybackSaveAPI+rawURL, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("SAVEPAGENOW RETURNED STATUS %d", resp.StatusCode)
+	}
+	// ANONYMOUS CAPTURES REDIRECT TO THE NEW SNAPSHOT OR REPORT IT IN Content-Location
+	if location := resp.Header.Get("Content-Location"); location != "" {
+		return "https://web.archive.org" + location, nil
+	}
+	return resp.Request.URL.String(), nil
+}
+
+// WHETHER A WAYBACK OPTION IS ENABLED FOR THIS TASK (TASK CONFIG OVERRIDES THE JOB RULE)
+func waybackOption(ctx *TaskContext, config map[string]any, key string) bool {
+	if v, ok := config[key].(bool); ok {
+		return v
+	}
+	if v, ok := ctx.Engine.jobRule(ctx.JobID, key); ok {
+		enabled, _ := v.(bool)
+		return enabled
+	}
+	return false
+}
@@ -1,17 +1,35 @@
 package scraper
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 // -- WORKER POOL STUFF --
 
 // WORKER REPRESENTS A CONCURRENT TASK EXECUTOR
 type Worker struct {
-	tasks chan func()
-	wg    *sync.WaitGroup
+	tasks     chan func()
+	wg        *sync.WaitGroup
+	queued    atomic.Int64
+	active    atomic.Int64
+	completed atomic.Int64
+	closeOnce sync.Once
+}
+
+// WORKER STATS FOR QUEUE DEPTH METRICS
+type WorkerStats struct {
+	Queued    int64 `json:"queued"`
+	Active    int64 `json:"active"`
+	Completed int64 `json:"completed"`
 }
 
 // NEWWORKER CREATES A NEW WORKER POOL
 func NewWorker(maxConcurrent int) *Worker {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+
 	w := &Worker{
 		tasks: make(chan func(), 100), // BUFFER SIZE FOR QUEUED TASKS
 		wg:    &sync.WaitGroup{},
@@ -21,7 +39,11 @@ func NewWorker(maxConcurrent int) *Worker {
 	for range maxConcurrent {
 		go func() {
 			for task := range w.tasks {
+				w.queued.Add(-1)
+				w.active.Add(1)
 				task()
+				w.active.Add(-1)
+				w.completed.Add(1)
 				w.wg.Done()
 			}
 		}()
@@ -30,9 +52,10 @@ func NewWorker(maxConcurrent int) *Worker {
 	return w
 }
 
-// SUBMIT ADDS A TASK TO THE WORKER POOL
+// SUBMIT ADDS A TASK TO THE WORKER POOL, BLOCKING WHEN THE QUEUE IS FULL
 func (w *Worker) Submit(task func()) {
 	w.wg.Add(1)
+	w.queued.Add(1)
 	w.tasks <- task
 }
 
@@ -41,7 +64,18 @@ func (w *Worker) Wait() {
 	w.wg.Wait()
 }
 
+// STATS RETURNS CURRENT QUEUE DEPTH AND THROUGHPUT COUNTERS
+func (w *Worker) Stats() WorkerStats {
+	return WorkerStats{
+		Queued:    w.queued.Load(),
+		Active:    w.active.Load(),
+		Completed: w.completed.Load(),
+	}
+}
+
 // CLOSE SHUTS DOWN THE WORKER POOL
 func (w *Worker) Close() {
-	close(w.tasks)
+	w.closeOnce.Do(func() {
+		close(w.tasks)
+	})
 }
@@ -0,0 +1,492 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+)
+
+// -- S3 --
+//
+// AN S3-COMPATIBLE BUCKET SPOKEN TO DIRECTLY WITH SIGNATURE V4. BODIES ARE STREAMED UNSIGNED
+// (UNSIGNED-PAYLOAD), SO A FILE IS NEVER READ TWICE OR HELD IN MEMORY; FILES OVER
+// multipartThreshold GO UP IN PARTS. A BODY OF UNKNOWN LENGTH (A DOWNLOAD WITHOUT A
+// Content-Length) IS READ A PART AT A TIME, SO AT MOST minPartSize OF IT IS EVER BUFFERED.
+
+const (
+	defaultS3Region    = "us-east-1"
+	unsignedPayload    = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash   = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	multipartThreshold = 64 << 20
+	minPartSize        = 16 << 20
+	maxParts           = 10000
+	maxURLExpiry       = 7 * 24 * time.Hour // THE LONGEST A V4 SIGNATURE CAN BE VALID
+)
+
+// A BUCKET, OR A PREFIX INSIDE ONE
+type S3 struct {
+	client    *http.Client
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	token     string
+	pathStyle bool
+}
+
+// A BUCKET FROM CONFIG; CREDENTIALS MISSING THERE COME FROM CREPES_S3_* OR AWS_* VARIABLES
+func NewS3(cfg config.S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 STORAGE NEEDS A bucket")
+	}
+	s := &S3{
+		client:    &http.Client{},
+		region:    cfg.Region,
+		bucket:    cfg.Bucket,
+		prefix:    strings.TrimPrefix(cfg.Prefix, "/"),
+		accessKey: cmp.Or(cfg.AccessKeyID, os.Getenv("CREPES_S3_ACCESS_KEY_ID"), os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey: cmp.Or(cfg.SecretAccessKey, os.Getenv("CREPES_S3_SECRET_ACCESS_KEY"), os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		token:     cmp.Or(cfg.SessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+		pathStyle: cfg.PathStyle,
+	}
+	if s.region == "" {
+		s.region = cmp.Or(os.Getenv("AWS_REGION"), defaultS3Region)
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("S3 STORAGE NEEDS accessKeyId AND secretAccessKey")
+	}
+	if s.prefix != "" && !strings.HasSuffix(s.prefix, "/") {
+		s.prefix += "/"
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint MUST BE AN http(s) URL, NOT %q", endpoint)
+	}
+	s.endpoint = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/")}
+	// DOTTED BUCKET NAMES BREAK VIRTUAL-HOST TLS
+	if strings.Contains(s.bucket, ".") && u.Scheme == "https" {
+		s.pathStyle = true
+	}
+	return s, nil
+}
+
+// THE SAME BUCKET UNDER A FURTHER PREFIX
+func (s *S3) Sub(prefix string) *S3 {
+	sub := *s
+	sub.prefix = s.prefix + prefix
+	return &sub
+}
+
+func (s *S3) Name() string {
+	return BackendS3
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if size < 0 {
+		// THE FIRST PART SAYS WHETHER ONE REQUEST IS ENOUGH
+		first := make([]byte, minPartSize)
+		n, err := io.ReadFull(body, first)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return s.putObject(ctx, key, bytes.NewReader(first[:n]), int64(n), contentType)
+		}
+		if err != nil {
+			return err
+		}
+		return s.putMultipart(ctx, key, io.MultiReader(bytes.NewReader(first), body), -1, contentType)
+	}
+	if size > multipartThreshold {
+		return s.putMultipart(ctx, key, body, size, contentType)
+	}
+	return s.putObject(ctx, key, body, size, contentType)
+}
+
+func (s *S3) putObject(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, header, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key, byteRange string) (io.ReadCloser, Object, error) {
+	header := http.Header{}
+	if byteRange != "" {
+		header.Set("Range", byteRange)
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, header, nil, 0)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	object := objectFrom(Key(key), resp)
+	return resp.Body, object, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	return objectFrom(Key(key), resp), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// A PRESIGNED GET (QUERY-STRING SIGNATURE) THE CLIENT CAN FOLLOW WITHOUT CREDENTIALS
+func (s *S3) SignedURL(key string, expiry time.Duration, params url.Values) (string, error) {
+	return s.signedURL(key, expiry, params, time.Now().UTC()), nil
+}
+
+func (s *S3) signedURL(key string, expiry time.Duration, params url.Values, now time.Time) string {
+	if expiry <= 0 {
+		expiry = defaultURLExpiry
+	}
+	expiry = min(expiry, maxURLExpiry)
+	u := s.objectURL(key)
+	scope := s.scope(now)
+	query := url.Values{}
+	for name, values := range params {
+		query[name] = values
+	}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.token != "" {
+		query.Set("X-Amz-Security-Token", s.token)
+	}
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(now, scope, canonical))
+	u.RawQuery = canonicalQuery(query)
+	return u.String()
+}
+
+// -- MULTIPART --
+
+type completeUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []completePart `xml:"Part"`
+}
+
+type completePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// UPLOAD IN PARTS READ ONE AFTER ANOTHER FROM body; A FAILURE ABORTS THE UPLOAD SO NO PARTS LINGER.
+// WITH size UNKNOWN (NEGATIVE) EACH PART IS BUFFERED TO LEARN ITS LENGTH, AND body ENDS THE UPLOAD
+func (s *S3) putMultipart(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	partSize := max(int64(minPartSize), (size+maxParts-1)/maxParts)
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, 0)
+	if err != nil {
+		return err
+	}
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil || created.UploadID == "" {
+		return fmt.Errorf("S3 DIDN'T START A MULTIPART UPLOAD FOR %s: %v", key, err)
+	}
+	abort := func(cause error) error {
+		// ITS OWN CONTEXT, SINCE A CANCELLED ONE IS OFTEN WHY IT FAILED
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if resp, err := s.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {created.UploadID}}, nil, nil, 0); err == nil {
+			resp.Body.Close()
+		}
+		return cause
+	}
+
+	var done completeUpload
+	var buffer []byte
+	if size < 0 {
+		buffer = make([]byte, partSize)
+	}
+	for number, offset := 1, int64(0); size < 0 || offset < size; number++ {
+		if number > maxParts {
+			return abort(fmt.Errorf("%s IS TOO LARGE FOR %d PARTS OF %d BYTES", key, maxParts, partSize))
+		}
+		length := min(partSize, size-offset)
+		part := io.LimitReader(body, length)
+		last := false
+		if size < 0 {
+			n, err := io.ReadFull(body, buffer)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return abort(fmt.Errorf("READING PART %d OF %s: %w", number, key, err))
+			}
+			// NOTHING LEFT AFTER A FULL PART: THE UPLOAD IS ALREADY WHOLE
+			if n == 0 && number > 1 {
+				break
+			}
+			length, part, last = int64(n), bytes.NewReader(buffer[:n]), err != nil
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {created.UploadID}}
+		resp, err := s.do(ctx, http.MethodPut, key, query, nil, part, length)
+		if err != nil {
+			return abort(fmt.Errorf("PART %d OF %s: %w", number, key, err))
+		}
+		resp.Body.Close()
+		done.Parts = append(done.Parts, completePart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		offset += length
+		if last {
+			break
+		}
+	}
+
+	manifest, err := xml.Marshal(done)
+	if err != nil {
+		return abort(err)
+	}
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {created.UploadID}}, nil, bytes.NewReader(manifest), int64(len(manifest)))
+	if err != nil {
+		return abort(err)
+	}
+	defer resp.Body.Close()
+	// A COMPLETION CAN FAIL AFTER A 200 WITH AN ERROR DOCUMENT IN THE BODY
+	payload, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if bytes.Contains(payload, []byte("<Error>")) {
+		return abort(s3Error(http.MethodPost, key, http.StatusInternalServerError, payload))
+	}
+	return nil
+}
+
+// -- REQUESTS --
+
+func (s *S3) objectURL(key string) *url.URL {
+	full := s.prefix + Key(key)
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = u.Path + "/" + s.bucket + "/" + full
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = u.Path + "/" + full
+	}
+	// SENT EXACTLY AS SIGNED
+	u.RawPath = escapePath(u.Path)
+	return &u
+}
+
+// SEND A SIGNED REQUEST; ANY STATUS OUTSIDE 2XX IS AN ERROR (404 IS ErrNotFound)
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := s.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+		if size == 0 {
+			// A ZERO LENGTH WITH A BODY WOULD GO OUT CHUNKED, WHICH S3 REFUSES
+			req.Body = http.NoBody
+		}
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s: %w", method, key, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	payload, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return nil, s3Error(method, key, resp.StatusCode, payload)
+}
+
+// SIGN A REQUEST WITH AN Authorization HEADER (SIGNATURE V4)
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := s.scope(now)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, s.signature(now, scope, canonical)))
+}
+
+func (s *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// THE SIGNING KEY IS DERIVED FROM THE SCOPE'S DATE, REGION, SERVICE AND aws4_request IN TURN
+func (s *S3) signature(now time.Time, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// URI-ENCODE EVERYTHING BUT UNRESERVED CHARACTERS (AND / IN PATHS), AS V4 EXPECTS
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func escapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return awsEscape(p, true)
+}
+
+// QUERY PARAMETERS SORTED AND ENCODED THE WAY THEY ARE SIGNED (AND SENT)
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, awsEscape(name, false)+"="+awsEscape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func objectFrom(key string, resp *http.Response) Object {
+	object := Object{
+		Key:         key,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+		Length:      resp.ContentLength,
+		Size:        resp.ContentLength,
+		Range:       resp.Header.Get("Content-Range"),
+	}
+	object.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	// bytes 0-99/1234: THE TOTAL IS AFTER THE SLASH
+	if slash := strings.LastIndex(object.Range, "/"); slash >= 0 {
+		if total, err := strconv.ParseInt(object.Range[slash+1:], 10, 64); err == nil {
+			object.Size = total
+		}
+	}
+	return object
+}
+
+func s3Error(method, key string, status int, payload []byte) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.Unmarshal(payload, &body)
+	if status == http.StatusNotFound || body.Code == "NoSuchKey" {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if status == http.StatusRequestedRangeNotSatisfiable {
+		return fmt.Errorf("%w: %s", ErrInvalidRange, key)
+	}
+	if body.Code == "" {
+		body.Code = http.StatusText(status)
+	}
+	return fmt.Errorf("S3 %s %s FAILED (%d %s): %s", method, key, status, body.Code, body.Message)
+}
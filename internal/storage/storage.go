@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nickheyer/Crepes/internal/config"
+	"github.com/nickheyer/Crepes/internal/utils"
+)
+
+// -- ASSET STORAGE --
+//
+// ASSET FILES AND THUMBNAILS BELONG TO A BACKEND: local KEEPS THEM UNDER storagePath AND
+// thumbnailsPath, s3 IN A BUCKET. DOWNLOADS FOR A BUCKET STREAM STRAIGHT INTO IT; WHAT HAS TO BE
+// ON DISK FIRST (RESUMABLE DOWNLOADS, IN-PLACE ENCRYPTION, RENDERED THUMBNAILS) IS WRITTEN LOCALLY,
+// THEN STREAMED UP AND THE LOCAL COPY REMOVED. KEYS ARE THE SLASH-SEPARATED PATHS RELATIVE TO
+// THOSE DIRECTORIES.
+
+// BACKEND NAMES (config.StorageConfig.Backend, THE storage SETTING AND models.Asset.Storage)
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// HOW REMOTE FILES REACH A CLIENT
+const (
+	ServeRedirect = "redirect" // TO A SHORT-LIVED SIGNED URL
+	ServeProxy    = "proxy"    // STREAMED THROUGH THE SERVER
+)
+
+const defaultURLExpiry = 15 * time.Minute
+
+var (
+	ErrNotFound     = errors.New("OBJECT NOT FOUND")
+	ErrInvalidRange = errors.New("RANGE NOT SATISFIABLE")
+	ErrUnsupported  = errors.New("NOT SUPPORTED BY THIS STORAGE BACKEND")
+)
+
+// WHAT A BACKEND KNOWS ABOUT A STORED FILE
+type Object struct {
+	Key          string
+	Size         int64 // OF THE WHOLE OBJECT
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Range        string // Content-Range WHEN ONLY PART OF IT WAS READ
+	Length       int64  // BYTES IN THE BODY (Size UNLESS A RANGE WAS READ)
+}
+
+// A PLACE FILES ARE KEPT BY KEY
+type Storage interface {
+	Name() string
+
+	// STORE size BYTES FROM body UNDER key (ALL OF body WHEN size IS NEGATIVE), REPLACING ANY FILE
+	// ALREADY THERE
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+
+	// READ A FILE, OR THE PART OF IT A Range HEADER VALUE ASKS FOR (EMPTY FOR ALL OF IT)
+	Get(ctx context.Context, key, byteRange string) (io.ReadCloser, Object, error)
+
+	Stat(ctx context.Context, key string) (Object, error)
+
+	// REMOVE A FILE; ONE THAT ISN'T THERE IS NOT AN ERROR
+	Delete(ctx context.Context, key string) error
+
+	// A URL THE FILE CAN BE FETCHED FROM DIRECTLY FOR expiry, WITH response-* OVERRIDES
+	// (E.G. response-content-disposition) IN params
+	SignedURL(key string, expiry time.Duration, params url.Values) (string, error)
+}
+
+// THE KEY OF A FILE STORED AT name RELATIVE TO ITS DIRECTORY
+func Key(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+}
+
+// -- LOCAL --
+
+// FILES IN A DIRECTORY; KEYS CAN'T ESCAPE IT
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{Root: root}
+}
+
+func (l *Local) Name() string {
+	return BackendLocal
+}
+
+func (l *Local) path(key string) (string, error) {
+	return utils.ConfinePath(l.Root, filepath.FromSlash(Key(key)))
+}
+
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	full, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	// WRITTEN BESIDE IT AND RENAMED, SO A READER NEVER SEES HALF A FILE
+	tmp, err := os.CreateTemp(filepath.Dir(full), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("WROTE %d OF %d BYTES TO %s", written, size, key)
+	}
+	return os.Rename(tmp.Name(), full)
+}
+
+// RANGES AREN'T READ HERE; LOCAL FILES ARE SERVED WITH http.ServeContent INSTEAD
+func (l *Local) Get(ctx context.Context, key, byteRange string) (io.ReadCloser, Object, error) {
+	object, err := l.Stat(ctx, key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	full, _ := l.path(key)
+	file, err := os.Open(full)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	return file, object, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (Object, error) {
+	full, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(full)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return Object{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{
+		Key:          Key(key),
+		Size:         info.Size(),
+		Length:       info.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(full)),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	full, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(full); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) SignedURL(key string, expiry time.Duration, params url.Values) (string, error) {
+	return "", ErrUnsupported
+}
+
+// -- STORES --
+
+// WHERE FINISHED ASSETS AND THUMBNAILS GO
+type Stores struct {
+	Backend    string
+	Assets     Storage
+	Thumbnails Storage
+	Serve      string        // ServeRedirect OR ServeProxy, FOR REMOTE FILES
+	URLExpiry  time.Duration // HOW LONG A SIGNED URL LASTS
+	KeepLocal  bool          // LEAVE THE LOCAL COPY AFTER UPLOADING
+}
+
+// THE BACKEND THE CONFIG ASKS FOR. ONLY CONFIGURATION IS CHECKED; NOTHING IS CONTACTED
+func Open(cfg *config.Config) (*Stores, error) {
+	stores := &Stores{
+		Backend:    BackendLocal,
+		Assets:     NewLocal(cfg.StoragePath),
+		Thumbnails: NewLocal(cfg.ThumbnailsPath),
+		Serve:      ServeProxy,
+	}
+	switch backend := strings.ToLower(strings.TrimSpace(cfg.Storage.Backend)); backend {
+	case "", BackendLocal:
+		return stores, nil
+	case BackendS3:
+		bucket, err := NewS3(cfg.Storage.S3)
+		if err != nil {
+			return nil, err
+		}
+		stores.Backend = BackendS3
+		stores.Assets = bucket.Sub("assets/")
+		stores.Thumbnails = bucket.Sub("thumbnails/")
+		stores.KeepLocal = cfg.Storage.S3.KeepLocal
+		stores.URLExpiry = defaultURLExpiry
+		if cfg.Storage.S3.URLExpiry > 0 {
+			stores.URLExpiry = time.Duration(cfg.Storage.S3.URLExpiry) * time.Second
+		}
+		switch serve := strings.ToLower(cfg.Storage.S3.Serve); serve {
+		case "", ServeRedirect:
+			stores.Serve = ServeRedirect
+		case ServeProxy:
+			stores.Serve = ServeProxy
+		default:
+			return nil, fmt.Errorf("STORAGE serve MUST BE %s OR %s, NOT %q", ServeRedirect, ServeProxy, serve)
+		}
+		return stores, nil
+	default:
+		return nil, fmt.Errorf("UNKNOWN STORAGE BACKEND %q (SUPPORTED: %s, %s)", backend, BackendLocal, BackendS3)
+	}
+}
+
+// WHETHER FINISHED FILES LEAVE THE LOCAL DIRECTORIES
+func (s *Stores) Remote() bool {
+	return s != nil && s.Backend != BackendLocal
+}
@@ -168,7 +168,7 @@ export async function regenerateAssetThumbnail(assetId) {
     state.assets = Array.isArray(state.assets)
       ? state.assets.map(asset =>
         asset.id === assetId
-          ? { ...asset, thumbnailPath: result.thumbnailPath }
+          ? { ...asset, thumbnailPath: result.thumbnailPath, thumbnailVersion: result.thumbnailVersion, metadata: result.asset?.metadata ?? asset.metadata }
           : asset
       )
       : [];
@@ -176,7 +176,9 @@ export async function regenerateAssetThumbnail(assetId) {
     if (state.selectedAsset && state.selectedAsset.id === assetId) {
       state.selectedAsset = {
         ...state.selectedAsset,
-        thumbnailPath: result.thumbnailPath
+        thumbnailPath: result.thumbnailPath,
+        thumbnailVersion: result.thumbnailVersion,
+        metadata: result.asset?.metadata ?? state.selectedAsset.metadata
       };
     }
     return result.thumbnailPath;
@@ -1,4 +1,6 @@
 // UI STORE USING SVELTE 5 RUNES
+import { base } from '$app/paths';
+
 export const state = $state({
   isSidebarOpen: false,
   toasts: [],
@@ -79,7 +81,7 @@ export async function initTheme() {
   } else {
     // TRY TO FETCH FROM SETTINGS API
     try {
-      const response = await fetch('/api/settings');
+      const response = await fetch(`${base}/api/settings`);
       const body = await response.json();
       console.log(JSON.stringify(body, 4, 2));
       
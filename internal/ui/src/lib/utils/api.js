@@ -1,6 +1,7 @@
+import { base } from '$app/paths';
 import { addToast } from '$lib/stores/uiStore.svelte';
 
-const API_BASE_URL = '/api';
+const API_BASE_URL = `${base}/api`;
 
 export async function apiRequest(endpoint, options = {}, showToasts = true) {
   const url = `${API_BASE_URL}${endpoint}`;
@@ -63,6 +64,25 @@ export const jobsApi = {
   }),
   getStatistics: (id) => apiRequest(`/jobs/${id}/statistics`),
   getAssets: (id) => apiRequest(`/jobs/${id}/assets`),
+  migrate: (id) => apiRequest(`/jobs/${id}/migrate`, {
+    method: 'POST',
+  }),
+  previewMigration: (id) => apiRequest(`/jobs/${id}/migrate?preview=true`, {
+    method: 'POST',
+  }),
+  getChanges: (id, filters = {}) => {
+    const queryString = new URLSearchParams(filters).toString();
+    return apiRequest(queryString ? `/jobs/${id}/changes?${queryString}` : `/jobs/${id}/changes`);
+  },
+  createMonitor: (monitorData) => apiRequest('/jobs/presets/monitor', {
+    method: 'POST',
+    body: JSON.stringify(monitorData),
+  }),
+  getPresets: () => apiRequest('/jobs/presets'),
+  getPresetJob: (id, url, options = {}) => {
+    const queryParams = new URLSearchParams({ url, ...options });
+    return apiRequest(`/jobs/presets/${id}?${queryParams.toString()}`);
+  },
 };
 
 // ASSETS API
@@ -85,11 +105,77 @@ export const assetsApi = {
     });
   },
   getById: (id) => apiRequest(`/assets/${id}`),
+  getText: (id) => apiRequest(`/assets/${id}/text`),
   delete: (id) => apiRequest(`/assets/${id}`, {
     method: 'DELETE',
   }),
-  regenerateThumbnail: (id) => apiRequest(`/assets/${id}/regenerate-thumbnail`, {
+  regenerateThumbnail: (id) => apiRequest(`/assets/${id}/thumbnail/regenerate`, {
+    method: 'POST',
+  }),
+  regenerateThumbnails: (selection) => apiRequest('/assets/thumbnails/regenerate', {
+    method: 'POST',
+    body: JSON.stringify(selection),
+  }),
+};
+
+// TEMPLATES API
+export const templatesApi = {
+  getAll: () => apiRequest('/templates'),
+  getById: (id) => apiRequest(`/templates/${id}`),
+  create: (templateData) => apiRequest('/templates', {
+    method: 'POST',
+    body: JSON.stringify(templateData),
+  }),
+  update: (id, templateData) => apiRequest(`/templates/${id}`, {
+    method: 'PUT',
+    body: JSON.stringify(templateData),
+  }),
+  delete: (id) => apiRequest(`/templates/${id}`, {
+    method: 'DELETE',
+  }),
+  instantiate: (id, options) => apiRequest(`/templates/${id}/instantiate`, {
+    method: 'POST',
+    body: JSON.stringify(options),
+  }),
+  export: (ids = []) => apiRequest(ids.length ? `/templates/export?ids=${ids.map(encodeURIComponent).join(',')}` : '/templates/export'),
+  import: (document) => apiRequest('/templates/import', {
+    method: 'POST',
+    body: JSON.stringify(document),
+  }),
+};
+
+// AUTH API (THE SESSION TRAVELS IN AN HTTP-ONLY COOKIE)
+export const authApi = {
+  status: () => apiRequest('/auth/status', {}, false),
+  setup: (username, password) => apiRequest('/auth/setup', {
     method: 'POST',
+    body: JSON.stringify({ username, password }),
+  }),
+  login: (username, password) => apiRequest('/auth/login', {
+    method: 'POST',
+    body: JSON.stringify({ username, password }),
+  }),
+  logout: () => apiRequest('/auth/logout', { method: 'POST' }),
+  me: () => apiRequest('/auth/me'),
+  changePassword: (currentPassword, newPassword) => apiRequest('/auth/password', {
+    method: 'PUT',
+    body: JSON.stringify({ currentPassword, newPassword }),
+  }),
+};
+
+// USERS API (ADMINS ONLY)
+export const usersApi = {
+  getAll: () => apiRequest('/users'),
+  create: (userData) => apiRequest('/users', {
+    method: 'POST',
+    body: JSON.stringify(userData),
+  }),
+  update: (id, userData) => apiRequest(`/users/${id}`, {
+    method: 'PUT',
+    body: JSON.stringify(userData),
+  }),
+  delete: (id) => apiRequest(`/users/${id}`, {
+    method: 'DELETE',
   }),
 };
 
@@ -13,7 +13,11 @@ export default {
 		// See https://svelte.dev/docs/kit/adapters for more information about adapters.
 		adapter: adapter({
 			fallback: 'index.html'
-		})
+		}),
+		// MUST MATCH basePath IN config.json WHEN SERVING UNDER A URL PREFIX
+		paths: {
+			base: process.env.CREPES_BASE_PATH ?? ''
+		}
 	}
 };
 
@@ -0,0 +1,8 @@
+package utils
+
+// BYTES ON A VOLUME; Available IS WHAT THIS PROCESS CAN USE (Free INCLUDES SPACE RESERVED FOR ROOT)
+type DiskSpace struct {
+	Total     uint64
+	Free      uint64
+	Available uint64
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package utils
+
+import "syscall"
+
+// SPACE ON THE VOLUME HOLDING path
+func DiskUsage(path string) (DiskSpace, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return DiskSpace{}, err
+	}
+	blockSize := uint64(stat.Bsize)
+	return DiskSpace{
+		Total:     blockSize * uint64(stat.Blocks),
+		Free:      blockSize * uint64(stat.Bfree),
+		Available: blockSize * uint64(stat.Bavail),
+	}, nil
+}
@@ -0,0 +1,16 @@
+package utils
+
+import "golang.org/x/sys/windows"
+
+// SPACE ON THE VOLUME HOLDING path
+func DiskUsage(path string) (DiskSpace, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskSpace{}, err
+	}
+	var space DiskSpace
+	if err := windows.GetDiskFreeSpaceEx(dir, &space.Available, &space.Total, &space.Free); err != nil {
+		return DiskSpace{}, err
+	}
+	return space, nil
+}
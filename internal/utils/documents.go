@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// -- DOCUMENT TEXT --
+//
+// TEXT IS PULLED OUT OF DOCUMENT ASSETS SO THEY SHOW UP IN SEARCH: PDFS WITH pdftotext, OFFICE
+// (docx, pptx, xlsx) AND OPENDOCUMENT (odt, odp, ods) FILES BY READING THEIR XML IN GO, HTML
+// WITHOUT ITS SCRIPTS AND STYLES, AND PLAIN TEXT AS IT IS. WHITESPACE IS COLLAPSED AND THE
+// RESULT CUT OFF AT maxDocumentText.
+
+// MOST TEXT KEPT FROM ONE DOCUMENT
+const maxDocumentText = 1 << 20
+
+// THE FILE ISN'T A FORMAT TEXT IS EXTRACTED FROM
+var ErrNoDocumentText = errors.New("NO TEXT EXTRACTOR FOR THIS FORMAT")
+
+// FILES LIBREOFFICE TURNS INTO A PDF FOR THEIR FIRST-PAGE PREVIEW
+var officeExtensions = []string{".doc", ".docx", ".odt", ".rtf", ".xls", ".xlsx", ".ods", ".ppt", ".pptx", ".odp"}
+
+var (
+	plainTextExtensions = []string{".txt", ".md", ".csv", ".tsv", ".json", ".log"}
+	htmlExtensions      = []string{".html", ".htm", ".xhtml"}
+	pptxSlidePattern    = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+)
+
+// PULL A DOCUMENT'S TEXT, REPORTING WHETHER IT WAS CUT OFF. ErrNoDocumentText FOR FORMATS
+// WITHOUT AN EXTRACTOR
+func ExtractDocumentText(sourcePath string) (string, bool, error) {
+	ext := strings.ToLower(filepath.Ext(sourcePath))
+	var text string
+	var err error
+	switch {
+	case ext == ".pdf":
+		text, err = pdfText(sourcePath)
+	case ext == ".docx":
+		text, err = zipXMLText(sourcePath, func(name string) bool { return name == "word/document.xml" }, "t")
+	case ext == ".pptx":
+		text, err = zipXMLText(sourcePath, func(name string) bool { return pptxSlidePattern.MatchString(name) }, "t")
+	case ext == ".xlsx":
+		text, err = zipXMLText(sourcePath, func(name string) bool { return name == "xl/sharedStrings.xml" }, "t")
+	case ext == ".odt", ext == ".odp", ext == ".ods":
+		text, err = zipXMLText(sourcePath, func(name string) bool { return name == "content.xml" }, "")
+	case slices.Contains(htmlExtensions, ext):
+		text, err = htmlText(sourcePath)
+	case slices.Contains(plainTextExtensions, ext):
+		var data []byte
+		data, err = readLimited(sourcePath, maxDocumentText*2)
+		text = string(data)
+	default:
+		return "", false, ErrNoDocumentText
+	}
+	if err != nil {
+		return "", false, err
+	}
+	text, truncated := tidyText(text)
+	return text, truncated, nil
+}
+
+// READ A PDF'S TEXT WITH pdftotext
+func pdfText(sourcePath string) (string, error) {
+	tools := CurrentMediaTools()
+	if !tools.Pdftotext.Available {
+		return "", fmt.Errorf("pdftotext NOT FOUND")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), toolRunTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, tools.Pdftotext.Path, "-q", "-enc", "UTF-8", sourcePath, "-")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if line, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); line != "" {
+			return "", fmt.Errorf("pdftotext: %s", line)
+		}
+		return "", fmt.Errorf("pdftotext: %v", err)
+	}
+	return string(out), nil
+}
+
+// THE TEXT OF THE XML PARTS OF A ZIPPED DOCUMENT, IN NAME ORDER (SLIDES BY NUMBER). WITH A
+// textElement ONLY TEXT INSIDE ELEMENTS OF THAT LOCAL NAME COUNTS (OOXML'S w:t, a:t, t);
+// PARAGRAPHS, ROWS AND SHARED STRINGS END LINES
+func zipXMLText(sourcePath string, wanted func(name string) bool, textElement string) (string, error) {
+	archive, err := zip.OpenReader(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("NOT A READABLE DOCUMENT: %v", err)
+	}
+	defer archive.Close()
+	var parts []*zip.File
+	for _, file := range archive.File {
+		if wanted(file.Name) {
+			parts = append(parts, file)
+		}
+	}
+	slices.SortFunc(parts, func(a, b *zip.File) int { return partNumber(a.Name) - partNumber(b.Name) })
+
+	var text strings.Builder
+	for _, part := range parts {
+		if text.Len() > maxDocumentText*2 {
+			break
+		}
+		reader, err := part.Open()
+		if err != nil {
+			return "", err
+		}
+		err = xmlText(io.LimitReader(reader, maxDocumentText*8), textElement, &text)
+		reader.Close()
+		if err != nil {
+			return "", fmt.Errorf("UNREADABLE %s: %v", part.Name, err)
+		}
+		text.WriteString("\n")
+	}
+	return text.String(), nil
+}
+
+// SLIDE NUMBER OF A pptx PART (0 FOR OTHER PARTS)
+func partNumber(name string) int {
+	if m := pptxSlidePattern.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	return 0
+}
+
+// ELEMENTS THAT END A LINE OF TEXT: PARAGRAPHS AND HEADINGS, TABLE ROWS, SHARED STRINGS, LINE BREAKS
+var xmlLineElements = map[string]bool{"p": true, "h": true, "tr": true, "table-row": true, "si": true, "br": true, "line-break": true}
+
+func xmlText(r io.Reader, textElement string, out *strings.Builder) error {
+	decoder := xml.NewDecoder(r)
+	depth := 0 // HOW MANY textElement ELEMENTS WE'RE INSIDE
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if textElement != "" && t.Name.Local == textElement {
+				depth++
+			}
+			if t.Name.Local == "tab" || t.Name.Local == "s" {
+				out.WriteString(" ")
+			}
+		case xml.EndElement:
+			if textElement != "" && t.Name.Local == textElement && depth > 0 {
+				depth--
+			}
+			if xmlLineElements[t.Name.Local] {
+				out.WriteString("\n")
+			} else if t.Name.Local == "c" || t.Name.Local == "table-cell" {
+				out.WriteString("\t")
+			}
+		case xml.CharData:
+			if textElement == "" || depth > 0 {
+				out.Write(t)
+			}
+		}
+	}
+}
+
+// THE VISIBLE TEXT OF AN HTML FILE
+func htmlText(sourcePath string) (string, error) {
+	data, err := readLimited(sourcePath, maxDocumentText*8)
+	if err != nil {
+		return "", err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	doc.Find("script, style, noscript, template").Remove()
+	doc.Find("p, div, br, li, tr, h1, h2, h3, h4, h5, h6").Each(func(_ int, s *goquery.Selection) {
+		s.AppendHtml("\n")
+	})
+	return doc.Text(), nil
+}
+
+func readLimited(path string, limit int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, limit))
+}
+
+// COLLAPSE RUNS OF SPACES AND BLANK LINES, DROP INVALID UTF-8 AND CUT THE TEXT AT
+// maxDocumentText (ON A RUNE BOUNDARY), REPORTING WHETHER IT WAS CUT
+func tidyText(text string) (string, bool) {
+	text = strings.ToValidUTF8(text, "")
+	var lines []string
+	for line := range strings.Lines(text) {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	text = strings.Join(lines, "\n")
+	if len(text) <= maxDocumentText {
+		return text, false
+	}
+	cut := maxDocumentText
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut], true
+}
+
+// -- OFFICE PREVIEWS --
+
+// WHETHER A FILE IS AN OFFICE DOCUMENT LIBREOFFICE CAN PREVIEW
+func IsOfficeDocument(sourcePath string) bool {
+	return slices.Contains(officeExtensions, strings.ToLower(filepath.Ext(sourcePath)))
+}
+
+// RENDER AN OFFICE DOCUMENT'S FIRST PAGE: LIBREOFFICE MAKES A PDF, IMAGEMAGICK DRAWS ITS FIRST
+// PAGE. EACH CONVERSION GETS ITS OWN PROFILE DIRECTORY SO PARALLEL RENDERS DON'T FIGHT OVER A LOCK
+func officeThumbnail(tools MediaTools, sourcePath, thumbnailPath string) error {
+	scratch, err := os.MkdirTemp("", "crepes_office_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+	profile := "file://" + filepath.ToSlash(filepath.Join(scratch, "profile"))
+	if !strings.HasPrefix(profile, "file:///") {
+		profile = "file:///" + strings.TrimPrefix(profile, "file://") // WINDOWS DRIVE PATHS
+	}
+	if err := runTool(tools.LibreOffice.Path, "-env:UserInstallation="+profile, "--headless", "--norestore",
+		"--convert-to", "pdf", "--outdir", scratch, sourcePath); err != nil {
+		return err
+	}
+	pdf := filepath.Join(scratch, strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))+".pdf")
+	if _, err := os.Stat(pdf); err != nil {
+		return fmt.Errorf("LibreOffice: NO PDF WRITTEN")
+	}
+	return magickThumbnail(tools, pdf, thumbnailPath)
+}
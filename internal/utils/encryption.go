@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// -- ENCRYPTION AT REST --
+//
+// FILES ARE SPLIT INTO FIXED-SIZE CHUNKS, EACH SEALED WITH AES-256-GCM SO RANGES
+// CAN BE DECRYPTED WITHOUT READING THE WHOLE FILE. LAYOUT:
+//
+//	MAGIC (8) | SALT (16) | CHUNK SIZE (4, BIG ENDIAN) | CHUNK 0 | CHUNK 1 | ...
+//
+// EACH FILE GETS ITS OWN KEY, DERIVED FROM THE MASTER SECRET AND THE FILE'S SALT
+// WITH HKDF-SHA256. THE NONCE IS THE CHUNK INDEX, AND THE LAST CHUNK IS MARKED
+// THROUGH ITS ADDITIONAL DATA SO TRUNCATION IS DETECTED.
+
+const (
+	encryptionMagic     = "CREPESE1"
+	encryptionSaltSize  = 16
+	encryptionChunkSize = 64 << 10
+	encryptionHeader    = len(encryptionMagic) + encryptionSaltSize + 4
+	encryptionInfo      = "crepes asset encryption v1"
+)
+
+var (
+	ErrNoEncryptionSecret = errors.New("NO ENCRYPTION SECRET CONFIGURED")
+	ErrNotEncrypted       = errors.New("FILE IS NOT ENCRYPTED")
+	ErrDecryptionFailed   = errors.New("DECRYPTION FAILED (WRONG SECRET OR CORRUPTED FILE)")
+)
+
+// WHETHER A FILE STARTS WITH THE ENCRYPTION HEADER
+func IsEncryptedFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == encryptionMagic
+}
+
+// ENCRYPT A FILE IN PLACE (WRITES A SIBLING TEMP FILE, THEN RENAMES OVER THE ORIGINAL)
+func EncryptFile(path, secret string) error {
+	if secret == "" {
+		return ErrNoEncryptionSecret
+	}
+	if IsEncryptedFile(path) {
+		return nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("FAILED TO OPEN FILE FOR ENCRYPTION: %v", err)
+	}
+	defer in.Close()
+
+	tmpPath := path + ".enc.tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("FAILED TO CREATE ENCRYPTED FILE: %v", err)
+	}
+	if err := encryptStream(in, out, secret); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("FAILED TO WRITE ENCRYPTED FILE: %v", err)
+	}
+	in.Close()
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("FAILED TO REPLACE FILE WITH ENCRYPTED COPY: %v", err)
+	}
+	return nil
+}
+
+// DECRYPT AN ENCRYPTED FILE INTO A NEW PLAINTEXT FILE
+func DecryptFileTo(path, dest, secret string) error {
+	reader, err := OpenDecrypted(path, secret)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("FAILED TO CREATE DECRYPTED FILE: %v", err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, reader); err != nil {
+		return err
+	}
+	return nil
+}
+
+func encryptStream(in io.Reader, out io.Writer, secret string) error {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("FAILED TO GENERATE SALT: %v", err)
+	}
+	aead, err := newFileAEAD(secret, salt)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, encryptionHeader)
+	header = append(header, encryptionMagic...)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, encryptionChunkSize)
+	if _, err := out.Write(header); err != nil {
+		return fmt.Errorf("FAILED TO WRITE ENCRYPTED FILE: %v", err)
+	}
+
+	// READ ONE CHUNK AHEAD SO THE FINAL CHUNK CAN BE MARKED
+	current := make([]byte, encryptionChunkSize)
+	next := make([]byte, encryptionChunkSize)
+	n, err := io.ReadFull(in, current)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("FAILED TO READ FILE FOR ENCRYPTION: %v", err)
+	}
+	sealed := make([]byte, 0, encryptionChunkSize+aead.Overhead())
+	for index := uint64(0); ; index++ {
+		final := n < encryptionChunkSize
+		var m int
+		if !final {
+			m, err = io.ReadFull(in, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return fmt.Errorf("FAILED TO READ FILE FOR ENCRYPTION: %v", err)
+			}
+			final = m == 0
+		}
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(index), current[:n], chunkAAD(final))
+		if _, err := out.Write(sealed); err != nil {
+			return fmt.Errorf("FAILED TO WRITE ENCRYPTED FILE: %v", err)
+		}
+		if final {
+			return nil
+		}
+		current, next = next, current
+		n = m
+	}
+}
+
+// RANDOM-ACCESS DECRYPTING READER OVER AN ENCRYPTED FILE (SUITABLE FOR http.ServeContent)
+type DecryptedFile struct {
+	file      *os.File
+	aead      cipher.AEAD
+	chunkSize int64
+	chunks    int64
+	size      int64 // PLAINTEXT SIZE
+	offset    int64
+
+	// LAST DECRYPTED CHUNK
+	cached      int64
+	cachedPlain []byte
+}
+
+// OPEN AN ENCRYPTED FILE FOR READING
+func OpenDecrypted(path, secret string) (*DecryptedFile, error) {
+	if secret == "" {
+		return nil, ErrNoEncryptionSecret
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	header := make([]byte, encryptionHeader)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.HasPrefix(header, []byte(encryptionMagic)) {
+		f.Close()
+		return nil, ErrNotEncrypted
+	}
+	salt := header[len(encryptionMagic) : len(encryptionMagic)+encryptionSaltSize]
+	chunkSize := int64(binary.BigEndian.Uint32(header[len(encryptionMagic)+encryptionSaltSize:]))
+	aead, err := newFileAEAD(secret, salt)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// DERIVE PLAINTEXT SIZE FROM THE CIPHERTEXT LENGTH
+	overhead := int64(aead.Overhead())
+	body := info.Size() - int64(encryptionHeader)
+	sealedChunk := chunkSize + overhead
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	if chunkSize <= 0 || chunks == 0 || body-(chunks-1)*sealedChunk < overhead {
+		f.Close()
+		return nil, ErrDecryptionFailed
+	}
+	size := body - chunks*overhead
+
+	return &DecryptedFile{
+		file:      f,
+		aead:      aead,
+		chunkSize: chunkSize,
+		chunks:    chunks,
+		size:      size,
+		cached:    -1,
+	}, nil
+}
+
+// PLAINTEXT SIZE
+func (d *DecryptedFile) Size() int64 {
+	return d.size
+}
+
+func (d *DecryptedFile) Read(p []byte) (int, error) {
+	n, err := d.ReadAt(p, d.offset)
+	d.offset += int64(n)
+	return n, err
+}
+
+func (d *DecryptedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("INVALID WHENCE")
+	}
+	if offset < 0 {
+		return 0, errors.New("NEGATIVE POSITION")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+func (d *DecryptedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	total := 0
+	for total < len(p) && off < d.size {
+		index := off / d.chunkSize
+		plain, err := d.chunk(index)
+		if err != nil {
+			return total, err
+		}
+		n := copy(p[total:], plain[off-index*d.chunkSize:])
+		total += n
+		off += int64(n)
+	}
+	if total < len(p) {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+func (d *DecryptedFile) Close() error {
+	return d.file.Close()
+}
+
+// DECRYPT (OR RETURN THE CACHED) CHUNK
+func (d *DecryptedFile) chunk(index int64) ([]byte, error) {
+	if index == d.cached {
+		return d.cachedPlain, nil
+	}
+	overhead := int64(d.aead.Overhead())
+	sealedSize := d.chunkSize + overhead
+	if index == d.chunks-1 {
+		sealedSize = d.size - index*d.chunkSize + overhead
+	}
+
+	sealed := make([]byte, sealedSize)
+	if _, err := d.file.ReadAt(sealed, int64(encryptionHeader)+index*(d.chunkSize+overhead)); err != nil {
+		return nil, fmt.Errorf("FAILED TO READ ENCRYPTED CHUNK: %v", err)
+	}
+	d.cached = -1 // THE CACHE BUFFER IS REUSED BELOW
+	plain, err := d.aead.Open(d.cachedPlain[:0], chunkNonce(uint64(index)), sealed, chunkAAD(index == d.chunks-1))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	d.cached = index
+	d.cachedPlain = plain
+	return plain, nil
+}
+
+// PER-FILE AES-256-GCM FROM THE MASTER SECRET AND FILE SALT
+func newFileAEAD(secret string, salt []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, []byte(secret), salt, encryptionInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO DERIVE KEY: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	return nonce
+}
+
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
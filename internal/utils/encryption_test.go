@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSecret = "correct horse battery staple"
+
+// AN ENCRYPTED COPY OF data IN A TEMP DIRECTORY, AND THE SEALED SIZE OF A FULL CHUNK
+func encryptedFile(t *testing.T, data []byte) (string, int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "asset.bin")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptFile(path, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newFileAEAD(testSecret, make([]byte, encryptionSaltSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, encryptionChunkSize + aead.Overhead()
+}
+
+// THE WHOLE PLAINTEXT, OR THE FIRST ERROR FROM OPENING OR READING IT
+func decryptAll(path, secret string) ([]byte, error) {
+	file, err := OpenDecrypted(path, secret)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/encryptionChunkSize)
+	}
+	return data
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 2*encryptionChunkSize + encryptionChunkSize/2} {
+		data := testData(size)
+		path, _ := encryptedFile(t, data)
+		if !IsEncryptedFile(path) {
+			t.Fatalf("%d bytes: not encrypted", size)
+		}
+		got, err := decryptAll(path, testSecret)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%d bytes: decrypted %d bytes, %v", size, len(got), err)
+		}
+	}
+
+	// A RANGE ACROSS A CHUNK BOUNDARY
+	data := testData(3 * encryptionChunkSize)
+	path, _ := encryptedFile(t, data)
+	file, err := OpenDecrypted(path, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	part := make([]byte, 100)
+	if _, err := file.ReadAt(part, encryptionChunkSize-50); err != nil || !bytes.Equal(part, data[encryptionChunkSize-50:encryptionChunkSize+50]) {
+		t.Fatalf("range read = %v", err)
+	}
+}
+
+// EVERY WAY OF DAMAGING A FILE IS CAUGHT: NOTHING DECRYPTS TO SOMETHING THAT WASN'T WRITTEN
+func TestEncryptionRejectsDamage(t *testing.T) {
+	data := testData(2*encryptionChunkSize + encryptionChunkSize/2)
+
+	tests := []struct {
+		name   string
+		damage func(raw []byte, sealedChunk int) []byte
+		secret string
+	}{
+		{
+			name:   "wrong secret",
+			damage: func(raw []byte, _ int) []byte { return raw },
+			secret: "another secret",
+		},
+		{
+			name: "flipped ciphertext bit",
+			damage: func(raw []byte, sealedChunk int) []byte {
+				raw[encryptionHeader+sealedChunk+10] ^= 0x01
+				return raw
+			},
+		},
+		{
+			name: "flipped tag bit",
+			damage: func(raw []byte, _ int) []byte {
+				raw[len(raw)-1] ^= 0x80
+				return raw
+			},
+		},
+		{
+			name: "changed salt",
+			damage: func(raw []byte, _ int) []byte {
+				raw[len(encryptionMagic)] ^= 0xff
+				return raw
+			},
+		},
+		{
+			name: "truncated mid-chunk",
+			damage: func(raw []byte, _ int) []byte {
+				return raw[:len(raw)-100]
+			},
+		},
+		{
+			// WHAT'S LEFT IS WHOLE CHUNKS, BUT THE NEW LAST ONE WASN'T SEALED AS THE LAST
+			name: "truncated at a chunk boundary",
+			damage: func(raw []byte, sealedChunk int) []byte {
+				return raw[:encryptionHeader+2*sealedChunk]
+			},
+		},
+		{
+			name: "header only",
+			damage: func(raw []byte, _ int) []byte {
+				return raw[:encryptionHeader]
+			},
+		},
+		{
+			name: "chunks swapped",
+			damage: func(raw []byte, sealedChunk int) []byte {
+				first := raw[encryptionHeader : encryptionHeader+sealedChunk]
+				second := raw[encryptionHeader+sealedChunk : encryptionHeader+2*sealedChunk]
+				swapped := append(append(append([]byte{}, raw[:encryptionHeader]...), second...), first...)
+				return append(swapped, raw[encryptionHeader+2*sealedChunk:]...)
+			},
+		},
+		{
+			name: "chunk dropped from the middle",
+			damage: func(raw []byte, sealedChunk int) []byte {
+				return append(raw[:encryptionHeader+sealedChunk:encryptionHeader+sealedChunk], raw[encryptionHeader+2*sealedChunk:]...)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, sealedChunk := encryptedFile(t, data)
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.damage(raw, sealedChunk), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			secret := tt.secret
+			if secret == "" {
+				secret = testSecret
+			}
+			got, err := decryptAll(path, secret)
+			if !errors.Is(err, ErrDecryptionFailed) {
+				t.Fatalf("decrypted %d bytes with error %v; want %v", len(got), err, ErrDecryptionFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -- APPLICATION LOG --
+//
+// THE LOG IS WRITTEN TO crepes.log IN THE DATA DIRECTORY. THE FILE IS ROTATED ONCE IT OUTGROWS
+// ITS SIZE LIMIT OR THE DAY CHANGES: IT IS RENAMED WITH THE TIME IT WAS ROTATED, COMPRESSED
+// IN THE BACKGROUND, AND ROTATED FILES BEYOND THE BACKUP COUNT OR PAST THE MAXIMUM AGE ARE DELETED.
+
+// NAME OF THE LIVE LOG IN THE DATA DIRECTORY
+const AppLogName = "crepes.log"
+
+// ROTATED FILES ARE NAMED crepes-<TIME>.log, PLUS .gz ONCE COMPRESSED
+const rotatedLogTime = "2006-01-02T15-04-05.000"
+
+// LIMITS OF A ROTATING LOG (ZERO VALUES TAKE THE DEFAULTS)
+type LogOptions struct {
+	MaxSize    int64 // BYTES (DEFAULT 50MB)
+	MaxAge     time.Duration
+	MaxBackups int  // DEFAULT 5
+	Compress   bool // GZIP ROTATED FILES
+}
+
+// A LOG FILE THAT ROTATES ITSELF. SAFE FOR CONCURRENT WRITES
+type RotatingLog struct {
+	path string
+	opts LogOptions
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	day  string // DATE OF THE LAST WRITE
+}
+
+// WHERE THE APPLICATION LOG LIVES IN A DATA DIRECTORY
+func AppLogPath(dataPath string) string {
+	return filepath.Join(dataPath, AppLogName)
+}
+
+// OPEN (OR CREATE) A ROTATING LOG AT path
+func OpenRotatingLog(path string, opts LogOptions) (*RotatingLog, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 50 << 20
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = 5
+	}
+	l := &RotatingLog{path: path, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *RotatingLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	l.day = info.ModTime().Format(time.DateOnly)
+	if l.size == 0 {
+		l.day = time.Now().Format(time.DateOnly)
+	}
+	return nil
+}
+
+// WRITE TO THE LOG, ROTATING IT FIRST WHEN THIS WRITE WOULD PASS THE SIZE LIMIT OR IS ON A NEW DAY
+func (l *RotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	today := time.Now().Format(time.DateOnly)
+	if l.size > 0 && (l.size+int64(len(p)) > l.opts.MaxSize || l.day != today) {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED TO ROTATE %s: %v\n", l.path, err)
+		}
+	}
+	if l.file == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	l.day = today
+	return n, err
+}
+
+// ROTATE NOW (IF THE LOG ISN'T EMPTY)
+func (l *RotatingLog) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size == 0 {
+		return nil
+	}
+	return l.rotate()
+}
+
+// CALLER HOLDS l.mu
+func (l *RotatingLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	ext := filepath.Ext(l.path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(l.path, ext), time.Now().Format(rotatedLogTime), ext)
+	renameErr := os.Rename(l.path, rotated)
+	if err := l.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	go func() {
+		if l.opts.Compress {
+			if err := compressLog(rotated); err != nil {
+				log.Printf("WARNING: Failed to compress %s: %v", rotated, err)
+			}
+		}
+		l.Prune()
+	}()
+	return nil
+}
+
+// A ROTATED LOG FILE
+type LogBackup struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Rotated time.Time `json:"rotated"`
+}
+
+// THE LOG'S ROTATED FILES, NEWEST FIRST
+func (l *RotatingLog) Backups() []LogBackup {
+	ext := filepath.Ext(l.path)
+	prefix := filepath.Base(strings.TrimSuffix(l.path, ext)) + "-"
+	backups := []LogBackup{}
+	entries, err := os.ReadDir(filepath.Dir(l.path))
+	if err != nil {
+		return backups
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		rotated, err := time.ParseInLocation(rotatedLogTime, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, LogBackup{Name: name, Size: info.Size(), Rotated: rotated})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Rotated.After(backups[j].Rotated) })
+	return backups
+}
+
+// DELETE ROTATED FILES BEYOND THE BACKUP COUNT OR OLDER THAN THE MAXIMUM AGE, RETURNING HOW MANY
+// WERE DELETED AND THE BYTES FREED
+func (l *RotatingLog) Prune() (int, int64) {
+	removed, freed := 0, int64(0)
+	for i, backup := range l.Backups() {
+		expired := l.opts.MaxAge > 0 && time.Since(backup.Rotated) > l.opts.MaxAge
+		if i < l.opts.MaxBackups && !expired {
+			continue
+		}
+		if err := os.Remove(filepath.Join(filepath.Dir(l.path), backup.Name)); err != nil {
+			log.Printf("WARNING: Failed to delete old log %s: %v", backup.Name, err)
+			continue
+		}
+		removed++
+		freed += backup.Size
+	}
+	return removed, freed
+}
+
+// PATH OF THE LIVE LOG
+func (l *RotatingLog) Path() string {
+	return l.path
+}
+
+// SIZE OF THE LIVE LOG
+func (l *RotatingLog) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+func (l *RotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// GZIP A FILE TO name.gz AND REMOVE THE ORIGINAL
+func compressLog(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(name)
+}
+
+// THE LAST n LINES OF A FILE THAT match ACCEPTS (ALL WHEN NIL), OLDEST FIRST. AT MOST maxBytes
+// FROM THE END OF THE FILE ARE READ
+func TailLines(path string, n int, maxBytes int64, match func(string) bool) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	const chunk = 64 << 10
+	lines := []string{}
+	var partial []byte // START OF A LINE WHOSE BEGINNING IS IN THE NEXT CHUNK BACK
+	end := info.Size()
+	floor := max(end-maxBytes, 0)
+	for end > floor && len(lines) < n {
+		start := max(end-chunk, floor)
+		buf := make([]byte, end-start, end-start+int64(len(partial)))
+		if _, err := file.ReadAt(buf, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = append(buf, partial...)
+		end = start
+
+		parts := bytes.Split(buf, []byte("\n"))
+		partial = parts[0]
+		for i := len(parts) - 1; i >= 1 && len(lines) < n; i-- {
+			if line := string(parts[i]); line != "" && (match == nil || match(line)) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	// THE FILE'S FIRST LINE HAS NO NEWLINE BEFORE IT
+	if end == 0 && len(lines) < n {
+		if line := string(partial); line != "" && (match == nil || match(line)) {
+			lines = append(lines, line)
+		}
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, nil
+}
@@ -0,0 +1,367 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -- EXTERNAL MEDIA TOOLS --
+//
+// ffmpeg MAKES REAL VIDEO THUMBNAILS (SCENE DETECTION PICKS THE FRAME) AND AUDIO WAVEFORMS OR
+// SPECTROGRAMS, ffprobe READS DURATIONS AND BITRATES, IMAGEMAGICK COVERS IMAGE
+// FORMATS THE GO DECODERS DON'T (HEIC, AVIF, PSD, ...) AND THE FIRST PAGE OF PDFS, LIBREOFFICE
+// TURNS OFFICE FILES INTO PDFS FOR IT, AND pdftotext READS PDF TEXT FOR SEARCH. ALL ARE
+// OPTIONAL: WITHOUT THEM THUMBNAILS FALL BACK TO PLACEHOLDERS AND SAY WHY.
+
+// HOW LONG A TOOL GETS TO REPORT ITS VERSION, AND TO MAKE ONE THUMBNAIL
+const (
+	toolProbeTimeout = 5 * time.Second
+	toolRunTimeout   = 60 * time.Second
+)
+
+// PATHS FROM THE SETTINGS; EMPTY MEANS LOOK ON THE PATH
+type MediaToolPaths struct {
+	FFmpeg      string
+	FFprobe     string
+	Magick      string
+	Pdftotext   string
+	LibreOffice string
+}
+
+// WHAT WAS FOUND FOR ONE TOOL
+type ToolStatus struct {
+	Available bool   `json:"available"`
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// THE TOOLS FOUND AT THE LAST CHECK
+type MediaTools struct {
+	FFmpeg      ToolStatus `json:"ffmpeg"`
+	FFprobe     ToolStatus `json:"ffprobe"`
+	Magick      ToolStatus `json:"magick"`
+	Pdftotext   ToolStatus `json:"pdftotext"`
+	LibreOffice ToolStatus `json:"libreOffice"`
+	CheckedAt   time.Time  `json:"checkedAt"`
+}
+
+// FEATURES THE TOOLS ENABLE, FOR THE SETTINGS API
+func (t MediaTools) Capabilities() map[string]bool {
+	return map[string]bool{
+		"videoThumbnails":      t.FFmpeg.Available,
+		"videoFrameSelection":  t.FFmpeg.Available && t.FFprobe.Available,
+		"videoSceneDetection":  t.FFmpeg.Available,
+		"audioPreviews":        t.FFmpeg.Available,
+		"mediaInfo":            t.FFprobe.Available,
+		"extendedImageFormats": t.Magick.Available,
+		"pdfThumbnails":        t.Magick.Available,
+		"officeThumbnails":     t.Magick.Available && t.LibreOffice.Available,
+		"pdfText":              t.Pdftotext.Available,
+	}
+}
+
+var (
+	mediaToolsMu sync.RWMutex
+	mediaTools   MediaTools
+)
+
+// LOOK FOR THE TOOLS (EXPLICIT PATHS FIRST) AND REMEMBER WHAT WAS FOUND
+func DetectMediaTools(paths MediaToolPaths) MediaTools {
+	tools := MediaTools{
+		FFmpeg:      probeTool(paths.FFmpeg, []string{"ffmpeg"}, "-version", "ffmpeg"),
+		FFprobe:     probeTool(paths.FFprobe, []string{"ffprobe"}, "-version", "ffprobe"),
+		Magick:      probeTool(paths.Magick, []string{"magick", "convert"}, "-version", "ImageMagick"),
+		Pdftotext:   probeTool(paths.Pdftotext, []string{"pdftotext"}, "-v", "pdftotext"),
+		LibreOffice: probeTool(paths.LibreOffice, []string{"soffice", "libreoffice"}, "--version", "LibreOffice"),
+		CheckedAt:   time.Now(),
+	}
+	mediaToolsMu.Lock()
+	mediaTools = tools
+	mediaToolsMu.Unlock()
+	return tools
+}
+
+// THE TOOLS FOUND AT THE LAST DetectMediaTools
+func CurrentMediaTools() MediaTools {
+	mediaToolsMu.RLock()
+	defer mediaToolsMu.RUnlock()
+	return mediaTools
+}
+
+// FIND A WORKING BINARY: WHAT IT PRINTS FOR versionFlag (pdftotext PRINTS IT TO STDERR) HAS TO
+// MENTION marker, WHICH KEEPS WINDOWS' OWN convert.exe FROM PASSING FOR IMAGEMAGICK
+func probeTool(explicit string, names []string, versionFlag, marker string) ToolStatus {
+	candidates := names
+	if explicit != "" {
+		candidates = []string{explicit}
+	}
+	var errs []string
+	for _, name := range candidates {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s NOT FOUND", name))
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), toolProbeTimeout)
+		out, err := exec.CommandContext(ctx, path, versionFlag).CombinedOutput()
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %s FAILED: %v", path, versionFlag, err))
+			continue
+		}
+		version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		if !strings.Contains(version, marker) {
+			errs = append(errs, fmt.Sprintf("%s IS NOT %s", path, marker))
+			continue
+		}
+		return ToolStatus{Available: true, Path: path, Version: strings.TrimSpace(version)}
+	}
+	return ToolStatus{Error: strings.Join(errs, "; ")}
+}
+
+// RUN A TOOL, RETURNING THE FIRST LINE OF ITS ERROR OUTPUT ON FAILURE
+func runTool(path string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), toolRunTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if line, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); line != "" {
+			return fmt.Errorf("%s: %s", filepath.Base(path), line)
+		}
+		return fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// A FRAME 10% INTO THE VIDEO (CAPPED AT A MINUTE) SKIPS BLACK INTROS; 1s WITHOUT ffprobe
+func videoThumbnailOffset(tools MediaTools, sourcePath string) float64 {
+	if !tools.FFprobe.Available {
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), toolProbeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, tools.FFprobe.Path, "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", sourcePath).Output()
+	if err != nil {
+		return 1
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || duration <= 0 {
+		return 1
+	}
+	return min(duration*0.1, 60)
+}
+
+// HOW SCENE DETECTION LOOKS FOR A FRAME: CUTS SCORING ABOVE sceneThreshold IN THE FIRST
+// sceneScanWindow SECONDS, KEEPING UP TO sceneCandidates OF THEM
+const (
+	sceneThreshold  = 0.3
+	sceneScanWindow = 120
+	sceneCandidates = 8
+)
+
+// A FRAME DARKER THAN THIS ON AVERAGE, OR FLATTER THAN THIS, IS BLACK (OR A FADE) AND NOT A THUMBNAIL
+const (
+	blackFrameLuma   = 24
+	flatFrameStddev  = 6
+	frameSampleLimit = 4096
+)
+
+// PICK A FRAME WITH ffmpeg. AN EXPLICIT TIMESTAMP WINS WHEN IT LANDS ON A FRAME; OTHERWISE THE
+// SCENE CUTS NEAR THE START AND THE FRAME 10% IN ARE CANDIDATES AND THE BUSIEST NON-BLACK ONE
+// IS KEPT. WHEN EVERYTHING LOOKS BLACK THE 10% FRAME IS KEPT ANYWAY, THEN THE FIRST FRAME
+func ffmpegThumbnail(tools MediaTools, sourcePath, thumbnailPath string, options VideoFrameOptions) error {
+	grab := func(seek float64, out string) error {
+		if err := runTool(tools.FFmpeg.Path, "-hide_banner", "-loglevel", "error",
+			"-ss", strconv.FormatFloat(seek, 'f', 2, 64), "-i", sourcePath,
+			"-frames:v", "1", "-vf", "scale=300:-2", "-q:v", "4", "-y", out); err != nil {
+			return err
+		}
+		return checkJPEG(out)
+	}
+	if options.Timestamp > 0 {
+		if grab(options.Timestamp, thumbnailPath) == nil {
+			return nil
+		}
+	}
+
+	scratch, err := os.MkdirTemp("", "crepes_frames_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	candidates := sceneCandidateFrames(tools, sourcePath, scratch)
+	offsetFrame := filepath.Join(scratch, "offset.jpg")
+	if grab(videoThumbnailOffset(tools, sourcePath), offsetFrame) == nil {
+		candidates = append(candidates, offsetFrame)
+	} else {
+		offsetFrame = ""
+	}
+	if best := pickVideoFrame(candidates); best != "" {
+		return copyFile(best, thumbnailPath)
+	}
+	if offsetFrame != "" {
+		return copyFile(offsetFrame, thumbnailPath)
+	}
+	if err := grab(0, thumbnailPath); err != nil {
+		return fmt.Errorf("ffmpeg: NO VIDEO FRAME FOUND (%v)", err)
+	}
+	return nil
+}
+
+// THE FRAMES AT SCENE CUTS IN THE FIRST sceneScanWindow SECONDS (NONE WHEN THE SCAN FAILS)
+func sceneCandidateFrames(tools MediaTools, sourcePath, dir string) []string {
+	err := runTool(tools.FFmpeg.Path, "-hide_banner", "-loglevel", "error",
+		"-t", strconv.Itoa(sceneScanWindow), "-i", sourcePath, "-an",
+		"-vf", fmt.Sprintf("select='gt(scene,%g)',scale=300:-2", sceneThreshold),
+		"-vsync", "vfr", "-frames:v", strconv.Itoa(sceneCandidates), "-q:v", "4",
+		filepath.Join(dir, "scene_%02d.jpg"))
+	if err != nil {
+		return nil
+	}
+	frames, _ := filepath.Glob(filepath.Join(dir, "scene_*.jpg"))
+	return frames
+}
+
+// THE CANDIDATE WITH THE MOST DETAIL THAT ISN'T BLACK OR FLAT ("" WHEN NONE QUALIFY)
+func pickVideoFrame(candidates []string) string {
+	best, bestSpread := "", 0.0
+	for _, path := range candidates {
+		mean, spread, err := frameLuma(path)
+		if err != nil || mean < blackFrameLuma || spread < flatFrameStddev {
+			continue
+		}
+		if spread > bestSpread {
+			best, bestSpread = path, spread
+		}
+	}
+	return best
+}
+
+// MEAN AND STANDARD DEVIATION OF A FRAME'S LUMA, FROM A GRID OF SAMPLED PIXELS
+func frameLuma(path string) (float64, float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	img, err := jpeg.Decode(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	bounds := img.Bounds()
+	step := max(1, int(math.Sqrt(float64(bounds.Dx()*bounds.Dy())/frameSampleLimit)))
+	var sum, squares, n float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			luma := float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			sum += luma
+			squares += luma * luma
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0, fmt.Errorf("EMPTY FRAME")
+	}
+	mean := sum / n
+	return mean, math.Sqrt(max(0, squares/n-mean*mean)), nil
+}
+
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0644)
+}
+
+// DRAW AN AUDIO FILE AS A WAVEFORM (OR A SPECTROGRAM) ON A DARK BACKGROUND, WIDER THAN THE
+// THUMBNAIL AND SCALED DOWN SO THE PEAKS STAY SMOOTH
+func ffmpegAudioPreview(tools MediaTools, sourcePath, thumbnailPath string, options AudioPreviewOptions) error {
+	graph := "color=c=0x1f2937:s=600x240[bg];[0:a]aformat=channel_layouts=mono,showwavespic=s=600x240:colors=0x60a5fa[fg];" +
+		"[bg][fg]overlay=format=auto,scale=300:-2"
+	if options.Style == AudioPreviewSpectrogram {
+		graph = "[0:a]showspectrumpic=s=600x240:legend=0,scale=300:-2"
+	}
+	return runTool(tools.FFmpeg.Path, "-hide_banner", "-loglevel", "error", "-i", sourcePath,
+		"-filter_complex", graph, "-frames:v", "1", "-q:v", "4", "-y", thumbnailPath)
+}
+
+// WHAT ffprobe REPORTS ABOUT A MEDIA FILE'S CONTAINER AND FIRST AUDIO STREAM
+type MediaInfo struct {
+	Duration   float64 // SECONDS
+	Bitrate    int64   // BITS PER SECOND
+	Codec      string
+	SampleRate int
+	Channels   int
+}
+
+// READ A MEDIA FILE'S DURATION, BITRATE AND AUDIO FORMAT WITH ffprobe
+func ProbeMedia(sourcePath string) (MediaInfo, error) {
+	tools := CurrentMediaTools()
+	if !tools.FFprobe.Available {
+		return MediaInfo{}, fmt.Errorf("ffprobe NOT FOUND")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), toolRunTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, tools.FFprobe.Path, "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "format=duration,bit_rate:stream=codec_name,sample_rate,channels,bit_rate",
+		"-of", "json", sourcePath).Output()
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("ffprobe: %v", err)
+	}
+	// ffprobe PRINTS NUMBERS AS STRINGS
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
+		Streams []struct {
+			CodecName  string `json:"codec_name"`
+			SampleRate string `json:"sample_rate"`
+			Channels   int    `json:"channels"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return MediaInfo{}, fmt.Errorf("ffprobe: UNREADABLE OUTPUT: %v", err)
+	}
+	var info MediaInfo
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	info.Bitrate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	if len(probe.Streams) > 0 {
+		stream := probe.Streams[0]
+		info.Codec = stream.CodecName
+		info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+		info.Channels = stream.Channels
+		if bitrate, err := strconv.ParseInt(stream.BitRate, 10, 64); err == nil && bitrate > 0 {
+			info.Bitrate = bitrate
+		}
+	}
+	if info.Duration <= 0 && info.Bitrate <= 0 && info.Codec == "" {
+		return MediaInfo{}, fmt.Errorf("ffprobe: NO AUDIO FOUND")
+	}
+	return info, nil
+}
+
+// RENDER THE FIRST FRAME OR PAGE OF A FILE WITH IMAGEMAGICK
+func magickThumbnail(tools MediaTools, sourcePath, thumbnailPath string) error {
+	return runTool(tools.Magick.Path, "-density", "72", sourcePath+"[0]",
+		"-thumbnail", "300x", "-background", "white", "-alpha", "remove", "-quality", "85", thumbnailPath)
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// -- STORAGE PATHS --
+//
+// NAMES FROM URLS, TASK CONFIG AND THE DATABASE ARE ONLY EVER RESOLVED INSIDE A CONFIGURED
+// ROOT (STORAGE, THUMBNAILS, DATA). ".." SEGMENTS AND ABSOLUTE PATHS LEADING OUTSIDE IT ARE
+// REFUSED, AND SO ARE SYMLINKS INSIDE THE ROOT THAT POINT OUT OF IT.
+
+// RETURNED FOR A NAME THAT RESOLVES OUTSIDE ITS ROOT
+var ErrPathEscapesRoot = errors.New("PATH ESCAPES ITS ROOT")
+
+// LONGEST FILENAME KEPT BY SafeFilename, IN BYTES
+const maxFilenameLength = 200
+
+// RESOLVE name INSIDE root: RELATIVE NAMES ARE JOINED TO THE ROOT, ABSOLUTE ONES MUST
+// ALREADY BE UNDER IT. THE RESULT IS ABSOLUTE AND CLEAN (AND MAY BE THE ROOT ITSELF)
+func ConfinePath(root, name string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	full := name
+	if !filepath.IsAbs(full) {
+		full = filepath.Join(absRoot, full)
+	}
+	full = filepath.Clean(full)
+	if !within(absRoot, full) {
+		return "", fmt.Errorf("%w: %s", ErrPathEscapesRoot, name)
+	}
+
+	// A ROOT THAT DOESN'T EXIST YET CAN'T CONTAIN LINKS
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return full, nil
+	}
+	if !within(realRoot, realPath(full)) {
+		return "", fmt.Errorf("%w: %s (THROUGH A SYMLINK)", ErrPathEscapesRoot, name)
+	}
+	return full, nil
+}
+
+// A FILE'S PATH (FROM THE WORKING DIRECTORY, OR ABSOLUTE) RELATIVE TO THE root IT MUST BE
+// IN, FOR STORING IN THE DATABASE
+func RelativeToRoot(root, path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	full, err := ConfinePath(root, absPath)
+	if err != nil {
+		return "", err
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absRoot, full)
+	if err != nil || rel == "." {
+		return "", fmt.Errorf("%w: %s", ErrPathEscapesRoot, path)
+	}
+	return rel, nil
+}
+
+// WHETHER path IS root OR SOMETHING BELOW IT (BOTH ABSOLUTE AND CLEAN)
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || filepath.IsAbs(rel) {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// WHERE A PATH REALLY POINTS: THE DEEPEST EXISTING ANCESTOR WITH ITS LINKS RESOLVED, PLUS
+// THE PART THAT DOESN'T EXIST YET
+func realPath(path string) string {
+	rest := ""
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// REDUCE AN UNTRUSTED NAME (A URL SEGMENT, A SERVER-SUGGESTED NAME) TO ONE SAFE PATH
+// ELEMENT: NO SEPARATORS, CONTROL OR RESERVED CHARACTERS, OR LEADING DOTS. EMPTY WHEN
+// NOTHING USABLE IS LEFT
+func SafeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:<>"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	if len(name) <= maxFilenameLength {
+		return name
+	}
+
+	// SHORTEN THE BASE, KEEPING A SENSIBLE EXTENSION
+	ext := filepath.Ext(name)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	for len(base)+len(ext) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	return base + ext
+}
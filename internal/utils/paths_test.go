@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestConfinePath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string // RELATIVE TO root
+		escapes bool
+	}{
+		{name: "plain name", path: "photo.jpg", want: "photo.jpg"},
+		{name: "nested name", path: "job/photo.jpg", want: "job/photo.jpg"},
+		{name: "root itself", path: ".", want: "."},
+		{name: "dot-dot that stays inside", path: "job/../photo.jpg", want: "photo.jpg"},
+		{name: "absolute inside root", path: filepath.Join(root, "job", "photo.jpg"), want: "job/photo.jpg"},
+		{name: "parent", path: "..", escapes: true},
+		{name: "traversal", path: "../../etc/passwd", escapes: true},
+		{name: "traversal after a directory", path: "job/../../secret", escapes: true},
+		{name: "absolute outside root", path: filepath.Join(outside, "secret"), escapes: true},
+		{name: "absolute system file", path: "/etc/passwd", escapes: true},
+		{name: "sibling with the root as prefix", path: root + "-other/file", escapes: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConfinePath(root, tt.path)
+			if tt.escapes {
+				if !errors.Is(err, ErrPathEscapesRoot) {
+					t.Fatalf("ConfinePath(%q) = %q, %v; want ErrPathEscapesRoot", tt.path, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConfinePath(%q): %v", tt.path, err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(tt.want)); got != want {
+				t.Fatalf("ConfinePath(%q) = %q; want %q", tt.path, got, want)
+			}
+		})
+	}
+}
+
+func TestConfinePathSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on windows")
+	}
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "real"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "real"), filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"escape/secret", "escape/not-yet-written", "escape"} {
+		if got, err := ConfinePath(root, name); !errors.Is(err, ErrPathEscapesRoot) {
+			t.Errorf("ConfinePath(%q) = %q, %v; want ErrPathEscapesRoot", name, got, err)
+		}
+	}
+	if _, err := ConfinePath(root, "inside/photo.jpg"); err != nil {
+		t.Errorf("link within the root: %v", err)
+	}
+}
+
+func TestRelativeToRoot(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.MkdirAll(filepath.Join("storage", "job"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		escapes bool
+	}{
+		{name: "legacy path from the working directory", path: "storage/job/photo.jpg", want: "job/photo.jpg"},
+		{name: "legacy path with a leading dot", path: "./storage/photo.jpg", want: "photo.jpg"},
+		{name: "absolute path", path: filepath.Join(dir, "storage", "photo.jpg"), want: "photo.jpg"},
+		{name: "the root itself", path: "storage", escapes: true},
+		{name: "next to the root", path: "crepes.db", escapes: true},
+		{name: "traversal out of the root", path: "storage/../crepes.db", escapes: true},
+		{name: "absolute outside the root", path: "/etc/passwd", escapes: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RelativeToRoot("storage", tt.path)
+			if tt.escapes {
+				if !errors.Is(err, ErrPathEscapesRoot) {
+					t.Fatalf("RelativeToRoot(%q) = %q, %v; want ErrPathEscapesRoot", tt.path, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RelativeToRoot(%q): %v", tt.path, err)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Fatalf("RelativeToRoot(%q) = %q; want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeFilename(t *testing.T) {
+	tests := map[string]string{
+		"photo.jpg":        "photo.jpg",
+		"../../etc/passwd": "_.._etc_passwd",
+		"..":               "",
+		"a\\b:c*d?":        "a_b_c_d_",
+		".hidden":          "hidden",
+		"line\nbreak":      "line_break",
+	}
+	for in, want := range tests {
+		if got := SafeFilename(in); got != want {
+			t.Errorf("SafeFilename(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
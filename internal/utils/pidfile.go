@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// -- PID FILE --
+//
+// ONE INSTANCE PER DATA DIRECTORY: THE PID FILE IS HELD UNDER AN OS LOCK THAT GOES AWAY
+// WITH THE PROCESS, SO A CRASH NEVER LEAVES A STALE LOCK BEHIND. A PID STILL IN THE FILE
+// AT STARTUP MEANS THE LAST RUN DIDN'T SHUT DOWN CLEANLY.
+
+// RETURNED WHEN ANOTHER PROCESS HOLDS THE LOCK
+var ErrAlreadyRunning = errors.New("ANOTHER INSTANCE IS ALREADY RUNNING")
+
+// A HELD PID FILE
+type PIDFile struct {
+	path        string
+	file        *os.File
+	PreviousPID int // LEFT BY A RUN THAT DIDN'T EXIT CLEANLY (0 IF NONE)
+}
+
+// LOCK path AND WRITE OUR PID TO IT
+func AcquirePIDFile(path string) (*PIDFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if owner, readErr := os.ReadFile(path); readErr == nil && len(strings.TrimSpace(string(owner))) > 0 {
+			return nil, fmt.Errorf("%w (PID %s, %s)", ErrAlreadyRunning, strings.TrimSpace(string(owner)), path)
+		}
+		return nil, fmt.Errorf("%w (%s)", ErrAlreadyRunning, path)
+	}
+
+	pidFile := &PIDFile{path: path, file: file}
+	if previous, err := io.ReadAll(file); err == nil {
+		pidFile.PreviousPID, _ = strconv.Atoi(strings.TrimSpace(string(previous)))
+	}
+	if err := file.Truncate(0); err != nil {
+		pidFile.Release()
+		return nil, err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		pidFile.Release()
+		return nil, err
+	}
+	return pidFile, file.Sync()
+}
+
+// EMPTY, UNLOCK AND REMOVE THE FILE ON A CLEAN EXIT
+func (p *PIDFile) Release() error {
+	p.file.Truncate(0)
+	unlockFile(p.file)
+	p.file.Close()
+	if err := os.Remove(p.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// WINDOWS LOCKS ARE MANDATORY, SO THE LOCKED BYTE SITS PAST THE END OF THE FILE (AT 4GB)
+// WHERE IT DOESN'T STOP OTHERS READING THE PID
+var lockRegion = windows.Overlapped{OffsetHigh: 1}
+
+func lockFile(file *os.File) error {
+	region := lockRegion
+	return windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &region)
+}
+
+func unlockFile(file *os.File) error {
+	region := lockRegion
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &region)
+}
@@ -0,0 +1,361 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+	_ "golang.org/x/image/webp" // WEBP SOURCES DECODE WITHOUT IMAGEMAGICK
+)
+
+// -- THUMBNAILS --
+//
+// IMAGES ARE DECODED AND SCALED IN GO (JPEG, PNG, GIF, BMP, TIFF, WEBP), WITH IMAGEMAGICK FOR
+// ANYTHING ELSE, ffmpeg FOR VIDEO FRAMES AND AUDIO WAVEFORMS AND LIBREOFFICE FOR OFFICE
+// DOCUMENTS. WHAT A TOOL WRITES IS DECODED BEFORE IT'S KEPT, AND ANYTHING THAT CAN'T BE RENDERED
+// GETS A DRAWN PLACEHOLDER ICON, SO EVERY THUMBNAIL IS A REAL JPEG.
+
+const (
+	thumbnailWidth     = 300
+	thumbnailMaxHeight = 600
+	thumbnailQuality   = 85
+	placeholderWidth   = 300
+	placeholderHeight  = 200
+	maxDecodePixels    = 100_000_000 // LARGER IMAGES ARE LEFT TO IMAGEMAGICK
+)
+
+// RETURNED WITH A PLACEHOLDER THUMBNAIL WHEN A REAL ONE COULDN'T BE MADE; THE PLACEHOLDER
+// IS STILL USABLE, THE REASON IS FOR THE USER
+type ThumbnailFallbackError struct {
+	Reason string
+}
+
+func (e *ThumbnailFallbackError) Error() string {
+	return "PLACEHOLDER THUMBNAIL: " + e.Reason
+}
+
+// SCALE AN IMAGE DOWN IN GO, USING IMAGEMAGICK FOR FORMATS (OR SIZES) GO WON'T DECODE
+func GenerateImageThumbnail(sourcePath, thumbnailPath string) error {
+	err := goImageThumbnail(sourcePath, thumbnailPath)
+	if err == nil {
+		return nil
+	}
+	tools := CurrentMediaTools()
+	if !tools.Magick.Available {
+		return placeholderFallback(thumbnailPath, placeholderImage, sourcePath,
+			fmt.Sprintf("image could not be decoded (%v); install ImageMagick or set magickPath for more formats", err))
+	}
+	if err := renderWithTool(thumbnailPath, func(out string) error { return magickThumbnail(tools, sourcePath, out) }); err != nil {
+		return placeholderFallback(thumbnailPath, placeholderImage, sourcePath, "ImageMagick could not read the image: "+err.Error())
+	}
+	return nil
+}
+
+// HOW TO PICK A VIDEO'S THUMBNAIL FRAME
+type VideoFrameOptions struct {
+	Timestamp float64 // SECONDS IN; 0 LETS SCENE DETECTION CHOOSE
+}
+
+// GRAB A FRAME WITH ffmpeg, OR A PLACEHOLDER SAYING WHY NOT
+func GenerateVideoThumbnail(sourcePath, thumbnailPath string) error {
+	return GenerateVideoThumbnailWith(sourcePath, thumbnailPath, VideoFrameOptions{})
+}
+
+// GRAB A FRAME WITH ffmpeg UNDER THE GIVEN OPTIONS, OR A PLACEHOLDER SAYING WHY NOT
+func GenerateVideoThumbnailWith(sourcePath, thumbnailPath string, options VideoFrameOptions) error {
+	tools := CurrentMediaTools()
+	if !tools.FFmpeg.Available {
+		return placeholderFallback(thumbnailPath, placeholderVideo, sourcePath, "ffmpeg not found; install it or set ffmpegPath for video thumbnails")
+	}
+	if err := renderWithTool(thumbnailPath, func(out string) error { return ffmpegThumbnail(tools, sourcePath, out, options) }); err != nil {
+		return placeholderFallback(thumbnailPath, placeholderVideo, sourcePath, "ffmpeg could not read the video: "+err.Error())
+	}
+	return nil
+}
+
+// HOW TO DRAW AN AUDIO FILE
+type AudioPreviewOptions struct {
+	Style string // AudioPreviewWaveform (THE DEFAULT) OR AudioPreviewSpectrogram
+}
+
+const (
+	AudioPreviewWaveform    = "waveform"
+	AudioPreviewSpectrogram = "spectrogram"
+)
+
+// DRAW AN AUDIO FILE'S WAVEFORM WITH ffmpeg, OR A PLACEHOLDER SAYING WHY NOT
+func GenerateAudioThumbnail(sourcePath, thumbnailPath string) error {
+	return GenerateAudioThumbnailWith(sourcePath, thumbnailPath, AudioPreviewOptions{})
+}
+
+// DRAW AN AUDIO FILE'S WAVEFORM OR SPECTROGRAM WITH ffmpeg, OR A PLACEHOLDER SAYING WHY NOT
+func GenerateAudioThumbnailWith(sourcePath, thumbnailPath string, options AudioPreviewOptions) error {
+	tools := CurrentMediaTools()
+	if !tools.FFmpeg.Available {
+		return placeholderFallback(thumbnailPath, placeholderAudio, sourcePath, "ffmpeg not found; install it or set ffmpegPath for audio waveforms")
+	}
+	if err := renderWithTool(thumbnailPath, func(out string) error { return ffmpegAudioPreview(tools, sourcePath, out, options) }); err != nil {
+		return placeholderFallback(thumbnailPath, placeholderAudio, sourcePath, "ffmpeg could not read the audio: "+err.Error())
+	}
+	return nil
+}
+
+// RENDER THE FIRST PAGE OF A PDF WITH IMAGEMAGICK, OR OF AN OFFICE DOCUMENT WITH LIBREOFFICE AND
+// IMAGEMAGICK; OTHER DOCUMENTS GET A PLACEHOLDER
+func GenerateDocumentThumbnail(sourcePath, thumbnailPath string) error {
+	pdf := strings.EqualFold(filepath.Ext(sourcePath), ".pdf")
+	if !pdf && !IsOfficeDocument(sourcePath) {
+		return writePlaceholder(thumbnailPath, placeholderDocument, sourcePath)
+	}
+	tools := CurrentMediaTools()
+	if !tools.Magick.Available {
+		return placeholderFallback(thumbnailPath, placeholderDocument, sourcePath, "ImageMagick not found; install it or set magickPath for document thumbnails")
+	}
+	if pdf {
+		if err := renderWithTool(thumbnailPath, func(out string) error { return magickThumbnail(tools, sourcePath, out) }); err != nil {
+			return placeholderFallback(thumbnailPath, placeholderDocument, sourcePath, "ImageMagick could not render the PDF: "+err.Error())
+		}
+		return nil
+	}
+	if !tools.LibreOffice.Available {
+		return placeholderFallback(thumbnailPath, placeholderDocument, sourcePath, "LibreOffice not found; install it or set libreOfficePath for Office document thumbnails")
+	}
+	if err := renderWithTool(thumbnailPath, func(out string) error { return officeThumbnail(tools, sourcePath, out) }); err != nil {
+		return placeholderFallback(thumbnailPath, placeholderDocument, sourcePath, "LibreOffice could not render the document: "+err.Error())
+	}
+	return nil
+}
+
+func GenerateGenericThumbnail(sourcePath, thumbnailPath string) error {
+	return writePlaceholder(thumbnailPath, placeholderFile, sourcePath)
+}
+
+// DECODE, ORIENT AND SCALE AN IMAGE WITHOUT LEAVING GO
+func goImageThumbnail(sourcePath, thumbnailPath string) error {
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxDecodePixels {
+		return fmt.Errorf("%dx%d IS TOO LARGE TO DECODE IN MEMORY", config.Width, config.Height)
+	}
+	src, err := imaging.Open(sourcePath, imaging.AutoOrientation(true))
+	if err != nil {
+		return err
+	}
+	return saveJPEG(imaging.Fit(src, thumbnailWidth, thumbnailMaxHeight, imaging.Lanczos), thumbnailPath)
+}
+
+// ENCODE AS JPEG OVER A WHITE BACKGROUND (JPEG HAS NO ALPHA), REPLACING path ATOMICALLY
+func saveJPEG(img image.Image, path string) error {
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, flat, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// WHETHER A FILE DECODES AS A JPEG
+func checkJPEG(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return err
+	}
+	if img.Bounds().Empty() {
+		return fmt.Errorf("EMPTY IMAGE")
+	}
+	return nil
+}
+
+// RUN AN EXTERNAL RENDERER INTO A SCRATCH FILE, KEEPING ITS OUTPUT ONLY IF IT'S A VALID JPEG
+func renderWithTool(thumbnailPath string, render func(out string) error) error {
+	scratch := strings.TrimSuffix(thumbnailPath, filepath.Ext(thumbnailPath)) + ".render.jpg"
+	defer os.Remove(scratch)
+	if err := render(scratch); err != nil {
+		return err
+	}
+	if err := checkJPEG(scratch); err != nil {
+		return fmt.Errorf("OUTPUT IS NOT A VALID JPEG: %v", err)
+	}
+	return os.Rename(scratch, thumbnailPath)
+}
+
+// -- PLACEHOLDER ICONS --
+
+type placeholderKind int
+
+const (
+	placeholderImage placeholderKind = iota
+	placeholderVideo
+	placeholderAudio
+	placeholderDocument
+	placeholderFile
+)
+
+var (
+	placeholderColors = map[placeholderKind]color.RGBA{
+		placeholderImage:    {96, 110, 128, 255},
+		placeholderVideo:    {44, 62, 120, 255},
+		placeholderAudio:    {34, 110, 80, 255},
+		placeholderDocument: {140, 52, 52, 255},
+		placeholderFile:     {110, 110, 110, 255},
+	}
+	placeholderNames = map[placeholderKind]string{
+		placeholderImage:    "IMAGE",
+		placeholderVideo:    "VIDEO",
+		placeholderAudio:    "AUDIO",
+		placeholderDocument: "DOCUMENT",
+		placeholderFile:     "FILE",
+	}
+	placeholderInk = color.RGBA{240, 240, 240, 255}
+)
+
+// WRITE A PLACEHOLDER AND REPORT WHY IT WAS NEEDED
+func placeholderFallback(thumbnailPath string, kind placeholderKind, sourcePath, reason string) error {
+	if err := writePlaceholder(thumbnailPath, kind, sourcePath); err != nil {
+		return err
+	}
+	return &ThumbnailFallbackError{Reason: reason}
+}
+
+// AN ICON FOR THE KIND OF FILE, LABELLED WITH ITS EXTENSION
+func writePlaceholder(thumbnailPath string, kind placeholderKind, sourcePath string) error {
+	label := placeholderLabel(sourcePath)
+	if label == "" {
+		label = placeholderNames[kind]
+	}
+	return saveJPEG(drawPlaceholder(kind, label), thumbnailPath)
+}
+
+// UPPER-CASE EXTENSION, LETTERS AND DIGITS ONLY (THE BUILT-IN FONT IS ASCII)
+func placeholderLabel(sourcePath string) string {
+	ext := strings.ToUpper(strings.TrimPrefix(filepath.Ext(sourcePath), "."))
+	label := strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, ext)
+	if len(label) > 8 {
+		return ""
+	}
+	return label
+}
+
+func drawPlaceholder(kind placeholderKind, label string) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, placeholderWidth, placeholderHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{placeholderColors[kind]}, image.Point{}, draw.Src)
+
+	// GLYPHS ARE FILLED PATHS; INNER PATHS RUN THE OTHER WAY TO CUT HOLES
+	z := vector.NewRasterizer(placeholderWidth, placeholderHeight)
+	const cx, cy = float32(placeholderWidth / 2), float32(80)
+	switch kind {
+	case placeholderImage: // FRAME, MOUNTAINS AND A SUN
+		frame(z, cx-50, cy-38, cx+50, cy+38, 5)
+		polygon(z, cx-40, cy+30, cx-10, cy-8, cx+18, cy+30)
+		polygon(z, cx+2, cy+30, cx+22, cy+6, cx+42, cy+30)
+		circle(z, cx+24, cy-16, 8)
+	case placeholderVideo: // SCREEN WITH A PLAY BUTTON
+		frame(z, cx-52, cy-36, cx+52, cy+36, 5)
+		polygon(z, cx-12, cy-18, cx+20, cy, cx-12, cy+18)
+	case placeholderAudio: // TWO BEAMED NOTES
+		circle(z, cx-16, cy+22, 9)
+		circle(z, cx+26, cy+14, 9)
+		rect(z, cx-12, cy-26, cx-7, cy+22)
+		rect(z, cx+30, cy-34, cx+35, cy+14)
+		polygon(z, cx-12, cy-28, cx+35, cy-36, cx+35, cy-26, cx-12, cy-18)
+	case placeholderDocument, placeholderFile: // PAGE WITH A FOLDED CORNER (AND LINES OF TEXT)
+		polygon(z, cx-32, cy-42, cx+16, cy-42, cx+32, cy-26, cx+32, cy+42, cx-32, cy+42)
+		polygon(z, cx-27, cy-37, cx-27, cy+37, cx+27, cy+37, cx+27, cy-21, cx+16, cy-37) // HOLE
+		polygon(z, cx+16, cy-42, cx+16, cy-26, cx+32, cy-26)
+		if kind == placeholderDocument {
+			for _, y := range []float32{cy - 10, cy + 2, cy + 14, cy + 26} {
+				rect(z, cx-19, y, cx+19, y+4)
+			}
+		}
+	}
+	z.Draw(img, img.Bounds(), &image.Uniform{placeholderInk}, image.Point{})
+
+	drawLabel(img, label, placeholderWidth/2, 168)
+	return img
+}
+
+// CENTRED TEXT IN THE BUILT-IN 7x13 FONT AT TWICE ITS SIZE
+func drawLabel(dst draw.Image, label string, cx, top int) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, label).Ceil()
+	if width == 0 {
+		return
+	}
+	small := image.NewAlpha(image.Rect(0, 0, width, 13))
+	drawer := font.Drawer{Dst: small, Src: image.Opaque, Face: face, Dot: fixed.P(0, 11)}
+	drawer.DrawString(label)
+	large := imaging.Resize(small, width*2, 26, imaging.NearestNeighbor)
+	r := image.Rect(cx-width, top, cx+width, top+26)
+	draw.DrawMask(dst, r, &image.Uniform{placeholderInk}, image.Point{}, large, image.Point{}, draw.Over)
+}
+
+// CLOSED PATH THROUGH x, y PAIRS
+func polygon(z *vector.Rasterizer, points ...float32) {
+	z.MoveTo(points[0], points[1])
+	for i := 2; i+1 < len(points); i += 2 {
+		z.LineTo(points[i], points[i+1])
+	}
+	z.ClosePath()
+}
+
+func rect(z *vector.Rasterizer, x0, y0, x1, y1 float32) {
+	polygon(z, x0, y0, x1, y0, x1, y1, x0, y1)
+}
+
+// RECTANGLE OUTLINE: THE OUTER EDGE ONE WAY, THE INNER EDGE BACK THE OTHER
+func frame(z *vector.Rasterizer, x0, y0, x1, y1, width float32) {
+	rect(z, x0, y0, x1, y1)
+	polygon(z, x0+width, y0+width, x0+width, y1-width, x1-width, y1-width, x1-width, y0+width)
+}
+
+// CIRCLE FROM FOUR CUBIC ARCS
+func circle(z *vector.Rasterizer, x, y, r float32) {
+	const k = 0.5523 // CONTROL POINT DISTANCE FOR A QUARTER CIRCLE
+	z.MoveTo(x+r, y)
+	z.CubeTo(x+r, y+k*r, x+k*r, y+r, x, y+r)
+	z.CubeTo(x-k*r, y+r, x-r, y+k*r, x-r, y)
+	z.CubeTo(x-r, y-k*r, x-k*r, y-r, x, y-r)
+	z.CubeTo(x+k*r, y-r, x+r, y-k*r, x+r, y)
+	z.ClosePath()
+}
@@ -6,18 +6,12 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"image"
-	"image/color"
-	"image/draw"
-	"image/jpeg"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 )
 
@@ -156,47 +150,6 @@ func ResolveURL(baseURL, relativeURL string) string {
 	return base.ResolveReference(rel).String()
 }
 
-// THUMBNAIL GENERATION
-func generatePlaceholderThumbnail(thumbnailPath string, bgColor color.Color) error {
-	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
-	draw.Draw(img, img.Bounds(), &image.Uniform{bgColor}, image.Point{}, draw.Src)
-	f, err := os.Create(thumbnailPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	return jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
-}
-
-func GenerateImageThumbnail(sourcePath, thumbnailPath string) error {
-	src, err := imaging.Open(sourcePath)
-	if err != nil {
-		return err
-	}
-	thumbnail := imaging.Resize(src, 300, 0, imaging.Lanczos)
-	return imaging.Save(thumbnail, thumbnailPath)
-}
-
-func GenerateVideoThumbnail(sourcePath, thumbnailPath string) error {
-	bgColor := color.RGBA{0, 0, 128, 255}
-	return generatePlaceholderThumbnail(thumbnailPath, bgColor)
-}
-
-func GenerateAudioThumbnail(thumbnailPath string) error {
-	bgColor := color.RGBA{0, 128, 0, 255}
-	return generatePlaceholderThumbnail(thumbnailPath, bgColor)
-}
-
-func GenerateDocumentThumbnail(thumbnailPath string) error {
-	bgColor := color.RGBA{128, 0, 0, 255}
-	return generatePlaceholderThumbnail(thumbnailPath, bgColor)
-}
-
-func GenerateGenericThumbnail(thumbnailPath string) error {
-	bgColor := color.RGBA{128, 128, 128, 255}
-	return generatePlaceholderThumbnail(thumbnailPath, bgColor)
-}
-
 func NormalizeURL(baseURL, relativeURL string) string {
 	// Check if URL is already absolute
 	if strings.HasPrefix(relativeURL, "http://") || strings.HasPrefix(relativeURL, "https://") {
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// -- SECRET VALUES --
+//
+// SMALL SECRETS (PASSWORDS, TOTP SEEDS) ARE SEALED WITH AES-256-GCM UNDER A KEY DERIVED
+// FROM THE MASTER SECRET AND A PER-VALUE SALT, AND STORED AS BASE64(SALT | NONCE | CIPHERTEXT).
+// THE VALUE'S NAME IS THE ADDITIONAL DATA, SO A SEALED VALUE CAN'T BE MOVED TO ANOTHER NAME.
+
+const vaultInfo = "crepes vault v1"
+
+// SEAL A SECRET VALUE STORED UNDER name
+func SealSecret(name, plain, secret string) (string, error) {
+	if secret == "" {
+		return "", ErrNoEncryptionSecret
+	}
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := newVaultAEAD(secret, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(append(salt, nonce...), nonce, []byte(plain), []byte(name))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OPEN A VALUE SEALED BY SealSecret
+func OpenSecret(name, sealed, secret string) (string, error) {
+	if secret == "" {
+		return "", ErrNoEncryptionSecret
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < encryptionSaltSize {
+		return "", ErrDecryptionFailed
+	}
+	aead, err := newVaultAEAD(secret, raw[:encryptionSaltSize])
+	if err != nil {
+		return "", err
+	}
+	raw = raw[encryptionSaltSize:]
+	if len(raw) < aead.NonceSize() {
+		return "", ErrDecryptionFailed
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return "", ErrDecryptionFailed
+	}
+	return string(plain), nil
+}
+
+func newVaultAEAD(secret string, salt []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, []byte(secret), salt, vaultInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("FAILED TO DERIVE KEY: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
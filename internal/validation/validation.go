@@ -0,0 +1,253 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/robfig/cron/v3"
+)
+
+// A SINGLE FIELD-LEVEL PROBLEM WITH A PAYLOAD
+type FieldError struct {
+	Path     string `json:"path"`               // JSON PATH, E.G. pipeline[0].tasks[2].config
+	Message  string `json:"message"`            // HUMAN-READABLE DESCRIPTION
+	Expected string `json:"expected,omitempty"` // EXPECTED TYPE OR CONSTRAINT
+	Rule     string `json:"rule,omitempty"`     // VALIDATION RULE THAT FAILED
+}
+
+// ALL PROBLEMS FOUND IN A PAYLOAD
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Path + ": " + fe.Message
+	}
+	return "VALIDATION FAILED: " + strings.Join(parts, "; ")
+}
+
+// PREFIX EVERY PATH (E.G. WHEN VALIDATING A NESTED DOCUMENT)
+func (e Errors) Prefix(prefix string) Errors {
+	if len(e) == 0 {
+		return nil
+	}
+	out := make(Errors, len(e))
+	for i, fe := range e {
+		fe.Path = JoinPath(prefix, fe.Path)
+		out[i] = fe
+	}
+	return out
+}
+
+// DROP ERRORS FOR THE GIVEN RULES (E.G. "required" ON PARTIAL UPDATES)
+func (e Errors) Without(rules ...string) Errors {
+	var out Errors
+	for _, fe := range e {
+		skip := false
+		for _, rule := range rules {
+			if fe.Rule == rule {
+				skip = true
+			}
+		}
+		if !skip {
+			out = append(out, fe)
+		}
+	}
+	return out
+}
+
+// JOIN TWO PATH SEGMENTS ("a" + "b" = "a.b", "a" + "[0]" = "a[0]")
+func JoinPath(prefix, path string) string {
+	switch {
+	case prefix == "":
+		return path
+	case path == "":
+		return prefix
+	case strings.HasPrefix(path, "["):
+		return prefix + path
+	default:
+		return prefix + "." + path
+	}
+}
+
+var (
+	validate     *validator.Validate
+	validateOnce sync.Once
+)
+
+// SHARED VALIDATOR REPORTING JSON FIELD NAMES
+func instance() *validator.Validate {
+	validateOnce.Do(func() {
+		validate = validator.New(validator.WithRequiredStructEnabled())
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+		validate.RegisterValidation("cron", func(fl validator.FieldLevel) bool {
+			_, err := cron.ParseStandard(fl.Field().String())
+			return err == nil
+		})
+	})
+	return validate
+}
+
+// VALIDATE A STRUCT AGAINST ITS `validate` TAGS
+func Struct(v any) Errors {
+	err := instance().Struct(v)
+	if err == nil {
+		return nil
+	}
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return Errors{{Message: err.Error()}}
+	}
+
+	out := make(Errors, 0, len(fieldErrs))
+	for _, fe := range fieldErrs {
+		// DROP THE ROOT STRUCT NAME FROM THE NAMESPACE
+		path := fe.Namespace()
+		if _, rest, ok := strings.Cut(path, "."); ok {
+			path = rest
+		}
+		out = append(out, FieldError{
+			Path:     path,
+			Message:  ruleMessage(fe),
+			Expected: ruleExpectation(fe),
+			Rule:     fe.Tag(),
+		})
+	}
+	return out
+}
+
+// DECODE JSON, TURNING SYNTAX AND TYPE ERRORS INTO FIELD ERRORS
+func DecodeJSON(r io.Reader, dst any) Errors {
+	err := json.NewDecoder(r).Decode(dst)
+	if err == nil {
+		return nil
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		return Errors{{
+			Path:     decodePath(typeErr.Field),
+			Message:  fmt.Sprintf("expected %s but got %s", jsonTypeName(typeErr.Type), typeErr.Value),
+			Expected: jsonTypeName(typeErr.Type),
+			Rule:     "type",
+		}}
+	case errors.As(err, &syntaxErr):
+		return Errors{{
+			Message: fmt.Sprintf("malformed JSON at offset %d: %v", syntaxErr.Offset, err),
+			Rule:    "syntax",
+		}}
+	case errors.Is(err, io.EOF):
+		return Errors{{Message: "request body is empty", Rule: "required"}}
+	default:
+		return Errors{{Message: err.Error(), Rule: "syntax"}}
+	}
+}
+
+// encoding/json REPORTS "stages.0.tasks.1.config"; REWRITE INDEXES AS "stages[0].tasks[1].config"
+func decodePath(field string) string {
+	path := ""
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			path += "[" + part + "]"
+		} else {
+			path = JoinPath(path, part)
+		}
+	}
+	return path
+}
+
+// JSON TYPE NAME FOR A GO TYPE
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	default:
+		return t.String()
+	}
+}
+
+// JSON TYPE NAME FOR A DECODED VALUE
+func ValueTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, int, int64:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "url", "http_url":
+		return "must be a valid URL"
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	case "gte":
+		return "must be greater than or equal to " + fe.Param()
+	case "lte":
+		return "must be less than or equal to " + fe.Param()
+	case "cron":
+		return "must be a valid cron expression (5 fields or a descriptor like @hourly)"
+	default:
+		return fmt.Sprintf("failed %q validation", fe.Tag())
+	}
+}
+
+func ruleExpectation(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "oneof":
+		return fe.Param()
+	case "url", "http_url":
+		return "url"
+	case "cron":
+		return "cron expression"
+	default:
+		return jsonTypeName(fe.Type())
+	}
+}